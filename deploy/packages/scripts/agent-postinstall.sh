@@ -0,0 +1,64 @@
+#!/bin/sh
+# aami-agent post-install: migrate config, register with the Config Server,
+# and start the agent timer.
+#
+# Registration runs once per node when a bootstrap token is provided in
+# /etc/aami/bootstrap-token or AAMI_BOOTSTRAP_TOKEN. Failures never fail the
+# package install; the agent keeps running checks either way.
+
+AGENT_CONFIG=/etc/aami/agent.yaml
+LEGACY_CONFIG=/etc/aami/config
+TOKEN_FILE=/etc/aami/bootstrap-token
+REGISTERED_MARKER=/var/lib/aami/registered
+
+config_value() {
+    sed -n "s/^$1:[[:space:]]*\"\{0,1\}\([^\"]*\)\"\{0,1\}[[:space:]]*$/\1/p" "$AGENT_CONFIG" 2>/dev/null | head -n 1
+}
+
+register() {
+    server="$1"
+    token="${AAMI_BOOTSTRAP_TOKEN:-}"
+    if [ -z "$token" ] && [ -r "$TOKEN_FILE" ]; then
+        token=$(tr -d '[:space:]' < "$TOKEN_FILE")
+    fi
+    if [ -z "$token" ] || [ -e "$REGISTERED_MARKER" ]; then
+        return 0
+    fi
+
+    node_hostname=$(config_value hostname)
+    node_hostname="${node_hostname:-$(hostname)}"
+    node_ip=$(hostname -I 2>/dev/null | awk '{print $1}')
+
+    payload="{\"token\": \"${token}\", \"hostname\": \"${node_hostname}\", \"ip_address\": \"${node_ip}\", \"labels\": {}}"
+    if curl -sf -X POST "${server%/}/api/v1/bootstrap/register" \
+        -H "Content-Type: application/json" -d "$payload" > "$REGISTERED_MARKER.tmp" 2>/dev/null; then
+        mv "$REGISTERED_MARKER.tmp" "$REGISTERED_MARKER"
+        echo "aami-agent: registered ${node_hostname} with ${server}"
+    else
+        rm -f "$REGISTERED_MARKER.tmp"
+        echo "aami-agent: registration with ${server} failed; retry by reinstalling or run bootstrap.sh" >&2
+    fi
+}
+
+# Bring the legacy KEY=VALUE config forward to agent.yaml
+if [ ! -e "$AGENT_CONFIG" ] && [ -e "$LEGACY_CONFIG" ]; then
+    /usr/bin/aami-agent config migrate || true
+fi
+
+if [ -d /run/systemd/system ]; then
+    systemctl daemon-reload >/dev/null 2>&1 || true
+fi
+
+server=$(config_value config_server_url)
+if [ -z "$server" ]; then
+    echo "aami-agent: set config_server_url in ${AGENT_CONFIG}, then run:"
+    echo "  systemctl enable --now aami-agent.timer"
+    exit 0
+fi
+
+register "$server"
+
+if [ -d /run/systemd/system ]; then
+    systemctl enable --now aami-agent.timer >/dev/null 2>&1 || true
+fi
+exit 0
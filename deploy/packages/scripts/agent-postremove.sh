@@ -0,0 +1,8 @@
+#!/bin/sh
+# aami-agent post-remove: reload systemd so removed units are forgotten.
+# Configuration and state in /etc/aami and /var/lib/aami are kept.
+
+if [ -d /run/systemd/system ]; then
+    systemctl daemon-reload >/dev/null 2>&1 || true
+fi
+exit 0
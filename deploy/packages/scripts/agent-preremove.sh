@@ -0,0 +1,14 @@
+#!/bin/sh
+# aami-agent pre-remove: stop the timer or watch service on removal, but not
+# on upgrade.
+# dpkg passes "remove" or "upgrade"; rpm passes the number of versions left.
+
+case "$1" in
+    remove|purge|0)
+        if [ -d /run/systemd/system ]; then
+            systemctl disable --now aami-agent.timer >/dev/null 2>&1 || true
+            systemctl disable --now aami-agent-watch.service >/dev/null 2>&1 || true
+        fi
+        ;;
+esac
+exit 0
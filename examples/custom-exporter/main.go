@@ -0,0 +1,92 @@
+// Command infiniband-exporter is an example exporter built on pkg/exporter.
+// It exposes the state and traffic counters of every InfiniBand port from
+// sysfs.
+//
+//	go run ./examples/custom-exporter --config infiniband.yaml
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+type config struct {
+	exporter.Config `yaml:",inline"`
+	SysfsPath       string `yaml:"sysfs_path"` // default: /sys/class/infiniband
+}
+
+// counters are the port counters exposed, by sysfs file
+var counters = map[string]string{
+	"port_rcv_data":       "infiniband_port_receive_data_total",
+	"port_xmit_data":      "infiniband_port_transmit_data_total",
+	"symbol_error":        "infiniband_port_symbol_errors_total",
+	"link_downed":         "infiniband_port_link_downed_total",
+	"port_rcv_errors":     "infiniband_port_receive_errors_total",
+	"port_xmit_discards":  "infiniband_port_transmit_discards_total",
+	"link_error_recovery": "infiniband_port_link_error_recovery_total",
+}
+
+type ports struct{ root string }
+
+func (p ports) Name() string { return "ports" }
+
+func (p ports) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	dirs, err := filepath.Glob(filepath.Join(p.root, "*", "ports", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var metrics []exporter.Metric
+	for _, dir := range dirs {
+		labels := map[string]string{
+			"device": filepath.Base(filepath.Dir(filepath.Dir(dir))),
+			"port":   filepath.Base(dir),
+		}
+		// state reads e.g. "4: ACTIVE"
+		if state, err := os.ReadFile(filepath.Join(dir, "state")); err == nil {
+			active := 0.0
+			if strings.Contains(string(state), "ACTIVE") {
+				active = 1
+			}
+			metrics = append(metrics, exporter.NewGauge("infiniband_port_active",
+				"Whether the port is ACTIVE.", active, labels))
+		}
+		for file, name := range counters {
+			data, err := os.ReadFile(filepath.Join(dir, "counters", file))
+			if err != nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+			if err != nil {
+				continue
+			}
+			metrics = append(metrics, exporter.NewCounter(name,
+				"InfiniBand port counter "+file+".", v, labels))
+		}
+	}
+	return metrics, nil
+}
+
+func main() {
+	configPath := flag.String("config", "", "Config file")
+	flag.Parse()
+
+	cfg := config{Config: exporter.Config{ListenAddress: ":9315"}, SysfsPath: "/sys/class/infiniband"}
+	if *configPath != "" {
+		if err := exporter.LoadConfig(*configPath, &cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	e := exporter.New("infiniband", cfg.Config)
+	e.Register(ports{root: cfg.SysfsPath})
+	if err := e.Run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
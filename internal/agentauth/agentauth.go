@@ -0,0 +1,310 @@
+// Package agentauth issues and verifies the credentials node agents use
+// after bootstrap. A credential is a short-lived token signed with a key
+// only the server holds (a JWT with HS256) and scoped to one target, so a
+// credential taken from one node cannot be used for another. Agents renew
+// their credential before it expires; operators revoke all credentials of
+// a target at once.
+package agentauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDir is where the signing key and revocations are stored
+const DefaultDir = "/var/lib/aami/agent-auth"
+
+// DefaultTTL is how long a credential is valid when agent_auth.ttl is not
+// set
+const DefaultTTL = 24 * time.Hour
+
+// Audience is the audience of agent credentials, so other tokens signed
+// with the same algorithm are not taken for one
+const Audience = "aami-agent"
+
+// Errors returned by Verify, and ErrMissing for requests without a
+// credential where one is required
+var (
+	ErrMissing = errors.New("credential required")
+	ErrInvalid = errors.New("invalid credential")
+	ErrExpired = errors.New("credential expired")
+	ErrRevoked = errors.New("credential revoked")
+)
+
+// header is the JWT header of every credential
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the claims of an agent credential.
+type Claims struct {
+	Subject   string `json:"sub"` // target hostname the credential is scoped to
+	Audience  string `json:"aud"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Target returns the target the credential is scoped to.
+func (c Claims) Target() string {
+	return c.Subject
+}
+
+// Expires returns when the credential expires.
+func (c Claims) Expires() time.Time {
+	return time.Unix(c.ExpiresAt, 0).UTC()
+}
+
+// Revocation invalidates the credentials of a target issued before it.
+type Revocation struct {
+	Target    string    `yaml:"target" json:"target"`
+	RevokedAt time.Time `yaml:"revoked_at" json:"revoked_at"`
+	RevokedBy string    `yaml:"revoked_by" json:"revoked_by"`
+	Reason    string    `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// Authority issues, verifies and revokes agent credentials.
+type Authority struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewAuthority creates an authority keeping its key and revocations in dir.
+func NewAuthority(dir string) *Authority {
+	return &Authority{dir: dir, now: time.Now}
+}
+
+// Issue returns a credential for a target, valid for ttl. The signing key
+// is created on first use.
+func (a *Authority) Issue(target string, ttl time.Duration) (string, Claims, error) {
+	if target == "" {
+		return "", Claims{}, fmt.Errorf("a credential needs a target")
+	}
+	if ttl <= 0 {
+		return "", Claims{}, fmt.Errorf("a credential needs a positive lifetime")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, err := a.key(true)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return "", Claims{}, err
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	issuedAt := a.now().Unix()
+	// Claims have whole seconds: a credential issued in the second of a
+	// revocation would be taken for one issued before it
+	if r, ok := revocations[target]; ok && issuedAt <= r.RevokedAt.Unix() {
+		issuedAt = r.RevokedAt.Unix() + 1
+	}
+	claims := Claims{
+		Subject:   target,
+		Audience:  Audience,
+		ID:        id,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt + int64(ttl/time.Second),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", Claims{}, fmt.Errorf("marshal claims: %w", err)
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + sign(key, signed), claims, nil
+}
+
+// Verify checks a credential's signature, audience, expiry and revocation
+// and returns its claims.
+func (a *Authority) Verify(token string) (Claims, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, err := a.key(false)
+	if err != nil {
+		return Claims{}, err
+	}
+	if key == nil {
+		return Claims{}, ErrInvalid
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return Claims{}, ErrInvalid
+	}
+	if !hmac.Equal([]byte(sign(key, parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return Claims{}, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Audience != Audience || claims.Subject == "" {
+		return Claims{}, ErrInvalid
+	}
+
+	if !a.now().Before(claims.Expires()) {
+		return Claims{}, ErrExpired
+	}
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return Claims{}, err
+	}
+	if r, ok := revocations[claims.Subject]; ok && !time.Unix(claims.IssuedAt, 0).After(r.RevokedAt) {
+		return Claims{}, ErrRevoked
+	}
+	return claims, nil
+}
+
+// Revoke invalidates every credential issued to a target so far. Credentials
+// issued afterwards are valid again.
+func (a *Authority) Revoke(target, by, reason string) (Revocation, error) {
+	if target == "" {
+		return Revocation{}, fmt.Errorf("a revocation needs a target")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return Revocation{}, err
+	}
+	r := Revocation{
+		Target:    target,
+		RevokedAt: a.now().UTC(),
+		RevokedBy: by,
+		Reason:    reason,
+	}
+	revocations[target] = r
+	return r, a.saveRevocations(revocations)
+}
+
+// Revocations returns the latest revocation of each target, by target.
+func (a *Authority) Revocations() ([]Revocation, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Revocation, 0, len(revocations))
+	for _, r := range revocations {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Target < list[j].Target
+	})
+	return list, nil
+}
+
+// key returns the signing key. Without create, a missing key is nil: no
+// credential was ever issued, so none can be valid.
+func (a *Authority) key(create bool) ([]byte, error) {
+	path := filepath.Join(a.dir, "signing.key")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("invalid signing key in %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	if !create {
+		return nil, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return nil, fmt.Errorf("create agent auth directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("write signing key: %w", err)
+	}
+	return key, nil
+}
+
+func (a *Authority) loadRevocations() (map[string]Revocation, error) {
+	revocations := make(map[string]Revocation)
+	data, err := os.ReadFile(filepath.Join(a.dir, "revocations.yaml"))
+	if os.IsNotExist(err) {
+		return revocations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read revocations: %w", err)
+	}
+
+	var list []Revocation
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse revocations: %w", err)
+	}
+	for _, r := range list {
+		revocations[r.Target] = r
+	}
+	return revocations, nil
+}
+
+func (a *Authority) saveRevocations(revocations map[string]Revocation) error {
+	list := make([]Revocation, 0, len(revocations))
+	for _, r := range revocations {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Target < list[j].Target
+	})
+
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("marshal revocations: %w", err)
+	}
+	if err := os.MkdirAll(a.dir, 0700); err != nil {
+		return fmt.Errorf("create agent auth directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(a.dir, "revocations.yaml"), data, 0644); err != nil {
+		return fmt.Errorf("write revocations: %w", err)
+	}
+	return nil
+}
+
+func sign(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,312 @@
+package agentauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultCertTTL is how long an agent certificate is valid when
+// agent_auth.cert_ttl is not set
+const DefaultCertTTL = 30 * 24 * time.Hour
+
+// caTTL is the lifetime of the CA itself
+const caTTL = 10 * 365 * 24 * time.Hour
+
+// certBackdate is how long before its issue a certificate becomes valid
+const certBackdate = time.Minute
+
+// caCommonName names the CA in the certificates it signs
+const caCommonName = "AAMI agent CA"
+
+// ErrCertificateRequired is returned for agent requests without a client
+// certificate where one is required
+var ErrCertificateRequired = errors.New("client certificate required")
+
+// Certificate is an issued certificate and its private key, PEM encoded.
+type Certificate struct {
+	Certificate []byte
+	PrivateKey  []byte
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// TLS returns the certificate for a tls.Config.
+func (c Certificate) TLS() (tls.Certificate, error) {
+	return tls.X509KeyPair(c.Certificate, c.PrivateKey)
+}
+
+// RenewAt returns when a third of the certificate's lifetime is left, the
+// time agents renew theirs.
+func (c Certificate) RenewAt() time.Time {
+	return c.NotAfter.Add(-c.NotAfter.Sub(c.NotBefore) / 3)
+}
+
+// CA is the internal certificate authority for mutual TLS between agents
+// and the server. It signs client certificates scoped to one target, like
+// credentials, and the server's own certificate. Its key is created on
+// first use and stays in the agent auth directory.
+type CA struct {
+	dir  string
+	mu   sync.Mutex
+	now  func() time.Time
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// NewCA creates a CA keeping its certificate and key in dir.
+func NewCA(dir string) *CA {
+	return &CA{dir: dir, now: time.Now}
+}
+
+// CertificatePEM returns the CA certificate, for agents and servers to
+// trust. The CA is created on first use.
+func (ca *CA) CertificatePEM() ([]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if err := ca.load(true); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), nil
+}
+
+// Pool returns the CA certificate as a pool, to verify agent certificates
+// with. A CA not created yet has signed nothing, so its pool is empty.
+func (ca *CA) Pool() (*x509.CertPool, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	pool := x509.NewCertPool()
+	if err := ca.load(false); err != nil {
+		return nil, err
+	}
+	if ca.cert != nil {
+		pool.AddCert(ca.cert)
+	}
+	return pool, nil
+}
+
+// IssueClient returns a client certificate for a target, valid for ttl.
+func (ca *CA) IssueClient(target string, ttl time.Duration) (Certificate, error) {
+	if target == "" {
+		return Certificate{}, fmt.Errorf("a certificate needs a target")
+	}
+	issuedAt := ca.now().Truncate(time.Second)
+	// As for credentials: a certificate issued in the second of a
+	// revocation would be taken for one issued before it
+	r, revoked, err := NewAuthority(ca.dir).revocation(target)
+	if err != nil {
+		return Certificate{}, err
+	}
+	if revoked && !issuedAt.After(r.RevokedAt) {
+		issuedAt = r.RevokedAt.Truncate(time.Second).Add(time.Second)
+	}
+	return ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: target, OrganizationalUnit: []string{Audience}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, issuedAt, ttl)
+}
+
+// IssueServer returns a server certificate for hosts, host names or IP
+// addresses, valid for ttl.
+func (ca *CA) IssueServer(hosts []string, ttl time.Duration) (Certificate, error) {
+	if len(hosts) == 0 {
+		return Certificate{}, fmt.Errorf("a server certificate needs a host")
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return ca.issue(template, ca.now().Truncate(time.Second), ttl)
+}
+
+// issue signs a certificate issued at issuedAt, a whole second
+func (ca *CA) issue(template *x509.Certificate, issuedAt time.Time, ttl time.Duration) (Certificate, error) {
+	if ttl <= 0 {
+		return Certificate{}, fmt.Errorf("a certificate needs a positive lifetime")
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if err := ca.load(true); err != nil {
+		return Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	// Backdated a minute for clocks behind the server's
+	template.SerialNumber = serial
+	template.NotBefore = issuedAt.Add(-certBackdate)
+	template.NotAfter = issuedAt.Add(ttl)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("sign certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return Certificate{}, fmt.Errorf("marshal key: %w", err)
+	}
+	return Certificate{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		NotBefore:   template.NotBefore,
+		NotAfter:    template.NotAfter,
+	}, nil
+}
+
+// load reads the CA, or with create makes one if there is none yet
+func (ca *CA) load(create bool) error {
+	if ca.cert != nil {
+		return nil
+	}
+	certPath := filepath.Join(ca.dir, "ca.crt")
+	keyPath := filepath.Join(ca.dir, "ca.key")
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parse CA certificate: %w", err)
+		}
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("invalid CA key in %s", keyPath)
+		}
+		ca.cert, ca.key = cert, key
+		return nil
+	}
+	if _, statErr := os.Stat(certPath); !os.IsNotExist(statErr) {
+		return fmt.Errorf("read CA: %w", err)
+	}
+	if !create {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate CA key: %w", err)
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return err
+	}
+	now := ca.now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: caCommonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caTTL),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("parse CA certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshal CA key: %w", err)
+	}
+
+	if err := os.MkdirAll(ca.dir, 0700); err != nil {
+		return fmt.Errorf("create agent auth directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("write CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("write CA certificate: %w", err)
+	}
+	ca.cert, ca.key = cert, key
+	return nil
+}
+
+// VerifyCertificate checks that a client certificate, already verified
+// against the CA by the TLS handshake, is an agent certificate issued
+// after the last revocation of its target, and returns its claims.
+func (a *Authority) VerifyCertificate(cert *x509.Certificate) (Claims, error) {
+	if cert.Subject.CommonName == "" || len(cert.Subject.OrganizationalUnit) == 0 || cert.Subject.OrganizationalUnit[0] != Audience {
+		return Claims{}, ErrInvalid
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.now().Before(cert.NotAfter) {
+		return Claims{}, ErrExpired
+	}
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return Claims{}, err
+	}
+	issuedAt := cert.NotBefore.Add(certBackdate)
+	if r, ok := revocations[cert.Subject.CommonName]; ok && !issuedAt.After(r.RevokedAt) {
+		return Claims{}, ErrRevoked
+	}
+	return Claims{
+		Subject:   cert.Subject.CommonName,
+		Audience:  Audience,
+		ID:        cert.SerialNumber.Text(16),
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: cert.NotAfter.Unix(),
+	}, nil
+}
+
+// revocation returns the latest revocation of a target, if any
+func (a *Authority) revocation(target string) (Revocation, bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	revocations, err := a.loadRevocations()
+	if err != nil {
+		return Revocation{}, false, err
+	}
+	r, ok := revocations[target]
+	return r, ok, nil
+}
+
+func serialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial number: %w", err)
+	}
+	return serial, nil
+}
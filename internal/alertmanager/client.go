@@ -0,0 +1,177 @@
+// Package alertmanager provides a minimal client for the Alertmanager v2 API.
+package alertmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultURL is the Alertmanager address installed by aami init
+const DefaultURL = "http://localhost:9093"
+
+// Client handles requests to Alertmanager.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient creates a new Alertmanager client.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Matcher matches alerts by label.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// Silence is an Alertmanager silence.
+type Silence struct {
+	ID        string    `json:"id,omitempty"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+	Status    *struct {
+		State string `json:"state"`
+	} `json:"status,omitempty"`
+}
+
+// Alert is an alert as returned by Alertmanager.
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Status      struct {
+		State       string   `json:"state"` // active, suppressed, unprocessed
+		SilencedBy  []string `json:"silencedBy"`
+		InhibitedBy []string `json:"inhibitedBy"`
+	} `json:"status"`
+}
+
+// Status is the status of an Alertmanager and of its cluster.
+type Status struct {
+	Cluster struct {
+		Name   string `json:"name"`
+		Status string `json:"status"` // ready, settling, disabled
+		Peers  []struct {
+			Name    string `json:"name"`
+			Address string `json:"address"`
+		} `json:"peers"`
+	} `json:"cluster"`
+	Uptime time.Time `json:"uptime"`
+}
+
+// Equal returns a matcher for label=value.
+func Equal(name, value string) Matcher {
+	return Matcher{Name: name, Value: value, IsEqual: true}
+}
+
+// Regex returns a matcher for label=~regex.
+func Regex(name, regex string) Matcher {
+	return Matcher{Name: name, Value: regex, IsRegex: true, IsEqual: true}
+}
+
+// CreateSilence creates a silence and returns its ID.
+func (c *Client) CreateSilence(s Silence) (string, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("marshal silence: %w", err)
+	}
+
+	var result struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := c.do(http.MethodPost, "/api/v2/silences", body, &result); err != nil {
+		return "", err
+	}
+	return result.SilenceID, nil
+}
+
+// ListSilences returns all silences.
+func (c *Client) ListSilences() ([]Silence, error) {
+	var silences []Silence
+	if err := c.do(http.MethodGet, "/api/v2/silences", nil, &silences); err != nil {
+		return nil, err
+	}
+	return silences, nil
+}
+
+// ExpireSilence expires a silence by ID.
+func (c *Client) ExpireSilence(id string) error {
+	return c.do(http.MethodDelete, "/api/v2/silence/"+url.PathEscape(id), nil, nil)
+}
+
+// Status returns the status of the Alertmanager and its cluster.
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do(http.MethodGet, "/api/v2/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ListAlerts returns alerts matching the given label filters (e.g. `node="gpu-01"`).
+func (c *Client) ListAlerts(filters ...string) ([]Alert, error) {
+	path := "/api/v2/alerts"
+	if len(filters) > 0 {
+		params := url.Values{}
+		for _, f := range filters {
+			params.Add("filter", f)
+		}
+		path += "?" + params.Encode()
+	}
+
+	var alerts []Alert
+	if err := c.do(http.MethodGet, path, nil, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+func (c *Client) do(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("alertmanager request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alertmanager error: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("parse response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package api hosts versioned HTTP routes side by side and marks old routes
+// deprecated.
+//
+// Routes live under /api/<version>/ (e.g. /api/v1/feed/), so a /api/v2 group
+// can be added next to v1 without breaking existing clients. Deprecated
+// routes keep working until their sunset date and announce it with the
+// Deprecation (RFC 9745), Sunset (RFC 8594) and Link rel="successor-version"
+// headers; after the sunset they answer 410 Gone.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Deprecation describes when a route was deprecated and when it goes away.
+type Deprecation struct {
+	Since     time.Time // announced in the Deprecation header
+	Sunset    time.Time // zero: no removal date yet
+	Successor string    // path of the replacement route, if any
+}
+
+// Unversioned applies to the routes served before /api/v1 existed
+// (/feed/, /chatops, /inventory, /replication/). They remain as aliases of
+// their /api/v1 routes until the sunset.
+var Unversioned = Deprecation{
+	Since:  time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+	Sunset: time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// Mux is an http.ServeMux with versioned route groups.
+type Mux struct {
+	mux *http.ServeMux
+
+	// OnDeprecated, if set, is called for every request to a deprecated
+	// route, so operators can find clients that still need to migrate.
+	OnDeprecated func(r *http.Request, d Deprecation)
+
+	// OnServed, if set, is called after every request with the pattern of
+	// the route that served it ("" when none matched), the response status
+	// and how long it took.
+	OnServed func(r *http.Request, route string, status int, d time.Duration)
+}
+
+// NewMux creates an empty Mux.
+func NewMux() *Mux {
+	return &Mux{mux: http.NewServeMux()}
+}
+
+// ServeHTTP dispatches to the registered routes.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.OnServed == nil {
+		m.mux.ServeHTTP(w, r)
+		return
+	}
+	start := time.Now()
+	h, route := m.mux.Handler(r)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	h.ServeHTTP(sw, r)
+	m.OnServed(r, route, sw.status, time.Since(start))
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Handle registers h for a path outside the versioned groups, such as
+// /metrics, whose clients expect a fixed path.
+func (m *Mux) Handle(pattern string, h http.Handler) {
+	m.mux.Handle(pattern, h)
+}
+
+// Version returns the route group for an API version such as "v1".
+func (m *Mux) Version(version string) *Group {
+	return &Group{mux: m, prefix: "/api/" + version}
+}
+
+// Deprecated registers a route outside the versioned groups that still
+// works until d.Sunset.
+func (m *Mux) Deprecated(pattern string, h http.Handler, d Deprecation) {
+	m.mux.Handle(pattern, m.deprecate(h, d, nil))
+}
+
+// deprecate wraps h with the deprecation headers. successor, if set,
+// computes d.Successor per request.
+func (m *Mux) deprecate(h http.Handler, d Deprecation, successor func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := d
+		if successor != nil {
+			d.Successor = successor(r)
+		}
+		if !d.Since.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+		}
+		if !d.Sunset.IsZero() {
+			w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Successor != "" {
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", d.Successor))
+		}
+		if m.OnDeprecated != nil {
+			m.OnDeprecated(r, d)
+		}
+
+		if !d.Sunset.IsZero() && !time.Now().Before(d.Sunset) {
+			msg := fmt.Sprintf("%s was removed on %s", r.URL.Path, d.Sunset.UTC().Format("2006-01-02"))
+			if d.Successor != "" {
+				msg += "; use " + d.Successor
+			}
+			http.Error(w, msg, http.StatusGone)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Group is the set of routes of one API version.
+type Group struct {
+	mux    *Mux
+	prefix string
+}
+
+// Path returns the full path of a route in this group.
+func (g *Group) Path(path string) string {
+	return g.prefix + path
+}
+
+// Handle registers h for path within the group. The version prefix is
+// stripped, so h sees the same path as it would unversioned (a handler
+// mounted at /feed/ sees /feed/<name> for /api/v1/feed/<name>).
+func (g *Group) Handle(path string, h http.Handler) {
+	g.mux.mux.Handle(g.Path(path), http.StripPrefix(g.prefix, h))
+}
+
+// HandleFunc registers a handler function for path within the group.
+func (g *Group) HandleFunc(path string, h func(http.ResponseWriter, *http.Request)) {
+	g.Handle(path, http.HandlerFunc(h))
+}
+
+// Deprecated registers h for path within the group as a deprecated route.
+func (g *Group) Deprecated(path string, h http.Handler, d Deprecation) {
+	g.mux.mux.Handle(g.Path(path), http.StripPrefix(g.prefix, g.mux.deprecate(h, d, nil)))
+}
+
+// HandleWithAlias registers h for path within the group, and keeps path
+// working unversioned as a deprecated alias. The alias points each request
+// to its versioned equivalent (/feed/a → /api/v1/feed/a).
+func (g *Group) HandleWithAlias(path string, h http.Handler, d Deprecation) {
+	g.Handle(path, h)
+	g.mux.mux.Handle(path, g.mux.deprecate(h, d, func(r *http.Request) string {
+		successor := g.prefix + r.URL.Path
+		if r.URL.RawQuery != "" {
+			successor += "?" + r.URL.RawQuery
+		}
+		return successor
+	}))
+}
@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"sort"
+	"time"
+)
+
+// Lifecycle decides which backups are pruned. Zero fields do not prune.
+type Lifecycle struct {
+	KeepLast int           // backups kept, newest first
+	MaxAge   time.Duration // backups older than this are pruned
+}
+
+// IsZero reports whether the lifecycle prunes nothing.
+func (l Lifecycle) IsZero() bool {
+	return l.KeepLast <= 0 && l.MaxAge <= 0
+}
+
+// Expired returns the backups the lifecycle prunes: those beyond the
+// KeepLast newest and those older than MaxAge. The newest backup is never
+// pruned, so a stalled backup job does not leave nothing to restore.
+func (l Lifecycle) Expired(backups []BackupInfo, now time.Time) []BackupInfo {
+	sorted := make([]BackupInfo, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	var expired []BackupInfo
+	for i, bi := range sorted {
+		if i == 0 {
+			continue
+		}
+		if (l.KeepLast > 0 && i >= l.KeepLast) || (l.MaxAge > 0 && now.Sub(bi.CreatedAt) > l.MaxAge) {
+			expired = append(expired, bi)
+		}
+	}
+	return expired
+}
+
+// NewBackupInfo describes a backup file stored elsewhere, such as in object
+// storage. It reports false for files that are not backups.
+func NewBackupInfo(name, path string, size int64, createdAt time.Time) (BackupInfo, bool) {
+	if !isBackupFile(name) {
+		return BackupInfo{}, false
+	}
+	return BackupInfo{
+		Name:         name,
+		Path:         path,
+		Size:         size,
+		CreatedAt:    createdAt,
+		IsFullBackup: isFullBackup(name),
+	}, true
+}
@@ -0,0 +1,355 @@
+// Package bmc stores the BMC credentials of nodes, which the IPMI exporter
+// (services/exporters/ipmi) of each node fetches to poll its BMC. The
+// address and protocol of a BMC are kept in the clear; the username and
+// password are sealed with AES-256-GCM under a key of the server, bound to
+// the node they belong to, so a copied file neither reveals them nor
+// decrypts as another node's. One JSON file per node. The key is kept
+// apart from the records, so a copy of the data directory alone does not
+// decrypt them.
+package bmc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir is where credentials are stored
+const DefaultDir = "/var/lib/aami/bmc"
+
+// DefaultKeyFile holds the hex-encoded AES-256 key, created with the first
+// credential with mode 0600
+const DefaultKeyFile = "/etc/aami/bmc.key"
+
+// legacyKeyFile is where the key was kept before, next to the records; it
+// is moved to the key file when found
+const legacyKeyFile = "secret.key"
+
+// Protocols a BMC is polled with
+const (
+	ProtocolIPMI    = "ipmi"    // ipmitool over IPMI v2.0 (lanplus)
+	ProtocolRedfish = "redfish" // Redfish REST API over HTTPS
+)
+
+// ErrNotFound is returned for a node without a BMC credential
+var ErrNotFound = errors.New("no BMC credential")
+
+// Credential is how the IPMI exporter of a node reaches its BMC.
+type Credential struct {
+	Target             string    `json:"target"`             // hostname of the node
+	Address            string    `json:"address"`            // BMC host or host:port
+	Protocol           string    `json:"protocol"`           // ipmi or redfish
+	Username           string    `json:"username,omitempty"` // omitted when listed
+	Password           string    `json:"password,omitempty"` // omitted when listed
+	InsecureSkipVerify bool      `json:"insecure_skip_verify,omitempty"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// Validate checks the fields an operator must give.
+func (c Credential) Validate() error {
+	if err := c.validTarget(); err != nil {
+		return err
+	}
+	if c.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if strings.ContainsAny(c.Address, "/ ") {
+		return fmt.Errorf("invalid address %q: give a host or host:port", c.Address)
+	}
+	if c.Protocol != ProtocolIPMI && c.Protocol != ProtocolRedfish {
+		return fmt.Errorf("invalid protocol %q (valid: %s, %s)", c.Protocol, ProtocolIPMI, ProtocolRedfish)
+	}
+	if c.Username == "" || c.Password == "" {
+		return fmt.Errorf("username and password are required")
+	}
+	return nil
+}
+
+// Host returns the BMC address without its port
+func (c Credential) Host() string {
+	if host, _, err := net.SplitHostPort(c.Address); err == nil {
+		return host
+	}
+	return c.Address
+}
+
+// Redacted returns the credential without its username and password
+func (c Credential) Redacted() Credential {
+	c.Username, c.Password = "", ""
+	return c
+}
+
+// record is a credential as stored: the secret is the sealed JSON of
+// secret
+type record struct {
+	Target             string    `json:"target"`
+	Address            string    `json:"address"`
+	Protocol           string    `json:"protocol"`
+	Secret             string    `json:"secret"` // base64 of nonce and ciphertext
+	InsecureSkipVerify bool      `json:"insecure_skip_verify,omitempty"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+type secret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Store reads and writes credentials.
+type Store struct {
+	dir     string
+	keyFile string
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewStore creates a credential store in dir, sealed with the key in
+// keyFile.
+func NewStore(dir, keyFile string) *Store {
+	return &Store{dir: dir, keyFile: keyFile, now: time.Now}
+}
+
+// Put validates, seals and stores the credential of a node, replacing the
+// one it had. It returns the credential redacted.
+func (s *Store) Put(c Credential) (Credential, error) {
+	if err := c.Validate(); err != nil {
+		return Credential{}, err
+	}
+	c.UpdatedAt = s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return Credential{}, fmt.Errorf("create BMC directory: %w", err)
+	}
+	aead, err := s.aead(true)
+	if err != nil {
+		return Credential{}, err
+	}
+	plain, err := json.Marshal(secret{Username: c.Username, Password: c.Password})
+	if err != nil {
+		return Credential{}, fmt.Errorf("marshal credential: %w", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Credential{}, fmt.Errorf("generate nonce: %w", err)
+	}
+	rec := record{
+		Target:             c.Target,
+		Address:            c.Address,
+		Protocol:           c.Protocol,
+		Secret:             base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(c.Target))),
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		UpdatedAt:          c.UpdatedAt,
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return Credential{}, fmt.Errorf("marshal credential: %w", err)
+	}
+	path := s.path(c.Target)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return Credential{}, fmt.Errorf("write credential: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Credential{}, fmt.Errorf("write credential: %w", err)
+	}
+	return c.Redacted(), nil
+}
+
+// Get returns the credential of a node, decrypted.
+func (s *Store) Get(target string) (Credential, error) {
+	if err := (Credential{Target: target}).validTarget(); err != nil {
+		return Credential{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.read(s.path(target))
+	if err != nil {
+		return Credential{}, err
+	}
+	aead, err := s.aead(false)
+	if err != nil {
+		return Credential{}, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(rec.Secret)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return Credential{}, fmt.Errorf("invalid sealed credential of %s", target)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(rec.Target))
+	if err != nil || rec.Target != target {
+		return Credential{}, fmt.Errorf("decrypt credential of %s: wrong key or tampered file", target)
+	}
+	var sec secret
+	if err := json.Unmarshal(plain, &sec); err != nil {
+		return Credential{}, fmt.Errorf("parse credential of %s: %w", target, err)
+	}
+	c := rec.credential()
+	c.Username, c.Password = sec.Username, sec.Password
+	return c, nil
+}
+
+// List returns the credentials of every node, redacted, by target.
+func (s *Store) List() ([]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Credential{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read BMC directory: %w", err)
+	}
+	creds := []Credential{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		rec, err := s.read(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, rec.credential())
+	}
+	sort.Slice(creds, func(i, j int) bool {
+		return creds[i].Target < creds[j].Target
+	})
+	return creds, nil
+}
+
+// Delete removes the credential of a node.
+func (s *Store) Delete(target string) error {
+	if err := (Credential{Target: target}).validTarget(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(target))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, target)
+	}
+	if err != nil {
+		return fmt.Errorf("remove credential: %w", err)
+	}
+	return nil
+}
+
+func (c Credential) validTarget() error {
+	if c.Target == "" || strings.ContainsAny(c.Target, `/\`) || strings.HasPrefix(c.Target, ".") {
+		return fmt.Errorf("invalid target %q", c.Target)
+	}
+	return nil
+}
+
+func (r record) credential() Credential {
+	return Credential{
+		Target:             r.Target,
+		Address:            r.Address,
+		Protocol:           r.Protocol,
+		InsecureSkipVerify: r.InsecureSkipVerify,
+		UpdatedAt:          r.UpdatedAt,
+	}
+}
+
+func (s *Store) path(target string) string {
+	return filepath.Join(s.dir, target+".json")
+}
+
+func (s *Store) read(path string) (record, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return record{}, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	if err != nil {
+		return record{}, fmt.Errorf("read credential: %w", err)
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return record{}, fmt.Errorf("parse credential %s: %w", path, err)
+	}
+	return rec, nil
+}
+
+// aead returns the cipher of the store's key. Without create, a missing
+// key is an error: no credential was sealed with it.
+func (s *Store) aead(create bool) (cipher.AEAD, error) {
+	if err := s.moveLegacyKey(); err != nil {
+		return nil, err
+	}
+	path := s.keyFile
+	var key []byte
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		key, err = hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("invalid BMC key in %s", path)
+		}
+	case os.IsNotExist(err) && create:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate BMC key: %w", err)
+		}
+		if err := writeKey(path, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("no BMC key in %s", path)
+	default:
+		return nil, fmt.Errorf("read BMC key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// moveLegacyKey moves a key kept next to the records, where it was kept
+// before, to the key file, unless the key file exists
+func (s *Store) moveLegacyKey() error {
+	legacy := filepath.Join(s.dir, legacyKeyFile)
+	data, err := os.ReadFile(legacy)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read BMC key: %w", err)
+	}
+	if current, err := os.ReadFile(s.keyFile); err == nil {
+		if strings.TrimSpace(string(current)) != strings.TrimSpace(string(data)) {
+			return fmt.Errorf("different BMC keys in %s and %s: remove the one the credentials were not sealed with", s.keyFile, legacy)
+		}
+	} else if err := writeKey(s.keyFile, data); err != nil {
+		return err
+	}
+	if err := os.Remove(legacy); err != nil {
+		return fmt.Errorf("remove %s: %w", legacy, err)
+	}
+	return nil
+}
+
+// writeKey writes a key readable by its owner only
+func writeKey(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create BMC key directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write BMC key: %w", err)
+	}
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,313 @@
+// Package chatops serves chat slash commands (Slack, Mattermost) for common
+// AAMI operations.
+package chatops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Roles, in increasing order of privilege
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+)
+
+var roleLevel = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+}
+
+// ackDuration is how long an acknowledged alert stays silenced
+const ackDuration = 4 * time.Hour
+
+// maxBodySize limits slash command request bodies
+const maxBodySize = 64 << 10
+
+const helpText = "Usage:\n" +
+	"  /aami status                        Active alerts summary\n" +
+	"  /aami silence <node> <duration> [comment]  Silence a node (e.g. 2h, 1d)\n" +
+	"  /aami ack <alertname> [node]        Acknowledge an alert (silences it for 4h)"
+
+// command describes a slash subcommand and the role it requires
+type command struct {
+	role string
+	run  func(h *Handler, cfg *config.Config, user string, args []string) (string, error)
+}
+
+var commands = map[string]command{
+	"status":  {role: RoleViewer, run: (*Handler).status},
+	"silence": {role: RoleOperator, run: (*Handler).silence},
+	"ack":     {role: RoleOperator, run: (*Handler).ack},
+}
+
+// Handler serves slash command requests.
+type Handler struct {
+	load func() (*config.Config, error)
+	am   *alertmanager.Client
+	now  func() time.Time
+}
+
+// NewHandler creates a slash command handler. The config is loaded on every
+// request so user role changes apply without a restart.
+func NewHandler(load func() (*config.Config, error), am *alertmanager.Client) *Handler {
+	return &Handler{load: load, am: am, now: time.Now}
+}
+
+// Response is understood by both Slack and Mattermost
+type Response struct {
+	ResponseType string `json:"response_type"` // in_channel, ephemeral
+	Text         string `json:"text"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "read body", http.StatusBadRequest)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := h.load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// With a signing secret configured every request must be signed: the
+	// token of a request is only checked when no secret is set, so a leaked
+	// token cannot stand in for the signature.
+	sig := r.Header.Get("X-Slack-Signature")
+	switch {
+	case sig != "":
+		err = VerifySlack(cfg.ChatOps.SigningSecret, r.Header.Get("X-Slack-Request-Timestamp"), sig, body, h.now())
+	case cfg.ChatOps.SigningSecret != "":
+		err = fmt.Errorf("missing X-Slack-Signature")
+	default:
+		err = VerifyToken(cfg.ChatOps.Token, form.Get("token"))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// A signing secret or token may be shared by several workspaces; only
+	// the configured team is trusted.
+	if cfg.ChatOps.TeamID != "" && form.Get("team_id") != cfg.ChatOps.TeamID {
+		http.Error(w, "team not allowed", http.StatusForbidden)
+		return
+	}
+
+	reply(w, h.Dispatch(cfg, User{ID: form.Get("user_id"), Name: form.Get("user_name")}, form.Get("text")))
+}
+
+// User is the chat user of a slash command. Roles are mapped by ID: the
+// name can be changed by the user and is shown only.
+type User struct {
+	ID   string
+	Name string
+}
+
+// display returns the name of the user for replies and silences
+func (u User) display() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.ID
+}
+
+// Dispatch runs a slash command for an already verified user.
+func (h *Handler) Dispatch(cfg *config.Config, user User, text string) Response {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] == "help" {
+		return Response{ResponseType: "ephemeral", Text: helpText}
+	}
+
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return Response{ResponseType: "ephemeral", Text: fmt.Sprintf("Unknown command: %s\n%s", fields[0], helpText)}
+	}
+
+	role := UserRole(cfg, user.ID)
+	if roleLevel[role] < roleLevel[cmd.role] {
+		return Response{ResponseType: "ephemeral",
+			Text: fmt.Sprintf("Permission denied: %s requires the %s role", fields[0], cmd.role)}
+	}
+
+	out, err := cmd.run(h, cfg, user.display(), fields[1:])
+	if err != nil {
+		return Response{ResponseType: "ephemeral", Text: "Error: " + err.Error()}
+	}
+	return Response{ResponseType: "in_channel", Text: out}
+}
+
+// UserRole returns the role mapped to a chat user ID.
+func UserRole(cfg *config.Config, userID string) string {
+	if role, ok := cfg.ChatOps.Users[userID]; ok && userID != "" {
+		return role
+	}
+	return cfg.ChatOps.DefaultRole
+}
+
+func (h *Handler) status(cfg *config.Config, user string, args []string) (string, error) {
+	alerts, err := h.am.ListAlerts()
+	if err != nil {
+		return "", err
+	}
+
+	counts := map[string]int{}
+	var critical []string
+	for _, a := range alerts {
+		if a.Status.State != "active" {
+			continue
+		}
+		severity := a.Labels["severity"]
+		counts[severity]++
+		if severity == "critical" {
+			critical = append(critical, fmt.Sprintf("• %s on %s", a.Labels["alertname"], alertTarget(a)))
+		}
+	}
+	sort.Strings(critical)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*: %d nodes, %d critical, %d warning alerts",
+		cfg.Cluster.Name, len(cfg.Nodes), counts["critical"], counts["warning"])
+	for i, line := range critical {
+		if i == 10 {
+			fmt.Fprintf(&b, "\n…and %d more", len(critical)-i)
+			break
+		}
+		b.WriteString("\n" + line)
+	}
+	return b.String(), nil
+}
+
+func (h *Handler) silence(cfg *config.Config, user string, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("usage: silence <node> <duration> [comment]")
+	}
+	node := args[0]
+	if !hasNode(cfg, node) {
+		return "", fmt.Errorf("unknown node: %s", node)
+	}
+	duration, err := ParseDuration(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	comment := strings.Join(args[2:], " ")
+	if comment == "" {
+		comment = "Silenced via chat"
+	}
+
+	now := h.now()
+	id, err := h.am.CreateSilence(alertmanager.Silence{
+		Matchers:  []alertmanager.Matcher{alertmanager.Equal("node", node)},
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: user,
+		Comment:   comment,
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s silenced %s for %s (silence %s)", user, node, args[1], id), nil
+}
+
+func (h *Handler) ack(cfg *config.Config, user string, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", fmt.Errorf("usage: ack <alertname> [node]")
+	}
+
+	matchers := []alertmanager.Matcher{alertmanager.Equal("alertname", args[0])}
+	filters := []string{fmt.Sprintf("alertname=%q", args[0])}
+	target := args[0]
+	if len(args) == 2 {
+		matchers = append(matchers, alertmanager.Equal("node", args[1]))
+		filters = append(filters, fmt.Sprintf("node=%q", args[1]))
+		target += " on " + args[1]
+	}
+
+	alerts, err := h.am.ListAlerts(filters...)
+	if err != nil {
+		return "", err
+	}
+	active := 0
+	for _, a := range alerts {
+		if a.Status.State == "active" {
+			active++
+		}
+	}
+	if active == 0 {
+		return "", fmt.Errorf("no active alert matches %s", target)
+	}
+
+	now := h.now()
+	id, err := h.am.CreateSilence(alertmanager.Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(ackDuration),
+		CreatedBy: user,
+		Comment:   "Acknowledged via chat",
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s acknowledged %s (%d alerts, silence %s)", user, target, active, id), nil
+}
+
+// ParseDuration parses durations like "30m", "2h" and "1d".
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+func hasNode(cfg *config.Config, name string) bool {
+	for _, node := range cfg.Nodes {
+		if node.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func alertTarget(a alertmanager.Alert) string {
+	if node := a.Labels["node"]; node != "" {
+		return node
+	}
+	return a.Labels["instance"]
+}
+
+func reply(w http.ResponseWriter, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
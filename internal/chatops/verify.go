@@ -0,0 +1,52 @@
+package chatops
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// maxRequestAge rejects replayed Slack requests
+const maxRequestAge = 5 * time.Minute
+
+// VerifySlack checks a Slack request signature (X-Slack-Signature) computed
+// over the timestamp and raw request body with the app's signing secret.
+func VerifySlack(secret, timestamp, signature string, body []byte, now time.Time) error {
+	if secret == "" {
+		return fmt.Errorf("slack signing secret is not configured")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp")
+	}
+	if math.Abs(now.Sub(time.Unix(ts, 0)).Seconds()) > maxRequestAge.Seconds() {
+		return fmt.Errorf("request timestamp too old")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return fmt.Errorf("invalid request signature")
+	}
+	return nil
+}
+
+// VerifyToken checks a Mattermost slash command token.
+func VerifyToken(expected, token string) error {
+	if expected == "" {
+		return fmt.Errorf("slash command token is not configured")
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+// Package checkresult stores the outcomes of check scripts reported by node
+// agents. Results are appended to one JSON Lines file per day, so retention
+// removes whole files and never rewrites the ones still kept.
+package checkresult
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir is where check results are stored
+const DefaultDir = "/var/lib/aami/check-results"
+
+// DefaultRetention is how long results are kept when check_results.retention
+// is not set
+const DefaultRetention = 30 * 24 * time.Hour
+
+// MaxOutputBytes is how much of a check's output and error is kept
+const MaxOutputBytes = 4096
+
+// Check statuses
+const (
+	StatusPassed  = "passed"  // the script exited 0
+	StatusFailed  = "failed"  // the script exited non-zero
+	StatusTimeout = "timeout" // the script was killed after its timeout
+	StatusError   = "error"   // the script could not be run
+)
+
+// Statuses returns the valid check statuses
+func Statuses() []string {
+	return []string{StatusPassed, StatusFailed, StatusTimeout, StatusError}
+}
+
+// dayLayout names the file of each day
+const dayLayout = "2006-01-02"
+
+// Result is one run of a check on a target.
+type Result struct {
+	ID         string    `json:"id"`
+	Target     string    `json:"target"` // hostname of the node
+	Check      string    `json:"check"`
+	Status     string    `json:"status"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	Output     string    `json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// Validate checks the fields an agent must report.
+func (r Result) Validate() error {
+	if r.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if r.Check == "" {
+		return fmt.Errorf("check is required")
+	}
+	if !validStatus(r.Status) {
+		return fmt.Errorf("invalid status %q (valid: %s)", r.Status, strings.Join(Statuses(), ", "))
+	}
+	if r.DurationMS < 0 {
+		return fmt.Errorf("duration_ms must be non-negative")
+	}
+	return nil
+}
+
+func validStatus(s string) bool {
+	for _, status := range Statuses() {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects results. Empty fields match everything.
+type Filter struct {
+	Target string
+	Check  string
+	Status string
+	Since  time.Time // reported at or after
+	Until  time.Time // reported before
+	// Targets, if not nil, limits results to these targets, e.g. the nodes
+	// of a tenant's namespace
+	Targets map[string]bool
+}
+
+// Match reports whether a result passes the filter.
+func (f Filter) Match(r Result) bool {
+	switch {
+	case f.Target != "" && r.Target != f.Target:
+		return false
+	case f.Check != "" && r.Check != f.Check:
+		return false
+	case f.Status != "" && r.Status != f.Status:
+		return false
+	case !f.Since.IsZero() && r.ReportedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !r.ReportedAt.Before(f.Until):
+		return false
+	case f.Targets != nil && !f.Targets[r.Target]:
+		return false
+	}
+	return true
+}
+
+// Page is one page of results, newest first.
+type Page struct {
+	Results []Result `json:"results"`
+	Page    int      `json:"page"`
+	Limit   int      `json:"limit"`
+	Total   int      `json:"total"` // results matching the filter
+}
+
+// Summary aggregates the results reported since a point in time.
+type Summary struct {
+	Since    time.Time      `json:"since"`
+	Runs     int            `json:"runs"`
+	Statuses map[string]int `json:"statuses"` // runs by status
+	Latest   map[string]int `json:"latest"`   // latest result of each target and check, by status
+	Checks   []CheckSummary `json:"checks"`
+	Failing  []Result       `json:"failing"` // latest results that did not pass
+}
+
+// CheckSummary aggregates the results of one check.
+type CheckSummary struct {
+	Check       string     `json:"check"`
+	Targets     int        `json:"targets"` // targets that reported the check
+	Passing     int        `json:"passing"` // targets whose latest run passed
+	Failing     int        `json:"failing"` // targets whose latest run did not pass
+	Runs        int        `json:"runs"`
+	Failures    int        `json:"failures"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+// Store reads and writes check results.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewStore creates a check result store.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, now: time.Now}
+}
+
+// Add validates and stores results. Each is given an ID and the time it was
+// reported; results without a start time are taken to start when reported.
+// Nothing is stored unless all results are valid.
+func (s *Store) Add(results []Result) ([]Result, error) {
+	for i, r := range results {
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("result %d: %w", i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UTC()
+	var lines strings.Builder
+	stored := make([]Result, 0, len(results))
+	for _, r := range results {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		r.ID = id
+		r.ReportedAt = now
+		if r.StartedAt.IsZero() {
+			r.StartedAt = now
+		}
+		r.Output = truncate(r.Output)
+		r.Error = truncate(r.Error)
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			return nil, fmt.Errorf("marshal check result: %w", err)
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+		stored = append(stored, r)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create check result directory: %w", err)
+	}
+	path := filepath.Join(s.dir, now.Format(dayLayout)+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open check results: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(lines.String()); err != nil {
+		return nil, fmt.Errorf("write check results: %w", err)
+	}
+	return stored, nil
+}
+
+// List returns a page of the results matching the filter, newest first.
+// Pages start at 1.
+func (s *Store) List(f Filter, page, limit int) (Page, error) {
+	if page < 1 {
+		return Page{}, fmt.Errorf("page must be at least 1")
+	}
+	if limit < 1 {
+		return Page{}, fmt.Errorf("limit must be at least 1")
+	}
+
+	matched, err := s.read(f)
+	if err != nil {
+		return Page{}, err
+	}
+	p := Page{Results: []Result{}, Page: page, Limit: limit, Total: len(matched)}
+	if start := (page - 1) * limit; start < len(matched) {
+		end := start + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		p.Results = matched[start:end]
+	}
+	return p, nil
+}
+
+// Summary aggregates the results matching the filter, reported since
+// f.Since.
+func (s *Store) Summary(f Filter) (Summary, error) {
+	since := f.Since
+	results, err := s.read(f)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary := Summary{
+		Since:    since.UTC(),
+		Runs:     len(results),
+		Statuses: map[string]int{},
+		Latest:   map[string]int{},
+		Failing:  []Result{},
+	}
+	checks := map[string]*CheckSummary{}
+	seen := map[[2]string]bool{}
+
+	// results are newest first, so the first seen per target and check is
+	// the latest
+	for _, r := range results {
+		summary.Statuses[r.Status]++
+
+		c := checks[r.Check]
+		if c == nil {
+			c = &CheckSummary{Check: r.Check}
+			checks[r.Check] = c
+		}
+		c.Runs++
+		if r.Status != StatusPassed {
+			c.Failures++
+			if c.LastFailure == nil {
+				at := r.ReportedAt
+				c.LastFailure = &at
+			}
+		}
+
+		key := [2]string{r.Target, r.Check}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		summary.Latest[r.Status]++
+		c.Targets++
+		if r.Status == StatusPassed {
+			c.Passing++
+		} else {
+			c.Failing++
+			summary.Failing = append(summary.Failing, r)
+		}
+	}
+
+	summary.Checks = make([]CheckSummary, 0, len(checks))
+	for _, c := range checks {
+		summary.Checks = append(summary.Checks, *c)
+	}
+	sort.Slice(summary.Checks, func(i, j int) bool {
+		return summary.Checks[i].Check < summary.Checks[j].Check
+	})
+	sort.SliceStable(summary.Failing, func(i, j int) bool {
+		a, b := summary.Failing[i], summary.Failing[j]
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Check < b.Check
+	})
+	return summary, nil
+}
+
+// Prune removes the days older than the retention period and returns how
+// many day files were removed. The day the period starts in is kept whole.
+func (s *Store) Prune(retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, fmt.Errorf("retention must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.days()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := s.now().UTC().Add(-retention).Format(dayLayout)
+	removed := 0
+	for _, day := range days {
+		if day >= cutoff {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, day+".jsonl")); err != nil {
+			return removed, fmt.Errorf("remove check results of %s: %w", day, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// read returns the results matching the filter, newest first. Day files
+// outside the filter's time range are not opened.
+func (s *Store) read(f Filter) ([]Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Result
+	for i := len(days) - 1; i >= 0; i-- {
+		day := days[i]
+		if !f.Since.IsZero() && day < f.Since.UTC().Format(dayLayout) {
+			break
+		}
+		if !f.Until.IsZero() && day > f.Until.UTC().Format(dayLayout) {
+			continue
+		}
+		results, err := s.readDay(day)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(results) - 1; j >= 0; j-- {
+			if f.Match(results[j]) {
+				matched = append(matched, results[j])
+			}
+		}
+	}
+	return matched, nil
+}
+
+// readDay returns the results of a day in the order they were stored. A
+// line cut short by a crash is skipped rather than failing every read.
+func (s *Store) readDay(day string) ([]Result, error) {
+	file, err := os.Open(filepath.Join(s.dir, day+".jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("read check results: %w", err)
+	}
+	defer file.Close()
+
+	var results []Result
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		results = append(results, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read check results of %s: %w", day, err)
+	}
+	return results, nil
+}
+
+// days returns the days with stored results, oldest first.
+func (s *Store) days() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read check result directory: %w", err)
+	}
+
+	var days []string
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func truncate(s string) string {
+	if len(s) <= MaxOutputBytes {
+		return s
+	}
+	return s[:MaxOutputBytes]
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,63 @@
+package checkresult
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client reports results to the check result API, as node agents do.
+type Client struct {
+	baseURL    string
+	credential string
+	httpClient *http.Client
+}
+
+// NewClient creates a client of the API at baseURL, authenticated with the
+// node's agent credential if it has one.
+func NewClient(baseURL, credential string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		credential: credential,
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Report stores results on the server and returns them as stored.
+func (c *Client) Report(ctx context.Context, results []Result) ([]Result, error) {
+	body, err := json.Marshal(map[string]interface{}{"results": results})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/check-results", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.credential != "" {
+		req.Header.Set("Authorization", "Bearer "+c.credential)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("check result API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("check result API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var stored struct {
+		Results []Result `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return nil, fmt.Errorf("check result API: decode: %w", err)
+	}
+	return stored.Results, nil
+}
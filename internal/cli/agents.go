@@ -0,0 +1,430 @@
+package cli
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/agentauth"
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+)
+
+var (
+	agentsTTL     string
+	agentsFile    string
+	agentsReason  string
+	agentsCertDir string
+)
+
+var agentsCmd = &cobra.Command{
+	Use:     "agents",
+	Aliases: []string{"agent"},
+	Short:   "Manage node agent credentials and tasks",
+	Long: `Manage the credentials node agents use after bootstrap, and queue
+tasks for agents to run on their nodes.
+
+A credential is a signed token scoped to one node and valid for
+agent_auth.ttl (default 24h). The Config Server issues it at registration,
+and agents renew it when a third of its lifetime is left, so a credential
+taken from a node stops working soon, and it never works for another node.
+Revoking a node invalidates all of its credentials at once.
+
+Credentials are signed with the key in /var/lib/aami/agent-auth, shared with
+the Config Server. With agent_auth.required, 'aami check-results serve'
+rejects agent requests without a valid credential.
+
+For mutual TLS, the same directory holds an internal CA that issues agents
+a client certificate, scoped to their node like a credential and valid for
+agent_auth.cert_ttl (default 30d). Agents get one at registration and
+renew it when a third of its lifetime is left. With agent_auth.mtls, 'aami
+check-results serve' takes agent requests only over TLS with one. Revoking
+a node also revokes its certificates.
+
+Tasks are queued on the config server and fetched by agents with their
+heartbeat, so operators can run checks now, restart an exporter or collect
+a diagnostic bundle on a node without logging in to it.
+
+Examples:
+  aami agents issue gpu-node-01 --file /tmp/gpu-node-01.credential
+  aami agents certificate gpu-node-01 --dir /tmp/gpu-node-01
+  aami agents revoke gpu-node-01 --reason "node stolen from rack A1"
+  aami agents revocations
+  aami agents run restart-exporter gpu-node-01 --exporter dcgm-exporter --wait
+  aami agents tasks gpu-node-01`,
+}
+
+var agentsCertificateCmd = &cobra.Command{
+	Use:     "certificate <node>",
+	Aliases: []string{"cert"},
+	Short:   "Issue a client certificate for a node",
+	Long: `Issue a client certificate for a node, for nodes enrolled without
+bootstrap.sh or whose certificate expired. It writes agent.crt, agent.key
+(mode 0600) and the CA certificate ca.crt to --dir; install them on the node
+in /etc/aami.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAgentsCertificate,
+}
+
+var agentsCACmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Print the agent CA certificate",
+	Long: `Print the certificate of the internal CA that signs agent and server
+certificates, for clients to trust. The CA is created on first use.`,
+	Args: cobra.NoArgs,
+	RunE: runAgentsCA,
+}
+
+var agentsIssueCmd = &cobra.Command{
+	Use:   "issue <node>",
+	Short: "Issue a credential for a node",
+	Long: `Issue a credential for a node, for nodes enrolled without bootstrap.sh
+or whose credential expired. Install it on the node as
+/etc/aami/agent-credential (mode 0600).`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAgentsIssue,
+}
+
+var agentsRevokeCmd = &cobra.Command{
+	Use:   "revoke <node>",
+	Short: "Revoke all credentials of a node",
+	Long: `Revoke all credentials and client certificates issued to a node so
+far, including the ones the agent would renew. Credentials and certificates
+issued afterwards with 'aami agents issue' and 'aami agents certificate'
+are valid again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAgentsRevoke,
+}
+
+var agentsRevocationsCmd = &cobra.Command{
+	Use:   "revocations",
+	Short: "List credential revocations",
+	Args:  cobra.NoArgs,
+	RunE:  runAgentsRevocations,
+}
+
+func init() {
+	agentsIssueCmd.Flags().StringVar(&agentsTTL, "ttl", "",
+		"Credential lifetime (default: agent_auth.ttl or 24h)")
+	agentsIssueCmd.Flags().StringVarP(&agentsFile, "file", "f", "",
+		"Write the credential to this file (mode 0600) instead of printing it")
+	agentsCertificateCmd.Flags().StringVar(&agentsTTL, "ttl", "",
+		"Certificate lifetime (default: agent_auth.cert_ttl or 30d)")
+	agentsCertificateCmd.Flags().StringVar(&agentsCertDir, "dir", ".",
+		"Directory to write agent.crt, agent.key and ca.crt to")
+	agentsRevokeCmd.Flags().StringVar(&agentsReason, "reason", "",
+		"Why the credentials and certificates are revoked")
+
+	agentsCmd.AddCommand(agentsIssueCmd)
+	agentsCmd.AddCommand(agentsCertificateCmd)
+	agentsCmd.AddCommand(agentsCACmd)
+	agentsCmd.AddCommand(agentsRevokeCmd)
+	agentsCmd.AddCommand(agentsRevocationsCmd)
+	rootCmd.AddCommand(agentsCmd)
+}
+
+func newAgentAuthority() *agentauth.Authority {
+	return agentauth.NewAuthority(agentauth.DefaultDir)
+}
+
+// agentCredentialTTL returns agent_auth.ttl, or the default
+func agentCredentialTTL(cfg *config.Config) (time.Duration, error) {
+	if cfg.AgentAuth.TTL == "" {
+		return agentauth.DefaultTTL, nil
+	}
+	d, err := chatops.ParseDuration(cfg.AgentAuth.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("agent_auth.ttl: %w", err)
+	}
+	return d, nil
+}
+
+func newAgentCA() *agentauth.CA {
+	return agentauth.NewCA(agentauth.DefaultDir)
+}
+
+// agentCertTTL returns agent_auth.cert_ttl, or the default
+func agentCertTTL(cfg *config.Config) (time.Duration, error) {
+	if cfg.AgentAuth.CertTTL == "" {
+		return agentauth.DefaultCertTTL, nil
+	}
+	d, err := chatops.ParseDuration(cfg.AgentAuth.CertTTL)
+	if err != nil {
+		return 0, fmt.Errorf("agent_auth.cert_ttl: %w", err)
+	}
+	return d, nil
+}
+
+// agentTLSConfig returns the TLS config of a server agents talk to. It
+// serves certFile and keyFile, or without them a certificate for hosts
+// issued by the agent CA and reissued when a third of its lifetime is
+// left. Agents may present a client certificate of the agent CA;
+// agentCredential decides whether they must.
+func agentTLSConfig(cfg *config.Config, certFile, keyFile string, hosts []string) (*tls.Config, error) {
+	ca := newAgentCA()
+	// Created now if needed, so agents registering later can be verified
+	if _, err := ca.CertificatePEM(); err != nil {
+		return nil, err
+	}
+	pool, err := ca.Pool()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" {
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+		return tlsConfig, nil
+	}
+
+	ttl, err := agentCertTTL(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu      sync.Mutex
+		current agentauth.Certificate
+		pair    tls.Certificate
+	)
+	issue := func() error {
+		cert, err := ca.IssueServer(hosts, ttl)
+		if err != nil {
+			return err
+		}
+		p, err := cert.TLS()
+		if err != nil {
+			return err
+		}
+		current, pair = cert, p
+		return nil
+	}
+	if err := issue(); err != nil {
+		return nil, fmt.Errorf("issue server certificate: %w", err)
+	}
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Now().After(current.RenewAt()) {
+			// A failed reissue keeps serving the current certificate
+			if err := issue(); err != nil {
+				fmt.Fprintf(os.Stderr, "reissue server certificate: %v\n", err)
+			}
+		}
+		return &pair, nil
+	}
+	return tlsConfig, nil
+}
+
+// agentCredential verifies the credential of an agent request: its client
+// certificate, else its bearer credential. It returns nil claims for a
+// request without either, which is an error only with agent_auth.required;
+// with agent_auth.mtls a certificate is required.
+func agentCredential(r *http.Request, cfg *config.Config, authority *agentauth.Authority) (*agentauth.Claims, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		claims, err := authority.VerifyCertificate(r.TLS.VerifiedChains[0][0])
+		if err != nil {
+			return nil, err
+		}
+		if !hasNodeConfig(cfg, claims.Target()) {
+			return nil, fmt.Errorf("%w: unknown target %s", agentauth.ErrInvalid, claims.Target())
+		}
+		return &claims, nil
+	}
+	if cfg.AgentAuth.MTLS {
+		return nil, agentauth.ErrCertificateRequired
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		if cfg.AgentAuth.Required {
+			return nil, agentauth.ErrMissing
+		}
+		return nil, nil
+	}
+	claims, err := authority.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if !hasNodeConfig(cfg, claims.Target()) {
+		return nil, fmt.Errorf("%w: unknown target %s", agentauth.ErrInvalid, claims.Target())
+	}
+	return &claims, nil
+}
+
+func runAgentsIssue(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !hasNodeConfig(cfg, args[0]) {
+		return fmt.Errorf("unknown node: %s", args[0])
+	}
+	ttl, err := agentCredentialTTL(cfg)
+	if err != nil {
+		return err
+	}
+	if agentsTTL != "" {
+		if ttl, err = chatops.ParseDuration(agentsTTL); err != nil {
+			return err
+		}
+	}
+
+	token, claims, err := newAgentAuthority().Issue(args[0], ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Issued credential for %s, expires %s", claims.Target(), claims.Expires().Local().Format("2006-01-02 15:04")))
+	if agentsFile == "" {
+		fmt.Println(token)
+		return nil
+	}
+	if err := os.WriteFile(agentsFile, []byte(token+"\n"), 0600); err != nil {
+		return fmt.Errorf("write credential: %w", err)
+	}
+	fmt.Println(i18n.T("  Written to %s; install it on the node as /etc/aami/agent-credential", agentsFile))
+	return nil
+}
+
+func runAgentsCertificate(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !hasNodeConfig(cfg, args[0]) {
+		return fmt.Errorf("unknown node: %s", args[0])
+	}
+	ttl, err := agentCertTTL(cfg)
+	if err != nil {
+		return err
+	}
+	if agentsTTL != "" {
+		if ttl, err = chatops.ParseDuration(agentsTTL); err != nil {
+			return err
+		}
+	}
+
+	ca := newAgentCA()
+	cert, err := ca.IssueClient(args[0], ttl)
+	if err != nil {
+		return err
+	}
+	caPEM, err := ca.CertificatePEM()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(agentsCertDir, 0755); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{"agent.crt", cert.Certificate, 0644},
+		{"agent.key", cert.PrivateKey, 0600},
+		{"ca.crt", caPEM, 0644},
+	} {
+		if err := os.WriteFile(filepath.Join(agentsCertDir, f.name), f.data, f.perm); err != nil {
+			return fmt.Errorf("write %s: %w", f.name, err)
+		}
+	}
+
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Issued certificate for %s, expires %s", args[0], cert.NotAfter.Local().Format("2006-01-02 15:04")))
+	fmt.Println(i18n.T("  Written to %s; install the files on the node in /etc/aami", agentsCertDir))
+	return nil
+}
+
+func runAgentsCA(cmd *cobra.Command, args []string) error {
+	caPEM, err := newAgentCA().CertificatePEM()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(caPEM)
+	return err
+}
+
+func runAgentsRevoke(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if _, err := newAgentAuthority().Revoke(args[0], currentUser(), agentsReason); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Revoked all credentials of %s", args[0]))
+	return nil
+}
+
+func runAgentsRevocations(cmd *cobra.Command, args []string) error {
+	revocations, err := newAgentAuthority().Revocations()
+	if err != nil {
+		return err
+	}
+	if len(revocations) == 0 {
+		fmt.Println(i18n.T("No revocations."))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Target"), i18n.T("Revoked"), i18n.T("Revoked By"), i18n.T("Reason")})
+	table.SetAutoWrapText(false)
+	for _, r := range revocations {
+		table.Append([]string{
+			r.Target,
+			r.RevokedAt.Local().Format("2006-01-02 15:04"),
+			r.RevokedBy,
+			r.Reason,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// writeAgentAuthError rejects an agent request with a bad credential. The
+// error code tells the agent whether renewing can help.
+func writeAgentAuthError(w http.ResponseWriter, err error) {
+	var code string
+	switch {
+	case errors.Is(err, agentauth.ErrMissing):
+		code = "CREDENTIAL_REQUIRED"
+	case errors.Is(err, agentauth.ErrCertificateRequired):
+		code = "CERTIFICATE_REQUIRED"
+	case errors.Is(err, agentauth.ErrInvalid):
+		code = "INVALID_CREDENTIAL"
+	case errors.Is(err, agentauth.ErrExpired):
+		code = "CREDENTIAL_EXPIRED"
+	case errors.Is(err, agentauth.ErrRevoked):
+		code = "CREDENTIAL_REVOKED"
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="aami-agent"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": err.Error()},
+	})
+}
@@ -0,0 +1,355 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/output"
+)
+
+var (
+	agentsConfigServerURL string
+	agentTaskChecks       []string
+	agentTaskExporter     string
+	agentTaskPriority     int
+	agentTaskTTL          string
+	agentTaskWait         bool
+	agentTaskTimeout      time.Duration
+	agentTaskStatus       string
+	agentTaskOutput       string
+)
+
+// agentTaskPollInterval is how often 'aami agents run --wait' checks on the
+// tasks it queued
+const agentTaskPollInterval = 2 * time.Second
+
+// agentTaskExporters are the exporters a restart-exporter task restarts,
+// as the agent knows them
+var agentTaskExporters = []string{"node_exporter", "dcgm-exporter", "all-smi"}
+
+var agentsRunCmd = &cobra.Command{
+	Use:   "run <type> <node>...",
+	Short: "Queue a task for the agents of nodes",
+	Long: `Queue a task for the agents of nodes on the config server. Agents fetch
+their tasks on the response to their heartbeat, every minute, so nodes
+never have to accept connections. The agent reports the result on its next heartbeat.
+
+Task types:
+  check-now         Run checks now regardless of schedule (--check, default: all)
+  restart-exporter  Restart an exporter: node_exporter, dcgm-exporter, all-smi (--exporter)
+  diagnostics       Collect a diagnostic bundle in /var/lib/aami/diagnostics
+  config-refresh    Refetch checks and reload scripts and schedules
+
+A task not delivered within --ttl expires. With --wait, the command follows
+the tasks until every agent reported its result, and fails if any did not
+succeed. 'aami agents tasks <node>' shows the tasks of a node later.
+
+Examples:
+  aami agents run check-now gpu-node-01 --check gpu --check disk
+  aami agents run restart-exporter gpu-node-01 gpu-node-02 --exporter dcgm-exporter --wait
+  aami agents run diagnostics gpu-node-01 --priority 10 --ttl 1h`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeTaskTypeThenTargets,
+	RunE:              runAgentsRun,
+}
+
+var agentsTasksCmd = &cobra.Command{
+	Use:   "tasks <node>",
+	Short: "List the tasks of a node",
+	Long: `List the tasks queued for the agent of a node, newest first, with their
+status: pending until a heartbeat delivers them, delivered until the agent
+reports a result, then succeeded, failed, expired or canceled.
+
+Examples:
+  aami agents tasks gpu-node-01
+  aami agents tasks gpu-node-01 --status failed -o wide`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runAgentsTasks,
+}
+
+var agentsCancelCmd = &cobra.Command{
+	Use:   "cancel <node> <task-id>",
+	Short: "Cancel a pending task",
+	Long: `Cancel a task that no heartbeat has delivered yet. A delivered task is
+already running on the node and cannot be canceled.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runAgentsCancel,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{agentsRunCmd, agentsTasksCmd, agentsCancelCmd} {
+		cmd.Flags().StringVar(&agentsConfigServerURL, "config-server-url", "",
+			"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	}
+	agentsRunCmd.Flags().StringArrayVar(&agentTaskChecks, "check", nil,
+		"Check to run with check-now (repeatable; default: all)")
+	agentsRunCmd.Flags().StringVar(&agentTaskExporter, "exporter", "",
+		"Exporter to restart with restart-exporter")
+	agentsRunCmd.Flags().IntVar(&agentTaskPriority, "priority", 0,
+		"Priority of the task; agents run higher priorities first")
+	agentsRunCmd.Flags().StringVar(&agentTaskTTL, "ttl", "10m",
+		"Expire the task if no heartbeat delivers it within this time (0: never)")
+	agentsRunCmd.Flags().BoolVar(&agentTaskWait, "wait", false,
+		"Wait until every agent reported the result of its task")
+	agentsRunCmd.Flags().DurationVar(&agentTaskTimeout, "timeout", 5*time.Minute,
+		"How long --wait waits")
+	agentsRunCmd.RegisterFlagCompletionFunc("exporter", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return agentTaskExporters, cobra.ShellCompDirectiveNoFileComp
+	})
+	agentsTasksCmd.Flags().StringVar(&agentTaskStatus, "status", "",
+		"Only tasks with this status (pending, delivered, succeeded, failed, expired, canceled)")
+	addOutputFlag(agentsTasksCmd, &agentTaskOutput)
+
+	agentsCmd.AddCommand(agentsRunCmd)
+	agentsCmd.AddCommand(agentsTasksCmd)
+	agentsCmd.AddCommand(agentsCancelCmd)
+}
+
+// completeTaskTypeThenTargets completes a task type and then targets
+func completeTaskTypeThenTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return configserver.TaskTypes(), cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeTargets(cmd, args, toComplete)
+}
+
+// agentTaskRequest builds the request of a task type from the flags
+func agentTaskRequest(taskType string) (configserver.AgentTaskRequest, error) {
+	req := configserver.AgentTaskRequest{Type: taskType, Priority: agentTaskPriority}
+
+	switch taskType {
+	case configserver.TaskCheckNow:
+		if len(agentTaskChecks) > 0 {
+			req.Args = map[string]interface{}{"checks": agentTaskChecks}
+		}
+	case configserver.TaskRestartExporter:
+		if agentTaskExporter == "" {
+			return req, fmt.Errorf("restart-exporter needs --exporter")
+		}
+		if !slices.Contains(agentTaskExporters, agentTaskExporter) {
+			return req, fmt.Errorf("unknown exporter %q (valid: %s)", agentTaskExporter, strings.Join(agentTaskExporters, ", "))
+		}
+		req.Args = map[string]interface{}{"exporter": agentTaskExporter}
+	case configserver.TaskDiagnostics, configserver.TaskConfigRefresh:
+	case configserver.TaskGPUValidate:
+		return req, fmt.Errorf("use 'aami gpu validate' to run the GPU validation suite")
+	default:
+		return req, fmt.Errorf("unknown task type %q (valid: %s)", taskType, strings.Join(configserver.TaskTypes(), ", "))
+	}
+	if len(agentTaskChecks) > 0 && taskType != configserver.TaskCheckNow {
+		return req, fmt.Errorf("--check only applies to check-now")
+	}
+	if agentTaskExporter != "" && taskType != configserver.TaskRestartExporter {
+		return req, fmt.Errorf("--exporter only applies to restart-exporter")
+	}
+
+	if agentTaskTTL != "" && agentTaskTTL != "0" {
+		ttl, err := chatops.ParseDuration(agentTaskTTL)
+		if err != nil {
+			return req, err
+		}
+		req.TTL = int(ttl.Seconds())
+	}
+	return req, nil
+}
+
+func runAgentsRun(cmd *cobra.Command, args []string) error {
+	req, err := agentTaskRequest(args[0])
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(agentsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	var queued []*configserver.AgentTask
+	var failed int
+	for _, ref := range args[1:] {
+		target, err := client.FindTarget(ctx, ref)
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", color.RedString("✗"), ref, err)
+			failed++
+			continue
+		}
+		task, err := client.QueueAgentTask(ctx, target.Hostname, req)
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", color.RedString("✗"), target.Hostname, err)
+			failed++
+			continue
+		}
+		task.Hostname = target.Hostname
+		fmt.Printf("%s Queued %s on %s (task %s)\n", green("✓"), task.Type, target.Hostname, task.ID)
+		queued = append(queued, task)
+	}
+
+	if agentTaskWait && len(queued) > 0 {
+		cancel()
+		failed += waitAgentTasks(client, queued, agentTaskTimeout)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d node(s) failed", failed, len(args)-1)
+	}
+	return nil
+}
+
+// waitAgentTasks polls the tasks until they are done or the timeout passes,
+// printing each result, and returns how many did not succeed
+func waitAgentTasks(client *configserver.Client, tasks []*configserver.AgentTask, timeout time.Duration) int {
+	fmt.Printf("Waiting for results (up to %s)...\n", timeout)
+	deadline := time.Now().Add(timeout)
+	pending := tasks
+	failed := 0
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(agentTaskPollInterval)
+		var still []*configserver.AgentTask
+		for _, t := range pending {
+			ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+			task, err := client.GetAgentTask(ctx, t.Hostname, t.ID)
+			cancel()
+			if err != nil || !task.Done() {
+				still = append(still, t)
+				continue
+			}
+			mark := color.GreenString("✓")
+			if task.Status != configserver.TaskSucceeded {
+				mark = color.RedString("✗")
+				failed++
+			}
+			fmt.Printf("%s %s: %s %s\n", mark, task.Hostname, colorTaskStatus(task.Status), task.Message)
+		}
+		pending = still
+	}
+	for _, t := range pending {
+		fmt.Printf("%s %s: no result within %s (task %s)\n", color.YellowString("⚠"), t.Hostname, timeout, t.ID)
+		failed++
+	}
+	return failed
+}
+
+func runAgentsTasks(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(agentTaskOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(agentsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	target, err := client.FindTarget(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	tasks, err := client.ListAgentTasks(ctx, target.Hostname, agentTaskStatus)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, tasks)
+	}
+	if len(tasks) == 0 {
+		fmt.Printf("No tasks for %s.\n", target.Hostname)
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "ID"},
+		{Header: "Type"},
+		{Header: "Status"},
+		{Header: "Created"},
+		{Header: "Finished"},
+		{Header: "Message"},
+		{Header: "Priority", Wide: true},
+		{Header: "Args", Wide: true},
+		{Header: "Requested By", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, t := range tasks {
+		finished := "-"
+		if t.FinishedAt != nil {
+			finished = t.FinishedAt.Local().Format("01-02 15:04:05")
+		}
+		table.Append(columns.Row(format,
+			t.ID,
+			t.Type,
+			colorTaskStatus(t.Status),
+			t.CreatedAt.Local().Format("01-02 15:04:05"),
+			finished,
+			defaultString(t.Message, "-"),
+			fmt.Sprint(t.Priority),
+			formatTaskArgs(t.Args),
+			defaultString(t.RequestedBy, "-"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runAgentsCancel(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel, err := configServerRequest(agentsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	target, err := client.FindTarget(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	task, err := client.CancelAgentTask(ctx, target.Hostname, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s Canceled %s on %s (task %s)\n", color.GreenString("✓"), task.Type, task.Hostname, task.ID)
+	return nil
+}
+
+// formatTaskArgs formats the arguments of a task as key=value pairs
+func formatTaskArgs(args map[string]interface{}) string {
+	if len(args) == 0 {
+		return "-"
+	}
+	pairs := make([]string, 0, len(args))
+	for k, v := range args {
+		if list, ok := v.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			v = strings.Join(items, ",")
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// colorTaskStatus colors an agent task status
+func colorTaskStatus(status string) string {
+	switch status {
+	case configserver.TaskSucceeded:
+		return color.GreenString(status)
+	case configserver.TaskFailed, configserver.TaskExpired:
+		return color.RedString(status)
+	default:
+		return color.YellowString(status)
+	}
+}
@@ -0,0 +1,659 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/output"
+)
+
+var (
+	alertRulesConfigServerURL string
+	alertRulesOutput          string
+
+	alertRulesGroup       string
+	alertRulesTemplate    string
+	alertRulesSet         []string
+	alertRulesUnset       []string
+	alertRulesName        string
+	alertRulesDescription string
+	alertRulesSeverity    string
+	alertRulesQuery       string
+	alertRulesPriority    int
+	alertRulesDisabled    bool
+)
+
+var alertRulesCmd = &cobra.Command{
+	Use:   "alert-rules",
+	Short: "Manage the config server's alert rules",
+	Long: `Manage the alert rules of the config server: rules assigned to a group,
+made from an alert template with its settings, or written directly as a
+query.
+
+The config server and token come from the active context (see aami
+context), else slurm.prolog.config_server_url or config_server_url of
+/etc/aami/agent.yaml, with admin.token.
+
+Examples:
+  aami alert-rules list --group gpu-servers
+  aami alert-rules templates high-cpu
+  aami alert-rules create --from-template high-cpu --group gpu-servers --set threshold=90
+  aami alert-rules create --group gpu-servers --name NodeDown --severity critical --query 'up == 0'
+  aami alert-rules update <id> --set threshold=95 --priority 50
+  aami alert-rules disable <id>
+  aami alert-rules delete <id>
+  aami alert-rules test -f tests.yaml`,
+}
+
+var alertRulesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List alert rules",
+	Args:  cobra.NoArgs,
+	RunE:  runAlertRulesList,
+}
+
+var alertRulesGetCmd = &cobra.Command{
+	Use:               "get <id>",
+	Short:             "Show an alert rule",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeAlertRules),
+	RunE:              runAlertRulesGet,
+}
+
+var alertRulesCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an alert rule",
+	Long: `Create an alert rule in a group, from an alert template (--from-template,
+with its settings given as --set key=value) or directly (--name,
+--severity and --query).
+
+Settings are checked against the template's variables: their type, range
+and allowed values. Required variables without a value are asked for on a
+terminal. Values of --set are read as the variable's type; settings of
+templates without variables are JSON when they parse as JSON (numbers,
+booleans, lists) and strings otherwise.
+
+Examples:
+  aami alert-rules create --from-template high-cpu --group gpu-servers --set threshold=90
+  aami alert-rules create --group gpu-servers --name NodeDown --severity critical \
+    --query 'up == 0' --description 'Node exporter is down'`,
+	Args: cobra.NoArgs,
+	RunE: runAlertRulesCreate,
+}
+
+var alertRulesUpdateCmd = &cobra.Command{
+	Use:   "update <id>",
+	Short: "Change an alert rule",
+	Long: `Change the fields given of an alert rule; the others keep their values.
+--set adds or changes template settings and --unset removes them; the
+settings are checked against the template's variables.
+
+Examples:
+  aami alert-rules update <id> --set threshold=95
+  aami alert-rules update <id> --severity warning --priority 50
+  aami alert-rules update <id> --group cpu-servers`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeAlertRules),
+	RunE:              runAlertRulesUpdate,
+}
+
+var alertRulesDeleteCmd = &cobra.Command{
+	Use:               "delete <id>...",
+	Short:             "Delete alert rules",
+	Long:              `Delete alert rules. The config server keeps deleted rules until they are purged, so they can be restored there.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeAlertRules,
+	RunE:              runAlertRulesDelete,
+}
+
+var alertRulesEnableCmd = &cobra.Command{
+	Use:               "enable <id>...",
+	Short:             "Enable alert rules",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeAlertRules,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setAlertRulesEnabled(args, true)
+	},
+}
+
+var alertRulesDisableCmd = &cobra.Command{
+	Use:               "disable <id>...",
+	Short:             "Disable alert rules",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeAlertRules,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setAlertRulesEnabled(args, false)
+	},
+}
+
+func init() {
+	alertRulesCmd.PersistentFlags().StringVar(&alertRulesConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+
+	alertRulesListCmd.Flags().StringVar(&alertRulesGroup, "group", "", "Only rules of this group (ID or name)")
+	alertRulesListCmd.Flags().StringVar(&alertRulesTemplate, "template", "", "Only rules made from this template")
+	alertRulesListCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	addOutputFlag(alertRulesListCmd, &alertRulesOutput)
+	addOutputFlag(alertRulesGetCmd, &alertRulesOutput)
+
+	for _, c := range []*cobra.Command{alertRulesCreateCmd, alertRulesUpdateCmd} {
+		c.Flags().StringVar(&alertRulesGroup, "group", "", "Group of the rule (ID or name)")
+		c.Flags().StringArrayVar(&alertRulesSet, "set", nil, "Template setting as key=value (repeatable)")
+		c.Flags().StringVar(&alertRulesName, "name", "", "Alert name")
+		c.Flags().StringVar(&alertRulesDescription, "description", "", "Description")
+		c.Flags().StringVar(&alertRulesSeverity, "severity", "", "Severity (critical, warning, info)")
+		c.Flags().StringVar(&alertRulesQuery, "query", "", "PromQL query template")
+		c.Flags().IntVar(&alertRulesPriority, "priority", 0, "Priority; lower is applied first")
+		c.RegisterFlagCompletionFunc("group", completeGroups)
+		c.RegisterFlagCompletionFunc("severity", cobra.FixedCompletions(
+			[]string{"critical", "warning", "info"}, cobra.ShellCompDirectiveNoFileComp))
+		addOutputFlag(c, &alertRulesOutput)
+	}
+	alertRulesCreateCmd.Flags().StringVar(&alertRulesTemplate, "from-template", "", "Alert template to make the rule from")
+	alertRulesCreateCmd.Flags().BoolVar(&alertRulesDisabled, "disabled", false, "Create the rule disabled")
+	alertRulesCreateCmd.MarkFlagRequired("group")
+	alertRulesUpdateCmd.Flags().StringArrayVar(&alertRulesUnset, "unset", nil, "Template setting to remove (repeatable)")
+
+	alertRulesCmd.AddCommand(alertRulesListCmd)
+	alertRulesCmd.AddCommand(alertRulesGetCmd)
+	alertRulesCmd.AddCommand(alertRulesCreateCmd)
+	alertRulesCmd.AddCommand(alertRulesUpdateCmd)
+	alertRulesCmd.AddCommand(alertRulesDeleteCmd)
+	alertRulesCmd.AddCommand(alertRulesEnableCmd)
+	alertRulesCmd.AddCommand(alertRulesDisableCmd)
+	rootCmd.AddCommand(alertRulesCmd)
+}
+
+// alertRulesClient returns a client of the config server and a context
+// bounding the command's requests
+func alertRulesClient() (*configserver.Client, context.Context, context.CancelFunc, error) {
+	return configServerRequest(alertRulesConfigServerURL)
+}
+
+// groupNames maps the IDs of the config server's groups to their names.
+// Rules are still listed, by group ID, if the groups cannot be read.
+func groupNames(ctx context.Context, client *configserver.Client) map[string]string {
+	names := make(map[string]string)
+	groups, err := client.ListGroups(ctx)
+	if err != nil {
+		return names
+	}
+	for _, g := range groups {
+		names[g.ID] = g.Name
+	}
+	return names
+}
+
+func runAlertRulesList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertRulesOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var rules []configserver.AlertRule
+	switch {
+	case alertRulesGroup != "":
+		group, err := client.FindGroup(ctx, alertRulesGroup)
+		if err != nil {
+			return err
+		}
+		rules, err = client.ListAlertRulesByGroup(ctx, group.ID)
+		if err != nil {
+			return err
+		}
+	case alertRulesTemplate != "":
+		rules, err = client.ListAlertRulesByTemplate(ctx, alertRulesTemplate)
+	default:
+		rules, err = client.ListAlertRules(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if alertRulesGroup != "" && alertRulesTemplate != "" {
+		filtered := rules[:0]
+		for _, r := range rules {
+			if r.TemplateID == alertRulesTemplate {
+				filtered = append(filtered, r)
+			}
+		}
+		rules = filtered
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].Name < rules[j].Name
+	})
+
+	if format.Structured() {
+		if rules == nil {
+			rules = []configserver.AlertRule{}
+		}
+		return writeOutput(format, rules)
+	}
+	if len(rules) == 0 {
+		fmt.Println("No alert rules.")
+		return nil
+	}
+
+	groups := groupNames(ctx, client)
+	columns := output.Columns{
+		{Header: "ID"},
+		{Header: "Name"},
+		{Header: "Group"},
+		{Header: "Severity"},
+		{Header: "Enabled"},
+		{Header: "Priority"},
+		{Header: "Template", Wide: true},
+		{Header: "Config", Wide: true},
+		{Header: "Updated", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, r := range rules {
+		table.Append(columns.Row(format,
+			r.ID,
+			r.Name,
+			defaultString(groups[r.GroupID], r.GroupID),
+			colorSeverity(r.Severity),
+			colorEnabled(r.Enabled),
+			strconv.Itoa(r.Priority),
+			defaultString(r.TemplateID, "-"),
+			defaultString(formatRuleConfig(r.Config), "-"),
+			r.UpdatedAt.Local().Format("2006-01-02 15:04"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runAlertRulesGet(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertRulesOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rule, err := client.GetAlertRule(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, rule)
+	}
+	printAlertRule(rule, groupNames(ctx, client))
+	return nil
+}
+
+// printAlertRule prints the fields of a rule
+func printAlertRule(r *configserver.AlertRule, groups map[string]string) {
+	bold := color.New(color.Bold).SprintFunc()
+
+	fmt.Printf("\n%s %s\n", bold(r.Name), r.ID)
+	if name := groups[r.GroupID]; name != "" {
+		fmt.Printf("  Group:       %s (%s)\n", name, r.GroupID)
+	} else {
+		fmt.Printf("  Group:       %s\n", r.GroupID)
+	}
+	fmt.Printf("  Severity:    %s\n", colorSeverity(r.Severity))
+	fmt.Printf("  Enabled:     %s\n", colorEnabled(r.Enabled))
+	fmt.Printf("  Priority:    %d\n", r.Priority)
+	if r.TemplateID != "" {
+		fmt.Printf("  Template:    %s\n", r.TemplateID)
+	}
+	if config := formatRuleConfig(r.Config); config != "" {
+		fmt.Printf("  Config:      %s\n", config)
+	}
+	if r.Description != "" {
+		fmt.Printf("  Description: %s\n", r.Description)
+	}
+	if r.QueryTemplate != "" {
+		fmt.Printf("  Query:       %s\n", r.QueryTemplate)
+	}
+	fmt.Printf("  Updated:     %s\n", r.UpdatedAt.Local().Format(time.RFC3339))
+	fmt.Println()
+}
+
+func runAlertRulesCreate(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertRulesOutput)
+	if err != nil {
+		return err
+	}
+	if alertRulesTemplate == "" && (alertRulesName == "" || alertRulesSeverity == "" || alertRulesQuery == "") {
+		return fmt.Errorf("give --from-template, or --name, --severity and --query")
+	}
+	if len(alertRulesSet) > 0 && alertRulesTemplate == "" {
+		return fmt.Errorf("--set configures a template; use it with --from-template")
+	}
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var settings map[string]interface{}
+	if alertRulesTemplate != "" {
+		t, err := client.GetAlertTemplate(ctx, alertRulesTemplate)
+		if err != nil {
+			return fmt.Errorf("alert template %s: %w", alertRulesTemplate, err)
+		}
+		if settings, err = parseRuleSettings(alertRulesSet, t); err != nil {
+			return err
+		}
+		settings = promptRuleSettings(t, settings)
+		if err := t.ValidateConfig(settings); err != nil {
+			return err
+		}
+	}
+
+	group, err := client.FindGroup(ctx, alertRulesGroup)
+	if err != nil {
+		return err
+	}
+	rule, err := client.CreateAlertRule(ctx, configserver.AlertRuleRequest{
+		GroupID:       group.ID,
+		TemplateID:    alertRulesTemplate,
+		Name:          alertRulesName,
+		Description:   alertRulesDescription,
+		Severity:      alertRulesSeverity,
+		QueryTemplate: alertRulesQuery,
+		Enabled:       !alertRulesDisabled,
+		Config:        settings,
+		Priority:      alertRulesPriority,
+	})
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, rule)
+	}
+	fmt.Printf("%s Alert rule %s created in group %s (%s)\n",
+		color.GreenString("✓"), rule.Name, group.Name, rule.ID)
+	return nil
+}
+
+func runAlertRulesUpdate(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertRulesOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	rule, err := client.GetAlertRule(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	req := rule.Request()
+
+	// Settings are checked against the variables of the rule's template
+	var template *configserver.AlertTemplate
+	if rule.TemplateID != "" && (len(alertRulesSet) > 0 || len(alertRulesUnset) > 0) {
+		if template, err = client.GetAlertTemplate(ctx, rule.TemplateID); err != nil {
+			return fmt.Errorf("alert template %s: %w", rule.TemplateID, err)
+		}
+	}
+	settings, err := parseRuleSettings(alertRulesSet, template)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	changed := false
+	if flags.Changed("group") {
+		group, err := client.FindGroup(ctx, alertRulesGroup)
+		if err != nil {
+			return err
+		}
+		req.GroupID = group.ID
+		changed = true
+	}
+	if flags.Changed("name") {
+		req.Name, changed = alertRulesName, true
+	}
+	if flags.Changed("description") {
+		req.Description, changed = alertRulesDescription, true
+	}
+	if flags.Changed("severity") {
+		req.Severity, changed = alertRulesSeverity, true
+	}
+	if flags.Changed("query") {
+		req.QueryTemplate, changed = alertRulesQuery, true
+	}
+	if flags.Changed("priority") {
+		req.Priority, changed = alertRulesPriority, true
+	}
+	if len(settings) > 0 || len(alertRulesUnset) > 0 {
+		config := make(map[string]interface{}, len(req.Config)+len(settings))
+		for k, v := range req.Config {
+			config[k] = v
+		}
+		for k, v := range settings {
+			config[k] = v
+		}
+		for _, k := range alertRulesUnset {
+			delete(config, k)
+		}
+		if template != nil {
+			if err := template.ValidateConfig(config); err != nil {
+				return err
+			}
+		}
+		req.Config, changed = config, true
+	}
+	if !changed {
+		return fmt.Errorf("nothing to change: give --set, --unset or a field flag")
+	}
+
+	updated, err := client.UpdateAlertRule(ctx, rule.ID, req)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, updated)
+	}
+	fmt.Printf("%s Alert rule %s updated\n", color.GreenString("✓"), updated.Name)
+	return nil
+}
+
+func runAlertRulesDelete(cmd *cobra.Command, args []string) error {
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	failed := 0
+	for _, id := range args {
+		if err := client.DeleteAlertRule(ctx, id); err != nil {
+			fmt.Printf("%s %s: %v\n", red("✗"), id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s Alert rule %s deleted\n", green("✓"), id)
+	}
+	return countError(failed, len(args), "alert rules")
+}
+
+// setAlertRulesEnabled enables or disables rules, leaving the other fields
+// as they are
+func setAlertRulesEnabled(ids []string, enabled bool) error {
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	action := "disabled"
+	if enabled {
+		action = "enabled"
+	}
+	failed := 0
+	for _, id := range ids {
+		rule, err := client.GetAlertRule(ctx, id)
+		if err == nil && rule.Enabled != enabled {
+			req := rule.Request()
+			req.Enabled = enabled
+			rule, err = client.UpdateAlertRule(ctx, id, req)
+		}
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", red("✗"), id, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s Alert rule %s %s\n", green("✓"), rule.Name, action)
+	}
+	return countError(failed, len(ids), "alert rules")
+}
+
+// parseRuleSettings parses key=value template settings. Values of the
+// template's variables are read as their type; others are JSON if they
+// parse as JSON, else strings, so threshold=90 is a number.
+func parseRuleSettings(pairs []string, t *configserver.AlertTemplate) (map[string]interface{}, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	settings := make(map[string]interface{}, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid setting %q: expected key=value", pair)
+		}
+		if t != nil {
+			if variable, ok := t.Variable(key); ok {
+				v, err := variable.Parse(value)
+				if err != nil {
+					return nil, err
+				}
+				settings[key] = v
+				continue
+			}
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			v = value
+		}
+		settings[key] = v
+	}
+	return settings, nil
+}
+
+// promptRuleSettings asks on a terminal for the required variables of a
+// template that have no value yet, until each answer fits
+func promptRuleSettings(t *configserver.AlertTemplate, settings map[string]interface{}) map[string]interface{} {
+	missing := t.Missing(settings)
+	if len(missing) == 0 || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return settings
+	}
+	if settings == nil {
+		settings = make(map[string]interface{}, len(missing))
+	}
+	p := newPrompter(true)
+	for _, v := range missing {
+		question := fmt.Sprintf("%s (%s)", v.Name, variableHint(v))
+		if v.Description != "" {
+			question = fmt.Sprintf("%s, %s", question, v.Description)
+		}
+		for {
+			answer := p.ask(question, "")
+			if answer == "" {
+				break
+			}
+			value, err := v.Parse(answer)
+			if err == nil {
+				err = v.Check(value)
+			}
+			if err == nil {
+				settings[v.Name] = value
+				break
+			}
+			fmt.Printf("  %s\n", err)
+		}
+	}
+	return settings
+}
+
+// variableHint describes the values a template variable takes
+func variableHint(v configserver.TemplateVariable) string {
+	hint := defaultString(v.Type, "any")
+	switch {
+	case len(v.Enum) > 0:
+		hint += ": " + v.EnumText()
+	case v.Min != nil && v.Max != nil:
+		hint += fmt.Sprintf(", %v-%v", *v.Min, *v.Max)
+	case v.Min != nil:
+		hint += fmt.Sprintf(", >= %v", *v.Min)
+	case v.Max != nil:
+		hint += fmt.Sprintf(", <= %v", *v.Max)
+	}
+	return hint
+}
+
+// formatRuleConfig formats template settings as sorted key=value pairs
+func formatRuleConfig(config map[string]interface{}) string {
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value, ok := config[k].(string)
+		if !ok {
+			data, _ := json.Marshal(config[k])
+			value = string(data)
+		}
+		pairs = append(pairs, k+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// colorSeverity colors a rule severity
+func colorSeverity(severity string) string {
+	switch severity {
+	case "critical":
+		return color.RedString(severity)
+	case "warning":
+		return color.YellowString(severity)
+	default:
+		return severity
+	}
+}
+
+// colorEnabled colors whether a rule is enabled
+func colorEnabled(enabled bool) string {
+	if enabled {
+		return color.GreenString("yes")
+	}
+	return color.YellowString("no")
+}
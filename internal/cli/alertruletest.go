@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/prometheus"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	alertRulesTestFile  string
+	alertRulesTestPrint bool
+)
+
+var alertRulesTestCmd = &cobra.Command{
+	Use:   "test [<id>]",
+	Short: "Run promtool unit tests against alert rules",
+	Long: `Run 'promtool test rules' against alert rules of the config server, with
+synthetic series and the alerts they must (or must not) raise. Each rule's
+query is rendered from its template and settings, as the config server
+does; config overrides settings, to test a change before making it.
+
+Tests are read from -f, one entry per rule (ID or name), each holding test
+cases in promtool's format. alertname defaults to the rule's name, and
+annotations are not checked:
+
+  rules:
+    - rule: high-cpu-gpu
+      config:
+        threshold: 95
+      tests:
+        - interval: 1m
+          input_series:
+            - series: 'node_cpu_seconds_total{mode="idle", instance="gpu-01:9100"}'
+              values: '0+3x10'
+          alert_rule_test:
+            - eval_time: 10m
+              exp_alerts:
+                - exp_labels: {severity: warning, instance: gpu-01:9100}
+
+With an ID or name, only the tests of that rule run. The command fails if
+a test fails or promtool is not installed, so it can gate rule changes in
+CI. --print shows the rule and test files instead of running them.
+
+The same tests run on POST /api/v1/alert-rules/<id>/test, served by
+'aami alerts test --listen' to admin.token and operators.
+
+Examples:
+  aami alert-rules test -f tests.yaml
+  aami alert-rules test high-cpu-gpu -f tests.yaml
+  aami alert-rules test -f tests.yaml --print`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: firstArg(completeAlertRules),
+	RunE:              runAlertRulesTest,
+}
+
+func init() {
+	alertRulesTestCmd.Flags().StringVarP(&alertRulesTestFile, "file", "f", "", "File of rule tests (required)")
+	alertRulesTestCmd.Flags().BoolVar(&alertRulesTestPrint, "print", false,
+		"Print the generated rule and test files instead of running them")
+	alertRulesTestCmd.MarkFlagRequired("file")
+
+	alertRulesCmd.AddCommand(alertRulesTestCmd)
+}
+
+// alertRuleTestFile is a file of alert rule tests
+type alertRuleTestFile struct {
+	Rules []alertRuleTest `yaml:"rules"`
+}
+
+// alertRuleTest holds the promtool test cases of an alert rule, and
+// settings to test in place of the rule's
+type alertRuleTest struct {
+	Rule   string                    `yaml:"rule" json:"rule,omitempty"`
+	Config map[string]interface{}    `yaml:"config,omitempty" json:"config,omitempty"`
+	Tests  []prometheus.RuleTestCase `yaml:"tests" json:"tests"`
+}
+
+// alertRuleTestResult is the outcome of the tests of an alert rule
+type alertRuleTestResult struct {
+	Rule     string                `json:"rule"`
+	Alert    string                `json:"alert"`
+	Expr     string                `json:"expr"`
+	RuleFile string                `json:"rule_file"`
+	TestFile string                `json:"test_file"`
+	Result   *prometheus.RuleCheck `json:"result,omitempty"`
+}
+
+// alertRuleTestsFile is the rule file the tests of a rule run against
+const alertRuleTestsFile = "alert-rule.yaml"
+
+// alertRuleExpr renders the query of a rule with its settings: the
+// template's defaults, then the rule's, then overrides
+func alertRuleExpr(ctx context.Context, client *configserver.Client, rule *configserver.AlertRule, overrides map[string]interface{}) (string, error) {
+	query := rule.QueryTemplate
+	settings := map[string]interface{}{}
+	if rule.TemplateID != "" {
+		t, err := client.GetAlertTemplate(ctx, rule.TemplateID)
+		if err != nil {
+			return "", fmt.Errorf("alert template %s: %w", rule.TemplateID, err)
+		}
+		query = defaultString(t.QueryTemplate, query)
+		config := map[string]interface{}{}
+		for k, v := range rule.Config {
+			config[k] = v
+		}
+		for k, v := range overrides {
+			config[k] = v
+		}
+		if err := t.ValidateConfig(config); err != nil {
+			return "", err
+		}
+		for k, v := range t.DefaultConfig {
+			settings[k] = v
+		}
+	}
+	for k, v := range rule.Config {
+		settings[k] = v
+	}
+	for k, v := range overrides {
+		settings[k] = v
+	}
+	if strings.TrimSpace(query) == "" {
+		return "", fmt.Errorf("alert rule %s has no query", rule.Name)
+	}
+
+	tmpl, err := template.New(rule.Name).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("parse query of %s: %w", rule.Name, err)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, settings); err != nil {
+		return "", fmt.Errorf("render query of %s: %w", rule.Name, err)
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// alertRuleFile renders a rule file holding the rule alone. Annotations
+// are left out: tests check whether, and with which labels, a rule fires.
+func alertRuleFile(rule *configserver.AlertRule, expr string) ([]byte, error) {
+	type promRule struct {
+		Alert  string            `yaml:"alert"`
+		Expr   string            `yaml:"expr"`
+		Labels map[string]string `yaml:"labels"`
+	}
+	type promGroup struct {
+		Name  string     `yaml:"name"`
+		Rules []promRule `yaml:"rules"`
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(struct {
+		Groups []promGroup `yaml:"groups"`
+	}{[]promGroup{{
+		Name:  "alert-rule-test",
+		Rules: []promRule{{Alert: rule.Name, Expr: expr, Labels: map[string]string{"severity": rule.Severity}}},
+	}}})
+	if err != nil {
+		return nil, fmt.Errorf("marshal rule file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// testAlertRule renders a rule and its test file and, unless dryRun, runs
+// the tests with promtool
+func testAlertRule(ctx context.Context, client *configserver.Client, rule *configserver.AlertRule, t alertRuleTest, dryRun bool) (*alertRuleTestResult, error) {
+	if len(t.Tests) == 0 {
+		return nil, fmt.Errorf("no tests for alert rule %s", rule.Name)
+	}
+	expr, err := alertRuleExpr(ctx, client, rule, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := alertRuleFile(rule, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	cases := make([]prometheus.RuleTestCase, len(t.Tests))
+	for i, c := range t.Tests {
+		if c.Interval == "" {
+			c.Interval = "1m"
+		}
+		alerts := make([]prometheus.AlertTest, len(c.Alerts))
+		for j, a := range c.Alerts {
+			a.Alertname = defaultString(a.Alertname, rule.Name)
+			alerts[j] = a
+		}
+		c.Alerts = alerts
+		cases[i] = c
+	}
+	tests, err := prometheus.RenderRuleTests(alertRuleTestsFile, cases)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &alertRuleTestResult{
+		Rule:     rule.ID,
+		Alert:    rule.Name,
+		Expr:     expr,
+		RuleFile: string(rules),
+		TestFile: string(tests),
+	}
+	if dryRun {
+		return result, nil
+	}
+	result.Result, err = prometheus.TestRules(ctx, alertRuleTestsFile, rules,
+		map[string][]byte{"alert-rule.test.yaml": tests})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// alertRuleTestHandler serves POST /alert-rules/<id>/test: the request
+// holds the test cases, and optionally settings, of the rule. Rules are
+// read from the config server of the config, as they are at the time.
+// Tests run with the config server's credentials, so only admin.token and
+// operators may run them.
+func alertRuleTestHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/alert-rules/"), "/test")
+		if !ok || id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t, err := tenant.Authenticate(cfg, r, cfg.Admin.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if t.Scoped() {
+			http.Error(w, fmt.Sprintf("%s cannot run alert rule tests", t.Name), http.StatusForbidden)
+			return
+		}
+
+		var req alertRuleTest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(req.Tests) == 0 {
+			http.Error(w, "tests are required", http.StatusBadRequest)
+			return
+		}
+
+		client, err := configServerClient(cfg, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), configServerTimeout)
+		defer cancel()
+
+		rule, err := client.FindAlertRule(ctx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		result, err := testAlertRule(ctx, client, rule, req, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func runAlertRulesTest(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(alertRulesTestFile)
+	if err != nil {
+		return fmt.Errorf("read rule tests: %w", err)
+	}
+	var file alertRuleTestFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse rule tests %s: %w", alertRulesTestFile, err)
+	}
+
+	var selected []alertRuleTest
+	for _, t := range file.Rules {
+		if t.Rule == "" {
+			return fmt.Errorf("%s: a test entry has no rule", alertRulesTestFile)
+		}
+		if len(args) == 0 || t.Rule == args[0] {
+			selected = append(selected, t)
+		}
+	}
+	if len(selected) == 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("no tests for alert rule %s in %s", args[0], alertRulesTestFile)
+		}
+		return fmt.Errorf("no tests in %s", alertRulesTestFile)
+	}
+
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	failed := 0
+	for _, t := range selected {
+		rule, err := client.FindAlertRule(ctx, t.Rule)
+		if err != nil {
+			return err
+		}
+		result, err := testAlertRule(ctx, client, rule, t, alertRulesTestPrint)
+		if err != nil {
+			return err
+		}
+		if alertRulesTestPrint {
+			fmt.Printf("# %s: %s\n%s", result.Alert, alertRuleTestsFile, result.RuleFile)
+			fmt.Printf("# %s: alert-rule.test.yaml\n%s", result.Alert, result.TestFile)
+			continue
+		}
+		switch {
+		case result.Result.Skipped:
+			return fmt.Errorf("cannot run rule tests: %s", result.Result.Output)
+		case result.Result.Valid:
+			fmt.Printf("%s %s (%s): %d test case(s) passed\n", green("✓"), result.Alert, result.Rule, len(t.Tests))
+		default:
+			failed++
+			fmt.Printf("%s %s (%s): tests failed\n", red("✗"), result.Alert, result.Rule)
+			fmt.Print(result.Result.Output)
+		}
+	}
+	if alertRulesTestPrint {
+		return nil
+	}
+	return countError(failed, len(selected), "alert rules")
+}
@@ -1,14 +1,25 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/gpuevents"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/prometheus"
 )
 
 var alertsCmd = &cobra.Command{
@@ -31,9 +42,14 @@ var alertsApplyPresetCmd = &cobra.Command{
 Available presets:
   gpu-basic       Basic GPU monitoring (3 rules)
   gpu-production  Comprehensive GPU monitoring (8 rules)
+  fabric          InfiniBand and NVLink link health (6 rules)
+
+Use --namespace to write the rules into a per-team subdirectory of
+/etc/aami/rules, owned according to alerts.namespaces in the config.
 
 Examples:
-  aami alerts apply-preset gpu-production`,
+  aami alerts apply-preset gpu-production
+  aami alerts apply-preset gpu-basic --namespace team-a`,
 	Args: cobra.ExactArgs(1),
 	RunE: runAlertsApplyPreset,
 }
@@ -41,9 +57,61 @@ Examples:
 var alertsListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List active alert rules",
-	RunE:  runAlertsList,
+	Long: `List the rule files in /etc/aami/rules and its namespace directories.
+
+Examples:
+  aami alerts list
+  aami alerts list -o wide   # With the number of rules and the file path`,
+	RunE: runAlertsList,
+}
+
+var alertsPatchCmd = &cobra.Command{
+	Use:   "patch [rule] [patch]",
+	Short: "Change fields of a custom alert rule with a JSON merge patch",
+	Long: `Apply an RFC 7386 JSON merge patch to one rule in alerts.custom, changing
+only the fields in the patch.
+
+Examples:
+  aami alerts patch GPUMemoryHigh '{"for": "10m"}'
+  aami alerts patch GPUMemoryHigh '{"severity": "critical", "expr": "DCGM_FI_DEV_FB_USED > 0.95"}'`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runAlertsPatch,
+}
+
+var alertsPreviewCmd = &cobra.Command{
+	Use:   "preview [preset|rule] [rule]",
+	Short: "Show the generated rules and promtool results without applying them",
+	Long: `Generate the rule file for a preset, a single rule, or the custom rules in
+the config ("custom"), and validate it with 'promtool check rules'. Nothing
+is written to /etc/aami/rules, so changes can be reviewed before they reach
+Prometheus. The command fails if promtool rejects the rules.
+
+With --listen, previews are served at POST /api/v1/prometheus/rules/preview
+with a JSON body of {"group": "<preset|custom>", "rule": "<name>"}; either
+field may be omitted. Previews require admin.token, as do the rule tests
+served alongside (see 'aami alerts test') and the synthetic alert test of
+'aami doctor --e2e' on POST /api/v1/admin/test-alert. The rules of
+alerts.custom can be read and changed at GET and PUT
+/api/v1/alert-rules/<name>, also with admin.token; a PUT must carry the
+rule's ETag in If-Match and fails with 409 Conflict if the rule changed.
+Changed rules are written to custom.yaml and Prometheus is reloaded in the
+background: changes within 2s are written once, with one reload, but no
+later than 10s after the first, and failed writes and reloads are retried.
+GET /api/v1/prometheus/generation-status shows the queue.
+
+Examples:
+  aami alerts preview gpu-production
+  aami alerts preview GPUXidError
+  aami alerts preview gpu-basic NodeDown
+  aami alerts preview custom
+  aami alerts preview --listen :8095`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: runAlertsPreview,
 }
 
+// customRulesGroup is the group name under which alerts.custom is previewed
+const customRulesGroup = "custom"
+
 // Preset definitions
 type alertPreset struct {
 	Name        string
@@ -58,6 +126,17 @@ type alertRule struct {
 	Severity    string
 	Summary     string
 	Description string
+	// Explain is an annotation template appended to the description, left
+	// untranslated, such as the meaning of the Xid code in $value
+	Explain string
+}
+
+// description returns the description annotation of the rule
+func (r alertRule) description() string {
+	if r.Explain == "" {
+		return r.Description
+	}
+	return r.Description + ": " + r.Explain
 }
 
 var presets = map[string]alertPreset{
@@ -135,13 +214,15 @@ var presets = map[string]alertPreset{
 				Summary:     "High ECC error count on {{ $labels.instance }}",
 				Description: "GPU {{ $labels.gpu }} has reported over 100 ECC errors in 24h",
 			},
+			// DCGM keeps the last Xid code, so the value is the code
 			{
 				Name:        "GPUXidError",
-				Expr:        "increase(DCGM_FI_DEV_XID_ERRORS[5m]) > 0",
+				Expr:        "(DCGM_FI_DEV_XID_ERRORS > 0) and (changes(DCGM_FI_DEV_XID_ERRORS[5m]) > 0 or (DCGM_FI_DEV_XID_ERRORS unless DCGM_FI_DEV_XID_ERRORS offset 5m))",
 				For:         "0m",
 				Severity:    "critical",
 				Summary:     "Xid error on {{ $labels.instance }}",
-				Description: "GPU {{ $labels.gpu }} reported Xid error",
+				Description: "GPU {{ $labels.gpu }} reported Xid {{ $value }}",
+				Explain:     gpuevents.AnnotationTemplate(gpuevents.KindXid),
 			},
 			{
 				Name:        "GPUNVLinkError",
@@ -161,34 +242,110 @@ var presets = map[string]alertPreset{
 			},
 		},
 	},
+	// fabric watches the metrics of aami-infiniband and the NVLink
+	// collector of aami-gpu-health
+	"fabric": {
+		Name:        "fabric",
+		Description: "InfiniBand and NVLink link health",
+		Rules: []alertRule{
+			{
+				Name:        "InfiniBandPortDown",
+				Expr:        "aami_infiniband_port_up == 0",
+				For:         "5m",
+				Severity:    "critical",
+				Summary:     "InfiniBand port down on {{ $labels.instance }}",
+				Description: "Port {{ $labels.port }} of {{ $labels.device }} is not active with its link up",
+			},
+			{
+				Name:        "InfiniBandPortRateDegraded",
+				Expr:        "aami_infiniband_port_rate_bytes < on (instance) group_left () max by (instance) (aami_infiniband_port_rate_bytes)",
+				For:         "10m",
+				Severity:    "warning",
+				Summary:     "InfiniBand port degraded on {{ $labels.instance }}",
+				Description: "Port {{ $labels.port }} of {{ $labels.device }} runs slower than the node's fastest port; its link width or speed dropped",
+			},
+			{
+				Name:        "InfiniBandSymbolErrors",
+				Expr:        "increase(aami_infiniband_port_symbol_errors_total[1h]) > 10",
+				For:         "0m",
+				Severity:    "warning",
+				Summary:     "InfiniBand symbol errors on {{ $labels.instance }}",
+				Description: "Port {{ $labels.port }} of {{ $labels.device }} has over 10 symbol errors in 1h; check the cable and transceiver",
+			},
+			{
+				Name:        "InfiniBandLinkFlapping",
+				Expr:        "increase(aami_infiniband_port_link_downed_total[1h]) > 0 or increase(aami_infiniband_port_link_error_recovery_total[1h]) > 0",
+				For:         "0m",
+				Severity:    "warning",
+				Summary:     "InfiniBand link flapping on {{ $labels.instance }}",
+				Description: "Port {{ $labels.port }} of {{ $labels.device }} went down or retrained in the last hour",
+			},
+			{
+				Name:        "NVLinkDegraded",
+				Expr:        "sum by (instance, gpu) (aami_gpu_nvlink_active) < on (instance) group_left () max by (instance) (sum by (instance, gpu) (aami_gpu_nvlink_active))",
+				For:         "5m",
+				Severity:    "critical",
+				Summary:     "NVLink down on {{ $labels.instance }}",
+				Description: "GPU {{ $labels.gpu }} has {{ $value }} active NVLinks, fewer than the other GPUs of the node",
+			},
+			{
+				Name:        "NVLinkRetraining",
+				Expr:        "increase(aami_gpu_nvlink_errors_total{type=\"recovery\"}[1h]) > 0",
+				For:         "0m",
+				Severity:    "warning",
+				Summary:     "NVLink retraining on {{ $labels.instance }}",
+				Description: "NVLink {{ $labels.link }} of GPU {{ $labels.gpu }} retrained in the last hour",
+			},
+		},
+	},
 }
 
+var (
+	alertsNamespace     string
+	alertsListOutput    string
+	alertsPreviewListen string
+	// ruleAPIAlertmanagerURL is the Alertmanager the rule API's synthetic
+	// alert tests follow
+	ruleAPIAlertmanagerURL string
+)
+
 func init() {
+	alertsApplyPresetCmd.Flags().StringVar(&alertsNamespace, "namespace", "",
+		"Write rules into this namespace's directory (default: the context's namespace)")
+	addOutputFlag(alertsListCmd, &alertsListOutput)
+	addPatchFlags(alertsPatchCmd)
+	alertsPreviewCmd.Flags().StringVar(&alertsPreviewListen, "listen", "",
+		"Serve previews over HTTP on this address (e.g. :8095)")
+	alertsPreviewCmd.Flags().StringVar(&ruleAPIAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager API address, for synthetic alert tests with --listen")
+
 	alertsCmd.AddCommand(alertsListPresetsCmd)
 	alertsCmd.AddCommand(alertsApplyPresetCmd)
 	alertsCmd.AddCommand(alertsListCmd)
+	alertsCmd.AddCommand(alertsPatchCmd)
+	alertsCmd.AddCommand(alertsPreviewCmd)
 	rootCmd.AddCommand(alertsCmd)
 }
 
 func runAlertsListPresets(cmd *cobra.Command, args []string) error {
-	fmt.Println("\nAvailable Alert Presets:")
+	fmt.Println("\n" + i18n.T("Available Alert Presets:"))
 	fmt.Println()
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Preset", "Description", "Rules"})
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Preset"), i18n.T("Description"), i18n.T("Rules")})
 	table.SetBorder(true)
 
 	for name, preset := range presets {
 		table.Append([]string{
 			name,
-			preset.Description,
+			i18n.T(preset.Description),
 			fmt.Sprintf("%d", len(preset.Rules)),
 		})
 	}
 
 	table.Render()
 	fmt.Println()
-	fmt.Println("Apply a preset with: aami alerts apply-preset <name>")
+	fmt.Println(i18n.T("Apply a preset with: aami alerts apply-preset <name>"))
 	fmt.Println()
 
 	return nil
@@ -201,67 +358,163 @@ func runAlertsApplyPreset(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("unknown preset: %s\nRun 'aami alerts list-presets' to see available presets", presetName)
 	}
 
-	green := color.New(color.FgGreen).SprintFunc()
+	if err := ensureWritable(); err != nil {
+		return err
+	}
 
-	// Generate Prometheus rules file
-	rulesDir := "/etc/aami/rules"
-	rulesFile := filepath.Join(rulesDir, fmt.Sprintf("%s.yaml", presetName))
+	green := color.New(color.FgGreen).SprintFunc()
 
-	// Ensure directory exists
-	if err := os.MkdirAll(rulesDir, 0755); err != nil {
-		return fmt.Errorf("create rules directory: %w", err)
+	ns := config.RuleNamespace{}
+	// Without a config, rules are written locally only
+	cfg, cfgErr := loadConfig()
+	if namespace := namespaceOrDefault(alertsNamespace); namespace != "" {
+		if cfgErr != nil {
+			return cfgErr
+		}
+		ns = prometheus.FindRuleNamespace(cfg, namespace)
 	}
 
 	// Generate YAML content
+	preset, err := localizedPreset(preset)
+	if err != nil {
+		return err
+	}
 	content := generatePrometheusRules(preset)
 
-	if err := os.WriteFile(rulesFile, []byte(content), 0644); err != nil {
-		return fmt.Errorf("write rules file: %w", err)
+	rulesFile, err := prometheus.WriteRuleFile(ns, fmt.Sprintf("%s.yaml", presetName), []byte(content))
+	if err != nil {
+		return err
+	}
+
+	uploaded, err := publishRuleFile(cfg, rulesFile, []byte(content))
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("%s Applied preset %s (%d rules)\n", green("✓"), presetName, len(preset.Rules))
-	fmt.Printf("  Rules file: %s\n", rulesFile)
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Applied preset %s (%d rules)", presetName, len(preset.Rules)))
+	fmt.Println(i18n.T("  Rules file: %s", rulesFile))
+	if uploaded != "" {
+		fmt.Println(i18n.T("  Uploaded to: %s", uploaded))
+	}
 	fmt.Println()
-	fmt.Println("Note: Reload Prometheus to activate the rules:")
+	fmt.Println(i18n.T("Note: Reload Prometheus to activate the rules:"))
 	fmt.Println("  curl -X POST http://localhost:9090/-/reload")
 	fmt.Println()
 
 	return nil
 }
 
-func runAlertsList(cmd *cobra.Command, args []string) error {
-	rulesDir := "/etc/aami/rules"
+// ruleFileListing is a rule file as listed by aami alerts list
+type ruleFileListing struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Rules     int    `json:"rules"`
+	Error     string `json:"error,omitempty"`
+}
 
-	entries, err := os.ReadDir(rulesDir)
+func runAlertsList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertsListOutput)
 	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Println("No alert rules configured.")
-			fmt.Println("Apply a preset with: aami alerts apply-preset gpu-production")
-			return nil
-		}
 		return err
 	}
 
-	if len(entries) == 0 {
-		fmt.Println("No alert rules configured.")
-		fmt.Println("Apply a preset with: aami alerts apply-preset gpu-production")
+	entries, err := os.ReadDir(prometheus.RulesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	files := []ruleFileListing{}
+	var namespaces []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			namespaces = append(namespaces, entry.Name())
+			continue
+		}
+		if name, ok := ruleFileName(entry.Name()); ok {
+			files = append(files, listRuleFile("", name, filepath.Join(prometheus.RulesDir, entry.Name())))
+		}
+	}
+
+	for _, ns := range namespaces {
+		dir := filepath.Join(prometheus.RulesDir, ns)
+		nsEntries, err := os.ReadDir(dir)
+		if err != nil {
+			files = append(files, ruleFileListing{Namespace: ns, Path: dir, Error: err.Error()})
+			continue
+		}
+		for _, entry := range nsEntries {
+			if name, ok := ruleFileName(entry.Name()); ok {
+				files = append(files, listRuleFile(ns, name, filepath.Join(dir, entry.Name())))
+			}
+		}
+	}
+
+	if format.Structured() {
+		return writeOutput(format, files)
+	}
+	if len(files) == 0 {
+		fmt.Println(i18n.T("No alert rules configured."))
+		fmt.Println(i18n.T("Apply a preset with: aami alerts apply-preset gpu-production"))
 		return nil
 	}
 
-	fmt.Println("\nActive Alert Rules:")
+	fmt.Println("\n" + i18n.T("Active Alert Rules:"))
 	fmt.Println()
 
-	for _, entry := range entries {
-		if strings.HasSuffix(entry.Name(), ".yaml") || strings.HasSuffix(entry.Name(), ".yml") {
-			name := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".yaml"), ".yml")
-			fmt.Printf("  • %s\n", name)
+	columns := output.Columns{
+		{Header: i18n.T("Namespace")},
+		{Header: i18n.T("Name")},
+		{Header: i18n.T("Rules"), Wide: true},
+		{Header: i18n.T("Path"), Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, f := range files {
+		name, rules := f.Name, fmt.Sprintf("%d", f.Rules)
+		if f.Error != "" {
+			name, rules = strings.TrimSpace(name+" "+color.RedString("(%s)", f.Error)), "-"
 		}
+		table.Append(columns.Row(format, defaultString(f.Namespace, "-"), name, rules, f.Path))
 	}
-
+	table.Render()
 	fmt.Println()
 	return nil
 }
 
+// listRuleFile reads a rule file to count its alerting and recording rules
+func listRuleFile(ns, name, path string) ruleFileListing {
+	listing := ruleFileListing{Namespace: ns, Name: name, Path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		listing.Error = err.Error()
+		return listing
+	}
+	var rules struct {
+		Groups []struct {
+			Rules []yaml.Node `yaml:"rules"`
+		} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		listing.Error = err.Error()
+		return listing
+	}
+	for _, g := range rules.Groups {
+		listing.Rules += len(g.Rules)
+	}
+	return listing
+}
+
+// ruleFileName strips the YAML extension from a rule file name
+func ruleFileName(filename string) (string, bool) {
+	if strings.HasSuffix(filename, ".yaml") || strings.HasSuffix(filename, ".yml") {
+		return strings.TrimSuffix(strings.TrimSuffix(filename, ".yaml"), ".yml"), true
+	}
+	return "", false
+}
+
 func generatePrometheusRules(preset alertPreset) string {
 	var sb strings.Builder
 
@@ -281,9 +534,352 @@ func generatePrometheusRules(preset alertPreset) string {
 		sb.WriteString(fmt.Sprintf("          severity: %s\n", rule.Severity))
 		sb.WriteString("        annotations:\n")
 		sb.WriteString(fmt.Sprintf("          summary: \"%s\"\n", rule.Summary))
-		sb.WriteString(fmt.Sprintf("          description: \"%s\"\n", rule.Description))
+		sb.WriteString(fmt.Sprintf("          description: \"%s\"\n", rule.description()))
 		sb.WriteString("\n")
 	}
 
 	return sb.String()
 }
+
+func runAlertsPatch(cmd *cobra.Command, args []string) error {
+	patch, err := readPatch(args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return err
+	}
+
+	name := args[0]
+	for i := range cfg.Alerts.Custom {
+		if cfg.Alerts.Custom[i].Name != name {
+			continue
+		}
+		rule := cfg.Alerts.Custom[i]
+		if err := config.Patch(&rule, patch); err != nil {
+			return err
+		}
+		if rule.Name == "" || rule.Expr == "" {
+			return fmt.Errorf("rule name and expr cannot be removed")
+		}
+		if rule.Name != name {
+			for _, other := range cfg.Alerts.Custom {
+				if other.Name == rule.Name {
+					return fmt.Errorf("rule %s already exists", rule.Name)
+				}
+			}
+		}
+		cfg.Alerts.Custom[i] = rule
+		return savePatched(cfg, version, rule, "rule "+rule.Name)
+	}
+	return fmt.Errorf("custom rule %s not found", name)
+}
+
+// rulePreview is a generated rule file and its promtool validation
+type rulePreview struct {
+	Group      string                `json:"group"`
+	Rule       string                `json:"rule,omitempty"`
+	File       string                `json:"file"`
+	YAML       string                `json:"yaml"`
+	Validation *prometheus.RuleCheck `json:"validation"`
+}
+
+// previewRules generates and validates the rules of a group (a preset or
+// "custom"), optionally narrowed to one rule. Without a group, the rule is
+// looked up in the custom rules first and then in the presets.
+func previewRules(group, rule string) (*rulePreview, error) {
+	if group == "" && rule == "" {
+		return nil, fmt.Errorf("no preset or rule given")
+	}
+
+	var candidates []alertPreset
+	if group == "" || group == customRulesGroup {
+		cfg, err := loadConfig()
+		if err != nil {
+			if group == customRulesGroup {
+				return nil, err
+			}
+		} else {
+			lang, err := alertLanguage(cfg, customRulesGroup)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, customRulesPreset(cfg, lang))
+		}
+	}
+	if group != customRulesGroup {
+		names := make([]string, 0, len(presets))
+		for name := range presets {
+			if group == "" || name == group {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown preset: %s\nRun 'aami alerts list-presets' to see available presets", group)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			candidates = append(candidates, presets[name])
+		}
+	}
+
+	preset := candidates[0]
+	if rule != "" {
+		var ok bool
+		if preset, ok = findRule(candidates, rule); !ok {
+			if group != "" {
+				return nil, fmt.Errorf("rule %s not found in %s", rule, group)
+			}
+			return nil, fmt.Errorf("unknown preset or rule: %s", rule)
+		}
+	}
+
+	if len(preset.Rules) == 0 {
+		return nil, fmt.Errorf("no rules in %s", preset.Name)
+	}
+	return renderPreview(context.Background(), preset, rule)
+}
+
+// renderPreview generates the rule file of a preset and validates it with
+// 'promtool check rules'
+func renderPreview(ctx context.Context, preset alertPreset, rule string) (*rulePreview, error) {
+	preset, err := localizedPreset(preset)
+	if err != nil {
+		return nil, err
+	}
+
+	content := generatePrometheusRules(preset)
+	file := preset.Name + ".yaml"
+	check, err := prometheus.CheckRules(ctx, file, []byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return &rulePreview{
+		Group:      preset.Name,
+		Rule:       rule,
+		File:       file,
+		YAML:       content,
+		Validation: check,
+	}, nil
+}
+
+// findRule returns a copy of the first preset containing the rule, reduced
+// to that rule
+func findRule(candidates []alertPreset, rule string) (alertPreset, bool) {
+	for _, preset := range candidates {
+		for _, r := range preset.Rules {
+			if r.Name == rule {
+				preset.Rules = []alertRule{r}
+				return preset, true
+			}
+		}
+	}
+	return alertPreset{}, false
+}
+
+// customRulesPreset turns alerts.custom into a preset with annotations in
+// lang
+func customRulesPreset(cfg *config.Config, lang i18n.Lang) alertPreset {
+	preset := alertPreset{Name: customRulesGroup, Description: "Custom rules from the config"}
+	for _, r := range cfg.Alerts.Custom {
+		preset.Rules = append(preset.Rules, alertRule{
+			Name:     r.Name,
+			Expr:     r.Expr,
+			For:      r.For,
+			Severity: r.Severity,
+			Summary:  i18n.In(lang, "%s on {{ $labels.instance }}", r.Name),
+		})
+	}
+	return preset
+}
+
+// alertLanguage returns the annotation language the config sets for a rule
+// group
+func alertLanguage(cfg *config.Config, group string) (i18n.Lang, error) {
+	lang, err := i18n.Parse(cfg.AlertLanguage(group))
+	if err != nil {
+		return i18n.EN, fmt.Errorf("annotation language of %s: %w", group, err)
+	}
+	return lang, nil
+}
+
+// localizedPreset returns a preset with its annotations in the language the
+// config sets for it. Without a config, annotations stay in English.
+func localizedPreset(preset alertPreset) (alertPreset, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return preset, nil
+	}
+	lang, err := alertLanguage(cfg, preset.Name)
+	if err != nil {
+		return alertPreset{}, err
+	}
+
+	rules := make([]alertRule, len(preset.Rules))
+	for i, r := range preset.Rules {
+		r.Summary = i18n.In(lang, r.Summary)
+		r.Description = i18n.In(lang, r.Description)
+		rules[i] = r
+	}
+	preset.Rules = rules
+	return preset, nil
+}
+
+func runAlertsPreview(cmd *cobra.Command, args []string) error {
+	if alertsPreviewListen != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--listen does not take a preset or rule")
+		}
+		return serveRuleAPI(alertsPreviewListen, alertmanagerURL(cmd, ruleAPIAlertmanagerURL))
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no preset or rule given")
+	}
+
+	// A single argument is a preset, "custom", or a rule name
+	group, rule := args[0], ""
+	if len(args) == 2 {
+		rule = args[1]
+	} else if _, ok := presets[group]; !ok && group != customRulesGroup {
+		group, rule = "", args[0]
+	}
+
+	preview, err := previewRules(group, rule)
+	if err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	fmt.Printf("# %s\n", preview.File)
+	fmt.Print(preview.YAML)
+
+	check := preview.Validation
+	switch {
+	case check.Skipped:
+		fmt.Printf("%s %s\n", yellow("•"), i18n.T("Not validated: %s", check.Output))
+	case check.Valid:
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("promtool check rules passed"))
+	default:
+		fmt.Printf("%s %s\n", red("✗"), i18n.T("promtool check rules failed:"))
+		fmt.Print(check.Output)
+		return fmt.Errorf("rules for %s failed validation", preview.Group)
+	}
+	return nil
+}
+
+// serveRuleAPI serves rule previews and rule tests over HTTP. The config is
+// reloaded on every request so custom rule changes are picked up without a
+// restart. Synthetic alert tests are sent to the Alertmanager at amURL.
+func serveRuleAPI(addr, amURL string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.HandleFunc("/prometheus/rules/preview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+
+		var req struct {
+			Group string `json:"group"`
+			Rule  string `json:"rule"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Group == "" && req.Rule == "" {
+			http.Error(w, "group or rule is required", http.StatusBadRequest)
+			return
+		}
+
+		preview, err := previewRules(req.Group, req.Rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(preview); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	// POST /alert-templates/<preset|custom>/test
+	v1.HandleFunc("/alert-templates/", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/alert-templates/"), "/test")
+		if !ok || id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+
+		result, err := runRuleTests(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	// POST /alert-rules/<id>/test, see 'aami alert-rules test';
+	// GET and PUT /alert-rules/<name> for the rules of alerts.custom
+	testRule := alertRuleTestHandler()
+	v1.HandleFunc("/alert-rules/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/alert-rules/")
+		if strings.HasSuffix(name, "/test") {
+			testRule(w, r)
+			return
+		}
+		if name == "" || strings.Contains(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		customRuleHandler(w, r, name)
+	})
+
+	// POST /admin/test-alert, see 'aami doctor --e2e'
+	v1.HandleFunc("/admin/test-alert", testAlertHandler(amURL))
+
+	// GET /prometheus/generation-status, the rule files written for custom
+	// rule changes and the Prometheus reloads
+	v1.HandleFunc("/prometheus/generation-status", generationStatusHandler)
+	go ruleQueue.Run(context.Background())
+
+	fmt.Printf("%s Serving rule previews on http://%s/api/v1/prometheus/rules/preview\n", green("✓"), addr)
+	fmt.Printf("%s Serving rule tests on http://%s/api/v1/alert-templates/<preset|custom>/test\n", green("✓"), addr)
+	fmt.Printf("%s Serving alert rule tests on http://%s/api/v1/alert-rules/<id>/test\n", green("✓"), addr)
+	fmt.Printf("%s Serving custom rules on http://%s/api/v1/alert-rules/<name>\n", green("✓"), addr)
+	fmt.Printf("%s Serving synthetic alert tests on http://%s/api/v1/admin/test-alert\n", green("✓"), addr)
+	fmt.Printf("%s Serving rule generation status on http://%s/api/v1/prometheus/generation-status\n", green("✓"), addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/output"
+)
+
+var alertRulesTemplatesCmd = &cobra.Command{
+	Use:   "templates [<id>]",
+	Short: "List alert templates, or show one with its variables",
+	Long: `List the config server's alert templates, or show one with the variables
+its rules are configured with: their type, range or allowed values,
+whether they are required, and their defaults.
+
+Examples:
+  aami alert-rules templates
+  aami alert-rules templates high-cpu
+  aami alert-rules templates high-cpu -o json`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: firstArg(completeAlertTemplates),
+	RunE:              runAlertRulesTemplates,
+}
+
+func init() {
+	addOutputFlag(alertRulesTemplatesCmd, &alertRulesOutput)
+	alertRulesCreateCmd.RegisterFlagCompletionFunc("from-template", completeAlertTemplates)
+	alertRulesListCmd.RegisterFlagCompletionFunc("template", completeAlertTemplates)
+
+	alertRulesCmd.AddCommand(alertRulesTemplatesCmd)
+}
+
+func runAlertRulesTemplates(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(alertRulesOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := alertRulesClient()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if len(args) == 1 {
+		t, err := client.GetAlertTemplate(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		if format.Structured() {
+			return writeOutput(format, t)
+		}
+		printAlertTemplate(t)
+		return nil
+	}
+
+	templates, err := client.ListAlertTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].ID < templates[j].ID })
+	if format.Structured() {
+		if templates == nil {
+			templates = []configserver.AlertTemplate{}
+		}
+		return writeOutput(format, templates)
+	}
+	if len(templates) == 0 {
+		fmt.Println("No alert templates.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "ID"},
+		{Header: "Name"},
+		{Header: "Severity"},
+		{Header: "Variables"},
+		{Header: "Query", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, t := range templates {
+		names := make([]string, len(t.Variables))
+		for i, v := range t.Variables {
+			names[i] = v.Name
+			if v.Required {
+				names[i] += "*"
+			}
+		}
+		table.Append(columns.Row(format,
+			t.ID,
+			t.Name,
+			colorSeverity(t.Severity),
+			defaultString(strings.Join(names, ","), "-"),
+			t.QueryTemplate,
+		))
+	}
+	table.Render()
+	return nil
+}
+
+// printAlertTemplate prints a template and its variables
+func printAlertTemplate(t *configserver.AlertTemplate) {
+	bold := color.New(color.Bold).SprintFunc()
+
+	fmt.Printf("\n%s %s\n", bold(t.Name), t.ID)
+	fmt.Printf("  Severity:    %s\n", colorSeverity(t.Severity))
+	if t.Description != "" {
+		fmt.Printf("  Description: %s\n", t.Description)
+	}
+	fmt.Printf("  Query:       %s\n", t.QueryTemplate)
+	if len(t.Variables) == 0 {
+		if config := formatRuleConfig(t.DefaultConfig); config != "" {
+			fmt.Printf("  Defaults:    %s\n", config)
+		}
+		fmt.Println()
+		return
+	}
+
+	fmt.Println()
+	table := newTable()
+	table.SetHeader([]string{"Variable", "Type", "Required", "Default", "Description"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, v := range t.Variables {
+		required := "no"
+		if v.Required {
+			required = "yes"
+		}
+		def := "-"
+		if value, ok := t.DefaultConfig[v.Name]; ok {
+			def = strings.TrimPrefix(formatRuleConfig(map[string]interface{}{v.Name: value}), v.Name+"=")
+		}
+		table.Append([]string{v.Name, variableHint(v), required, def, v.Description})
+	}
+	table.Render()
+	fmt.Println()
+}
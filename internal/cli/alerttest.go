@@ -0,0 +1,400 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	alertsTestPrint  bool
+	alertsTestListen string
+)
+
+var alertsTestCmd = &cobra.Command{
+	Use:   "test [preset|custom]...",
+	Short: "Run promtool unit tests against the generated rules",
+	Long: `Run 'promtool test rules' against the rules AAMI generates for a preset or
+for the custom rules ("custom"), without applying them. Without arguments
+every preset and the custom rules are tested.
+
+Each preset rule ships with a test feeding it series that must make it fire
+and series that must not. Your own tests, in promtool's test file format,
+go in /etc/aami/rule-tests/<preset|custom>/*.yaml; their rule_files entry
+is replaced with the generated rule file.
+
+The command fails if a test fails or promtool is not installed, so it can
+gate changes in CI. --print shows the built-in tests as a starting point
+for your own.
+
+With --listen, tests run on POST /api/v1/alert-templates/<preset|custom>/test
+for admin.token and the config server's alert rules are tested on
+POST /api/v1/alert-rules/<id>/test (see 'aami alert-rules test'); rule
+previews are served too, see 'aami alerts preview'.
+
+Examples:
+  aami alerts test
+  aami alerts test gpu-production
+  aami alerts test custom
+  aami alerts test gpu-basic --print
+  aami alerts test --listen :8095`,
+	RunE: runAlertsTest,
+}
+
+// ruleTest is the built-in unit test of a preset rule: the input series,
+// and the alert expected at EvalTime
+type ruleTest struct {
+	Input    []prometheus.SeriesInput
+	EvalTime string
+	Labels   map[string]string // alert labels besides alertname and severity
+	Value    float64           // $value in annotations
+}
+
+// dcgmSeries returns a DCGM series of GPU gpu on the test node
+func dcgmSeries(metric, gpu, values string) prometheus.SeriesInput {
+	return prometheus.SeriesInput{
+		Series: fmt.Sprintf(`%s{instance="gpu-01:9400", gpu="%s"}`, metric, gpu),
+		Values: values,
+	}
+}
+
+// gpu0 are the labels of the alerts expected from dcgmSeries(_, "0", _)
+var gpu0 = map[string]string{"instance": "gpu-01:9400", "gpu": "0"}
+
+// presetRuleTests holds the built-in tests by rule name. In each, GPU 0
+// (or the first HCA) must fire and GPU 1 (or the second node or HCA) must
+// not. Memory values are
+// powers of two so the expected percentage is exact.
+var presetRuleTests = map[string]ruleTest{
+	"GPUTemperatureCritical": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_GPU_TEMP", "0", "90x10"),
+			dcgmSeries("DCGM_FI_DEV_GPU_TEMP", "1", "80x10"),
+		},
+		EvalTime: "10m", Labels: gpu0, Value: 90,
+	},
+	"GPUTemperatureWarning": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_GPU_TEMP", "0", "80x15"),
+			dcgmSeries("DCGM_FI_DEV_GPU_TEMP", "1", "70x15"),
+		},
+		EvalTime: "15m", Labels: gpu0, Value: 80,
+	},
+	"GPUMemoryHigh": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_FB_USED", "0", "124x15"),
+			dcgmSeries("DCGM_FI_DEV_FB_TOTAL", "0", "128x15"),
+			dcgmSeries("DCGM_FI_DEV_FB_USED", "1", "64x15"),
+			dcgmSeries("DCGM_FI_DEV_FB_TOTAL", "1", "128x15"),
+		},
+		EvalTime: "15m", Labels: gpu0, Value: 96.875,
+	},
+	"GPUMemoryLeak": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_FB_USED", "0", "124x40"),
+			dcgmSeries("DCGM_FI_DEV_FB_TOTAL", "0", "128x40"),
+			dcgmSeries("DCGM_FI_DEV_GPU_UTIL", "0", "2x40"),
+			dcgmSeries("DCGM_FI_DEV_FB_USED", "1", "124x40"),
+			dcgmSeries("DCGM_FI_DEV_FB_TOTAL", "1", "128x40"),
+			dcgmSeries("DCGM_FI_DEV_GPU_UTIL", "1", "80x40"),
+		},
+		EvalTime: "40m", Labels: gpu0,
+	},
+	"GPUECCErrors": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_ECC_DBE_VOL_TOTAL", "0", "0+10x1500"),
+			dcgmSeries("DCGM_FI_DEV_ECC_DBE_VOL_TOTAL", "1", "0x1500"),
+		},
+		EvalTime: "24h", Labels: gpu0,
+	},
+	"GPUXidError": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_XID_ERRORS", "0", "0x5 79x5"),
+			dcgmSeries("DCGM_FI_DEV_XID_ERRORS", "1", "0x10"),
+		},
+		EvalTime: "7m", Labels: gpu0, Value: 79,
+	},
+	"GPUNVLinkError": {
+		Input: []prometheus.SeriesInput{
+			dcgmSeries("DCGM_FI_DEV_NVLINK_CRC_FLIT_ERROR_COUNT_TOTAL", "0", "0x30 3x30"),
+			dcgmSeries("DCGM_FI_DEV_NVLINK_CRC_FLIT_ERROR_COUNT_TOTAL", "1", "0x60"),
+		},
+		EvalTime: "40m", Labels: gpu0,
+	},
+	"NodeDown": {
+		Input: []prometheus.SeriesInput{
+			{Series: `up{job="node", instance="10.0.0.1:9100"}`, Values: "1 1 0x5"},
+			{Series: `up{job="node", instance="10.0.0.2:9100"}`, Values: "1x7"},
+		},
+		EvalTime: "5m",
+		Labels:   map[string]string{"job": "node", "instance": "10.0.0.1:9100"},
+	},
+	"InfiniBandPortDown": {
+		Input: []prometheus.SeriesInput{
+			ibSeries("aami_infiniband_port_up", "mlx5_0", "1 1 0x10"),
+			ibSeries("aami_infiniband_port_up", "mlx5_1", "1x12"),
+		},
+		EvalTime: "10m", Labels: ibPort0,
+	},
+	"InfiniBandPortRateDegraded": {
+		Input: []prometheus.SeriesInput{
+			ibSeries("aami_infiniband_port_rate_bytes", "mlx5_0", "25000000000x2 6250000000x18"),
+			ibSeries("aami_infiniband_port_rate_bytes", "mlx5_1", "25000000000x20"),
+		},
+		EvalTime: "15m", Labels: ibPort0, Value: 6250000000,
+	},
+	"InfiniBandSymbolErrors": {
+		Input: []prometheus.SeriesInput{
+			ibSeries("aami_infiniband_port_symbol_errors_total", "mlx5_0", "0+20x70"),
+			ibSeries("aami_infiniband_port_symbol_errors_total", "mlx5_1", "0x70"),
+		},
+		EvalTime: "65m", Labels: ibPort0, Value: 1200,
+	},
+	"InfiniBandLinkFlapping": {
+		Input: []prometheus.SeriesInput{
+			ibSeries("aami_infiniband_port_link_downed_total", "mlx5_0", "0x30 1x30"),
+			ibSeries("aami_infiniband_port_link_downed_total", "mlx5_1", "0x60"),
+		},
+		EvalTime: "40m", Labels: ibPort0,
+	},
+	"NVLinkDegraded": {
+		Input: []prometheus.SeriesInput{
+			nvlinkSeries("aami_gpu_nvlink_active", "0", "0", "1x15"),
+			nvlinkSeries("aami_gpu_nvlink_active", "0", "1", "1 1 0x13"),
+			nvlinkSeries("aami_gpu_nvlink_active", "1", "0", "1x15"),
+			nvlinkSeries("aami_gpu_nvlink_active", "1", "1", "1x15"),
+		},
+		EvalTime: "10m", Labels: map[string]string{"instance": "gpu-01:9402", "gpu": "0"}, Value: 1,
+	},
+	"NVLinkRetraining": {
+		Input: []prometheus.SeriesInput{
+			{Series: `aami_gpu_nvlink_errors_total{instance="gpu-01:9402", gpu="0", link="3", type="recovery"}`, Values: "0x30 2x30"},
+			{Series: `aami_gpu_nvlink_errors_total{instance="gpu-01:9402", gpu="1", link="3", type="recovery"}`, Values: "0x60"},
+		},
+		EvalTime: "40m",
+		Labels:   map[string]string{"instance": "gpu-01:9402", "gpu": "0", "link": "3", "type": "recovery"},
+	},
+}
+
+// ibSeries returns a series of port 1 of an HCA on the test node
+func ibSeries(metric, device, values string) prometheus.SeriesInput {
+	return prometheus.SeriesInput{
+		Series: fmt.Sprintf(`%s{instance="ib-01:9315", device="%s", port="1", link_layer="InfiniBand"}`, metric, device),
+		Values: values,
+	}
+}
+
+// ibPort0 are the labels of the alerts expected from ibSeries(_, "mlx5_0", _)
+var ibPort0 = map[string]string{"instance": "ib-01:9315", "device": "mlx5_0", "port": "1", "link_layer": "InfiniBand"}
+
+// nvlinkSeries returns a series of an NVLink of a GPU on the test node
+func nvlinkSeries(metric, gpu, link, values string) prometheus.SeriesInput {
+	return prometheus.SeriesInput{
+		Series: fmt.Sprintf(`%s{instance="gpu-01:9402", gpu="%s", link="%s"}`, metric, gpu, link),
+		Values: values,
+	}
+}
+
+func init() {
+	alertsTestCmd.Flags().BoolVar(&alertsTestPrint, "print", false,
+		"Print the built-in tests instead of running them")
+	alertsTestCmd.Flags().StringVar(&alertsTestListen, "listen", "",
+		"Serve tests over HTTP on this address (e.g. :8095)")
+	alertsTestCmd.Flags().StringVar(&ruleAPIAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager API address, for synthetic alert tests with --listen")
+
+	alertsCmd.AddCommand(alertsTestCmd)
+}
+
+// ruleTestResult is the outcome of the unit tests of a rule group
+type ruleTestResult struct {
+	Template string                `json:"template"`
+	Files    []string              `json:"files"`
+	Result   *prometheus.RuleCheck `json:"result"`
+}
+
+// ruleTestGroup returns the preset, or the custom rules, to test, with the
+// annotations they are generated with
+func ruleTestGroup(name string) (alertPreset, error) {
+	if name == customRulesGroup {
+		cfg, err := loadConfig()
+		if err != nil {
+			return alertPreset{}, err
+		}
+		lang, err := alertLanguage(cfg, customRulesGroup)
+		if err != nil {
+			return alertPreset{}, err
+		}
+		return customRulesPreset(cfg, lang), nil
+	}
+	preset, ok := presets[name]
+	if !ok {
+		return alertPreset{}, fmt.Errorf("unknown preset: %s\nRun 'aami alerts list-presets' to see available presets", name)
+	}
+	return localizedPreset(preset)
+}
+
+// builtinRuleTests renders the built-in tests of a preset's rules, or nil
+// if none of its rules has one. Custom rules only have the tests users
+// write, even if they reuse a preset rule name.
+func builtinRuleTests(preset alertPreset) ([]byte, error) {
+	if preset.Name == customRulesGroup {
+		return nil, nil
+	}
+	var cases []prometheus.RuleTestCase
+	for _, rule := range preset.Rules {
+		t, ok := presetRuleTests[rule.Name]
+		if !ok {
+			continue
+		}
+		labels := map[string]string{"severity": rule.Severity}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		cases = append(cases, prometheus.RuleTestCase{
+			Interval: "1m",
+			Input:    t.Input,
+			Alerts: []prometheus.AlertTest{{
+				EvalTime:  t.EvalTime,
+				Alertname: rule.Name,
+				Alerts: []prometheus.ExpectedAlert{{
+					Labels: labels,
+					Annotations: map[string]string{
+						"summary":     expandAnnotation(rule.Summary, labels, t.Value),
+						"description": expandAnnotation(rule.description(), labels, t.Value),
+					},
+				}},
+			}},
+		})
+	}
+	if len(cases) == 0 {
+		return nil, nil
+	}
+	return prometheus.RenderRuleTests(preset.Name+".yaml", cases)
+}
+
+// expandAnnotation expands an annotation template as Prometheus does when
+// the alert fires, with $labels and $value set
+func expandAnnotation(text string, labels map[string]string, value float64) string {
+	tmpl, err := template.New("annotation").Parse("{{ $labels := .Labels }}{{ $value := .Value }}" + text)
+	if err != nil {
+		return text
+	}
+	var sb strings.Builder
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value}
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return text
+	}
+	return sb.String()
+}
+
+// runRuleTests runs the built-in and user-written tests of a rule group
+func runRuleTests(name string) (*ruleTestResult, error) {
+	preset, err := ruleTestGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	ruleFile := preset.Name + ".yaml"
+
+	tests, err := prometheus.LoadRuleTests(preset.Name, ruleFile)
+	if err != nil {
+		return nil, err
+	}
+	builtin, err := builtinRuleTests(preset)
+	if err != nil {
+		return nil, err
+	}
+	if builtin != nil {
+		tests[preset.Name+".test.yaml"] = builtin
+	}
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("no tests for %s: add them to %s/%s/", preset.Name, prometheus.RuleTestsDir, preset.Name)
+	}
+	if len(preset.Rules) == 0 {
+		return nil, fmt.Errorf("no rules in %s", preset.Name)
+	}
+
+	check, err := prometheus.TestRules(context.Background(), ruleFile, []byte(generatePrometheusRules(preset)), tests)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ruleTestResult{Template: preset.Name, Result: check}
+	for file := range tests {
+		result.Files = append(result.Files, file)
+	}
+	sort.Strings(result.Files)
+	return result, nil
+}
+
+func runAlertsTest(cmd *cobra.Command, args []string) error {
+	if alertsTestListen != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--listen does not take a preset")
+		}
+		return serveRuleAPI(alertsTestListen, alertmanagerURL(cmd, ruleAPIAlertmanagerURL))
+	}
+
+	names := args
+	if len(names) == 0 {
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if cfg, err := loadConfig(); err == nil && len(cfg.Alerts.Custom) > 0 {
+			names = append(names, customRulesGroup)
+		}
+	}
+
+	if alertsTestPrint {
+		for _, name := range names {
+			preset, err := ruleTestGroup(name)
+			if err != nil {
+				return err
+			}
+			builtin, err := builtinRuleTests(preset)
+			if err != nil {
+				return err
+			}
+			if builtin != nil {
+				fmt.Printf("# %s.test.yaml\n%s", preset.Name, builtin)
+			}
+		}
+		return nil
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	failed := 0
+	for _, name := range names {
+		result, err := runRuleTests(name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case result.Result.Skipped:
+			return fmt.Errorf("cannot run rule tests: %s", result.Result.Output)
+		case result.Result.Valid:
+			fmt.Printf("%s %s\n", green("✓"), i18n.T("%s: %d test file(s) passed", name, len(result.Files)))
+		default:
+			failed++
+			fmt.Printf("%s %s\n", red("✗"), i18n.T("%s: tests failed", name))
+			fmt.Print(result.Result.Output)
+		}
+	}
+
+	return countError(failed, len(names), "rule groups")
+}
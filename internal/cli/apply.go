@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/manifest"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	applyFiles  []string
+	applyDryRun bool
+	applyPrune  bool
+	applyOutput string
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply resource manifests to the configuration",
+	Long: `Create, update and delete nodes, custom alert rules and namespaces from
+declarative manifests, like kubectl apply.
+
+  apiVersion: aami/v1
+  kind: Node                # or AlertRule, Namespace
+  metadata:
+    name: gpu-01
+  spec:                     # fields as in config.yaml
+    ip: 10.0.0.1
+    labels: {rack: a1}
+
+Each apply is compared three ways with the current config and the
+manifests of the last apply: fields in a manifest are set, fields removed
+from it since the last apply are removed, and fields changed by other
+means (aami nodes patch, discovery) are kept. With --prune, resources of
+earlier applies that are no longer in the manifests are deleted; resources
+never applied are left alone.
+
+Examples:
+  aami apply -f cluster/ --dry-run
+  aami apply -f nodes.yaml -f rules.yaml
+  aami apply -f cluster/ --prune
+  kustomize build . | aami apply -f -`,
+	Args: cobra.NoArgs,
+	RunE: runApply,
+}
+
+func init() {
+	applyCmd.Flags().StringArrayVarP(&applyFiles, "file", "f", nil,
+		"Manifest file or directory (- for stdin); repeatable")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false,
+		"Show the changes without saving them")
+	applyCmd.Flags().BoolVar(&applyPrune, "prune", false,
+		"Delete previously applied resources missing from the manifests")
+	addOutputFlag(applyCmd, &applyOutput)
+	applyCmd.MarkFlagRequired("file")
+
+	rootCmd.AddCommand(applyCmd)
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(applyOutput)
+	if err != nil {
+		return err
+	}
+
+	var resources []manifest.Resource
+	for _, path := range applyFiles {
+		var rs []manifest.Resource
+		var err error
+		if path == "-" {
+			var data []byte
+			if data, err = io.ReadAll(os.Stdin); err == nil {
+				rs, err = manifest.Parse(data)
+			}
+		} else {
+			rs, err = manifest.ReadPath(path)
+		}
+		if err != nil {
+			return err
+		}
+		resources = append(resources, rs...)
+	}
+
+	changes, err := applyManifests(resources, applyDryRun, applyPrune)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		if changes == nil {
+			changes = []manifest.Change{}
+		}
+		return writeOutput(format, map[string]interface{}{
+			"dry_run": applyDryRun,
+			"changed": len(changes) > 0,
+			"changes": changes,
+		})
+	}
+	printManifestChanges(changes, applyDryRun)
+	return nil
+}
+
+// applyManifests applies resources to the config and records them as last
+// applied, or with dryRun only returns the changes
+func applyManifests(resources []manifest.Resource, dryRun, prune bool) ([]manifest.Change, error) {
+	if !dryRun {
+		if err := ensureWritable(); err != nil {
+			return nil, err
+		}
+	}
+
+	configImport.Lock()
+	defer configImport.Unlock()
+
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return nil, err
+	}
+	state, err := manifest.LoadState(manifest.DefaultStatePath)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := manifest.Apply(cfg, state, resources, prune)
+	if err != nil || dryRun {
+		return changes, err
+	}
+
+	if len(changes) > 0 {
+		if _, err := saveConfig(cfg, version); err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			if c.Kind == manifest.KindNode {
+				if err := prometheus.GenerateAllTargets(cfg.Nodes, prometheus.DefaultTargetsDir); err != nil {
+					return changes, err
+				}
+				break
+			}
+		}
+	}
+	return changes, state.Save(manifest.DefaultStatePath)
+}
+
+func printManifestChanges(changes []manifest.Change, dryRun bool) {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if len(changes) == 0 {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Configuration is up to date"))
+		return
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Action"), i18n.T("Kind"), i18n.T("Name")})
+	for _, c := range changes {
+		table.Append([]string{i18n.T(c.Action), c.Kind, c.Name})
+	}
+	table.Render()
+
+	if !dryRun {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Applied %d changes", len(changes)))
+		return
+	}
+	for _, c := range changes {
+		if c.Diff == "" {
+			continue
+		}
+		fmt.Printf("\n--- %s/%s\n", c.Kind, c.Name)
+		printDiff(c.Diff)
+	}
+	fmt.Println()
+	fmt.Println(i18n.T("Dry run: nothing was saved"))
+}
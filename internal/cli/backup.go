@@ -1,14 +1,19 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
 	"github.com/fregataa/aami/internal/backup"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/storage"
 )
 
 var (
@@ -17,6 +22,7 @@ var (
 	backupConfigOnly  bool
 	backupForce       bool
 	backupDryRun      bool
+	backupRemote      bool
 )
 
 var backupCmd = &cobra.Command{
@@ -28,8 +34,14 @@ Examples:
   aami backup create              # Create config backup
   aami backup create --include-data  # Include Prometheus/Grafana data
   aami backup list                # List available backups
+  aami backup list --remote       # List backups in storage.backups
+  aami backup prune --dry-run     # Show backups the lifecycle would remove
   aami backup restore <file>      # Restore from backup
-  aami backup restore <file> --config-only  # Restore config only`,
+  aami backup restore <file> --config-only  # Restore config only
+
+With storage.backups set to s3 or gcs, new backups are also uploaded to the
+bucket. After each backup, keep_last and max_age prune old backups locally
+and in the bucket.`,
 }
 
 var backupCreateCmd = &cobra.Command{
@@ -50,10 +62,25 @@ var backupListCmd = &cobra.Command{
 	RunE:  runBackupList,
 }
 
+var backupPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove backups expired by the lifecycle policy",
+	Long: `Remove the backups storage.backups.keep_last and max_age expire, in the
+local backup directory and in the backup bucket. The newest backup is
+always kept.
+
+Examples:
+  aami backup prune --dry-run
+  aami backup prune`,
+	Args: cobra.NoArgs,
+	RunE: runBackupPrune,
+}
+
 var backupRestoreCmd = &cobra.Command{
 	Use:   "restore <backup-file>",
 	Short: "Restore from a backup",
-	Long: `Restore AAMI configuration and data from a backup file.
+	Long: `Restore AAMI configuration and data from a backup file. A backup that
+is only in the backup bucket is downloaded first.
 
 Examples:
   aami backup restore aami-backup-2024-01-01.tar.gz
@@ -89,6 +116,13 @@ func init() {
 
 	// List subcommand
 	backupCmd.AddCommand(backupListCmd)
+	backupListCmd.Flags().BoolVar(&backupRemote, "remote", false,
+		"List backups in the backup bucket instead of the local directory")
+
+	// Prune subcommand
+	backupCmd.AddCommand(backupPruneCmd)
+	backupPruneCmd.Flags().BoolVar(&backupDryRun, "dry-run", false,
+		"Show what would be removed without removing it")
 
 	// Restore subcommand
 	backupCmd.AddCommand(backupRestoreCmd)
@@ -108,9 +142,11 @@ func init() {
 
 func runBackupCreate(cmd *cobra.Command, args []string) error {
 	b := backup.NewBackup()
+	cfg := backupConfig()
 
 	opts := backup.DefaultBackupOptions()
 	opts.IncludeData = backupIncludeData
+	opts.OutputDir = backupDir(cfg)
 	if backupOutputDir != "" {
 		opts.OutputDir = backupOutputDir
 	}
@@ -130,14 +166,148 @@ func runBackupCreate(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  Data:       %v\n", result.IncludesData)
 	fmt.Printf("  Created:    %s\n", result.CreatedAt.Format("2006-01-02 15:04:05"))
 
+	if cfg == nil {
+		return nil
+	}
+	remote, err := backupStorage(cfg)
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		data, err := os.ReadFile(result.FilePath)
+		if err != nil {
+			return fmt.Errorf("read backup: %w", err)
+		}
+		name := filepath.Base(result.FilePath)
+		if err := remote.Put(name, data); err != nil {
+			return err
+		}
+		fmt.Printf("  Uploaded:   %s\n", remote.Location(name))
+	}
+
+	// Backups written elsewhere with --output are not subject to the
+	// lifecycle
+	if backupOutputDir != "" {
+		return nil
+	}
+	return pruneBackups(cfg, false)
+}
+
+// backupConfig returns the configuration for storage.backups, or nil
+// without one: backups work on hosts that were never initialized
+func backupConfig() *config.Config {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+	return cfg
+}
+
+// remoteBackups lists the backups in the backup bucket, newest first
+func remoteBackups(remote storage.Backend) ([]backup.BackupInfo, error) {
+	objects, err := remote.List("")
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup.BackupInfo
+	for _, obj := range objects {
+		if bi, ok := backup.NewBackupInfo(obj.Key, remote.Location(obj.Key), obj.Size, obj.Modified); ok {
+			backups = append(backups, bi)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// pruneBackups removes the backups the lifecycle policy expires, locally
+// and in the backup bucket
+func pruneBackups(cfg *config.Config, dryRun bool) error {
+	lifecycle, err := backupLifecycle(cfg)
+	if err != nil {
+		return err
+	}
+	if lifecycle.IsZero() {
+		if dryRun {
+			fmt.Println("No lifecycle policy: set storage.backups.keep_last or max_age")
+		}
+		return nil
+	}
+
+	b := backup.NewBackup()
+	now := time.Now()
+	local, err := b.List(backupDir(cfg))
+	if err != nil {
+		return fmt.Errorf("list backups: %w", err)
+	}
+	removed := 0
+	for _, bi := range lifecycle.Expired(local, now) {
+		if dryRun {
+			fmt.Printf("  - %s\n", bi.Path)
+		} else if err := b.Delete(bi.Path); err != nil {
+			return fmt.Errorf("delete backup: %w", err)
+		}
+		removed++
+	}
+
+	remote, err := backupStorage(cfg)
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		backups, err := remoteBackups(remote)
+		if err != nil {
+			return err
+		}
+		for _, bi := range lifecycle.Expired(backups, now) {
+			if dryRun {
+				fmt.Printf("  - %s\n", bi.Path)
+			} else if err := remote.Delete(bi.Name); err != nil {
+				return err
+			}
+			removed++
+		}
+	}
+
+	switch {
+	case dryRun:
+		fmt.Printf("Dry run: %d backup(s) to remove\n", removed)
+	case removed > 0:
+		fmt.Printf("  Pruned:     %d expired backup(s)\n", removed)
+	}
 	return nil
 }
 
+func runBackupPrune(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return pruneBackups(cfg, backupDryRun)
+}
+
 func runBackupList(cmd *cobra.Command, args []string) error {
 	b := backup.NewBackup()
+	cfg := backupConfig()
 
-	backups, err := b.List("")
-	if err != nil {
+	var backups []backup.BackupInfo
+	var err error
+	if backupRemote {
+		if cfg == nil {
+			return fmt.Errorf("--remote needs storage.backups in the config")
+		}
+		remote, err := backupStorage(cfg)
+		if err != nil {
+			return err
+		}
+		if remote == nil {
+			return fmt.Errorf("storage.backups keeps backups in %s only", backupDir(cfg))
+		}
+		if backups, err = remoteBackups(remote); err != nil {
+			return err
+		}
+	} else if backups, err = b.List(backupDir(cfg)); err != nil {
 		return fmt.Errorf("list backups: %w", err)
 	}
 
@@ -148,7 +318,7 @@ func runBackupList(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Name", "Size", "Created", "Type"})
 	table.SetBorder(false)
 
@@ -171,7 +341,10 @@ func runBackupList(cmd *cobra.Command, args []string) error {
 }
 
 func runBackupRestore(cmd *cobra.Command, args []string) error {
-	backupPath := args[0]
+	backupPath, err := fetchBackup(args[0])
+	if err != nil {
+		return err
+	}
 
 	b := backup.NewBackup()
 
@@ -233,6 +406,42 @@ func runBackupRestore(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// fetchBackup returns the path of a backup to restore. A name not found
+// locally is downloaded from the backup bucket into the backup directory.
+func fetchBackup(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil {
+		return name, nil
+	}
+	cfg := backupConfig()
+	local := filepath.Join(backupDir(cfg), filepath.Base(name))
+	if _, err := os.Stat(local); err == nil {
+		return local, nil
+	}
+	if cfg == nil {
+		return name, nil
+	}
+	remote, err := backupStorage(cfg)
+	if err != nil || remote == nil {
+		return name, err
+	}
+
+	data, err := remote.Get(filepath.Base(name))
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", fmt.Errorf("backup file not found locally or in %s: %s", remote.Location(""), name)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return "", fmt.Errorf("create backup dir: %w", err)
+	}
+	if err := os.WriteFile(local, data, 0600); err != nil {
+		return "", fmt.Errorf("write backup: %w", err)
+	}
+	fmt.Printf("Downloaded %s to %s\n", remote.Location(filepath.Base(name)), local)
+	return local, nil
+}
+
 func runBackupVerify(cmd *cobra.Command, args []string) error {
 	backupPath := args[0]
 
@@ -0,0 +1,301 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/agentauth"
+	"github.com/fregataa/aami/internal/bmc"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	bmcAddress       string
+	bmcProtocol      string
+	bmcUsername      string
+	bmcPasswordStdin bool
+	bmcInsecure      bool
+	bmcOutput        string
+)
+
+var nodesBMCCmd = &cobra.Command{
+	Use:   "bmc",
+	Short: "Manage the BMC credentials the IPMI exporter polls with",
+	Long: `Manage the BMC (baseboard management controller) credentials of nodes.
+
+The IPMI exporter of a node (aami-ipmi) polls its BMC for power draw, fan
+speeds and sensor faults, with ipmitool or Redfish. It fetches the BMC's
+address and credentials with the node agent's credential from
+'aami check-results serve' (/api/v1/targets/<hostname>/bmc), which keeps them
+under /var/lib/aami/bmc with the username and password encrypted
+(AES-256-GCM) by a key only the server reads: /etc/aami/bmc.key, mode 0600,
+or the file in $AAMI_BMC_KEY_FILE. A key found in /var/lib/aami/bmc, where
+earlier versions kept it, is moved there. Back the key up separately from
+the credentials; 'aami backup' does not include /var/lib/aami/bmc, and the
+credentials cannot be decrypted without the key. Nodes without a credential
+are polled in-band, through the local /dev/ipmi0.
+
+Examples:
+  aami node bmc set gpu-node-01 --address 10.0.10.1 --username admin --password-stdin < pw
+  aami node bmc set gpu-node-02 --address bmc-02.example.com --protocol redfish --username admin --password-stdin
+  aami node bmc list
+  aami node bmc remove gpu-node-01`,
+}
+
+var nodesBMCSetCmd = &cobra.Command{
+	Use:   "set <node>",
+	Short: "Set the BMC address and credentials of a node",
+	Long: `Set the BMC address and credentials of a node, replacing its previous ones.
+
+The password is read from the first line of stdin with --password-stdin, or
+from AAMI_BMC_PASSWORD, so that it stays out of the shell history. The
+exporter picks up the change within five minutes.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesBMCSet,
+}
+
+var nodesBMCListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the nodes with BMC credentials, without their secrets",
+	Args:  cobra.NoArgs,
+	RunE:  runNodesBMCList,
+}
+
+var nodesBMCRemoveCmd = &cobra.Command{
+	Use:               "remove <node>",
+	Short:             "Remove the BMC credentials of a node",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesBMCRemove,
+}
+
+func init() {
+	nodesBMCSetCmd.Flags().StringVar(&bmcAddress, "address", "", "BMC host or host:port (required)")
+	nodesBMCSetCmd.Flags().StringVar(&bmcProtocol, "protocol", bmc.ProtocolIPMI,
+		"Protocol to poll the BMC with: "+bmc.ProtocolIPMI+" or "+bmc.ProtocolRedfish)
+	nodesBMCSetCmd.Flags().StringVar(&bmcUsername, "username", "", "BMC user (required)")
+	nodesBMCSetCmd.Flags().BoolVar(&bmcPasswordStdin, "password-stdin", false, "Read the password from stdin")
+	nodesBMCSetCmd.Flags().BoolVar(&bmcInsecure, "insecure-skip-verify", false,
+		"Accept the BMC's self-signed certificate (redfish)")
+	nodesBMCSetCmd.MarkFlagRequired("address")
+	nodesBMCSetCmd.MarkFlagRequired("username")
+	nodesBMCSetCmd.RegisterFlagCompletionFunc("protocol",
+		cobra.FixedCompletions([]string{bmc.ProtocolIPMI, bmc.ProtocolRedfish}, cobra.ShellCompDirectiveNoFileComp))
+	addOutputFlag(nodesBMCListCmd, &bmcOutput)
+
+	nodesBMCCmd.AddCommand(nodesBMCSetCmd)
+	nodesBMCCmd.AddCommand(nodesBMCListCmd)
+	nodesBMCCmd.AddCommand(nodesBMCRemoveCmd)
+	nodesCmd.AddCommand(nodesBMCCmd)
+}
+
+// newBMCStore returns the BMC credential store, sealed with the key in
+// $AAMI_BMC_KEY_FILE if set
+func newBMCStore() *bmc.Store {
+	return bmc.NewStore(bmc.DefaultDir, defaultString(os.Getenv("AAMI_BMC_KEY_FILE"), bmc.DefaultKeyFile))
+}
+
+func runNodesBMCSet(cmd *cobra.Command, args []string) error {
+	password := os.Getenv("AAMI_BMC_PASSWORD")
+	if bmcPasswordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("read password from stdin: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		return fmt.Errorf("no password: pass --password-stdin or set AAMI_BMC_PASSWORD")
+	}
+
+	host, err := bmcHost(args[0])
+	if err != nil {
+		return err
+	}
+	stored, err := newBMCStore().Put(bmc.Credential{
+		Target:             host,
+		Address:            bmcAddress,
+		Protocol:           bmcProtocol,
+		Username:           bmcUsername,
+		Password:           password,
+		InsecureSkipVerify: bmcInsecure,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s BMC of %s set: %s %s\n", color.GreenString("✓"), stored.Target, stored.Protocol, stored.Address)
+	return nil
+}
+
+func runNodesBMCList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(bmcOutput)
+	if err != nil {
+		return err
+	}
+	creds, err := newBMCStore().List()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, creds)
+	}
+	if len(creds) == 0 {
+		fmt.Println("No BMC credentials set. Nodes are polled in-band.")
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Node", "Protocol", "Address", "Updated"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, c := range creds {
+		address := c.Address
+		if c.InsecureSkipVerify {
+			address += " (insecure)"
+		}
+		table.Append([]string{c.Target, c.Protocol, address, c.UpdatedAt.Local().Format("01-02 15:04")})
+	}
+	table.Render()
+	return nil
+}
+
+func runNodesBMCRemove(cmd *cobra.Command, args []string) error {
+	host, err := bmcHost(args[0])
+	if err != nil {
+		return err
+	}
+	if err := newBMCStore().Delete(host); err != nil {
+		return err
+	}
+	fmt.Printf("%s BMC of %s removed; it is polled in-band from now on\n", color.GreenString("✓"), host)
+	return nil
+}
+
+// bmcHost resolves a node to the hostname its credential is kept by
+func bmcHost(ref string) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+	target, err := findNodeTarget(ctx, cfg, ref)
+	if err != nil {
+		return "", err
+	}
+	return target.Hostname, nil
+}
+
+// handleTargetBMC serves /targets/<hostname>/bmc of the agent API: the
+// node's own agent credential reads the credential in full, for its IPMI
+// exporter; readers get it without username and password; operators PUT
+// and DELETE it
+func handleTargetBMC(w http.ResponseWriter, r *http.Request, cfg *config.Config, store *bmc.Store, authority *agentauth.Authority) {
+	host, ok := strings.CutSuffix(strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/targets/"), "/bmc")
+	if !ok || host == "" || strings.Contains(host, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Agents must prove who they are: the secrets are never handed out
+	// without a credential, even when agent_auth.required is off
+	if r.Method == http.MethodGet {
+		if claims, err := agentCredential(r, cfg, authority); err == nil && claims != nil {
+			if host != claims.Target() {
+				http.Error(w, fmt.Sprintf("credential of %s cannot read the BMC of %s", claims.Target(), host), http.StatusForbidden)
+				return
+			}
+			c, err := store.Get(host)
+			if errors.Is(err, bmc.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, c)
+			return
+		}
+	}
+
+	// Everything else authenticates like the other readers; API keys only
+	// see the nodes of their namespace
+	t, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if nodes := t.Nodes(cfg); nodes != nil && !nodes[host] {
+		http.Error(w, "unknown target: "+host, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		c, err := store.Get(host)
+		if errors.Is(err, bmc.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, c.Redacted())
+
+	case http.MethodPut:
+		if !hasNodeConfig(cfg, host) {
+			http.Error(w, "unknown target: "+host, http.StatusBadRequest)
+			return
+		}
+		data, err := readLimited(r, 64<<10)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var c bmc.Credential
+		if err := json.Unmarshal(data, &c); err != nil {
+			http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if c.Target != "" && c.Target != host {
+			http.Error(w, fmt.Sprintf("BMC of %s put for %s", c.Target, host), http.StatusBadRequest)
+			return
+		}
+		c.Target = host
+		stored, err := store.Put(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, stored)
+
+	case http.MethodDelete:
+		err := store.Delete(host)
+		if errors.Is(err, bmc.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
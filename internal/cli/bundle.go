@@ -98,6 +98,10 @@ func runBundleCreate(cmd *cobra.Command, args []string) error {
 	// Generate default rules
 	fmt.Printf("  %s Including alert rules...\n", yellow("•"))
 	for presetName, preset := range presets {
+		preset, err := localizedPreset(preset)
+		if err != nil {
+			return err
+		}
 		content := generatePrometheusRules(preset)
 		rulePath := filepath.Join(rulesDir, fmt.Sprintf("%s.yaml", presetName))
 		if err := os.WriteFile(rulePath, []byte(content), 0644); err != nil {
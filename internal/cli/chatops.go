@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/api"
+	"github.com/fregataa/aami/internal/chatops"
+)
+
+var (
+	chatopsListen          string
+	chatopsAlertmanagerURL string
+)
+
+var chatopsCmd = &cobra.Command{
+	Use:   "chatops",
+	Short: "Serve chat slash commands",
+	Long: `Serve Slack and Mattermost slash commands for common operations.
+
+Commands:
+  /aami status                          Active alerts summary (viewer)
+  /aami silence <node> <duration> [msg] Silence a node's alerts (operator)
+  /aami ack <alertname> [node]          Acknowledge an alert for 4h (operator)
+
+Slack requests are verified with chatops.signing_secret, Mattermost
+requests with chatops.token. Once a signing secret is configured every
+request must carry a valid X-Slack-Signature and the token alone is
+refused: chatops.token only applies when no signing secret is set.
+Requests from a team other than chatops.team_id are refused. Chat user
+IDs (not names, which users can change) are mapped to roles in
+chatops.users; unlisted users get chatops.default_role.`,
+}
+
+var chatopsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the slash command endpoint",
+	Long: `Start the slash command endpoint.
+
+Point the slash command's request URL at http://<host><listen>/api/v1/chatops.
+
+Examples:
+  aami chatops serve --listen :8092`,
+	Args: cobra.NoArgs,
+	RunE: runChatopsServe,
+}
+
+func init() {
+	chatopsServeCmd.Flags().StringVar(&chatopsListen, "listen", ":8092",
+		"Address to listen on")
+	chatopsServeCmd.Flags().StringVar(&chatopsAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager URL")
+
+	chatopsCmd.AddCommand(chatopsServeCmd)
+	rootCmd.AddCommand(chatopsCmd)
+}
+
+func runChatopsServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.ChatOps.SigningSecret == "" && cfg.ChatOps.Token == "" {
+		return fmt.Errorf("chatops.signing_secret or chatops.token must be configured")
+	}
+
+	handler := chatops.NewHandler(readConfig, alertmanager.NewClient(alertmanagerURL(cmd, chatopsAlertmanagerURL)))
+
+	mux := newAPIMux()
+	mux.Version("v1").HandleWithAlias("/chatops", handler, api.Unversioned)
+
+	fmt.Printf("%s Serving slash commands on http://%s/api/v1/chatops\n", green("✓"), chatopsListen)
+	return http.ListenAndServe(chatopsListen, mux)
+}
@@ -0,0 +1,615 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+// Page sizes of the check result API
+const (
+	checkResultsDefaultLimit = 50
+	checkResultsMaxLimit     = 500
+)
+
+// checkResultsMaxBatch is the most results accepted in one POST
+const checkResultsMaxBatch = 500
+
+// checkResultsPruneInterval is how often serve removes expired results
+const checkResultsPruneInterval = time.Hour
+
+var (
+	checkResultsTarget   string
+	checkResultsCheck    string
+	checkResultsStatus   string
+	checkResultsSince    string
+	checkResultsPage     int
+	checkResultsLimit    int
+	checkResultsOutput   string
+	checkResultsListen   string
+	checkResultsTLSCert  string
+	checkResultsTLSKey   string
+	checkResultsTLSHosts []string
+)
+
+var checkResultsCmd = &cobra.Command{
+	Use:     "check-results",
+	Aliases: []string{"results"},
+	Short:   "Browse check results reported by node agents",
+	Long: `Browse the outcomes of check scripts reported by node agents.
+
+Agents post each check run (status, exit code, duration and output) to
+/api/v1/check-results, served by 'aami check-results serve'. Results are
+kept for check_results.retention (default 30d).
+
+Examples:
+  aami check-results list --status failed
+  aami check-results list --target gpu-node-01 --check disk-usage --since 7d
+  aami check-results summary
+  aami check-results prune
+  aami check-results serve --listen :8098`,
+}
+
+var checkResultsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List check results, newest first",
+	Args:  cobra.NoArgs,
+	RunE:  runCheckResultsList,
+}
+
+var checkResultsSummaryCmd = &cobra.Command{
+	Use:   "summary",
+	Short: "Summarize check results per check",
+	Args:  cobra.NoArgs,
+	RunE:  runCheckResultsSummary,
+}
+
+var checkResultsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove results older than the retention period",
+	Args:  cobra.NoArgs,
+	RunE:  runCheckResultsPrune,
+}
+
+var checkResultsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the check result API",
+	Long: `Serve the check result API at /api/v1/check-results:
+
+  POST /api/v1/check-results          Report results (agents)
+  GET  /api/v1/check-results          List results, newest first
+  GET  /api/v1/check-results/summary  Per-check summary for dashboards
+
+It also takes the hardware inventory of each node ('aami node inventory'):
+
+  POST /api/v1/targets/<hostname>/inventory  Report the inventory (agents)
+  GET  /api/v1/targets/<hostname>/inventory  Latest inventory of a node
+
+and the debug bundles agents collect ('aami node collect-debug'), kept
+under storage.debug_bundles:
+
+  POST /api/v1/targets/<hostname>/debug-bundles       Upload a bundle (agents)
+  GET  /api/v1/targets/<hostname>/debug-bundles       Bundles of a node
+  GET  /api/v1/targets/<hostname>/debug-bundles/<id>  Download a bundle
+
+and the reports of GPU validation runs ('aami gpu validate'):
+
+  PUT  /api/v1/targets/<hostname>/gpu-validations/<id>  Report a run's progress (agents)
+  GET  /api/v1/targets/<hostname>/gpu-validations       Reports of a node
+  GET  /api/v1/targets/<hostname>/gpu-validations/<id>  One report
+
+and the BMC credentials of the IPMI exporters ('aami node bmc'), encrypted
+under /var/lib/aami/bmc:
+
+  GET    /api/v1/targets/<hostname>/bmc  The BMC of a node; in full for its agent
+  PUT    /api/v1/targets/<hostname>/bmc  Set the BMC of a node
+  DELETE /api/v1/targets/<hostname>/bmc  Remove it
+
+GET requests authenticate with "Authorization: Bearer <check_results.token>",
+or with an API key (api_keys) or SSO ID token (oidc, 'aami login'), which
+reads only the results of the nodes in its namespace.
+Agents post with their credential ('aami agents') and only for their own
+node; without agent_auth.required they may also post without one, for any
+node in the config.
+List filters: target, check, status, since (e.g. 24h, 7d or an RFC 3339
+time); pages: page (from 1) and limit (default 50, at most 500).
+
+Results older than check_results.retention are removed at startup and
+every hour.
+
+With --tls-cert, or agent_auth.mtls, the API is served over TLS and agents
+may authenticate with a client certificate of the agent CA ('aami agents
+certificate') instead of a credential; with agent_auth.mtls they must.
+Without --tls-cert, the server's certificate is issued by the agent CA,
+which agents trust, and reissued before it expires.
+
+Examples:
+  aami check-results serve --listen :8098
+  aami check-results serve --listen :8098 --tls-host results.example.com`,
+	Args: cobra.NoArgs,
+	RunE: runCheckResultsServe,
+}
+
+func init() {
+	checkResultsListCmd.Flags().StringVar(&checkResultsTarget, "target", "",
+		"Only results of this target (hostname)")
+	checkResultsListCmd.Flags().StringVar(&checkResultsCheck, "check", "",
+		"Only results of this check")
+	checkResultsListCmd.Flags().StringVar(&checkResultsStatus, "status", "",
+		"Only results with this status: "+strings.Join(checkresult.Statuses(), ", "))
+	checkResultsListCmd.RegisterFlagCompletionFunc("target", completeTargets)
+	checkResultsListCmd.RegisterFlagCompletionFunc("status",
+		cobra.FixedCompletions(checkresult.Statuses(), cobra.ShellCompDirectiveNoFileComp))
+	checkResultsListCmd.Flags().IntVar(&checkResultsPage, "page", 1,
+		"Page to show, from 1")
+	checkResultsListCmd.Flags().IntVar(&checkResultsLimit, "limit", checkResultsDefaultLimit,
+		"Results per page")
+	for _, c := range []*cobra.Command{checkResultsListCmd, checkResultsSummaryCmd} {
+		c.Flags().StringVar(&checkResultsSince, "since", "24h",
+			"Only results reported within this duration (e.g. 1h, 7d)")
+		addOutputFlag(c, &checkResultsOutput)
+	}
+	checkResultsServeCmd.Flags().StringVar(&checkResultsListen, "listen", ":8098",
+		"Address to listen on")
+	checkResultsServeCmd.Flags().StringVar(&checkResultsTLSCert, "tls-cert", "",
+		"TLS certificate to serve (default with agent_auth.mtls: one issued by the agent CA)")
+	checkResultsServeCmd.Flags().StringVar(&checkResultsTLSKey, "tls-key", "",
+		"Private key of --tls-cert")
+	checkResultsServeCmd.Flags().StringSliceVar(&checkResultsTLSHosts, "tls-host", nil,
+		"Host names and addresses of the certificate issued by the agent CA (default: the hostname)")
+
+	checkResultsCmd.AddCommand(checkResultsListCmd)
+	checkResultsCmd.AddCommand(checkResultsSummaryCmd)
+	checkResultsCmd.AddCommand(checkResultsPruneCmd)
+	checkResultsCmd.AddCommand(checkResultsServeCmd)
+	rootCmd.AddCommand(checkResultsCmd)
+}
+
+func newCheckResultStore() *checkresult.Store {
+	return checkresult.NewStore(checkresult.DefaultDir)
+}
+
+// checkResultsRetention returns check_results.retention, or the default
+func checkResultsRetention(cfg *config.Config) (time.Duration, error) {
+	if cfg.CheckResults.Retention == "" {
+		return checkresult.DefaultRetention, nil
+	}
+	d, err := chatops.ParseDuration(cfg.CheckResults.Retention)
+	if err != nil {
+		return 0, fmt.Errorf("check_results.retention: %w", err)
+	}
+	return d, nil
+}
+
+// parseSince parses a lookback duration (24h, 7d) or an RFC 3339 time
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := chatops.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q: expected a duration such as 24h or 7d, or an RFC 3339 time", s)
+	}
+	return now.Add(-d), nil
+}
+
+// checkResultFilter builds a filter, rejecting unknown statuses so a typo
+// does not read as "no failures"
+func checkResultFilter(target, check, status, since string) (checkresult.Filter, error) {
+	f := checkresult.Filter{Target: target, Check: check, Status: status}
+	if status != "" {
+		if err := (checkresult.Result{Target: "-", Check: "-", Status: status}).Validate(); err != nil {
+			return f, err
+		}
+	}
+	if since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return f, err
+		}
+		f.Since = t
+	}
+	return f, nil
+}
+
+func runCheckResultsList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(checkResultsOutput)
+	if err != nil {
+		return err
+	}
+	f, err := checkResultFilter(checkResultsTarget, checkResultsCheck, checkResultsStatus, checkResultsSince)
+	if err != nil {
+		return err
+	}
+	page, err := newCheckResultStore().List(f, checkResultsPage, checkResultsLimit)
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		return writeOutput(format, page)
+	}
+	if page.Total == 0 {
+		fmt.Println(i18n.T("No check results."))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Reported"), i18n.T("Target"), i18n.T("Check"), i18n.T("Status"), i18n.T("Duration"), i18n.T("Detail")})
+	table.SetAutoWrapText(false)
+	for _, r := range page.Results {
+		table.Append([]string{
+			r.ReportedAt.Local().Format("01-02 15:04:05"),
+			r.Target,
+			r.Check,
+			colorCheckStatus(r.Status),
+			formatCheckDuration(r.DurationMS),
+			checkResultDetail(r),
+		})
+	}
+	table.Render()
+
+	last := (page.Total + page.Limit - 1) / page.Limit
+	fmt.Println(i18n.T("Page %d of %d (%d results)", page.Page, last, page.Total))
+	return nil
+}
+
+func runCheckResultsSummary(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(checkResultsOutput)
+	if err != nil {
+		return err
+	}
+	since, err := parseSince(checkResultsSince, time.Now())
+	if err != nil {
+		return err
+	}
+	summary, err := newCheckResultStore().Summary(checkresult.Filter{Since: since})
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		return writeOutput(format, summary)
+	}
+	if summary.Runs == 0 {
+		fmt.Println(i18n.T("No check results."))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Check"), i18n.T("Targets"), i18n.T("Passing"), i18n.T("Failing"), i18n.T("Runs"), i18n.T("Failures"), i18n.T("Last Failure")})
+	for _, c := range summary.Checks {
+		lastFailure := "-"
+		if c.LastFailure != nil {
+			lastFailure = c.LastFailure.Local().Format("01-02 15:04")
+		}
+		table.Append([]string{
+			c.Check,
+			strconv.Itoa(c.Targets),
+			strconv.Itoa(c.Passing),
+			strconv.Itoa(c.Failing),
+			strconv.Itoa(c.Runs),
+			strconv.Itoa(c.Failures),
+			lastFailure,
+		})
+	}
+	table.Render()
+
+	if len(summary.Failing) > 0 {
+		red := color.New(color.FgRed).SprintFunc()
+		fmt.Println()
+		fmt.Println(i18n.T("Failing now:"))
+		for _, r := range summary.Failing {
+			line := fmt.Sprintf("%s/%s: %s", r.Target, r.Check, r.Status)
+			if detail := checkResultDetail(r); detail != "" {
+				line += " " + detail
+			}
+			fmt.Printf("  %s %s\n", red("✗"), line)
+		}
+	}
+	return nil
+}
+
+func runCheckResultsPrune(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	retention, err := checkResultsRetention(cfg)
+	if err != nil {
+		return err
+	}
+	removed, err := newCheckResultStore().Prune(retention)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Removed %d day(s) of check results older than %s", removed, defaultString(cfg.CheckResults.Retention, "30d")))
+	return nil
+}
+
+func colorCheckStatus(status string) string {
+	switch status {
+	case checkresult.StatusPassed:
+		return color.GreenString(status)
+	case checkresult.StatusFailed:
+		return color.RedString(status)
+	default:
+		return color.YellowString(status)
+	}
+}
+
+// formatCheckDuration formats a check's run time, in milliseconds below a
+// second
+func formatCheckDuration(ms int64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return formatDuration(time.Duration(ms) * time.Millisecond)
+}
+
+// checkResultDetail returns the first line of a result's error, or of its
+// output if the check passed
+func checkResultDetail(r checkresult.Result) string {
+	text := r.Error
+	if text == "" && r.Status != checkresult.StatusPassed {
+		text = r.Output
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return truncate(line, 60)
+}
+
+// runCheckResultsServe serves /api/v1/check-results. The config is
+// reloaded on every request so token and node changes apply without a
+// restart.
+func runCheckResultsServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.CheckResults.Token == "" {
+		return fmt.Errorf("check_results.token is not configured")
+	}
+	if _, err := checkResultsRetention(cfg); err != nil {
+		return err
+	}
+
+	store := newCheckResultStore()
+	authority := newAgentAuthority()
+	prune := func() {
+		cfg, err := readConfig()
+		if err == nil {
+			var retention time.Duration
+			if retention, err = checkResultsRetention(cfg); err == nil {
+				_, err = store.Prune(retention)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s prune check results: %v\n", yellow("•"), err)
+		}
+	}
+	prune()
+	go func() {
+		for range time.Tick(checkResultsPruneInterval) {
+			prune()
+		}
+	}()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		switch {
+		case r.Method == http.MethodPost && path == "/check-results":
+			claims, err := agentCredential(r, cfg, authority)
+			if err != nil {
+				writeAgentAuthError(w, err)
+				return
+			}
+			results, err := decodeCheckResults(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for i, res := range results {
+				if claims != nil && res.Target != claims.Target() {
+					http.Error(w, fmt.Sprintf("result %d: credential of %s cannot report for %s", i, claims.Target(), res.Target), http.StatusForbidden)
+					return
+				}
+				if !hasNodeConfig(cfg, res.Target) {
+					http.Error(w, fmt.Sprintf("result %d: unknown target: %s", i, res.Target), http.StatusBadRequest)
+					return
+				}
+			}
+			stored, err := store.Add(results)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusCreated, map[string]interface{}{
+				"accepted": len(stored),
+				"results":  stored,
+			})
+
+		case r.Method == http.MethodGet && (path == "/check-results" || path == "/check-results/summary"):
+			// API keys read only the results of their namespace's nodes
+			t, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			q := r.URL.Query()
+
+			if path == "/check-results/summary" {
+				since, err := parseSince(defaultString(q.Get("since"), "24h"), time.Now())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				summary, err := store.Summary(checkresult.Filter{Since: since, Targets: t.Nodes(cfg)})
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				writeSilenceJSON(w, http.StatusOK, summary)
+				return
+			}
+
+			f, err := checkResultFilter(q.Get("target"), q.Get("check"), q.Get("status"), q.Get("since"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.Targets = t.Nodes(cfg)
+			page, err := queryInt(q.Get("page"), 1)
+			if err != nil {
+				http.Error(w, "invalid page: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			limit, err := queryInt(q.Get("limit"), checkResultsDefaultLimit)
+			if err != nil {
+				http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if limit > checkResultsMaxLimit {
+				limit = checkResultsMaxLimit
+			}
+			result, err := store.List(f, page, limit)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, result)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	hardwareStore := newHardwareStore()
+	gpuValidationStore := newGPUValidationStore()
+	bmcStore := newBMCStore()
+	inventoryHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/debug-bundles") {
+			handleTargetDebugBundles(w, r, cfg, authority)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/gpu-validations") {
+			handleTargetGPUValidations(w, r, cfg, gpuValidationStore, authority)
+			return
+		}
+		if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/bmc") {
+			handleTargetBMC(w, r, cfg, bmcStore, authority)
+			return
+		}
+		handleTargetInventory(w, r, cfg, hardwareStore, authority)
+	})
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.Handle("/check-results", handler)
+	v1.Handle("/check-results/", handler)
+	v1.Handle("/targets/", inventoryHandler)
+
+	if checkResultsTLSCert == "" && !cfg.AgentAuth.MTLS {
+		fmt.Printf("%s Serving check results on http://%s/api/v1/check-results\n", green("✓"), checkResultsListen)
+		return http.ListenAndServe(checkResultsListen, mux)
+	}
+
+	hosts := checkResultsTLSHosts
+	if len(hosts) == 0 {
+		host, _ := os.Hostname()
+		hosts = []string{host, "localhost", "127.0.0.1"}
+	}
+	tlsConfig, err := agentTLSConfig(cfg, checkResultsTLSCert, checkResultsTLSKey, hosts)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: checkResultsListen, Handler: mux, TLSConfig: tlsConfig}
+	fmt.Printf("%s Serving check results on https://%s/api/v1/check-results\n", green("✓"), checkResultsListen)
+	if cfg.AgentAuth.MTLS {
+		fmt.Printf("%s Agents must present a client certificate of the agent CA\n", green("✓"))
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// decodeCheckResults reads a POST body: either one result or
+// {"results": [...]}
+func decodeCheckResults(r *http.Request) ([]checkresult.Result, error) {
+	data, err := readLimited(r, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch struct {
+		Results []checkresult.Result `json:"results"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("parse request: %v", err)
+	}
+	if batch.Results == nil {
+		var single checkresult.Result
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("parse request: %v", err)
+		}
+		batch.Results = []checkresult.Result{single}
+	}
+	if len(batch.Results) == 0 {
+		return nil, fmt.Errorf("no results")
+	}
+	if len(batch.Results) > checkResultsMaxBatch {
+		return nil, fmt.Errorf("too many results: %d (at most %d per request)", len(batch.Results), checkResultsMaxBatch)
+	}
+	return batch.Results, nil
+}
+
+// readLimited reads a request body of at most max bytes
+func readLimited(r *http.Request, max int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(http.MaxBytesReader(nil, r.Body, max)); err != nil {
+		return nil, fmt.Errorf("read request: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func queryInt(s string, fallback int) (int, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func defaultString(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/config"
 	"github.com/fregataa/aami/internal/multicluster"
+	"github.com/fregataa/aami/internal/output"
 )
 
 var clustersCmd = &cobra.Command{
@@ -29,7 +35,8 @@ Examples:
   aami clusters add prod-east --endpoint https://aami-east.example.com
   aami clusters list
   aami clusters status
-  aami clusters alerts`,
+  aami clusters alerts
+  aami clusters query 'sum(DCGM_FI_DEV_FB_USED)'`,
 }
 
 var clustersAddCmd = &cobra.Command{
@@ -69,7 +76,20 @@ var clustersStatusCmd = &cobra.Command{
 var clustersAlertsCmd = &cobra.Command{
 	Use:   "alerts",
 	Short: "Show alerts from all clusters",
-	RunE:  runClustersAlerts,
+	Long: `Show the active alerts of all registered clusters.
+
+With --correlate, alerts reported twice are dropped and the rest are grouped
+by rule and labels, leaving out the cluster, node and GPU they fired for.
+An alert that started firing in --min-clusters clusters within --window is
+shown as a fleet-wide incident: a shared cause such as a driver rollout or
+an upstream network fault is more likely than a local fault.
+
+Examples:
+  aami clusters alerts
+  aami clusters alerts --severity critical
+  aami clusters alerts --correlate
+  aami clusters alerts --correlate --min-clusters 3 --window 30m`,
+	RunE: runClustersAlerts,
 }
 
 var clustersTestCmd = &cobra.Command{
@@ -93,6 +113,70 @@ var clustersInfoCmd = &cobra.Command{
 	RunE:  runClustersInfo,
 }
 
+var clustersWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Poll all clusters and record their status",
+	Long: `Poll the status of every registered cluster on an interval and keep the
+snapshots, one JSON Lines file per day, for aami clusters history. Clusters
+added to or removed from the registry are picked up on the next poll, and
+days older than --retention are removed.
+
+Run it as a service on one host per team; aami clusters history reads the
+snapshots it keeps.
+
+Examples:
+  aami clusters watch
+  aami clusters watch --interval 30s --retention 30d`,
+	RunE: runClustersWatch,
+}
+
+var clustersHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show availability and health trends of all clusters",
+	Long: `Show the availability (share of polls a cluster answered) and health
+score of every cluster per day, from the snapshots aami clusters watch keeps.
+
+Examples:
+  aami clusters history
+  aami clusters history --days 30 --cluster prod-east
+  aami clusters history -o json`,
+	RunE: runClustersHistory,
+}
+
+var clustersImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import clusters from a clusters.yaml",
+	Long: `Add the clusters of a clusters.yaml to the registry, replacing clusters
+of the same name.
+
+Use it to move a local registry to a shared backend: set cluster_registry in
+the config, then import the old file.
+
+Examples:
+  aami clusters import /etc/aami/clusters.yaml
+  aami clusters import ~/clusters.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: runClustersImport,
+}
+
+var clustersQueryCmd = &cobra.Command{
+	Use:   "query <promql>",
+	Short: "Run a PromQL query on every cluster",
+	Long: `Run an instant PromQL query on the Prometheus of every registered
+cluster and merge the results, each series labelled with its cluster.
+
+A cluster is queried at its --prometheus-url, or else through the query API
+of its endpoint (an aami query-proxy), with its API key as bearer token.
+Clusters that cannot answer are listed after the results.
+
+Examples:
+  aami clusters query 'sum(DCGM_FI_DEV_FB_USED)'
+  aami clusters query 'count(DCGM_FI_DEV_GPU_UTIL < 10)' --cluster prod-east
+  aami clusters query 'avg(DCGM_FI_DEV_GPU_UTIL)' --time 2026-10-01T00:00:00Z -o json`,
+	Args: cobra.ExactArgs(1),
+	RunE: runClustersQuery,
+}
+
 // Flags
 var (
 	clusterEndpoint  string
@@ -104,6 +188,26 @@ var (
 	clusterLabels    []string
 	alertsSeverity   string
 	alertsLimit      int
+
+	alertsCorrelate   bool
+	alertsMinClusters int
+	alertsWindow      time.Duration
+
+	clusterPrometheusURL string
+	clustersQueryTime    string
+	clustersQueryNames   []string
+	clustersQueryOutput  string
+	clustersQueryTimeout time.Duration
+
+	clustersWatchInterval  time.Duration
+	clustersWatchRetention string
+	clustersHistoryDir     string
+	clustersHistoryDays    int
+	clustersHistoryNames   []string
+	clustersHistoryOutput  string
+
+	clustersListOutput   string
+	clustersStatusOutput string
 )
 
 func init() {
@@ -122,13 +226,48 @@ func init() {
 		"Skip TLS certificate verification")
 	clustersAddCmd.Flags().StringSliceVar(&clusterLabels, "label", nil,
 		"Labels for the cluster (key=value)")
+	clustersAddCmd.Flags().StringVar(&clusterPrometheusURL, "prometheus-url", "",
+		"Prometheus URL for aami clusters query (default: the endpoint's query API)")
 	clustersAddCmd.MarkFlagRequired("endpoint")
 
+	// Query flags
+	clustersQueryCmd.Flags().StringVar(&clustersQueryTime, "time", "",
+		"Evaluation time (RFC3339, default: now)")
+	clustersQueryCmd.Flags().StringSliceVar(&clustersQueryNames, "cluster", nil,
+		"Query only these clusters (repeatable)")
+	addOutputFlag(clustersQueryCmd, &clustersQueryOutput)
+	clustersQueryCmd.Flags().DurationVar(&clustersQueryTimeout, "timeout", 30*time.Second,
+		"Time to wait for the slowest cluster")
+
+	// Watch and history flags
+	clustersWatchCmd.Flags().DurationVar(&clustersWatchInterval, "interval", time.Minute,
+		"Time between polls")
+	clustersWatchCmd.Flags().StringVar(&clustersWatchRetention, "retention", "90d",
+		"How long snapshots are kept")
+	for _, cmd := range []*cobra.Command{clustersWatchCmd, clustersHistoryCmd} {
+		cmd.Flags().StringVar(&clustersHistoryDir, "dir", multicluster.DefaultHistoryDir,
+			"Snapshot directory")
+	}
+	clustersHistoryCmd.Flags().IntVar(&clustersHistoryDays, "days", 7,
+		"Days of history to show, today included")
+	clustersHistoryCmd.Flags().StringSliceVar(&clustersHistoryNames, "cluster", nil,
+		"Show only these clusters (repeatable)")
+	addOutputFlag(clustersHistoryCmd, &clustersHistoryOutput)
+	addOutputFlag(clustersListCmd, &clustersListOutput)
+	addOutputFlag(clustersStatusCmd, &clustersStatusOutput)
+
 	// Alerts flags
 	clustersAlertsCmd.Flags().StringVar(&alertsSeverity, "severity", "",
 		"Filter by severity (critical, warning, info)")
 	clustersAlertsCmd.Flags().IntVar(&alertsLimit, "limit", 50,
 		"Maximum number of alerts to show")
+	clustersAlertsCmd.Flags().BoolVar(&alertsCorrelate, "correlate", false,
+		"Group identical alerts across clusters and show fleet-wide incidents")
+	correlation := multicluster.DefaultCorrelationPolicy()
+	clustersAlertsCmd.Flags().IntVar(&alertsMinClusters, "min-clusters", correlation.MinClusters,
+		"Clusters an alert must fire in to be a fleet-wide incident")
+	clustersAlertsCmd.Flags().DurationVar(&alertsWindow, "window", correlation.Window,
+		"Time within which it must start firing in each of them (0: any time)")
 
 	// Add subcommands
 	clustersCmd.AddCommand(clustersAddCmd)
@@ -138,14 +277,36 @@ func init() {
 	clustersCmd.AddCommand(clustersAlertsCmd)
 	clustersCmd.AddCommand(clustersTestCmd)
 	clustersCmd.AddCommand(clustersInfoCmd)
+	clustersCmd.AddCommand(clustersQueryCmd)
+	clustersCmd.AddCommand(clustersImportCmd)
+	clustersCmd.AddCommand(clustersWatchCmd)
+	clustersCmd.AddCommand(clustersHistoryCmd)
 	rootCmd.AddCommand(clustersCmd)
 }
 
+// getRegistry loads the cluster registry from the backend in the config's
+// cluster_registry, or from /etc/aami/clusters.yaml without a config
 func getRegistry() (*multicluster.Registry, error) {
-	registryPath := "/etc/aami/clusters.yaml"
-	registry := multicluster.NewRegistry(registryPath)
+	var settings config.RegistryConfig
+	path := cfgFile
+	if path == "" {
+		path = config.DefaultConfigPath
+	}
+	if _, err := os.Stat(path); err == nil {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		settings = cfg.Registry
+	}
+
+	store, err := multicluster.NewStore(settings)
+	if err != nil {
+		return nil, err
+	}
+	registry := multicluster.NewRegistryWithStore(store)
 	if err := registry.Load(); err != nil {
-		return nil, fmt.Errorf("load registry: %w", err)
+		return nil, fmt.Errorf("load registry from %s: %w", store.Location(), err)
 	}
 	return registry, nil
 }
@@ -176,6 +337,8 @@ func runClustersAdd(cmd *cobra.Command, args []string) error {
 		TLSCACert: clusterTLSCACert,
 		SkipTLS:   clusterSkipTLS,
 		Labels:    labels,
+
+		PrometheusURL: clusterPrometheusURL,
 	}
 
 	// Test connection
@@ -212,21 +375,56 @@ func runClustersAdd(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// clusterListing is a registered cluster as listed, without its credentials
+type clusterListing struct {
+	Name          string            `json:"name"`
+	Endpoint      string            `json:"endpoint"`
+	PrometheusURL string            `json:"prometheus_url,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	TLS           bool              `json:"tls_client_cert"`
+	SkipTLS       bool              `json:"skip_tls_verify"`
+}
+
 func runClustersList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(clustersListOutput)
+	if err != nil {
+		return err
+	}
 	registry, err := getRegistry()
 	if err != nil {
 		return err
 	}
 
 	clusters := registry.List()
+	if format.Structured() {
+		listing := make([]clusterListing, 0, len(clusters))
+		for _, c := range clusters {
+			listing = append(listing, clusterListing{
+				Name:          c.Name,
+				Endpoint:      c.Endpoint,
+				PrometheusURL: c.PrometheusURL,
+				Labels:        c.Labels,
+				TLS:           c.TLSCert != "",
+				SkipTLS:       c.SkipTLS,
+			})
+		}
+		return writeOutput(format, listing)
+	}
 	if len(clusters) == 0 {
 		fmt.Println("No clusters registered.")
 		fmt.Println("\nUse 'aami clusters add <name> --endpoint <url>' to add a cluster.")
 		return nil
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Endpoint", "Labels"})
+	columns := output.Columns{
+		{Header: "Name"},
+		{Header: "Endpoint"},
+		{Header: "Labels"},
+		{Header: "Prometheus", Wide: true},
+		{Header: "TLS", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
 	table.SetBorder(false)
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
@@ -240,13 +438,186 @@ func runClustersList(cmd *cobra.Command, args []string) error {
 			}
 			labelStr = strings.Join(parts, ", ")
 		}
-		table.Append([]string{c.Name, c.Endpoint, labelStr})
+		tls := "-"
+		switch {
+		case c.SkipTLS:
+			tls = "insecure"
+		case c.TLSCert != "":
+			tls = "client cert"
+		}
+		table.Append(columns.Row(format, c.Name, c.Endpoint, labelStr, defaultString(c.PrometheusURL, "-"), tls))
 	}
 
 	table.Render()
 	return nil
 }
 
+func runClustersImport(cmd *cobra.Command, args []string) error {
+	source := multicluster.NewRegistry(args[0])
+	if err := source.Load(); err != nil {
+		return fmt.Errorf("load %s: %w", args[0], err)
+	}
+	clusters := source.List()
+	if len(clusters) == 0 {
+		return fmt.Errorf("no clusters in %s", args[0])
+	}
+
+	registry, err := getRegistry()
+	if err != nil {
+		return err
+	}
+	if err := registry.Import(clusters); err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Imported %d clusters into %s\n", green("✓"), len(clusters), registry.Location())
+	return nil
+}
+
+func runClustersWatch(cmd *cobra.Command, args []string) error {
+	if clustersWatchInterval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	retention, err := chatops.ParseDuration(clustersWatchRetention)
+	if err != nil || retention <= 0 {
+		return fmt.Errorf("invalid --retention %q", clustersWatchRetention)
+	}
+
+	registry, err := getRegistry()
+	if err != nil {
+		return err
+	}
+	store := multicluster.NewHistoryStore(clustersHistoryDir)
+	collector := multicluster.NewCollector(registry, store, retention)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Polling clusters every %s, keeping snapshots in %s\n", green("✓"), clustersWatchInterval, clustersHistoryDir)
+
+	// Log every poll, and the clusters whose connection changed
+	connected := make(map[string]bool)
+	collector.Run(ctx, clustersWatchInterval, func(snapshot multicluster.Snapshot, err error) {
+		if err != nil {
+			discoveryLogf("clusters watch: %v", err)
+			return
+		}
+		up := 0
+		for _, s := range snapshot.Clusters {
+			if s.Connected {
+				up++
+			}
+			was, seen := connected[s.Name]
+			switch {
+			case s.Connected && seen && !was:
+				discoveryLogf("%s reconnected", s.Name)
+			case !s.Connected && (!seen || was):
+				discoveryLogf("%s unreachable: %s", s.Name, s.Error)
+			}
+			connected[s.Name] = s.Connected
+		}
+		discoveryLogf("%d/%d clusters connected", up, len(snapshot.Clusters))
+	})
+	return nil
+}
+
+func runClustersHistory(cmd *cobra.Command, args []string) error {
+	if clustersHistoryDays < 1 {
+		return fmt.Errorf("--days must be at least 1")
+	}
+	format, err := output.Parse(clustersHistoryOutput)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-clustersHistoryDays)
+	snapshots, err := multicluster.NewHistoryStore(clustersHistoryDir).Since(since)
+	if err != nil {
+		return err
+	}
+
+	trends := multicluster.Trends(snapshots)
+	if len(clustersHistoryNames) > 0 {
+		wanted := make(map[string]bool)
+		for _, name := range clustersHistoryNames {
+			wanted[name] = true
+		}
+		var filtered []multicluster.ClusterTrend
+		for _, t := range trends {
+			if wanted[t.Cluster] {
+				filtered = append(filtered, t)
+			}
+		}
+		trends = filtered
+	}
+
+	if format.Structured() {
+		if trends == nil {
+			trends = []multicluster.ClusterTrend{}
+		}
+		return writeOutput(format, trends)
+	}
+
+	if len(trends) == 0 {
+		fmt.Printf("No snapshots since %s.\n", since.Format("2006-01-02"))
+		fmt.Println("\nRun 'aami clusters watch' to record the status of the clusters.")
+		return nil
+	}
+
+	fmt.Printf("Cluster History (%d days, since %s UTC)\n", clustersHistoryDays, since.Format("2006-01-02"))
+	fmt.Println(strings.Repeat("━", 80))
+
+	table := newTable()
+	table.SetHeader([]string{"Cluster", "Day", "Polls", "Availability", "Avg Health", "Min Health", "Max Alerts"})
+	table.SetBorder(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, t := range trends {
+		for i, d := range t.Days {
+			name := ""
+			if i == 0 {
+				name = t.Cluster
+			}
+			avg, minHealth := "-", "-"
+			if d.Availability > 0 {
+				avg, minHealth = fmt.Sprintf("%.0f%%", d.AvgHealth), fmt.Sprintf("%.0f%%", d.MinHealth)
+			}
+			table.Append([]string{
+				name,
+				d.Day,
+				fmt.Sprintf("%d", d.Polls),
+				formatAvailability(d.Availability),
+				avg,
+				minHealth,
+				fmt.Sprintf("%d", d.MaxAlerts),
+			})
+		}
+		avg := "-"
+		if t.Availability > 0 {
+			avg = fmt.Sprintf("%.0f%%", t.AvgHealth)
+		}
+		table.Append([]string{"", "total", fmt.Sprintf("%d", t.Polls), formatAvailability(t.Availability), avg, "", ""})
+	}
+	table.Render()
+	return nil
+}
+
+// formatAvailability colors an availability percentage
+func formatAvailability(pct float64) string {
+	s := fmt.Sprintf("%.2f%%", pct)
+	switch {
+	case pct >= 99.9:
+		return color.New(color.FgGreen).Sprint(s)
+	case pct >= 99:
+		return color.New(color.FgYellow).Sprint(s)
+	default:
+		return color.New(color.FgRed).Sprint(s)
+	}
+}
+
 func runClustersRemove(cmd *cobra.Command, args []string) error {
 	name := args[0]
 
@@ -269,6 +640,10 @@ func runClustersRemove(cmd *cobra.Command, args []string) error {
 }
 
 func runClustersStatus(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(clustersStatusOutput)
+	if err != nil {
+		return err
+	}
 	registry, err := getRegistry()
 	if err != nil {
 		return err
@@ -276,6 +651,9 @@ func runClustersStatus(cmd *cobra.Command, args []string) error {
 
 	clusters := registry.List()
 	if len(clusters) == 0 {
+		if format.Structured() {
+			return writeOutput(format, []multicluster.ClusterStatus{})
+		}
 		fmt.Println("No clusters registered.")
 		return nil
 	}
@@ -289,16 +667,30 @@ func runClustersStatus(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	fmt.Println("Multi-Cluster Status")
-	fmt.Println(strings.Repeat("━", 70))
-
 	statuses, err := aggregator.GetAggregatedStatus(ctx)
 	if err != nil {
 		return err
 	}
+	if format.Structured() {
+		return writeOutput(format, statuses)
+	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Cluster", "Nodes", "GPUs", "Health", "Alerts", "Status"})
+	fmt.Println("Multi-Cluster Status")
+	fmt.Println(strings.Repeat("━", 70))
+
+	columns := output.Columns{
+		{Header: "Cluster"},
+		{Header: "Nodes"},
+		{Header: "GPUs"},
+		{Header: "Health"},
+		{Header: "Alerts"},
+		{Header: "Status"},
+		{Header: "Endpoint", Wide: true},
+		{Header: "Version", Wide: true},
+		{Header: "Last Sync", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
 	table.SetBorder(false)
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
@@ -354,14 +746,21 @@ func runClustersStatus(cmd *cobra.Command, args []string) error {
 			}
 		}
 
-		table.Append([]string{
+		lastSync := "-"
+		if !status.LastSync.IsZero() {
+			lastSync = status.LastSync.Local().Format("2006-01-02 15:04:05")
+		}
+		table.Append(columns.Row(format,
 			status.Name,
 			fmt.Sprintf("%d", status.Nodes),
 			fmt.Sprintf("%d", status.TotalGPUs),
 			healthStr,
 			alertStr,
 			statusStr,
-		})
+			status.Endpoint,
+			defaultString(status.Version, "-"),
+			lastSync,
+		))
 	}
 
 	table.Render()
@@ -416,6 +815,12 @@ func runClustersAlerts(cmd *cobra.Command, args []string) error {
 		alerts = filtered
 	}
 
+	if alertsCorrelate {
+		policy := multicluster.CorrelationPolicy{MinClusters: alertsMinClusters, Window: alertsWindow}
+		printCorrelatedAlerts(multicluster.CorrelateAlerts(alerts, policy), policy)
+		return nil
+	}
+
 	// Limit
 	if len(alerts) > alertsLimit {
 		alerts = alerts[:alertsLimit]
@@ -454,6 +859,85 @@ func runClustersAlerts(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func printCorrelatedAlerts(c *multicluster.AlertCorrelation, policy multicluster.CorrelationPolicy) {
+	if len(c.Groups) == 0 {
+		fmt.Println("No active alerts across all clusters.")
+		return
+	}
+
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	cyan := color.New(color.FgCyan).SprintFunc()
+	severity := func(s string) string {
+		switch s {
+		case "critical":
+			return red("CRITICAL")
+		case "warning":
+			return yellow("WARNING")
+		case "info":
+			return cyan("INFO")
+		}
+		return s
+	}
+
+	if incidents := c.Incidents(); len(incidents) > 0 {
+		fmt.Printf("Fleet-wide Incidents (%d)\n", len(incidents))
+		fmt.Println(strings.Repeat("━", 80))
+		for _, g := range incidents {
+			fmt.Printf("[%s] %s", severity(g.Severity), g.AlertName)
+			if len(g.Labels) > 0 {
+				fmt.Printf(" {%s}", multicluster.FormatLabels(g.Labels))
+			}
+			fmt.Println()
+			fmt.Printf("  Clusters: %d (%s) | Nodes: %d\n", len(g.Clusters), strings.Join(g.Clusters, ", "), g.Nodes)
+			fmt.Printf("  Since: %s", g.FirstFired.Format("2006-01-02 15:04:05"))
+			if policy.Window > 0 {
+				fmt.Printf(" | Started in %d clusters within %s", g.Burst, policy.Window)
+			}
+			fmt.Println()
+			fmt.Println()
+		}
+	} else {
+		fmt.Printf("No fleet-wide incidents (an alert firing in %d+ clusters", policy.MinClusters)
+		if policy.Window > 0 {
+			fmt.Printf(", starting within %s", policy.Window)
+		}
+		fmt.Print(").\n\n")
+	}
+
+	groups := c.Groups
+	if len(groups) > alertsLimit {
+		groups = groups[:alertsLimit]
+	}
+
+	fmt.Printf("Alert Groups (%d)\n", len(c.Groups))
+	table := newTable()
+	table.SetHeader([]string{"Severity", "Alert", "Labels", "Clusters", "Nodes", "Since"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, g := range groups {
+		name := g.AlertName
+		if g.FleetWide {
+			name += " " + red("(fleet-wide)")
+		}
+		table.Append([]string{
+			severity(g.Severity),
+			name,
+			multicluster.FormatLabels(g.Labels),
+			strings.Join(g.Clusters, ", "),
+			fmt.Sprintf("%d", g.Nodes),
+			g.FirstFired.Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render()
+
+	if c.Duplicates > 0 {
+		fmt.Printf("\n%d duplicate alerts dropped.\n", c.Duplicates)
+	}
+}
+
 func runClustersTest(cmd *cobra.Command, args []string) error {
 	registry, err := getRegistry()
 	if err != nil {
@@ -488,10 +972,12 @@ func runClustersTest(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	failed := 0
 	for _, cfg := range clustersToTest {
 		client, err := multicluster.NewClient(cfg)
 		if err != nil {
 			fmt.Printf("%s %s: Failed to create client: %v\n", red("✗"), cfg.Name, err)
+			failed++
 			continue
 		}
 
@@ -500,12 +986,13 @@ func runClustersTest(cmd *cobra.Command, args []string) error {
 
 		if err != nil {
 			fmt.Printf("%s %s: %v\n", red("✗"), cfg.Name, err)
+			failed++
 		} else {
 			fmt.Printf("%s %s: Connection successful\n", green("✓"), cfg.Name)
 		}
 	}
 
-	return nil
+	return countError(failed, len(clustersToTest), "clusters")
 }
 
 func runClustersInfo(cmd *cobra.Command, args []string) error {
@@ -585,3 +1072,97 @@ func runClustersInfo(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func runClustersQuery(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(clustersQueryOutput)
+	if err != nil {
+		return err
+	}
+	ts := time.Now()
+	if clustersQueryTime != "" {
+		t, err := time.Parse(time.RFC3339, clustersQueryTime)
+		if err != nil {
+			return fmt.Errorf("invalid --time: %w", err)
+		}
+		ts = t
+	}
+
+	registry, err := getRegistry()
+	if err != nil {
+		return err
+	}
+	if registry.Count() == 0 {
+		fmt.Println("No clusters registered.")
+		return nil
+	}
+
+	aggregator := multicluster.NewAggregator(registry)
+	if err := aggregator.Initialize(); err != nil {
+		return err
+	}
+	defer aggregator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), clustersQueryTimeout)
+	defer cancel()
+
+	result, err := aggregator.Query(ctx, args[0], ts, clustersQueryNames)
+	if err != nil {
+		return err
+	}
+
+	queried := len(clustersQueryNames)
+	if queried == 0 {
+		queried = registry.Count()
+	}
+	failed := countError(len(result.Errors), queried, "clusters")
+
+	if format.Structured() {
+		if err := writeOutput(format, result); err != nil {
+			return err
+		}
+		return failed
+	}
+
+	if len(result.Series) == 0 {
+		fmt.Println("No results.")
+	} else {
+		table := newTable()
+		table.SetHeader([]string{"Cluster", "Series", "Value"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+
+		for _, s := range result.Series {
+			metric := make(map[string]string)
+			for k, v := range s.Metric {
+				if k != "cluster" {
+					metric[k] = v
+				}
+			}
+			// The latest value of a range vector, and how many there are
+			last := s.Points[len(s.Points)-1]
+			value := last.Value
+			if len(s.Points) > 1 {
+				value = fmt.Sprintf("%s (%d samples)", last.Value, len(s.Points))
+			}
+			table.Append([]string{s.Cluster, multicluster.FormatMetric(metric), value})
+		}
+		table.Render()
+	}
+
+	if len(result.Errors) > 0 {
+		red := color.New(color.FgRed).SprintFunc()
+		fmt.Println()
+		var names []string
+		for name := range result.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s %s: %s\n", red("✗"), name, result.Errors[name])
+		}
+	}
+
+	return failed
+}
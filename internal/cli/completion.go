@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/configserver"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion <bash|zsh|fish|powershell>",
+	Short: "Generate shell completion scripts",
+	Long: `Generate the completion script of a shell. Besides commands and flags,
+it completes real resources: node names from the config, and target
+hostnames, group names and alert rule IDs from the config server when one
+is configured (the server and token of the active context, else
+slurm.prolog.config_server_url or config_server_url of /etc/aami/agent.yaml,
+with admin.token).
+
+Bash (needs the bash-completion package):
+  aami completion bash > /etc/bash_completion.d/aami
+
+Zsh:
+  aami completion zsh > "${fpath[1]}/_aami"
+
+Fish:
+  aami completion fish > ~/.config/fish/completions/aami.fish
+
+PowerShell:
+  aami completion powershell | Out-String | Invoke-Expression`,
+	Args:                  cobra.ExactArgs(1),
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	DisableFlagsInUseLine: true,
+	RunE:                  runCompletion,
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
+
+func runCompletion(cmd *cobra.Command, args []string) error {
+	switch args[0] {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		return rootCmd.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		return rootCmd.GenPowerShellCompletionWithDesc(os.Stdout)
+	default:
+		return cmd.Usage()
+	}
+}
+
+// completionTimeout bounds the config server requests made while
+// completing, so a slow server does not hang the shell
+const completionTimeout = 2 * time.Second
+
+// completionFunc completes an argument or flag value
+type completionFunc func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// firstArg completes only the first argument with f
+func firstArg(f completionFunc) completionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return f(cmd, args, toComplete)
+	}
+}
+
+// completions returns the candidates starting with toComplete, each as
+// "value\tdescription", sorted and without duplicates
+func completions(candidates map[string]string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var out []string
+	for value, desc := range candidates {
+		if !strings.HasPrefix(value, toComplete) {
+			continue
+		}
+		if desc != "" {
+			value += "\t" + desc
+		}
+		out = append(out, value)
+	}
+	sort.Strings(out)
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeNodes completes the names of the nodes in the config
+func completeNodes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	if cfg, err := loadConfig(); err == nil {
+		for _, n := range cfg.Nodes {
+			candidates[n.Name] = n.IP
+		}
+	}
+	return completions(candidates, toComplete)
+}
+
+// completeTargets completes the hostnames of the config server's targets
+// and the nodes in the config
+func completeTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	withConfigServer(func(ctx context.Context, client *configserver.Client) {
+		targets, err := client.ListTargets(ctx)
+		if err != nil {
+			return
+		}
+		for _, t := range targets {
+			candidates[t.Hostname] = t.IPAddress
+		}
+	})
+	if cfg, err := loadConfig(); err == nil {
+		for _, n := range cfg.Nodes {
+			if _, ok := candidates[n.Name]; !ok {
+				candidates[n.Name] = n.IP
+			}
+		}
+	}
+	return completions(candidates, toComplete)
+}
+
+// completeGroups completes the names of the config server's groups
+func completeGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	withConfigServer(func(ctx context.Context, client *configserver.Client) {
+		groups, err := client.ListGroups(ctx)
+		if err != nil {
+			return
+		}
+		for _, g := range groups {
+			candidates[g.Name] = g.Description
+		}
+	})
+	return completions(candidates, toComplete)
+}
+
+// completeAlertRules completes the IDs of the config server's alert rules,
+// described by their names
+func completeAlertRules(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	withConfigServer(func(ctx context.Context, client *configserver.Client) {
+		rules, err := client.ListAlertRules(ctx)
+		if err != nil {
+			return
+		}
+		for _, r := range rules {
+			candidates[r.ID] = r.Name
+		}
+	})
+	return completions(candidates, toComplete)
+}
+
+// completeAlertTemplates completes the IDs of the config server's alert
+// templates, described by their names
+func completeAlertTemplates(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	withConfigServer(func(ctx context.Context, client *configserver.Client) {
+		templates, err := client.ListAlertTemplates(ctx)
+		if err != nil {
+			return
+		}
+		for _, t := range templates {
+			candidates[t.ID] = t.Name
+		}
+	})
+	return completions(candidates, toComplete)
+}
+
+// completeAlertNames completes the alert names of the config server's
+// alert rules
+func completeAlertNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	withConfigServer(func(ctx context.Context, client *configserver.Client) {
+		rules, err := client.ListAlertRules(ctx)
+		if err != nil {
+			return
+		}
+		for _, r := range rules {
+			candidates[r.Name] = r.Severity
+		}
+	})
+	return completions(candidates, toComplete)
+}
+
+// withConfigServer calls f with a config server client if one is
+// configured. Errors are ignored: completion offers what it can.
+func withConfigServer(f func(ctx context.Context, client *configserver.Client)) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return
+	}
+	client, err := configServerClient(cfg, "")
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	f(ctx, client)
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+var (
+	patchFile   string
+	patchDryRun bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and change the AAMI configuration",
+}
+
+var configPatchCmd = &cobra.Command{
+	Use:   "patch [patch]",
+	Short: "Change configuration fields with a JSON merge patch",
+	Long: `Apply an RFC 7386 JSON merge patch to the configuration, so one field can
+be changed without rewriting the file. Objects are merged, null removes a
+field, and arrays are replaced as a whole; use 'aami nodes patch' and
+'aami alerts patch' to change a single node or custom rule.
+
+The patch is given as an argument or read from --file (- for stdin), in
+JSON or YAML. Field names are those of the config file.
+
+Examples:
+  aami config patch '{"prometheus": {"retention": "30d"}}'
+  aami config patch '{"notifications": {"slack": {"channel": "#gpu-oncall"}}}'
+  aami config patch '{"chatops": {"users": {"alice": "operator", "bob": null}}}'
+  aami config patch --file patch.yaml --dry-run`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigPatch,
+}
+
+func init() {
+	addPatchFlags(configPatchCmd)
+
+	configCmd.AddCommand(configPatchCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// addPatchFlags registers the flags shared by the patch commands
+func addPatchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&patchFile, "file", "f", "",
+		"Read the patch from a file (- for stdin)")
+	cmd.Flags().BoolVar(&patchDryRun, "dry-run", false,
+		"Print the result without saving")
+}
+
+// readPatch returns the patch from the argument at index i or --file
+func readPatch(args []string, i int) ([]byte, error) {
+	switch {
+	case len(args) > i && patchFile != "":
+		return nil, fmt.Errorf("give the patch as an argument or with --file, not both")
+	case len(args) > i:
+		return []byte(args[i]), nil
+	case patchFile == "-":
+		return io.ReadAll(os.Stdin)
+	case patchFile != "":
+		data, err := os.ReadFile(patchFile)
+		if err != nil {
+			return nil, fmt.Errorf("read patch: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("no patch given: pass it as an argument or with --file")
+	}
+}
+
+// savePatched prints v for --dry-run, or saves the config
+func savePatched(cfg *config.Config, version string, v interface{}, what string) error {
+	if patchDryRun {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	if _, err := saveConfig(cfg, version); err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Patched %s\n", green("✓"), what)
+	return nil
+}
+
+func runConfigPatch(cmd *cobra.Command, args []string) error {
+	patch, err := readPatch(args, 0)
+	if err != nil {
+		return err
+	}
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return err
+	}
+	if err := config.Patch(cfg, patch); err != nil {
+		return err
+	}
+	return savePatched(cfg, version, cfg, "configuration")
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+)
+
+// configServerURL returns the config server address: the flag, then the
+// server of the active context, then slurm.prolog.config_server_url, then
+// the node agent's config_server_url
+func configServerURL(cfg *config.Config, flag string) (string, error) {
+	if serverURL := defaultString(flag, currentContext().Server); serverURL != "" {
+		return serverURL, nil
+	}
+	if serverURL := cfg.Slurm.Prolog.ConfigServerURL; serverURL != "" {
+		return serverURL, nil
+	}
+	agent, err := readAgentSettings()
+	if err != nil {
+		return "", err
+	}
+	return agent.ConfigServerURL, nil
+}
+
+// configServerClient returns a client of the config server, authenticated
+// with the SSO login or token of the active context, else admin.token
+func configServerClient(cfg *config.Config, urlFlag string) (*configserver.Client, error) {
+	serverURL, err := configServerURL(cfg, urlFlag)
+	if err != nil {
+		return nil, err
+	}
+	if serverURL == "" {
+		return nil, fmt.Errorf("no config server: use --config-server-url or a context (aami context set), or set config_server_url in %s", agentConfigPath)
+	}
+	return configserver.NewClient(serverURL, configServerToken(cfg)), nil
+}
+
+// configServerTimeout bounds the config server requests of a command
+const configServerTimeout = 30 * time.Second
+
+// configServerRequest returns a client of the config server and a context
+// bounding the command's requests
+func configServerRequest(urlFlag string) (*configserver.Client, context.Context, context.CancelFunc, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client, err := configServerClient(cfg, urlFlag)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	return client, ctx, cancel, nil
+}
+
+// configServerToken returns the ID token of the active context's SSO
+// login, else the context's token, else admin.token
+func configServerToken(cfg *config.Config) string {
+	if token := loginToken(); token != "" {
+		return token
+	}
+	if c := currentContext(); c.Token != "" {
+		return c.BearerToken()
+	}
+	return cfg.Admin.Token
+}
@@ -0,0 +1,369 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/contexts"
+	"github.com/fregataa/aami/internal/output"
+)
+
+var (
+	contextName string // --context
+
+	contextServer          string
+	contextToken           string
+	contextNamespace       string
+	contextPrometheusURL   string
+	contextAlertmanagerURL string
+	contextListOutput      string
+)
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage contexts of config servers and endpoints",
+	Long: `Contexts name the config server, token, default namespace, Prometheus
+and Alertmanager that commands use, like the contexts of a kubeconfig.
+They are stored in ~/.aami/config.
+
+Commands use the context given with --context, else the one in
+$AAMI_CONTEXT, else the current context. Flags such as
+--config-server-url and --alertmanager-url still take precedence, and
+endpoints a context leaves empty are the local stack.
+
+Examples:
+  aami context set prod --server https://aami.example.com --token '${AAMI_PROD_TOKEN}' \
+    --prometheus-url https://prom.example.com --namespace team-a
+  aami context use prod
+  aami context list
+  aami --context staging status`,
+}
+
+var contextListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List contexts",
+	Args:  cobra.NoArgs,
+	RunE:  runContextList,
+}
+
+var contextUseCmd = &cobra.Command{
+	Use:               "use <name>",
+	Short:             "Make a context current",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeContexts),
+	RunE:              runContextUse,
+}
+
+var contextCurrentCmd = &cobra.Command{
+	Use:   "current",
+	Short: "Print the active context",
+	Args:  cobra.NoArgs,
+	RunE:  runContextCurrent,
+}
+
+var contextSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Create a context or change its fields",
+	Long: `Create a context, or change the fields given of an existing one. An
+empty value clears a field. Tokens may reference environment variables as
+${VAR}, so the file need not hold the secret.
+
+Examples:
+  aami context set prod --server https://aami.example.com --token '${AAMI_PROD_TOKEN}'
+  aami context set prod --namespace team-b
+  aami context set lab --prometheus-url http://lab-prom:9090 --alertmanager-url http://lab-am:9093`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeContexts),
+	RunE:              runContextSet,
+}
+
+var contextDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Delete a context",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeContexts),
+	RunE:              runContextDelete,
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&contextName, "context", "",
+		"Context to use (default: $AAMI_CONTEXT, then the current context of ~/.aami/config)")
+	rootCmd.RegisterFlagCompletionFunc("context", completeContexts)
+
+	contextSetCmd.Flags().StringVar(&contextServer, "server", "", "Config server address")
+	contextSetCmd.Flags().StringVar(&contextToken, "token", "", "Bearer token, or ${ENV_VAR} holding it")
+	contextSetCmd.Flags().StringVar(&contextNamespace, "namespace", "", "Default namespace")
+	contextSetCmd.Flags().StringVar(&contextPrometheusURL, "prometheus-url", "", "Prometheus address")
+	contextSetCmd.Flags().StringVar(&contextAlertmanagerURL, "alertmanager-url", "", "Alertmanager address")
+	addOutputFlag(contextListCmd, &contextListOutput)
+
+	contextCmd.AddCommand(contextListCmd)
+	contextCmd.AddCommand(contextUseCmd)
+	contextCmd.AddCommand(contextCurrentCmd)
+	contextCmd.AddCommand(contextSetCmd)
+	contextCmd.AddCommand(contextDeleteCmd)
+	rootCmd.AddCommand(contextCmd)
+}
+
+// loadContexts reads ~/.aami/config
+func loadContexts() (*contexts.File, error) {
+	path, err := contexts.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return contexts.Load(path)
+}
+
+var (
+	activeContextOnce  sync.Once
+	activeContextValue *contexts.Context
+	activeContextErr   error
+)
+
+// activeContext returns the context selected by --context, $AAMI_CONTEXT
+// or current-context; nil without one
+func activeContext() (*contexts.Context, error) {
+	activeContextOnce.Do(func() {
+		f, err := loadContexts()
+		if err != nil {
+			activeContextErr = err
+			return
+		}
+		activeContextValue, activeContextErr = f.Active(contextName)
+	})
+	return activeContextValue, activeContextErr
+}
+
+// currentContext returns the active context, or an empty one. Errors were
+// reported before the command ran.
+func currentContext() contexts.Context {
+	if c, err := activeContext(); err == nil && c != nil {
+		return *c
+	}
+	return contexts.Context{}
+}
+
+// prometheusURL returns the Prometheus of the active context, else the
+// local one
+func prometheusURL(cfg *config.Config) string {
+	if u := currentContext().PrometheusURL; u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	port := 9090
+	if cfg != nil && cfg.Prometheus.Port != 0 {
+		port = cfg.Prometheus.Port
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// alertmanagerURL returns the --alertmanager-url of cmd if given, else
+// the Alertmanager of the active context, else the local one
+func alertmanagerURL(cmd *cobra.Command, flag string) string {
+	if cmd != nil && cmd.Flags().Changed("alertmanager-url") {
+		return flag
+	}
+	if u := currentContext().AlertmanagerURL; u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return defaultString(flag, alertmanager.DefaultURL)
+}
+
+// namespaceOrDefault returns a --namespace value, else the namespace of
+// the active context
+func namespaceOrDefault(flag string) string {
+	return defaultString(flag, currentContext().Namespace)
+}
+
+// isContextCommand reports whether cmd manages contexts, which must work
+// when the selected context does not exist
+func isContextCommand(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == contextCmd {
+			return true
+		}
+	}
+	return false
+}
+
+func runContextList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(contextListOutput)
+	if err != nil {
+		return err
+	}
+	f, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	active, _ := f.Active(contextName)
+
+	if format.Structured() {
+		type listed struct {
+			contexts.Context
+			Current bool `json:"current"`
+		}
+		list := []listed{}
+		for _, c := range f.Contexts {
+			list = append(list, listed{Context: c, Current: active != nil && c.Name == active.Name})
+		}
+		return writeOutput(format, list)
+	}
+	if len(f.Contexts) == 0 {
+		fmt.Printf("No contexts in %s.\n", f.Path())
+		fmt.Println("Create one with: aami context set <name> --server <url>")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Current"},
+		{Header: "Name"},
+		{Header: "Server"},
+		{Header: "Namespace"},
+		{Header: "Prometheus", Wide: true},
+		{Header: "Alertmanager", Wide: true},
+		{Header: "Token", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, c := range f.Contexts {
+		current := ""
+		if active != nil && c.Name == active.Name {
+			current = "*"
+		}
+		token := "-"
+		if c.Token != "" {
+			token = "set"
+			if strings.HasPrefix(c.Token, "${") {
+				token = c.Token
+			}
+		}
+		if c.LoggedIn() {
+			token = "sso: " + defaultString(c.OIDC.User, "-")
+		}
+		table.Append(columns.Row(format,
+			current,
+			c.Name,
+			defaultString(c.Server, "-"),
+			defaultString(c.Namespace, "-"),
+			defaultString(c.PrometheusURL, "local"),
+			defaultString(c.AlertmanagerURL, "local"),
+			token,
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runContextUse(cmd *cobra.Command, args []string) error {
+	f, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Get(args[0]); !ok {
+		return fmt.Errorf("context %q not found in %s", args[0], f.Path())
+	}
+	f.CurrentContext = args[0]
+	if err := f.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s Switched to context %s\n", color.GreenString("✓"), args[0])
+	return nil
+}
+
+func runContextCurrent(cmd *cobra.Command, args []string) error {
+	f, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	active, err := f.Active(contextName)
+	if err != nil {
+		return err
+	}
+	if active == nil {
+		return fmt.Errorf("no current context: set one with 'aami context use <name>'")
+	}
+	fmt.Println(active.Name)
+	return nil
+}
+
+func runContextSet(cmd *cobra.Command, args []string) error {
+	f, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	c := contexts.Context{Name: args[0]}
+	existing, exists := f.Get(args[0])
+	if exists {
+		c = *existing
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("server") {
+		c.Server = contextServer
+	}
+	if flags.Changed("token") {
+		c.Token = contextToken
+	}
+	if flags.Changed("namespace") {
+		c.Namespace = contextNamespace
+	}
+	if flags.Changed("prometheus-url") {
+		c.PrometheusURL = contextPrometheusURL
+	}
+	if flags.Changed("alertmanager-url") {
+		c.AlertmanagerURL = contextAlertmanagerURL
+	}
+
+	f.Set(c)
+	first := len(f.Contexts) == 1
+	if first {
+		f.CurrentContext = c.Name
+	}
+	if err := f.Save(); err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	if exists {
+		fmt.Printf("%s Context %s updated\n", green("✓"), c.Name)
+	} else {
+		fmt.Printf("%s Context %s created\n", green("✓"), c.Name)
+	}
+	if first {
+		fmt.Printf("  Switched to context %s\n", c.Name)
+	}
+	return nil
+}
+
+func runContextDelete(cmd *cobra.Command, args []string) error {
+	f, err := loadContexts()
+	if err != nil {
+		return err
+	}
+	if !f.Delete(args[0]) {
+		return fmt.Errorf("context %q not found in %s", args[0], f.Path())
+	}
+	if err := f.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s Context %s deleted\n", color.GreenString("✓"), args[0])
+	return nil
+}
+
+// completeContexts completes the names of the contexts
+func completeContexts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	if f, err := loadContexts(); err == nil {
+		for _, c := range f.Contexts {
+			candidates[c.Name] = c.Server
+		}
+	}
+	return completions(candidates, toComplete)
+}
@@ -0,0 +1,199 @@
+package cli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+// ruleQueue writes the rule files of the groups changed through the rule
+// API and reloads Prometheus in the background, debounced and one at a
+// time. The synthetic alert test reloads through it too.
+var ruleQueue = prometheus.NewGenerationQueue(generateRuleGroup, func() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return prometheus.Reload(ctx, prometheusURL(cfg))
+})
+
+// generateRuleGroup writes the rule file of a group of the rule queue.
+// alerts.custom, written to custom.yaml, is the only group that changes
+// through the API.
+func generateRuleGroup(group string) error {
+	if group != customRulesGroup {
+		return fmt.Errorf("unknown rule group %q", group)
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	lang, err := alertLanguage(cfg, customRulesGroup)
+	if err != nil {
+		return err
+	}
+	content := []byte(generatePrometheusRules(customRulesPreset(cfg, lang)))
+	path, err := prometheus.WriteRuleFile(config.RuleNamespace{}, customRulesGroup+".yaml", content)
+	if err != nil {
+		return err
+	}
+	_, err = publishRuleFile(cfg, path, content)
+	return err
+}
+
+// generationStatusHandler serves GET /prometheus/generation-status, the
+// state of the rule queue
+func generationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeSilenceJSON(w, http.StatusOK, ruleQueue.Status())
+}
+
+// customRule is a rule of alerts.custom as served by the rule API
+type customRule struct {
+	Name     string `json:"name"`
+	Expr     string `json:"expr"`
+	For      string `json:"for,omitempty"`
+	Severity string `json:"severity"`
+	Version  string `json:"version"`
+}
+
+// customRuleVersion returns the version of a custom rule: a hash of its
+// fields, so it changes with every change to the rule
+func customRuleVersion(rule config.CustomAlertRule) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{rule.Name, rule.Expr, rule.For, rule.Severity}, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newCustomRule(rule config.CustomAlertRule) customRule {
+	return customRule{
+		Name:     rule.Name,
+		Expr:     rule.Expr,
+		For:      rule.For,
+		Severity: rule.Severity,
+		Version:  customRuleVersion(rule),
+	}
+}
+
+// customRuleHandler serves GET and PUT /alert-rules/<name> for the rules of
+// alerts.custom. The rule's version is its ETag; a PUT must send it back in
+// If-Match and fails with 409 Conflict and the current rule if the rule
+// was changed since, so two operators editing the same rule do not
+// overwrite each other's changes. A change is checked with promtool, as
+// previews are, failing with 422 if it is rejected, and is then queued to
+// be written to custom.yaml and loaded by Prometheus.
+func customRuleHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkAdminToken(w, r, cfg) {
+		return
+	}
+
+	index := -1
+	for i, rule := range cfg.Alerts.Custom {
+		if rule.Name == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		http.Error(w, fmt.Sprintf("custom rule %q not found", name), http.StatusNotFound)
+		return
+	}
+	current := newCustomRule(cfg.Alerts.Custom[index])
+	w.Header().Set("ETag", `"`+current.Version+`"`)
+
+	if r.Method == http.MethodGet {
+		writeSilenceJSON(w, http.StatusOK, current)
+		return
+	}
+
+	ifMatch := strings.Trim(r.Header.Get("If-Match"), `"`)
+	if ifMatch == "" {
+		http.Error(w, "If-Match with the rule's version is required", http.StatusPreconditionRequired)
+		return
+	}
+	if ifMatch != current.Version {
+		writeSilenceJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":   fmt.Sprintf("rule %s was changed by someone else (version %s, now %s)", name, ifMatch, current.Version),
+			"current": current,
+		})
+		return
+	}
+
+	var req customRule
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = name
+	}
+	if req.Expr == "" || req.Severity == "" {
+		http.Error(w, "expr and severity are required", http.StatusBadRequest)
+		return
+	}
+	if req.Name != name {
+		for _, other := range cfg.Alerts.Custom {
+			if other.Name == req.Name {
+				http.Error(w, fmt.Sprintf("rule %s already exists", req.Name), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	rule := config.CustomAlertRule{Name: req.Name, Expr: req.Expr, For: req.For, Severity: req.Severity}
+	cfg.Alerts.Custom[index] = rule
+
+	// Check the rule as the preview route does, so a rule promtool rejects
+	// never reaches custom.yaml and breaks the next reload
+	lang, err := alertLanguage(cfg, customRulesGroup)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	preset, _ := findRule([]alertPreset{customRulesPreset(cfg, lang)}, rule.Name)
+	preview, err := renderPreview(r.Context(), preset, rule.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !preview.Validation.Valid && !preview.Validation.Skipped {
+		writeSilenceJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":      fmt.Sprintf("rule %s failed promtool check rules", rule.Name),
+			"validation": preview.Validation,
+		})
+		return
+	}
+
+	if _, err := saveConfig(cfg, version); err != nil {
+		// Another rule or setting was saved since loading: retrying reads
+		// the current version
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	ruleQueue.Enqueue(customRulesGroup)
+
+	saved := newCustomRule(rule)
+	w.Header().Set("ETag", `"`+saved.Version+`"`)
+	writeSilenceJSON(w, http.StatusOK, saved)
+}
@@ -0,0 +1,351 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/agentauth"
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/debugbundle"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/storage"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	debugBundleConfigServerURL string
+	debugBundleTimeout         time.Duration
+	debugBundleSkipBugReport   bool
+	debugBundleOutput          string
+)
+
+// debugBundleLinkTTL is how long s3/gcs download links stay valid without
+// storage.debug_bundles.link_ttl
+const debugBundleLinkTTL = 7 * 24 * time.Hour
+
+var nodesCollectDebugCmd = &cobra.Command{
+	Use:   "collect-debug <node>",
+	Short: "Collect a debug bundle from a node for a support case",
+	Long: `Collect a debug bundle from a node and print a link to download it, for
+vendor support cases.
+
+The command queues a diagnostics task for the node's agent on the config
+server ('aami agents run'). The agent gathers kernel messages,
+nvidia-bug-report.log.gz, the logs of the exporters, its recent check
+results and state, and the usual diagnostic command outputs into a tarball,
+and uploads it to 'aami check-results serve'
+(/api/v1/targets/<hostname>/debug-bundles). The server keeps it under
+storage.debug_bundles: a local directory (/var/lib/aami/debug-bundles), or
+an s3 or gcs bucket.
+
+The link of a bundle in a bucket is presigned and works without credentials
+for storage.debug_bundles.link_ttl (default 7d). The link of a local bundle
+points at storage.debug_bundles.url and needs the check result token.
+nvidia-bug-report takes minutes on large nodes; --skip-nvidia-bug-report
+leaves it out.
+
+Examples:
+  aami node collect-debug gpu-node-01
+  aami node collect-debug gpu-node-01 --timeout 30m
+  aami node collect-debug gpu-node-01 --skip-nvidia-bug-report`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesCollectDebug,
+}
+
+var nodesDebugBundlesCmd = &cobra.Command{
+	Use:   "debug-bundles [node]",
+	Short: "List the debug bundles of nodes, with download links",
+	Long: `List the debug bundles node agents uploaded, newest first, with a link to
+download each. Without <node>, the bundles of every node are listed.
+
+Examples:
+  aami node debug-bundles
+  aami node debug-bundles gpu-node-01 -o json`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesDebugBundles,
+}
+
+func init() {
+	nodesCollectDebugCmd.Flags().StringVar(&debugBundleConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	nodesCollectDebugCmd.Flags().DurationVar(&debugBundleTimeout, "timeout", 15*time.Minute,
+		"How long to wait for the agent to upload the bundle")
+	nodesCollectDebugCmd.Flags().BoolVar(&debugBundleSkipBugReport, "skip-nvidia-bug-report", false,
+		"Leave nvidia-bug-report.log.gz out of the bundle")
+	addOutputFlag(nodesDebugBundlesCmd, &debugBundleOutput)
+
+	nodesCmd.AddCommand(nodesCollectDebugCmd)
+	nodesCmd.AddCommand(nodesDebugBundlesCmd)
+}
+
+// newDebugBundleStore returns the store of storage.debug_bundles
+func newDebugBundleStore(cfg *config.Config) (*debugbundle.Store, error) {
+	dir := cfg.Storage.DebugBundles.Path
+	if dir == "" {
+		dir = debugbundle.DefaultDir
+	}
+	backend, err := storage.New(cfg.Storage.DebugBundles.ObjectStoreConfig, dir)
+	if err != nil {
+		return nil, fmt.Errorf("storage.debug_bundles: %w", err)
+	}
+	return debugbundle.NewStore(backend), nil
+}
+
+// debugBundleLink returns a link to download a bundle: presigned for a
+// bucket, else on 'aami check-results serve'
+func debugBundleLink(cfg *config.Config, store *debugbundle.Store, b debugbundle.Bundle) (string, error) {
+	ttl := debugBundleLinkTTL
+	if cfg.Storage.DebugBundles.LinkTTL != "" {
+		d, err := chatops.ParseDuration(cfg.Storage.DebugBundles.LinkTTL)
+		if err != nil {
+			return "", fmt.Errorf("storage.debug_bundles.link_ttl: %w", err)
+		}
+		ttl = d
+	}
+	if link, ok, err := store.Link(b, ttl); ok {
+		return link, err
+	}
+
+	base := cfg.Storage.DebugBundles.URL
+	if base == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "localhost"
+		}
+		base = "http://" + host + ":8098"
+	}
+	return strings.TrimSuffix(base, "/") + "/api/v1/targets/" + url.PathEscape(b.Target) +
+		"/debug-bundles/" + url.PathEscape(b.ID), nil
+}
+
+func runNodesCollectDebug(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := newDebugBundleStore(cfg)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(debugBundleConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	target, err := client.FindTarget(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	taskArgs := map[string]interface{}{"upload": true}
+	if !debugBundleSkipBugReport {
+		taskArgs["nvidia_bug_report"] = true
+	}
+	task, err := client.QueueAgentTask(ctx, target.Hostname, configserver.AgentTaskRequest{
+		Type: configserver.TaskDiagnostics,
+		Args: taskArgs,
+		TTL:  int(debugBundleTimeout.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	task.Hostname = target.Hostname
+	fmt.Printf("%s Queued diagnostics on %s (task %s)\n", color.GreenString("✓"), target.Hostname, task.ID)
+
+	cancel()
+	if failed := waitAgentTasks(client, []*configserver.AgentTask{task}, debugBundleTimeout); failed > 0 {
+		return fmt.Errorf("no debug bundle from %s", target.Hostname)
+	}
+
+	b, err := store.FindTask(target.Hostname, task.ID)
+	if err != nil {
+		return err
+	}
+	link, err := debugBundleLink(cfg, store, b)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("Bundle:   %s (%s, %d files)\n", b.ID, formatSize(b.Size), len(b.Files))
+	fmt.Printf("SHA-256:  %s\n", b.SHA256)
+	fmt.Printf("Stored:   %s\n", b.Location)
+	fmt.Printf("Download: %s\n", link)
+	return nil
+}
+
+// debugBundleListing is a bundle with its download link, as listed
+type debugBundleListing struct {
+	debugbundle.Bundle
+	URL string `json:"url"`
+}
+
+func runNodesDebugBundles(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(debugBundleOutput)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	store, err := newDebugBundleStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	host := ""
+	if len(args) == 1 {
+		ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+		defer cancel()
+		target, err := findNodeTarget(ctx, cfg, args[0])
+		if err != nil {
+			return err
+		}
+		host = target.Hostname
+	}
+	bundles, err := store.List(host)
+	if err != nil {
+		return err
+	}
+	listings := make([]debugBundleListing, len(bundles))
+	for i, b := range bundles {
+		link, err := debugBundleLink(cfg, store, b)
+		if err != nil {
+			return err
+		}
+		listings[i] = debugBundleListing{Bundle: b, URL: link}
+	}
+	if format.Structured() {
+		return writeOutput(format, listings)
+	}
+	if len(listings) == 0 {
+		fmt.Println("No debug bundles uploaded yet.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Node"},
+		{Header: "ID"},
+		{Header: "Size"},
+		{Header: "Uploaded"},
+		{Header: "Download"},
+		{Header: "Task", Wide: true},
+		{Header: "Files", Wide: true},
+		{Header: "SHA-256", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, l := range listings {
+		table.Append(columns.Row(format,
+			l.Target,
+			l.ID,
+			formatSize(l.Size),
+			l.UploadedAt.Local().Format("01-02 15:04"),
+			l.URL,
+			defaultString(l.TaskID, "-"),
+			fmt.Sprint(len(l.Files)),
+			l.SHA256,
+		))
+	}
+	table.Render()
+	return nil
+}
+
+// handleTargetDebugBundles serves /targets/<hostname>/debug-bundles of the
+// agent API: agents POST a bundle, readers list and download them
+func handleTargetDebugBundles(w http.ResponseWriter, r *http.Request, cfg *config.Config, authority *agentauth.Authority) {
+	rest := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/targets/")
+	host, id, _ := strings.Cut(rest, "/debug-bundles")
+	id, ok := strings.CutPrefix(id, "/")
+	if host == "" || strings.Contains(host, "/") || (id != "" && !ok) || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	store, err := newDebugBundleStore(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		claims, err := agentCredential(r, cfg, authority)
+		if err != nil {
+			writeAgentAuthError(w, err)
+			return
+		}
+		if claims != nil && host != claims.Target() {
+			http.Error(w, fmt.Sprintf("credential of %s cannot upload for %s", claims.Target(), host), http.StatusForbidden)
+			return
+		}
+		if !hasNodeConfig(cfg, host) {
+			http.Error(w, "unknown target: "+host, http.StatusBadRequest)
+			return
+		}
+		data, err := readLimited(r, debugbundle.MaxSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		b, err := store.Put(host, r.URL.Query().Get("task"), data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSilenceJSON(w, http.StatusCreated, b)
+
+	case r.Method == http.MethodGet:
+		// API keys read only the bundles of their namespace's nodes
+		t, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if nodes := t.Nodes(cfg); nodes != nil && !nodes[host] {
+			http.Error(w, "unknown target: "+host, http.StatusNotFound)
+			return
+		}
+		if id == "" {
+			bundles, err := store.List(host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, bundles)
+			return
+		}
+		b, err := store.Get(host, id)
+		if err == nil {
+			var data []byte
+			if data, err = store.Open(b); err == nil {
+				w.Header().Set("Content-Type", "application/gzip")
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", b.Target+"-"+b.ID+".tar.gz"))
+				w.Write(data)
+				return
+			}
+		}
+		if errors.Is(err, debugbundle.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -57,6 +57,7 @@ type DiagnosticResult struct {
 	Status  string // pass, warn, fail
 	Message string
 	Details string
+	Remedy  string // what to do when the check does not pass
 }
 
 func runDiagnose(cmd *cobra.Command, args []string) error {
@@ -105,7 +106,7 @@ func runDiagnose(cmd *cobra.Command, args []string) error {
 	// Summary
 	printSummary(results)
 
-	return nil
+	return checksError(results)
 }
 
 func checkSystem() []DiagnosticResult {
@@ -269,7 +270,7 @@ func checkComponents() []DiagnosticResult {
 	var results []DiagnosticResult
 
 	// Prometheus
-	promResult := checkHTTPService("Prometheus", "http://localhost:9090/-/healthy")
+	promResult := checkHTTPService("Prometheus", prometheusURL(nil)+"/-/healthy")
 	results = append(results, promResult)
 
 	// Grafana
@@ -277,7 +278,7 @@ func checkComponents() []DiagnosticResult {
 	results = append(results, grafanaResult)
 
 	// Alertmanager
-	alertResult := checkHTTPService("Alertmanager", "http://localhost:9093/-/healthy")
+	alertResult := checkHTTPService("Alertmanager", alertmanagerURL(nil, "")+"/-/healthy")
 	results = append(results, alertResult)
 
 	// DCGM Exporter (check if nvidia-smi exists first)
@@ -437,6 +438,17 @@ func printSummary(results []DiagnosticResult) {
 	}
 }
 
+// checksError reports failed checks through the exit code
+func checksError(results []DiagnosticResult) error {
+	failed := 0
+	for _, r := range results {
+		if r.Status == "fail" {
+			failed++
+		}
+	}
+	return countError(failed, len(results), "checks")
+}
+
 // Subcommand for specific diagnostics
 var diagnoseConfigCmd = &cobra.Command{
 	Use:   "config",
@@ -446,7 +458,7 @@ var diagnoseConfigCmd = &cobra.Command{
 		fmt.Println(strings.Repeat("=", 50))
 		results := checkConfiguration()
 		printResults(results)
-		return nil
+		return checksError(results)
 	},
 }
 
@@ -462,7 +474,7 @@ var diagnoseNodesCmd = &cobra.Command{
 		fmt.Println(strings.Repeat("=", 50))
 		results := checkNodes(cfg)
 		printResults(results)
-		return nil
+		return checksError(results)
 	},
 }
 
@@ -474,7 +486,7 @@ var diagnoseComponentsCmd = &cobra.Command{
 		fmt.Println(strings.Repeat("=", 50))
 		results := checkComponents()
 		printResults(results)
-		return nil
+		return checksError(results)
 	},
 }
 
@@ -0,0 +1,411 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/discovery"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	discoveryWatch      bool
+	discoveryDryRun     bool
+	discoveryKubeconfig string
+	discoveryContext    string
+	discoverySelector   string
+	discoveryConflict   string
+	discoveryListen     string
+)
+
+var discoveryCmd = &cobra.Command{
+	Use:   "discovery",
+	Short: "Register nodes from other inventories",
+	Long: `Register nodes from other inventories: a Kubernetes cluster or a Consul
+catalog.
+
+Discovered nodes are added to the node list with their source recorded, and
+removed when the source no longer has them. A discovered node whose name is
+taken by another node is reported as a conflict and skipped; Consul may
+take over nodes added by hand (--conflict theirs).
+Target files are regenerated after every change.`,
+}
+
+var discoveryKubernetesCmd = &cobra.Command{
+	Use:   "kubernetes",
+	Short: "Register the nodes of a Kubernetes cluster",
+	Long: `Register the nodes of a Kubernetes cluster as AAMI nodes.
+
+Each Node becomes a node with its InternalIP. Annotations carry AAMI
+settings, so nodes need no bootstrap token:
+
+  label.aami.io/<key>: <value>   node label key=value
+  aami.io/ssh-user: <user>       SSH user (default: discovery.kubernetes.ssh_user)
+  aami.io/ignore: "true"         leave the node out
+
+The cluster is reached through the kubeconfig in discovery.kubernetes, the
+pod's service account when running in the cluster, or $KUBECONFIG and
+~/.kube/config. Only get, list and watch on nodes are needed.
+
+With --watch, nodes are watched and registered or removed as they join and
+leave the cluster.
+
+Examples:
+  aami discovery kubernetes --dry-run                          # Show what would change
+  aami discovery kubernetes --selector nvidia.com/gpu.present=true
+  aami discovery kubernetes --watch                            # Keep nodes in sync`,
+	Args: cobra.NoArgs,
+	RunE: runDiscoveryKubernetes,
+}
+
+var discoveryConsulCmd = &cobra.Command{
+	Use:   "consul",
+	Short: "Register the nodes of a Consul catalog",
+	Long: `Register the nodes of a Consul catalog as AAMI nodes.
+
+All catalog nodes are registered, or with discovery.consul.services only
+the nodes running a passing instance of those services. Node metadata
+carries AAMI settings:
+
+  aami_label_<key>: <value>   node label key=value
+  aami_ssh_user: <user>       SSH user (default: discovery.consul.ssh_user)
+  aami_ignore: "true"         leave the node out
+
+A catalog node whose name is taken by a node added by hand is a conflict:
+with --conflict ours (default) the node is kept as it is, with theirs
+Consul takes it over. Nodes registered from Kubernetes are never taken over.
+
+With --listen, nodes are synced every discovery.consul.interval (default
+5m), and POST /api/v1/discovery/consul/sync syncs on demand; it needs
+Authorization: Bearer <admin.token>.
+
+Examples:
+  aami discovery consul --dry-run                 # Show what would change
+  aami discovery consul --conflict theirs         # Take over nodes added by hand
+  aami discovery consul --listen :8099            # Sync on a schedule and on demand`,
+	Args: cobra.NoArgs,
+	RunE: runDiscoveryConsul,
+}
+
+func init() {
+	discoveryConsulCmd.Flags().BoolVar(&discoveryDryRun, "dry-run", false,
+		"Show changes without saving them")
+	discoveryConsulCmd.Flags().StringVar(&discoveryConflict, "conflict", "",
+		"Nodes of the same name added by hand: ours, theirs (default: discovery.consul.conflict, else ours)")
+	discoveryConsulCmd.Flags().StringVar(&discoveryListen, "listen", "",
+		"Sync on a schedule and serve the sync API on this address (e.g. :8099)")
+
+	discoveryKubernetesCmd.Flags().BoolVar(&discoveryWatch, "watch", false,
+		"Keep watching nodes instead of syncing once")
+	discoveryKubernetesCmd.Flags().BoolVar(&discoveryDryRun, "dry-run", false,
+		"Show changes without saving them")
+	discoveryKubernetesCmd.Flags().StringVar(&discoveryKubeconfig, "kubeconfig", "",
+		"Kubeconfig file (default: discovery.kubernetes.kubeconfig)")
+	discoveryKubernetesCmd.Flags().StringVar(&discoveryContext, "context", "",
+		"Kubeconfig context (default: discovery.kubernetes.context)")
+	discoveryKubernetesCmd.Flags().StringVar(&discoverySelector, "selector", "",
+		"Label selector of the nodes to register (default: discovery.kubernetes.label_selector)")
+
+	discoveryCmd.AddCommand(discoveryKubernetesCmd)
+	discoveryCmd.AddCommand(discoveryConsulCmd)
+	rootCmd.AddCommand(discoveryCmd)
+}
+
+func runDiscoveryKubernetes(cmd *cobra.Command, args []string) error {
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return err
+	}
+	if discoveryWatch && discoveryDryRun {
+		return fmt.Errorf("--watch and --dry-run cannot be combined")
+	}
+
+	k8sCfg := cfg.Discovery.Kubernetes
+	if discoveryKubeconfig != "" {
+		k8sCfg.Kubeconfig = discoveryKubeconfig
+	}
+	if discoveryContext != "" {
+		k8sCfg.Context = discoveryContext
+	}
+	if discoverySelector != "" {
+		k8sCfg.LabelSelector = discoverySelector
+	}
+	k8s, err := discovery.NewKubernetes(k8sCfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !discoveryWatch {
+		nodes, _, err := k8s.Nodes(ctx)
+		if err != nil {
+			return err
+		}
+		changes, err := syncDiscoveredNodes(cfg, version, discovery.SourceKubernetes, nodes, discovery.ConflictOurs, discoveryDryRun)
+		if err != nil {
+			return err
+		}
+		printDiscoveryChanges(changes, discoveryDryRun)
+		return nil
+	}
+
+	k8s.Logf = discoveryLogf
+	fmt.Println(i18n.T("Watching nodes of %s", k8s.Server()))
+	return k8s.Watch(ctx, func(nodes []config.NodeConfig) error {
+		// Reload: the config may have been changed since the last sync
+		cfg, version, err := loadConfigVersion()
+		if err != nil {
+			return err
+		}
+		changes, err := syncDiscoveredNodes(cfg, version, discovery.SourceKubernetes, nodes, discovery.ConflictOurs, false)
+		logDiscoveryChanges(changes)
+		return err
+	})
+}
+
+func runDiscoveryConsul(cmd *cobra.Command, args []string) error {
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return err
+	}
+	if discoveryListen != "" && discoveryDryRun {
+		return fmt.Errorf("--listen and --dry-run cannot be combined")
+	}
+	conflict := cfg.Discovery.Consul.Conflict
+	if discoveryConflict != "" {
+		conflict = discoveryConflict
+	}
+	if conflict, err = discovery.ParseConflict(conflict); err != nil {
+		return err
+	}
+
+	if discoveryListen != "" {
+		return serveConsulDiscovery(cfg, discoveryListen, conflict)
+	}
+
+	consul := discovery.NewConsul(cfg.Discovery.Consul)
+	nodes, err := consul.Nodes(cmd.Context())
+	if err != nil {
+		return err
+	}
+	changes, err := syncDiscoveredNodes(cfg, version, discovery.SourceConsul, nodes, conflict, discoveryDryRun)
+	if err != nil {
+		return err
+	}
+	printDiscoveryChanges(changes, discoveryDryRun)
+	return nil
+}
+
+// consulSync serializes the scheduled and requested Consul syncs
+var consulSync sync.Mutex
+
+// syncConsul syncs the nodes of the Consul catalog with the current config
+func syncConsul(ctx context.Context, conflict string, dryRun bool) ([]discovery.Change, error) {
+	consulSync.Lock()
+	defer consulSync.Unlock()
+
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := discovery.NewConsul(cfg.Discovery.Consul).Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return syncDiscoveredNodes(cfg, version, discovery.SourceConsul, nodes, conflict, dryRun)
+}
+
+// serveConsulDiscovery syncs the Consul catalog every
+// discovery.consul.interval and serves POST /api/v1/discovery/consul/sync
+func serveConsulDiscovery(cfg *config.Config, addr, conflict string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	interval := 5 * time.Minute
+	if s := cfg.Discovery.Consul.Interval; s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid discovery.consul.interval %q", s)
+		}
+		interval = d
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Conflicts recur on every sync; log them when they change
+		var lastConflicts string
+		for {
+			changes, err := syncConsul(ctx, conflict, false)
+			var logged []discovery.Change
+			var conflicts []string
+			for _, c := range changes {
+				if c.Action == discovery.ActionConflict {
+					conflicts = append(conflicts, c.Node+" "+c.Detail)
+				}
+			}
+			if s := strings.Join(conflicts, "\n"); s != lastConflicts {
+				logged, lastConflicts = changes, s
+			} else {
+				for _, c := range changes {
+					if c.Action != discovery.ActionConflict {
+						logged = append(logged, c)
+					}
+				}
+			}
+			logDiscoveryChanges(logged)
+			if err != nil {
+				discoveryLogf("consul discovery: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+
+	mux := newAPIMux()
+	mux.Version("v1").HandleFunc("/discovery/consul/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+
+		req := struct {
+			DryRun   bool   `json:"dry_run"`
+			Conflict string `json:"conflict"`
+		}{Conflict: conflict}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Conflict, err = discovery.ParseConflict(req.Conflict); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !req.DryRun {
+			if err := ensureWritable(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+
+		changes, err := syncConsul(r.Context(), req.Conflict, req.DryRun)
+		if writeQuotaError(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !req.DryRun {
+			logDiscoveryChanges(changes)
+		}
+		if changes == nil {
+			changes = []discovery.Change{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run": req.DryRun,
+			"changed": discovery.Changed(changes),
+			"changes": changes,
+		})
+	})
+
+	fmt.Printf("%s Syncing nodes from Consul every %s\n", green("✓"), interval)
+	fmt.Printf("%s Serving sync API on http://%s/api/v1/discovery/consul/sync\n", green("✓"), addr)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// syncDiscoveredNodes applies the nodes a source discovered to cfg, loaded
+// at version, then saves the config and regenerates the target files if
+// anything changed
+func syncDiscoveredNodes(cfg *config.Config, version, source string, nodes []config.NodeConfig, conflict string, dryRun bool) ([]discovery.Change, error) {
+	changes := discovery.Apply(cfg, source, nodes, conflict)
+	if dryRun || !discovery.Changed(changes) {
+		return changes, nil
+	}
+	if _, err := saveConfig(cfg, version); err != nil {
+		// Nothing changed, e.g. the nodes would exceed a namespace quota
+		return nil, err
+	}
+	if err := prometheus.GenerateAllTargets(cfg.Nodes, prometheus.DefaultTargetsDir); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+func printDiscoveryChanges(changes []discovery.Change, dryRun bool) {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if len(changes) == 0 {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Nodes are up to date"))
+		return
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Action"), i18n.T("Node"), i18n.T("Details")})
+	for _, c := range changes {
+		action := i18n.T(c.Action)
+		if c.Action == discovery.ActionConflict {
+			action = yellow(action)
+		}
+		table.Append([]string{action, c.Node, c.Detail})
+	}
+	table.Render()
+
+	if dryRun {
+		fmt.Println(i18n.T("Dry run: nothing was saved"))
+	} else if discovery.Changed(changes) {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Nodes updated"))
+	} else {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Nodes are up to date"))
+	}
+}
+
+func discoveryLogf(format string, args ...interface{}) {
+	fmt.Printf("%s "+format+"\n", append([]interface{}{time.Now().Format(time.RFC3339)}, args...)...)
+}
+
+func logDiscoveryChanges(changes []discovery.Change) {
+	for _, c := range changes {
+		if c.Detail != "" {
+			discoveryLogf("%s %s: %s", c.Action, c.Node, c.Detail)
+		} else {
+			discoveryLogf("%s %s", c.Action, c.Node)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/installer"
+	"github.com/fregataa/aami/internal/notify"
+	"github.com/fregataa/aami/internal/prometheus"
+	"github.com/fregataa/aami/internal/synthetic"
+)
+
+// defaultSyntheticGroup is the rule group of the synthetic alert unless
+// another is chosen
+const defaultSyntheticGroup = "aami-synthetic"
+
+var (
+	doctorE2E             bool
+	doctorGroup           string
+	doctorSeverity        string
+	doctorChannel         string
+	doctorTimeout         time.Duration
+	doctorAlertmanagerURL string
+	doctorConfigServerURL string
+	doctorStrict          bool
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the monitoring stack and that alerts reach their channels",
+	Long: `Check the whole stack: the config server, Prometheus, Alertmanager and
+Slurm answer, the rules directory is writable, namespace rule directories
+have the ownership and mode of the config, every node's node_exporter
+reports the version AAMI installs, and notification channels are enabled.
+Each problem comes with the steps to fix it.
+
+The command exits non-zero when a check fails, or with --strict when one
+warns, so it can gate CI pipelines and deployments.
+
+With --e2e, a synthetic always-firing rule is added to a rule group and
+followed until Prometheus fires it, Alertmanager receives it and every
+enabled notification channel (or the one given with --channel) delivers
+it. The rule is removed afterwards, whatever the outcome. Run it after
+changing rules, routing or channel settings; the command fails if any step
+does, so it can gate deployments.
+
+The synthetic alert is named AAMISyntheticTest and carries an
+aami_synthetic label, so receivers can recognize it. Prometheus must run
+with --web.enable-lifecycle to reload rules.
+
+The same test runs on POST /api/v1/admin/test-alert of the rule API
+(aami alerts preview --listen), with admin.token as bearer token.
+
+Examples:
+  aami doctor
+  aami doctor --strict
+  aami doctor --e2e
+  aami doctor --e2e --group gpu-production --severity critical
+  aami doctor --e2e --channel slack --timeout 10m`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorE2E, "e2e", false,
+		"Send a synthetic alert through the whole pipeline")
+	doctorCmd.Flags().StringVar(&doctorGroup, "group", defaultSyntheticGroup,
+		"Rule group the synthetic rule is added to")
+	doctorCmd.Flags().StringVar(&doctorSeverity, "severity", synthetic.DefaultSeverity,
+		"Severity label of the synthetic alert")
+	doctorCmd.Flags().StringVar(&doctorChannel, "channel", "",
+		"Only confirm delivery on this channel (slack, email, webhook)")
+	doctorCmd.Flags().DurationVar(&doctorTimeout, "timeout", synthetic.DefaultTimeout,
+		"How long each step waits")
+	doctorCmd.Flags().StringVar(&doctorAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager API address")
+	doctorCmd.Flags().StringVar(&doctorConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	doctorCmd.Flags().BoolVar(&doctorStrict, "strict", false,
+		"Fail on warnings too")
+
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// syntheticOptions returns the options of a synthetic alert test against
+// the configured Prometheus, Alertmanager and channels
+func syntheticOptions(cfg *config.Config, group, severity, channel string, timeout time.Duration, alertmanagerURL string) (synthetic.Options, error) {
+	if group == "" {
+		group = defaultSyntheticGroup
+	}
+	integrations := notify.EnabledChannels(cfg)
+	if channel != "" {
+		if !notify.IsChannel(channel) {
+			return synthetic.Options{}, fmt.Errorf("unknown channel %q (valid: %s)", channel, strings.Join(notify.Channels, ", "))
+		}
+		enabled := false
+		for _, c := range integrations {
+			enabled = enabled || c == channel
+		}
+		if !enabled {
+			return synthetic.Options{}, fmt.Errorf("channel %s is not enabled", channel)
+		}
+		integrations = []string{channel}
+	}
+
+	backend, err := ruleStorage(cfg)
+	if err != nil {
+		return synthetic.Options{}, err
+	}
+	opts := synthetic.Options{
+		Group:           group,
+		Severity:        severity,
+		RulesDir:        prometheus.RulesDir,
+		PrometheusURL:   prometheusURL(cfg),
+		AlertmanagerURL: alertmanagerURL,
+		Integrations:    integrations,
+		Timeout:         timeout,
+		Storage:         backend,
+	}
+	// A Prometheus restricted to a namespace only loads rules from its directory
+	if ns := cfg.Prometheus.RuleNamespace; ns != "" {
+		opts.RulesDir = filepath.Join(prometheus.RulesDir, ns)
+		opts.RuleKeyPrefix = ns
+	}
+	return opts, nil
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	promURL := prometheusURL(cfg)
+	amURL := strings.TrimRight(alertmanagerURL(cmd, doctorAlertmanagerURL), "/")
+	results := []DiagnosticResult{
+		checkConfigServer(cfg),
+		withRemedy(checkHTTPService("Prometheus", promURL+"/-/ready"),
+			i18n.T("Start Prometheus (systemctl start prometheus) and check that it listens on prometheus.port (%d)", cfg.Prometheus.Port)),
+		withRemedy(checkHTTPService("Alertmanager", amURL+"/-/ready"),
+			i18n.T("Start Alertmanager (systemctl start alertmanager), or give its address with --alertmanager-url")),
+		checkSlurm(cfg),
+		checkRulesWritable(cfg),
+	}
+	if len(cfg.Alerts.Namespaces) > 0 {
+		results = append(results, checkRuleNamespaces(cfg))
+	}
+	results = append(results, checkAgentVersions(cfg, promURL))
+	channels := DiagnosticResult{Name: "Channels", Status: "pass", Message: strings.Join(notify.EnabledChannels(cfg), ", ")}
+	if channels.Message == "" {
+		channels.Status = "warn"
+		channels.Message = i18n.T("No notification channel is enabled")
+		channels.Remedy = i18n.T("Enable a notification channel, e.g. with 'aami config notifications slack'")
+	}
+	results = append(results, channels)
+	printResults(results)
+	printRemedies(results)
+
+	if doctorStrict && checksError(results) == nil {
+		warned := 0
+		for _, r := range results {
+			if r.Status == "warn" {
+				warned++
+			}
+		}
+		if warned > 0 {
+			return partialf("%d of %d checks warned (--strict)", warned, len(results))
+		}
+	}
+	if !doctorE2E {
+		if err := checksError(results); err != nil {
+			return err
+		}
+		fmt.Println()
+		fmt.Println(i18n.T("Run 'aami doctor --e2e' to send a synthetic alert through the pipeline."))
+		return nil
+	}
+	for _, r := range results {
+		if r.Status == "fail" {
+			return fmt.Errorf("%s: %s; fix it before the end-to-end test", r.Name, r.Message)
+		}
+	}
+	if err := ensureWritable(); err != nil {
+		return err
+	}
+
+	opts, err := syntheticOptions(cfg, doctorGroup, doctorSeverity, doctorChannel, doctorTimeout, alertmanagerURL(cmd, doctorAlertmanagerURL))
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println(i18n.T("End-to-end test of group %s (waiting up to %s per step)", opts.Group, opts.Timeout))
+
+	result, err := synthetic.Run(context.Background(), opts, printSyntheticStep)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	if !result.Passed {
+		return fmt.Errorf("end-to-end test %s failed", result.ID)
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Alerts reach their channels (test %s, %s)",
+		result.ID, result.FinishedAt.Sub(result.StartedAt).Round(time.Second)))
+	return nil
+}
+
+// printSyntheticStep prints a step of the end-to-end test as it finishes
+func printSyntheticStep(s synthetic.Step) {
+	icon := getStatusIcon("pass")
+	switch s.Status {
+	case synthetic.StepFailed:
+		icon = getStatusIcon("fail")
+	case synthetic.StepSkipped:
+		icon = color.New(color.Faint).Sprint("-")
+	}
+	fmt.Printf("  %s %-26s %s\n", icon, i18n.T(s.Name)+":", s.Detail)
+}
+
+// checkRulesWritable checks that the synthetic rule can be written where
+// Prometheus loads rules
+func checkRulesWritable(cfg *config.Config) DiagnosticResult {
+	dir := prometheus.RulesDir
+	if ns := cfg.Prometheus.RuleNamespace; ns != "" {
+		dir = filepath.Join(dir, ns)
+	}
+	result := DiagnosticResult{Name: "Rules Directory", Status: "pass", Message: dir}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		result.Status = "fail"
+		result.Message = err.Error()
+		result.Remedy = i18n.T("Run as root, or create %s writable by this user", dir)
+		return result
+	}
+	f, err := os.CreateTemp(dir, ".aami-doctor-*")
+	if err != nil {
+		result.Status = "fail"
+		result.Message = i18n.T("%s is not writable", dir)
+		result.Remedy = i18n.T("Run as root, or give this user write access to %s", dir)
+		return result
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return result
+}
+
+// withRemedy sets the remedy of a check that did not pass
+func withRemedy(r DiagnosticResult, remedy string) DiagnosticResult {
+	if r.Status != "pass" {
+		r.Remedy = remedy
+	}
+	return r
+}
+
+// printRemedies lists what to do about the checks that did not pass, with
+// their details
+func printRemedies(results []DiagnosticResult) {
+	printed := false
+	for _, r := range results {
+		if r.Status == "pass" || r.Remedy == "" {
+			continue
+		}
+		if !printed {
+			fmt.Println()
+			fmt.Println(i18n.T("How to fix:"))
+			printed = true
+		}
+		fmt.Printf("  %s %s: %s\n", getStatusIcon(r.Status), r.Name, r.Remedy)
+		if r.Details != "" {
+			fmt.Printf("      └─ %s\n", r.Details)
+		}
+	}
+}
+
+// checkConfigServer checks that the config server answers its liveness
+// probe
+func checkConfigServer(cfg *config.Config) DiagnosticResult {
+	serverURL, err := configServerURL(cfg, doctorConfigServerURL)
+	if err != nil {
+		return DiagnosticResult{Name: "Config Server", Status: "warn", Message: err.Error(),
+			Remedy: i18n.T("Fix %s, or give the address with --config-server-url", agentConfigPath)}
+	}
+	if serverURL == "" {
+		return DiagnosticResult{Name: "Config Server", Status: "pass", Message: i18n.T("Not configured")}
+	}
+	result := withRemedy(checkHTTPService("Config Server", strings.TrimRight(serverURL, "/")+"/health/live"),
+		i18n.T("Check that the config server runs and that %s is reachable from this host", serverURL))
+	if result.Status == "pass" {
+		result.Message = serverURL
+	}
+	return result
+}
+
+// checkSlurm checks that the Slurm controller answers, through slurmrestd
+// when it is configured. Hosts without Slurm pass.
+func checkSlurm(cfg *config.Config) DiagnosticResult {
+	result := DiagnosticResult{Name: "Slurm", Status: "pass"}
+	if _, err := exec.LookPath("sinfo"); err != nil && cfg.Slurm.REST.Endpoint == "" {
+		result.Message = i18n.T("Not configured")
+		return result
+	}
+
+	client, err := slurmClientFor(cfg)
+	if err != nil {
+		result.Status = "fail"
+		result.Message = err.Error()
+		result.Remedy = i18n.T("Fix slurm.rest in the config")
+		return result
+	}
+	var fallback error
+	client.OnFallback = func(op string, err error) { fallback = err }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	partitions, err := client.GetPartitions(ctx)
+	switch {
+	case err != nil:
+		result.Status = "fail"
+		result.Message = i18n.T("Controller unreachable")
+		result.Details = err.Error()
+		result.Remedy = i18n.T("Check that slurmctld runs (scontrol ping) and that this host can reach it")
+	case fallback != nil:
+		result.Status = "warn"
+		result.Message = i18n.T("slurmrestd unreachable, using Slurm commands")
+		result.Details = fallback.Error()
+		result.Remedy = i18n.T("Check that slurmrestd runs at %s and that slurm.rest.token or slurm.rest.user can authenticate", cfg.Slurm.REST.Endpoint)
+	default:
+		result.Message = i18n.T("%d partition(s)", len(partitions))
+		if cfg.Slurm.REST.Endpoint != "" {
+			result.Message += ", slurmrestd"
+		}
+	}
+	return result
+}
+
+// checkRuleNamespaces checks that the rule directories of namespaces have
+// the ownership and mode of the config, so each team's Prometheus can read
+// them and no other team can
+func checkRuleNamespaces(cfg *config.Config) DiagnosticResult {
+	result := DiagnosticResult{Name: "Rule Namespaces", Status: "pass"}
+	var problems, fixes []string
+	for _, ns := range cfg.Alerts.Namespaces {
+		p, err := prometheus.NamespacePermissionProblems(ns)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if len(p) > 0 {
+			problems = append(problems, p...)
+			fixes = append(fixes, namespaceFix(ns))
+		}
+	}
+	if len(problems) == 0 {
+		result.Message = i18n.T("%d namespace(s) with the configured ownership and mode", len(cfg.Alerts.Namespaces))
+		return result
+	}
+	result.Status = "warn"
+	result.Message = i18n.T("%d permission problem(s)", len(problems))
+	result.Details = strings.Join(problems, "; ")
+	result.Remedy = i18n.T("Fix alerts.namespaces in the config")
+	if len(fixes) > 0 {
+		result.Remedy = i18n.T("As root, run: %s", strings.Join(fixes, "; "))
+	}
+	return result
+}
+
+// namespaceFix returns the commands giving a namespace's rule directory
+// and files the ownership and modes of its config
+func namespaceFix(ns config.RuleNamespace) string {
+	fileMode, dirMode, _ := prometheus.NamespaceModes(ns)
+	dir := filepath.Join(prometheus.RulesDir, ns.Name)
+	var cmds []string
+	if ns.Owner != "" || ns.Group != "" {
+		// "owner:" is the owner with its login group
+		owner := ns.Owner + ":" + ns.Group
+		cmds = append(cmds, fmt.Sprintf("chown -R %s %s", owner, dir))
+	}
+	cmds = append(cmds,
+		fmt.Sprintf("chmod %04o %s", dirMode, dir),
+		fmt.Sprintf("chmod %04o %s/*.yaml", fileMode, dir))
+	return strings.Join(cmds, " && ")
+}
+
+// checkAgentVersions checks that every node's node_exporter reports, and
+// at the version AAMI installs
+func checkAgentVersions(cfg *config.Config, prometheusURL string) DiagnosticResult {
+	result := DiagnosticResult{Name: "Agent Versions", Status: "pass"}
+	if len(cfg.Nodes) == 0 {
+		result.Message = i18n.T("No nodes configured")
+		return result
+	}
+	want := installer.Components["node_exporter"].Version
+
+	resp, err := health.NewPrometheusClient(prometheusURL).Query("node_exporter_build_info")
+	if err != nil {
+		result.Status = "warn"
+		result.Message = i18n.T("Could not query Prometheus")
+		result.Details = err.Error()
+		result.Remedy = i18n.T("Fix Prometheus first")
+		return result
+	}
+	versions := make(map[string]string) // by node name and by IP
+	for _, r := range resp.Data.Result {
+		version := strings.TrimPrefix(r.Metric["version"], "v")
+		if node := r.Metric["node"]; node != "" {
+			versions[node] = version
+		}
+		if host, _, err := net.SplitHostPort(r.Metric["instance"]); err == nil {
+			versions[host] = version
+		}
+	}
+
+	var missing, outdated, details []string
+	for _, node := range cfg.Nodes {
+		version, ok := versions[node.Name]
+		if !ok {
+			version, ok = versions[node.IP]
+		}
+		switch {
+		case !ok:
+			missing = append(missing, node.Name)
+		case version != want:
+			outdated = append(outdated, node.Name)
+			details = append(details, fmt.Sprintf("%s: %s", node.Name, defaultString(version, "unknown")))
+		}
+	}
+	if len(missing) == 0 && len(outdated) == 0 {
+		result.Message = i18n.T("node_exporter %s on all %d node(s)", want, len(cfg.Nodes))
+		return result
+	}
+
+	result.Status = "warn"
+	var msgs []string
+	if len(outdated) > 0 {
+		msgs = append(msgs, i18n.T("%d node(s) not at node_exporter %s", len(outdated), want))
+	}
+	if len(missing) > 0 {
+		msgs = append(msgs, i18n.T("%d node(s) not reporting", len(missing)))
+		details = append(details, i18n.T("not reporting: %s", strings.Join(missing, ", ")))
+	}
+	result.Message = strings.Join(msgs, ", ")
+	result.Details = strings.Join(details, "; ")
+	result.Remedy = i18n.T("Run 'aami nodes install %s'", strings.Join(append(outdated, missing...), " "))
+	return result
+}
+
+// syntheticTestRunning lets a single synthetic alert test run at a time
+var syntheticTestRunning sync.Mutex
+
+// testAlertHandler serves POST /admin/test-alert, running the synthetic
+// alert test against an Alertmanager and returning its steps when it ends
+func testAlertHandler(alertmanagerURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+
+		var req struct {
+			Group    string `json:"group"`
+			Severity string `json:"severity"`
+			Channel  string `json:"channel"`
+			Timeout  string `json:"timeout"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		timeout := synthetic.DefaultTimeout
+		if req.Timeout != "" {
+			if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 {
+				http.Error(w, fmt.Sprintf("invalid timeout %q", req.Timeout), http.StatusBadRequest)
+				return
+			}
+		}
+		opts, err := syntheticOptions(cfg, req.Group, req.Severity, req.Channel, timeout, alertmanagerURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := ensureWritable(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		// Reload Prometheus one at a time with the rule files of the API
+		opts.Reload = ruleQueue.Reload
+
+		if !syntheticTestRunning.TryLock() {
+			http.Error(w, "a synthetic alert test is already running", http.StatusConflict)
+			return
+		}
+		defer syntheticTestRunning.Unlock()
+
+		result, err := synthetic.Run(r.Context(), opts, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/drift"
+	"github.com/fregataa/aami/internal/metrics"
+	"github.com/fregataa/aami/internal/output"
+)
+
+// driftQuarantineDir receives the unmanaged files drift healing removes
+const driftQuarantineDir = "/var/lib/aami/quarantine"
+
+var (
+	driftMetricsFile string
+	driftInterval    time.Duration
+	driftOutput      string
+	driftAuditLog    string
+	driftHeal        bool
+	driftListen      string
+)
+
+// defaultDriftListenInterval is how often drift is checked with --listen
+// and no --interval
+const defaultDriftListenInterval = 5 * time.Minute
+
+// driftStatus is the result of the last drift check, served with --listen
+type driftStatus struct {
+	CheckedAt time.Time          `json:"checked_at"`
+	Files     int                `json:"files"`
+	Drift     []drift.Drift      `json:"drift"`
+	Healed    []drift.AuditEvent `json:"healed,omitempty"`
+	Error     string             `json:"error,omitempty"`
+
+	manifest *drift.Manifest
+}
+
+var (
+	lastDriftMu sync.Mutex
+	lastDrift   *driftStatus
+)
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Detect manual edits to generated files",
+	Long: `Detect manual edits to files generated by AAMI.
+
+AAMI records the hash of every rule file, target file, and Prometheus or
+Alertmanager config it writes. Edits made outside AAMI would be silently
+overwritten on the next apply; drift detection reports them first.`,
+}
+
+var driftCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Compare generated files with what AAMI last wrote",
+	Long: `Compare generated files with what AAMI last wrote.
+
+Reports files that were modified or deleted, and files in rule and target
+directories that AAMI did not generate. Exits non-zero when drift is found.
+
+--heal reconciles what it finds: modified and deleted files are restored
+to what AAMI generated, and unmanaged files are moved to
+/var/lib/aami/quarantine, each with an event in the audit log.
+
+--listen keeps checking (every --interval, default 5m) and serves the last
+result on GET /api/v1/prometheus/drift (?kind=rules for rule files only),
+with the aami_drift_* gauges on /metrics.
+
+Examples:
+  aami drift check
+  aami drift check -o json
+  aami drift check --interval 5m \
+    --metrics-file /var/lib/node_exporter/textfile_collector/aami_drift.prom
+  aami drift check --listen :8096 --heal`,
+	Args: cobra.NoArgs,
+	RunE: runDriftCheck,
+}
+
+var driftEnforceCmd = &cobra.Command{
+	Use:   "enforce",
+	Short: "Revert on-disk edits to generated files (immutable mode)",
+	Long: `Watch generated files and revert every change made outside AAMI.
+
+Modified or deleted files are restored from the manifest as soon as the
+change is seen (inotify). Files dropped into rule or target directories
+are moved to /var/lib/aami/quarantine. Each revert is appended to the
+audit log with the changed file and its diff.
+
+Use this on sites where only AAMI may own the rule directory, e.g. as a
+systemd service running "aami drift enforce".
+
+Examples:
+  aami drift enforce
+  aami drift enforce --audit-log /var/log/aami/drift-audit.log`,
+	Args: cobra.NoArgs,
+	RunE: runDriftEnforce,
+}
+
+func init() {
+	driftCheckCmd.Flags().StringVar(&driftMetricsFile, "metrics-file", "",
+		"Write Prometheus metrics to this file")
+	driftCheckCmd.Flags().DurationVar(&driftInterval, "interval", 0,
+		"Keep checking at this interval instead of exiting")
+	driftCheckCmd.Flags().BoolVar(&driftHeal, "heal", false,
+		"Restore drifted files and quarantine unmanaged ones")
+	driftCheckCmd.Flags().StringVar(&driftListen, "listen", "",
+		"Serve the last result over HTTP on this address (e.g. :8096)")
+	addOutputFlag(driftCheckCmd, &driftOutput)
+
+	for _, c := range []*cobra.Command{driftCheckCmd, driftEnforceCmd} {
+		c.Flags().StringVar(&driftAuditLog, "audit-log", drift.DefaultAuditLog,
+			"File receiving an audit event for every reverted change")
+	}
+
+	driftCmd.AddCommand(driftCheckCmd)
+	driftCmd.AddCommand(driftEnforceCmd)
+	rootCmd.AddCommand(driftCmd)
+}
+
+func runDriftCheck(cmd *cobra.Command, args []string) error {
+	if _, err := output.Parse(driftOutput); err != nil {
+		return err
+	}
+	if driftListen != "" {
+		if driftInterval <= 0 {
+			driftInterval = defaultDriftListenInterval
+		}
+		go serveDrift(driftListen)
+	}
+	if driftInterval <= 0 {
+		drifts, healed, err := checkDrift()
+		if err != nil {
+			return err
+		}
+		if len(drifts) > len(healed) {
+			return fmt.Errorf("drift detected in %d file(s)", len(drifts)-len(healed))
+		}
+		return nil
+	}
+
+	for {
+		if _, _, err := checkDrift(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %v\n", color.RedString("✗"), err)
+		}
+		time.Sleep(driftInterval)
+	}
+}
+
+// serveDrift serves the last drift check, and metrics with its gauges
+func serveDrift(addr string) {
+	mux := newAPIMux()
+	mux.Version("v1").HandleFunc("/prometheus/drift", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		lastDriftMu.Lock()
+		status := lastDrift
+		lastDriftMu.Unlock()
+		if status == nil {
+			http.Error(w, "no drift check yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		out := *status
+		if kind := r.URL.Query().Get("kind"); kind != "" {
+			out.Drift, out.Healed = nil, nil
+			for _, d := range status.Drift {
+				if d.Kind == kind {
+					out.Drift = append(out.Drift, d)
+				}
+			}
+			for _, ev := range status.Healed {
+				if ev.Kind == kind {
+					out.Healed = append(out.Healed, ev)
+				}
+			}
+		}
+		if out.Drift == nil {
+			out.Drift = []drift.Drift{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	})
+
+	fmt.Printf("%s Serving drift on http://%s/api/v1/prometheus/drift\n", color.GreenString("✓"), addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", color.RedString("✗"), err)
+		os.Exit(1)
+	}
+}
+
+// writeDriftStatusMetrics writes the gauges of the last drift check, if
+// one ran in this process
+func writeDriftStatusMetrics(w io.Writer) error {
+	lastDriftMu.Lock()
+	status := lastDrift
+	lastDriftMu.Unlock()
+	if status == nil || status.manifest == nil {
+		return nil
+	}
+	return drift.WriteMetrics(w, status.manifest, status.Drift, status.CheckedAt)
+}
+
+// setDriftStatus keeps the result of a drift check for --listen
+func setDriftStatus(manifest *drift.Manifest, drifts []drift.Drift, healed []drift.AuditEvent, err error) {
+	status := &driftStatus{CheckedAt: time.Now().UTC(), Drift: drifts, Healed: healed, manifest: manifest}
+	if manifest != nil {
+		status.Files = len(manifest.Entries)
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	lastDriftMu.Lock()
+	lastDrift = status
+	lastDriftMu.Unlock()
+}
+
+// checkDrift runs one drift check, heals it with --heal, prints the
+// result, and updates metrics
+func checkDrift() (drifts []drift.Drift, healed []drift.AuditEvent, err error) {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	var manifest *drift.Manifest
+	defer func() { setDriftStatus(manifest, drifts, healed, err) }()
+
+	manifest, err = drift.LoadManifest(drift.DefaultManifestPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	drifts, err = drift.Check(manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var healErr error
+	if driftHeal && len(drifts) > 0 {
+		enforcer := &drift.Enforcer{
+			ManifestPath:  drift.DefaultManifestPath,
+			AuditLog:      driftAuditLog,
+			QuarantineDir: driftQuarantineDir,
+		}
+		healed, healErr = enforcer.Revert(manifest, drifts)
+		for _, ev := range healed {
+			metrics.DriftHeals.Inc(ev.Kind, ev.Action)
+		}
+	}
+
+	if driftMetricsFile != "" {
+		if err := writeDriftMetrics(manifest, drifts); err != nil {
+			return drifts, healed, err
+		}
+	}
+
+	if format := output.Format(driftOutput); format.Structured() {
+		if healed != nil {
+			return drifts, healed, writeOutput(format, map[string]interface{}{"drift": drifts, "healed": healed})
+		}
+		return drifts, healed, writeOutput(format, drifts)
+	}
+
+	if len(drifts) == 0 {
+		fmt.Printf("%s %d generated file(s) match\n", green("✓"), len(manifest.Entries))
+		return drifts, nil, nil
+	}
+
+	for _, d := range drifts {
+		switch d.State {
+		case drift.StateModified:
+			fmt.Printf("  %s %-10s %s\n", yellow("~"), d.State, d.Path)
+		case drift.StateMissing:
+			fmt.Printf("  %s %-10s %s\n", red("-"), d.State, d.Path)
+		case drift.StateUnmanaged:
+			fmt.Printf("  %s %-10s %s\n", yellow("+"), d.State, d.Path)
+		}
+	}
+	for _, ev := range healed {
+		fmt.Printf("%s %s %s\n", green("✓"), ev.Action, ev.Path)
+	}
+	if healErr != nil {
+		return drifts, healed, fmt.Errorf("heal drift: %w", healErr)
+	}
+	return drifts, healed, nil
+}
+
+func runDriftEnforce(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	enforcer := &drift.Enforcer{
+		ManifestPath:  drift.DefaultManifestPath,
+		AuditLog:      driftAuditLog,
+		QuarantineDir: driftQuarantineDir,
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+		},
+	}
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	fmt.Printf("%s Enforcing generated files (audit log: %s)\n", green("✓"), driftAuditLog)
+	return enforcer.Enforce(stop)
+}
+
+func writeDriftMetrics(manifest *drift.Manifest, drifts []drift.Drift) error {
+	tmp := filepath.Join(filepath.Dir(driftMetricsFile), "."+filepath.Base(driftMetricsFile)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create metrics file: %w", err)
+	}
+	if err := drift.WriteMetrics(f, manifest, drifts, time.Now()); err != nil {
+		f.Close()
+		return fmt.Errorf("write metrics: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+	return os.Rename(tmp, driftMetricsFile)
+}
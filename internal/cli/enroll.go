@@ -0,0 +1,356 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/enroll"
+)
+
+var (
+	enrollConfigServerURL string
+	enrollGroup           string
+	enrollHostname        string
+	enrollIP              string
+	enrollPort            int
+	enrollLabels          []string
+	enrollWatch           bool
+	enrollTokenTTL        time.Duration
+	enrollTimeout         time.Duration
+	enrollYes             bool
+	enrollForce           bool
+	enrollDryRun          bool
+)
+
+// enrollSteps is the number of steps printed by aami node enroll
+const enrollSteps = 5
+
+var nodesEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Enroll this node with the config server",
+	Long: `Enroll the node it runs on with the config server, in one command:
+
+  1. request a single-use bootstrap token scoped to a group
+  2. register the node with it and save the agent credential
+     (` + enroll.CredentialPath + `) and, if the server issues one, its
+     client certificate for mutual TLS (` + enroll.CertPath + `)
+  3. write the agent config (` + enroll.AgentConfigPath + `)
+  4. install and start the agent's systemd units (the aami-agent.timer, or
+     with --watch aami-agent-watch.service); units the aami-agent package
+     installed are kept
+  5. wait for the agent's first heartbeat
+
+Run it as root on the node, with the aami-agent package installed and a
+context (or --config-server-url and admin.token) allowed to create
+bootstrap tokens. Values not given as flags are asked for on a terminal;
+with --yes the defaults are taken and --group is required.
+
+Examples:
+  sudo aami node enroll
+  sudo aami node enroll --group gpu-servers --label rack=a12 --yes
+  aami node enroll --group gpu-servers --dry-run`,
+	Aliases: []string{"join"},
+	Args:    cobra.NoArgs,
+	RunE:    runNodesEnroll,
+}
+
+func init() {
+	nodesEnrollCmd.Flags().StringVar(&enrollConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	nodesEnrollCmd.Flags().StringVar(&enrollGroup, "group", "", "Group to enroll the node into (ID or name)")
+	nodesEnrollCmd.Flags().StringVar(&enrollHostname, "hostname", "", "Hostname to register (default: the system's)")
+	nodesEnrollCmd.Flags().StringVar(&enrollIP, "ip", "", "IP address to register (default: the first non-loopback address)")
+	nodesEnrollCmd.Flags().IntVar(&enrollPort, "port", 9100, "Node exporter port")
+	nodesEnrollCmd.Flags().StringArrayVar(&enrollLabels, "label", nil, "Label as key=value (repeatable)")
+	nodesEnrollCmd.Flags().BoolVar(&enrollWatch, "watch", false, "Run the agent as a long-running service instead of a timer")
+	nodesEnrollCmd.Flags().DurationVar(&enrollTokenTTL, "token-ttl", time.Hour, "Lifetime of the bootstrap token")
+	nodesEnrollCmd.Flags().DurationVar(&enrollTimeout, "timeout", 3*time.Minute, "How long to wait for the first heartbeat (0 to skip)")
+	nodesEnrollCmd.Flags().BoolVarP(&enrollYes, "yes", "y", false, "Do not ask; take the defaults")
+	nodesEnrollCmd.Flags().BoolVar(&enrollForce, "force", false, "Overwrite an existing agent config")
+	nodesEnrollCmd.Flags().BoolVar(&enrollDryRun, "dry-run", false, "Print what would be done without doing it")
+	nodesEnrollCmd.RegisterFlagCompletionFunc("group", completeGroups)
+
+	nodesCmd.AddCommand(nodesEnrollCmd)
+}
+
+func runNodesEnroll(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	if !enrollDryRun && os.Geteuid() != 0 {
+		return fmt.Errorf("enrolling writes %s and systemd units: run as root, or use --dry-run", enroll.AgentConfigPath)
+	}
+	if !enrollDryRun {
+		if _, err := os.Stat(enroll.AgentBinary); err != nil {
+			return fmt.Errorf("the node agent is not installed (%s): install the aami-agent package first", enroll.AgentBinary)
+		}
+	}
+	labels, err := parseEnrollLabels(enrollLabels)
+	if err != nil {
+		return err
+	}
+
+	// A node has no aami config unless it is also the control node; the
+	// context or --config-server-url then gives the config server
+	cfg, err := loadConfig()
+	if err != nil {
+		if enrollConfigServerURL == "" && currentContext().Server == "" {
+			return err
+		}
+		cfg = &config.Config{}
+	}
+	client, err := configServerClient(cfg, enrollConfigServerURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+
+	p := newPrompter(!enrollYes && isatty.IsTerminal(os.Stdin.Fd()))
+	group, err := enrollGroupOf(ctx, client, p)
+	if err != nil {
+		return err
+	}
+	hostname := enrollHostname
+	if hostname == "" {
+		host, _ := os.Hostname()
+		hostname = p.ask("Hostname", host)
+	}
+	ip := enrollIP
+	if ip == "" {
+		ip = p.ask("IP address", defaultIP())
+	}
+	if hostname == "" || ip == "" {
+		return fmt.Errorf("hostname and IP address are required: use --hostname and --ip")
+	}
+
+	agentConfig, err := enroll.NewAgentConfig(client.BaseURL(), hostname).Render()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(enroll.AgentConfigPath); err == nil && !enrollForce && !enrollDryRun {
+		if !p.confirm(fmt.Sprintf("%s exists. Overwrite it?", enroll.AgentConfigPath)) {
+			return fmt.Errorf("%s exists: use --force to overwrite it", enroll.AgentConfigPath)
+		}
+	}
+
+	fmt.Printf("\nEnrolling %s (%s) into group %s via %s\n", hostname, ip, group.Name, client.BaseURL())
+
+	if enrollDryRun {
+		printEnrollPlan(group, hostname, ip, labels, agentConfig)
+		return nil
+	}
+
+	// 1. Bootstrap token, good for this node only
+	printEnrollStep(1, "Requesting a bootstrap token")
+	token, err := client.CreateBootstrapToken(ctx, configserver.BootstrapTokenRequest{
+		Name:        "enroll-" + hostname,
+		Description: "aami node enroll on " + hostname,
+		GroupID:     group.ID,
+		ExpiresAt:   time.Now().Add(enrollTokenTTL).UTC(),
+		MaxUses:     1,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("       %s Token %s, scoped to %s, expires %s\n",
+		green("✓"), token.Name, group.Name, token.ExpiresAt.Local().Format(time.RFC3339))
+
+	// 2. Registration and the agent's credential
+	printEnrollStep(2, "Registering "+hostname)
+	reg, err := client.Register(ctx, configserver.RegisterRequest{
+		Token:     token.Token,
+		Hostname:  hostname,
+		IPAddress: ip,
+		Port:      enrollPort,
+		Labels:    labels,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("       %s Target %s\n", green("✓"), reg.Target.ID)
+	if reg.Credential.Token != "" {
+		if err := enroll.WriteFile(enroll.CredentialPath, []byte(reg.Credential.Token+"\n"), 0600); err != nil {
+			return err
+		}
+		fmt.Printf("       %s Credential saved to %s\n", green("✓"), enroll.CredentialPath)
+	} else {
+		fmt.Printf("       %s No credential returned; the agent will use none\n", yellow("⚠"))
+	}
+	if reg.Certificate != nil {
+		if err := enroll.WriteCertificate(reg.Certificate); err != nil {
+			return err
+		}
+		fmt.Printf("       %s Client certificate saved to %s, expires %s\n",
+			green("✓"), enroll.CertPath, reg.Certificate.ExpiresAt.Local().Format("2006-01-02"))
+	}
+
+	// 3. Agent config
+	printEnrollStep(3, "Writing the agent config")
+	if err := enroll.WriteFile(enroll.AgentConfigPath, agentConfig, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("       %s %s\n", green("✓"), enroll.AgentConfigPath)
+
+	// 4. systemd units
+	printEnrollStep(4, "Installing the agent's systemd units")
+	started := time.Now()
+	written, err := enroll.InstallUnits(ctx, enrollWatch)
+	for _, path := range written {
+		fmt.Printf("       %s %s\n", green("✓"), path)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("       %s %s enabled and started\n", green("✓"), enroll.EnabledUnit(enrollWatch))
+
+	// 5. First heartbeat
+	if enrollTimeout == 0 {
+		fmt.Printf("\n%s %s enrolled; not waiting for its first heartbeat\n", green("✓"), hostname)
+		return nil
+	}
+	printEnrollStep(5, fmt.Sprintf("Waiting for the first heartbeat (up to %s)", enrollTimeout))
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), enrollTimeout)
+	defer waitCancel()
+	target, err := enroll.WaitForHeartbeat(waitCtx, client, hostname, started, 5*time.Second)
+	if err != nil {
+		service := "aami-agent.service"
+		if enrollWatch {
+			service = enroll.EnabledUnit(true)
+		}
+		return fmt.Errorf("%v; check the agent with: journalctl -u %s", err, service)
+	}
+	fmt.Printf("       %s Heartbeat received at %s\n", green("✓"), target.LastSeen.Local().Format("15:04:05"))
+	fmt.Printf("\n%s %s enrolled into %s\n", green("✓"), hostname, group.Name)
+	return nil
+}
+
+// enrollGroupOf returns the group given with --group, else the one picked
+// from the config server's groups
+func enrollGroupOf(ctx context.Context, client *configserver.Client, p *prompter) (*configserver.Group, error) {
+	if enrollGroup != "" {
+		return client.FindGroup(ctx, enrollGroup)
+	}
+	if !p.interactive {
+		return nil, fmt.Errorf("--group is required")
+	}
+	groups, err := client.ListGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("the config server has no groups: create one first")
+	}
+	fmt.Println("Groups:")
+	for i, g := range groups {
+		fmt.Printf("  %d) %s", i+1, g.Name)
+		if g.Description != "" {
+			fmt.Printf(" - %s", g.Description)
+		}
+		fmt.Println()
+	}
+	answer := p.ask("Group (number or name)", "1")
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(groups) {
+		return &groups[n-1], nil
+	}
+	return client.FindGroup(ctx, answer)
+}
+
+func printEnrollStep(step int, message string) {
+	bold := color.New(color.Bold, color.FgCyan).SprintFunc()
+	fmt.Printf("\n%s %s\n", bold(fmt.Sprintf("[%d/%d]", step, enrollSteps)), message)
+}
+
+// printEnrollPlan prints what enrolling would do
+func printEnrollPlan(group *configserver.Group, hostname, ip string, labels map[string]string, agentConfig []byte) {
+	fmt.Println("\n[DRY-RUN] Would:")
+	fmt.Printf("  1. create a single-use bootstrap token for group %s, expiring in %s\n", group.Name, enrollTokenTTL)
+	fmt.Printf("  2. register %s (%s:%d, labels %s) and save its credential to %s\n",
+		hostname, ip, enrollPort, formatLabels(labels), enroll.CredentialPath)
+	fmt.Printf("     and its client certificate, if issued, to %s\n", enroll.CertPath)
+	fmt.Printf("  3. write %s:\n", enroll.AgentConfigPath)
+	for _, line := range strings.Split(strings.TrimRight(string(agentConfig), "\n"), "\n") {
+		fmt.Printf("       %s\n", line)
+	}
+	fmt.Printf("  4. install the units missing from %s and enable %s:", enroll.UnitDir, enroll.EnabledUnit(enrollWatch))
+	for _, u := range enroll.Units(enrollWatch) {
+		fmt.Printf(" %s", u.Name)
+	}
+	fmt.Println()
+	if enrollTimeout > 0 {
+		fmt.Printf("  5. wait up to %s for the first heartbeat\n", enrollTimeout)
+	}
+}
+
+// parseEnrollLabels parses key=value labels
+func parseEnrollLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", pair)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// defaultIP returns the first non-loopback IPv4 address of the host
+func defaultIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
+
+// prompter asks for values on a terminal, and takes the defaults
+// elsewhere
+type prompter struct {
+	interactive bool
+	reader      *bufio.Reader
+}
+
+func newPrompter(interactive bool) *prompter {
+	return &prompter{interactive: interactive, reader: bufio.NewReader(os.Stdin)}
+}
+
+// ask returns the answer to a question, or def for an empty answer
+func (p *prompter) ask(question, def string) string {
+	if !p.interactive {
+		return def
+	}
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, _ := p.reader.ReadString('\n')
+	return defaultString(strings.TrimSpace(answer), def)
+}
+
+// confirm asks a yes/no question, no by default
+func (p *prompter) confirm(question string) bool {
+	if !p.interactive {
+		return false
+	}
+	answer := p.ask(question+" [y/N]", "")
+	return answer == "y" || answer == "Y"
+}
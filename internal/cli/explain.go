@@ -8,13 +8,14 @@ import (
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/fregataa/aami/internal/gpuevents"
 	"github.com/fregataa/aami/internal/xid"
 )
 
 var explainCmd = &cobra.Command{
 	Use:   "explain",
 	Short: "Explain error codes",
-	Long:  "Provides detailed explanations for various error codes including NVIDIA Xid errors.",
+	Long:  "Provides detailed explanations for various error codes including NVIDIA Xid and NVSwitch SXid errors.",
 }
 
 var explainXidCmd = &cobra.Command{
@@ -38,9 +39,33 @@ var explainXidListCmd = &cobra.Command{
 	RunE:  runExplainXidList,
 }
 
+var explainSXidCmd = &cobra.Command{
+	Use:   "sxid [code]",
+	Short: "Explain NVSwitch SXid error code",
+	Long: `Explain an NVSwitch SXid error code with its severity and recommended action.
+
+SXid errors are logged by the NVSwitch driver on HGX/DGX systems when the
+NVLink fabric between GPUs has problems. Fatal SXids stop the NVSwitch from
+routing until it is reset.
+
+Examples:
+  aami explain sxid 20034   # Explain SXid 20034 (NVLink down on a port)
+  aami explain sxid list`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExplainSXid,
+}
+
+var explainSXidListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all known SXid error codes",
+	RunE:  runExplainSXidList,
+}
+
 func init() {
 	explainXidCmd.AddCommand(explainXidListCmd)
+	explainSXidCmd.AddCommand(explainSXidListCmd)
 	explainCmd.AddCommand(explainXidCmd)
+	explainCmd.AddCommand(explainSXidCmd)
 	rootCmd.AddCommand(explainCmd)
 }
 
@@ -52,6 +77,11 @@ func runExplainXid(cmd *cobra.Command, args []string) error {
 
 	info, ok := xid.GetXidInfo(code)
 	if !ok {
+		// Codes without a long description still have a line in gpuevents
+		if c, ok := gpuevents.Lookup(gpuevents.KindXid, code); ok {
+			printEventCode(c)
+			return nil
+		}
 		return fmt.Errorf("unknown Xid code: %d\nRun 'aami explain xid list' to see all known codes", code)
 	}
 
@@ -136,6 +166,55 @@ func runExplainXidList(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runExplainSXid(cmd *cobra.Command, args []string) error {
+	code, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid SXid code: %s", args[0])
+	}
+	c, ok := gpuevents.Lookup(gpuevents.KindSXid, code)
+	if !ok {
+		return fmt.Errorf("unknown SXid code: %d\nRun 'aami explain sxid list' to see all known codes, or see %s",
+			code, gpuevents.References[gpuevents.KindSXid])
+	}
+	printEventCode(c)
+	return nil
+}
+
+func runExplainSXidList(cmd *cobra.Command, args []string) error {
+	fmt.Println("\nKnown SXid Error Codes:")
+	fmt.Println()
+	for _, c := range gpuevents.Codes(gpuevents.KindSXid) {
+		fmt.Printf("  SXid %-5d  %s  %s\n", c.Code, eventSeverity(c.Severity, "%-8s"), c.Meaning)
+	}
+	fmt.Println()
+	fmt.Println("Use 'aami explain sxid <code>' for the recommended action.")
+	fmt.Println()
+	return nil
+}
+
+// printEventCode prints a code of the gpuevents table
+func printEventCode(c gpuevents.Code) {
+	bold := color.New(color.Bold).SprintFunc()
+	fmt.Println()
+	fmt.Printf("%s\n", bold(c.Label()+": "+c.Meaning))
+	fmt.Printf("  Severity:  %s\n", eventSeverity(c.Severity, "%s"))
+	fmt.Printf("  Action:    %s\n", c.Action)
+	fmt.Printf("  Reference: %s\n", gpuevents.References[c.Kind])
+	fmt.Println()
+}
+
+// eventSeverity colors a gpuevents severity
+func eventSeverity(severity, format string) string {
+	text := fmt.Sprintf(format, severity)
+	switch severity {
+	case gpuevents.SeverityCritical:
+		return color.RedString(text)
+	case gpuevents.SeverityWarning:
+		return color.YellowString(text)
+	}
+	return text
+}
+
 func printWrapped(text string, width int, prefix string) {
 	words := []rune(text)
 	line := ""
@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
 	"github.com/fregataa/aami/internal/federation"
@@ -20,6 +21,23 @@ var (
 	federationShardBy    string
 	federationDryRun     bool
 	federationForce      bool
+	federationType       string
+	federationObjstore   string
+	federationRWURL      string
+	federationRWBackend  string
+	federationRWTenant   string
+	federationAMHA       bool
+	federationAMReplicas int
+
+	// rebalance only suggests moves by default
+	federationRebalanceDryRun bool
+
+	federationAutoscaleWatch     bool
+	federationAutoscaleApply     bool
+	federationAutoscaleInterval  time.Duration
+	federationAutoscaleCooldown  time.Duration
+	federationAutoscaleMaxSeries int64
+	federationAutoscaleMaxScrape time.Duration
 )
 
 var federationCmd = &cobra.Command{
@@ -33,8 +51,13 @@ with a central Prometheus aggregating metrics from all shards.
 Examples:
   aami federation enable --shards 3      # Enable with 3 shards
   aami federation enable --by rack       # Shard by rack labels
+  aami federation enable --type thanos --objstore-config objstore.yml
+  aami federation enable --type remote-write --remote-write-url http://vm:8428/api/v1/write
+  aami federation enable --alertmanager-ha  # Clustered Alertmanager
   aami federation status                 # Show federation status
   aami federation rebalance              # Rebalance nodes across shards
+  aami federation history                # Show applied rebalances
+  aami federation autoscale --watch      # Recommend splitting loaded shards
   aami federation disable                # Disable federation`,
 }
 
@@ -52,7 +75,24 @@ This will:
 Sharding strategies:
   auto  - Automatically distribute nodes evenly (default)
   rack  - Distribute based on 'rack' label in node config
-  count - Fixed number of nodes per shard`,
+  count - Fixed number of nodes per shard
+
+Federation types:
+  prometheus - A central Prometheus scrapes /federate of every shard (default)
+  thanos     - A Thanos sidecar next to each shard uploads its blocks to
+               object storage; a store gateway serves them and Thanos Query
+               gives the global view on the central port. Shards evaluate
+               the alert rules themselves. Requires --objstore-config, a
+               Thanos objstore.yml (S3, GCS, AZURE, FILESYSTEM, ...).
+  remote-write - Each shard pushes its samples to a central VictoriaMetrics
+               or Mimir (--remote-write-backend), which gives the global
+               view. Shards evaluate the alert rules themselves. Requires
+               --remote-write-url, the write endpoint of the backend.
+
+With --alertmanager-ha, a clustered Alertmanager replaces the single one on
+:9093: one replica per shard (2 to 3, or --alertmanager-replicas) on ports
+9193+, gossiping over their mesh ports, and every shard and the central
+Prometheus send alerts to all replicas.`,
 	RunE: runFederationEnable,
 }
 
@@ -78,10 +118,40 @@ var federationRebalanceCmd = &cobra.Command{
 	Short: "Rebalance nodes across shards",
 	Long: `Analyze and suggest rebalancing of nodes across shards.
 
-Use --apply to actually move nodes between shards.`,
+Use --dry-run=false to actually move nodes between shards. The shards
+gaining nodes are updated and reloaded before the shards losing them, so
+no node goes unscraped, and the moves are recorded in the rebalance
+history (aami federation history).`,
 	RunE: runFederationRebalance,
 }
 
+var federationAutoscaleCmd = &cobra.Command{
+	Use:   "autoscale",
+	Short: "Split shards that outgrow their series or scrape limits",
+	Long: `Check the head series (TSDB headStats.numSeries) and the slowest scrape
+of every shard, and recommend splitting the shards over the limits.
+
+With --apply, a shard is split by deploying and starting new shards, adding
+them to the global view and moving an even share of its nodes to them, as
+in rebalance. With --watch, the check repeats every --interval; a shard is
+not split again within --cooldown, as its head keeps the series of moved
+nodes until the next head compaction.
+
+Examples:
+  aami federation autoscale                          # Check once
+  aami federation autoscale --watch                  # Recommend every 5m
+  aami federation autoscale --watch --apply          # Split automatically
+  aami federation autoscale --max-series 1500000 --max-scrape-duration 8s`,
+	Args: cobra.NoArgs,
+	RunE: runFederationAutoscale,
+}
+
+var federationHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show applied rebalances",
+	RunE:  runFederationHistory,
+}
+
 var federationValidateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate federation configuration",
@@ -106,17 +176,48 @@ func init() {
 		"Show what would be done without making changes")
 	federationEnableCmd.Flags().BoolVar(&federationForce, "force", false,
 		"Force enable even with few nodes")
+	federationEnableCmd.Flags().StringVar(&federationType, "type", string(federation.FederationTypePrometheus),
+		"Federation type: prometheus, thanos, remote-write")
+	federationEnableCmd.Flags().StringVar(&federationObjstore, "objstore-config", "",
+		"Thanos object storage config (objstore.yml) for --type thanos")
+	federationEnableCmd.Flags().StringVar(&federationRWURL, "remote-write-url", "",
+		"Write endpoint of the backend for --type remote-write")
+	federationEnableCmd.Flags().StringVar(&federationRWBackend, "remote-write-backend", federation.RemoteWriteVictoriaMetrics,
+		"Remote write backend: victoriametrics, mimir")
+	federationEnableCmd.Flags().StringVar(&federationRWTenant, "remote-write-tenant", "",
+		"Mimir tenant (X-Scope-OrgID) for --type remote-write")
+	federationEnableCmd.Flags().BoolVar(&federationAMHA, "alertmanager-ha", false,
+		"Deploy a clustered Alertmanager")
+	federationEnableCmd.Flags().IntVar(&federationAMReplicas, "alertmanager-replicas", 0,
+		"Alertmanager replicas with --alertmanager-ha (0 = one per shard, 2 to 3)")
 
 	// Rebalance flags
-	federationRebalanceCmd.Flags().BoolVar(&federationDryRun, "dry-run", true,
+	federationRebalanceCmd.Flags().BoolVar(&federationRebalanceDryRun, "dry-run", true,
 		"Show suggested changes without applying")
 
+	// Autoscale flags
+	policy := federation.DefaultAutoscalePolicy()
+	federationAutoscaleCmd.Flags().BoolVar(&federationAutoscaleWatch, "watch", false,
+		"Keep checking the shards")
+	federationAutoscaleCmd.Flags().BoolVar(&federationAutoscaleApply, "apply", false,
+		"Split the shards over the limits instead of only recommending it")
+	federationAutoscaleCmd.Flags().DurationVar(&federationAutoscaleInterval, "interval", 5*time.Minute,
+		"Time between checks with --watch")
+	federationAutoscaleCmd.Flags().DurationVar(&federationAutoscaleCooldown, "cooldown", 3*time.Hour,
+		"Time before a split shard is considered again with --watch")
+	federationAutoscaleCmd.Flags().Int64Var(&federationAutoscaleMaxSeries, "max-series", policy.MaxSeries,
+		"Head series per shard beyond which it is split")
+	federationAutoscaleCmd.Flags().DurationVar(&federationAutoscaleMaxScrape, "max-scrape-duration", policy.MaxScrapeDuration,
+		"Slowest scrape per shard beyond which it is split")
+
 	federationCmd.AddCommand(federationEnableCmd)
 	federationCmd.AddCommand(federationDisableCmd)
 	federationCmd.AddCommand(federationStatusCmd)
 	federationCmd.AddCommand(federationRebalanceCmd)
 	federationCmd.AddCommand(federationValidateCmd)
 	federationCmd.AddCommand(federationShardsCmd)
+	federationCmd.AddCommand(federationHistoryCmd)
+	federationCmd.AddCommand(federationAutoscaleCmd)
 }
 
 func runFederationEnable(cmd *cobra.Command, args []string) error {
@@ -127,6 +228,34 @@ func runFederationEnable(cmd *cobra.Command, args []string) error {
 
 	nodeCount := len(cfg.Nodes)
 
+	fedType := federation.FederationType(federationType)
+	switch fedType {
+	case federation.FederationTypePrometheus:
+	case federation.FederationTypeThanos:
+		if federationObjstore == "" {
+			return fmt.Errorf("--type thanos requires --objstore-config")
+		}
+		if _, err := federation.LoadObjectStorage(federationObjstore); err != nil {
+			return err
+		}
+	case federation.FederationTypeRemoteWrite:
+		if federationRWURL == "" {
+			return fmt.Errorf("--type remote-write requires --remote-write-url")
+		}
+	default:
+		return fmt.Errorf("unknown federation type: %s (must be prometheus, thanos or remote-write)", federationType)
+	}
+	if federationAMReplicas != 0 && (!federationAMHA || federationAMReplicas < 2) {
+		return fmt.Errorf("--alertmanager-replicas must be at least 2, with --alertmanager-ha")
+	}
+	remoteWrite := federation.DefaultRemoteWriteConfig(federationRWBackend, federationRWURL)
+	remoteWrite.TenantID = federationRWTenant
+	if fedType == federation.FederationTypeRemoteWrite {
+		if err := remoteWrite.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// Warn if node count is low
 	if nodeCount < 100 && !federationForce {
 		color.Yellow("Warning: Federation is recommended for 500+ nodes.")
@@ -148,6 +277,17 @@ func runFederationEnable(cmd *cobra.Command, args []string) error {
 	// Create federation config
 	fedConfig := federation.DefaultFederationConfig()
 	fedConfig.Enabled = true
+	fedConfig.Type = fedType
+	if fedType == federation.FederationTypeThanos {
+		fedConfig.Thanos = federation.DefaultThanosConfig()
+		// Recorded as an absolute path, as deploy copies it
+		if fedConfig.Thanos.ObjectStorageConfig, err = filepath.Abs(federationObjstore); err != nil {
+			return err
+		}
+	}
+	if fedType == federation.FederationTypeRemoteWrite {
+		fedConfig.RemoteWrite = remoteWrite
+	}
 
 	manager := federation.NewManager(cfg, fedConfig)
 
@@ -159,13 +299,25 @@ func runFederationEnable(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("no shards calculated - check node configuration")
 	}
 
+	alertmanagerHA := federation.DefaultAlertmanagerConfig(len(shards))
+	if federationAMReplicas > 0 {
+		alertmanagerHA.Replicas = federationAMReplicas
+	}
+
 	// Display shard plan
+	fmt.Printf("Federation type: %s\n", fedType)
+	if fedType == federation.FederationTypeRemoteWrite {
+		fmt.Printf("Remote write: %s (%s)\n", remoteWrite.URL, remoteWrite.Backend)
+	}
+	if federationAMHA {
+		fmt.Printf("Alertmanager replicas: %d\n", alertmanagerHA.Replicas)
+	}
 	fmt.Printf("Sharding strategy: %s\n", federationShardBy)
 	fmt.Printf("Total nodes: %d\n", nodeCount)
 	fmt.Printf("Shard count: %d\n", len(shards))
 	fmt.Println()
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Shard", "Nodes", "Port", "Storage Path"})
 	table.SetBorder(false)
 
@@ -197,13 +349,27 @@ func runFederationEnable(cmd *cobra.Command, args []string) error {
 		fmt.Println("Would create:")
 		fmt.Println("  - Prometheus config for each shard")
 		fmt.Println("  - Systemd service for each shard")
-		fmt.Println("  - Central Prometheus federation config")
+		if fedType == federation.FederationTypeThanos {
+			fmt.Println("  - Thanos sidecar service for each shard")
+			fmt.Println("  - Thanos store gateway and query services")
+			fmt.Println("  - Object storage config for the Thanos components")
+		} else if fedType == federation.FederationTypeRemoteWrite {
+			fmt.Printf("  - Remote write to %s in each shard config\n", remoteWrite.URL)
+		} else {
+			fmt.Println("  - Central Prometheus federation config")
+		}
+		if federationAMHA {
+			fmt.Printf("  - Systemd service for each of the %d Alertmanager replicas\n", alertmanagerHA.Replicas)
+		}
 		fmt.Println("  - Recording rules for aggregation")
 		return nil
 	}
 
 	// Set shards and deploy
 	manager.SetShards(shards)
+	if federationAMHA {
+		manager.SetAlertmanager(alertmanagerHA)
+	}
 
 	fmt.Println("Deploying federation configuration...")
 
@@ -228,8 +394,24 @@ func runFederationEnable(cmd *cobra.Command, args []string) error {
 	for _, shard := range shards {
 		fmt.Printf("     sudo systemctl start aami-prometheus-%s\n", shard.Name)
 	}
-	fmt.Println("  2. Start central service:")
-	fmt.Println("     sudo systemctl start aami-prometheus-central")
+	switch fedType {
+	case federation.FederationTypeThanos:
+		fmt.Println("  2. Start Thanos services:")
+		for _, service := range federation.ThanosServices(manager.GetConfig()) {
+			fmt.Printf("     sudo systemctl start %s\n", service)
+		}
+	case federation.FederationTypeRemoteWrite:
+		fmt.Printf("  2. Add %s as a Grafana data source\n", remoteWrite.Backend)
+	default:
+		fmt.Println("  2. Start central service:")
+		fmt.Println("     sudo systemctl start aami-prometheus-central")
+	}
+	if federationAMHA {
+		fmt.Println("     Start the Alertmanager replicas:")
+		for _, service := range federation.AlertmanagerServices(manager.GetConfig()) {
+			fmt.Printf("     sudo systemctl start %s\n", service)
+		}
+	}
 	fmt.Println("  3. Verify status:")
 	fmt.Println("     aami federation status")
 
@@ -320,11 +502,38 @@ func runFederationStatus(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Central:      %s %s (%s)\n", centralIcon, centralStatus, status.Central.Endpoint)
 	fmt.Println()
 
+	if len(status.Alertmanagers) > 0 {
+		fmt.Println("Alertmanager Cluster")
+		fmt.Println(strings.Repeat("-", 60))
+
+		amTable := newTable()
+		amTable.SetHeader([]string{"Name", "Endpoint", "Cluster", "Peers", "Status"})
+		amTable.SetBorder(false)
+		for _, am := range status.Alertmanagers {
+			statusStr := color.GreenString("Healthy")
+			if !am.Healthy {
+				statusStr = color.RedString("Error")
+			} else if am.Peers < len(status.Alertmanagers) {
+				// The replica does not see all of the others
+				statusStr = color.YellowString("Partitioned")
+			}
+			amTable.Append([]string{
+				am.Name,
+				am.Endpoint,
+				am.ClusterStatus,
+				fmt.Sprintf("%d/%d", am.Peers, len(status.Alertmanagers)),
+				statusStr,
+			})
+		}
+		amTable.Render()
+		fmt.Println()
+	}
+
 	// Shard table
 	fmt.Println("Shards")
 	fmt.Println(strings.Repeat("-", 60))
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Name", "Endpoint", "Nodes", "Metrics", "Status"})
 	table.SetBorder(false)
 
@@ -360,6 +569,16 @@ func runFederationStatus(cmd *cobra.Command, args []string) error {
 			fmt.Printf("  %s: %s\n", shard.Name, shard.Error)
 		}
 	}
+	for _, am := range status.Alertmanagers {
+		if am.Error != "" {
+			if !hasErrors {
+				fmt.Println()
+				color.Red("Errors:")
+				hasErrors = true
+			}
+			fmt.Printf("  %s: %s\n", am.Name, am.Error)
+		}
+	}
 
 	return nil
 }
@@ -393,7 +612,7 @@ func runFederationRebalance(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Println("Suggested node moves:")
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Node", "From", "To"})
 	table.SetBorder(false)
 
@@ -402,19 +621,189 @@ func runFederationRebalance(cmd *cobra.Command, args []string) error {
 	}
 	table.Render()
 
-	if federationDryRun {
+	if federationRebalanceDryRun {
 		fmt.Println()
 		color.Yellow("Dry-run mode - no changes made")
 		fmt.Println("Use --dry-run=false to apply changes")
-	} else {
-		// Would apply rebalancing here
-		_ = cfg
-		color.Green("✓ Rebalancing applied")
+		return nil
+	}
+
+	manager := federation.NewManager(cfg, fedConfig)
+	record, err := manager.ApplyRebalance(context.Background(), moves, currentUser())
+	if err != nil {
+		return fmt.Errorf("rebalance failed: %w", err)
+	}
+
+	fmt.Println()
+	color.Green("✓ Rebalancing applied (%d nodes moved)", len(record.Moves))
+	if len(record.Reloaded) > 0 {
+		fmt.Printf("Reloaded: %s\n", strings.Join(record.Reloaded, ", "))
+	}
+	for _, e := range record.Errors {
+		color.Yellow("Warning: %s (the shard picks up its targets within 30s)", e)
 	}
 
 	return nil
 }
 
+func runFederationAutoscale(cmd *cobra.Command, args []string) error {
+	if federationAutoscaleWatch && federationAutoscaleInterval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	policy := federation.AutoscalePolicy{
+		MaxSeries:         federationAutoscaleMaxSeries,
+		MaxScrapeDuration: federationAutoscaleMaxScrape,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !federationAutoscaleWatch {
+		_, err := autoscaleOnce(ctx, policy, nil, true)
+		return err
+	}
+
+	fmt.Printf("Checking shards every %s (max %d series, max scrape %s)\n",
+		federationAutoscaleInterval, policy.MaxSeries, policy.MaxScrapeDuration)
+	splitAt := make(map[string]time.Time)
+	for {
+		// Shards split within the cooldown, and the shards they were split into
+		cooling := make(map[string]bool)
+		for shard, at := range splitAt {
+			if time.Since(at) < federationAutoscaleCooldown {
+				cooling[shard] = true
+			}
+		}
+		split, err := autoscaleOnce(ctx, policy, cooling, false)
+		if err != nil {
+			discoveryLogf("autoscale: %v", err)
+		}
+		for _, shard := range split {
+			splitAt[shard] = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(federationAutoscaleInterval):
+		}
+	}
+}
+
+// autoscaleOnce checks the shards and, with --apply, splits those over the
+// policy limits, except the cooling ones. It returns the shards involved in
+// a split. The load table is printed when verbose, otherwise the
+// recommendations and splits are logged.
+func autoscaleOnce(ctx context.Context, policy federation.AutoscalePolicy, cooling map[string]bool, verbose bool) ([]string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Reload: the shards may have changed since the last check
+	fedConfig, err := loadFederationConfig()
+	if err != nil {
+		return nil, fmt.Errorf("federation not enabled: %w", err)
+	}
+	manager := federation.NewManager(cfg, fedConfig)
+
+	loads := manager.CollectLoad(ctx)
+	if verbose {
+		table := newTable()
+		table.SetHeader([]string{"Shard", "Nodes", "Series", "Slowest Scrape", "Status"})
+		table.SetBorder(false)
+		for _, load := range loads {
+			status := color.GreenString("OK")
+			if load.Error != "" {
+				status = color.RedString("Error")
+			}
+			table.Append([]string{
+				load.Shard,
+				fmt.Sprintf("%d", load.Nodes),
+				fmt.Sprintf("%d", load.Series),
+				load.ScrapeDuration.Round(time.Millisecond).String(),
+				status,
+			})
+		}
+		table.Render()
+		fmt.Println()
+	}
+	for _, load := range loads {
+		if load.Error != "" {
+			discoveryLogf("%s: %s", load.Shard, load.Error)
+		}
+	}
+
+	var recs []federation.ScaleRecommendation
+	for _, rec := range federation.Recommend(loads, policy) {
+		if !cooling[rec.Shard] {
+			recs = append(recs, rec)
+		}
+	}
+	if len(recs) == 0 {
+		if verbose {
+			color.Green("✓ All shards are within the limits")
+		}
+		return nil, nil
+	}
+
+	var split []string
+	for _, rec := range recs {
+		if !federationAutoscaleApply {
+			discoveryLogf("%s should be split into %d shards: %s", rec.Shard, rec.Parts, rec.Reason)
+			continue
+		}
+
+		discoveryLogf("splitting %s into %d shards: %s", rec.Shard, rec.Parts, rec.Reason)
+		record, err := manager.SplitShard(ctx, rec.Shard, rec.Parts, currentUser())
+		if err != nil {
+			return split, fmt.Errorf("split %s: %w", rec.Shard, err)
+		}
+		split = append(split, rec.Shard)
+		for _, move := range record.Moves {
+			split = append(split, move.ToShard)
+		}
+		discoveryLogf("%s split: %d nodes moved", rec.Shard, len(record.Moves))
+		for _, e := range record.Errors {
+			discoveryLogf("warning: %s", e)
+		}
+	}
+	if !federationAutoscaleApply && verbose {
+		fmt.Println()
+		color.Yellow("Use --apply to split the shards")
+	}
+	return split, nil
+}
+
+func runFederationHistory(cmd *cobra.Command, args []string) error {
+	records, err := federation.LoadRebalanceHistory(federation.DefaultRebalanceHistory)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("No rebalances applied")
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Time", "User", "Node", "From", "To"})
+	table.SetBorder(false)
+
+	for _, r := range records {
+		for _, move := range r.Moves {
+			table.Append([]string{
+				r.Time.Local().Format("2006-01-02 15:04:05"),
+				r.User,
+				move.Node,
+				move.FromShard,
+				move.ToShard,
+			})
+		}
+	}
+	table.Render()
+
+	return nil
+}
+
 func runFederationValidate(cmd *cobra.Command, args []string) error {
 	fedConfig, err := loadFederationConfig()
 	if err != nil {
@@ -464,7 +853,14 @@ func runFederationShards(cmd *cobra.Command, args []string) error {
 
 // loadFederationConfig loads federation configuration from file.
 func loadFederationConfig() (federation.FederationConfig, error) {
-	fedConfigPath := filepath.Join("/etc/aami", "federation", "federation.yaml")
+	fedConfigPath := federation.DefaultConfigPath
+	if fedConfig, err := federation.LoadConfig(fedConfigPath); err == nil {
+		return fedConfig, nil
+	} else if !os.IsNotExist(err) {
+		return federation.FederationConfig{}, err
+	}
+
+	// Federations deployed before federation.yaml was recorded
 
 	// Check if federation directory exists
 	fedDir := filepath.Dir(fedConfigPath)
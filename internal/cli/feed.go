@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/api"
+	"github.com/fregataa/aami/internal/feed"
+	"github.com/fregataa/aami/internal/health"
+)
+
+var (
+	feedTTL             time.Duration
+	feedBaseURL         string
+	feedListen          string
+	feedAlertmanagerURL string
+)
+
+var feedCmd = &cobra.Command{
+	Use:   "feed",
+	Short: "Manage read-only status feeds",
+	Long: `Manage read-only status feeds for wallboards and phone widgets.
+
+A feed serves a compact JSON status (health score, active critical alerts)
+at a signed URL, without API credentials. Each feed can be revoked on its
+own; re-creating a revoked feed issues a new URL.
+
+Examples:
+  aami feed create noc-wallboard                 # Create a feed and print its URL
+  aami feed create oncall-phone --ttl 720h       # Feed expiring in 30 days
+  aami feed list                                 # List feeds
+  aami feed revoke noc-wallboard                 # Revoke a feed
+  aami feed serve --listen :8093                 # Serve feeds`,
+}
+
+var feedCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a feed and print its signed URL",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runFeedCreate,
+}
+
+var feedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List feeds",
+	RunE:  runFeedList,
+}
+
+var feedRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke a feed",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runFeedRevoke,
+}
+
+var feedServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve status feeds over HTTP",
+	Args:  cobra.NoArgs,
+	RunE:  runFeedServe,
+}
+
+func init() {
+	feedCreateCmd.Flags().DurationVar(&feedTTL, "ttl", 0,
+		"Expire the feed after this duration (default: never)")
+	for _, c := range []*cobra.Command{feedCreateCmd, feedListCmd} {
+		c.Flags().StringVar(&feedBaseURL, "base-url", "http://localhost:8093",
+			"Public base URL of the feed server")
+	}
+	feedServeCmd.Flags().StringVar(&feedListen, "listen", ":8093",
+		"Address to listen on")
+	feedServeCmd.Flags().StringVar(&feedAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager URL")
+
+	feedCmd.AddCommand(feedCreateCmd)
+	feedCmd.AddCommand(feedListCmd)
+	feedCmd.AddCommand(feedRevokeCmd)
+	feedCmd.AddCommand(feedServeCmd)
+	rootCmd.AddCommand(feedCmd)
+}
+
+func getFeedStore() (*feed.Store, error) {
+	store := feed.NewStore(feed.DefaultStorePath)
+	if err := store.Load(); err != nil {
+		return nil, fmt.Errorf("load feeds: %w", err)
+	}
+	return store, nil
+}
+
+func runFeedCreate(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getFeedStore()
+	if err != nil {
+		return err
+	}
+
+	f, err := store.Create(args[0], feedTTL)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Feed %s created\n", green("✓"), f.Name)
+	fmt.Printf("  URL: %s%s\n", strings.TrimSuffix(feedBaseURL, "/"), store.Path(f))
+	if !f.ExpiresAt.IsZero() {
+		fmt.Printf("  Expires: %s\n", f.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runFeedList(cmd *cobra.Command, args []string) error {
+	store, err := getFeedStore()
+	if err != nil {
+		return err
+	}
+
+	feeds := store.List()
+	if len(feeds) == 0 {
+		fmt.Println("No feeds. Create one with: aami feed create <name>")
+		return nil
+	}
+
+	now := time.Now()
+	table := newTable()
+	table.SetHeader([]string{"Name", "Status", "Created", "Expires", "URL"})
+	table.SetBorder(true)
+
+	for _, f := range feeds {
+		status := "active"
+		if f.Revoked {
+			status = "revoked"
+		} else if !f.Active(now) {
+			status = "expired"
+		}
+		expires := "never"
+		if !f.ExpiresAt.IsZero() {
+			expires = f.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		url := "-"
+		if status == "active" {
+			url = strings.TrimSuffix(feedBaseURL, "/") + store.Path(f)
+		}
+		table.Append([]string{f.Name, status, f.CreatedAt.Format("2006-01-02 15:04"), expires, url})
+	}
+
+	table.Render()
+	return nil
+}
+
+func runFeedRevoke(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getFeedStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Revoke(args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Feed %s revoked\n", green("✓"), args[0])
+	return nil
+}
+
+func runFeedServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	store, err := getFeedStore()
+	if err != nil {
+		return err
+	}
+
+	handler := feed.NewHandler(store, cfg.Cluster.Name,
+		health.NewPrometheusClient(prometheusURL(cfg)), alertmanager.NewClient(alertmanagerURL(cmd, feedAlertmanagerURL)))
+
+	mux := newAPIMux()
+	mux.Version("v1").HandleWithAlias("/feed/", handler, api.Unversioned)
+
+	fmt.Printf("%s Serving status feeds on http://%s/api/v1/feed/\n", green("✓"), feedListen)
+	return http.ListenAndServe(feedListen, mux)
+}
@@ -0,0 +1,594 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/gitops"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/manifest"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	exportFile   string
+	importFile   string
+	importDryRun bool
+	importPrune  bool
+	importOutput string
+	configListen string
+)
+
+var configExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the whole configuration as a YAML bundle",
+	Long: `Export config.yaml, the rule files under /etc/aami/rules and the
+notification templates the config refers to as one YAML bundle.
+
+The bundle is deterministic: exporting an unchanged configuration gives the
+same file, so it can be committed to git and reviewed as a diff. It holds
+the same secrets as config.yaml; in git, replace them with ${VAR}
+references, which are expanded on import.
+
+Examples:
+  aami config export > aami.yaml
+  aami config export --file gitops/aami.yaml`,
+	Args: cobra.NoArgs,
+	RunE: runConfigExport,
+}
+
+var configImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Apply a configuration bundle",
+	Long: `Apply a bundle made by 'aami config export': save its config, write its
+rule files and templates, and regenerate the target files.
+
+Import is idempotent: only what differs from the current configuration is
+changed, and importing the same bundle again changes nothing. --dry-run
+prints the changes with their diffs without applying them. Rule files not
+in the bundle are kept unless --prune is given; templates are never
+deleted.
+
+Every rule file to write is validated with promtool first, --dry-run
+included; if one fails, nothing is changed and the failing files are
+reported. An import that fails halfway puts the rule files and config back
+as they were. Rule files are written under a temporary name and renamed
+into place, so Prometheus never loads a partial file.
+
+Examples:
+  aami config import --file aami.yaml --dry-run
+  aami config import --file aami.yaml --prune
+  git show main:aami.yaml | aami config import --file -`,
+	Args: cobra.NoArgs,
+	RunE: runConfigImport,
+}
+
+var configServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the configuration export and import API",
+	Long: `Serve the configuration bundle API:
+
+  GET  /api/v1/config/export   The bundle of the current configuration
+  POST /api/v1/config/import   Apply the bundle in the request body
+                               (?dry_run=true, ?prune=true)
+  POST /api/v1/apply           Apply the manifests in the request body,
+                               as aami apply (?dry_run=true, ?prune=true)
+
+Requests authenticate with "Authorization: Bearer <admin.token>".
+
+Examples:
+  aami config serve --listen :8110`,
+	Args: cobra.NoArgs,
+	RunE: runConfigServe,
+}
+
+func init() {
+	configExportCmd.Flags().StringVarP(&exportFile, "file", "f", "",
+		"Write the bundle to a file instead of stdout")
+	configImportCmd.Flags().StringVarP(&importFile, "file", "f", "",
+		"Bundle to apply (- for stdin)")
+	configImportCmd.Flags().BoolVar(&importDryRun, "dry-run", false,
+		"Show the changes without applying them")
+	configImportCmd.Flags().BoolVar(&importPrune, "prune", false,
+		"Delete rule files that are not in the bundle")
+	addOutputFlag(configImportCmd, &importOutput)
+	configImportCmd.MarkFlagRequired("file")
+	configServeCmd.Flags().StringVar(&configListen, "listen", ":8110",
+		"Address to listen on")
+
+	configCmd.AddCommand(configExportCmd)
+	configCmd.AddCommand(configImportCmd)
+	configCmd.AddCommand(configServeCmd)
+}
+
+func runConfigExport(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	bundle, err := gitops.Export(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := bundle.Marshal()
+	if err != nil {
+		return err
+	}
+	if exportFile == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(exportFile, data, 0600); err != nil {
+		return err
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Exported configuration to %s", exportFile))
+	return nil
+}
+
+func runConfigImport(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(importOutput)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if importFile == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(importFile)
+	}
+	if err != nil {
+		return fmt.Errorf("read bundle: %w", err)
+	}
+
+	desired, err := gitops.Parse(data)
+	if err != nil {
+		return err
+	}
+	changes, err := importBundle(desired, importDryRun, importPrune)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, importResponse(changes, importDryRun))
+	}
+	printBundleChanges(changes, importDryRun)
+	return nil
+}
+
+// configImport serializes imports and applies, which load, compare and save
+// the config
+var configImport sync.Mutex
+
+// importBundle applies a bundle to the current configuration and returns
+// the changes it made, or would make with dryRun
+func importBundle(desired *gitops.Bundle, dryRun, prune bool) ([]gitops.Change, error) {
+	configImport.Lock()
+	defer configImport.Unlock()
+
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return nil, err
+	}
+	current, err := gitops.Export(cfg)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := gitops.Plan(current, desired, prune)
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+	if err := checkBundleRules(desired, changes); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return changes, nil
+	}
+	if err := ensureWritable(); err != nil {
+		return nil, err
+	}
+	return changes, applyBundle(cfg, version, desired, changes)
+}
+
+// ruleValidationError lists the rule files of a bundle promtool rejects
+type ruleValidationError struct {
+	Files map[string]string // bundle path -> promtool output
+}
+
+func (e *ruleValidationError) Error() string {
+	paths := make([]string, 0, len(e.Files))
+	for path := range e.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d rule file(s) failed validation, nothing was changed:", len(paths))
+	for _, path := range paths {
+		fmt.Fprintf(&sb, "\n%s:\n%s", path, strings.TrimRight(e.Files[path], "\n"))
+	}
+	return sb.String()
+}
+
+// checkBundleRules validates every rule file a plan writes with promtool,
+// before any is written. Without promtool the files are not checked.
+func checkBundleRules(desired *gitops.Bundle, changes []gitops.Change) error {
+	failed := map[string]string{}
+	for _, c := range changes {
+		if c.Kind != gitops.KindRules || c.Action == gitops.ActionDelete {
+			continue
+		}
+		check, err := prometheus.CheckRules(context.Background(), c.Path, []byte(desired.File(c)))
+		if err != nil {
+			return err
+		}
+		if !check.Valid && !check.Skipped {
+			failed[c.Path] = check.Output
+		}
+	}
+	if len(failed) > 0 {
+		return &ruleValidationError{Files: failed}
+	}
+	return nil
+}
+
+// fileSnapshot is a rule file or template as it was before an import
+// changed it
+type fileSnapshot struct {
+	kind    string // gitops.KindRules or gitops.KindTemplate
+	key     string // rule file key in the bundle, or template path
+	content []byte // nil if the file did not exist
+}
+
+// snapshotFile reads the file at path before an import changes it
+func snapshotFile(kind, key, path string) (fileSnapshot, error) {
+	snapshot := fileSnapshot{kind: kind, key: key}
+	if data, err := os.ReadFile(path); err == nil {
+		snapshot.content = data
+	} else if !os.IsNotExist(err) {
+		return snapshot, fmt.Errorf("read %s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// applyBundle makes the changes of a plan in order. The config is saved
+// first, so rule files are written with the bundle's namespaces and quotas.
+// If a change fails, the rule
+// files and templates already changed and the config are put back as they
+// were in previous, loaded at version, so an import is applied whole or not
+// at all.
+func applyBundle(previous *config.Config, version string, desired *gitops.Bundle, changes []gitops.Change) (err error) {
+	var snapshots []fileSnapshot
+	savedVersion := "" // version of the desired config, once saved
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rollbackErr := rollbackBundle(previous, savedVersion, snapshots); rollbackErr != nil {
+			err = fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+	}()
+
+	for _, c := range changes {
+		switch c.Kind {
+		case gitops.KindConfig:
+			saved, err := saveConfig(desired.Config, version)
+			if err != nil {
+				return err
+			}
+			savedVersion = saved
+			if err := prometheus.GenerateAllTargets(desired.Config.Nodes, prometheus.DefaultTargetsDir); err != nil {
+				return err
+			}
+
+		case gitops.KindRules:
+			path, namespace := gitops.RulePath(c.Path)
+			snapshot, err := snapshotFile(c.Kind, c.Path, path)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+
+			if c.Action == gitops.ActionDelete {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("delete rule file: %w", err)
+				}
+				continue
+			}
+			ns := config.RuleNamespace{}
+			if namespace != "" {
+				ns = prometheus.FindRuleNamespace(desired.Config, namespace)
+			}
+			content := []byte(desired.File(c))
+			written, err := prometheus.WriteRuleFile(ns, filepath.Base(path), content)
+			if err != nil {
+				return err
+			}
+			if _, err := publishRuleFile(desired.Config, written, content); err != nil {
+				return err
+			}
+
+		case gitops.KindTemplate:
+			path, err := gitops.TemplatePath(c.Path)
+			if err != nil {
+				return err
+			}
+			snapshot, err := snapshotFile(c.Kind, path, path)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("create template directory: %w", err)
+			}
+			if err := os.WriteFile(path, []byte(desired.File(c)), 0644); err != nil {
+				return fmt.Errorf("write template: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// rollbackBundle puts back the rule files and templates an import changed,
+// newest first, and the previous config if the import saved its config at
+// savedVersion
+func rollbackBundle(previous *config.Config, savedVersion string, snapshots []fileSnapshot) error {
+	var errs []string
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if err := restoreSnapshot(previous, snapshots[i]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if savedVersion != "" {
+		if _, err := saveConfig(previous, savedVersion); err != nil {
+			errs = append(errs, err.Error())
+		} else if err := prometheus.GenerateAllTargets(previous.Nodes, prometheus.DefaultTargetsDir); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// restoreSnapshot puts a rule file or template back as it was, removing
+// it if it did not exist
+func restoreSnapshot(previous *config.Config, s fileSnapshot) error {
+	if s.kind == gitops.KindTemplate {
+		if s.content == nil {
+			if err := os.Remove(s.key); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return os.WriteFile(s.key, s.content, 0644)
+	}
+
+	path, namespace := gitops.RulePath(s.key)
+	if s.content == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	ns := config.RuleNamespace{}
+	if namespace != "" {
+		ns = prometheus.FindRuleNamespace(previous, namespace)
+	}
+	written, err := prometheus.WriteRuleFile(ns, filepath.Base(path), s.content)
+	if err == nil {
+		_, err = publishRuleFile(previous, written, s.content)
+	}
+	return err
+}
+
+func importResponse(changes []gitops.Change, dryRun bool) map[string]interface{} {
+	if changes == nil {
+		changes = []gitops.Change{}
+	}
+	return map[string]interface{}{
+		"dry_run": dryRun,
+		"changed": len(changes) > 0,
+		"changes": changes,
+	}
+}
+
+func printBundleChanges(changes []gitops.Change, dryRun bool) {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if len(changes) == 0 {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Configuration is up to date"))
+		return
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Action"), i18n.T("Kind"), i18n.T("Path")})
+	for _, c := range changes {
+		table.Append([]string{i18n.T(c.Action), c.Kind, c.Path})
+	}
+	table.Render()
+
+	if !dryRun {
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Applied %d changes", len(changes)))
+		return
+	}
+	for _, c := range changes {
+		if c.Diff == "" {
+			continue
+		}
+		fmt.Printf("\n--- %s (%s)\n", c.Path, c.Kind)
+		printDiff(c.Diff)
+	}
+	fmt.Println()
+	fmt.Println(i18n.T("Dry run: nothing was saved"))
+}
+
+// printDiff prints a line diff with added lines green and removed ones red
+func printDiff(diff string) {
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			fmt.Println(green(line))
+		case strings.HasPrefix(line, "-"):
+			fmt.Println(red(line))
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+func runConfigServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.HandleFunc("/config/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+		bundle, err := gitops.Export(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := bundle.Marshal()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(data)
+	})
+	v1.HandleFunc("/config/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		prune := r.URL.Query().Get("prune") == "true"
+
+		desired, err := gitops.Parse(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !dryRun {
+			if err := ensureWritable(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+		changes, err := importBundle(desired, dryRun, prune)
+		if writeQuotaError(w, err) {
+			return
+		}
+		var invalid *ruleValidationError
+		if errors.As(err, &invalid) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(importResponse(changes, dryRun))
+	})
+
+	v1.HandleFunc("/apply", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		prune := r.URL.Query().Get("prune") == "true"
+
+		resources, err := manifest.Parse(data)
+		if err == nil {
+			err = manifest.Sort(resources)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !dryRun {
+			if err := ensureWritable(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+		changes, err := applyManifests(resources, dryRun, prune)
+		if writeQuotaError(w, err) {
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if changes == nil {
+			changes = []manifest.Change{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run": dryRun,
+			"changed": len(changes) > 0,
+			"changes": changes,
+		})
+	})
+
+	fmt.Printf("%s Serving configuration API on http://%s/api/v1/{config/export,config/import,apply}\n", green("✓"), configListen)
+	return http.ListenAndServe(configListen, mux)
+}
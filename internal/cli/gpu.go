@@ -0,0 +1,529 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/agentauth"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/gpuvalidate"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	gpuConfigServerURL string
+	gpuValidateSteps   []string
+	gpuValidateLevel   int
+	gpuValidateTimeout time.Duration
+	gpuReportsOutput   string
+)
+
+var gpuCmd = &cobra.Command{
+	Use:   "gpu",
+	Short: "Validate the GPUs of nodes",
+	Long: `Validate the GPUs of nodes with a burn-in suite run by their agents, and
+show the reports.
+
+Examples:
+  aami gpu validate gpu-node-01
+  aami gpu validate gpu-node-01 --step dcgm-diag --level 4
+  aami gpu reports
+  aami gpu report gpu-node-01`,
+}
+
+var gpuValidateCmd = &cobra.Command{
+	Use:   "validate <node>",
+	Short: "Run the GPU validation suite on a node",
+	Long: `Run the GPU validation suite on a node and follow its progress.
+
+The command queues a gpu-validate task for the node's agent on the config
+server ('aami agents'). The agent runs the steps in order:
+
+  dcgm-diag       dcgmi diag at a run level (1-4, default 3)
+  bandwidth       CUDA bandwidthTest, host to device and back (min_gbps)
+  nccl-allreduce  all_reduce_perf of nccl-tests over every GPU (min_gbps of bus bandwidth)
+
+and posts its report to /api/v1/targets/<hostname>/gpu-validations on
+'aami check-results serve' as each step finishes; the command shows the steps
+as they do. The suite is gpu_validation.steps in the config, or the three
+steps above; --step runs only some of them. The node runs no checks while
+the suite runs, which takes from minutes to an hour at level 4.
+
+The report stays attached to the node: 'aami gpu report <node>' shows the
+latest. The command fails if a step failed.
+
+Examples:
+  aami gpu validate gpu-node-01
+  aami gpu validate gpu-node-01 --step dcgm-diag --level 4 --timeout 2h
+  aami gpu validate gpu-node-01 --step bandwidth --step nccl-allreduce`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runGPUValidate,
+}
+
+var gpuReportsCmd = &cobra.Command{
+	Use:   "reports [node]",
+	Short: "List GPU validation reports, newest first",
+	Args:  cobra.MaximumNArgs(1),
+	Example: `  aami gpu reports
+  aami gpu reports gpu-node-01 -o json`,
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runGPUReports,
+}
+
+var gpuReportCmd = &cobra.Command{
+	Use:   "report <node> [id]",
+	Short: "Show a GPU validation report of a node, by default the latest",
+	Args:  cobra.RangeArgs(1, 2),
+	Example: `  aami gpu report gpu-node-01
+  aami gpu report gpu-node-01 t-57 -o json`,
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runGPUReport,
+}
+
+func init() {
+	gpuValidateCmd.Flags().StringVar(&gpuConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	gpuValidateCmd.Flags().StringArrayVar(&gpuValidateSteps, "step", nil,
+		"Run only this step of the suite (repeatable): "+strings.Join(gpuvalidate.StepNames(), ", "))
+	gpuValidateCmd.Flags().IntVar(&gpuValidateLevel, "level", 0,
+		"dcgm-diag run level, 1-4 (default: the suite's)")
+	gpuValidateCmd.Flags().DurationVar(&gpuValidateTimeout, "timeout", time.Hour,
+		"How long to wait for the suite to finish")
+	gpuValidateCmd.RegisterFlagCompletionFunc("step",
+		cobra.FixedCompletions(gpuvalidate.StepNames(), cobra.ShellCompDirectiveNoFileComp))
+	addOutputFlag(gpuReportsCmd, &gpuReportsOutput)
+	addOutputFlag(gpuReportCmd, &gpuReportsOutput)
+
+	gpuCmd.AddCommand(gpuValidateCmd)
+	gpuCmd.AddCommand(gpuReportsCmd)
+	gpuCmd.AddCommand(gpuReportCmd)
+	rootCmd.AddCommand(gpuCmd)
+}
+
+func newGPUValidationStore() *gpuvalidate.Store {
+	return gpuvalidate.NewStore(gpuvalidate.DefaultDir)
+}
+
+// gpuValidationSuite returns the steps of gpu_validation.steps, or the
+// default suite
+func gpuValidationSuite(cfg *config.Config) ([]gpuvalidate.Step, error) {
+	if len(cfg.GPUValidation.Steps) == 0 {
+		return gpuvalidate.DefaultSuite(), nil
+	}
+	steps := make([]gpuvalidate.Step, len(cfg.GPUValidation.Steps))
+	for i, s := range cfg.GPUValidation.Steps {
+		step := gpuvalidate.Step{Name: s.Name, Level: s.Level, MinGBps: s.MinGBps}
+		if step.Name == gpuvalidate.StepDCGMDiag && step.Level == 0 {
+			step.Level = 3
+		}
+		if s.Timeout != "" {
+			d, err := time.ParseDuration(s.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("gpu_validation.steps[%d].timeout: %w", i, err)
+			}
+			step.Timeout = int(d.Seconds())
+		}
+		if err := step.Validate(); err != nil {
+			return nil, fmt.Errorf("gpu_validation.steps[%d]: %w", i, err)
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// selectGPUSteps keeps the steps named with --step, in suite order, and
+// applies --level
+func selectGPUSteps(suite []gpuvalidate.Step, names []string, level int) ([]gpuvalidate.Step, error) {
+	for _, name := range names {
+		if !slices.Contains(stepNames(suite), name) {
+			return nil, fmt.Errorf("step %q is not in the suite (suite: %s)", name, strings.Join(stepNames(suite), ", "))
+		}
+	}
+	var steps []gpuvalidate.Step
+	for _, s := range suite {
+		if len(names) > 0 && !slices.Contains(names, s.Name) {
+			continue
+		}
+		if level != 0 && s.Name == gpuvalidate.StepDCGMDiag {
+			s.Level = level
+			if err := s.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+func stepNames(steps []gpuvalidate.Step) []string {
+	names := make([]string, len(steps))
+	for i, s := range steps {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func runGPUValidate(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	suite, err := gpuValidationSuite(cfg)
+	if err != nil {
+		return err
+	}
+	steps, err := selectGPUSteps(suite, gpuValidateSteps, gpuValidateLevel)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(gpuConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	target, err := client.FindTarget(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	task, err := client.QueueAgentTask(ctx, target.Hostname, configserver.AgentTaskRequest{
+		Type:     configserver.TaskGPUValidate,
+		Priority: 10,
+		Args:     map[string]interface{}{"steps": steps},
+		TTL:      int((10 * time.Minute).Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s Queued GPU validation on %s (task %s): %s\n",
+		color.GreenString("✓"), target.Hostname, task.ID, strings.Join(stepNames(steps), ", "))
+	cancel()
+
+	report, err := followGPUValidation(client, newGPUValidationStore(), target.Hostname, task.ID, gpuValidateTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("GPU validation of %s %s (report %s)\n", report.Target, colorValidationStatus(report.Status), report.ID)
+	if report.Status != gpuvalidate.StatusPassed {
+		return fmt.Errorf("GPU validation of %s failed", report.Target)
+	}
+	return nil
+}
+
+// followGPUValidation prints the steps of a validation as the agent reports
+// them, until it finishes, the agent task ends without it, or the timeout
+// passes
+func followGPUValidation(client *configserver.Client, store *gpuvalidate.Store, host, id string, timeout time.Duration) (gpuvalidate.Report, error) {
+	fmt.Printf("Waiting for %s to start (up to %s)...\n", host, timeout)
+	deadline := time.Now().Add(timeout)
+	shown := map[string]string{}
+	for time.Now().Before(deadline) {
+		time.Sleep(agentTaskPollInterval)
+
+		report, err := store.Get(host, id)
+		if err == nil {
+			for _, s := range report.Steps {
+				if shown[s.Name] == s.Status {
+					continue
+				}
+				shown[s.Name] = s.Status
+				printGPUStep(s)
+			}
+			if report.Done() {
+				return report, nil
+			}
+			continue
+		}
+		if !errors.Is(err, gpuvalidate.ErrNotFound) {
+			return gpuvalidate.Report{}, err
+		}
+
+		// No report yet: the task may have ended without one, e.g. expired
+		// or on an agent without the suite
+		ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+		task, err := client.GetAgentTask(ctx, host, id)
+		cancel()
+		if err == nil && task.Done() {
+			return gpuvalidate.Report{}, fmt.Errorf("task %s on %s %s without a report: %s", id, host, task.Status, task.Message)
+		}
+	}
+	return gpuvalidate.Report{}, fmt.Errorf("no result from %s within %s; 'aami gpu report %s %s' shows it later", host, timeout, host, id)
+}
+
+func printGPUStep(s gpuvalidate.StepResult) {
+	switch s.Status {
+	case gpuvalidate.StatusPending:
+		return
+	case gpuvalidate.StatusRunning:
+		fmt.Printf("  %s %s running...\n", color.YellowString("…"), s.Name)
+		return
+	}
+	mark := color.GreenString("✓")
+	if s.Status == gpuvalidate.StatusFailed {
+		mark = color.RedString("✗")
+	}
+	took := formatDuration(time.Duration(s.DurationSeconds * float64(time.Second)))
+	fmt.Printf("  %s %s %s in %s", mark, s.Name, colorValidationStatus(s.Status), took)
+	if s.Message != "" {
+		fmt.Printf(": %s", s.Message)
+	}
+	fmt.Println()
+}
+
+func runGPUReports(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(gpuReportsOutput)
+	if err != nil {
+		return err
+	}
+	host := ""
+	if len(args) == 1 {
+		if host, err = gpuReportHost(args[0]); err != nil {
+			return err
+		}
+	}
+	reports, err := newGPUValidationStore().List(host)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, reports)
+	}
+	if len(reports) == 0 {
+		fmt.Println("No GPU validation reports yet.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Node"},
+		{Header: "ID"},
+		{Header: "Status"},
+		{Header: "Steps"},
+		{Header: "Started"},
+		{Header: "Duration"},
+		{Header: "GPUs", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, r := range reports {
+		steps := make([]string, len(r.Steps))
+		for i, s := range r.Steps {
+			steps[i] = s.Name + ":" + s.Status
+		}
+		duration := "-"
+		if r.FinishedAt != nil {
+			duration = formatDuration(r.FinishedAt.Sub(r.StartedAt))
+		}
+		table.Append(columns.Row(format,
+			r.Target,
+			r.ID,
+			colorValidationStatus(r.Status),
+			strings.Join(steps, " "),
+			r.StartedAt.Local().Format("01-02 15:04"),
+			duration,
+			fmt.Sprint(r.GPUs),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runGPUReport(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(gpuReportsOutput)
+	if err != nil {
+		return err
+	}
+	host, err := gpuReportHost(args[0])
+	if err != nil {
+		return err
+	}
+	store := newGPUValidationStore()
+
+	var report gpuvalidate.Report
+	if len(args) == 2 {
+		report, err = store.Get(host, args[1])
+	} else {
+		var reports []gpuvalidate.Report
+		if reports, err = store.List(host); err == nil {
+			if len(reports) == 0 {
+				return fmt.Errorf("%w: %s", gpuvalidate.ErrNotFound, host)
+			}
+			report = reports[0]
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, report)
+	}
+
+	bold := color.New(color.Bold).SprintFunc()
+	fmt.Printf("%s %s\n", bold("Node:    "), report.Target)
+	fmt.Printf("%s %s\n", bold("Report:  "), report.ID)
+	fmt.Printf("%s %s\n", bold("Status:  "), colorValidationStatus(report.Status))
+	if report.GPUs > 0 {
+		fmt.Printf("%s %d\n", bold("GPUs:    "), report.GPUs)
+	}
+	fmt.Printf("%s %s\n", bold("Started: "), report.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if report.FinishedAt != nil {
+		fmt.Printf("%s %s (%s)\n", bold("Finished:"), report.FinishedAt.Local().Format("2006-01-02 15:04:05"),
+			formatDuration(report.FinishedAt.Sub(report.StartedAt)))
+	}
+	fmt.Println()
+
+	table := newTable()
+	table.SetHeader([]string{"Step", "Status", "Duration", "Metrics", "Message"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, s := range report.Steps {
+		var metrics []string
+		for _, name := range sortedKeys(s.Metrics) {
+			metrics = append(metrics, fmt.Sprintf("%s=%.1f", name, s.Metrics[name]))
+		}
+		duration := "-"
+		if s.DurationSeconds > 0 {
+			duration = formatDuration(time.Duration(s.DurationSeconds * float64(time.Second)))
+		}
+		table.Append([]string{s.Name, colorValidationStatus(s.Status), duration,
+			defaultString(strings.Join(metrics, " "), "-"), defaultString(s.Message, "-")})
+	}
+	table.Render()
+	return nil
+}
+
+// gpuReportHost resolves a node to the hostname its reports are kept by
+func gpuReportHost(ref string) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+	target, err := findNodeTarget(ctx, cfg, ref)
+	if err != nil {
+		return "", err
+	}
+	return target.Hostname, nil
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// colorValidationStatus colors a validation or step status
+func colorValidationStatus(status string) string {
+	switch status {
+	case gpuvalidate.StatusPassed:
+		return color.GreenString(status)
+	case gpuvalidate.StatusFailed:
+		return color.RedString(status)
+	default:
+		return color.YellowString(status)
+	}
+}
+
+// handleTargetGPUValidations serves /targets/<hostname>/gpu-validations of
+// the agent API: agents PUT the report of a run as it progresses, readers
+// list and get them
+func handleTargetGPUValidations(w http.ResponseWriter, r *http.Request, cfg *config.Config, store *gpuvalidate.Store, authority *agentauth.Authority) {
+	rest := strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/targets/")
+	host, id, _ := strings.Cut(rest, "/gpu-validations")
+	id, ok := strings.CutPrefix(id, "/")
+	if host == "" || strings.Contains(host, "/") || (id != "" && !ok) || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && id != "":
+		claims, err := agentCredential(r, cfg, authority)
+		if err != nil {
+			writeAgentAuthError(w, err)
+			return
+		}
+		if claims != nil && host != claims.Target() {
+			http.Error(w, fmt.Sprintf("credential of %s cannot report for %s", claims.Target(), host), http.StatusForbidden)
+			return
+		}
+		if !hasNodeConfig(cfg, host) {
+			http.Error(w, "unknown target: "+host, http.StatusBadRequest)
+			return
+		}
+		data, err := readLimited(r, 1<<20)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var report gpuvalidate.Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if (report.Target != "" && report.Target != host) || (report.ID != "" && report.ID != id) {
+			http.Error(w, fmt.Sprintf("report %s of %s put as %s of %s", report.ID, report.Target, id, host), http.StatusBadRequest)
+			return
+		}
+		report.Target, report.ID = host, id
+		stored, err := store.Put(report)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, stored)
+
+	case r.Method == http.MethodGet:
+		// API keys read only the reports of their namespace's nodes
+		t, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if nodes := t.Nodes(cfg); nodes != nil && !nodes[host] {
+			http.Error(w, "unknown target: "+host, http.StatusNotFound)
+			return
+		}
+		if id == "" {
+			reports, err := store.List(host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, reports)
+			return
+		}
+		report, err := store.Get(host, id)
+		if errors.Is(err, gpuvalidate.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, report)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,289 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/agentauth"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/hardware"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var hardwareOutput string
+
+var nodesInventoryCmd = &cobra.Command{
+	Use:   "inventory [host]",
+	Short: "Show the hardware of nodes, as reported by their agents",
+	Long: `Show the hardware inventory node agents report: CPU model, memory, GPU
+models, serials and VBIOS versions, network and InfiniBand adapters, and the
+kernel and driver versions.
+
+Agents collect it when they first run after registration and every hour
+after, and post it to /api/v1/targets/<hostname>/inventory on
+'aami check-results serve' when it changed, or daily. Without <host>, every
+node that reported one is listed. <host> is a config server target ID or
+hostname, or a node name without a config server.
+
+Examples:
+  aami node inventory
+  aami node inventory gpu-node-01
+  aami node inventory gpu-node-01 -o json`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesInventory,
+}
+
+func init() {
+	addOutputFlag(nodesInventoryCmd, &hardwareOutput)
+	nodesCmd.AddCommand(nodesInventoryCmd)
+}
+
+func newHardwareStore() *hardware.Store {
+	return hardware.NewStore(hardware.DefaultDir)
+}
+
+func runNodesInventory(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(hardwareOutput)
+	if err != nil {
+		return err
+	}
+	store := newHardwareStore()
+
+	if len(args) == 0 {
+		inventories, err := store.List()
+		if err != nil {
+			return err
+		}
+		if format.Structured() {
+			return writeOutput(format, inventories)
+		}
+		if len(inventories) == 0 {
+			fmt.Println("No hardware inventory reported yet.")
+			return nil
+		}
+		printInventoryList(format, inventories)
+		return nil
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+	target, err := findNodeTarget(ctx, cfg, args[0])
+	if err != nil {
+		return err
+	}
+	inv, err := store.Get(target.Hostname)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, inv)
+	}
+	printInventory(inv)
+	return nil
+}
+
+func printInventoryList(format output.Format, inventories []hardware.Inventory) {
+	columns := output.Columns{
+		{Header: "Node"},
+		{Header: "CPU"},
+		{Header: "Memory"},
+		{Header: "GPUs"},
+		{Header: "Kernel"},
+		{Header: "Driver"},
+		{Header: "Reported"},
+		{Header: "HCAs", Wide: true},
+		{Header: "OS", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, inv := range inventories {
+		table.Append(columns.Row(format,
+			inv.Target,
+			defaultString(inv.CPU.Model, "-"),
+			formatSize(inv.MemoryBytes),
+			defaultString(strings.Join(inv.GPUModels(), ", "), "-"),
+			defaultString(inv.Kernel, "-"),
+			defaultString(inv.Drivers["nvidia"], "-"),
+			inv.ReportedAt.Local().Format("01-02 15:04"),
+			strconv.Itoa(len(inv.HCAs)),
+			defaultString(inv.OS, "-"),
+		))
+	}
+	table.Render()
+}
+
+func printInventory(inv hardware.Inventory) {
+	bold := color.New(color.Bold).SprintFunc()
+
+	fmt.Printf("%s %s\n", bold("Node:    "), inv.Target)
+	if inv.OS != "" {
+		fmt.Printf("%s %s\n", bold("OS:      "), inv.OS)
+	}
+	fmt.Printf("%s %s\n", bold("Kernel:  "), defaultString(inv.Kernel, "-"))
+	cpu := defaultString(inv.CPU.Model, "-")
+	if inv.CPU.Sockets > 1 {
+		cpu = fmt.Sprintf("%dx %s", inv.CPU.Sockets, cpu)
+	}
+	fmt.Printf("%s %s (%d cores, %d threads)\n", bold("CPU:     "), cpu, inv.CPU.Cores, inv.CPU.Threads)
+	fmt.Printf("%s %s\n", bold("Memory:  "), formatSize(inv.MemoryBytes))
+	if len(inv.Drivers) > 0 {
+		names := make([]string, 0, len(inv.Drivers))
+		for name := range inv.Drivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		drivers := make([]string, len(names))
+		for i, name := range names {
+			drivers[i] = name + " " + inv.Drivers[name]
+		}
+		fmt.Printf("%s %s\n", bold("Drivers: "), strings.Join(drivers, ", "))
+	}
+	fmt.Printf("%s %s (collected %s)\n", bold("Reported:"),
+		inv.ReportedAt.Local().Format("2006-01-02 15:04"), inv.CollectedAt.Local().Format("2006-01-02 15:04"))
+
+	if len(inv.GPUs) > 0 {
+		fmt.Println()
+		fmt.Println(bold("GPUs"))
+		table := newTable()
+		table.SetHeader([]string{"Index", "Model", "UUID", "Serial", "VBIOS", "Memory"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		for _, g := range inv.GPUs {
+			memory := "-"
+			if g.MemoryMiB > 0 {
+				memory = formatSize(g.MemoryMiB << 20)
+			}
+			table.Append([]string{strconv.Itoa(g.Index), g.Model, g.UUID,
+				defaultString(g.Serial, "-"), defaultString(g.VBIOS, "-"), memory})
+		}
+		table.Render()
+	}
+
+	if len(inv.NICs) > 0 {
+		fmt.Println()
+		fmt.Println(bold("Network"))
+		table := newTable()
+		table.SetHeader([]string{"Name", "Type", "Driver", "MAC", "Speed"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		for _, n := range inv.NICs {
+			speed := "-"
+			if n.SpeedMbps > 0 {
+				speed = fmt.Sprintf("%d Gb/s", n.SpeedMbps/1000)
+				if n.SpeedMbps < 1000 {
+					speed = fmt.Sprintf("%d Mb/s", n.SpeedMbps)
+				}
+			}
+			table.Append([]string{n.Name, n.Type, defaultString(n.Driver, "-"), defaultString(n.MAC, "-"), speed})
+		}
+		table.Render()
+	}
+
+	if len(inv.HCAs) > 0 {
+		fmt.Println()
+		fmt.Println(bold("InfiniBand"))
+		table := newTable()
+		table.SetHeader([]string{"Name", "Model", "Board", "Firmware", "Ports"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		for _, h := range inv.HCAs {
+			ports := make([]string, len(h.Ports))
+			for i, p := range h.Ports {
+				ports[i] = fmt.Sprintf("%d: %s %s", p.Port, defaultString(p.State, "?"), p.Rate)
+			}
+			table.Append([]string{h.Name, defaultString(h.Model, "-"), defaultString(h.BoardID, "-"),
+				defaultString(h.Firmware, "-"), defaultString(strings.Join(ports, "; "), "-")})
+		}
+		table.Render()
+	}
+}
+
+// handleTargetInventory serves /targets/<hostname>/inventory of the agent
+// API: agents POST their inventory, readers GET it
+func handleTargetInventory(w http.ResponseWriter, r *http.Request, cfg *config.Config, store *hardware.Store, authority *agentauth.Authority) {
+	host, ok := strings.CutSuffix(strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/targets/"), "/inventory")
+	if !ok || host == "" || strings.Contains(host, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		claims, err := agentCredential(r, cfg, authority)
+		if err != nil {
+			writeAgentAuthError(w, err)
+			return
+		}
+		if claims != nil && host != claims.Target() {
+			http.Error(w, fmt.Sprintf("credential of %s cannot report for %s", claims.Target(), host), http.StatusForbidden)
+			return
+		}
+		if !hasNodeConfig(cfg, host) {
+			http.Error(w, "unknown target: "+host, http.StatusBadRequest)
+			return
+		}
+		data, err := readLimited(r, 1<<20)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var inv hardware.Inventory
+		if err := json.Unmarshal(data, &inv); err != nil {
+			http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if inv.Target != "" && inv.Target != host {
+			http.Error(w, fmt.Sprintf("inventory of %s posted for %s", inv.Target, host), http.StatusBadRequest)
+			return
+		}
+		inv.Target = host
+		stored, err := store.Put(inv)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, stored)
+
+	case http.MethodGet:
+		// API keys read only the inventory of their namespace's nodes
+		t, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if nodes := t.Nodes(cfg); nodes != nil && !nodes[host] {
+			http.Error(w, "unknown target: "+host, http.StatusNotFound)
+			return
+		}
+		inv, err := store.Get(host)
+		if errors.Is(err, hardware.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, inv)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
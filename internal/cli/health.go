@@ -1,22 +1,23 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
+	"github.com/fregataa/aami/internal/alertmanager"
 	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/output"
 )
 
 var (
 	healthOutput   string
-	healthNoColor  bool
 	healthDetailed bool
+	healthListen   string
+	healthAMURL    string
 )
 
 var healthCmd = &cobra.Command{
@@ -31,36 +32,54 @@ Health scores are calculated based on:
   - NVLink Status (15%)
   - Uptime (15%)
 
+--listen serves the health score of each target on
+GET /api/v1/targets/<id>/health, where <id> is a config server target ID or
+hostname, or a node name without a config server. It combines the GPU
+scores above, the target's firing alerts and its check results of the last
+24h, weighed by health.weights (default: gpu 0.5, alerts 0.3, checks 0.2).
+Requests authenticate with "Authorization: Bearer <check_results.token>";
+API keys and SSO users see only the targets of their namespace.
+
 Examples:
   aami health              # Show cluster health summary
   aami health gpu-node-01  # Show detailed health for a node
-  aami health --detailed   # Show all component scores`,
-	Args: cobra.MaximumNArgs(1),
-	RunE: runHealth,
+  aami health --detailed   # Show all component scores (same as -o wide)
+  aami health -o yaml      # Scores for scripts
+  aami health --listen :8112`,
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.MaximumNArgs(1),
+	RunE:              runHealth,
 }
 
 func init() {
 	rootCmd.AddCommand(healthCmd)
 
-	healthCmd.Flags().StringVarP(&healthOutput, "output", "o", "table",
-		"Output format: table, json")
-	healthCmd.Flags().BoolVar(&healthNoColor, "no-color", false,
-		"Disable colored output")
+	addOutputFlag(healthCmd, &healthOutput)
 	healthCmd.Flags().BoolVar(&healthDetailed, "detailed", false,
 		"Show detailed component scores")
+	healthCmd.Flags().StringVar(&healthListen, "listen", "",
+		"Serve target health scores over HTTP on this address (e.g. :8112)")
+	healthCmd.Flags().StringVar(&healthAMURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager URL for the firing alerts of targets")
 }
 
 func runHealth(cmd *cobra.Command, args []string) error {
+	if healthListen != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--listen takes no node")
+		}
+		return serveTargetHealth(healthListen, alertmanagerURL(cmd, healthAMURL))
+	}
+	format, err := output.Parse(healthOutput)
+	if err != nil {
+		return err
+	}
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
 
-	// Build Prometheus URL
-	promURL := fmt.Sprintf("http://localhost:%d", cfg.Prometheus.Port)
-	if cfg.Prometheus.Port == 0 {
-		promURL = "http://localhost:9090"
-	}
+	promURL := prometheusURL(cfg)
 
 	// Create clients
 	promClient := health.NewPrometheusClient(promURL)
@@ -101,28 +120,18 @@ func runHealth(cmd *cobra.Command, args []string) error {
 	clusterHealth := calculator.CalculateClusterHealth(nodeMetrics)
 
 	// Render output
-	switch healthOutput {
-	case "json":
-		return renderHealthJSON(clusterHealth)
-	case "table":
-		if len(args) > 0 || healthDetailed {
-			renderDetailedHealth(clusterHealth, !healthNoColor && !color.NoColor)
-		} else {
-			renderClusterHealth(clusterHealth, !healthNoColor && !color.NoColor)
-		}
+	switch {
+	case format.Structured():
+		return writeOutput(format, clusterHealth)
+	case len(args) > 0 || healthDetailed || format == output.Wide:
+		renderDetailedHealth(clusterHealth, !color.NoColor)
 	default:
-		return fmt.Errorf("unknown output format: %s", healthOutput)
+		renderClusterHealth(clusterHealth, !color.NoColor)
 	}
 
 	return nil
 }
 
-func renderHealthJSON(cluster health.ClusterHealth) error {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(cluster)
-}
-
 func renderClusterHealth(cluster health.ClusterHealth, useColor bool) {
 	// Header
 	fmt.Println()
@@ -159,7 +168,7 @@ func renderClusterHealth(cluster health.ClusterHealth, useColor bool) {
 	fmt.Println()
 
 	// Node summary table
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Node", "GPUs", "Score", "Status", "Issues"})
 	table.SetBorder(false)
 	table.SetColumnAlignment([]int{
@@ -224,7 +233,7 @@ func renderDetailedHealth(cluster health.ClusterHealth, useColor bool) {
 			fmt.Println()
 
 			// Component scores
-			table := tablewriter.NewWriter(os.Stdout)
+			table := newTable()
 			table.SetHeader([]string{"Component", "Score", "Weight", "Status", "Details"})
 			table.SetBorder(false)
 			table.SetColumnAlignment([]int{
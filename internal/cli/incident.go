@@ -0,0 +1,388 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/incident"
+)
+
+var (
+	incidentSeverity  string
+	incidentAlerts    []string
+	incidentListAll   bool
+	incidentEventType string
+	incidentFormat    string
+	incidentIfVersion int
+)
+
+var incidentCmd = &cobra.Command{
+	Use:     "incident",
+	Aliases: []string{"incidents"},
+	Short:   "Track incidents",
+	Long: `Track incidents that group related alerts, actions, and notes.
+
+An incident moves through open → mitigated → resolved. Every change is
+recorded on its timeline, which can be exported as a postmortem draft.
+
+Examples:
+  aami incident open "GPU overheating on rack 3" --alert GPUTemperatureCritical@gpu-node-01
+  aami incident ack INC-0001
+  aami incident link INC-0001 GPUTemperatureCritical gpu-node-02
+  aami incident log INC-0001 --type playbook "Ran fan speed reset"
+  aami incident note INC-0001 "CRAC unit 2 failed"
+  aami incident status INC-0001 resolved "CRAC unit replaced"
+  aami incident export INC-0001 > postmortem.md
+
+Every change increments the incident's version (shown by 'incident show').
+Pass --if-version to apply a change only if nobody else changed the
+incident since you looked at it:
+  aami incident status INC-0001 resolved --if-version 7`,
+}
+
+var incidentOpenCmd = &cobra.Command{
+	Use:   "open <title>",
+	Short: "Open a new incident",
+	Args:  cobra.MinimumNArgs(1),
+	RunE:  runIncidentOpen,
+}
+
+var incidentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List incidents",
+	RunE:  runIncidentList,
+}
+
+var incidentShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show an incident and its timeline",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runIncidentShow,
+}
+
+var incidentLinkCmd = &cobra.Command{
+	Use:   "link <id> <alertname> [node]",
+	Short: "Link an alert to an incident",
+	Args:  cobra.RangeArgs(2, 3),
+	RunE:  runIncidentLink,
+}
+
+var incidentAckCmd = &cobra.Command{
+	Use:   "ack <id>",
+	Short: "Acknowledge an incident",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runIncidentAck,
+}
+
+var incidentNoteCmd = &cobra.Command{
+	Use:   "note <id> <message>",
+	Short: "Add a note to an incident",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runIncidentNote,
+}
+
+var incidentLogCmd = &cobra.Command{
+	Use:   "log <id> <message>",
+	Short: "Record an action taken (drain, playbook run, ...)",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runIncidentLog,
+}
+
+var incidentStatusCmd = &cobra.Command{
+	Use:   "status <id> <open|mitigated|resolved> [message]",
+	Short: "Change an incident's status",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runIncidentStatus,
+}
+
+var incidentExportCmd = &cobra.Command{
+	Use:   "export <id>",
+	Short: "Export a postmortem timeline",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runIncidentExport,
+}
+
+func init() {
+	incidentOpenCmd.Flags().StringVar(&incidentSeverity, "severity", "critical",
+		"Incident severity")
+	incidentOpenCmd.Flags().StringSliceVar(&incidentAlerts, "alert", nil,
+		"Link an alert (alertname or alertname@node), repeatable")
+	incidentListCmd.Flags().BoolVar(&incidentListAll, "all", false,
+		"Include resolved incidents")
+	incidentLogCmd.Flags().StringVar(&incidentEventType, "type", incident.EventAction,
+		"Action type: drain, playbook, action")
+	incidentExportCmd.Flags().StringVar(&incidentFormat, "format", "markdown",
+		"Export format: markdown, json")
+	for _, c := range []*cobra.Command{incidentLinkCmd, incidentAckCmd, incidentNoteCmd, incidentLogCmd, incidentStatusCmd} {
+		c.Flags().IntVar(&incidentIfVersion, "if-version", 0,
+			"Fail unless the incident is still at this version")
+	}
+
+	incidentCmd.AddCommand(incidentOpenCmd)
+	incidentCmd.AddCommand(incidentListCmd)
+	incidentCmd.AddCommand(incidentShowCmd)
+	incidentCmd.AddCommand(incidentLinkCmd)
+	incidentCmd.AddCommand(incidentAckCmd)
+	incidentCmd.AddCommand(incidentNoteCmd)
+	incidentCmd.AddCommand(incidentLogCmd)
+	incidentCmd.AddCommand(incidentStatusCmd)
+	incidentCmd.AddCommand(incidentExportCmd)
+	rootCmd.AddCommand(incidentCmd)
+}
+
+// currentUser returns the operator name recorded on incident events
+func currentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// updateIncident loads an incident, applies fn, and saves it. The save
+// fails if the incident changed in between, or if it is no longer at
+// --if-version.
+func updateIncident(id string, fn func(inc *incident.Incident) error) (*incident.Incident, error) {
+	if err := ensureWritable(); err != nil {
+		return nil, err
+	}
+	store := incident.NewStore(incident.DefaultDir)
+	inc, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if incidentIfVersion > 0 && inc.Version != incidentIfVersion {
+		return nil, incidentConflict(&incident.ConflictError{
+			ID: inc.ID, Version: incidentIfVersion, Current: inc.Version,
+		})
+	}
+	if err := fn(inc); err != nil {
+		return nil, err
+	}
+	if err := store.Save(inc); err != nil {
+		return nil, incidentConflict(err)
+	}
+	return inc, nil
+}
+
+// incidentConflict adds a hint to version conflicts
+func incidentConflict(err error) error {
+	var conflict *incident.ConflictError
+	if errors.As(err, &conflict) {
+		return fmt.Errorf("%w\nReview the changes with 'aami incident show %s' and retry", err, conflict.ID)
+	}
+	return err
+}
+
+func runIncidentOpen(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if err := ensureWritable(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	author := currentUser()
+	store := incident.NewStore(incident.DefaultDir)
+
+	inc, err := store.Create(strings.Join(args, " "), incidentSeverity, author, now)
+	if err != nil {
+		return err
+	}
+
+	if len(incidentAlerts) > 0 {
+		for _, a := range incidentAlerts {
+			name, node, _ := strings.Cut(a, "@")
+			inc.LinkAlert(incident.AlertRef{Name: name, Node: node}, author, now)
+		}
+		if err := store.Save(inc); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s Opened %s: %s\n", green("✓"), inc.ID, inc.Title)
+	return nil
+}
+
+func runIncidentList(cmd *cobra.Command, args []string) error {
+	incidents, err := incident.NewStore(incident.DefaultDir).List()
+	if err != nil {
+		return err
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"ID", "Status", "Severity", "Opened", "Alerts", "Title"})
+	table.SetBorder(true)
+
+	shown := 0
+	for _, inc := range incidents {
+		if inc.Status == incident.StatusResolved && !incidentListAll {
+			continue
+		}
+		table.Append([]string{
+			inc.ID,
+			inc.Status,
+			inc.Severity,
+			inc.CreatedAt.Local().Format("2006-01-02 15:04"),
+			fmt.Sprintf("%d", len(inc.Alerts)),
+			inc.Title,
+		})
+		shown++
+	}
+
+	if shown == 0 {
+		fmt.Println("No open incidents.")
+		return nil
+	}
+	table.Render()
+	return nil
+}
+
+func runIncidentShow(cmd *cobra.Command, args []string) error {
+	bold := color.New(color.Bold).SprintFunc()
+
+	inc, err := incident.NewStore(incident.DefaultDir).Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%s %s\n", bold(inc.ID), inc.Title)
+	fmt.Printf("  Status:   %s\n", inc.Status)
+	fmt.Printf("  Severity: %s\n", inc.Severity)
+	fmt.Printf("  Opened:   %s\n", inc.CreatedAt.Local().Format(time.RFC3339))
+	fmt.Printf("  Version:  %d\n", inc.Version)
+
+	if len(inc.Alerts) > 0 {
+		fmt.Printf("\n%s\n", bold("Alerts"))
+		for _, a := range inc.Alerts {
+			if a.Node != "" {
+				fmt.Printf("  %s on %s\n", a.Name, a.Node)
+			} else {
+				fmt.Printf("  %s\n", a.Name)
+			}
+		}
+	}
+
+	fmt.Printf("\n%s\n", bold("Timeline"))
+	for _, e := range inc.Timeline() {
+		fmt.Printf("  %s  %-8s %-10s %s\n",
+			e.Time.Local().Format("01-02 15:04:05"), e.Type, e.Author, e.Message)
+	}
+	fmt.Println()
+	return nil
+}
+
+func runIncidentLink(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	ref := incident.AlertRef{Name: args[1]}
+	if len(args) == 3 {
+		ref.Node = args[2]
+	}
+
+	inc, err := updateIncident(args[0], func(inc *incident.Incident) error {
+		if !inc.LinkAlert(ref, currentUser(), time.Now().UTC()) {
+			return fmt.Errorf("alert already linked to %s", inc.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Linked %s to %s\n", green("✓"), ref.Name, inc.ID)
+	return nil
+}
+
+func runIncidentAck(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	inc, err := updateIncident(args[0], func(inc *incident.Incident) error {
+		return inc.Acknowledge(currentUser(), time.Now().UTC())
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Acknowledged %s\n", green("✓"), inc.ID)
+	return nil
+}
+
+func runIncidentNote(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	inc, err := updateIncident(args[0], func(inc *incident.Incident) error {
+		inc.AddEvent(incident.EventNote, currentUser(), strings.Join(args[1:], " "), time.Now().UTC())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Note added to %s\n", green("✓"), inc.ID)
+	return nil
+}
+
+func runIncidentLog(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	switch incidentEventType {
+	case incident.EventDrain, incident.EventPlaybook, incident.EventAction:
+	default:
+		return fmt.Errorf("invalid action type: %s (drain, playbook, action)", incidentEventType)
+	}
+
+	inc, err := updateIncident(args[0], func(inc *incident.Incident) error {
+		inc.AddEvent(incidentEventType, currentUser(), strings.Join(args[1:], " "), time.Now().UTC())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Recorded %s on %s\n", green("✓"), incidentEventType, inc.ID)
+	return nil
+}
+
+func runIncidentStatus(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	inc, err := updateIncident(args[0], func(inc *incident.Incident) error {
+		return inc.SetStatus(args[1], currentUser(), strings.Join(args[2:], " "), time.Now().UTC())
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s is now %s\n", green("✓"), inc.ID, inc.Status)
+	return nil
+}
+
+func runIncidentExport(cmd *cobra.Command, args []string) error {
+	inc, err := incident.NewStore(incident.DefaultDir).Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	switch incidentFormat {
+	case "markdown":
+		fmt.Print(inc.Markdown())
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inc)
+	default:
+		return fmt.Errorf("unknown format: %s", incidentFormat)
+	}
+	return nil
+}
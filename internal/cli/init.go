@@ -85,6 +85,10 @@ func createDefaultConfig(path string) error {
 cluster:
   name: my-gpu-cluster
 
+# Language of CLI messages and alert annotations: en (default), ko.
+# AAMI_LANG overrides it for CLI messages.
+# language: ko
+
 # Nodes to monitor (add via 'aami nodes add' or manually)
 nodes: []
 
@@ -102,6 +106,20 @@ ssh:
 alerts:
   presets:
     - gpu-production
+  # Per-team rule directories (aami alerts apply-preset --namespace team-a)
+  # namespaces:
+  #   - name: team-a
+  #     owner: prometheus-team-a
+  #     group: team-a
+  #     mode: "0640"             # default 0644; Prometheus must be able to read
+  #     quota:                   # 0 or unset: unlimited
+  #       max_targets: 50        # nodes labelled namespace=team-a
+  #       max_groups: 20
+  #       max_rules: 200
+  # Annotation language, per rule group and by default (see language)
+  # language: en
+  # group_languages:
+  #   gpu-production: ko
 
 # Notification channels
 notifications:
@@ -109,25 +127,146 @@ notifications:
     enabled: false
     webhook_url: ""
     channel: ""
+    # template: /etc/aami/templates/slack.tmpl  # Block Kit blocks, see: aami notifications preview slack
   email:
     enabled: false
     smtp_host: ""
     smtp_port: 587
     from: ""
     to: []
+    # template: /etc/aami/templates/email.html.tmpl
+  # Slack and webhook notifications are rendered by aami notifications serve
+  # relay_url: http://localhost:8115
+  # relay_token: "${AAMI_RELAY_TOKEN}"
+
+# Chat slash commands (aami chatops serve)
+# chatops:
+#   signing_secret: "${SLACK_SIGNING_SECRET}"  # Slack, token requests refused
+#   token: "${MATTERMOST_SLASH_TOKEN}"         # Mattermost, without a secret
+#   team_id: T0123ABCD                         # only this workspace/team
+#   users:                                     # by user ID, not name
+#     U012AB3CD: operator                      # status, silence, ack
+#   default_role: viewer                       # status only
+
+# Replication to a read-only secondary (aami replication serve/follow)
+# replication:
+#   token: "${AAMI_REPLICATION_TOKEN}"
+#   conflicts:                                 # for files changed on the replica
+#     config: manual                           # theirs (default), ours, newest, manual
+#     incidents: newest
+
+# Query limits for dashboards and scripts (aami query-proxy serve)
+# query_proxy:
+#   tokens:
+#     - name: grafana
+#       token: "${AAMI_GRAFANA_QUERY_TOKEN}"
+#       max_range: 30d                           # query_range window and [range] selectors
+#       min_step: 1m                             # finest query_range resolution
+#       max_series: 5000
+#       max_samples: 50000000                    # from Prometheus query stats
+#   deny:
+#     - '\{__name__=~"\.[*+]"\}'               # every series in the TSDB
+#   cache_ttl: 30s
+
+# Silence API for maintenance tooling (aami silence serve)
+# silences:
+#   token: "${AAMI_SILENCE_TOKEN}"
+
+# Check results reported by node agents (aami check-results serve)
+# check_results:
+#   token: "${AAMI_CHECK_RESULTS_TOKEN}"  # for reading results
+#   retention: 30d
+
+# Node agent credentials (aami agents issue|revoke)
+# agent_auth:
+#   required: true  # reject agent requests without a credential
+#   ttl: 24h        # agents renew when a third of the lifetime is left
+
+# Slurm integration
+# slurm:
+#   rest:                                 # slurmrestd; the Slurm commands when unavailable
+#     endpoint: http://slurm-ctl:6820
+#     user: aami                          # default token: scontrol token, renewed
+#     token_lifetime: 1h
+#   prolog:                               # health gate of the prolog hook
+#     fail_above: 1                       # reject jobs when failed checks weigh more
+#     checks:
+#       - builtin: nvidia-smi             # or xid, nvlink
+#         weight: 2
+#       - policy: gpu-quick               # from the config server's script policies
+#   correlations:                         # job-GPU correlations (aami slurm serve)
+#     url: http://aami-mgmt:8099          # where epilog hooks report
+#     token: "${AAMI_CORRELATIONS_TOKEN}" # for reading correlations
+#     retention: 90d
+#   drain_policy:                         # aami slurm autodrain
+#     dry_run: true
+#     partitions: [gpu]
+#     rules:
+#       - name: fallen-off-bus
+#         xid: [79]
+
+# Admin API, e.g. POST /api/v1/admin/test-alert (aami alerts preview --listen)
+# admin:
+#   token: "${AAMI_ADMIN_TOKEN}"
+
+# API keys of teams, scoped to the nodes of their namespace (alerts.namespaces)
+# api_keys:
+#   - name: team-a-dashboards
+#     key: "${TEAM_A_API_KEY}"
+#     namespace: team-a
+
+# SSO for people (aami login); their groups pick what they see
+# oidc:
+#   issuer: https://sso.example.com/realms/ops
+#   client_id: aami-cli
+#   operator_groups: [sre]       # every namespace
+#   namespace_groups:
+#     ml-research: team-a
+
+# How long serve commands reuse the parsed config between requests
+# cache:
+#   ttl: 10s  # "0" reads the file on every request
+
+# Where rule files and backups are stored (aami storage status)
+# storage:
+#   rules:
+#     backend: s3  # local (default), s3, gcs
+#     bucket: aami-rules
+#     prefix: prod/rules
+#     access_key: "${AWS_ACCESS_KEY_ID}"
+#     secret_key: "${AWS_SECRET_ACCESS_KEY}"
+#   backups:
+#     backend: local
+#     keep_last: 14
+#     max_age: 90d
+
+# Register nodes from Kubernetes or Consul (aami discovery kubernetes|consul)
+# discovery:
+#   kubernetes:
+#     kubeconfig: /etc/aami/kubeconfig  # default: service account, then ~/.kube/config
+#     label_selector: nvidia.com/gpu.present=true
+#     ssh_user: ubuntu
+#   consul:
+#     address: http://localhost:8500
+#     token: "${CONSUL_HTTP_TOKEN}"
+#     services: [dcgm-exporter]  # default: all catalog nodes
+#     interval: 5m
+#     conflict: ours             # nodes added by hand: ours (keep), theirs (take over)
 
 # Prometheus settings
 prometheus:
   retention: 15d
   storage_path: /var/lib/aami/prometheus
   port: 9090
+  # Load only one rule namespace (for per-team Prometheus instances)
+  # rule_namespace: team-a
 
 # Grafana settings
 grafana:
   port: 3000
   admin_password: ${GRAFANA_ADMIN_PASSWORD}
 `
-	return os.WriteFile(path, []byte(defaultConfig), 0644)
+	return os.WriteFile(path, []byte(defaultConfig), 0600)
 }
 
 func installOnline() error {
@@ -0,0 +1,299 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/api"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/inventory"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	inventoryFormat string
+	inventoryList   bool
+	inventoryHost   string
+	inventoryListen string
+
+	inventorySelector string
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Export nodes as a configuration management inventory",
+	Long: `Export configured nodes as an inventory for configuration management tools.
+
+Nodes are grouped by their labels (key=value becomes group "key_value") and
+by cluster name, prefixed with "cluster_" if the name is "all" or that of a
+label group. The output follows the Ansible dynamic inventory protocol
+(--list for every group, --host for one host's variables), so aami can be
+used directly as an inventory script.
+
+The grafana and alertmanager formats configure the monitoring components
+from the same nodes: a Grafana datasource provisioning file for the
+Prometheus and Alertmanager instances, and the Alertmanager cluster peers
+(with --host, the --cluster.peer flags of that node's Alertmanager). Nodes
+run the components their "role" label lists, e.g. role=prometheus,alertmanager;
+without any, the components are taken to run on this host. The HTTP server
+also serves them at /api/v1/sd/grafana and /api/v1/sd/alertmanager, and the
+exporter targets for Prometheus HTTP service discovery at /api/v1/sd/prometheus.
+
+The scrape format renders complete scrape jobs instead, one per exporter
+type, for Prometheus' scrape_config_files (also served at
+/api/v1/sd/scrape-configs). With a config server, they are built from its
+exporter records: port, path, scheme, TLS settings and scrape intervals,
+with the target's labels; node_exporter scrapes as job "node". Without
+one, from the node and dcgm exporters of the configured nodes.
+
+--selector (or ?selector= over HTTP) limits every format to the nodes whose
+labels match a kubectl-style selector, e.g. rack=a1,gpu_model!=H100.
+
+Examples:
+  aami inventory --format ansible           # Print full inventory
+  aami inventory --list                     # Same, Ansible script protocol
+  aami inventory --host gpu-01              # Print variables for one host
+  aami inventory -l rack=a1                 # Only the nodes in rack a1
+  aami inventory --listen :8090             # Serve inventory over HTTP
+  aami inventory --format grafana > /etc/grafana/provisioning/datasources/aami.yaml
+  aami inventory --format alertmanager --host mgmt-02   # --cluster.peer flags
+  aami inventory --format scrape > /etc/prometheus/scrape/aami.yaml
+  ansible-inventory -i inventory.sh --graph # inventory.sh: exec aami inventory "$@"`,
+	Args: cobra.NoArgs,
+	RunE: runInventory,
+}
+
+func init() {
+	inventoryCmd.Flags().StringVar(&inventoryFormat, "format", inventory.FormatAnsible,
+		"Inventory format (ansible, grafana, alertmanager, scrape)")
+	inventoryCmd.Flags().BoolVar(&inventoryList, "list", false,
+		"Print every group and host (Ansible dynamic inventory protocol; the default output of --format ansible)")
+	inventoryCmd.Flags().StringVar(&inventoryHost, "host", "",
+		"Print variables for a single host")
+	inventoryCmd.Flags().StringVar(&inventoryListen, "listen", "",
+		"Serve the inventory over HTTP on this address (e.g. :8090)")
+	inventoryCmd.Flags().StringVarP(&inventorySelector, "selector", "l", "",
+		"Label selector (e.g. rack=a1,gpu_model!=H100)")
+
+	rootCmd.AddCommand(inventoryCmd)
+}
+
+func runInventory(cmd *cobra.Command, args []string) error {
+	switch inventoryFormat {
+	case inventory.FormatAnsible, inventory.FormatGrafana, inventory.FormatAlertmanager, inventoryFormatScrape:
+	default:
+		return fmt.Errorf("unsupported inventory format: %s", inventoryFormat)
+	}
+
+	// Ansible runs inventory scripts with either --list or --host <name>
+	if inventoryList && inventoryFormat != inventory.FormatAnsible {
+		return fmt.Errorf("--list is only supported with --format ansible")
+	}
+	if inventoryList && (inventoryHost != "" || inventoryListen != "") {
+		return fmt.Errorf("--list cannot be combined with --host or --listen")
+	}
+
+	if inventoryListen != "" {
+		return serveInventory(inventoryListen)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg, err = selectNodes(cfg, inventorySelector)
+	if err != nil {
+		return err
+	}
+
+	switch inventoryFormat {
+	case inventoryFormatScrape:
+		if inventoryHost != "" {
+			return fmt.Errorf("--host is not supported with --format scrape")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+		defer cancel()
+		data, err := renderScrapeConfigs(ctx, cfg, inventorySelector)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case inventory.FormatGrafana:
+		if inventoryHost != "" {
+			return fmt.Errorf("--host is not supported with --format grafana")
+		}
+		data, err := inventory.RenderGrafana(inventory.BuildGrafana(cfg))
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case inventory.FormatAlertmanager:
+		peers := inventory.BuildAlertmanagerPeers(cfg)
+		if inventoryHost != "" {
+			fmt.Println(peers.Flags(inventoryHost))
+			return nil
+		}
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		return out.Encode(peers)
+	}
+
+	var out interface{}
+	if inventoryHost != "" {
+		vars, err := inventory.HostVars(cfg, inventoryHost)
+		if err != nil {
+			return err
+		}
+		out = vars
+	} else {
+		out = inventory.BuildAnsible(cfg)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// serveInventory exposes the inventory over HTTP. The config is reloaded on
+// every request so node changes are picked up without a restart.
+func serveInventory(addr string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		cfg, ok := readSelectedConfig(w, r)
+		if !ok {
+			return
+		}
+
+		var out interface{}
+		if host := r.URL.Query().Get("host"); host != "" {
+			vars, err := inventory.HostVars(cfg, host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			out = vars
+		} else {
+			out = inventory.BuildAnsible(cfg)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.HandleWithAlias("/inventory", handler, api.Unversioned)
+	v1.HandleFunc("/sd/grafana", serveGrafanaSD)
+	v1.HandleFunc("/sd/alertmanager", serveAlertmanagerSD)
+	v1.HandleFunc("/sd/prometheus", servePrometheusSD)
+	v1.HandleFunc("/sd/scrape-configs", serveScrapeConfigsSD)
+
+	fmt.Printf("%s Serving inventory on http://%s/api/v1/inventory\n", green("✓"), addr)
+	fmt.Printf("%s Serving discovery documents on http://%s/api/v1/sd/{grafana,alertmanager,prometheus,scrape-configs}\n", green("✓"), addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// serveGrafanaSD serves the Grafana datasource provisioning file
+func serveGrafanaSD(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, ok := readSelectedConfig(w, r)
+	if !ok {
+		return
+	}
+	data, err := inventory.RenderGrafana(inventory.BuildGrafana(cfg))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
+
+// serveAlertmanagerSD serves the Alertmanager cluster peers, or with
+// ?node= the --cluster.peer flags of that node's Alertmanager
+func serveAlertmanagerSD(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, ok := readSelectedConfig(w, r)
+	if !ok {
+		return
+	}
+	peers := inventory.BuildAlertmanagerPeers(cfg)
+	if node := r.URL.Query().Get("node"); node != "" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintln(w, peers.Flags(node))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// servePrometheusSD serves the exporter targets in the Prometheus HTTP SD
+// format; ?job=node or ?job=dcgm limits them to one exporter
+func servePrometheusSD(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, ok := readSelectedConfig(w, r)
+	if !ok {
+		return
+	}
+	targets := []prometheus.Target{}
+	switch job := r.URL.Query().Get("job"); job {
+	case "":
+		targets = append(targets, prometheus.NodeTargets(cfg.Nodes)...)
+		targets = append(targets, prometheus.DCGMTargets(cfg.Nodes)...)
+	case "node":
+		targets = append(targets, prometheus.NodeTargets(cfg.Nodes)...)
+	case "dcgm":
+		targets = append(targets, prometheus.DCGMTargets(cfg.Nodes)...)
+	default:
+		http.Error(w, fmt.Sprintf("unknown job %q: must be node or dcgm", job), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targets); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readSelectedConfig reads the config with only the nodes matching the
+// ?selector= of the request. It answers the request itself and returns
+// false when that fails; a bad selector is a 400.
+func readSelectedConfig(w http.ResponseWriter, r *http.Request) (*config.Config, bool) {
+	cfg, err := readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	cfg, err = selectNodes(cfg, r.URL.Query().Get("selector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return cfg, true
+}
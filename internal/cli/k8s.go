@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/k8s"
+)
+
+var k8sHelmOutput string
+
+var k8sCmd = &cobra.Command{
+	Use:   "k8s",
+	Short: "Kubernetes deployment helpers",
+	Long:  `Render AAMI configuration for Prometheus stacks running on Kubernetes.`,
+}
+
+var k8sRenderHelmCmd = &cobra.Command{
+	Use:   "render-helm",
+	Short: "Generate kube-prometheus-stack values from AAMI config",
+	Long: `Generate a values.yaml for the kube-prometheus-stack Helm chart from the
+current AAMI configuration, so a Kubernetes deployment runs the same rules,
+targets and notifications as the AAMI-managed one.
+
+The values include:
+  - one PrometheusRule per generated rule file (additionalPrometheusRulesMap)
+  - node_exporter and DCGM exporter targets (additionalScrapeConfigs)
+  - the Alertmanager config and notification templates
+  - Prometheus retention, cluster label and the Grafana admin password
+
+The output contains credentials (webhook URLs, passwords). It is written
+with mode 0600; keep it out of version control or store it as a secret.
+
+Examples:
+  aami k8s render-helm -o values.yaml
+  helm upgrade --install monitoring prometheus-community/kube-prometheus-stack \
+    -n monitoring -f values.yaml`,
+	Args: cobra.NoArgs,
+	RunE: runK8sRenderHelm,
+}
+
+func init() {
+	k8sRenderHelmCmd.Flags().StringVarP(&k8sHelmOutput, "output", "o", "",
+		"Write values to this file instead of stdout")
+
+	k8sCmd.AddCommand(k8sRenderHelmCmd)
+	rootCmd.AddCommand(k8sCmd)
+}
+
+func runK8sRenderHelm(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	values, err := k8s.RenderHelmValues(cfg)
+	if err != nil {
+		return fmt.Errorf("render helm values: %w", err)
+	}
+
+	if k8sHelmOutput == "" {
+		_, err := os.Stdout.Write(values)
+		return err
+	}
+	if err := os.WriteFile(k8sHelmOutput, values, 0600); err != nil {
+		return fmt.Errorf("write values: %w", err)
+	}
+	fmt.Printf("%s Helm values written to %s\n", green("✓"), k8sHelmOutput)
+	return nil
+}
@@ -0,0 +1,194 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/contexts"
+	"github.com/fregataa/aami/internal/oidc"
+)
+
+var (
+	loginIssuer   string
+	loginClientID string
+)
+
+// loginRefreshBefore is how long before its expiry an ID token is renewed
+const loginRefreshBefore = time.Minute
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Sign in to the config server with SSO",
+	Long: `Sign in with the OpenID Connect provider of the config server, using
+the device authorization flow: open the printed address in any browser,
+enter the code and approve. The ID token and its refresh token are saved
+in the active context (~/.aami/config) and sent to the config server in
+place of the context's token; they are renewed as they expire.
+
+The provider is --issuer and --client-id, else the context's last login,
+else oidc.issuer and oidc.client_id of the config.
+
+Examples:
+  aami login
+  aami --context prod login --issuer https://sso.example.com/realms/ops --client-id aami-cli`,
+	Args: cobra.NoArgs,
+	RunE: runLogin,
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Remove the SSO login of the active context",
+	Args:  cobra.NoArgs,
+	RunE:  runLogout,
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginIssuer, "issuer", "", "OIDC issuer (default: the context's, then oidc.issuer)")
+	loginCmd.Flags().StringVar(&loginClientID, "client-id", "", "OIDC client ID (default: the context's, then oidc.client_id)")
+
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+}
+
+func runLogin(cmd *cobra.Command, args []string) error {
+	f, c, err := loginContext()
+	if err != nil {
+		return err
+	}
+	login := contexts.Login{Issuer: loginIssuer, ClientID: loginClientID}
+	if c.OIDC != nil {
+		login.Issuer = defaultString(login.Issuer, c.OIDC.Issuer)
+		login.ClientID = defaultString(login.ClientID, c.OIDC.ClientID)
+	}
+	if login.Issuer == "" || login.ClientID == "" {
+		if cfg, err := readConfig(); err == nil {
+			login.Issuer = defaultString(login.Issuer, cfg.OIDC.Issuer)
+			login.ClientID = defaultString(login.ClientID, cfg.OIDC.ClientID)
+		}
+	}
+	if login.Issuer == "" || login.ClientID == "" {
+		return fmt.Errorf("no OIDC provider: use --issuer and --client-id, or set oidc.issuer and oidc.client_id")
+	}
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+	provider, err := oidc.Discover(ctx, login.Issuer)
+	if err != nil {
+		return err
+	}
+	code, err := provider.StartDevice(ctx, login.ClientID)
+	if err != nil {
+		return err
+	}
+
+	bold := color.New(color.Bold).SprintFunc()
+	fmt.Printf("Open %s and enter the code %s\n", bold(code.VerificationURI), bold(code.UserCode))
+	if code.VerificationURIComplete != "" {
+		fmt.Printf("  or open %s\n", code.VerificationURIComplete)
+	}
+	fmt.Println("Waiting for approval...")
+
+	token, err := provider.PollDevice(ctx, login.ClientID, code)
+	if err != nil {
+		return err
+	}
+	setLoginToken(&login, token)
+	c.OIDC = &login
+	if err := f.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s Logged in to %s as %s (until %s)\n", color.GreenString("✓"), c.Name,
+		bold(defaultString(login.User, "-")), login.Expiry.Local().Format("2006-01-02 15:04"))
+	return nil
+}
+
+func runLogout(cmd *cobra.Command, args []string) error {
+	f, c, err := loginContext()
+	if err != nil {
+		return err
+	}
+	if !c.LoggedIn() {
+		fmt.Printf("Not logged in to %s.\n", c.Name)
+		return nil
+	}
+	// Keep the provider for the next login
+	c.OIDC = &contexts.Login{Issuer: c.OIDC.Issuer, ClientID: c.OIDC.ClientID}
+	if err := f.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("%s Logged out of %s\n", color.GreenString("✓"), c.Name)
+	return nil
+}
+
+// loginContext returns the contexts file and its active context, where
+// logins are kept
+func loginContext() (*contexts.File, *contexts.Context, error) {
+	f, err := loadContexts()
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := f.Active(contextName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c == nil {
+		return nil, nil, fmt.Errorf("no current context: create one with 'aami context set <name> --server <url>'")
+	}
+	return f, c, nil
+}
+
+// setLoginToken keeps the tokens of a login or refresh
+func setLoginToken(login *contexts.Login, token *oidc.Token) {
+	login.IDToken = token.IDToken
+	login.RefreshToken = defaultString(token.RefreshToken, login.RefreshToken)
+	login.Expiry = token.Expiry
+	if claims, err := oidc.UnverifiedClaims(token.IDToken); err == nil {
+		login.User = defaultString(claims.String("email"), claims.Subject)
+	}
+}
+
+// loginToken returns the ID token of the active context's login, renewed
+// first when it is about to expire. A token that cannot be renewed is
+// returned as it is, for the server to reject.
+func loginToken() string {
+	c := currentContext()
+	if !c.LoggedIn() {
+		return ""
+	}
+	login := *c.OIDC
+	if time.Until(login.Expiry) > loginRefreshBefore || login.RefreshToken == "" {
+		return login.IDToken
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+	provider, err := oidc.Discover(ctx, login.Issuer)
+	var token *oidc.Token
+	if err == nil {
+		token, err = provider.Refresh(ctx, login.ClientID, login.RefreshToken)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s SSO login of %s expired (%v); run 'aami login'\n", color.YellowString("!"), c.Name, err)
+		return login.IDToken
+	}
+	setLoginToken(&login, token)
+
+	// Save the renewed tokens, and keep them for the rest of the command
+	if f, err := loadContexts(); err == nil {
+		if saved, ok := f.Get(c.Name); ok {
+			saved.OIDC = &login
+			if err := f.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s save renewed login: %v\n", color.YellowString("!"), err)
+			}
+		}
+	}
+	if active, err := activeContext(); err == nil && active != nil {
+		active.OIDC = &login
+	}
+	return login.IDToken
+}
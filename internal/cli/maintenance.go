@@ -0,0 +1,448 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/maintenance"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/silence"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	maintenanceGroup           string
+	maintenanceTargets         []string
+	maintenanceSchedule        string
+	maintenanceStart           string
+	maintenanceDuration        string
+	maintenanceTimezone        string
+	maintenanceReason          string
+	maintenanceOutput          string
+	maintenanceConfigServerURL string
+)
+
+// maintenanceSyncInterval is how often aami silence serve syncs the
+// silences of maintenance windows
+const maintenanceSyncInterval = time.Minute
+
+var maintenanceCmd = &cobra.Command{
+	Use:     "maintenance",
+	Aliases: []string{"maintenance-windows"},
+	Short:   "Manage maintenance windows",
+	Long: `Manage maintenance windows: recurring or one-off periods in which a
+group of the config server, or some targets, are worked on and must not
+page anyone.
+
+While a window is open, its targets are silenced in Alertmanager until it
+closes; deleting an open window ends its silence. 'aami silence serve'
+keeps the silences in step every minute; without it, run 'aami maintenance
+sync' from cron or a systemd timer.
+
+Schedules are cron expressions (minute hour day month weekday) in the
+window's --timezone, local time by default.
+
+Examples:
+  aami maintenance create weekly-patching --group gpu-servers \
+    --schedule "0 2 * * sat" --duration 4h --reason "OS patching"
+  aami maintenance create psu-swap --target gpu-07 --target gpu-08 \
+    --start "2026-11-03 09:00" --duration 2h --reason "PSU replacement"
+  aami maintenance list
+  aami maintenance delete psu-swap`,
+}
+
+var maintenanceCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a maintenance window",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runMaintenanceCreate,
+}
+
+var maintenanceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List maintenance windows",
+	Args:  cobra.NoArgs,
+	RunE:  runMaintenanceList,
+}
+
+var maintenanceDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Delete a maintenance window and end its silence",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeMaintenanceWindows),
+	RunE:              runMaintenanceDelete,
+}
+
+var maintenanceSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Silence the targets of open windows now",
+	Long: `Create the silences of open maintenance windows and expire those of
+closed or deleted ones, once. Windows already silenced are left alone.`,
+	Args: cobra.NoArgs,
+	RunE: runMaintenanceSync,
+}
+
+func init() {
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceGroup, "group", "", "Config server group (ID or name) to silence")
+	maintenanceCreateCmd.Flags().StringArrayVar(&maintenanceTargets, "target", nil, "Target (node name) to silence (repeatable)")
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceSchedule, "schedule", "", `Cron schedule of a recurring window (e.g. "0 2 * * sat")`)
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceStart, "start", "", `Start of a one-off window (RFC 3339 or "2006-01-02 15:04")`)
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceDuration, "duration", "", "How long the window stays open (e.g. 30m, 4h, 1d)")
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceTimezone, "timezone", "", "Time zone of the schedule and start (default: local)")
+	maintenanceCreateCmd.Flags().StringVar(&maintenanceReason, "reason", "", "What the maintenance is for")
+	maintenanceCreateCmd.MarkFlagRequired("duration")
+	maintenanceCreateCmd.MarkFlagRequired("reason")
+	maintenanceCreateCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	maintenanceCreateCmd.RegisterFlagCompletionFunc("target", completeTargets)
+	addOutputFlag(maintenanceListCmd, &maintenanceOutput)
+
+	for _, c := range []*cobra.Command{maintenanceDeleteCmd, maintenanceSyncCmd} {
+		c.Flags().StringVar(&silenceAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL, "Alertmanager URL")
+	}
+	maintenanceSyncCmd.Flags().StringVar(&maintenanceConfigServerURL, "config-server-url", "",
+		"Config server address for groups (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+
+	maintenanceCmd.AddCommand(maintenanceCreateCmd)
+	maintenanceCmd.AddCommand(maintenanceListCmd)
+	maintenanceCmd.AddCommand(maintenanceDeleteCmd)
+	maintenanceCmd.AddCommand(maintenanceSyncCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
+
+func newMaintenanceStore() *maintenance.Store {
+	return maintenance.NewStore(maintenance.DefaultStorePath)
+}
+
+func runMaintenanceCreate(cmd *cobra.Command, args []string) error {
+	w := maintenance.Window{
+		Name:      args[0],
+		Group:     maintenanceGroup,
+		Targets:   maintenanceTargets,
+		Schedule:  maintenanceSchedule,
+		Duration:  maintenanceDuration,
+		Timezone:  maintenanceTimezone,
+		Reason:    maintenanceReason,
+		CreatedBy: currentUser(),
+	}
+	if maintenanceStart != "" {
+		start, err := parseWindowStart(maintenanceStart, maintenanceTimezone)
+		if err != nil {
+			return err
+		}
+		w.Start = &start
+	}
+
+	w, err := newMaintenanceStore().Create(w)
+	if err != nil {
+		return err
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Created maintenance window %s\n", green("✓"), w.Name)
+	if start, end, ok := w.Next(time.Now()); ok {
+		fmt.Printf("  Next: %s - %s\n", start.Local().Format("2006-01-02 15:04"), end.Local().Format("2006-01-02 15:04"))
+	}
+	if _, end, open, _ := w.Open(time.Now()); open {
+		fmt.Printf("  Open now, until %s: run 'aami maintenance sync' or wait for aami silence serve\n", end.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// parseWindowStart parses --start in the window's time zone
+func parseWindowStart(s, timezone string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	loc := time.Local
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return time.Time{}, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start %q: use RFC 3339 or \"2006-01-02 15:04\"", s)
+	}
+	return t, nil
+}
+
+// windowListing is a maintenance window as listed
+type windowListing struct {
+	maintenance.Window
+	Open      bool       `json:"open"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	NextStart *time.Time `json:"next_start,omitempty"`
+}
+
+func listWindows(windows []maintenance.Window, now time.Time) []windowListing {
+	listing := []windowListing{}
+	for _, w := range windows {
+		l := windowListing{Window: w}
+		if _, end, open, _ := w.Open(now); open {
+			l.Open, l.OpenUntil = true, &end
+		}
+		if start, _, ok := w.Next(now); ok {
+			l.NextStart = &start
+		}
+		listing = append(listing, l)
+	}
+	return listing
+}
+
+func runMaintenanceList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(maintenanceOutput)
+	if err != nil {
+		return err
+	}
+	windows, err := newMaintenanceStore().List()
+	if err != nil {
+		return err
+	}
+	listing := listWindows(windows, time.Now())
+	if format.Structured() {
+		return writeOutput(format, listing)
+	}
+	if len(listing) == 0 {
+		fmt.Println("No maintenance windows.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Name"},
+		{Header: "Scope"},
+		{Header: "When"},
+		{Header: "Duration"},
+		{Header: "State"},
+		{Header: "Reason"},
+		{Header: "Timezone", Wide: true},
+		{Header: "Created By", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, l := range listing {
+		scope := "group " + l.Group
+		if l.Group == "" {
+			scope = strings.Join(l.Targets, ", ")
+		}
+		when := l.Schedule
+		if l.Start != nil {
+			when = l.Start.Local().Format("2006-01-02 15:04")
+		}
+		state := color.YellowString("ended")
+		switch {
+		case l.Open:
+			state = color.GreenString("open until " + l.OpenUntil.Local().Format("01-02 15:04"))
+		case l.NextStart != nil:
+			state = "next " + l.NextStart.Local().Format("01-02 15:04")
+		}
+		table.Append(columns.Row(format,
+			l.Name,
+			scope,
+			when,
+			l.Duration,
+			state,
+			l.Reason,
+			defaultString(l.Timezone, "local"),
+			defaultString(l.CreatedBy, "-"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runMaintenanceDelete(cmd *cobra.Command, args []string) error {
+	if err := newMaintenanceStore().Delete(args[0]); err != nil {
+		return err
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Deleted maintenance window %s\n", green("✓"), args[0])
+
+	closed, err := maintenance.Close(newSilenceManager(cmd), args[0])
+	if err != nil {
+		fmt.Printf("%s Its silence was not expired (%v); the next sync expires it\n", color.YellowString("•"), err)
+		return nil
+	}
+	if closed > 0 {
+		fmt.Printf("%s Expired its silence\n", green("✓"))
+	}
+	return nil
+}
+
+func runMaintenanceSync(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	windows, err := newMaintenanceStore().List()
+	if err != nil {
+		return err
+	}
+	actions, err := maintenance.Sync(windows, newSilenceManager(cmd), maintenanceResolver(cfg, maintenanceConfigServerURL), time.Now())
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		fmt.Println("Silences are up to date.")
+		return nil
+	}
+	return printMaintenanceActions(actions)
+}
+
+// printMaintenanceActions reports what a sync did
+func printMaintenanceActions(actions []maintenance.Action) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	failed := 0
+	for _, a := range actions {
+		switch a.Action {
+		case "silenced":
+			fmt.Printf("%s %s silenced until %s (%s)\n", green("✓"), a.Window, a.Until.Local().Format("2006-01-02 15:04"), a.Silence)
+		case "unsilenced":
+			fmt.Printf("%s %s closed, silence %s expired\n", green("✓"), a.Window, a.Silence)
+		default:
+			fmt.Printf("%s %s: %s\n", red("✗"), a.Window, a.Error)
+			failed++
+		}
+	}
+	return countError(failed, len(actions), "windows")
+}
+
+// maintenanceResolver resolves the groups of windows to the hostnames of
+// their active targets on the config server
+func maintenanceResolver(cfg *config.Config, urlFlag string) maintenance.Resolver {
+	return func(group string) ([]string, error) {
+		client, err := configServerClient(cfg, urlFlag)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+		defer cancel()
+
+		g, err := client.FindGroup(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := client.ListTargetsByGroup(ctx, g.ID)
+		if err != nil {
+			return nil, err
+		}
+		var nodes []string
+		for _, t := range targets {
+			if t.Status != configserver.TargetInactive {
+				nodes = append(nodes, t.Hostname)
+			}
+		}
+		return nodes, nil
+	}
+}
+
+// syncMaintenanceWindows syncs the silences of maintenance windows once,
+// for aami silence serve, reporting on stderr
+func syncMaintenanceWindows(manager *silence.Manager) {
+	yellow := color.New(color.FgYellow).SprintFunc()
+	cfg, err := readConfig()
+	if err == nil {
+		var windows []maintenance.Window
+		if windows, err = newMaintenanceStore().List(); err == nil {
+			var actions []maintenance.Action
+			actions, err = maintenance.Sync(windows, manager, maintenanceResolver(cfg, ""), time.Now())
+			for _, a := range actions {
+				if a.Error != "" {
+					fmt.Fprintf(os.Stderr, "%s maintenance window %s: %s\n", yellow("•"), a.Window, a.Error)
+				} else {
+					fmt.Fprintf(os.Stderr, "maintenance window %s: %s %s\n", a.Window, a.Action, a.Silence)
+				}
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s sync maintenance windows: %v\n", yellow("•"), err)
+	}
+}
+
+// maintenanceHandler serves /api/v1/maintenance-windows for aami silence
+// serve. Windows span namespaces, so API keys may not use it.
+func maintenanceHandler(manager *silence.Manager) http.Handler {
+	store := newMaintenanceStore()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t, _ := tenant.FromContext(r.Context()); t.Scoped() {
+			http.Error(w, fmt.Sprintf("%s cannot manage maintenance windows", t.Name), http.StatusForbidden)
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/maintenance-windows"), "/")
+		switch {
+		case name == "" && r.Method == http.MethodGet:
+			windows, err := store.List()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, listWindows(windows, time.Now()))
+
+		case name == "" && r.Method == http.MethodPost:
+			var req maintenance.Window
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+				return
+			}
+			req.CreatedAt = time.Time{}
+			if t, _ := tenant.FromContext(r.Context()); req.CreatedBy == "" {
+				req.CreatedBy = t.Name
+			}
+			created, err := store.Create(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusCreated, listWindows([]maintenance.Window{created}, time.Now())[0])
+
+		case name != "" && r.Method == http.MethodGet:
+			window, ok, err := store.Get(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "maintenance window not found: "+name, http.StatusNotFound)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, listWindows([]maintenance.Window{window}, time.Now())[0])
+
+		case name != "" && r.Method == http.MethodDelete:
+			if err := store.Delete(name); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			// The sync loop expires the silence if Alertmanager is away now
+			maintenance.Close(manager, name)
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func completeMaintenanceWindows(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	if windows, err := newMaintenanceStore().List(); err == nil {
+		for _, w := range windows {
+			candidates[w.Name] = w.Reason
+		}
+	}
+	return completions(candidates, toComplete)
+}
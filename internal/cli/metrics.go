@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fregataa/aami/internal/metrics"
+	"github.com/fregataa/aami/internal/quota"
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// serveMetrics serves the metrics of a serve command in the Prometheus
+// text format: config cache reads, requests, generated files and reloads
+// (internal/metrics), and the usage and quotas of each namespace
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := sharedConfigCache().Stats()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, help string
+		value      uint64
+	}{
+		{"aami_config_cache_hits_total", "Config reads served from the cache.", stats.Hits},
+		{"aami_config_cache_misses_total", "Config reads that loaded the config file.", stats.Misses},
+		{"aami_config_cache_invalidations_total", "Cached configs dropped because the config was written or changed.", stats.Invalidations},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", m.name, m.help, m.name, m.name, m.value)
+	}
+	if err := metrics.WriteText(w); err != nil {
+		return
+	}
+	if err := writeDriftStatusMetrics(w); err != nil {
+		return
+	}
+
+	// Namespace gauges are best effort: a broken config or rules
+	// directory should not hide the metrics above
+	cfg, err := readConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: %v\n", err)
+		return
+	}
+	usages, err := namespaceUsages(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: %v\n", err)
+		return
+	}
+	exporter.WriteText(w, namespaceMetrics(usages))
+}
+
+// namespaceMetrics returns the usage of every namespace, and its limits
+// where a quota is set
+func namespaceMetrics(usages []*quota.Usage) []exporter.Metric {
+	var out []exporter.Metric
+	for _, u := range usages {
+		for _, res := range []struct {
+			name string
+			quota.Resource
+		}{
+			{quota.ResourceTargets, u.Targets},
+			{quota.ResourceGroups, u.Groups},
+			{quota.ResourceRules, u.Rules},
+		} {
+			labels := map[string]string{"namespace": u.Namespace, "resource": res.name}
+			out = append(out, exporter.NewGauge("aami_namespace_usage",
+				"Targets, rule groups and alert rules of a namespace.", float64(res.Used), labels))
+			if res.Limit > 0 {
+				out = append(out, exporter.NewGauge("aami_namespace_quota_limit",
+					"Quota of a namespace; absent when unlimited.", float64(res.Limit), labels))
+			}
+		}
+	}
+	return out
+}
+
+// observeRequest records a served request in metrics.HTTPRequests
+func observeRequest(r *http.Request, route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.HTTPRequests.ObserveDuration(d, route, r.Method, strconv.Itoa(status))
+}
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/prometheus"
+	"github.com/fregataa/aami/internal/quota"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	namespacesOutput string
+	namespacesListen string
+)
+
+var namespacesCmd = &cobra.Command{
+	Use:     "namespaces",
+	Aliases: []string{"ns"},
+	Short:   "Show namespace quotas and their usage",
+	Long: `Show the quotas of namespaces and how much of them is in use.
+
+Namespaces are configured under alerts.namespaces. A node belongs to a
+namespace through its "namespace" label; rule groups and alert rules are
+counted in the namespace's directory under /etc/aami/rules. A quota of 0
+is unlimited.
+
+Quotas are enforced when nodes are added (nodes add, discovery) and when
+rule files are written (alerts apply-preset, report recording-rules).
+Changes that would take a namespace over its quota fail with a
+quota-exceeded error; changes that do not add to a resource still work,
+so a lowered quota does not block cleaning up.
+
+Examples:
+  aami namespaces list
+  aami namespaces usage team-a -o json
+  aami namespaces serve --listen :8100`,
+}
+
+var namespacesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List namespaces with their quota usage",
+	Args:  cobra.NoArgs,
+	RunE:  runNamespacesList,
+}
+
+var namespacesUsageCmd = &cobra.Command{
+	Use:   "usage <namespace>",
+	Short: "Show the quota usage of a namespace",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNamespacesUsage,
+}
+
+var namespacesServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the namespace API",
+	Long: `Serve the namespace API:
+
+  GET /api/v1/namespaces                Quota usage of all namespaces
+  GET /api/v1/namespaces/<name>/usage   Quota usage of one namespace
+
+Requests authenticate with "Authorization: Bearer <admin.token>", or with
+an API key (api_keys) or SSO ID token (oidc, 'aami login'), which sees only
+its own namespace.
+
+Examples:
+  aami namespaces serve --listen :8100`,
+	Args: cobra.NoArgs,
+	RunE: runNamespacesServe,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{namespacesListCmd, namespacesUsageCmd} {
+		addOutputFlag(c, &namespacesOutput)
+	}
+	namespacesServeCmd.Flags().StringVar(&namespacesListen, "listen", ":8100",
+		"Address to listen on")
+
+	namespacesCmd.AddCommand(namespacesListCmd)
+	namespacesCmd.AddCommand(namespacesUsageCmd)
+	namespacesCmd.AddCommand(namespacesServeCmd)
+	rootCmd.AddCommand(namespacesCmd)
+}
+
+func runNamespacesList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(namespacesOutput)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	usages, err := namespaceUsages(cfg)
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, usages)
+	}
+	if len(usages) == 0 {
+		fmt.Println(i18n.T("No namespaces configured"))
+		return nil
+	}
+	printNamespaceUsages(usages)
+	return nil
+}
+
+func runNamespacesUsage(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(namespacesOutput)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if !hasNamespace(cfg, args[0]) {
+		return fmt.Errorf("namespace not found: %s", args[0])
+	}
+	usage, err := prometheus.NamespaceUsage(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, usage)
+	}
+	printNamespaceUsages([]*quota.Usage{usage})
+	return nil
+}
+
+func printNamespaceUsages(usages []*quota.Usage) {
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Namespace"), i18n.T("Targets"), i18n.T("Groups"), i18n.T("Alert Rules")})
+	for _, u := range usages {
+		table.Append([]string{u.Namespace, formatQuota(u.Targets), formatQuota(u.Groups), formatQuota(u.Rules)})
+	}
+	table.Render()
+}
+
+// formatQuota shows a resource as used/limit, marking resources over their
+// limit (after the limit was lowered)
+func formatQuota(r quota.Resource) string {
+	if r.Limit <= 0 {
+		return fmt.Sprintf("%d/-", r.Used)
+	}
+	s := fmt.Sprintf("%d/%d", r.Used, r.Limit)
+	if r.Used > r.Limit {
+		s = color.RedString(s)
+	}
+	return s
+}
+
+// namespaceNames returns the configured namespaces and the ones nodes are
+// labelled with, sorted
+func namespaceNames(cfg *config.Config) []string {
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, ns := range cfg.Alerts.Namespaces {
+		add(ns.Name)
+	}
+	for name := range quota.Targets(cfg) {
+		add(name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hasNamespace(cfg *config.Config, name string) bool {
+	for _, n := range namespaceNames(cfg) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func namespaceUsages(cfg *config.Config) ([]*quota.Usage, error) {
+	usages := []*quota.Usage{}
+	for _, name := range namespaceNames(cfg) {
+		usage, err := prometheus.NamespaceUsage(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// writeQuotaError answers 403 with the quota-exceeded details if err is a
+// quota error, and reports whether it was one
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	writeSilenceJSON(w, http.StatusForbidden, map[string]interface{}{
+		"error": exceeded.Error(),
+		"quota": exceeded,
+	})
+	return true
+}
+
+// runNamespacesServe serves /api/v1/namespaces. The config is reloaded on
+// every request so quota changes apply without a restart.
+func runNamespacesServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	mux := newAPIMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// API keys see only their own namespace
+		t, _ := tenant.FromContext(r.Context())
+		cfg = t.Scope(cfg)
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/namespaces"), "/")
+		if path == "" {
+			usages, err := namespaceUsages(cfg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, usages)
+			return
+		}
+
+		name, rest, _ := strings.Cut(path, "/")
+		if rest != "usage" {
+			http.NotFound(w, r)
+			return
+		}
+		if !hasNamespace(cfg, name) {
+			http.Error(w, fmt.Sprintf("namespace not found: %s", name), http.StatusNotFound)
+			return
+		}
+		usage, err := prometheus.NamespaceUsage(cfg, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, usage)
+	}
+	scoped := tenant.Middleware(readConfig, func(c *config.Config) string { return c.Admin.Token }, http.HandlerFunc(handler))
+	v1 := mux.Version("v1")
+	v1.Handle("/namespaces", scoped)
+	v1.Handle("/namespaces/", scoped)
+
+	fmt.Printf("%s Serving namespace API on http://%s/api/v1/namespaces\n", green("✓"), namespacesListen)
+	return http.ListenAndServe(namespacesListen, mux)
+}
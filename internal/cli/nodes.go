@@ -5,21 +5,25 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
 	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/selector"
 	"github.com/fregataa/aami/internal/ssh"
 )
 
 var nodesCmd = &cobra.Command{
-	Use:   "nodes",
-	Short: "Manage GPU nodes",
-	Long:  "Add, remove, list, and manage monitored GPU nodes.",
+	Use:     "nodes",
+	Aliases: []string{"node"},
+	Short:   "Manage GPU nodes",
+	Long:    "Add, remove, list, and manage monitored GPU nodes.",
 }
 
 var nodesAddCmd = &cobra.Command{
@@ -37,14 +41,41 @@ Examples:
 var nodesListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all nodes",
-	RunE:  runNodesList,
+	Long: `List configured nodes, optionally only those matching a label selector.
+
+Selectors use the kubectl syntax: comma-separated terms that must all hold.
+  key=value, key!=value, key in (v1,v2), key notin (v1,v2), key, !key
+
+Examples:
+  aami nodes list
+  aami nodes list -l rack=a1,gpu_model!=H100
+  aami nodes list -l 'env in (prod,staging),!maintenance'
+  aami nodes list -o wide   # With the SSH key and inventory source`,
+	Args: cobra.NoArgs,
+	RunE: runNodesList,
 }
 
 var nodesRemoveCmd = &cobra.Command{
-	Use:   "remove [name]",
-	Short: "Remove a node from the cluster",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runNodesRemove,
+	Use:               "remove [name]",
+	Short:             "Remove a node from the cluster",
+	ValidArgsFunction: firstArg(completeNodes),
+	Args:              cobra.ExactArgs(1),
+	RunE:              runNodesRemove,
+}
+
+var nodesPatchCmd = &cobra.Command{
+	Use:   "patch [name] [patch]",
+	Short: "Change fields of a node with a JSON merge patch",
+	Long: `Apply an RFC 7386 JSON merge patch to one node, changing only the fields
+in the patch. null removes a field or label.
+
+Examples:
+  aami nodes patch gpu-01 '{"ip": "192.168.1.110"}'
+  aami nodes patch gpu-01 '{"labels": {"rack": "r3", "gpu_type": null}}'
+  aami nodes patch gpu-01 --file node-patch.yaml --dry-run`,
+	ValidArgsFunction: firstArg(completeNodes),
+	Args:              cobra.RangeArgs(1, 2),
+	RunE:              runNodesPatch,
 }
 
 var nodesInstallCmd = &cobra.Command{
@@ -55,7 +86,8 @@ var nodesInstallCmd = &cobra.Command{
 Examples:
   aami nodes install gpu-01        # Install on single node
   aami nodes install --all         # Install on all nodes`,
-	RunE: runNodesInstall,
+	ValidArgsFunction: firstArg(completeNodes),
+	RunE:              runNodesInstall,
 }
 
 var nodesTestCmd = &cobra.Command{
@@ -66,7 +98,8 @@ var nodesTestCmd = &cobra.Command{
 Examples:
   aami nodes test gpu-01           # Test single node
   aami nodes test --all            # Test all nodes`,
-	RunE: runNodesTest,
+	ValidArgsFunction: firstArg(completeNodes),
+	RunE:              runNodesTest,
 }
 
 var (
@@ -77,6 +110,9 @@ var (
 	nodeLabels string
 	nodesFile  string
 	allNodes   bool
+
+	nodesSelector string
+	nodesOutput   string
 )
 
 func init() {
@@ -88,6 +124,12 @@ func init() {
 	nodesAddCmd.Flags().StringVar(&nodeLabels, "labels", "", "Labels (k=v,k2=v2)")
 	nodesAddCmd.Flags().StringVar(&nodesFile, "file", "", "File with nodes list (format: name ip)")
 
+	nodesListCmd.Flags().StringVarP(&nodesSelector, "selector", "l", "",
+		"Label selector (e.g. rack=a1,gpu_model!=H100)")
+	addOutputFlag(nodesListCmd, &nodesOutput)
+
+	addPatchFlags(nodesPatchCmd)
+
 	nodesInstallCmd.Flags().BoolVar(&allNodes, "all", false, "Install on all nodes")
 	nodesTestCmd.Flags().BoolVar(&allNodes, "all", false, "Test all nodes")
 
@@ -95,13 +137,14 @@ func init() {
 	nodesCmd.AddCommand(nodesAddCmd)
 	nodesCmd.AddCommand(nodesListCmd)
 	nodesCmd.AddCommand(nodesRemoveCmd)
+	nodesCmd.AddCommand(nodesPatchCmd)
 	nodesCmd.AddCommand(nodesInstallCmd)
 	nodesCmd.AddCommand(nodesTestCmd)
 	rootCmd.AddCommand(nodesCmd)
 }
 
 func runNodesAdd(cmd *cobra.Command, args []string) error {
-	cfg, err := loadConfig()
+	cfg, version, err := loadConfigVersion()
 	if err != nil {
 		return err
 	}
@@ -114,8 +157,9 @@ func runNodesAdd(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%s Added %d nodes from %s\n", green("✓"), count, nodesFile)
-		return saveConfig(cfg)
+		fmt.Printf("%s %s\n", green("✓"), i18n.T("Added %d nodes from %s", count, nodesFile))
+		_, err = saveConfig(cfg, version)
+		return err
 	}
 
 	// Add single node
@@ -144,52 +188,100 @@ func runNodesAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	cfg.Nodes = append(cfg.Nodes, node)
-	if err := saveConfig(cfg); err != nil {
+	if _, err := saveConfig(cfg, version); err != nil {
 		return err
 	}
 
-	fmt.Printf("%s Node %s added\n", green("✓"), node.Name)
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Node %s added", node.Name))
 	return nil
 }
 
+// nodeListing is a configured node as listed
+type nodeListing struct {
+	Name    string            `json:"name"`
+	IP      string            `json:"ip"`
+	SSHPort int               `json:"ssh_port"`
+	SSHUser string            `json:"ssh_user"`
+	SSHKey  string            `json:"ssh_key"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Source  string            `json:"source,omitempty"`
+}
+
 func runNodesList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(nodesOutput)
+	if err != nil {
+		return err
+	}
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
 	}
+	sel, err := selector.Parse(nodesSelector)
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		listing := []nodeListing{}
+		for _, node := range sel.Nodes(cfg.Nodes) {
+			listing = append(listing, nodeListing{
+				Name:    node.Name,
+				IP:      node.IP,
+				SSHPort: nodeSSHPort(node),
+				SSHUser: node.SSHUser,
+				SSHKey:  node.SSHKey,
+				Labels:  node.Labels,
+				Source:  node.Source,
+			})
+		}
+		return writeOutput(format, listing)
+	}
 
 	if len(cfg.Nodes) == 0 {
-		fmt.Println("No nodes configured.")
-		fmt.Println("Add nodes with: aami nodes add <name> --ip <ip> --user <user> --key <key>")
+		fmt.Println(i18n.T("No nodes configured."))
+		fmt.Println(i18n.T("Add nodes with: aami nodes add <name> --ip <ip> --user <user> --key <key>"))
+		return nil
+	}
+
+	nodes := sel.Nodes(cfg.Nodes)
+	if len(nodes) == 0 {
+		fmt.Println(i18n.T("No nodes match selector %s", sel))
 		return nil
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "IP", "Port", "User", "Labels"})
+	columns := output.Columns{
+		{Header: i18n.T("Name")},
+		{Header: "IP"},
+		{Header: i18n.T("Port")},
+		{Header: i18n.T("User")},
+		{Header: i18n.T("SSH Key"), Wide: true},
+		{Header: i18n.T("Labels")},
+		{Header: i18n.T("Source"), Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
 	table.SetBorder(true)
 	table.SetRowLine(false)
 
-	for _, node := range cfg.Nodes {
-		port := node.SSHPort
-		if port == 0 {
-			port = 22
-		}
-		table.Append([]string{
+	for _, node := range nodes {
+		table.Append(columns.Row(format,
 			node.Name,
 			node.IP,
-			fmt.Sprintf("%d", port),
+			fmt.Sprintf("%d", nodeSSHPort(node)),
 			node.SSHUser,
+			defaultString(node.SSHKey, "-"),
 			formatLabels(node.Labels),
-		})
+			defaultString(node.Source, "-"),
+		))
 	}
 
 	table.Render()
-	fmt.Printf("\nTotal: %d nodes\n", len(cfg.Nodes))
+	fmt.Println("\n" + i18n.T("Total: %d nodes", len(nodes)))
 	return nil
 }
 
 func runNodesRemove(cmd *cobra.Command, args []string) error {
-	cfg, err := loadConfig()
+	cfg, version, err := loadConfigVersion()
 	if err != nil {
 		return err
 	}
@@ -211,15 +303,48 @@ func runNodesRemove(cmd *cobra.Command, args []string) error {
 	}
 
 	cfg.Nodes = newNodes
-	if err := saveConfig(cfg); err != nil {
+	if _, err := saveConfig(cfg, version); err != nil {
 		return err
 	}
 
 	green := color.New(color.FgGreen).SprintFunc()
-	fmt.Printf("%s Node %s removed\n", green("✓"), nodeName)
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Node %s removed", nodeName))
 	return nil
 }
 
+func runNodesPatch(cmd *cobra.Command, args []string) error {
+	patch, err := readPatch(args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, version, err := loadConfigVersion()
+	if err != nil {
+		return err
+	}
+
+	name := args[0]
+	for i := range cfg.Nodes {
+		if cfg.Nodes[i].Name != name {
+			continue
+		}
+		node := cfg.Nodes[i]
+		if err := config.Patch(&node, patch); err != nil {
+			return err
+		}
+		if node.Name == "" {
+			return fmt.Errorf("node name cannot be removed")
+		}
+		if node.Name != name {
+			if _, exists := findNode(cfg, node.Name); exists {
+				return fmt.Errorf("node %s already exists", node.Name)
+			}
+		}
+		cfg.Nodes[i] = node
+		return savePatched(cfg, version, node, "node "+node.Name)
+	}
+	return fmt.Errorf("node %s not found", name)
+}
+
 func runNodesInstall(cmd *cobra.Command, args []string) error {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -249,7 +374,7 @@ func runNodesInstall(cmd *cobra.Command, args []string) error {
 	green := color.New(color.FgGreen).SprintFunc()
 	red := color.New(color.FgRed).SprintFunc()
 
-	fmt.Printf("Installing exporters on %d node(s)...\n\n", len(nodesToInstall))
+	fmt.Println(i18n.T("Installing exporters on %d node(s)...", len(nodesToInstall)) + "\n")
 
 	// TODO: Implement actual installation via SSH
 	for _, node := range nodesToInstall {
@@ -257,9 +382,9 @@ func runNodesInstall(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Println()
-	fmt.Printf("%s Installation complete\n", green("✓"))
-	fmt.Printf("  Succeeded: %s\n", green(fmt.Sprintf("%d", len(nodesToInstall))))
-	fmt.Printf("  Failed:    %s\n", red("0"))
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Installation complete"))
+	fmt.Println(i18n.T("  Succeeded: %s", green(fmt.Sprintf("%d", len(nodesToInstall)))))
+	fmt.Println(i18n.T("  Failed:    %s", red("0")))
 
 	return nil
 }
@@ -293,7 +418,7 @@ func runNodesTest(cmd *cobra.Command, args []string) error {
 	green := color.New(color.FgGreen).SprintFunc()
 	red := color.New(color.FgRed).SprintFunc()
 
-	fmt.Printf("Testing SSH connection to %d node(s)...\n\n", len(nodesToTest))
+	fmt.Println(i18n.T("Testing SSH connection to %d node(s)...", len(nodesToTest)) + "\n")
 
 	executor := ssh.NewExecutorFromConfig(
 		cfg.SSH.MaxParallel,
@@ -330,11 +455,11 @@ func runNodesTest(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Println()
-	fmt.Printf("Results: %s succeeded, %s failed\n",
+	fmt.Println(i18n.T("Results: %s succeeded, %s failed",
 		green(fmt.Sprintf("%d", succeeded)),
-		red(fmt.Sprintf("%d", failed)))
+		red(fmt.Sprintf("%d", failed))))
 
-	return nil
+	return countError(failed, len(nodesToTest), "nodes")
 }
 
 func addNodesFromFile(cfg *config.Config, filepath string) (int, error) {
@@ -397,6 +522,14 @@ func parseLabels(s string) map[string]string {
 	return labels
 }
 
+// nodeSSHPort returns the SSH port of a node, 22 when not set
+func nodeSSHPort(node config.NodeConfig) int {
+	if node.SSHPort == 0 {
+		return 22
+	}
+	return node.SSHPort
+}
+
 func formatLabels(labels map[string]string) string {
 	if len(labels) == 0 {
 		return "-"
@@ -405,9 +538,22 @@ func formatLabels(labels map[string]string) string {
 	for k, v := range labels {
 		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
 	}
+	sort.Strings(pairs)
 	return strings.Join(pairs, ", ")
 }
 
+// selectNodes returns cfg with only the nodes matching the label selector
+// s. The rest of cfg is shared, so the result must not be saved.
+func selectNodes(cfg *config.Config, s string) (*config.Config, error) {
+	sel, err := selector.Parse(s)
+	if err != nil || len(sel) == 0 {
+		return cfg, err
+	}
+	selected := *cfg
+	selected.Nodes = sel.Nodes(cfg.Nodes)
+	return &selected, nil
+}
+
 func findNode(cfg *config.Config, name string) (config.NodeConfig, bool) {
 	for _, node := range cfg.Nodes {
 		if node.Name == name {
@@ -0,0 +1,278 @@
+package cli
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/notify"
+)
+
+var (
+	notificationsTemplate  string
+	notificationsResolved  bool
+	notificationsOutputDir string
+	notificationsListen    string
+)
+
+var notificationsCmd = &cobra.Command{
+	Use:   "notifications",
+	Short: "Manage notification channels and message templates",
+	Long: `Manage notification channels and their message templates.
+
+Each channel (slack, email, webhook) can use its own Go template file, set
+with notifications.<channel>.template in the config. Templates receive the
+same data as Alertmanager templates (.Status, .Alerts, .CommonLabels, ...).
+Slack templates render a JSON array of Block Kit blocks, webhook templates
+the JSON payload, and email templates the HTML body.
+
+Alertmanager sends email itself. Slack and webhook notifications go through
+'aami notifications serve', which renders their templates and sends them.
+
+Examples:
+  aami notifications preview slack                   # Render with sample data
+  aami notifications preview email --template my.html
+  aami notifications test slack                      # Send a sample message
+  aami notifications generate                        # Write Alertmanager config
+  aami notifications serve --listen :8115            # Relay and template API`,
+}
+
+var notificationsPreviewCmd = &cobra.Command{
+	Use:   "preview <channel>",
+	Short: "Render a channel template with sample alert data",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNotificationsPreview,
+}
+
+var notificationsTestCmd = &cobra.Command{
+	Use:   "test <channel>",
+	Short: "Send a sample notification to a channel",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNotificationsTest,
+}
+
+var notificationsGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate Alertmanager config from notification settings",
+	Long: `Generate alertmanager.yml and the channel template file.
+
+Templates are rendered against sample data first, so a broken template is
+reported here instead of when an alert fires.`,
+	Args: cobra.NoArgs,
+	RunE: runNotificationsGenerate,
+}
+
+var notificationsServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Relay Alertmanager notifications and serve the template API",
+	Long: `Serve the notification relay and template API:
+
+  POST /api/v1/notifications/alertmanager   Alertmanager webhook receiver
+  POST /api/v1/notifications/preview        Render a template with sample data
+  POST /api/v1/notifications/test           Send a sample notification
+
+The generated Alertmanager config sends Slack and webhook notifications to
+notifications.relay_url (default: http://localhost:8115), where they are
+rendered with the channel templates and sent. The receiver checks
+"Authorization: Bearer <notifications.relay_token>" if the token is set.
+Preview and test requests authenticate with "Authorization: Bearer
+<admin.token>" and take {"channel", "template", "resolved"}, "template"
+being template text to use instead of the configured template.
+
+Examples:
+  aami notifications serve --listen :8115`,
+	Args: cobra.NoArgs,
+	RunE: runNotificationsServe,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{notificationsPreviewCmd, notificationsTestCmd} {
+		c.Flags().StringVar(&notificationsTemplate, "template", "",
+			"Template file to use instead of the configured one")
+		c.Flags().BoolVar(&notificationsResolved, "resolved", false,
+			"Use a resolved alert instead of a firing one")
+	}
+	notificationsGenerateCmd.Flags().StringVar(&notificationsOutputDir, "output-dir", notify.AlertmanagerDir,
+		"Directory for alertmanager.yml and templates")
+	notificationsServeCmd.Flags().StringVar(&notificationsListen, "listen", ":8115",
+		"Address to listen on")
+
+	notificationsCmd.AddCommand(notificationsPreviewCmd)
+	notificationsCmd.AddCommand(notificationsTestCmd)
+	notificationsCmd.AddCommand(notificationsGenerateCmd)
+	notificationsCmd.AddCommand(notificationsServeCmd)
+	rootCmd.AddCommand(notificationsCmd)
+}
+
+// renderNotification renders a channel's template against sample data
+func renderNotification(channel string) (string, *notify.Data, error) {
+	if !notify.IsChannel(channel) {
+		return "", nil, fmt.Errorf("unknown channel: %s (available: %s)",
+			channel, strings.Join(notify.Channels, ", "))
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var text string
+	if notificationsTemplate != "" {
+		if text, err = notify.LoadTemplate(channel, notificationsTemplate); err != nil {
+			return "", nil, err
+		}
+	}
+	return notify.Preview(cfg, channel, text, notificationsResolved)
+}
+
+func runNotificationsPreview(cmd *cobra.Command, args []string) error {
+	message, _, err := renderNotification(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Print(message)
+	if !strings.HasSuffix(message, "\n") {
+		fmt.Println()
+	}
+	return nil
+}
+
+func runNotificationsTest(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	message, data, err := renderNotification(args[0])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := notify.Send(cfg, args[0], message, data); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Sent test notification to %s\n", green("✓"), args[0])
+	return nil
+}
+
+func runNotificationsGenerate(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if err := ensureWritable(); err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := notify.GenerateAlertmanagerConfig(cfg, notificationsOutputDir); err != nil {
+		return fmt.Errorf("generate alertmanager config: %w", err)
+	}
+
+	fmt.Printf("%s Alertmanager config written to %s\n", green("✓"), notificationsOutputDir)
+	fmt.Println("  Reload Alertmanager to apply: systemctl reload alertmanager")
+	return nil
+}
+
+// notificationRequest is a preview or test request of the template API
+type notificationRequest struct {
+	Channel  string `json:"channel"`
+	Template string `json:"template"`
+	Resolved bool   `json:"resolved"`
+}
+
+func runNotificationsServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.HandleFunc("/notifications/alertmanager", relayHandler)
+	v1.HandleFunc("/notifications/preview", notificationTemplateHandler)
+	v1.HandleFunc("/notifications/test", notificationTemplateHandler)
+
+	fmt.Printf("%s Serving notification relay on http://%s/api/v1/notifications\n", green("✓"), notificationsListen)
+	return http.ListenAndServe(notificationsListen, mux)
+}
+
+// relayHandler receives the notifications Alertmanager posts to the relay
+// and sends them to the Slack and webhook channels
+func relayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if token := cfg.Notifications.RelayToken; token != "" {
+		given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(given)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var data notify.Data
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := notify.Relay(cfg, &data); err != nil {
+		// Alertmanager retries on 5xx
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notificationTemplateHandler serves POST /notifications/preview and
+// /notifications/test
+func notificationTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkAdminToken(w, r, cfg) {
+		return
+	}
+
+	var req notificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !notify.IsChannel(req.Channel) {
+		http.Error(w, fmt.Sprintf("unknown channel: %s (available: %s)",
+			req.Channel, strings.Join(notify.Channels, ", ")), http.StatusBadRequest)
+		return
+	}
+	message, data, err := notify.Preview(cfg, req.Channel, req.Template, req.Resolved)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/test") {
+		if err := notify.Send(cfg, req.Channel, message, data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeSilenceJSON(w, http.StatusOK, map[string]string{"channel": req.Channel, "status": "sent"})
+		return
+	}
+	writeSilenceJSON(w, http.StatusOK, map[string]string{"channel": req.Channel, "message": message})
+}
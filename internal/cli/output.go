@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+)
+
+// Exit codes of aami
+const (
+	ExitOK      = 0 // the command succeeded
+	ExitError   = 1 // the command failed
+	ExitPartial = 2 // the command failed for some of its targets
+)
+
+// plainOutput is set by --plain: no color, English messages, tab-separated
+// tables and ASCII in place of symbols and box drawing
+var plainOutput bool
+
+// noColor is set by --no-color
+var noColor bool
+
+// stopPlainOutput flushes and restores stdout after a command run in plain
+// mode
+var stopPlainOutput func()
+
+// partialError is returned by commands that succeeded for some targets
+// and failed for others
+type partialError struct {
+	error
+}
+
+// partialf returns a partial failure, making aami exit with ExitPartial
+func partialf(format string, args ...interface{}) error {
+	return partialError{fmt.Errorf(format, args...)}
+}
+
+// countError reports the failed targets of a command: none is success, all
+// of them an error, and some a partial failure.
+func countError(failed, total int, targets string) error {
+	switch {
+	case failed == 0:
+		return nil
+	case failed == total:
+		return fmt.Errorf("all %d %s failed", total, targets)
+	default:
+		return partialf("%d of %d %s failed", failed, total, targets)
+	}
+}
+
+// ExitCode returns the exit status for an error returned by Execute
+func ExitCode(err error) int {
+	var partial partialError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &partial):
+		return ExitPartial
+	default:
+		return ExitError
+	}
+}
+
+// startPlainOutput switches the process to plain output. The error of the
+// command is printed by Execute once stdout is flushed, so it comes last.
+func startPlainOutput(cmd *cobra.Command) error {
+	color.NoColor = true
+	i18n.SetLang(i18n.EN)
+	cmd.SilenceErrors = true
+
+	restore, err := filterFile(&os.Stdout)
+	if err != nil {
+		return err
+	}
+	color.Output = os.Stdout
+	stopPlainOutput = restore
+	return nil
+}
+
+// filterFile replaces *f with a pipe whose output is written to the
+// original file through plainText. The returned function closes the pipe,
+// waits for the output to be written and restores *f.
+func filterFile(f **os.File) (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("create pipe: %w", err)
+	}
+	original := *f
+	*f = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyPlain(original, r)
+	}()
+
+	return func() {
+		w.Close()
+		<-done
+		*f = original
+	}, nil
+}
+
+// copyPlain copies r to w through plainText. Output is written as it
+// arrives, so prompts without a trailing newline are shown; a character
+// split across reads is held back until it is complete.
+func copyPlain(w io.Writer, r io.Reader) {
+	buf := make([]byte, 4096)
+	var pending []byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data := append(pending, buf[:n]...)
+			end := len(data)
+			for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+				if utf8.RuneStart(data[i]) {
+					if !utf8.FullRune(data[i:]) {
+						end = i
+					}
+					break
+				}
+			}
+			io.WriteString(w, plainText(string(data[:end])))
+			pending = append([]byte(nil), data[end:]...)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(pending) > 0 {
+		w.Write(pending)
+	}
+}
+
+// plainSymbols are the symbols used in output and their ASCII replacements
+var plainSymbols = strings.NewReplacer(
+	"✓", "OK",
+	"✗", "FAIL",
+	"⚠", "WARN",
+	"•", "-",
+	"●", "*",
+	"○", "o",
+	"→", "->",
+)
+
+// plainText replaces symbols and box-drawing characters with ASCII and
+// drops emoji
+func plainText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune("─━═╌╍┄┅┈┉", r):
+			return '-'
+		case strings.ContainsRune("│┃║╎╏┆┇┊┋", r):
+			return '|'
+		case r >= 0x2500 && r <= 0x257F: // corners and junctions
+			return '+'
+		case r == 0xFE0F, r >= 0x1F000 && r <= 0x1FAFF: // emoji
+			return -1
+		}
+		return r
+	}, plainSymbols.Replace(s))
+}
+
+// table is a tablewriter table that renders as tab-separated lines in plain
+// mode: an upper-case header line, then one line per row.
+type table struct {
+	*tablewriter.Table
+	header []string
+	rows   [][]string
+}
+
+// newTable creates a table writing to stdout
+func newTable() *table {
+	return &table{Table: tablewriter.NewWriter(os.Stdout)}
+}
+
+// SetHeader sets the column names
+func (t *table) SetHeader(keys []string) {
+	t.header = keys
+	t.Table.SetHeader(keys)
+}
+
+// Append adds a row
+func (t *table) Append(row []string) {
+	t.rows = append(t.rows, row)
+	t.Table.Append(row)
+}
+
+// Render writes the table
+func (t *table) Render() {
+	if !plainOutput {
+		t.Table.Render()
+		return
+	}
+
+	cell := strings.NewReplacer("\t", " ", "\n", " ")
+	writeRow := func(row []string) {
+		cells := make([]string, len(row))
+		for i, c := range row {
+			cells[i] = cell.Replace(c)
+		}
+		fmt.Fprintln(os.Stdout, strings.Join(cells, "\t"))
+	}
+	if len(t.header) > 0 {
+		header := make([]string, len(t.header))
+		for i, h := range t.header {
+			header[i] = strings.ToUpper(h)
+		}
+		writeRow(header)
+	}
+	for _, row := range t.rows {
+		writeRow(row)
+	}
+}
+
+// addOutputFlag adds --output (-o) to a command, accepting the common
+// formats and the command's own
+func addOutputFlag(cmd *cobra.Command, p *string, extra ...output.Format) {
+	cmd.Flags().StringVarP(p, "output", "o", string(output.Table), output.Usage(extra...))
+
+	var formats []string
+	for _, f := range append(append([]output.Format(nil), output.Formats...), extra...) {
+		formats = append(formats, string(f))
+	}
+	cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(formats, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// writeOutput writes a result to stdout as JSON or YAML
+func writeOutput(f output.Format, v interface{}) error {
+	return output.Write(os.Stdout, f, v)
+}
+
+func writeJSON(v interface{}) error {
+	return writeOutput(output.JSON, v)
+}
@@ -0,0 +1,511 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/probe"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	probesType            string
+	probesGroup           string
+	probesTargets         []string
+	probesPort            int
+	probesScheme          string
+	probesPath            string
+	probesStatusCodes     []int
+	probesInterval        string
+	probesTimeout         string
+	probesOutput          string
+	probesModulesFile     string
+	probesScrapeFile      string
+	probesBlackbox        string
+	probesPrint           bool
+	probesConfigServerURL string
+)
+
+// Files probes generate writes by default
+const (
+	defaultProbeModulesFile = "/etc/blackbox_exporter/aami.yml"
+	defaultProbeScrapeFile  = "/etc/prometheus/scrape/aami-probes.yaml"
+)
+
+var probesCmd = &cobra.Command{
+	Use:   "probes",
+	Short: "Manage blackbox probes of targets",
+	Long: `Manage blackbox probes: ICMP, TCP or HTTP checks of the targets of a
+config server group, or of some targets, run by blackbox_exporter.
+
+'aami probes generate' writes a blackbox_exporter module per probe and a
+Prometheus scrape job per probe, for scrape_config_files; run it again
+after changing probes or group members. Targets given by name are probed at
+the IP of the configured node of that name, else at the name itself. The
+probe_success series carry probe and node labels, and 'aami probes status'
+and 'aami targets get' show them.
+
+Examples:
+  aami probes create ping --type icmp --group gpu-servers
+  aami probes create ssh --type tcp --port 22 --group gpu-servers
+  aami probes create jupyter --type http --port 8888 --path /api \
+    --status 200 --target gpu-01 --target gpu-02
+  aami probes generate --blackbox-address mgmt-01:9115
+  aami probes status`,
+}
+
+var probesCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a probe",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runProbesCreate,
+}
+
+var probesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List probes",
+	Args:  cobra.NoArgs,
+	RunE:  runProbesList,
+}
+
+var probesDeleteCmd = &cobra.Command{
+	Use:               "delete <name>",
+	Short:             "Delete a probe",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeProbes),
+	RunE:              runProbesDelete,
+}
+
+var probesGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write the blackbox_exporter modules and scrape jobs of the probes",
+	Long: `Write the blackbox_exporter configuration, holding a module per probe, and
+the Prometheus scrape jobs running the probes through blackbox_exporter.
+
+Point blackbox_exporter at the modules file (--config.file), include the
+scrape file with scrape_config_files in prometheus.yml, then reload both.
+Groups are resolved to their active targets on the config server.
+
+Examples:
+  aami probes generate
+  aami probes generate --blackbox-address mgmt-01:9115
+  aami probes generate --print`,
+	Args: cobra.NoArgs,
+	RunE: runProbesGenerate,
+}
+
+var probesStatusCmd = &cobra.Command{
+	Use:               "status [<name>]",
+	Short:             "Show the results of probes from Prometheus",
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: firstArg(completeProbes),
+	RunE:              runProbesStatus,
+}
+
+func init() {
+	probesCreateCmd.Flags().StringVar(&probesType, "type", "", "Probe type (icmp, tcp, http)")
+	probesCreateCmd.Flags().StringVar(&probesGroup, "group", "", "Config server group (ID or name) to probe")
+	probesCreateCmd.Flags().StringArrayVar(&probesTargets, "target", nil, "Target (node name) to probe (repeatable)")
+	probesCreateCmd.Flags().IntVar(&probesPort, "port", 0, "Port to probe (tcp, http)")
+	probesCreateCmd.Flags().StringVar(&probesScheme, "scheme", "", "http or https (default: http)")
+	probesCreateCmd.Flags().StringVar(&probesPath, "path", "", "HTTP path (default: /)")
+	probesCreateCmd.Flags().IntSliceVar(&probesStatusCodes, "status", nil, "Accepted HTTP status codes (default: 2xx)")
+	probesCreateCmd.Flags().StringVar(&probesInterval, "interval", "", "How often to probe (default: Prometheus' scrape interval)")
+	probesCreateCmd.Flags().StringVar(&probesTimeout, "timeout", "", "Probe timeout (default: 5s)")
+	probesCreateCmd.MarkFlagRequired("type")
+	probesCreateCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	probesCreateCmd.RegisterFlagCompletionFunc("target", completeTargets)
+	probesCreateCmd.RegisterFlagCompletionFunc("type", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{probe.TypeICMP, probe.TypeTCP, probe.TypeHTTP}, cobra.ShellCompDirectiveNoFileComp
+	})
+	addOutputFlag(probesListCmd, &probesOutput)
+	addOutputFlag(probesStatusCmd, &probesOutput)
+
+	probesGenerateCmd.Flags().StringVar(&probesModulesFile, "modules-file", defaultProbeModulesFile,
+		"blackbox_exporter configuration to write")
+	probesGenerateCmd.Flags().StringVar(&probesScrapeFile, "scrape-file", defaultProbeScrapeFile,
+		"Prometheus scrape config file to write")
+	probesGenerateCmd.Flags().StringVar(&probesBlackbox, "blackbox-address", probe.DefaultBlackboxAddress,
+		"Address Prometheus reaches blackbox_exporter at")
+	probesGenerateCmd.Flags().BoolVar(&probesPrint, "print", false, "Print the files instead of writing them")
+	probesGenerateCmd.Flags().StringVar(&probesConfigServerURL, "config-server-url", "",
+		"Config server address for groups (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+
+	probesCmd.AddCommand(probesCreateCmd)
+	probesCmd.AddCommand(probesListCmd)
+	probesCmd.AddCommand(probesDeleteCmd)
+	probesCmd.AddCommand(probesGenerateCmd)
+	probesCmd.AddCommand(probesStatusCmd)
+	rootCmd.AddCommand(probesCmd)
+}
+
+func newProbeStore() *probe.Store {
+	return probe.NewStore(probe.DefaultStorePath)
+}
+
+func runProbesCreate(cmd *cobra.Command, args []string) error {
+	p, err := newProbeStore().Create(probe.Probe{
+		Name:        args[0],
+		Type:        probesType,
+		Group:       probesGroup,
+		Targets:     probesTargets,
+		Port:        probesPort,
+		Scheme:      probesScheme,
+		Path:        probesPath,
+		StatusCodes: probesStatusCodes,
+		Interval:    probesInterval,
+		Timeout:     probesTimeout,
+		CreatedBy:   currentUser(),
+	})
+	if err != nil {
+		return err
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Created probe %s\n", green("✓"), p.Name)
+	fmt.Println("  Run 'aami probes generate' and reload blackbox_exporter and Prometheus to start it")
+	return nil
+}
+
+func runProbesList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(probesOutput)
+	if err != nil {
+		return err
+	}
+	probes, err := newProbeStore().List()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		if probes == nil {
+			probes = []probe.Probe{}
+		}
+		return writeOutput(format, probes)
+	}
+	if len(probes) == 0 {
+		fmt.Println("No probes.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Name"},
+		{Header: "Type"},
+		{Header: "Scope"},
+		{Header: "Checks"},
+		{Header: "Interval"},
+		{Header: "Timeout", Wide: true},
+		{Header: "Created By", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, p := range probes {
+		scope := "group " + p.Group
+		if p.Group == "" {
+			scope = strings.Join(p.Targets, ", ")
+		}
+		table.Append(columns.Row(format,
+			p.Name,
+			p.Type,
+			scope,
+			p.Address("<host>"),
+			defaultString(p.Interval, "default"),
+			defaultString(p.Timeout, "5s"),
+			defaultString(p.CreatedBy, "-"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runProbesDelete(cmd *cobra.Command, args []string) error {
+	if err := newProbeStore().Delete(args[0]); err != nil {
+		return err
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Deleted probe %s\n", green("✓"), args[0])
+	fmt.Println("  Run 'aami probes generate' and reload blackbox_exporter and Prometheus to stop it")
+	return nil
+}
+
+func runProbesGenerate(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	probes, err := newProbeStore().List()
+	if err != nil {
+		return err
+	}
+
+	modules, err := probe.RenderModules(probes)
+	if err != nil {
+		return err
+	}
+	configs, err := probe.ScrapeConfigs(probes, probeResolver(cfg, probesConfigServerURL), probesBlackbox)
+	if err != nil {
+		return err
+	}
+	scrape, err := prometheus.RenderScrapeConfigs(configs)
+	if err != nil {
+		return err
+	}
+
+	if probesPrint {
+		fmt.Printf("# %s\n%s\n# %s\n%s", probesModulesFile, modules, probesScrapeFile, scrape)
+		return nil
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	for _, f := range []struct {
+		path string
+		data []byte
+	}{{probesModulesFile, modules}, {probesScrapeFile, scrape}} {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return fmt.Errorf("create directory: %w", err)
+		}
+		if err := os.WriteFile(f.path, f.data, 0644); err != nil {
+			return fmt.Errorf("write %s: %w", f.path, err)
+		}
+		fmt.Printf("%s Wrote %s\n", green("✓"), f.path)
+	}
+	fmt.Printf("  %d probe(s); reload blackbox_exporter and Prometheus to apply\n", len(probes))
+	return nil
+}
+
+// probeResolver resolves the hosts of probes: the active targets of a
+// group on the config server, or the nodes named by the probe
+func probeResolver(cfg *config.Config, urlFlag string) probe.Resolver {
+	return func(p probe.Probe) ([]probe.Host, error) {
+		var hosts []probe.Host
+		if p.Group == "" {
+			for _, name := range p.Targets {
+				address := name
+				if node, ok := findNode(cfg, name); ok && node.IP != "" {
+					address = node.IP
+				}
+				hosts = append(hosts, probe.Host{Name: name, Address: address})
+			}
+			return hosts, nil
+		}
+
+		client, err := configServerClient(cfg, urlFlag)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+		defer cancel()
+
+		g, err := client.FindGroup(ctx, p.Group)
+		if err != nil {
+			return nil, err
+		}
+		targets, err := client.ListTargetsByGroup(ctx, g.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range targets {
+			if t.Status != configserver.TargetInactive {
+				hosts = append(hosts, probe.Host{Name: t.Hostname, Address: defaultString(t.IPAddress, t.Hostname)})
+			}
+		}
+		return hosts, nil
+	}
+}
+
+// probeResult is the last result of a probe of a host
+type probeResult struct {
+	Probe    string  `json:"probe"`
+	Type     string  `json:"type"`
+	Node     string  `json:"node"`
+	Instance string  `json:"instance,omitempty"`
+	State    string  `json:"state"` // up, down, unknown
+	Duration float64 `json:"duration_seconds,omitempty"`
+}
+
+// Probe states
+const (
+	probeUp      = "up"
+	probeDown    = "down"
+	probeUnknown = "unknown"
+)
+
+// queryProbeResults reads the last results of probes from Prometheus,
+// keyed by probe and node
+func queryProbeResults(promURL string) (map[[2]string]probeResult, error) {
+	client := health.NewPrometheusClient(promURL)
+	success, err := client.Query(`probe_success{probe!=""}`)
+	if err != nil {
+		return nil, err
+	}
+	results := map[[2]string]probeResult{}
+	for _, r := range success.Data.Result {
+		state := probeDown
+		if sampleValue(r.Value) == 1 {
+			state = probeUp
+		}
+		key := [2]string{r.Metric["probe"], r.Metric["node"]}
+		results[key] = probeResult{
+			Probe:    r.Metric["probe"],
+			Type:     r.Metric["probe_type"],
+			Node:     r.Metric["node"],
+			Instance: r.Metric["instance"],
+			State:    state,
+		}
+	}
+
+	// Durations are a nicety; a failed query leaves them out
+	if durations, err := client.Query(`probe_duration_seconds{probe!=""}`); err == nil {
+		for _, r := range durations.Data.Result {
+			key := [2]string{r.Metric["probe"], r.Metric["node"]}
+			if res, ok := results[key]; ok {
+				res.Duration = sampleValue(r.Value)
+				results[key] = res
+			}
+		}
+	}
+	return results, nil
+}
+
+// sampleValue returns the value of an instant query sample
+func sampleValue(value []interface{}) float64 {
+	if len(value) < 2 {
+		return 0
+	}
+	s, _ := value[1].(string)
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// targetProbes returns the results of the probes of a target; probes
+// Prometheus has no result for yet are unknown
+func targetProbes(probes []probe.Probe, t *configserver.Target, results map[[2]string]probeResult) []probeResult {
+	groups := make([]string, 0, 2*len(t.Groups))
+	for _, g := range t.Groups {
+		groups = append(groups, g.ID, g.Name)
+	}
+	var out []probeResult
+	for _, p := range probes {
+		if !p.AppliesTo(t.Hostname, groups) {
+			continue
+		}
+		r, ok := results[[2]string{p.Name, t.Hostname}]
+		if !ok {
+			r = probeResult{Probe: p.Name, Node: t.Hostname, State: probeUnknown}
+		}
+		r.Type = p.Type
+		out = append(out, r)
+	}
+	return out
+}
+
+func runProbesStatus(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(probesOutput)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		if _, ok, err := newProbeStore().Get(args[0]); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("probe not found: %s", args[0])
+		}
+	}
+	results, err := queryProbeResults(prometheusURL(cfg))
+	if err != nil {
+		return err
+	}
+
+	list := []probeResult{}
+	for _, r := range results {
+		if len(args) == 0 || r.Probe == args[0] {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Probe != list[j].Probe {
+			return list[i].Probe < list[j].Probe
+		}
+		return list[i].Node < list[j].Node
+	})
+	if format.Structured() {
+		return writeOutput(format, list)
+	}
+	if len(list) == 0 {
+		fmt.Println("No probe results in Prometheus yet.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Probe"},
+		{Header: "Node"},
+		{Header: "State"},
+		{Header: "Duration"},
+		{Header: "Instance", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	down := 0
+	for _, r := range list {
+		if r.State == probeDown {
+			down++
+		}
+		table.Append(columns.Row(format,
+			r.Probe,
+			defaultString(r.Node, "-"),
+			colorProbeState(r.State),
+			formatProbeDuration(r.Duration),
+			r.Instance,
+		))
+	}
+	table.Render()
+	return countError(down, len(list), "probes")
+}
+
+// formatProbeDuration formats how long a probe took; failed probes may
+// report none
+func formatProbeDuration(seconds float64) string {
+	if seconds == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.3fs", seconds)
+}
+
+// colorProbeState colors a probe state
+func colorProbeState(state string) string {
+	switch state {
+	case probeUp:
+		return color.GreenString(state)
+	case probeDown:
+		return color.RedString(state)
+	default:
+		return color.YellowString(state)
+	}
+}
+
+func completeProbes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	candidates := make(map[string]string)
+	if probes, err := newProbeStore().List(); err == nil {
+		for _, p := range probes {
+			candidates[p.Name] = p.Type
+		}
+	}
+	return completions(candidates, toComplete)
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/queryproxy"
+)
+
+var (
+	queryProxyListen        string
+	queryProxyPrometheusURL string
+)
+
+var queryProxyCmd = &cobra.Command{
+	Use:   "query-proxy",
+	Short: "Serve the Prometheus query API with per-token limits",
+	Long: `Serve the Prometheus query API in front of a shared Prometheus, so
+dashboards and scripts cannot overload it.
+
+Clients authenticate with a bearer token from query_proxy.tokens, each with
+its own limits:
+  max_range    Longest query_range window and [range] selector
+  min_step     Finest query_range resolution
+  max_series   Series in a result
+  max_samples  Samples loaded, from Prometheus query stats
+
+Queries matching a query_proxy.deny pattern are rejected. Identical queries
+are answered from a cache for query_proxy.cache_ttl (default 30s).
+
+max_series and max_samples are checked once Prometheus has answered; they
+keep oversized results away from clients, while range, step and the deny
+list stop expensive queries before they run.`,
+}
+
+var queryProxyServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the query proxy",
+	Long: `Start the query proxy.
+
+Point Grafana's Prometheus data source (or any Prometheus API client) at
+http://<host><listen> and send the token in an "Authorization: Bearer"
+header.
+
+Examples:
+  aami query-proxy serve --listen :8096
+  aami query-proxy serve --prometheus-url http://prom-shard-1:9090`,
+	Args: cobra.NoArgs,
+	RunE: runQueryProxyServe,
+}
+
+func init() {
+	queryProxyServeCmd.Flags().StringVar(&queryProxyListen, "listen", ":8096",
+		"Address to listen on")
+	queryProxyServeCmd.Flags().StringVar(&queryProxyPrometheusURL, "prometheus-url", "",
+		"Prometheus URL (default: the context's Prometheus, else prometheus.port on localhost)")
+
+	queryProxyCmd.AddCommand(queryProxyServeCmd)
+	rootCmd.AddCommand(queryProxyCmd)
+}
+
+func runQueryProxyServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(cfg.QueryProxy.Tokens) == 0 {
+		return fmt.Errorf("no query_proxy.tokens configured")
+	}
+
+	promURL := defaultString(queryProxyPrometheusURL, prometheusURL(cfg))
+	mux := newAPIMux()
+	mux.Version("v1").Handle("/", queryproxy.New(readConfig, promURL))
+
+	fmt.Printf("%s Serving Prometheus queries on http://%s/api/v1/ (upstream %s)\n",
+		green("✓"), queryProxyListen, promURL)
+	return http.ListenAndServe(queryProxyListen, mux)
+}
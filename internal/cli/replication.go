@@ -0,0 +1,368 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/api"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+	"github.com/fregataa/aami/internal/replication"
+)
+
+var (
+	replicationListen   string
+	replicationPrimary  string
+	replicationToken    string
+	replicationInterval time.Duration
+	replicationOnce     bool
+	replicationConflict []string
+	replicationTake     string
+)
+
+var replicationCmd = &cobra.Command{
+	Use:   "replication",
+	Short: "Replicate AAMI state to other regions",
+	Long: `Replicate AAMI state from a primary control node to read-only replicas.
+
+The primary serves a snapshot of its configuration, rules, targets,
+incidents and drift manifest. A replica polls the primary and imports a new
+snapshot whenever it changes. While following, the replica rejects local
+changes; promote it to make it writable, e.g. when the primary region fails.
+
+Examples:
+  # On the primary
+  aami replication serve --listen :8094
+
+  # On the replica
+  aami replication follow --primary http://aami-eu.example.com:8094
+  aami replication status
+  aami replication conflicts
+  aami replication promote`,
+}
+
+var replicationServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve snapshots to replicas",
+	Long: `Serve the current revision and snapshot of the replicated paths.
+
+Replicas authenticate with replication.token from the config.`,
+	Args: cobra.NoArgs,
+	RunE: runReplicationServe,
+}
+
+var replicationFollowCmd = &cobra.Command{
+	Use:   "follow",
+	Short: "Continuously import state from the primary",
+	Long: `Poll the primary and import its snapshot whenever the revision changes.
+
+Files under the replicated paths are updated to the primary's version, and
+files missing on the primary are removed. Run this as a service on the replica.
+
+Files changed on the replica since the last import are conflicts. Each entity
+type (config, rules, notifications, targets, incidents, manifest, other) is
+handled by its strategy from replication.conflicts or --conflict:
+
+  theirs   take the primary's version (default)
+  ours     keep the local version until the primary changes the file again
+  newest   keep whichever version was modified last
+  manual   keep the local version and queue the conflict for review
+
+The token is read from --token, AAMI_REPLICATION_TOKEN or replication.token
+in the config, in that order.
+
+Examples:
+  aami replication follow --primary http://aami-eu.example.com:8094
+  aami replication follow --primary http://aami-eu.example.com:8094 \
+    --conflict config=manual --conflict incidents=newest`,
+	Args: cobra.NoArgs,
+	RunE: runReplicationFollow,
+}
+
+var replicationStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show replication role and lag",
+	Args:  cobra.NoArgs,
+	RunE:  runReplicationStatus,
+}
+
+var replicationConflictsCmd = &cobra.Command{
+	Use:   "conflicts",
+	Short: "List conflicts queued for manual review",
+	Long: `List conflicts queued by the manual strategy. Until a conflict is
+resolved, the replica keeps its local version of the file.
+
+Examples:
+  aami replication conflicts
+  aami replication conflicts show 3f2a9c1e07bd
+  aami replication conflicts resolve 3f2a9c1e07bd --take theirs`,
+	Args: cobra.NoArgs,
+	RunE: runReplicationConflicts,
+}
+
+var replicationConflictsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show the difference between the local and primary version",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runReplicationConflictsShow,
+}
+
+var replicationConflictsResolveCmd = &cobra.Command{
+	Use:   "resolve <id>",
+	Short: "Resolve a conflict by taking one version",
+	Long: `Resolve a queued conflict.
+
+  --take theirs   write the primary's version (or delete the file if the
+                  primary deleted it)
+  --take ours     keep the local version until the primary changes the file`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReplicationConflictsResolve,
+}
+
+var replicationPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote this replica to a writable primary",
+	Long: `Stop treating this node as a replica, so local changes are accepted again.
+
+Stop the follow service first, otherwise the next sync may overwrite local
+changes with the old primary's state.`,
+	Args: cobra.NoArgs,
+	RunE: runReplicationPromote,
+}
+
+func init() {
+	replicationServeCmd.Flags().StringVar(&replicationListen, "listen", ":8094",
+		"Address to serve snapshots on")
+
+	replicationFollowCmd.Flags().StringVar(&replicationPrimary, "primary", "",
+		"Primary URL (e.g. http://aami-eu.example.com:8094)")
+	replicationFollowCmd.Flags().StringVar(&replicationToken, "token", "",
+		"Replication token (default: $AAMI_REPLICATION_TOKEN or replication.token)")
+	replicationFollowCmd.Flags().DurationVar(&replicationInterval, "interval", 30*time.Second,
+		"Poll interval")
+	replicationFollowCmd.Flags().BoolVar(&replicationOnce, "once", false,
+		"Sync once and exit")
+	replicationFollowCmd.Flags().StringSliceVar(&replicationConflict, "conflict", nil,
+		"Conflict strategy as entity=strategy, overrides replication.conflicts (repeatable)")
+	replicationFollowCmd.MarkFlagRequired("primary")
+
+	replicationConflictsResolveCmd.Flags().StringVar(&replicationTake, "take", "",
+		"Version to keep: ours or theirs")
+	replicationConflictsResolveCmd.MarkFlagRequired("take")
+
+	replicationConflictsCmd.AddCommand(replicationConflictsShowCmd)
+	replicationConflictsCmd.AddCommand(replicationConflictsResolveCmd)
+
+	replicationCmd.AddCommand(replicationServeCmd)
+	replicationCmd.AddCommand(replicationFollowCmd)
+	replicationCmd.AddCommand(replicationStatusCmd)
+	replicationCmd.AddCommand(replicationConflictsCmd)
+	replicationCmd.AddCommand(replicationPromoteCmd)
+	rootCmd.AddCommand(replicationCmd)
+}
+
+func runReplicationServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Replication.Token == "" {
+		return fmt.Errorf("replication.token is not set in the config")
+	}
+
+	mux := newAPIMux()
+	mux.Version("v1").HandleWithAlias("/replication/",
+		replication.NewHandler(cfg.Replication.Token, replication.DefaultPaths), api.Unversioned)
+
+	fmt.Printf("%s Serving replication snapshots on http://%s/api/v1/replication/\n", green("✓"), replicationListen)
+	return http.ListenAndServe(replicationListen, mux)
+}
+
+func runReplicationFollow(cmd *cobra.Command, args []string) error {
+	// The config is optional on a fresh replica; it arrives with the first import
+	var cfg *config.Config
+	if c, err := loadConfig(); err == nil {
+		cfg = c
+	}
+
+	token := replicationToken
+	if token == "" {
+		token = os.Getenv("AAMI_REPLICATION_TOKEN")
+	}
+	if token == "" && cfg != nil {
+		token = cfg.Replication.Token
+	}
+	if token == "" {
+		return fmt.Errorf("no replication token: use --token or set AAMI_REPLICATION_TOKEN")
+	}
+
+	raw := map[string]string{}
+	if cfg != nil {
+		for entity, strategy := range cfg.Replication.Conflicts {
+			raw[entity] = strategy
+		}
+	}
+	for _, kv := range replicationConflict {
+		entity, strategy, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --conflict %q: expected entity=strategy", kv)
+		}
+		raw[entity] = strategy
+	}
+	strategies, err := replication.ParseStrategies(raw)
+	if err != nil {
+		return err
+	}
+
+	follower := &replication.Follower{
+		Primary:    replicationPrimary,
+		Token:      token,
+		Paths:      replication.DefaultPaths,
+		StatePath:  replication.DefaultStatePath,
+		Strategies: strategies,
+		Queue:      replication.NewConflictQueue(replication.DefaultConflictDir),
+		Client:     &http.Client{Timeout: 60 * time.Second},
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("%s "+format+"\n", append([]interface{}{time.Now().Format(time.RFC3339)}, args...)...)
+		},
+	}
+
+	if replicationOnce {
+		return follower.Sync()
+	}
+
+	fmt.Printf("Following %s every %s\n", replicationPrimary, replicationInterval)
+	for {
+		if err := follower.Sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s sync failed: %v\n", time.Now().Format(time.RFC3339), err)
+		}
+		time.Sleep(replicationInterval)
+	}
+}
+
+func runReplicationStatus(cmd *cobra.Command, args []string) error {
+	st, err := replication.LoadState(replication.DefaultStatePath)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		rev, err := replication.Revision(replication.DefaultPaths)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Role:      primary")
+		fmt.Printf("Revision:  %.12s\n", rev)
+		return nil
+	}
+
+	red := color.New(color.FgRed).SprintFunc()
+
+	fmt.Println("Role:      replica (read-only)")
+	fmt.Printf("Primary:   %s\n", st.Primary)
+	fmt.Printf("Revision:  %.12s\n", st.Revision)
+	if !st.AppliedAt.IsZero() {
+		fmt.Printf("Applied:   %s (%d written, %d removed, %d kept)\n",
+			st.AppliedAt.Local().Format(time.RFC3339), st.FilesWritten, st.FilesRemoved, st.FilesKept)
+	}
+	if st.Conflicts > 0 {
+		fmt.Printf("Conflicts: %d awaiting review (aami replication conflicts)\n", st.Conflicts)
+	}
+	if st.LastSyncAt.IsZero() {
+		fmt.Println("Last sync: never")
+	} else {
+		fmt.Printf("Last sync: %s (lag %s)\n",
+			st.LastSyncAt.Local().Format(time.RFC3339), st.Lag(time.Now()).Round(time.Second))
+	}
+	if st.LastError != "" {
+		fmt.Printf("Error:     %s\n", red(st.LastError))
+	}
+	return nil
+}
+
+func runReplicationConflicts(cmd *cobra.Command, args []string) error {
+	conflicts, err := replication.NewConflictQueue(replication.DefaultConflictDir).List()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("No conflicts awaiting review.")
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"ID", "Entity", "Path", "Primary", "Detected"})
+	table.SetBorder(false)
+	for _, c := range conflicts {
+		theirs := "changed"
+		if c.TheirsDeleted() {
+			theirs = "deleted"
+		}
+		table.Append([]string{
+			c.ID, c.Entity, c.Path, theirs,
+			c.DetectedAt.Local().Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func runReplicationConflictsShow(cmd *cobra.Command, args []string) error {
+	c, theirs, err := replication.NewConflictQueue(replication.DefaultConflictDir).Get(args[0])
+	if err != nil {
+		return err
+	}
+	ours, err := os.ReadFile(c.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", c.Path, err)
+	}
+
+	fmt.Printf("Conflict %s: %s (%s)\n", c.ID, c.Path, c.Entity)
+	fmt.Printf("Detected: %s\n\n", c.DetectedAt.Local().Format(time.RFC3339))
+	if c.TheirsDeleted() {
+		fmt.Println("The primary deleted this file; it was changed here.")
+		return nil
+	}
+	fmt.Println("--- ours (local)")
+	fmt.Println("+++ theirs (primary)")
+	fmt.Println(drift.Diff(string(ours), string(theirs)))
+	return nil
+}
+
+func runReplicationConflictsResolve(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	queue := replication.NewConflictQueue(replication.DefaultConflictDir)
+	c, err := replication.Resolve(queue, replication.DefaultStatePath, args[0], replication.Strategy(replicationTake))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s Resolved %s on %s (took %s)\n", green("✓"), c.ID, c.Path, replicationTake)
+	return nil
+}
+
+func runReplicationPromote(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	st, err := replication.LoadState(replication.DefaultStatePath)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		return fmt.Errorf("this node is not a replica")
+	}
+	if err := replication.Promote(replication.DefaultStatePath); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s Promoted to primary (was following %s)\n", green("✓"), st.Primary)
+	fmt.Println("\nStop the follow service and point clients and replicas at this node.")
+	return nil
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/incident"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+var (
+	reportSince       time.Duration
+	reportGroupBy     string
+	reportOutput      string
+	reportMetricsFile string
+	reportRulesDryRun bool
+	reportRulesNS     string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate operational reports",
+}
+
+var reportReliabilityCmd = &cobra.Command{
+	Use:   "reliability",
+	Short: "Show MTTA and MTTR from incident history",
+	Long: `Show mean time to acknowledge (MTTA) and mean time to resolve (MTTR)
+for incidents, grouped by alert rule, node, severity, or a node label.
+
+With --metrics-file the stats are also written in Prometheus text format,
+so the node exporter textfile collector can keep long-term trends.
+
+Examples:
+  aami report reliability                          # Last 30 days, by rule
+  aami report reliability --by label:rack --since 2160h
+  aami report reliability --metrics-file /var/lib/node_exporter/textfile_collector/aami_reliability.prom`,
+	Args: cobra.NoArgs,
+	RunE: runReportReliability,
+}
+
+var reportRecordingRulesCmd = &cobra.Command{
+	Use:   "recording-rules",
+	Short: "Install hourly recording rules for long-term capacity dashboards",
+	Long: `Install recording rules that aggregate GPU utilization, power draw,
+GPU count and firing alerts per hour, so capacity dashboards spanning
+13 months query a few hourly series instead of raw samples.
+
+Series are recorded for the cluster, each node, each node label key in the
+config (e.g. rack:gpu_utilization:avg1h), and alerts by rule and severity,
+matching the groupings of 'aami report'. Rerun after adding label keys.
+
+Keep prometheus.retention at least as long as the dashboards look back
+(e.g. 400d), and query the series with a step of 1h or more.
+
+Examples:
+  aami report recording-rules
+  aami report recording-rules --dry-run
+  aami report recording-rules --namespace capacity`,
+	Args: cobra.NoArgs,
+	RunE: runReportRecordingRules,
+}
+
+func init() {
+	reportReliabilityCmd.Flags().DurationVar(&reportSince, "since", 30*24*time.Hour,
+		"Include incidents opened within this duration")
+	reportReliabilityCmd.Flags().StringVar(&reportGroupBy, "by", "rule",
+		"Group by: rule, node, severity, label:<key>")
+	addOutputFlag(reportReliabilityCmd, &reportOutput)
+	reportReliabilityCmd.Flags().StringVar(&reportMetricsFile, "metrics-file", "",
+		"Also write Prometheus metrics to this file")
+
+	reportRecordingRulesCmd.Flags().BoolVar(&reportRulesDryRun, "dry-run", false,
+		"Print the rules without installing them")
+	reportRecordingRulesCmd.Flags().StringVar(&reportRulesNS, "namespace", "",
+		"Write the rules into this namespace's directory (default: the context's namespace)")
+
+	reportCmd.AddCommand(reportReliabilityCmd)
+	reportCmd.AddCommand(reportRecordingRulesCmd)
+	rootCmd.AddCommand(reportCmd)
+}
+
+func runReportReliability(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(reportOutput)
+	if err != nil {
+		return err
+	}
+	groupBy, err := reliabilityGroupFunc(reportGroupBy)
+	if err != nil {
+		return err
+	}
+
+	incidents, err := incident.NewStore(incident.DefaultDir).List()
+	if err != nil {
+		return err
+	}
+	stats := incident.Reliability(incidents, time.Now().Add(-reportSince), groupBy)
+
+	if reportMetricsFile != "" {
+		if err := writeReliabilityMetrics(reportMetricsFile, stats); err != nil {
+			return err
+		}
+	}
+
+	if format.Structured() {
+		return writeOutput(format, stats)
+	}
+
+	if len(stats) == 0 {
+		fmt.Println("No incidents in the report window.")
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Group (" + reportGroupBy + ")", "Incidents", "Acked", "Resolved", "MTTA", "MTTR"})
+	table.SetBorder(true)
+	for _, s := range stats {
+		table.Append([]string{
+			s.Group,
+			fmt.Sprintf("%d", s.Incidents),
+			fmt.Sprintf("%d", s.Acknowledged),
+			fmt.Sprintf("%d", s.Resolved),
+			formatMeanDuration(s.MTTA, s.Acknowledged),
+			formatMeanDuration(s.MTTR, s.Resolved),
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// reliabilityGroupFunc maps a --by value to an incident grouping
+func reliabilityGroupFunc(by string) (incident.GroupFunc, error) {
+	switch by {
+	case "rule":
+		return incident.ByRule, nil
+	case "node":
+		return incident.ByNode, nil
+	case "severity":
+		return incident.BySeverity, nil
+	}
+
+	key, ok := strings.CutPrefix(by, "label:")
+	if !ok || key == "" {
+		return nil, fmt.Errorf("invalid grouping: %s (rule, node, severity, label:<key>)", by)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]map[string]string, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		labels[node.Name] = node.Labels
+	}
+	return incident.ByNodeLabel(key, labels), nil
+}
+
+func writeReliabilityMetrics(path string, stats []incident.Stats) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create metrics file: %w", err)
+	}
+	if err := incident.WriteMetrics(f, reportGroupBy, stats); err != nil {
+		f.Close()
+		return fmt.Errorf("write metrics: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+	// Rename so the textfile collector never reads a partial file
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s Metrics written to %s\n", green("✓"), path)
+	return nil
+}
+
+func formatMeanDuration(d time.Duration, n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
+}
+
+func runReportRecordingRules(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	groups := prometheus.CapacityRules(cfg)
+	content, err := prometheus.RenderRecordingRules(groups)
+	if err != nil {
+		return err
+	}
+	if reportRulesDryRun {
+		fmt.Print(string(content))
+		return nil
+	}
+
+	if err := ensureWritable(); err != nil {
+		return err
+	}
+	ns := config.RuleNamespace{}
+	if namespace := namespaceOrDefault(reportRulesNS); namespace != "" {
+		ns = prometheus.FindRuleNamespace(cfg, namespace)
+	}
+	path, err := prometheus.WriteRuleFile(ns, prometheus.CapacityRulesFile, content)
+	if err != nil {
+		return err
+	}
+	uploaded, err := publishRuleFile(cfg, path, content)
+	if err != nil {
+		return err
+	}
+
+	rules := 0
+	for _, g := range groups {
+		rules += len(g.Rules)
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Installed %d recording rules in %d groups\n", green("✓"), rules, len(groups))
+	fmt.Printf("  Rules file: %s\n", path)
+	if uploaded != "" {
+		fmt.Printf("  Uploaded to: %s\n", uploaded)
+	}
+	fmt.Println()
+	fmt.Println("Note: Reload Prometheus to activate the rules:")
+	fmt.Println("  curl -X POST http://localhost:9090/-/reload")
+	return nil
+}
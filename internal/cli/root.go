@@ -1,13 +1,24 @@
 package cli
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/fregataa/aami/internal/api"
 	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/quota"
+	"github.com/fregataa/aami/internal/replication"
+	"github.com/fregataa/aami/internal/webhook"
 )
 
 var cfgFile string
@@ -19,18 +30,61 @@ var rootCmd = &cobra.Command{
 	Long: `AAMI - GPU cluster monitoring tool with Prometheus stack.
 
 Simplifies the installation, configuration, and operation of the Prometheus
-stack through a single CLI, with GPU-specific diagnostic features.`,
+stack through a single CLI, with GPU-specific diagnostic features.
+
+For scripts and cron jobs, --plain (or --porcelain) prints output without
+color, in English, with tables as tab-separated lines and ASCII in place of
+symbols and box drawing. --no-color only turns color off.
+
+Commands printing results take --output (-o): table, wide (a table with
+more columns), json or yaml.
+
+Exit codes:
+  0  success
+  1  error
+  2  partial failure: the command failed for some of its targets
+     (e.g. some nodes in 'aami nodes test')`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Arguments are valid at this point; errors from here on are not
+		// usage errors
+		cmd.SilenceUsage = true
+		if noColor {
+			color.NoColor = true
+		}
+		if !isContextCommand(cmd) {
+			if _, err := activeContext(); err != nil {
+				return err
+			}
+		}
+		if plainOutput {
+			return startPlainOutput(cmd)
+		}
+		return nil
+	},
 }
 
 // Execute runs the root command
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if stopPlainOutput != nil {
+		stopPlainOutput()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", plainText(err.Error()))
+		}
+	}
+	return err
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "",
 		"config file (default: /etc/aami/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&plainOutput, "plain", false,
+		"Stable output for scripts: no color, English, tab-separated tables, ASCII only")
+	rootCmd.PersistentFlags().BoolVar(&plainOutput, "porcelain", false,
+		"Same as --plain")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false,
+		"Print output without color (also set by $NO_COLOR)")
 }
 
 func initConfig() {
@@ -41,27 +95,182 @@ func initConfig() {
 	}
 	viper.AutomaticEnv()
 	_ = viper.ReadInConfig()
+
+	setLanguage()
+}
+
+// setLanguage selects the language of CLI messages: $AAMI_LANG, then the
+// language setting of the config, then English.
+func setLanguage() {
+	name := os.Getenv("AAMI_LANG")
+	if name == "" {
+		path := cfgFile
+		if path == "" {
+			path = config.DefaultConfigPath
+		}
+		if c, err := config.Load(path); err == nil {
+			name = c.Language
+		}
+	}
+	lang, err := i18n.Parse(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v, using English\n", err)
+	}
+	i18n.SetLang(lang)
 }
 
 // loadConfig loads the configuration file
 func loadConfig() (*config.Config, error) {
+	cfg, _, err := loadConfigVersion()
+	return cfg, err
+}
+
+// loadConfigVersion loads the configuration file for a command that changes
+// it, and returns the version it was loaded at to pass to saveConfig
+func loadConfigVersion() (*config.Config, string, error) {
 	path := cfgFile
 	if path == "" {
 		path = config.DefaultConfigPath
 	}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("config file not found: %s\nRun 'aami init' to create one", path)
+	}
+	return config.LoadVersion(path)
+}
+
+// configCache serves the config to the request handlers of serve commands
+var (
+	configCache     *config.Cache
+	configCacheOnce sync.Once
+)
+
+// sharedConfigCache returns the config cache, created on first use
+func sharedConfigCache() *config.Cache {
+	configCacheOnce.Do(func() {
+		path := cfgFile
+		if path == "" {
+			path = config.DefaultConfigPath
+		}
+		configCache = config.NewCache(path)
+	})
+	return configCache
+}
+
+// readConfig returns the config for request handlers that only read it.
+// It is cached for cache.ttl and shared between requests, so it must not
+// be changed or saved; commands that change the config use loadConfig.
+func readConfig() (*config.Config, error) {
+	cfg, err := sharedConfigCache().Load()
+	if os.IsNotExist(err) {
+		path := cfgFile
+		if path == "" {
+			path = config.DefaultConfigPath
+		}
 		return nil, fmt.Errorf("config file not found: %s\nRun 'aami init' to create one", path)
 	}
+	return cfg, err
+}
 
-	return config.Load(path)
+// saveConfig saves the configuration to file. version is the one c was
+// loaded at (see loadConfigVersion): the save is refused if the file was
+// changed since. It returns the version saved.
+func saveConfig(c *config.Config, version string) (string, error) {
+	if err := ensureWritable(); err != nil {
+		return "", err
+	}
+	saved, newVersion, err := saveConfigLocked(c, version)
+	if err != nil {
+		return "", err
+	}
+	// Delivered after the lock is released: a slow or dead webhook must not
+	// hold up other saves. A failed delivery is kept as a dead letter and
+	// does not undo the save.
+	notifyWebhooks(webhook.ConfigEvents(saved, c))
+	return newVersion, nil
 }
 
-// saveConfig saves the configuration to file
-func saveConfig(c *config.Config) error {
+// saveConfigLocked saves c under the config lock, which keeps other
+// commands and request handlers from saving between the version check and
+// the save. It returns the config that was replaced, nil if there was
+// none, and the version saved.
+func saveConfigLocked(c *config.Config, version string) (*config.Config, string, error) {
 	path := cfgFile
 	if path == "" {
 		path = config.DefaultConfigPath
 	}
-	return config.Save(c, path)
+
+	unlock, err := config.Lock(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+
+	// Refuse to overwrite changes saved by another command since loading
+	current, err := config.Version(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if current != version {
+		return nil, "", fmt.Errorf("%s was changed by someone else since it was loaded\nRe-run the command to apply your change on top of the latest version", path)
+	}
+
+	// Nodes may only be added to a namespace within its target quota
+	var saved *config.Config
+	if _, err := os.Stat(path); err == nil {
+		if saved, err = config.Load(path); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := quota.CheckTargets(saved, c); err != nil {
+		return nil, "", err
+	}
+
+	if err := config.Save(c, path); err != nil {
+		return nil, "", err
+	}
+	sharedConfigCache().Invalidate()
+	newVersion, err := config.Version(path)
+	return saved, newVersion, err
+}
+
+// newAPIMux creates the router for the serve commands. Requests to
+// deprecated routes are logged, so clients that still need to migrate can
+// be found before the routes are removed.
+func newAPIMux() *api.Mux {
+	mux := api.NewMux()
+	mux.OnDeprecated = func(r *http.Request, d api.Deprecation) {
+		fmt.Fprintf(os.Stderr, "%s deprecated route %s used by %s (%s), use %s\n",
+			time.Now().Format(time.RFC3339), r.URL.Path, r.RemoteAddr, r.UserAgent(), d.Successor)
+	}
+	mux.OnServed = observeRequest
+	mux.Handle("/metrics", http.HandlerFunc(serveMetrics))
+	return mux
+}
+
+// checkAdminToken rejects admin API requests without admin.token as their
+// bearer token, and all of them if admin.token is not set
+func checkAdminToken(w http.ResponseWriter, r *http.Request, cfg *config.Config) bool {
+	if cfg.Admin.Token == "" {
+		http.Error(w, "admin.token is not configured", http.StatusForbidden)
+		return false
+	}
+	given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(cfg.Admin.Token), []byte(given)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// ensureWritable rejects changes on a read-only replica
+func ensureWritable() error {
+	st, err := replication.LoadState(replication.DefaultStatePath)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		return fmt.Errorf("this node is a read-only replica of %s\nMake changes on the primary, or run 'aami replication promote'", st.Primary)
+	}
+	return nil
 }
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/prometheus"
+	"github.com/fregataa/aami/internal/selector"
+)
+
+// inventoryFormatScrape renders the exporters as prometheus.yml scrape jobs
+const inventoryFormatScrape = "scrape"
+
+// scrapeTargets returns the exporters to scrape, of the targets matching a
+// selector: the exporter records of the config server when one is
+// configured, else the node and dcgm exporters of the configured nodes
+func scrapeTargets(ctx context.Context, cfg *config.Config, sel string) ([]prometheus.ScrapeTarget, error) {
+	s, err := selector.Parse(sel)
+	if err != nil {
+		return nil, err
+	}
+	serverURL, err := configServerURL(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	if serverURL == "" {
+		return prometheus.NodeScrapeTargets(s.Nodes(cfg.Nodes)), nil
+	}
+
+	client, err := configServerClient(cfg, "")
+	if err != nil {
+		return nil, err
+	}
+	targets, err := client.ListTargets(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list targets: %w", err)
+	}
+	exporters, err := client.ListExporters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list exporters: %w", err)
+	}
+
+	byID := make(map[string]configserver.Target, len(targets))
+	for _, t := range targets {
+		if t.Status != configserver.TargetInactive && s.Matches(t.Labels) {
+			byID[t.ID] = t
+		}
+	}
+	var scrape []prometheus.ScrapeTarget
+	for _, e := range exporters {
+		t, ok := byID[e.TargetID]
+		if !ok || !e.Enabled || e.Port == 0 {
+			continue
+		}
+		labels := map[string]string{}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		for k, v := range e.Labels {
+			labels[k] = v
+		}
+		st := prometheus.ScrapeTarget{
+			Job:      exporterJob(e.Type),
+			Address:  net.JoinHostPort(defaultString(t.IPAddress, t.Hostname), strconv.Itoa(e.Port)),
+			Node:     t.Hostname,
+			Path:     e.Path,
+			Scheme:   e.Scheme,
+			Interval: e.ScrapeInterval,
+			Timeout:  e.ScrapeTimeout,
+			Labels:   labels,
+		}
+		if st.Path == "/metrics" {
+			st.Path = ""
+		}
+		if e.TLS != nil {
+			st.TLS = &prometheus.TLSConfig{
+				CAFile:             e.TLS.CAFile,
+				CertFile:           e.TLS.CertFile,
+				KeyFile:            e.TLS.KeyFile,
+				ServerName:         e.TLS.ServerName,
+				InsecureSkipVerify: e.TLS.InsecureSkipVerify,
+			}
+			if st.Scheme == "" {
+				st.Scheme = "https"
+			}
+		}
+		scrape = append(scrape, st)
+	}
+	return scrape, nil
+}
+
+// exporterJob is the job of an exporter type: node_exporter scrapes as
+// job="node", as the file_sd targets do
+func exporterJob(exporterType string) string {
+	return strings.TrimSuffix(exporterType, "_exporter")
+}
+
+// renderScrapeConfigs renders the scrape jobs of the exporters of the
+// targets matching a selector
+func renderScrapeConfigs(ctx context.Context, cfg *config.Config, sel string) ([]byte, error) {
+	targets, err := scrapeTargets(ctx, cfg, sel)
+	if err != nil {
+		return nil, err
+	}
+	return prometheus.RenderScrapeConfigs(prometheus.ScrapeConfigs(targets))
+}
+
+// serveScrapeConfigsSD serves the scrape jobs of the exporters as a
+// prometheus.yml fragment
+func serveScrapeConfigsSD(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sel := r.URL.Query().Get("selector")
+	if _, err := selector.Parse(sel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), configServerTimeout)
+	defer cancel()
+	data, err := renderScrapeConfigs(ctx, cfg, sel)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(data)
+}
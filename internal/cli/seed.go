@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/output"
+)
+
+var (
+	seedConfigServerURL string
+	seedToken           string
+	seedForce           bool
+	seedDryRun          bool
+	seedOutput          string
+)
+
+var seedCmd = &cobra.Command{
+	Use:   "seed [file...]",
+	Short: "Load seed data into the config server",
+	Long: `Ask the config server to load its seed data (groups, check templates,
+script policies and the like) through POST /api/v1/admin/seed, and print
+what was created, updated and skipped.
+
+Files are seed files on the config server host; without any, the server
+loads its default seed directory. Existing resources are skipped unless
+--force is given, which overwrites them with the seed. --dry-run reports
+the changes without making them.
+
+The request is authenticated with --token, else the token of the active
+context, else admin.token.
+
+Examples:
+  aami seed --dry-run
+  aami seed
+  aami seed /etc/aami/seed/checks.yaml --force`,
+	RunE: runSeed,
+}
+
+func init() {
+	seedCmd.Flags().StringVar(&seedConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+	seedCmd.Flags().StringVar(&seedToken, "token", "",
+		"Admin bearer token (default: the context's token, then admin.token)")
+	seedCmd.Flags().BoolVar(&seedForce, "force", false,
+		"Overwrite existing resources with the seed")
+	seedCmd.Flags().BoolVar(&seedDryRun, "dry-run", false,
+		"Report the changes without making them")
+	addOutputFlag(seedCmd, &seedOutput)
+
+	rootCmd.AddCommand(seedCmd)
+}
+
+// seedRequest is the body of POST /api/v1/admin/seed
+type seedRequest struct {
+	Force  bool     `json:"force"`
+	DryRun bool     `json:"dry_run"`
+	Files  []string `json:"files,omitempty"`
+}
+
+// seedItem is a resource the seed loader created, updated or skipped
+type seedItem struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"` // created, updated, skipped
+	Reason string `json:"reason,omitempty"`
+}
+
+// seedResult is the summary the config server returns
+type seedResult struct {
+	DryRun  bool       `json:"dry_run"`
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Skipped int        `json:"skipped"`
+	Items   []seedItem `json:"items"`
+}
+
+func runSeed(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(seedOutput)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	serverURL, err := configServerURL(cfg, seedConfigServerURL)
+	if err != nil {
+		return err
+	}
+	if serverURL == "" {
+		return fmt.Errorf("no config server: use --config-server-url or a context (aami context set), or set config_server_url in %s", agentConfigPath)
+	}
+	token := defaultString(seedToken, configServerToken(cfg))
+	if token == "" {
+		return fmt.Errorf("no admin token: use --token, a context token, or set admin.token")
+	}
+
+	body, err := json.Marshal(seedRequest{Force: seedForce, DryRun: seedDryRun, Files: args})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(serverURL, "/")+"/api/v1/admin/seed", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("seed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("seed: config server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result seedResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("seed: decode: %w", err)
+	}
+
+	if format.Structured() {
+		return writeOutput(format, result)
+	}
+
+	if len(result.Items) > 0 {
+		table := newTable()
+		table.SetHeader([]string{"Kind", "Name", "Action", "Reason"})
+		table.SetBorder(false)
+		table.SetAutoWrapText(false)
+		for _, item := range result.Items {
+			table.Append([]string{item.Kind, item.Name, colorSeedAction(item.Action), defaultString(item.Reason, "-")})
+		}
+		table.Render()
+		fmt.Println()
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	if result.DryRun {
+		fmt.Printf("Dry run: would create %d, update %d and skip %d resource(s)\n",
+			result.Created, result.Updated, result.Skipped)
+		return nil
+	}
+	fmt.Printf("%s Seeded %s: %d created, %d updated, %d skipped\n", green("✓"), serverURL,
+		result.Created, result.Updated, result.Skipped)
+	return nil
+}
+
+func colorSeedAction(action string) string {
+	switch action {
+	case "created":
+		return color.GreenString(action)
+	case "updated":
+		return color.YellowString(action)
+	default:
+		return action
+	}
+}
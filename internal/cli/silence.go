@@ -0,0 +1,411 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/silence"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+var (
+	silenceRule            string
+	silenceNode            string
+	silenceMatchers        []string
+	silenceDuration        string
+	silenceReason          string
+	silenceListAll         bool
+	silenceListen          string
+	silenceAlertmanagerURL string
+)
+
+var silenceCmd = &cobra.Command{
+	Use:     "silence",
+	Aliases: []string{"silences"},
+	Short:   "Silence alerts during maintenance",
+	Long: `Create, list and expire Alertmanager silences.
+
+Besides the silence itself, AAMI records why it was created, by whom, and
+which alert rule it belongs to, so 'aami silence list' shows more than
+Alertmanager's comment field.
+
+Examples:
+  aami silence create --node gpu-node-01 --duration 4h --reason "PSU replacement"
+  aami silence create --rule GPUXidError --node gpu-node-02 --duration 1d --reason "Known driver bug, RMA pending"
+  aami silence create --matcher rack=A1 --duration 2h --reason "Rack A1 power work"
+  aami silence list
+  aami silence expire 1b2c3d4e-...`,
+}
+
+var silenceCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a silence",
+	Args:  cobra.NoArgs,
+	RunE:  runSilenceCreate,
+}
+
+var silenceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List silences",
+	Args:  cobra.NoArgs,
+	RunE:  runSilenceList,
+}
+
+var silenceExpireCmd = &cobra.Command{
+	Use:   "expire <id>",
+	Short: "End a silence",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSilenceExpire,
+}
+
+var silenceServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the silence API",
+	Long: `Serve the silence API for maintenance tooling at /api/v1/alerts/silences:
+
+  GET    /api/v1/alerts/silences[?all=true]  List silences (all: include expired)
+  POST   /api/v1/alerts/silences             Create a silence
+  DELETE /api/v1/alerts/silences/<id>        Expire a silence
+
+  GET    /api/v1/maintenance-windows         List maintenance windows
+  POST   /api/v1/maintenance-windows         Create a maintenance window
+  GET    /api/v1/maintenance-windows/<name>  Show a maintenance window
+  DELETE /api/v1/maintenance-windows/<name>  Delete one and end its silence
+
+Requests authenticate with "Authorization: Bearer <silences.token>", or
+with an API key (api_keys) or SSO ID token (oidc), which only lists, creates and expires silences
+of the nodes in its namespace; its silences need a node. A create request takes the same fields as 'aami silence create':
+  {"rule": "GPUXidError", "node": "gpu-node-01", "matchers": {"rack": "A1"},
+   "duration": "4h", "reason": "PSU replacement", "created_by": "alice"}
+
+Every minute, the silences of maintenance windows ('aami maintenance') are
+created as windows open and expired as they close. API keys cannot manage
+maintenance windows.
+
+Examples:
+  aami silence serve --listen :8097`,
+	Args: cobra.NoArgs,
+	RunE: runSilenceServe,
+}
+
+func init() {
+	silenceCreateCmd.Flags().StringVar(&silenceRule, "rule", "",
+		"Alert rule (alertname) to silence")
+	silenceCreateCmd.Flags().StringVar(&silenceNode, "node", "",
+		"Node to silence")
+	silenceCreateCmd.RegisterFlagCompletionFunc("rule", completeAlertNames)
+	silenceCreateCmd.RegisterFlagCompletionFunc("node", completeTargets)
+	silenceCreateCmd.Flags().StringSliceVar(&silenceMatchers, "matcher", nil,
+		"Further label=value matcher, repeatable")
+	silenceCreateCmd.Flags().StringVar(&silenceDuration, "duration", "2h",
+		"How long the silence lasts (e.g. 30m, 4h, 1d)")
+	silenceCreateCmd.Flags().StringVar(&silenceReason, "reason", "",
+		"Why the alerts are silenced")
+	silenceCreateCmd.MarkFlagRequired("reason")
+	silenceListCmd.Flags().BoolVar(&silenceListAll, "all", false,
+		"Include expired silences")
+	silenceServeCmd.Flags().StringVar(&silenceListen, "listen", ":8097",
+		"Address to listen on")
+	for _, c := range []*cobra.Command{silenceCreateCmd, silenceListCmd, silenceExpireCmd, silenceServeCmd} {
+		c.Flags().StringVar(&silenceAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+			"Alertmanager URL")
+	}
+
+	silenceCmd.AddCommand(silenceCreateCmd)
+	silenceCmd.AddCommand(silenceListCmd)
+	silenceCmd.AddCommand(silenceExpireCmd)
+	silenceCmd.AddCommand(silenceServeCmd)
+	rootCmd.AddCommand(silenceCmd)
+}
+
+func newSilenceManager(cmd *cobra.Command) *silence.Manager {
+	return silence.NewManager(alertmanager.NewClient(alertmanagerURL(cmd, silenceAlertmanagerURL)), silence.NewStore(silence.DefaultDir))
+}
+
+// silenceRequest validates a silence against the config: the node must be
+// configured, and a rule outside the presets and custom rules is reported.
+func silenceRequest(cfg *config.Config, rule, node string, matchers map[string]string, duration, reason, createdBy string) (silence.Request, []string, error) {
+	d, err := chatops.ParseDuration(duration)
+	if err != nil {
+		return silence.Request{}, nil, err
+	}
+	if node != "" && !hasNodeConfig(cfg, node) {
+		return silence.Request{}, nil, fmt.Errorf("unknown node: %s", node)
+	}
+
+	var warnings []string
+	if rule != "" && !knownRule(cfg, rule) {
+		warnings = append(warnings, i18n.T("%s is not a preset or custom rule", rule))
+	}
+	return silence.Request{
+		Rule:      rule,
+		Node:      node,
+		Matchers:  matchers,
+		Duration:  d,
+		Reason:    reason,
+		CreatedBy: createdBy,
+	}, warnings, nil
+}
+
+func hasNodeConfig(cfg *config.Config, name string) bool {
+	for _, node := range cfg.Nodes {
+		if node.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// knownRule reports whether a rule is defined by a preset or alerts.custom
+func knownRule(cfg *config.Config, name string) bool {
+	for _, r := range cfg.Alerts.Custom {
+		if r.Name == name {
+			return true
+		}
+	}
+	for _, preset := range presets {
+		for _, r := range preset.Rules {
+			if r.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func runSilenceCreate(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	matchers := map[string]string{}
+	for _, m := range silenceMatchers {
+		name, value, ok := strings.Cut(m, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid matcher %q: expected label=value", m)
+		}
+		matchers[name] = value
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	req, warnings, err := silenceRequest(cfg, silenceRule, silenceNode, matchers, silenceDuration, silenceReason, currentUser())
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Printf("%s %s\n", yellow("•"), w)
+	}
+
+	s, err := newSilenceManager(cmd).Create(req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Created silence %s until %s", s.ID, s.EndsAt.Local().Format("2006-01-02 15:04")))
+	fmt.Println(i18n.T("  Matchers: %s", formatMatchers(s.Matchers)))
+	return nil
+}
+
+func runSilenceList(cmd *cobra.Command, args []string) error {
+	silences, err := newSilenceManager(cmd).List(silenceListAll)
+	if err != nil {
+		return err
+	}
+	if len(silences) == 0 {
+		fmt.Println(i18n.T("No silences."))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"ID", i18n.T("State"), i18n.T("Matchers"), i18n.T("Ends"), i18n.T("Rule"), i18n.T("Created By"), i18n.T("Reason")})
+	table.SetBorder(true)
+	table.SetAutoWrapText(false)
+
+	for _, s := range silences {
+		rule, reason := "-", s.Comment
+		if s.Metadata != nil {
+			if s.Metadata.Rule != "" {
+				rule = s.Metadata.Rule
+			}
+			reason = s.Metadata.Reason
+		}
+		table.Append([]string{
+			s.ID,
+			s.State(),
+			formatMatchers(s.Matchers),
+			s.EndsAt.Local().Format("01-02 15:04"),
+			rule,
+			s.CreatedBy,
+			reason,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+func runSilenceExpire(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	if err := newSilenceManager(cmd).Expire(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Expired silence %s", args[0]))
+	return nil
+}
+
+func formatMatchers(matchers []alertmanager.Matcher) string {
+	parts := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		op := "="
+		switch {
+		case m.IsRegex && m.IsEqual:
+			op = "=~"
+		case m.IsRegex:
+			op = "!~"
+		case !m.IsEqual:
+			op = "!="
+		}
+		parts = append(parts, m.Name+op+m.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// runSilenceServe serves /api/v1/alerts/silences. The config is reloaded on
+// every request so token and node changes apply without a restart.
+func runSilenceServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Silences.Token == "" {
+		return fmt.Errorf("silences.token is not configured")
+	}
+
+	manager := newSilenceManager(cmd)
+	syncMaintenanceWindows(manager)
+	go func() {
+		for range time.Tick(maintenanceSyncInterval) {
+			syncMaintenanceWindows(manager)
+		}
+	}()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// API keys only see and change the silences of their namespace's nodes
+		t, _ := tenant.FromContext(r.Context())
+		nodes := t.Nodes(cfg)
+
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/alerts/silences"), "/")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			silences, err := manager.List(r.URL.Query().Get("all") == "true")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			visible := []silence.Silence{}
+			for _, s := range silences {
+				if silenceOwned(s, nodes) {
+					visible = append(visible, s)
+				}
+			}
+			writeSilenceJSON(w, http.StatusOK, visible)
+
+		case r.Method == http.MethodPost && id == "":
+			var body struct {
+				Rule      string            `json:"rule"`
+				Node      string            `json:"node"`
+				Matchers  map[string]string `json:"matchers"`
+				Duration  string            `json:"duration"`
+				Reason    string            `json:"reason"`
+				CreatedBy string            `json:"created_by"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, fmt.Sprintf("parse request: %v", err), http.StatusBadRequest)
+				return
+			}
+			if nodes != nil && !nodes[body.Node] {
+				http.Error(w, fmt.Sprintf("API key %s can only silence nodes of namespace %s", t.Name, t.Namespace), http.StatusForbidden)
+				return
+			}
+			req, _, err := silenceRequest(cfg, body.Rule, body.Node, body.Matchers, body.Duration, body.Reason, body.CreatedBy)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s, err := manager.Create(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusCreated, s)
+
+		case r.Method == http.MethodDelete && id != "":
+			if nodes != nil {
+				silences, err := manager.List(true)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadGateway)
+					return
+				}
+				found := false
+				for _, s := range silences {
+					found = found || (s.ID == id && silenceOwned(s, nodes))
+				}
+				if !found {
+					http.Error(w, "silence not found: "+id, http.StatusNotFound)
+					return
+				}
+			}
+			if err := manager.Expire(id); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	scoped := tenant.Middleware(readConfig, func(c *config.Config) string { return c.Silences.Token }, handler)
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.Handle("/alerts/silences", scoped)
+	v1.Handle("/alerts/silences/", scoped)
+	windows := tenant.Middleware(readConfig, func(c *config.Config) string { return c.Silences.Token }, maintenanceHandler(manager))
+	v1.Handle("/maintenance-windows", windows)
+	v1.Handle("/maintenance-windows/", windows)
+
+	fmt.Printf("%s Serving silences on http://%s/api/v1/alerts/silences\n", green("✓"), silenceListen)
+	return http.ListenAndServe(silenceListen, mux)
+}
+
+// silenceOwned reports whether a silence is for one of nodes; nil nodes
+// own every silence
+func silenceOwned(s silence.Silence, nodes map[string]bool) bool {
+	return nodes == nil || (s.Metadata != nil && nodes[s.Metadata.Node])
+}
+
+func writeSilenceJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
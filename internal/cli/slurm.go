@@ -2,23 +2,51 @@ package cli
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/incident"
+	"github.com/fregataa/aami/internal/output"
 	"github.com/fregataa/aami/internal/slurm"
+	"github.com/fregataa/aami/internal/tenant"
 )
 
+// Page sizes and batch limit of the correlation API
+const (
+	slurmCorrelationsDefaultLimit = 50
+	slurmCorrelationsMaxLimit     = 500
+	slurmCorrelationsMaxBatch     = 500
+)
+
+// defaultAgentCredentialFile is where bootstrap.sh installs a node's agent
+// credential
+const defaultAgentCredentialFile = "/etc/aami/agent-credential"
+
+// agentConfigPath is the node agent's config, shared with the GPU health
+// exporter
+const agentConfigPath = "/etc/aami/agent.yaml"
+
 var (
 	slurmDrainReason   string
+	slurmDrainIncident string
 	slurmOutputJSON    bool
+	slurmAnalyzeOutput string
 	slurmAnalyzeHours  int
 	slurmInstallForce  bool
 )
@@ -38,7 +66,8 @@ Examples:
   aami slurm job-analyze 12345        # Analyze job for GPU issues
   aami slurm drain gpu-node-01        # Drain a node
   aami slurm install-hooks            # Install Slurm hooks
-  aami slurm jobs --node gpu-node-01  # List jobs on a node`,
+  aami slurm jobs --node gpu-node-01  # List jobs on a node
+  aami slurm history gpu-node-01      # Past job-GPU incidents of a node`,
 }
 
 var slurmJobAnalyzeCmd = &cobra.Command{
@@ -52,7 +81,7 @@ if the job failure may be GPU-related.
 
 Examples:
   aami slurm job-analyze 12345
-  aami slurm job-analyze 12345 --json`,
+  aami slurm job-analyze 12345 -o json`,
 	Args: cobra.ExactArgs(1),
 	RunE: runSlurmJobAnalyze,
 }
@@ -68,16 +97,18 @@ a GPU issue is detected that requires investigation.
 Examples:
   aami slurm drain gpu-node-01
   aami slurm drain gpu-node-01 --reason "GPU maintenance"`,
-	Args: cobra.ExactArgs(1),
-	RunE: runSlurmDrain,
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.ExactArgs(1),
+	RunE:              runSlurmDrain,
 }
 
 var slurmResumeCmd = &cobra.Command{
-	Use:   "resume <node>",
-	Short: "Resume a drained node",
-	Long:  `Remove the DRAIN state from a node, allowing new jobs to be scheduled.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runSlurmResume,
+	Use:               "resume <node>",
+	Short:             "Resume a drained node",
+	Long:              `Remove the DRAIN state from a node, allowing new jobs to be scheduled.`,
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.ExactArgs(1),
+	RunE:              runSlurmResume,
 }
 
 var slurmInstallHooksCmd = &cobra.Command{
@@ -108,50 +139,214 @@ var slurmJobsCmd = &cobra.Command{
 Examples:
   aami slurm jobs                      # All running jobs
   aami slurm jobs --node gpu-node-01   # Jobs on specific node
-  aami slurm jobs --user alice         # Jobs by user`,
+  aami slurm jobs --user alice         # Jobs by user
+  aami slurm jobs -o wide              # With account, GPUs and all nodes`,
 	RunE: runSlurmJobs,
 }
 
 var slurmNodesCmd = &cobra.Command{
 	Use:   "nodes",
 	Short: "List Slurm node status",
-	RunE:  runSlurmNodes,
+	Long: `List the Slurm partitions with the state of their nodes.
+
+Examples:
+  aami slurm nodes
+  aami slurm nodes -o wide   # With CPUs, time limits and node lists`,
+	RunE: runSlurmNodes,
 }
 
 var slurmLogCorrelationCmd = &cobra.Command{
-	Use:    "log-correlation",
-	Short:  "Log job-GPU correlation event (internal use)",
+	Use:   "log-correlation",
+	Short: "Log job-GPU correlation event (internal use)",
+	Long: `Report a job-GPU correlation event, as the epilog hook does for jobs that
+failed on a node with degraded GPU health.
+
+The event is posted to slurm.correlations.url with the node's agent
+credential, or stored locally when no URL is configured.`,
 	Hidden: true, // Called by hooks
 	RunE:   runSlurmLogCorrelation,
 }
 
+var slurmHistoryCmd = &cobra.Command{
+	Use:   "history <node>",
+	Short: "Show past job-GPU incidents of a node",
+	Long: `Show the job-GPU correlations reported for a node by the epilog hook,
+newest first: the jobs that failed while its GPUs were unhealthy.
+
+Correlations are read from slurm.correlations.url with slurm.correlations.token,
+or from the local store when no URL is configured.
+
+Examples:
+  aami slurm history gpu-node-01
+  aami slurm history gpu-node-01 --since 90d --limit 100
+  aami slurm history gpu-node-01 -o json`,
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.ExactArgs(1),
+	RunE:              runSlurmHistory,
+}
+
+var slurmServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the job-GPU correlation API",
+	Long: `Serve the job-GPU correlation API at /api/v1/correlations, where the
+epilog hooks of every node report:
+
+  POST /api/v1/correlations        Report correlations (hooks)
+  GET  /api/v1/correlations        List correlations, newest first
+  GET  /api/v1/correlations/<id>   One correlation
+  GET  /api/v1/correlations/nodes  Per-node summary
+
+GET requests authenticate with "Authorization: Bearer <slurm.correlations.token>",
+or with an API key (api_keys) or SSO ID token (oidc), which reads only the
+correlations of the nodes in its namespace. Hooks post with their node's agent credential ('aami
+agents'), and only for their own node; without agent_auth.required they may
+also post without one, for any node in the config.
+List filters: node, job, correlation, since (e.g. 24h, 7d or an RFC 3339
+time); pages: page (from 1) and limit (default 50, at most 500).
+
+Correlations older than slurm.correlations.retention (default 90d) are
+removed at startup and every hour.
+
+Examples:
+  aami slurm serve --listen :8099`,
+	Args: cobra.NoArgs,
+	RunE: runSlurmServe,
+}
+
 var slurmNodeAnalyzeCmd = &cobra.Command{
-	Use:   "node-analyze <node>",
-	Short: "Analyze recent jobs on a node for GPU issues",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runSlurmNodeAnalyze,
+	Use:               "node-analyze <node>",
+	Short:             "Analyze recent jobs on a node for GPU issues",
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.ExactArgs(1),
+	RunE:              runSlurmNodeAnalyze,
+}
+
+var slurmAutodrainCmd = &cobra.Command{
+	Use:   "autodrain",
+	Short: "Drain nodes automatically by the drain policy",
+	Long: `Evaluate the rules of slurm.drain_policy in the config on an interval and
+drain the nodes whose GPUs meet one: an Xid error, a double-bit ECC error,
+a temperature held above a threshold, a firing alert or a PromQL
+expression.
+
+A node is only drained when it is in an allowed partition, not already
+drained or down, and not drained by the policy within the cooldown. Every
+drain, and every drain a dry run would make, is recorded in the audit log.
+
+Examples:
+  aami slurm autodrain                  # Run as a daemon
+  aami slurm autodrain --dry-run        # Audit the drains without draining
+  aami slurm autodrain --once           # Evaluate the rules once and exit`,
+	RunE: runSlurmAutodrain,
+}
+
+var slurmDrainAuditCmd = &cobra.Command{
+	Use:   "drain-audit",
+	Short: "Show the drains made by the drain policy",
+	Long: `Show the audit log of aami slurm autodrain: the nodes drained, the drains
+that failed and the drains dry runs would have made. Drains and resumes
+made with 'aami slurm drain' and 'aami slurm resume' are listed under the
+rule "manual".
+
+Examples:
+  aami slurm drain-audit
+  aami slurm drain-audit --since 30d --node gpu-node-01
+  aami slurm drain-audit -o json`,
+	RunE: runSlurmDrainAudit,
+}
+
+var slurmPrologCheckCmd = &cobra.Command{
+	Use:   "prolog-check",
+	Short: "Run the prolog health gate on this node",
+	Long: `Run the checks of slurm.prolog in the config on this node, as the prolog
+hook does before each job, and fail when the checks that failed or timed
+out weigh more than slurm.prolog.fail_above.
+
+Checks are built in (nvidia-smi, xid, nvlink), shell commands, or checks
+assigned to the node by the config server's script policies, which are
+cached for when the server cannot be reached. Results are reported to the
+check result API as prolog/<check>.
+
+Examples:
+  aami slurm prolog-check
+  aami slurm prolog-check --no-report -o json`,
+	RunE: runSlurmPrologCheck,
+}
+
+var slurmUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show GPU usage by user or account",
+	Long: `Aggregate the GPU hours of the jobs in the Slurm accounting database
+(sacct) by user or account, for chargeback and capacity planning. Only the
+part of a job that ran within the range is counted.
+
+Efficiency is the mean GPU utilization of the jobs' nodes in Prometheus,
+weighted by GPU hours; idle GPU hours are the GPU hours it leaves unused.
+Jobs without utilization samples count towards GPU hours only.
+
+Examples:
+  aami slurm usage
+  aami slurm usage --by account --range 90d
+  aami slurm usage --by account -o csv > gpu-usage.csv`,
+	Args: cobra.NoArgs,
+	RunE: runSlurmUsage,
 }
 
+var (
+	slurmUsageBy           string
+	slurmUsageRange        string
+	slurmUsageNoEfficiency bool
+	slurmUsageOutput       string
+)
+
+var (
+	slurmPrologJobID    int64
+	slurmPrologNode     string
+	slurmPrologNoReport bool
+	slurmPrologOutput   string
+)
+
+var (
+	slurmAutodrainOnce     bool
+	slurmAutodrainDryRun   bool
+	slurmAutodrainInterval time.Duration
+	slurmDrainAuditSince   string
+	slurmDrainAuditNode    string
+	slurmDrainAuditOutput  string
+)
+
 var (
 	slurmJobsNode      string
 	slurmJobsUser      string
 	slurmJobsPartition string
+	slurmJobsOutput    string
+	slurmNodesOutput   string
 	slurmLogJobID      int64
 	slurmLogNode       string
 	slurmLogScore      int
 	slurmLogExitCode   int
+	slurmLogUser       string
+	slurmLogType       string
+	slurmHistorySince  string
+	slurmHistoryLimit  int
+	slurmHistoryOutput string
+	slurmServeListen   string
 )
 
 func init() {
 	rootCmd.AddCommand(slurmCmd)
 
 	// job-analyze
+	addOutputFlag(slurmJobAnalyzeCmd, &slurmAnalyzeOutput)
 	slurmJobAnalyzeCmd.Flags().BoolVar(&slurmOutputJSON, "json", false, "Output in JSON format")
+	slurmJobAnalyzeCmd.Flags().MarkDeprecated("json", "use -o json")
 	slurmCmd.AddCommand(slurmJobAnalyzeCmd)
 
 	// drain
 	slurmDrainCmd.Flags().StringVar(&slurmDrainReason, "reason", "AAMI: GPU health issue",
 		"Reason for draining the node")
+	slurmDrainCmd.Flags().StringVar(&slurmDrainIncident, "incident", "",
+		"Record the drain on this incident's timeline")
 	slurmCmd.AddCommand(slurmDrainCmd)
 
 	// resume
@@ -167,11 +362,14 @@ func init() {
 
 	// jobs
 	slurmJobsCmd.Flags().StringVar(&slurmJobsNode, "node", "", "Filter by node")
+	slurmJobsCmd.RegisterFlagCompletionFunc("node", completeTargets)
 	slurmJobsCmd.Flags().StringVar(&slurmJobsUser, "user", "", "Filter by user")
 	slurmJobsCmd.Flags().StringVar(&slurmJobsPartition, "partition", "", "Filter by partition")
+	addOutputFlag(slurmJobsCmd, &slurmJobsOutput)
 	slurmCmd.AddCommand(slurmJobsCmd)
 
 	// nodes
+	addOutputFlag(slurmNodesCmd, &slurmNodesOutput)
 	slurmCmd.AddCommand(slurmNodesCmd)
 
 	// log-correlation (hidden, for hooks)
@@ -179,15 +377,74 @@ func init() {
 	slurmLogCorrelationCmd.Flags().StringVar(&slurmLogNode, "node", "", "Node name")
 	slurmLogCorrelationCmd.Flags().IntVar(&slurmLogScore, "score", 0, "Health score")
 	slurmLogCorrelationCmd.Flags().IntVar(&slurmLogExitCode, "exit-code", 0, "Exit code")
+	slurmLogCorrelationCmd.Flags().StringVar(&slurmLogUser, "user", "", "Job user")
+	slurmLogCorrelationCmd.Flags().StringVar(&slurmLogType, "correlation", string(slurm.CorrelationPossible),
+		"Correlation: none, unlikely, possible, likely, confirmed")
 	slurmCmd.AddCommand(slurmLogCorrelationCmd)
 
+	// history
+	slurmHistoryCmd.Flags().StringVar(&slurmHistorySince, "since", "30d",
+		"Only correlations reported within this duration (e.g. 7d) or since an RFC 3339 time")
+	slurmHistoryCmd.Flags().IntVar(&slurmHistoryLimit, "limit", slurmCorrelationsDefaultLimit,
+		"Correlations to show")
+	addOutputFlag(slurmHistoryCmd, &slurmHistoryOutput)
+	slurmCmd.AddCommand(slurmHistoryCmd)
+
+	// serve
+	slurmServeCmd.Flags().StringVar(&slurmServeListen, "listen", ":8099",
+		"Address to listen on")
+	slurmCmd.AddCommand(slurmServeCmd)
+
 	// node-analyze
 	slurmNodeAnalyzeCmd.Flags().IntVar(&slurmAnalyzeHours, "hours", 24,
 		"Hours of history to analyze")
 	slurmCmd.AddCommand(slurmNodeAnalyzeCmd)
+
+	// autodrain
+	slurmAutodrainCmd.Flags().BoolVar(&slurmAutodrainOnce, "once", false,
+		"Evaluate the rules once and exit")
+	slurmAutodrainCmd.Flags().BoolVar(&slurmAutodrainDryRun, "dry-run", false,
+		"Audit the drains without draining, whatever the config says")
+	slurmAutodrainCmd.Flags().DurationVar(&slurmAutodrainInterval, "interval", 0,
+		"Time between evaluations (default: slurm.drain_policy.interval)")
+	slurmCmd.AddCommand(slurmAutodrainCmd)
+
+	// prolog-check
+	slurmPrologCheckCmd.Flags().Int64Var(&slurmPrologJobID, "job", 0, "Job the checks are run for")
+	slurmPrologCheckCmd.Flags().StringVar(&slurmPrologNode, "node", "",
+		"Node name (default: hostname in /etc/aami/agent.yaml, then the system hostname)")
+	slurmPrologCheckCmd.Flags().BoolVar(&slurmPrologNoReport, "no-report", false,
+		"Do not report the results to the check result API")
+	addOutputFlag(slurmPrologCheckCmd, &slurmPrologOutput)
+	slurmCmd.AddCommand(slurmPrologCheckCmd)
+
+	// usage
+	slurmUsageCmd.Flags().StringVar(&slurmUsageBy, "by", slurm.UsageByUser,
+		"Group by: user, account")
+	slurmUsageCmd.Flags().StringVar(&slurmUsageRange, "range", "30d",
+		"Period to report, up to now (e.g. 7d, 30d)")
+	slurmUsageCmd.Flags().BoolVar(&slurmUsageNoEfficiency, "no-efficiency", false,
+		"Skip the GPU utilization queries to Prometheus")
+	addOutputFlag(slurmUsageCmd, &slurmUsageOutput, usageCSV)
+	slurmCmd.AddCommand(slurmUsageCmd)
+
+	// drain-audit
+	slurmDrainAuditCmd.Flags().StringVar(&slurmDrainAuditSince, "since", "7d",
+		"How far back to show")
+	slurmDrainAuditCmd.Flags().StringVar(&slurmDrainAuditNode, "node", "", "Filter by node")
+	slurmDrainAuditCmd.RegisterFlagCompletionFunc("node", completeTargets)
+	addOutputFlag(slurmDrainAuditCmd, &slurmDrainAuditOutput)
+	slurmCmd.AddCommand(slurmDrainAuditCmd)
 }
 
 func runSlurmJobAnalyze(cmd *cobra.Command, args []string) error {
+	if slurmOutputJSON {
+		slurmAnalyzeOutput = string(output.JSON)
+	}
+	format, err := output.Parse(slurmAnalyzeOutput)
+	if err != nil {
+		return err
+	}
 	jobID, err := strconv.ParseInt(args[0], 10, 64)
 	if err != nil {
 		return fmt.Errorf("invalid job ID: %s", args[0])
@@ -198,24 +455,25 @@ func runSlurmJobAnalyze(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
-	prometheusURL := fmt.Sprintf("http://localhost:%d", cfg.Prometheus.Port)
-	analyzer := slurm.NewAnalyzer(slurmClient, prometheusURL)
+	slurmClient, err := slurmClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	promURL := prometheusURL(cfg)
+	analyzer := slurm.NewAnalyzer(slurmClient, promURL)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	fmt.Printf("Analyzing job %d...\n\n", jobID)
+	fmt.Fprintf(os.Stderr, "Analyzing job %d...\n\n", jobID)
 
 	result, err := analyzer.AnalyzeJob(ctx, jobID)
 	if err != nil {
 		return fmt.Errorf("analysis failed: %w", err)
 	}
 
-	if slurmOutputJSON {
-		data, _ := json.MarshalIndent(result, "", "  ")
-		fmt.Println(string(data))
-		return nil
+	if format.Structured() {
+		return writeOutput(format, result)
 	}
 
 	// Print results
@@ -234,6 +492,9 @@ func runSlurmJobAnalyze(cmd *cobra.Command, args []string) error {
 	fmt.Printf("  State:      %s\n", colorJobState(result.Job.State))
 	fmt.Printf("  Exit Code:  %d\n", result.Job.ExitCode)
 	fmt.Printf("  Nodes:      %s\n", strings.Join(result.Job.Nodes, ", "))
+	if len(result.Job.GPUs) > 0 {
+		fmt.Printf("  GPUs:       %s\n", formatGPUAllocations(result.Job.GPUs))
+	}
 	if !result.Job.StartTime.IsZero() {
 		fmt.Printf("  Start:      %s\n", result.Job.StartTime.Format("2006-01-02 15:04:05"))
 	}
@@ -276,7 +537,7 @@ func runSlurmJobAnalyze(cmd *cobra.Command, args []string) error {
 		fmt.Println("Affected GPUs")
 		fmt.Println(strings.Repeat("-", 50))
 		for _, gpu := range result.AffectedGPUs {
-			fmt.Printf("  %s %s GPU %d\n", color.RedString("•"), gpu.Node, gpu.GPUIndex)
+			fmt.Printf("  %s %s %s\n", color.RedString("•"), gpu.Node, gpu.Label())
 		}
 		fmt.Println()
 	}
@@ -297,7 +558,10 @@ func runSlurmJobAnalyze(cmd *cobra.Command, args []string) error {
 func runSlurmDrain(cmd *cobra.Command, args []string) error {
 	node := args[0]
 
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
+	slurmClient, err := newSlurmClient()
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -310,6 +574,19 @@ func runSlurmDrain(cmd *cobra.Command, args []string) error {
 
 	color.Green("✓ Node %s drained", node)
 	fmt.Printf("  Reason: %s\n", slurmDrainReason)
+	recordManualDrain(node, slurm.DrainActionDrained, slurmDrainReason)
+
+	if slurmDrainIncident != "" {
+		msg := fmt.Sprintf("Drained %s: %s", node, slurmDrainReason)
+		inc, err := updateIncident(slurmDrainIncident, func(inc *incident.Incident) error {
+			inc.AddEvent(incident.EventDrain, currentUser(), msg, time.Now().UTC())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("record drain on incident: %w", err)
+		}
+		fmt.Printf("  Recorded on %s\n", inc.ID)
+	}
 	fmt.Println()
 	fmt.Println("To resume the node:")
 	fmt.Printf("  aami slurm resume %s\n", node)
@@ -320,7 +597,10 @@ func runSlurmDrain(cmd *cobra.Command, args []string) error {
 func runSlurmResume(cmd *cobra.Command, args []string) error {
 	node := args[0]
 
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
+	slurmClient, err := newSlurmClient()
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -332,10 +612,37 @@ func runSlurmResume(cmd *cobra.Command, args []string) error {
 	}
 
 	color.Green("✓ Node %s resumed", node)
+	recordManualDrain(node, slurm.DrainActionResumed, "")
 
 	return nil
 }
 
+// recordManualDrain adds a drain or resume made from the command line to
+// the drain audit log, for 'aami slurm drain-audit' and node timelines. The
+// node's state has already changed, so a failure is only reported.
+func recordManualDrain(node, action, reason string) {
+	record := slurm.DrainRecord{
+		Time:   time.Now().UTC(),
+		Rule:   slurm.DrainRuleManual,
+		Node:   node,
+		Reason: reason,
+		Action: action,
+	}
+	cfg, _ := loadConfig()
+	if err := slurm.AppendDrainAudit(slurmDrainAuditLog(cfg), &record); err != nil {
+		fmt.Fprintf(os.Stderr, "%s record %s in drain audit log: %v\n", color.YellowString("!"), action, err)
+	}
+}
+
+// slurmDrainAuditLog returns slurm.drain_policy.audit_log, or the default
+// without one or without a config
+func slurmDrainAuditLog(cfg *config.Config) string {
+	if cfg != nil && cfg.Slurm.DrainPolicy.AuditLog != "" {
+		return cfg.Slurm.DrainPolicy.AuditLog
+	}
+	return slurm.DefaultDrainAuditLog
+}
+
 func runSlurmInstallHooks(cmd *cobra.Command, args []string) error {
 	prologPath := "/etc/slurm/aami-prolog.sh"
 	epilogPath := "/etc/slurm/aami-epilog.sh"
@@ -354,7 +661,10 @@ func runSlurmInstallHooks(cmd *cobra.Command, args []string) error {
 	slurmCfg.PreJobCheck = true
 	slurmCfg.PostJobCheck = true
 
-	slurmClient := slurm.NewClient(slurmCfg)
+	slurmClient, err := newSlurmClient()
+	if err != nil {
+		return err
+	}
 	hookMgr := slurm.NewHookManager(slurmCfg, slurmClient)
 
 	fmt.Println("Installing Slurm hooks...")
@@ -400,7 +710,14 @@ func runSlurmUninstallHooks(cmd *cobra.Command, args []string) error {
 }
 
 func runSlurmJobs(cmd *cobra.Command, args []string) error {
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
+	format, err := output.Parse(slurmJobsOutput)
+	if err != nil {
+		return err
+	}
+	slurmClient, err := newSlurmClient()
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -416,14 +733,33 @@ func runSlurmJobs(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("get jobs: %w", err)
 	}
 
+	if format.Structured() {
+		if jobs == nil {
+			jobs = []slurm.Job{}
+		}
+		return writeOutput(format, jobs)
+	}
 	if len(jobs) == 0 {
 		fmt.Println("No jobs found")
 		return nil
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Job ID", "Name", "User", "Partition", "State", "Nodes", "Time"})
+	columns := output.Columns{
+		{Header: "Job ID"},
+		{Header: "Name"},
+		{Header: "User"},
+		{Header: "Account", Wide: true},
+		{Header: "Partition"},
+		{Header: "State"},
+		{Header: "GPUs", Wide: true},
+		{Header: "Nodes"},
+		{Header: "Time"},
+		{Header: "Submitted", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
 	table.SetBorder(false)
+	table.SetAutoWrapText(false)
 
 	for _, job := range jobs {
 		runtime := ""
@@ -435,20 +771,30 @@ func runSlurmJobs(cmd *cobra.Command, args []string) error {
 			runtime = formatDuration(duration)
 		}
 
-		nodes := strings.Join(job.Nodes, ",")
-		if len(nodes) > 20 {
-			nodes = nodes[:17] + "..."
+		name, nodes := job.Name, strings.Join(job.Nodes, ",")
+		if format != output.Wide {
+			name = truncate(name, 20)
+			if len(nodes) > 20 {
+				nodes = nodes[:17] + "..."
+			}
+		}
+		submitted := "-"
+		if !job.SubmitTime.IsZero() {
+			submitted = job.SubmitTime.Local().Format("2006-01-02 15:04")
 		}
 
-		table.Append([]string{
+		table.Append(columns.Row(format,
 			strconv.FormatInt(job.ID, 10),
-			truncate(job.Name, 20),
+			name,
 			job.User,
+			defaultString(job.Account, "-"),
 			job.Partition,
 			string(job.State),
+			strconv.Itoa(job.GPUCount),
 			nodes,
 			runtime,
-		})
+			submitted,
+		))
 	}
 
 	table.Render()
@@ -457,7 +803,14 @@ func runSlurmJobs(cmd *cobra.Command, args []string) error {
 }
 
 func runSlurmNodes(cmd *cobra.Command, args []string) error {
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
+	format, err := output.Parse(slurmNodesOutput)
+	if err != nil {
+		return err
+	}
+	slurmClient, err := newSlurmClient()
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -467,20 +820,43 @@ func runSlurmNodes(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("get partitions: %w", err)
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Partition", "State", "Nodes", "Idle", "Alloc", "Down", "GPUs"})
+	if format.Structured() {
+		if partitions == nil {
+			partitions = []slurm.PartitionInfo{}
+		}
+		return writeOutput(format, partitions)
+	}
+
+	columns := output.Columns{
+		{Header: "Partition"},
+		{Header: "State"},
+		{Header: "Nodes"},
+		{Header: "Idle"},
+		{Header: "Alloc"},
+		{Header: "Down"},
+		{Header: "CPUs", Wide: true},
+		{Header: "GPUs"},
+		{Header: "Max Time", Wide: true},
+		{Header: "Node List", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
 	table.SetBorder(false)
+	table.SetAutoWrapText(false)
 
 	for _, p := range partitions {
-		table.Append([]string{
+		table.Append(columns.Row(format,
 			p.Name,
 			p.State,
 			strconv.Itoa(p.TotalNodes),
 			strconv.Itoa(p.IdleNodes),
 			strconv.Itoa(p.AllocNodes),
 			strconv.Itoa(p.DownNodes),
+			strconv.Itoa(p.TotalCPUs),
 			strconv.Itoa(p.TotalGPUs),
-		})
+			defaultString(p.MaxTime, "-"),
+			defaultString(strings.Join(p.Nodes, ","), "-"),
+		))
 	}
 
 	table.Render()
@@ -491,20 +867,142 @@ func runSlurmNodes(cmd *cobra.Command, args []string) error {
 func runSlurmLogCorrelation(cmd *cobra.Command, args []string) error {
 	// This is called by the epilog hook to log correlation data
 	log := slurm.CorrelationLog{
-		Timestamp:   time.Now(),
+		Timestamp:   time.Now().UTC(),
 		JobID:       slurmLogJobID,
+		User:        slurmLogUser,
 		Node:        slurmLogNode,
 		HealthScore: slurmLogScore,
 		ExitCode:    slurmLogExitCode,
+		Correlation: slurm.CorrelationType(slurmLogType),
+	}
+	if err := log.Validate(); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var stored []slurm.CorrelationLog
+	if apiURL := cfg.Slurm.Correlations.URL; apiURL != "" {
+		credential, err := readAgentCredential(cfg.Slurm.Correlations.CredentialFile)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		stored, err = slurm.NewCorrelationClient(apiURL, credential).Report(ctx, []slurm.CorrelationLog{log})
+		if err != nil {
+			return fmt.Errorf("report correlation: %w", err)
+		}
+	} else {
+		stored, err = newCorrelationStore().Add([]slurm.CorrelationLog{log})
+		if err != nil {
+			return err
+		}
 	}
 
-	// In production, this would write to a database or log file
-	data, _ := json.Marshal(log)
+	data, _ := json.Marshal(stored)
 	fmt.Println(string(data))
 
 	return nil
 }
 
+// readAgentCredential reads the node's agent credential, if it has one
+func readAgentCredential(path string) (string, error) {
+	if path == "" {
+		path = defaultAgentCredentialFile
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read agent credential: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func runSlurmHistory(cmd *cobra.Command, args []string) error {
+	node := args[0]
+	format, err := output.Parse(slurmHistoryOutput)
+	if err != nil {
+		return err
+	}
+	if slurmHistoryLimit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+	since, err := parseSince(slurmHistorySince, time.Now())
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	f := slurm.CorrelationFilter{Node: node, Since: since}
+	var page slurm.CorrelationPage
+	if apiURL := cfg.Slurm.Correlations.URL; apiURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		page, err = slurm.NewCorrelationClient(apiURL, cfg.Slurm.Correlations.Token).List(ctx, f, 1, slurmHistoryLimit)
+	} else {
+		page, err = newCorrelationStore().List(f, 1, slurmHistoryLimit)
+	}
+	if err != nil {
+		return err
+	}
+
+	if format.Structured() {
+		return writeOutput(format, page)
+	}
+	if page.Total == 0 {
+		fmt.Printf("No job-GPU incidents on %s since %s.\n", node, since.Local().Format("2006-01-02 15:04"))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Time", "Job", "User", "Exit", "Health", "Correlation", "Event"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, c := range page.Correlations {
+		event := "-"
+		if c.EventType != "" {
+			event = formatCorrelationEvent(c)
+		}
+		table.Append([]string{
+			c.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			strconv.FormatInt(c.JobID, 10),
+			defaultString(c.User, "-"),
+			strconv.Itoa(c.ExitCode),
+			strconv.Itoa(c.HealthScore),
+			colorCorrelation(c.Correlation),
+			event,
+		})
+	}
+	table.Render()
+
+	if page.Total > len(page.Correlations) {
+		fmt.Printf("\nShowing %d of %d; use --limit to see more.\n", len(page.Correlations), page.Total)
+	}
+	return nil
+}
+
+// formatCorrelationEvent describes the GPU event of a correlation
+func formatCorrelationEvent(c slurm.CorrelationLog) string {
+	event := fmt.Sprintf("%s on GPU %d", c.EventType, c.GPUIndex)
+	if c.EventValue != "" {
+		event += ": " + c.EventValue
+	}
+	if c.ActionTaken != "" {
+		event += " (" + c.ActionTaken + ")"
+	}
+	return event
+}
+
 func runSlurmNodeAnalyze(cmd *cobra.Command, args []string) error {
 	node := args[0]
 
@@ -513,9 +1011,12 @@ func runSlurmNodeAnalyze(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	slurmClient := slurm.NewClient(slurm.DefaultSlurmConfig())
-	prometheusURL := fmt.Sprintf("http://localhost:%d", cfg.Prometheus.Port)
-	analyzer := slurm.NewAnalyzer(slurmClient, prometheusURL)
+	slurmClient, err := slurmClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	promURL := prometheusURL(cfg)
+	analyzer := slurm.NewAnalyzer(slurmClient, promURL)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
@@ -546,7 +1047,7 @@ func runSlurmNodeAnalyze(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Analyzed %d jobs: %d with GPU events, %d critical\n\n", len(correlations), withIssues, critical)
 
 	// Table
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Job ID", "User", "State", "Events", "Correlation", "Confidence"})
 	table.SetBorder(false)
 
@@ -572,6 +1073,591 @@ func runSlurmNodeAnalyze(cmd *cobra.Command, args []string) error {
 
 // Helper functions
 
+func runSlurmAutodrain(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	policyCfg := cfg.Slurm.DrainPolicy
+	if len(policyCfg.Rules) == 0 {
+		return fmt.Errorf("no drain rules configured in slurm.drain_policy.rules")
+	}
+	if slurmAutodrainDryRun {
+		policyCfg.DryRun = true
+	}
+	if cmd.Flags().Changed("interval") {
+		if slurmAutodrainInterval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		policyCfg.Interval = slurmAutodrainInterval.String()
+	}
+
+	slurmClient, err := slurmClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	promURL := prometheusURL(cfg)
+	policy, err := slurm.NewDrainPolicy(slurmClient, promURL, policyCfg)
+	if err != nil {
+		return fmt.Errorf("drain policy: %w", err)
+	}
+
+	mode := "draining"
+	if policy.DryRun() {
+		mode = "dry run"
+	}
+
+	if slurmAutodrainOnce {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		eval, err := policy.Evaluate(ctx)
+		logPolicyEvaluation(eval)
+		if err != nil {
+			return err
+		}
+		if len(eval.Errors) > 0 {
+			return countError(len(eval.Errors), policy.NumRules(), "drain rules")
+		}
+		return countError(eval.Failed(), len(eval.Records), "drains")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Evaluating drain policy every %s (%s), auditing to %s\n",
+		green("✓"), policy.Interval(), mode, policy.AuditLog())
+
+	policy.Run(ctx, func(eval *slurm.PolicyEvaluation, err error) {
+		logPolicyEvaluation(eval)
+		if err != nil {
+			discoveryLogf("autodrain: %v", err)
+		}
+	})
+	return nil
+}
+
+// logPolicyEvaluation logs the drains, skips and errors of an evaluation
+func logPolicyEvaluation(eval *slurm.PolicyEvaluation) {
+	if eval == nil {
+		return
+	}
+	for _, e := range eval.Errors {
+		discoveryLogf("autodrain: %s", e)
+	}
+	for _, r := range eval.Records {
+		switch r.Action {
+		case slurm.DrainActionFailed:
+			discoveryLogf("%s %s failed: %s", color.RedString("drain"), r.Node, r.Error)
+		case slurm.DrainActionDryRun:
+			discoveryLogf("would drain %s: %s", r.Node, r.Reason)
+		default:
+			discoveryLogf("drained %s: %s", r.Node, r.Reason)
+		}
+	}
+	for _, s := range eval.Skipped {
+		discoveryLogf("skipped %s (rule %s): %s", s.Node, s.Rule, s.Reason)
+	}
+}
+
+func runSlurmDrainAudit(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(slurmDrainAuditOutput)
+	if err != nil {
+		return err
+	}
+	since, err := chatops.ParseDuration(slurmDrainAuditSince)
+	if err != nil || since <= 0 {
+		return fmt.Errorf("invalid --since %q", slurmDrainAuditSince)
+	}
+
+	cfg, _ := loadConfig()
+	records, err := slurm.LoadDrainAudit(slurmDrainAuditLog(cfg))
+	if err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+
+	cutoff := time.Now().Add(-since)
+	filtered := []slurm.DrainRecord{}
+	for _, r := range records {
+		if r.Time.Before(cutoff) || (slurmDrainAuditNode != "" && r.Node != slurmDrainAuditNode) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+
+	if format.Structured() {
+		return writeOutput(format, filtered)
+	}
+
+	if len(filtered) == 0 {
+		fmt.Printf("No drains in the last %s.\n", slurmDrainAuditSince)
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Time", "Node", "Rule", "Action", "Reason"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, r := range filtered {
+		action := r.Action
+		switch r.Action {
+		case slurm.DrainActionDrained, slurm.DrainActionResumed:
+			action = color.GreenString(r.Action)
+		case slurm.DrainActionFailed:
+			action = color.RedString(r.Action)
+		}
+		reason := r.Reason
+		if r.Error != "" {
+			reason += " (" + r.Error + ")"
+		}
+		table.Append([]string{
+			r.Time.Local().Format("2006-01-02 15:04:05"),
+			r.Node,
+			r.Rule,
+			action,
+			reason,
+		})
+	}
+	table.Render()
+	return nil
+}
+
+// agentSettings are the settings of the node agent's agent.yaml used here
+type agentSettings struct {
+	ConfigServerURL string `yaml:"config_server_url"`
+	CredentialFile  string `yaml:"credential_file"`
+	Hostname        string `yaml:"hostname"`
+}
+
+// readAgentSettings reads the node agent's agent.yaml, if the node has one
+func readAgentSettings() (agentSettings, error) {
+	var settings agentSettings
+	data, err := os.ReadFile(agentConfigPath)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, fmt.Errorf("read agent config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("parse %s: %w", agentConfigPath, err)
+	}
+	return settings, nil
+}
+
+func runSlurmPrologCheck(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(slurmPrologOutput)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	prolog := cfg.Slurm.Prolog
+	agent, err := readAgentSettings()
+	if err != nil {
+		return err
+	}
+
+	node := slurmPrologNode
+	if node == "" {
+		node = agent.Hostname
+	}
+	if node == "" {
+		if node, err = os.Hostname(); err != nil {
+			return fmt.Errorf("hostname: %w", err)
+		}
+	}
+	serverURL := defaultString(prolog.ConfigServerURL, agent.ConfigServerURL)
+	credentialFile := defaultString(prolog.CredentialFile, agent.CredentialFile)
+	credential, err := readAgentCredential(credentialFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	var policies []slurm.PolicyCheck
+	for _, check := range prolog.Checks {
+		if check.Policy == "" {
+			continue
+		}
+		if serverURL == "" {
+			return fmt.Errorf("policy checks need slurm.prolog.config_server_url or config_server_url in %s", agentConfigPath)
+		}
+		policies, err = slurm.FetchPolicyChecks(ctx, serverURL, node, credential, slurm.DefaultPolicyCheckCache)
+		if err != nil {
+			if policies == nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%s %v; using the cached policy checks\n", color.YellowString("!"), err)
+		}
+		break
+	}
+
+	gate, err := slurm.NewPrologGate(node, prolog, policies)
+	if err != nil {
+		return fmt.Errorf("slurm.prolog: %w", err)
+	}
+	report := gate.Run(ctx, slurmPrologJobID)
+
+	resultsURL := defaultString(prolog.ResultsURL, serverURL)
+	if resultsURL != "" && !slurmPrologNoReport && len(report.Results) > 0 {
+		if _, err := checkresult.NewClient(resultsURL, credential).Report(ctx, report.Results); err != nil {
+			fmt.Fprintf(os.Stderr, "%s report results: %v\n", color.YellowString("!"), err)
+		}
+	}
+
+	if format.Structured() {
+		if err := writeOutput(format, report); err != nil {
+			return err
+		}
+	} else {
+		printPrologReport(gate, report)
+	}
+
+	if report.Rejected {
+		return fmt.Errorf("health gate failed: failed checks weigh %d (fail above %d)", report.Score, report.FailAbove)
+	}
+	return nil
+}
+
+func printPrologReport(gate *slurm.PrologGate, report slurm.PrologReport) {
+	if gate.NumChecks() == 0 {
+		fmt.Println("No prolog checks configured (slurm.prolog.checks).")
+		return
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"Check", "Status", "Duration", "Detail"})
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, r := range report.Results {
+		status := r.Status
+		switch r.Status {
+		case checkresult.StatusPassed:
+			status = color.GreenString(r.Status)
+		case checkresult.StatusFailed, checkresult.StatusTimeout:
+			status = color.RedString(r.Status)
+		case checkresult.StatusError:
+			status = color.YellowString(r.Status)
+		}
+		table.Append([]string{
+			strings.TrimPrefix(r.Check, "prolog/"),
+			status,
+			(time.Duration(r.DurationMS) * time.Millisecond).String(),
+			truncate(r.Error, 80),
+		})
+	}
+	table.Render()
+	fmt.Println()
+
+	if report.Rejected {
+		fmt.Printf("%s Health gate failed on %s: score %d, fail above %d\n",
+			color.RedString("✗"), report.Node, report.Score, report.FailAbove)
+		return
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	fmt.Printf("%s Health gate passed on %s: score %d, fail above %d\n",
+		green("✓"), report.Node, report.Score, report.FailAbove)
+}
+
+// newSlurmClient creates a Slurm client with the slurmrestd settings of the
+// config file, if there is one
+func newSlurmClient() (*slurm.Client, error) {
+	path := cfgFile
+	if path == "" {
+		path = config.DefaultConfigPath
+	}
+	var cfg *config.Config
+	if _, err := os.Stat(path); err == nil {
+		if cfg, err = loadConfig(); err != nil {
+			return nil, err
+		}
+	}
+	return slurmClientFor(cfg)
+}
+
+// slurmClientFor creates a Slurm client with the slurmrestd settings of
+// cfg, which may be nil. Falling back to the Slurm commands is reported on
+// stderr, so that output stays parseable.
+func slurmClientFor(cfg *config.Config) (*slurm.Client, error) {
+	slurmCfg := slurm.DefaultSlurmConfig()
+	if cfg != nil {
+		rest := cfg.Slurm.REST
+		slurmCfg.Endpoint = rest.Endpoint
+		slurmCfg.APIVersion = rest.APIVersion
+		slurmCfg.User = rest.User
+		slurmCfg.AuthToken = rest.Token
+		if rest.TokenLifetime != "" {
+			lifetime, err := time.ParseDuration(rest.TokenLifetime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slurm.rest.token_lifetime: %w", err)
+			}
+			slurmCfg.TokenLifetime = lifetime
+		}
+	}
+
+	client := slurm.NewClient(slurmCfg)
+	yellow := color.New(color.FgYellow).SprintFunc()
+	client.OnFallback = func(op string, err error) {
+		fmt.Fprintf(os.Stderr, "%s slurmrestd unavailable (%v), using Slurm commands to %s\n", yellow("!"), err, op)
+	}
+	return client, nil
+}
+
+func newCorrelationStore() *slurm.CorrelationStore {
+	return slurm.NewCorrelationStore(slurm.DefaultCorrelationDir)
+}
+
+// correlationsRetention returns slurm.correlations.retention, or the default
+func correlationsRetention(cfg *config.Config) (time.Duration, error) {
+	if cfg.Slurm.Correlations.Retention == "" {
+		return slurm.DefaultCorrelationRetention, nil
+	}
+	d, err := chatops.ParseDuration(cfg.Slurm.Correlations.Retention)
+	if err != nil {
+		return 0, fmt.Errorf("slurm.correlations.retention: %w", err)
+	}
+	return d, nil
+}
+
+// runSlurmServe serves /api/v1/correlations. The config is reloaded on
+// every request so token and node changes apply without a restart.
+func runSlurmServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Slurm.Correlations.Token == "" {
+		return fmt.Errorf("slurm.correlations.token is not configured")
+	}
+	if _, err := correlationsRetention(cfg); err != nil {
+		return err
+	}
+
+	store := newCorrelationStore()
+	authority := newAgentAuthority()
+	prune := func() {
+		cfg, err := readConfig()
+		if err == nil {
+			var retention time.Duration
+			if retention, err = correlationsRetention(cfg); err == nil {
+				_, err = store.Prune(retention)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s prune correlations: %v\n", yellow("•"), err)
+		}
+	}
+	prune()
+	go func() {
+		for range time.Tick(checkResultsPruneInterval) {
+			prune()
+		}
+	}()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		if r.Method == http.MethodPost && path == "/correlations" {
+			claims, err := agentCredential(r, cfg, authority)
+			if err != nil {
+				writeAgentAuthError(w, err)
+				return
+			}
+			logs, err := decodeCorrelations(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for i, c := range logs {
+				if claims != nil && c.Node != claims.Target() {
+					http.Error(w, fmt.Sprintf("correlation %d: credential of %s cannot report for %s", i, claims.Target(), c.Node), http.StatusForbidden)
+					return
+				}
+				if !hasNodeConfig(cfg, c.Node) {
+					http.Error(w, fmt.Sprintf("correlation %d: unknown node: %s", i, c.Node), http.StatusBadRequest)
+					return
+				}
+			}
+			stored, err := store.Add(logs)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusCreated, map[string]interface{}{
+				"accepted":     len(stored),
+				"correlations": stored,
+			})
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// API keys read only the correlations of their namespace's nodes
+		t, err := tenant.Authenticate(cfg, r, cfg.Slurm.Correlations.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+
+		switch id := strings.TrimPrefix(path, "/correlations/"); {
+		case path == "/correlations/nodes":
+			f := slurm.CorrelationFilter{Nodes: t.Nodes(cfg)}
+			if since := q.Get("since"); since != "" {
+				if f.Since, err = parseSince(since, time.Now()); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			nodes, err := store.Nodes(f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, nodes)
+
+		case path == "/correlations":
+			f, err := correlationFilter(q)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.Nodes = t.Nodes(cfg)
+			page, err := queryInt(q.Get("page"), 1)
+			if err != nil {
+				http.Error(w, "invalid page: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			limit, err := queryInt(q.Get("limit"), slurmCorrelationsDefaultLimit)
+			if err != nil {
+				http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if limit > slurmCorrelationsMaxLimit {
+				limit = slurmCorrelationsMaxLimit
+			}
+			result, err := store.List(f, page, limit)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, result)
+
+		default:
+			c, err := store.Get(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if c == nil || (t.Nodes(cfg) != nil && !t.Nodes(cfg)[c.Node]) {
+				http.Error(w, "correlation not found", http.StatusNotFound)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, c)
+		}
+	})
+
+	mux := newAPIMux()
+	v1 := mux.Version("v1")
+	v1.Handle("/correlations", handler)
+	v1.Handle("/correlations/", handler)
+
+	fmt.Printf("%s Serving job-GPU correlations on http://%s/api/v1/correlations\n", green("✓"), slurmServeListen)
+	return http.ListenAndServe(slurmServeListen, mux)
+}
+
+// correlationFilter builds a filter from the query of a list request,
+// rejecting unknown correlation types so a typo does not read as "none"
+func correlationFilter(q url.Values) (slurm.CorrelationFilter, error) {
+	f := slurm.CorrelationFilter{Node: q.Get("node")}
+	if job := q.Get("job"); job != "" {
+		id, err := strconv.ParseInt(job, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("invalid job: %s", job)
+		}
+		f.JobID = id
+	}
+	if c := q.Get("correlation"); c != "" {
+		f.Correlation = slurm.CorrelationType(c)
+		if err := (slurm.CorrelationLog{Node: "-", JobID: 1, Correlation: f.Correlation}).Validate(); err != nil {
+			return f, err
+		}
+	}
+	if since := q.Get("since"); since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return f, err
+		}
+		f.Since = t
+	}
+	return f, nil
+}
+
+// decodeCorrelations reads a POST body: either one correlation or
+// {"correlations": [...]}
+func decodeCorrelations(r *http.Request) ([]slurm.CorrelationLog, error) {
+	data, err := readLimited(r, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch struct {
+		Correlations []slurm.CorrelationLog `json:"correlations"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("parse request: %v", err)
+	}
+	if batch.Correlations == nil {
+		var single slurm.CorrelationLog
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, fmt.Errorf("parse request: %v", err)
+		}
+		batch.Correlations = []slurm.CorrelationLog{single}
+	}
+	if len(batch.Correlations) == 0 {
+		return nil, fmt.Errorf("no correlations")
+	}
+	if len(batch.Correlations) > slurmCorrelationsMaxBatch {
+		return nil, fmt.Errorf("too many correlations: %d (at most %d per request)", len(batch.Correlations), slurmCorrelationsMaxBatch)
+	}
+	return batch.Correlations, nil
+}
+
+// formatGPUAllocations lists GPUs by node, e.g. "gpu-01: GPU 0, GPU 1"
+func formatGPUAllocations(allocs []slurm.GPUAllocation) string {
+	var nodes []string
+	byNode := make(map[string][]string)
+	for _, a := range allocs {
+		if _, ok := byNode[a.Node]; !ok {
+			nodes = append(nodes, a.Node)
+		}
+		byNode[a.Node] = append(byNode[a.Node], a.Label())
+	}
+	parts := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		parts = append(parts, fmt.Sprintf("%s: %s", node, strings.Join(byNode[node], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
 func colorJobState(state slurm.JobState) string {
 	switch state {
 	case slurm.JobStateCompleted:
@@ -625,3 +1711,140 @@ func truncate(s string, maxLen int) string {
 	}
 	return s[:maxLen-3] + "..."
 }
+
+func runSlurmUsage(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(slurmUsageOutput, usageCSV)
+	if err != nil {
+		return err
+	}
+	if slurmUsageBy != slurm.UsageByUser && slurmUsageBy != slurm.UsageByAccount {
+		return fmt.Errorf("invalid --by %q (valid: %s, %s)", slurmUsageBy, slurm.UsageByUser, slurm.UsageByAccount)
+	}
+	period, err := chatops.ParseDuration(slurmUsageRange)
+	if err != nil || period <= 0 {
+		return fmt.Errorf("invalid range %q: expected a duration such as 7d or 30d", slurmUsageRange)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	slurmClient, err := slurmClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	promURL := prometheusURL(cfg)
+	analyzer := slurm.NewAnalyzer(slurmClient, promURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	until := time.Now().Truncate(time.Minute)
+	report, err := analyzer.Usage(ctx, until.Add(-period), until, slurmUsageBy, !slurmUsageNoEfficiency)
+	if err != nil {
+		return fmt.Errorf("usage failed: %w", err)
+	}
+
+	switch {
+	case format.Structured():
+		return writeOutput(format, report)
+	case format == usageCSV:
+		return writeUsageCSV(report, !slurmUsageNoEfficiency)
+	}
+
+	fmt.Printf("GPU usage by %s, %s to %s\n\n", report.By,
+		report.Since.Local().Format("2006-01-02 15:04"), report.Until.Local().Format("2006-01-02 15:04"))
+	if len(report.Rows) == 0 {
+		fmt.Println("No GPU jobs in this period.")
+		return nil
+	}
+
+	header := []string{report.By, "Jobs", "GPU Hours", "Share"}
+	if !slurmUsageNoEfficiency {
+		header = append(header, "Efficiency", "Idle GPU Hours")
+	}
+	row := func(r slurm.UsageRow) []string {
+		share := 0.0
+		if report.Total.GPUHours > 0 {
+			share = 100 * r.GPUHours / report.Total.GPUHours
+		}
+		cells := []string{r.Key, strconv.Itoa(r.Jobs), fmt.Sprintf("%.1f", r.GPUHours), fmt.Sprintf("%.1f%%", share)}
+		if !slurmUsageNoEfficiency {
+			cells = append(cells, formatEfficiency(r), formatIdleHours(r))
+		}
+		return cells
+	}
+
+	table := newTable()
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, r := range report.Rows {
+		table.Append(row(r))
+	}
+	table.SetFooter(row(report.Total))
+	table.Render()
+	return nil
+}
+
+// formatEfficiency colors the efficiency of a usage row, or "-" when none
+// of its GPU hours were measured
+func formatEfficiency(r slurm.UsageRow) string {
+	if r.MeasuredGPUH == 0 {
+		return "-"
+	}
+	s := fmt.Sprintf("%.1f%%", r.Efficiency)
+	switch {
+	case r.Efficiency < 30:
+		return color.New(color.FgRed).Sprint(s)
+	case r.Efficiency < 60:
+		return color.New(color.FgYellow).Sprint(s)
+	default:
+		return color.New(color.FgGreen).Sprint(s)
+	}
+}
+
+func formatIdleHours(r slurm.UsageRow) string {
+	if r.MeasuredGPUH == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", r.IdleGPUHours)
+}
+
+// usageCSV is the billing format of aami slurm usage
+const usageCSV output.Format = "csv"
+
+// writeUsageCSV writes a usage report as CSV for billing: a header, a row
+// per user or account and the total. Efficiency columns are empty for rows
+// without measured GPU hours.
+func writeUsageCSV(report *slurm.UsageReport, efficiency bool) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{report.By, "jobs", "gpu_hours", "period_start", "period_end"}
+	if efficiency {
+		header = append(header, "efficiency_percent", "measured_gpu_hours", "idle_gpu_hours")
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	start := report.Since.UTC().Format(time.RFC3339)
+	end := report.Until.UTC().Format(time.RFC3339)
+	for _, r := range append(report.Rows, report.Total) {
+		record := []string{r.Key, strconv.Itoa(r.Jobs), strconv.FormatFloat(r.GPUHours, 'f', 2, 64), start, end}
+		if efficiency {
+			if r.MeasuredGPUH > 0 {
+				record = append(record,
+					strconv.FormatFloat(r.Efficiency, 'f', 2, 64),
+					strconv.FormatFloat(r.MeasuredGPUH, 'f', 2, 64),
+					strconv.FormatFloat(r.IdleGPUHours, 'f', 2, 64))
+			} else {
+				record = append(record, "", "", "")
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
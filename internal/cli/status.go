@@ -3,11 +3,15 @@ package cli
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/i18n"
 )
 
 var statusCmd = &cobra.Command{
@@ -38,48 +42,52 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	bold := color.New(color.Bold).SprintFunc()
 
 	fmt.Println()
-	fmt.Printf("%s\n", bold("AAMI Status"))
+	fmt.Printf("%s\n", bold(i18n.T("AAMI Status")))
 	fmt.Println(strings.Repeat("─", 40))
 
 	// Cluster info
-	fmt.Printf("\n%s\n", bold("Cluster"))
-	fmt.Printf("  Name:  %s\n", cfg.Cluster.Name)
-	fmt.Printf("  Nodes: %d\n", len(cfg.Nodes))
+	fmt.Printf("\n%s\n", bold(i18n.T("Cluster")))
+	fmt.Println(i18n.T("  Name:  %s", cfg.Cluster.Name))
+	fmt.Println(i18n.T("  Nodes: %d", len(cfg.Nodes)))
+	if c := currentContext(); c.Name != "" {
+		fmt.Println(i18n.T("  Context: %s", c.Name))
+	}
 
 	// Alert presets
 	if len(cfg.Alerts.Presets) > 0 {
-		fmt.Printf("  Alert Presets: %s\n", strings.Join(cfg.Alerts.Presets, ", "))
+		fmt.Println(i18n.T("  Alert Presets: %s", strings.Join(cfg.Alerts.Presets, ", ")))
 	}
 
 	// Components
-	fmt.Printf("\n%s\n", bold("Components"))
+	fmt.Printf("\n%s\n", bold(i18n.T("Components")))
 
-	promURL := fmt.Sprintf("http://localhost:%d/-/ready", cfg.Prometheus.Port)
-	checkComponent("Prometheus", promURL, cfg.Prometheus.Port, green, red, yellow)
+	promURL := prometheusURL(cfg)
+	checkComponent("Prometheus", promURL+"/-/ready", urlPort(promURL), green, red, yellow)
 
-	checkComponent("Alertmanager", "http://localhost:9093/-/ready", 9093, green, red, yellow)
+	amURL := alertmanagerURL(nil, "")
+	checkComponent("Alertmanager", amURL+"/-/ready", urlPort(amURL), green, red, yellow)
 
 	grafanaURL := fmt.Sprintf("http://localhost:%d/api/health", cfg.Grafana.Port)
 	checkComponent("Grafana", grafanaURL, cfg.Grafana.Port, green, red, yellow)
 
 	// Notifications
-	fmt.Printf("\n%s\n", bold("Notifications"))
+	fmt.Printf("\n%s\n", bold(i18n.T("Notifications")))
 	if cfg.Notifications.Slack != nil && cfg.Notifications.Slack.Enabled {
-		fmt.Printf("  Slack:   %s (%s)\n", green("enabled"), cfg.Notifications.Slack.Channel)
+		fmt.Printf("  Slack:   %s (%s)\n", green(i18n.T("enabled")), cfg.Notifications.Slack.Channel)
 	} else {
-		fmt.Printf("  Slack:   %s\n", yellow("disabled"))
+		fmt.Printf("  Slack:   %s\n", yellow(i18n.T("disabled")))
 	}
 
 	if cfg.Notifications.Email != nil && cfg.Notifications.Email.Enabled {
-		fmt.Printf("  Email:   %s\n", green("enabled"))
+		fmt.Printf("  Email:   %s\n", green(i18n.T("enabled")))
 	} else {
-		fmt.Printf("  Email:   %s\n", yellow("disabled"))
+		fmt.Printf("  Email:   %s\n", yellow(i18n.T("disabled")))
 	}
 
 	if cfg.Notifications.Webhook != nil && cfg.Notifications.Webhook.Enabled {
-		fmt.Printf("  Webhook: %s\n", green("enabled"))
+		fmt.Printf("  Webhook: %s\n", green(i18n.T("enabled")))
 	} else {
-		fmt.Printf("  Webhook: %s\n", yellow("disabled"))
+		fmt.Printf("  Webhook: %s\n", yellow(i18n.T("disabled")))
 	}
 
 	fmt.Println()
@@ -87,21 +95,36 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// urlPort returns the port of an address, or the default of its scheme
+func urlPort(rawURL string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
 func checkComponent(name, url string, port int, green, red, yellow func(a ...interface{}) string) {
 	client := &http.Client{Timeout: 2 * time.Second}
 	resp, err := client.Get(url)
 
 	status := ""
 	if err != nil {
-		status = red("not running")
+		status = red(i18n.T("not running"))
 	} else {
 		defer resp.Body.Close()
 		if resp.StatusCode == 200 {
-			status = green("running")
+			status = green(i18n.T("running"))
 		} else {
-			status = yellow(fmt.Sprintf("unhealthy (%d)", resp.StatusCode))
+			status = yellow(i18n.T("unhealthy (%d)", resp.StatusCode))
 		}
 	}
 
-	fmt.Printf("  %-12s %s (port %d)\n", name+":", status, port)
+	fmt.Printf("  %-12s %s %s\n", name+":", status, i18n.T("(port %d)", port))
 }
@@ -0,0 +1,309 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/backup"
+	"github.com/fregataa/aami/internal/chatops"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/prometheus"
+	"github.com/fregataa/aami/internal/storage"
+)
+
+var storageDryRun bool
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manage where rule files and backups are stored",
+	Long: `Manage where generated rule files and backups are stored.
+
+Rule files are always written to /etc/aami/rules. With storage.rules set to
+s3 or gcs, every rule file AAMI writes is also uploaded to the bucket, for
+containerized Prometheus setups that load rules synced by a sidecar.
+Backups are uploaded to storage.backups and pruned by its lifecycle
+(keep_last, max_age) after each 'aami backup create'.
+
+Examples:
+  aami storage status
+  aami storage sync --dry-run
+  aami storage list rules
+  aami storage list backups`,
+}
+
+var storageStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the storage backends in use",
+	Args:  cobra.NoArgs,
+	RunE:  runStorageStatus,
+}
+
+var storageSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Upload all rule files to the rules bucket",
+	Long: `Upload every rule file in /etc/aami/rules to the rules bucket and
+remove the rule files in the bucket that no longer exist locally, so the
+bucket matches what a local Prometheus would load. Use it after enabling
+object storage, or after editing rule files by hand.`,
+	Args: cobra.NoArgs,
+	RunE: runStorageSync,
+}
+
+var storageListCmd = &cobra.Command{
+	Use:       "list <rules|backups>",
+	Short:     "List stored rule files or backups",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"rules", "backups"},
+	RunE:      runStorageList,
+}
+
+func init() {
+	storageSyncCmd.Flags().BoolVar(&storageDryRun, "dry-run", false,
+		"Show what would be uploaded and removed")
+
+	storageCmd.AddCommand(storageStatusCmd)
+	storageCmd.AddCommand(storageSyncCmd)
+	storageCmd.AddCommand(storageListCmd)
+	rootCmd.AddCommand(storageCmd)
+}
+
+// ruleStorage returns the bucket rule files are uploaded to, or nil when
+// storage.rules keeps them local only
+func ruleStorage(cfg *config.Config) (storage.Backend, error) {
+	if !storage.Remote(cfg.Storage.Rules) {
+		return nil, nil
+	}
+	return storage.New(cfg.Storage.Rules, prometheus.RulesDir)
+}
+
+// backupStorage returns the bucket backups are uploaded to, or nil when
+// storage.backups keeps them local only
+func backupStorage(cfg *config.Config) (storage.Backend, error) {
+	if !storage.Remote(cfg.Storage.Backups.ObjectStoreConfig) {
+		return nil, nil
+	}
+	return storage.New(cfg.Storage.Backups.ObjectStoreConfig, backupDir(cfg))
+}
+
+// backupDir returns the local backup directory
+func backupDir(cfg *config.Config) string {
+	if cfg != nil && cfg.Storage.Backups.Path != "" {
+		return cfg.Storage.Backups.Path
+	}
+	return backup.DefaultBackupDir
+}
+
+// backupLifecycle returns storage.backups' keep_last and max_age
+func backupLifecycle(cfg *config.Config) (backup.Lifecycle, error) {
+	l := backup.Lifecycle{KeepLast: cfg.Storage.Backups.KeepLast}
+	if cfg.Storage.Backups.MaxAge != "" {
+		d, err := chatops.ParseDuration(cfg.Storage.Backups.MaxAge)
+		if err != nil {
+			return backup.Lifecycle{}, fmt.Errorf("storage.backups.max_age: %w", err)
+		}
+		l.MaxAge = d
+	}
+	return l, nil
+}
+
+// publishRuleFile uploads a rule file just written under /etc/aami/rules to
+// the rules bucket. It returns where the file was uploaded, or "" when rules
+// are kept local only.
+func publishRuleFile(cfg *config.Config, rulePath string, content []byte) (string, error) {
+	if cfg == nil {
+		return "", nil
+	}
+	backend, err := ruleStorage(cfg)
+	if err != nil || backend == nil {
+		return "", err
+	}
+	key, err := prometheus.RuleKey(rulePath)
+	if err != nil {
+		return "", err
+	}
+	if err := backend.Put(key, content); err != nil {
+		return "", err
+	}
+	return backend.Location(key), nil
+}
+
+// storageDescription describes a storage backend for status output
+func storageDescription(c config.ObjectStoreConfig, local string) string {
+	if !storage.Remote(c) {
+		return i18n.T("local (%s)", local)
+	}
+	scheme := "s3"
+	if c.Backend == storage.BackendGCS {
+		scheme = "gs"
+	}
+	location := fmt.Sprintf("%s://%s/%s", scheme, c.Bucket, strings.Trim(c.Prefix, "/"))
+	if c.Endpoint != "" {
+		location += " (" + c.Endpoint + ")"
+	}
+	return location
+}
+
+func runStorageStatus(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	lifecycle, err := backupLifecycle(cfg)
+	if err != nil {
+		return err
+	}
+
+	rules := storageDescription(cfg.Storage.Rules, prometheus.RulesDir)
+	if storage.Remote(cfg.Storage.Rules) {
+		rules = i18n.T("%s, copied from %s", rules, prometheus.RulesDir)
+	}
+	fmt.Printf("%-10s %s\n", i18n.T("Rules:"), rules)
+	backups := storageDescription(cfg.Storage.Backups.ObjectStoreConfig, backupDir(cfg))
+	if storage.Remote(cfg.Storage.Backups.ObjectStoreConfig) {
+		backups = i18n.T("%s, copied from %s", backups, backupDir(cfg))
+	}
+	fmt.Printf("%-10s %s\n", i18n.T("Backups:"), backups)
+
+	var policy []string
+	if lifecycle.KeepLast > 0 {
+		policy = append(policy, i18n.T("keep last %d", lifecycle.KeepLast))
+	}
+	if lifecycle.MaxAge > 0 {
+		policy = append(policy, i18n.T("prune after %s", cfg.Storage.Backups.MaxAge))
+	}
+	if len(policy) == 0 {
+		policy = append(policy, i18n.T("keep all"))
+	}
+	fmt.Printf("%-10s %s\n", i18n.T("Lifecycle:"), strings.Join(policy, ", "))
+	return nil
+}
+
+func runStorageSync(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	backend, err := ruleStorage(cfg)
+	if err != nil {
+		return err
+	}
+	if backend == nil {
+		return fmt.Errorf("storage.rules keeps rule files in %s only; set it to s3 or gcs to sync them", prometheus.RulesDir)
+	}
+
+	local, err := prometheus.LocalRuleFiles()
+	if err != nil {
+		return err
+	}
+	remote, err := backend.List("")
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(local))
+	for key := range local {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	uploaded := 0
+	for _, key := range keys {
+		if storageDryRun {
+			fmt.Printf("  + %s\n", backend.Location(key))
+			uploaded++
+			continue
+		}
+		content, err := os.ReadFile(local[key])
+		if err != nil {
+			return fmt.Errorf("read rules file: %w", err)
+		}
+		if err := backend.Put(key, content); err != nil {
+			return err
+		}
+		uploaded++
+	}
+
+	removed := 0
+	for _, obj := range remote {
+		if _, ok := local[obj.Key]; ok || !isRuleKey(obj.Key) {
+			continue
+		}
+		if storageDryRun {
+			fmt.Printf("  - %s\n", backend.Location(obj.Key))
+			removed++
+			continue
+		}
+		if err := backend.Delete(obj.Key); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	if storageDryRun {
+		fmt.Println(i18n.T("Dry run: %d rule file(s) to upload, %d to remove", uploaded, removed))
+		return nil
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Uploaded %d rule file(s) to %s, removed %d", uploaded, backend.Location(""), removed))
+	return nil
+}
+
+// isRuleKey reports whether a key in the rules bucket is a rule file AAMI
+// manages, so sync leaves other objects under the prefix alone
+func isRuleKey(key string) bool {
+	ext := path.Ext(key)
+	return (ext == ".yaml" || ext == ".yml") && strings.Count(key, "/") <= 1
+}
+
+func runStorageList(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	var backend storage.Backend
+	switch args[0] {
+	case "rules":
+		backend, err = ruleStorage(cfg)
+		if err == nil && backend == nil {
+			backend = storage.NewLocal(prometheus.RulesDir)
+		}
+	case "backups":
+		backend, err = storage.New(cfg.Storage.Backups.ObjectStoreConfig, backupDir(cfg))
+	default:
+		return fmt.Errorf("unknown storage %q (valid: rules, backups)", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	objects, err := backend.List("")
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		fmt.Println(i18n.T("Nothing stored in %s", backend.Location("")))
+		return nil
+	}
+
+	fmt.Println(backend.Location(""))
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Key"), i18n.T("Size"), i18n.T("Modified")})
+	for _, obj := range objects {
+		table.Append([]string{
+			obj.Key,
+			formatSize(obj.Size),
+			obj.Modified.Local().Format("2006-01-02 15:04"),
+		})
+	}
+	table.Render()
+	return nil
+}
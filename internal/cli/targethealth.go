@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/tenant"
+)
+
+// targetHealthCheckWindow is how far back check results count towards a
+// target's health score
+const targetHealthCheckWindow = 24 * time.Hour
+
+// errTargetNotFound is returned for a target that is neither on the config
+// server nor a configured node
+var errTargetNotFound = errors.New("target not found")
+
+// nodeTarget is a target of the target API: a config server target, or a
+// configured node without a config server
+type nodeTarget struct {
+	ID       string
+	Hostname string
+	Address  string // IP address, if known
+}
+
+// targetHealthWeights returns health.weights, or the defaults if none is set
+func targetHealthWeights(cfg *config.Config) health.TargetWeights {
+	w := cfg.Health.Weights
+	if w == (config.HealthWeights{}) {
+		return health.DefaultTargetWeights()
+	}
+	return health.TargetWeights{GPU: w.GPU, Alerts: w.Alerts, Checks: w.Checks}
+}
+
+// findNodeTarget resolves a target by ID or hostname on the config server
+// when one is configured, else by configured node name
+func findNodeTarget(ctx context.Context, cfg *config.Config, ref string) (nodeTarget, error) {
+	serverURL, err := configServerURL(cfg, "")
+	if err != nil {
+		return nodeTarget{}, err
+	}
+	if serverURL == "" {
+		node, ok := findNode(cfg, ref)
+		if !ok {
+			return nodeTarget{}, fmt.Errorf("%w: %s", errTargetNotFound, ref)
+		}
+		return nodeTarget{ID: node.Name, Hostname: node.Name, Address: node.IP}, nil
+	}
+
+	client, err := configServerClient(cfg, "")
+	if err != nil {
+		return nodeTarget{}, err
+	}
+	targets, err := client.ListTargets(ctx)
+	if err != nil {
+		return nodeTarget{}, fmt.Errorf("list targets: %w", err)
+	}
+	for _, t := range targets {
+		if t.ID == ref || t.Hostname == ref {
+			return nodeTarget{ID: t.ID, Hostname: t.Hostname, Address: t.IPAddress}, nil
+		}
+	}
+	return nodeTarget{}, fmt.Errorf("%w: %s", errTargetNotFound, ref)
+}
+
+// matches reports whether a host, such as that of a Prometheus instance,
+// is the target
+func (t nodeTarget) matches(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host != "" && (host == t.Hostname || host == t.Address)
+}
+
+// targetHealth scores a target from its GPU metrics in Prometheus, its
+// firing alerts in Alertmanager and its latest check results
+func targetHealth(cfg *config.Config, t nodeTarget, amURL string) (health.TargetHealth, error) {
+	in := health.TargetInputs{Target: t.ID, Hostname: t.Hostname}
+
+	nodes, err := health.NewPrometheusClient(prometheusURL(cfg)).CollectAllMetrics()
+	if err != nil {
+		return health.TargetHealth{}, fmt.Errorf("collect GPU metrics: %w", err)
+	}
+	for i := range nodes {
+		if t.matches(nodes[i].NodeName) {
+			in.GPUs = &nodes[i]
+			break
+		}
+	}
+
+	alerts, err := alertmanager.NewClient(amURL).ListAlerts()
+	if err != nil {
+		return health.TargetHealth{}, fmt.Errorf("list alerts: %w", err)
+	}
+	// Silenced and inhibited alerts do not count
+	for _, a := range alerts {
+		if a.Status.State == "active" && (a.Labels["node"] == t.Hostname || t.matches(a.Labels["instance"])) {
+			in.Alerts = append(in.Alerts, a.Labels["severity"])
+		}
+	}
+
+	summary, err := newCheckResultStore().Summary(checkresult.Filter{
+		Target: t.Hostname,
+		Since:  time.Now().Add(-targetHealthCheckWindow),
+	})
+	if err != nil {
+		return health.TargetHealth{}, fmt.Errorf("read check results: %w", err)
+	}
+	for _, c := range summary.Checks {
+		in.Passing += c.Passing
+		in.Failing += c.Failing
+	}
+
+	return health.NewCalculator().CalculateTargetHealth(in, targetHealthWeights(cfg)), nil
+}
+
+// serveTargetHealth serves GET /api/v1/targets/<id>/health. The config is
+// reloaded on every request, so weight changes apply without a restart.
+func serveTargetHealth(addr, amURL string) error {
+	mux := newAPIMux()
+	mux.Version("v1").HandleFunc("/targets/", targetAPIHandler("health",
+		func(w http.ResponseWriter, r *http.Request, cfg *config.Config, t nodeTarget) {
+			th, err := targetHealth(cfg, t, amURL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(th)
+		}))
+
+	fmt.Printf("%s Serving target health on http://%s/api/v1/targets/<id>/health\n", color.GreenString("✓"), addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// targetAPIHandler handles GET /targets/<id>/<resource>, passing serve the
+// config and the target, which is looked up on every request. Requests
+// authenticate as for the check result API: check_results.token for every
+// target, an API key or OIDC user for the targets of its namespace; other
+// targets look as if they did not exist.
+func targetAPIHandler(resource string, serve func(w http.ResponseWriter, r *http.Request, cfg *config.Config, t nodeTarget)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/targets/"), "/"+resource)
+		if !ok || id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tn, err := tenant.Authenticate(cfg, r, cfg.CheckResults.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), configServerTimeout)
+		defer cancel()
+		target, err := findNodeTarget(ctx, cfg, id)
+		if errors.Is(err, errTargetNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if nodes := tn.Nodes(cfg); nodes != nil && !nodes[target.Hostname] {
+			http.Error(w, fmt.Sprintf("%v: %s", errTargetNotFound, id), http.StatusNotFound)
+			return
+		}
+		serve(w, r, cfg, target)
+	}
+}
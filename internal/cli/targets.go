@@ -0,0 +1,596 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/configserver"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/selector"
+)
+
+var (
+	targetsConfigServerURL string
+	targetsOutput          string
+	targetsGroup           string
+	targetsSelector        string
+	targetsStatus          string
+	targetsFile            string
+	targetsDelete          bool
+)
+
+var targetsCmd = &cobra.Command{
+	Use:   "targets",
+	Short: "Manage the config server's targets",
+	Long: `Manage the inventory of the config server: its targets (monitored nodes),
+their labels, annotations and groups, and their decommissioning.
+
+Commands changing targets take hostnames (or target IDs) as arguments, or
+a file of them with -f: one per line, where blank lines and # comments
+are skipped, only the first field counts, and - is stdin.
+
+The config server and token come from the active context (see aami
+context), else slurm.prolog.config_server_url or config_server_url of
+/etc/aami/agent.yaml, with admin.token.
+
+Examples:
+  aami targets list -l rack=a12
+  aami targets get gpu-01          # with the results of its probes
+  aami targets label gpu-01 gpu-02 rack=a12 row-
+  aami targets label -f nodes.txt rack=a12
+  aami targets annotate gpu-01 owner=ml-team
+  aami targets group add gpu-servers -f nodes.txt
+  aami targets decommission gpu-07`,
+}
+
+var targetsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List targets",
+	Args:  cobra.NoArgs,
+	RunE:  runTargetsList,
+}
+
+var targetsGetCmd = &cobra.Command{
+	Use:               "get <target>",
+	Short:             "Show a target",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runTargetsGet,
+}
+
+var targetsLabelCmd = &cobra.Command{
+	Use:   "label [<target>...] <key=value|key->...",
+	Short: "Set or remove labels of targets",
+	Long: `Set labels of targets with key=value, and remove them with key-.
+Arguments with = or ending in - are changes; the others are targets.
+
+Examples:
+  aami targets label gpu-01 rack=a12
+  aami targets label gpu-01 gpu-02 rack=a12 row-
+  aami targets label -f nodes.txt rack=a12`,
+	ValidArgsFunction: completeTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTargetsEditMap(args, "labels", "labeled", func(req *configserver.TargetRequest) map[string]string {
+			return req.Labels
+		})
+	},
+}
+
+var targetsAnnotateCmd = &cobra.Command{
+	Use:   "annotate [<target>...] <key=value|key->...",
+	Short: "Set or remove annotations of targets",
+	Long: `Set annotations of targets with key=value, and remove them with key-.
+Annotations hold information for people and tools (owners, tickets,
+purchase dates); unlike labels, they are not used to select targets.
+
+Examples:
+  aami targets annotate gpu-01 owner=ml-team ticket=OPS-142
+  aami targets annotate -f nodes.txt ticket-`,
+	ValidArgsFunction: completeTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTargetsEditMap(args, "annotations", "annotated", func(req *configserver.TargetRequest) map[string]string {
+			return req.Annotations
+		})
+	},
+}
+
+var targetsGroupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Add targets to groups or remove them",
+}
+
+var targetsGroupAddCmd = &cobra.Command{
+	Use:               "add <group> [<target>...]",
+	Short:             "Add targets to a group",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeGroupThenTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTargetsGroup(args, true)
+	},
+}
+
+var targetsGroupRemoveCmd = &cobra.Command{
+	Use:               "remove <group> [<target>...]",
+	Short:             "Remove targets from a group",
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeGroupThenTargets,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runTargetsGroup(args, false)
+	},
+}
+
+var targetsDecommissionCmd = &cobra.Command{
+	Use:   "decommission [<target>...]",
+	Short: "Mark targets inactive",
+	Long: `Mark targets inactive, so they are no longer scraped or alerted on, and
+with --delete also delete them. The config server keeps deleted targets
+until they are purged, so they can be restored there.
+
+Examples:
+  aami targets decommission gpu-07
+  aami targets decommission -f retired.txt --delete`,
+	ValidArgsFunction: completeTargets,
+	RunE:              runTargetsDecommission,
+}
+
+func init() {
+	targetsCmd.PersistentFlags().StringVar(&targetsConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+
+	targetsListCmd.Flags().StringVar(&targetsGroup, "group", "", "Only targets of this group (ID or name)")
+	targetsListCmd.Flags().StringVarP(&targetsSelector, "selector", "l", "",
+		"Label selector (e.g. rack=a12,gpu!=a100)")
+	targetsListCmd.Flags().StringVar(&targetsStatus, "status", "", "Only targets with this status (active, inactive)")
+	targetsListCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	targetsListCmd.RegisterFlagCompletionFunc("status", cobra.FixedCompletions(
+		[]string{configserver.TargetActive, configserver.TargetInactive}, cobra.ShellCompDirectiveNoFileComp))
+	addOutputFlag(targetsListCmd, &targetsOutput)
+	addOutputFlag(targetsGetCmd, &targetsOutput)
+
+	for _, c := range []*cobra.Command{targetsLabelCmd, targetsAnnotateCmd, targetsGroupAddCmd, targetsGroupRemoveCmd, targetsDecommissionCmd} {
+		c.Flags().StringVarP(&targetsFile, "file", "f", "", "File of targets, one per line (- for stdin)")
+	}
+	targetsDecommissionCmd.Flags().BoolVar(&targetsDelete, "delete", false, "Also delete the targets")
+
+	targetsGroupCmd.AddCommand(targetsGroupAddCmd)
+	targetsGroupCmd.AddCommand(targetsGroupRemoveCmd)
+
+	targetsCmd.AddCommand(targetsListCmd)
+	targetsCmd.AddCommand(targetsGetCmd)
+	targetsCmd.AddCommand(targetsLabelCmd)
+	targetsCmd.AddCommand(targetsAnnotateCmd)
+	targetsCmd.AddCommand(targetsGroupCmd)
+	targetsCmd.AddCommand(targetsDecommissionCmd)
+	rootCmd.AddCommand(targetsCmd)
+}
+
+func runTargetsList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(targetsOutput)
+	if err != nil {
+		return err
+	}
+	sel, err := selector.Parse(targetsSelector)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(targetsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	var targets []configserver.Target
+	if targetsGroup != "" {
+		group, err := client.FindGroup(ctx, targetsGroup)
+		if err != nil {
+			return err
+		}
+		targets, err = client.ListTargetsByGroup(ctx, group.ID)
+		if err != nil {
+			return err
+		}
+	} else if targets, err = client.ListTargets(ctx); err != nil {
+		return err
+	}
+
+	filtered := []configserver.Target{}
+	for _, t := range targets {
+		if targetsStatus != "" && t.Status != targetsStatus {
+			continue
+		}
+		if !sel.Matches(t.Labels) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	sort.Slice(filtered, func(i, j int) bool { return filtered[i].Hostname < filtered[j].Hostname })
+
+	if format.Structured() {
+		return writeOutput(format, filtered)
+	}
+	if len(filtered) == 0 {
+		fmt.Println("No targets.")
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Hostname"},
+		{Header: "IP"},
+		{Header: "Status"},
+		{Header: "Groups"},
+		{Header: "Labels"},
+		{Header: "ID", Wide: true},
+		{Header: "Port", Wide: true},
+		{Header: "Annotations", Wide: true},
+		{Header: "Updated", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, t := range filtered {
+		port := "-"
+		if t.Port != 0 {
+			port = strconv.Itoa(t.Port)
+		}
+		table.Append(columns.Row(format,
+			t.Hostname,
+			t.IPAddress,
+			colorTargetStatus(t.Status),
+			defaultString(targetGroupNames(t), "-"),
+			formatLabels(t.Labels),
+			t.ID,
+			port,
+			formatLabels(t.Annotations),
+			t.UpdatedAt.Local().Format("2006-01-02 15:04"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+func runTargetsGet(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(targetsOutput)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(targetsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	t, err := client.FindTarget(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	probes, probesErr := targetProbeResults(t)
+	if format.Structured() {
+		return writeOutput(format, targetDetail{Target: t, Probes: probes})
+	}
+
+	bold := color.New(color.Bold).SprintFunc()
+	fmt.Printf("\n%s %s\n", bold(t.Hostname), t.ID)
+	fmt.Printf("  IP:          %s\n", t.IPAddress)
+	if t.Port != 0 {
+		fmt.Printf("  Port:        %d\n", t.Port)
+	}
+	fmt.Printf("  Status:      %s\n", colorTargetStatus(t.Status))
+	fmt.Printf("  Groups:      %s\n", defaultString(targetGroupNames(*t), "-"))
+	fmt.Printf("  Labels:      %s\n", formatLabels(t.Labels))
+	fmt.Printf("  Annotations: %s\n", formatLabels(t.Annotations))
+	fmt.Printf("  Probes:      %s\n", formatTargetProbes(probes))
+	if probesErr != nil {
+		fmt.Printf("  %s Probe results unavailable: %v\n", color.YellowString("⚠"), probesErr)
+	}
+	fmt.Printf("  Updated:     %s\n", t.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Println()
+	return nil
+}
+
+// targetDetail is a target with the results of its probes
+type targetDetail struct {
+	*configserver.Target
+	Probes []probeResult `json:"probes,omitempty"`
+}
+
+// targetProbeResults returns the results of the probes of a target from
+// Prometheus. When Prometheus cannot be reached, the probes are returned
+// as unknown along with the error.
+func targetProbeResults(t *configserver.Target) ([]probeResult, error) {
+	probes, err := newProbeStore().List()
+	if err != nil || len(probes) == 0 {
+		return nil, err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return targetProbes(probes, t, nil), err
+	}
+	results, err := queryProbeResults(prometheusURL(cfg))
+	return targetProbes(probes, t, results), err
+}
+
+// formatTargetProbes lists the probes of a target with their states
+func formatTargetProbes(probes []probeResult) string {
+	if len(probes) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(probes))
+	for i, p := range probes {
+		parts[i] = fmt.Sprintf("%s (%s) %s", p.Probe, p.Type, colorProbeState(p.State))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runTargetsEditMap sets and removes the keys of the labels or annotations
+// of targets, given by field
+func runTargetsEditMap(args []string, what, done string, field func(*configserver.TargetRequest) map[string]string) error {
+	refs, changes := splitTargetArgs(args)
+	set, remove, err := parseKeyChanges(changes)
+	if err != nil {
+		return err
+	}
+	if len(set) == 0 && len(remove) == 0 {
+		return fmt.Errorf("no %s to change: give key=value or key-", what)
+	}
+	refs, err = targetRefs(refs)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(targetsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return updateTargets(ctx, client, refs, done, func(req *configserver.TargetRequest) bool {
+		m := field(req)
+		changed := false
+		for k, v := range set {
+			if old, ok := m[k]; !ok || old != v {
+				m[k] = v
+				changed = true
+			}
+		}
+		for _, k := range remove {
+			if _, ok := m[k]; ok {
+				delete(m, k)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+func runTargetsGroup(args []string, add bool) error {
+	refs, err := targetRefs(args[1:])
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(targetsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	group, err := client.FindGroup(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	done := "removed from " + group.Name
+	if add {
+		done = "added to " + group.Name
+	}
+	return updateTargets(ctx, client, refs, done, func(req *configserver.TargetRequest) bool {
+		for i, id := range req.GroupIDs {
+			if id == group.ID {
+				if add {
+					return false
+				}
+				req.GroupIDs = append(req.GroupIDs[:i], req.GroupIDs[i+1:]...)
+				return true
+			}
+		}
+		if !add {
+			return false
+		}
+		req.GroupIDs = append(req.GroupIDs, group.ID)
+		return true
+	})
+}
+
+func runTargetsDecommission(cmd *cobra.Command, args []string) error {
+	refs, err := targetRefs(args)
+	if err != nil {
+		return err
+	}
+	client, ctx, cancel, err := configServerRequest(targetsConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	failed := 0
+	for _, ref := range refs {
+		err := decommissionTarget(ctx, client, ref, targetsDelete)
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", red("✗"), ref, err)
+			failed++
+			continue
+		}
+		if targetsDelete {
+			fmt.Printf("%s %s decommissioned and deleted\n", green("✓"), ref)
+		} else {
+			fmt.Printf("%s %s decommissioned\n", green("✓"), ref)
+		}
+	}
+	return countError(failed, len(refs), "targets")
+}
+
+// decommissionTarget marks a target inactive, and deletes it if del
+func decommissionTarget(ctx context.Context, client *configserver.Client, ref string, del bool) error {
+	t, err := client.FindTarget(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if t.Status != configserver.TargetInactive {
+		if err := client.SetTargetStatus(ctx, t.ID, configserver.TargetInactive); err != nil {
+			return err
+		}
+	}
+	if del {
+		return client.DeleteTarget(ctx, t.ID)
+	}
+	return nil
+}
+
+// updateTargets applies change to each target and saves the ones it
+// changed, reporting each
+func updateTargets(ctx context.Context, client *configserver.Client, refs []string, done string,
+	change func(*configserver.TargetRequest) bool) error {
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+
+	failed := 0
+	for _, ref := range refs {
+		t, err := client.FindTarget(ctx, ref)
+		if err == nil {
+			req := t.Request()
+			if !change(&req) {
+				fmt.Printf("%s %s unchanged\n", yellow("•"), t.Hostname)
+				continue
+			}
+			_, err = client.UpdateTarget(ctx, t.ID, req)
+		}
+		if err != nil {
+			fmt.Printf("%s %s: %v\n", red("✗"), ref, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s %s %s\n", green("✓"), t.Hostname, done)
+	}
+	return countError(failed, len(refs), "targets")
+}
+
+// splitTargetArgs separates the targets among args from the changes:
+// key=value and key-
+func splitTargetArgs(args []string) (refs, changes []string) {
+	for _, arg := range args {
+		if strings.Contains(arg, "=") || strings.HasSuffix(arg, "-") {
+			changes = append(changes, arg)
+		} else {
+			refs = append(refs, arg)
+		}
+	}
+	return refs, changes
+}
+
+// parseKeyChanges parses key=value changes into keys to set, and key- into
+// keys to remove
+func parseKeyChanges(changes []string) (map[string]string, []string, error) {
+	set := make(map[string]string)
+	var remove []string
+	for _, c := range changes {
+		if key, value, ok := strings.Cut(c, "="); ok {
+			if key == "" {
+				return nil, nil, fmt.Errorf("invalid change %q: empty key", c)
+			}
+			set[key] = value
+			continue
+		}
+		key := strings.TrimSuffix(c, "-")
+		if key == "" {
+			return nil, nil, fmt.Errorf("invalid change %q: empty key", c)
+		}
+		remove = append(remove, key)
+	}
+	for _, key := range remove {
+		if _, ok := set[key]; ok {
+			return nil, nil, fmt.Errorf("%s is both set and removed", key)
+		}
+	}
+	return set, remove, nil
+}
+
+// targetRefs returns the targets given as arguments and in --file
+func targetRefs(args []string) ([]string, error) {
+	refs := append([]string(nil), args...)
+	if targetsFile != "" {
+		fromFile, err := readTargetList(targetsFile)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, fromFile...)
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no targets: give hostnames or --file")
+	}
+	return refs, nil
+}
+
+// readTargetList reads a file of targets: the first field of each line,
+// skipping blank lines and # comments. - is stdin.
+func readTargetList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var refs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, strings.Fields(line)[0])
+	}
+	return refs, scanner.Err()
+}
+
+// targetGroupNames lists the names of a target's groups
+func targetGroupNames(t configserver.Target) string {
+	names := make([]string, 0, len(t.Groups))
+	for _, g := range t.Groups {
+		names = append(names, defaultString(g.Name, g.ID))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// colorTargetStatus colors a target status
+func colorTargetStatus(status string) string {
+	switch status {
+	case configserver.TargetActive:
+		return color.GreenString(status)
+	case configserver.TargetInactive:
+		return color.YellowString(status)
+	default:
+		return color.RedString(defaultString(status, "unknown"))
+	}
+}
+
+// completeGroupThenTargets completes a group and then targets
+func completeGroupThenTargets(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return completeGroups(cmd, args, toComplete)
+	}
+	return completeTargets(cmd, args, toComplete)
+}
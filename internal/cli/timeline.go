@@ -0,0 +1,316 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/slurm"
+	"github.com/fregataa/aami/internal/timeline"
+)
+
+var (
+	timelineSince   string
+	timelineUntil   string
+	timelineSources []string
+	timelineOutput  string
+	timelineListen  string
+)
+
+// timelineMaxRecords bounds the check results and job failures read for a
+// timeline, newest first
+const timelineMaxRecords = 10000
+
+// timelineMaxSamples bounds the samples of each alert; longer windows are
+// sampled more coarsely, down to timelineMinStep
+const (
+	timelineMaxSamples = 10000
+	timelineMinStep    = time.Minute
+)
+
+var nodesTimelineCmd = &cobra.Command{
+	Use:   "timeline <host>",
+	Short: "Show what happened on a node, in order",
+	Long: `Show the events of a node in chronological order: alerts firing and
+resolving (from the ALERTS series of Prometheus), checks starting to fail
+and passing again, drains and resumes (from the drain audit log), and jobs
+that failed (from the job-GPU correlations of the epilog hook).
+
+<host> is a config server target ID or hostname, or a node name without a
+config server. A source that cannot be read is reported and the others are
+still shown.
+
+--listen serves the timeline of each target on
+GET /api/v1/targets/<id>/timeline, with since, until and source query
+parameters. Requests authenticate with "Authorization: Bearer
+<check_results.token>"; API keys and SSO users see only the targets of
+their namespace.
+
+Examples:
+  aami node timeline gpu-node-01
+  aami node timeline gpu-node-01 --since 7d --source alerts --source drains
+  aami node timeline gpu-node-01 --since 2026-10-15T08:00:00Z --until 2026-10-15T12:00:00Z
+  aami node timeline --listen :8113`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if timelineListen != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	ValidArgsFunction: firstArg(completeTargets),
+	RunE:              runNodesTimeline,
+}
+
+func init() {
+	nodesTimelineCmd.Flags().StringVar(&timelineSince, "since", "24h",
+		"Start of the timeline: a duration back from now (24h, 7d) or an RFC 3339 time")
+	nodesTimelineCmd.Flags().StringVar(&timelineUntil, "until", "",
+		"End of the timeline, like --since (default: now)")
+	nodesTimelineCmd.Flags().StringSliceVar(&timelineSources, "source", nil,
+		"Only events of these sources: "+strings.Join(timeline.Sources(), ", ")+" (repeatable)")
+	nodesTimelineCmd.Flags().StringVar(&timelineListen, "listen", "",
+		"Serve timelines over HTTP on this address (e.g. :8113)")
+	addOutputFlag(nodesTimelineCmd, &timelineOutput)
+	nodesTimelineCmd.RegisterFlagCompletionFunc("source", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return timeline.Sources(), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	nodesCmd.AddCommand(nodesTimelineCmd)
+}
+
+// timelineWindow parses the since and until of a timeline
+func timelineWindow(since, until string, now time.Time) (time.Time, time.Time, error) {
+	start, err := parseSince(since, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end := now
+	if until != "" {
+		if end, err = parseSince(until, now); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid until %q", until)
+		}
+	}
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("since must be before until")
+	}
+	return start, end, nil
+}
+
+// timelineSourceSet returns the sources to read, all of them if none is
+// given
+func timelineSourceSet(sources []string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, s := range timeline.Sources() {
+		set[s] = len(sources) == 0
+	}
+	for _, s := range sources {
+		if _, ok := set[s]; !ok {
+			return nil, fmt.Errorf("unknown source %q (valid: %s)", s, strings.Join(timeline.Sources(), ", "))
+		}
+		set[s] = true
+	}
+	return set, nil
+}
+
+// buildTimeline reads the events of a target from every source
+func buildTimeline(cfg *config.Config, t nodeTarget, since, until time.Time, sources map[string]bool) *timeline.Timeline {
+	tl := timeline.New(t.ID, t.Hostname, since, until)
+
+	if sources[timeline.SourceAlerts] {
+		step := until.Sub(since) / timelineMaxSamples
+		if step < timelineMinStep {
+			step = timelineMinStep
+		}
+		step = step.Round(time.Second)
+		if series, err := alertSeries(prometheusURL(cfg), t, since, until, step); err != nil {
+			tl.Fail(timeline.SourceAlerts, err)
+		} else {
+			tl.Add(timeline.AlertEvents(series, step, until)...)
+		}
+	}
+
+	if sources[timeline.SourceChecks] {
+		page, err := newCheckResultStore().List(checkresult.Filter{Target: t.Hostname, Since: since, Until: until}, 1, timelineMaxRecords)
+		if err != nil {
+			tl.Fail(timeline.SourceChecks, err)
+		} else {
+			tl.Add(timeline.CheckEvents(page.Results)...)
+		}
+	}
+
+	if sources[timeline.SourceDrains] {
+		records, err := slurm.LoadDrainAudit(slurmDrainAuditLog(cfg))
+		if err != nil {
+			tl.Fail(timeline.SourceDrains, err)
+		} else {
+			var node []slurm.DrainRecord
+			for _, r := range records {
+				if r.Node == t.Hostname {
+					node = append(node, r)
+				}
+			}
+			tl.Add(timeline.DrainEvents(node)...)
+		}
+	}
+
+	if sources[timeline.SourceJobs] {
+		page, err := newCorrelationStore().List(slurm.CorrelationFilter{Node: t.Hostname, Since: since}, 1, timelineMaxRecords)
+		if err != nil {
+			tl.Fail(timeline.SourceJobs, err)
+		} else {
+			tl.Add(timeline.JobEvents(page.Correlations)...)
+		}
+	}
+	return tl
+}
+
+// alertSeries reads when the alerts of a target were firing: those with
+// its node label, or without one and with an instance on its host
+func alertSeries(promURL string, t nodeTarget, since, until time.Time, step time.Duration) ([]timeline.AlertSeries, error) {
+	hosts := []string{regexp.QuoteMeta(t.Hostname)}
+	if t.Address != "" && t.Address != t.Hostname {
+		hosts = append(hosts, regexp.QuoteMeta(t.Address))
+	}
+	query := fmt.Sprintf(`ALERTS{alertstate="firing",node=%q} or ALERTS{alertstate="firing",node="",instance=~%q}`,
+		t.Hostname, "("+strings.Join(hosts, "|")+")(:[0-9]+)?")
+
+	resp, err := health.NewPrometheusClient(promURL).QueryRange(query, since, until, step)
+	if err != nil {
+		return nil, err
+	}
+	series := make([]timeline.AlertSeries, 0, len(resp.Data.Result))
+	for _, r := range resp.Data.Result {
+		s := timeline.AlertSeries{Labels: r.Metric}
+		for _, v := range r.Values {
+			if len(v) < 2 {
+				continue
+			}
+			if ts, ok := v[0].(float64); ok {
+				s.Times = append(s.Times, time.Unix(0, int64(ts*float64(time.Second))))
+			}
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func runNodesTimeline(cmd *cobra.Command, args []string) error {
+	if timelineListen != "" {
+		return serveTimelines(timelineListen)
+	}
+	format, err := output.Parse(timelineOutput)
+	if err != nil {
+		return err
+	}
+	since, until, err := timelineWindow(timelineSince, timelineUntil, time.Now())
+	if err != nil {
+		return err
+	}
+	sources, err := timelineSourceSet(timelineSources)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), configServerTimeout)
+	defer cancel()
+	target, err := findNodeTarget(ctx, cfg, args[0])
+	if err != nil {
+		return err
+	}
+	tl := buildTimeline(cfg, target, since, until, sources)
+
+	if format.Structured() {
+		return writeOutput(format, tl)
+	}
+	for _, source := range timeline.Sources() {
+		if msg, ok := tl.Errors[source]; ok {
+			fmt.Fprintf(os.Stderr, "%s Could not read %s: %s\n", color.YellowString("⚠"), source, msg)
+		}
+	}
+	if len(tl.Events) == 0 {
+		fmt.Printf("No events on %s between %s and %s.\n", target.Hostname,
+			since.Local().Format("2006-01-02 15:04"), until.Local().Format("2006-01-02 15:04"))
+		return nil
+	}
+
+	columns := output.Columns{
+		{Header: "Time"},
+		{Header: "Source"},
+		{Header: "Event"},
+		{Header: "Message"},
+		{Header: "Severity", Wide: true},
+	}
+	table := newTable()
+	table.SetHeader(columns.Headers(format))
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	for _, e := range tl.Events {
+		table.Append(columns.Row(format,
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Source,
+			colorTimelineEvent(e.Type),
+			e.Message,
+			defaultString(e.Severity, "-"),
+		))
+	}
+	table.Render()
+	return nil
+}
+
+// colorTimelineEvent colors an event type: red when something broke, green
+// when it came back
+func colorTimelineEvent(eventType string) string {
+	switch eventType {
+	case timeline.EventAlertFiring, timeline.EventCheckFailed, timeline.EventJobFailed:
+		return color.RedString(eventType)
+	case timeline.EventNodeDrained:
+		return color.YellowString(eventType)
+	default:
+		return color.GreenString(eventType)
+	}
+}
+
+// serveTimelines serves GET /api/v1/targets/<id>/timeline, authenticated
+// and scoped to the tenant's nodes by targetAPIHandler
+func serveTimelines(addr string) error {
+	mux := newAPIMux()
+	mux.Version("v1").HandleFunc("/targets/", targetAPIHandler("timeline",
+		func(w http.ResponseWriter, r *http.Request, cfg *config.Config, t nodeTarget) {
+			q := r.URL.Query()
+			since, until, err := timelineWindow(defaultString(q.Get("since"), "24h"), q.Get("until"), time.Now())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var requested []string
+			for _, s := range q["source"] {
+				requested = append(requested, strings.Split(s, ",")...)
+			}
+			sources, err := timelineSourceSet(requested)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(buildTimeline(cfg, t, since, until, sources))
+		}))
+
+	fmt.Printf("%s Serving timelines on http://%s/api/v1/targets/<id>/timeline\n", color.GreenString("✓"), addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/configserver"
+)
+
+var (
+	tokensConfigServerURL string
+	installCmdServerURL   string
+	installCmdLabels      []string
+	installCmdMaxUses     int
+	installCmdTokenTTL    time.Duration
+)
+
+var tokensCmd = &cobra.Command{
+	Use:     "tokens",
+	Aliases: []string{"bootstrap-tokens"},
+	Short:   "Manage bootstrap tokens",
+	Long: `Work with the config server's bootstrap tokens, which let nodes
+register themselves into a group.`,
+}
+
+var tokensInstallCmdCmd = &cobra.Command{
+	Use:   "install-cmd <group>",
+	Short: "Create a bootstrap token and print the command enrolling a node with it",
+	Long: `Create a bootstrap token for a group (ID or name) and print a shell
+one-liner that enrolls a node with it.
+
+The config server returns a token's secret only when it creates it, so the
+command always creates a new token (single-use by default, see --max-uses)
+and embeds the token returned. Run as root on a node, the one-liner
+downloads bootstrap.sh from the config server, checks it against the
+SHA-256 sum of the script the config server served to this command,
+stopping on a mismatch, and runs it with the token, the server address and
+the labels. Handle the output like the token itself.
+
+Token details go to stderr, so the output can be piped to a node.
+
+Examples:
+  aami tokens install-cmd gpu-servers > enroll.sh
+  aami tokens install-cmd gpu-servers --label rack=a12 | ssh gpu-09 sudo bash
+  aami tokens install-cmd gpu-servers --server-url https://aami.example.com --max-uses 20`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: firstArg(completeGroups),
+	RunE:              runTokensInstallCmd,
+}
+
+func init() {
+	tokensCmd.PersistentFlags().StringVar(&tokensConfigServerURL, "config-server-url", "",
+		"Config server address (default: the context's server, slurm.prolog.config_server_url, then config_server_url of /etc/aami/agent.yaml)")
+
+	tokensInstallCmdCmd.Flags().StringVar(&installCmdServerURL, "server-url", "",
+		"Config server address nodes use (default: the one this command uses)")
+	tokensInstallCmdCmd.Flags().StringArrayVar(&installCmdLabels, "label", nil, "Label of the node as key=value (repeatable)")
+	tokensInstallCmdCmd.Flags().IntVar(&installCmdMaxUses, "max-uses", 1, "How many nodes can enroll with the token")
+	tokensInstallCmdCmd.Flags().DurationVar(&installCmdTokenTTL, "token-ttl", 24*time.Hour, "Lifetime of the bootstrap token")
+
+	tokensCmd.AddCommand(tokensInstallCmdCmd)
+	rootCmd.AddCommand(tokensCmd)
+}
+
+func runTokensInstallCmd(cmd *cobra.Command, args []string) error {
+	labels, err := parseEnrollLabels(installCmdLabels)
+	if err != nil {
+		return err
+	}
+	if installCmdMaxUses < 1 {
+		return fmt.Errorf("--max-uses must be at least 1")
+	}
+	client, ctx, cancel, err := configServerRequest(tokensConfigServerURL)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	group, err := client.FindGroup(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	// Fetched before the token is created, so a config server that does
+	// not serve the script leaves no token behind
+	script, err := client.BootstrapScript(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := client.CreateBootstrapToken(ctx, configserver.BootstrapTokenRequest{
+		Name:        "install-" + group.Name + "-" + time.Now().UTC().Format("20060102150405"),
+		Description: "aami tokens install-cmd",
+		GroupID:     group.ID,
+		ExpiresAt:   time.Now().Add(installCmdTokenTTL).UTC(),
+		MaxUses:     installCmdMaxUses,
+	})
+	if err != nil {
+		return err
+	}
+	if token.Token == "" {
+		return fmt.Errorf("the config server returned bootstrap token %s without its secret", token.ID)
+	}
+	fmt.Fprintf(os.Stderr, "Bootstrap token %s (%s) for group %s, %d use(s), expires %s\n",
+		token.Name, token.ID, group.Name, installCmdMaxUses, token.ExpiresAt.Local().Format(time.RFC3339))
+
+	serverURL := strings.TrimRight(installCmdServerURL, "/")
+	if serverURL == "" {
+		serverURL = client.BaseURL()
+	}
+	fmt.Println(installCommand(serverURL, token.Token, group.ID, labels, script))
+	return nil
+}
+
+// installCommand returns the one-liner that downloads bootstrap.sh, checks
+// it against the SHA-256 sum of script and runs it with the token
+func installCommand(serverURL, token, groupID string, labels map[string]string, script []byte) string {
+	sum := sha256.Sum256(script)
+	run := []string{"bash", `"$f"`,
+		"--token", shellQuote(token),
+		"--server", shellQuote(serverURL),
+		"--group-id", shellQuote(groupID),
+	}
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	for _, pair := range pairs {
+		run = append(run, "--labels", shellQuote(pair))
+	}
+	run = append(run, "--unattended")
+
+	return fmt.Sprintf(`f=$(mktemp) && curl -fsSL %s -o "$f" && echo %s | sha256sum -c --quiet - && %s; rc=$?; rm -f "$f"; exit $rc`,
+		shellQuote(serverURL+"/bootstrap.sh"),
+		shellQuote(hex.EncodeToString(sum[:])+"  ")+`"$f"`,
+		strings.Join(run, " "))
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/slurm"
+	"github.com/fregataa/aami/internal/top"
+)
+
+var (
+	topInterval        time.Duration
+	topAlertmanagerURL string
+	topNoSlurm         bool
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Live dashboard of cluster health",
+	Long: `Show a live dashboard of the cluster in the terminal: the health score,
+hottest GPU and mean GPU utilization of every node (least healthy first),
+the firing alerts, and the pending and running jobs of each Slurm
+partition. It refreshes from Prometheus, Alertmanager and Slurm every
+--interval.
+
+Select a node with the arrow keys (or j/k) and press enter to see its GPUs,
+with their temperature, utilization, health score and the components
+lowering it, and the node's firing alerts. Esc goes back, r refreshes now
+and q quits.
+
+Examples:
+  aami top
+  aami top --interval 10s
+  aami top --no-slurm`,
+	Args: cobra.NoArgs,
+	RunE: runTop,
+}
+
+func init() {
+	topCmd.Flags().DurationVar(&topInterval, "interval", 5*time.Second,
+		"Time between refreshes")
+	topCmd.Flags().StringVar(&topAlertmanagerURL, "alertmanager-url", alertmanager.DefaultURL,
+		"Alertmanager API address")
+	topCmd.Flags().BoolVar(&topNoSlurm, "no-slurm", false,
+		"Do not show the Slurm queue")
+
+	rootCmd.AddCommand(topCmd)
+}
+
+func runTop(cmd *cobra.Command, args []string) error {
+	if topInterval < time.Second {
+		return fmt.Errorf("--interval must be at least 1s")
+	}
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		return fmt.Errorf("aami top needs a terminal; use 'aami health' or 'aami status' in scripts")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	nodeNames := make(map[string]string, len(cfg.Nodes))
+	for _, n := range cfg.Nodes {
+		nodeNames[n.IP] = n.Name
+	}
+
+	// The queue is shown where Slurm is: slurmrestd or the Slurm commands
+	var slurmClient *slurm.Client
+	if _, err := exec.LookPath("squeue"); !topNoSlurm && (err == nil || cfg.Slurm.REST.Endpoint != "") {
+		if slurmClient, err = slurmClientFor(cfg); err != nil {
+			return err
+		}
+		// Warnings on stderr would garble the dashboard
+		slurmClient.OnFallback = nil
+	}
+
+	collector := top.NewCollector(
+		health.NewPrometheusClient(prometheusURL(cfg)),
+		alertmanager.NewClient(alertmanagerURL(cmd, topAlertmanagerURL)),
+		slurmClient,
+		nodeNames,
+	)
+	_, err = tea.NewProgram(top.New(cfg.Cluster.Name, collector, topInterval), tea.WithAltScreen()).Run()
+	return err
+}
@@ -1,25 +1,26 @@
 package cli
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
 	"github.com/fregataa/aami/internal/nvlink"
+	"github.com/fregataa/aami/internal/output"
 	"github.com/fregataa/aami/internal/ssh"
 )
 
 var (
-	topologyOutput   string
-	topologyNoColor  bool
+	topologyOutput     string
 	topologyShowLegend bool
 )
 
+// topologyASCII draws the topology of each node
+const topologyASCII output.Format = "ascii"
+
 var topologyCmd = &cobra.Command{
 	Use:   "topology [node|all]",
 	Short: "Display NVLink topology",
@@ -32,22 +33,25 @@ Examples:
   aami topology gpu-node-01     # Show topology for a specific node
   aami topology all             # Show topology for all nodes
   aami topology --legend        # Show with connection legend`,
-	Args: cobra.MaximumNArgs(1),
-	RunE: runTopology,
+	ValidArgsFunction: firstArg(completeTargets),
+	Args:              cobra.MaximumNArgs(1),
+	RunE:              runTopology,
 }
 
 func init() {
 	rootCmd.AddCommand(topologyCmd)
 
-	topologyCmd.Flags().StringVarP(&topologyOutput, "output", "o", "ascii",
-		"Output format: ascii, table, json")
-	topologyCmd.Flags().BoolVar(&topologyNoColor, "no-color", false,
-		"Disable colored output")
+	topologyCmd.Flags().StringVarP(&topologyOutput, "output", "o", string(topologyASCII),
+		output.Usage(topologyASCII))
 	topologyCmd.Flags().BoolVar(&topologyShowLegend, "legend", false,
 		"Show connection type legend")
 }
 
 func runTopology(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(topologyOutput, topologyASCII)
+	if err != nil {
+		return err
+	}
 	cfg, err := loadConfig()
 	if err != nil {
 		return err
@@ -78,7 +82,7 @@ func runTopology(cmd *cobra.Command, args []string) error {
 		collector.AddNode(node.Name, node.IP, port, node.SSHUser, node.SSHKey)
 	}
 
-	renderer := nvlink.NewRenderer(!topologyNoColor && !color.NoColor)
+	renderer := nvlink.NewRenderer(!color.NoColor)
 
 	// Show legend if requested
 	if topologyShowLegend {
@@ -109,56 +113,52 @@ func runTopology(cmd *cobra.Command, args []string) error {
 
 	// Collect and render
 	if len(targetNodes) == 1 {
-		return renderSingleNode(collector, renderer, targetNodes[0])
+		return renderSingleNode(collector, renderer, format, targetNodes[0])
 	}
 
-	return renderCluster(collector, renderer, targetNodes)
+	return renderCluster(collector, renderer, format, targetNodes)
 }
 
-func renderSingleNode(collector *nvlink.Collector, renderer *nvlink.Renderer, host string) error {
-	fmt.Printf("Collecting topology from %s...\n\n", host)
+func renderSingleNode(collector *nvlink.Collector, renderer *nvlink.Renderer, format output.Format, host string) error {
+	fmt.Fprintf(os.Stderr, "Collecting topology from %s...\n\n", host)
 
 	topology, err := collector.CollectTopology(host)
 	if err != nil {
 		return fmt.Errorf("failed to collect topology: %w", err)
 	}
 
-	switch topologyOutput {
-	case "ascii":
+	switch format {
+	case topologyASCII:
 		fmt.Println(renderer.RenderTopology(topology))
-	case "table":
+	case output.Table, output.Wide:
 		renderTopologyTable(topology)
-	case "json":
-		return renderJSON(topology)
 	default:
-		return fmt.Errorf("unknown output format: %s", topologyOutput)
+		return writeOutput(format, topology)
 	}
 
 	return nil
 }
 
-func renderCluster(collector *nvlink.Collector, renderer *nvlink.Renderer, hosts []string) error {
-	fmt.Printf("Collecting topology from %d nodes...\n\n", len(hosts))
+func renderCluster(collector *nvlink.Collector, renderer *nvlink.Renderer, format output.Format, hosts []string) error {
+	fmt.Fprintf(os.Stderr, "Collecting topology from %d nodes...\n\n", len(hosts))
 
 	cluster, err := collector.CollectClusterTopology(hosts)
 	if err != nil {
 		return fmt.Errorf("failed to collect cluster topology: %w", err)
 	}
 
-	switch topologyOutput {
-	case "ascii":
+	switch format {
+	case topologyASCII:
 		fmt.Println(renderer.RenderClusterSummary(cluster))
 		fmt.Println()
 		for _, node := range cluster.Nodes {
 			fmt.Println(renderer.RenderTopology(&node))
 			fmt.Println()
 		}
-	case "table":
+	case output.Table, output.Wide:
 		renderClusterTable(cluster)
-	case "json":
-		return renderJSON(cluster)
 	default:
-		return fmt.Errorf("unknown output format: %s", topologyOutput)
+		return writeOutput(format, cluster)
 	}
 
 	return nil
@@ -170,7 +170,7 @@ func renderTopologyTable(topology *nvlink.NodeTopology) {
 
 	// GPU table
 	fmt.Println("GPUs:")
-	gpuTable := tablewriter.NewWriter(os.Stdout)
+	gpuTable := newTable()
 	gpuTable.SetHeader([]string{"Index", "Name", "Bus ID", "UUID"})
 	gpuTable.SetBorder(false)
 	for _, gpu := range topology.GPUs {
@@ -187,7 +187,7 @@ func renderTopologyTable(topology *nvlink.NodeTopology) {
 	// Connection matrix
 	fmt.Println("Connection Matrix:")
 	gpuCount := len(topology.GPUs)
-	matrixTable := tablewriter.NewWriter(os.Stdout)
+	matrixTable := newTable()
 
 	headers := []string{""}
 	for i := 0; i < gpuCount; i++ {
@@ -240,7 +240,7 @@ func renderClusterTable(cluster *nvlink.ClusterTopology) {
 	fmt.Printf("Error Links:  %d\n", cluster.ErrorLinks)
 	fmt.Println()
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Node", "GPUs", "Active/Total", "Status"})
 	table.SetBorder(false)
 
@@ -262,9 +262,3 @@ func truncateUUID(uuid string) string {
 	}
 	return uuid
 }
-
-func renderJSON(v interface{}) error {
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(v)
-}
@@ -2,10 +2,8 @@ package cli
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/fatih/color"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 
 	"github.com/fregataa/aami/internal/upgrade"
@@ -167,7 +165,7 @@ func runReleases(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := newTable()
 	table.SetHeader([]string{"Version", "Published", "Type", "Assets"})
 	table.SetBorder(false)
 
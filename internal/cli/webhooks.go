@@ -0,0 +1,409 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/fregataa/aami/internal/i18n"
+	"github.com/fregataa/aami/internal/output"
+	"github.com/fregataa/aami/internal/webhook"
+)
+
+var (
+	webhookURL    string
+	webhookEvents []string
+	webhookOutput string
+	webhookListen string
+)
+
+var webhooksCmd = &cobra.Command{
+	Use:   "webhooks",
+	Short: "Manage webhooks for resource lifecycle events",
+	Long: `Manage webhooks notified when targets, alert rules and namespaces are
+created, updated or deleted.
+
+Events are raised whenever the config is saved — by aami nodes, apply,
+config import, discovery and the APIs — and posted as JSON to the webhooks
+subscribed to them:
+
+  target.created      target.updated      target.deleted
+  alert_rule.created  alert_rule.updated  alert_rule.deleted
+  namespace.created   namespace.updated   namespace.deleted
+
+Subscribe with event types, <resource>.* or *. Each delivery carries an
+X-AAMI-Signature header, "v1=" and the hex HMAC-SHA256 of
+"v1:<X-AAMI-Timestamp>:<body>" with the webhook's secret. Failed deliveries
+are retried twice (after 1s and 5s, for network errors, 429 and 5xx) and
+then kept as dead letters to be redelivered.
+
+Examples:
+  aami webhooks add cmdb --url https://cmdb.example.com/hooks/aami --event 'target.*'
+  aami webhooks add audit --url https://audit.example.com/aami --event '*'
+  aami webhooks list
+  aami webhooks test cmdb
+  aami webhooks dead-letters
+  aami webhooks redeliver 3f9c2a7d1e4b8a60-cmdb
+  aami webhooks serve --listen :8111`,
+}
+
+var webhooksAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Register a webhook and print its signing secret",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runWebhooksAdd,
+}
+
+var webhooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List webhooks",
+	Args:  cobra.NoArgs,
+	RunE:  runWebhooksList,
+}
+
+var webhooksRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a webhook",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runWebhooksRemove,
+}
+
+var webhooksTestCmd = &cobra.Command{
+	Use:   "test <name>",
+	Short: "Send a webhook.ping event to a webhook",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runWebhooksTest,
+}
+
+var webhooksDeadLettersCmd = &cobra.Command{
+	Use:   "dead-letters",
+	Short: "List deliveries that failed all attempts",
+	Args:  cobra.NoArgs,
+	RunE:  runWebhooksDeadLetters,
+}
+
+var webhooksRedeliverCmd = &cobra.Command{
+	Use:   "redeliver <id>",
+	Short: "Deliver a dead letter again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runWebhooksRedeliver,
+}
+
+var webhooksServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the webhook API",
+	Long: `Serve the webhook API:
+
+  GET    /api/v1/webhooks                                 List webhooks
+  POST   /api/v1/webhooks                                 Register a webhook
+  GET    /api/v1/webhooks/<name>                          Get a webhook
+  DELETE /api/v1/webhooks/<name>                          Remove a webhook
+  POST   /api/v1/webhooks/<name>/test                     Send a webhook.ping event
+  GET    /api/v1/webhooks/dead-letters                    List dead letters
+  POST   /api/v1/webhooks/dead-letters/<id>/redeliver     Redeliver a dead letter
+
+Requests authenticate with "Authorization: Bearer <admin.token>".
+
+Examples:
+  aami webhooks serve --listen :8111`,
+	Args: cobra.NoArgs,
+	RunE: runWebhooksServe,
+}
+
+func init() {
+	webhooksAddCmd.Flags().StringVar(&webhookURL, "url", "",
+		"URL to post events to")
+	webhooksAddCmd.Flags().StringArrayVar(&webhookEvents, "event", nil,
+		"Event type to deliver, <resource>.* or *; repeatable")
+	webhooksAddCmd.MarkFlagRequired("url")
+	webhooksAddCmd.MarkFlagRequired("event")
+	for _, c := range []*cobra.Command{webhooksListCmd, webhooksDeadLettersCmd} {
+		addOutputFlag(c, &webhookOutput)
+	}
+	webhooksServeCmd.Flags().StringVar(&webhookListen, "listen", ":8111",
+		"Address to listen on")
+
+	webhooksCmd.AddCommand(webhooksAddCmd)
+	webhooksCmd.AddCommand(webhooksListCmd)
+	webhooksCmd.AddCommand(webhooksRemoveCmd)
+	webhooksCmd.AddCommand(webhooksTestCmd)
+	webhooksCmd.AddCommand(webhooksDeadLettersCmd)
+	webhooksCmd.AddCommand(webhooksRedeliverCmd)
+	webhooksCmd.AddCommand(webhooksServeCmd)
+	rootCmd.AddCommand(webhooksCmd)
+}
+
+func getWebhookStore() (*webhook.Store, error) {
+	store := webhook.NewStore(webhook.DefaultStorePath, webhook.DefaultDeadLetterPath)
+	if err := store.Load(); err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+	return store, nil
+}
+
+// notifyWebhooks delivers config lifecycle events. Failures are reported
+// on stderr only: the change they describe has been made.
+func notifyWebhooks(events []webhook.Event) {
+	if len(events) == 0 {
+		return
+	}
+	store, err := getWebhookStore()
+	if err == nil {
+		err = webhook.Deliver(store, events)
+	}
+	if err != nil {
+		yellow := color.New(color.FgYellow).SprintFunc()
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "%s %s\n", yellow("•"), line)
+		}
+	}
+}
+
+func runWebhooksAdd(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	h, err := store.Create(args[0], webhookURL, webhookEvents)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Webhook %s registered", h.Name))
+	fmt.Printf("  %s: %s\n", i18n.T("Events"), strings.Join(h.Events, ", "))
+	fmt.Printf("  %s: %s\n", i18n.T("Secret"), h.Secret)
+	return nil
+}
+
+func runWebhooksList(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(webhookOutput)
+	if err != nil {
+		return err
+	}
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	webhooks := store.List()
+	if format.Structured() {
+		return writeOutput(format, webhooks)
+	}
+	if len(webhooks) == 0 {
+		fmt.Println(i18n.T("No webhooks registered"))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{i18n.T("Name"), "URL", i18n.T("Events"), i18n.T("Created")})
+	for _, h := range webhooks {
+		table.Append([]string{h.Name, h.URL, strings.Join(h.Events, ", "), h.CreatedAt.Format(time.RFC3339)})
+	}
+	table.Render()
+	return nil
+}
+
+func runWebhooksRemove(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Webhook %s removed", args[0]))
+	return nil
+}
+
+func runWebhooksTest(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	h, ok := store.Get(args[0])
+	if !ok {
+		return fmt.Errorf("webhook not found: %s", args[0])
+	}
+	if _, err := webhook.Ping(h, webhookCluster()); err != nil {
+		return fmt.Errorf("webhook %s: %w", h.Name, err)
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Delivered %s to %s", webhook.EventPing, h.URL))
+	return nil
+}
+
+// webhookCluster names the cluster in events not raised by a config save
+func webhookCluster() string {
+	cfg, err := loadConfig()
+	if err != nil {
+		return ""
+	}
+	return cfg.Cluster.Name
+}
+
+func runWebhooksDeadLetters(cmd *cobra.Command, args []string) error {
+	format, err := output.Parse(webhookOutput)
+	if err != nil {
+		return err
+	}
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	letters, err := store.DeadLetters()
+	if err != nil {
+		return err
+	}
+	if format.Structured() {
+		return writeOutput(format, letters)
+	}
+	if len(letters) == 0 {
+		fmt.Println(i18n.T("No dead letters"))
+		return nil
+	}
+
+	table := newTable()
+	table.SetHeader([]string{"ID", "Webhook", i18n.T("Event"), i18n.T("Attempts"), i18n.T("Failed"), i18n.T("Error")})
+	for _, d := range letters {
+		table.Append([]string{d.ID, d.Webhook, d.Event, fmt.Sprint(d.Attempts), d.FailedAt.Format(time.RFC3339), d.Error})
+	}
+	table.Render()
+	return nil
+}
+
+func runWebhooksRedeliver(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	store, err := getWebhookStore()
+	if err != nil {
+		return err
+	}
+	if err := webhook.Redeliver(store, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s\n", green("✓"), i18n.T("Redelivered %s", args[0]))
+	return nil
+}
+
+// webhookRequest is the body of POST /api/v1/webhooks
+type webhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// runWebhooksServe serves /api/v1/webhooks. The store is reloaded on every
+// request, so webhooks added with the CLI are served without a restart.
+func runWebhooksServe(cmd *cobra.Command, args []string) error {
+	green := color.New(color.FgGreen).SprintFunc()
+
+	mux := newAPIMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := readConfig()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !checkAdminToken(w, r, cfg) {
+			return
+		}
+		store, err := getWebhookStore()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhooks"), "/")
+		name, rest, _ := strings.Cut(path, "/")
+		switch {
+		case path == "" && r.Method == http.MethodGet:
+			writeSilenceJSON(w, http.StatusOK, store.List())
+
+		case path == "" && r.Method == http.MethodPost:
+			var req webhookRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+			h, err := store.Create(req.Name, req.URL, req.Events)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// The secret is returned once, on registration
+			writeSilenceJSON(w, http.StatusCreated, struct {
+				webhook.Webhook
+				Secret string `json:"secret"`
+			}{h, h.Secret})
+
+		case name == "dead-letters" && rest == "" && r.Method == http.MethodGet:
+			letters, err := store.DeadLetters()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, letters)
+
+		case name == "dead-letters" && strings.HasSuffix(rest, "/redeliver") && r.Method == http.MethodPost:
+			id := strings.TrimSuffix(rest, "/redeliver")
+			if err := webhook.Redeliver(store, id); err != nil {
+				status := http.StatusBadGateway
+				if errors.Is(err, webhook.ErrNotFound) {
+					status = http.StatusNotFound
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, map[string]string{"id": id, "status": "delivered"})
+
+		case name != "" && name != "dead-letters" && rest == "" && (r.Method == http.MethodGet || r.Method == http.MethodDelete):
+			h, ok := store.Get(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("webhook not found: %s", name), http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodGet {
+				writeSilenceJSON(w, http.StatusOK, h)
+				return
+			}
+			if err := store.Delete(name); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case name != "" && rest == "test" && r.Method == http.MethodPost:
+			h, ok := store.Get(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("webhook not found: %s", name), http.StatusNotFound)
+				return
+			}
+			e, err := webhook.Ping(h, cfg.Cluster.Name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			writeSilenceJSON(w, http.StatusOK, map[string]string{"id": e.ID, "status": "delivered"})
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}
+	v1 := mux.Version("v1")
+	v1.HandleFunc("/webhooks", handler)
+	v1.HandleFunc("/webhooks/", handler)
+
+	fmt.Printf("%s Serving webhook API on http://%s/api/v1/webhooks\n", green("✓"), webhookListen)
+	return http.ListenAndServe(webhookListen, mux)
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is used when cache.ttl is not set
+const DefaultCacheTTL = 10 * time.Second
+
+// Cache keeps the parsed configuration for servers that read it on every
+// request. A cached config is used until cache.ttl passes or the file
+// changes size or modification time, and is dropped by Invalidate when
+// the config is written.
+type Cache struct {
+	path string
+	now  func() time.Time
+
+	mu       sync.Mutex
+	cfg      *Config
+	size     int64
+	modTime  time.Time
+	loadedAt time.Time
+	stats    CacheStats
+}
+
+// CacheStats counts the reads of a Cache.
+type CacheStats struct {
+	Hits          uint64 // reads served from the cache
+	Misses        uint64 // reads that loaded the file
+	Invalidations uint64 // cached configs dropped by writes or file changes
+}
+
+// NewCache creates a cache of the configuration file at path.
+func NewCache(path string) *Cache {
+	return &Cache{path: path, now: time.Now}
+}
+
+// Load returns the configuration. It is shared between callers and must
+// not be changed.
+func (c *Cache) Load() (*Config, error) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg != nil {
+		if info.Size() == c.size && info.ModTime().Equal(c.modTime) && c.now().Sub(c.loadedAt) < c.ttl() {
+			c.stats.Hits++
+			return c.cfg, nil
+		}
+		if info.Size() != c.size || !info.ModTime().Equal(c.modTime) {
+			c.stats.Invalidations++
+		}
+	}
+
+	c.stats.Misses++
+	cfg, err := Load(c.path)
+	if err != nil {
+		c.cfg = nil
+		return nil, err
+	}
+	c.cfg = cfg
+	c.size, c.modTime, c.loadedAt = info.Size(), info.ModTime(), c.now()
+	return cfg, nil
+}
+
+// Invalidate drops the cached config, so the next Load reads the file.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg != nil {
+		c.cfg = nil
+		c.stats.Invalidations++
+	}
+}
+
+// Stats returns the read counts so far.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// ttl returns the cache.ttl of the cached config; an invalid one disables
+// caching (the validator reports it)
+func (c *Cache) ttl() time.Duration {
+	d, err := ParseCacheTTL(c.cfg.Cache.TTL)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// ParseCacheTTL parses cache.ttl: empty means DefaultCacheTTL, "0" disables
+// caching.
+func ParseCacheTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultCacheTTL, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must be non-negative")
+	}
+	return d, nil
+}
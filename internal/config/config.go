@@ -1,8 +1,12 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"regexp"
+	"syscall"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,7 +20,25 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Parse(data)
+}
+
+// LoadVersion loads the configuration like Load and returns the Version of
+// the file it was loaded from.
+func LoadVersion(path string) (*Config, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return cfg, version(data), nil
+}
 
+// Parse parses a configuration the way Load reads the file
+func Parse(data []byte) (*Config, error) {
 	// Expand environment variables: ${VAR_NAME}
 	expanded := expandEnvVars(string(data))
 
@@ -24,24 +46,137 @@ func Load(path string) (*Config, error) {
 	if err := yaml.Unmarshal([]byte(expanded), &cfg); err != nil {
 		return nil, err
 	}
+	cfg.envRefs = envRefs(data)
 
 	setDefaults(&cfg)
 	return &cfg, nil
 }
 
-// Save saves the configuration to the specified path
+// Save saves the configuration to the specified path. ${VAR} references
+// of the file the config was loaded from are saved as they were, and the
+// file is only readable by its owner, as it may hold tokens.
 func Save(cfg *Config, path string) error {
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0600)
+}
+
+// MarshalYAML marshals the config with the ${VAR} references it was parsed
+// from in place of the values they expanded to, where those are unchanged.
+func (c Config) MarshalYAML() (interface{}, error) {
+	type plain Config
+	var doc yaml.Node
+	if err := doc.Encode((*plain)(&c)); err != nil {
+		return nil, err
+	}
+	if len(c.envRefs) > 0 {
+		walkScalars(&doc, "", func(path string, n *yaml.Node) {
+			if ref, ok := c.envRefs[path]; ok && n.Value == expandEnvVars(ref) {
+				n.Value, n.Tag, n.Style = ref, "!!str", 0
+			}
+		})
+	}
+	return &doc, nil
+}
+
+// envRefs returns the scalars of a config file that reference environment
+// variables, by path
+func envRefs(data []byte) map[string]string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+	refs := map[string]string{}
+	walkScalars(&doc, "", func(path string, n *yaml.Node) {
+		if envVarRef.MatchString(n.Value) {
+			refs[path] = n.Value
+		}
+	})
+	return refs
+}
+
+// walkScalars calls fn for every scalar of a YAML document with its path,
+// e.g. ".notifications.slack.webhook_url" or ".nodes[0].ssh_key"
+func walkScalars(n *yaml.Node, path string, fn func(path string, n *yaml.Node)) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			walkScalars(c, path, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walkScalars(n.Content[i+1], path+"."+n.Content[i].Value, fn)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			walkScalars(c, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case yaml.ScalarNode:
+		fn(path, n)
+	}
+}
+
+// Version returns a hash of the configuration file's contents. Comparing
+// it before saving detects changes made by someone else in the meantime.
+// It is empty if the file does not exist.
+func Version(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return version(data), nil
+}
+
+func version(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Lock takes an exclusive lock on the configuration file at path, held
+// until unlock is called. It is a lock on a file next to it, path + ".lock",
+// so it also keeps out other aami processes.
+func Lock(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("lock config: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock config: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
 }
 
+// AlertLanguage returns the language of the annotations generated for a
+// rule group: alerts.group_languages, then alerts.language, then language.
+func (c *Config) AlertLanguage(group string) string {
+	if lang := c.Alerts.GroupLanguages[group]; lang != "" {
+		return lang
+	}
+	if c.Alerts.Language != "" {
+		return c.Alerts.Language
+	}
+	return c.Language
+}
+
+// envVarRef matches a reference to an environment variable, ${VAR_NAME}
+var envVarRef = regexp.MustCompile(`\$\{([^}]+)\}`)
+
 // expandEnvVars expands environment variables in the format ${VAR_NAME}
 func expandEnvVars(content string) string {
-	re := regexp.MustCompile(`\$\{([^}]+)\}`)
-	return re.ReplaceAllStringFunc(content, func(match string) string {
+	return envVarRef.ReplaceAllStringFunc(content, func(match string) string {
 		varName := match[2 : len(match)-1]
 		return os.Getenv(varName)
 	})
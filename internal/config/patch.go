@@ -0,0 +1,68 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MergePatch applies an RFC 7386 JSON merge patch to doc and returns the
+// result: objects are merged recursively, null removes a member, and any
+// other value (including arrays) replaces the target value.
+func MergePatch(doc, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	docObj, ok := doc.(map[string]interface{})
+	if !ok {
+		docObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(docObj, key)
+			continue
+		}
+		docObj[key] = MergePatch(docObj[key], value)
+	}
+	return docObj
+}
+
+// Patch applies a merge patch, given as JSON or YAML, to the value v points
+// to (a config struct such as *NodeConfig). Fields are named as in the
+// config file. Unknown fields and type mismatches are rejected, and v is
+// left unchanged on error.
+func Patch(v interface{}, patch []byte) error {
+	var p interface{}
+	if err := yaml.Unmarshal(patch, &p); err != nil {
+		return fmt.Errorf("parse patch: %w", err)
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return fmt.Errorf("patch must be an object")
+	}
+
+	current, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(current, &doc); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+
+	merged, err := yaml.Marshal(MergePatch(doc, p))
+	if err != nil {
+		return fmt.Errorf("marshal patched value: %w", err)
+	}
+
+	target := reflect.New(reflect.TypeOf(v).Elem())
+	decoder := yaml.NewDecoder(bytes.NewReader(merged))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(target.Interface()); err != nil {
+		return fmt.Errorf("apply patch: %w", err)
+	}
+	reflect.ValueOf(v).Elem().Set(target.Elem())
+	return nil
+}
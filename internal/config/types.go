@@ -3,12 +3,34 @@ package config
 // Config represents the main AAMI configuration
 type Config struct {
 	Cluster       ClusterConfig       `yaml:"cluster"`
+	Language      string              `yaml:"language"` // CLI messages and alert annotations: en (default), ko
 	Nodes         []NodeConfig        `yaml:"nodes"`
 	SSH           SSHConfig           `yaml:"ssh"`
 	Alerts        AlertsConfig        `yaml:"alerts"`
 	Notifications NotificationsConfig `yaml:"notifications"`
+	ChatOps       ChatOpsConfig       `yaml:"chatops"`
+	Replication   ReplicationConfig   `yaml:"replication"`
+	QueryProxy    QueryProxyConfig    `yaml:"query_proxy"`
+	Silences      SilencesConfig      `yaml:"silences"`
+	CheckResults  CheckResultsConfig  `yaml:"check_results"`
+	AgentAuth     AgentAuthConfig     `yaml:"agent_auth"`
+	Admin         AdminConfig         `yaml:"admin"`
+	APIKeys       []APIKey            `yaml:"api_keys"`
+	OIDC          OIDCConfig          `yaml:"oidc"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Storage       StorageConfig       `yaml:"storage"`
+	Discovery     DiscoveryConfig     `yaml:"discovery"`
+	Registry      RegistryConfig      `yaml:"cluster_registry"`
+	Slurm         SlurmConfig         `yaml:"slurm"`
 	Prometheus    PrometheusConfig    `yaml:"prometheus"`
 	Grafana       GrafanaConfig       `yaml:"grafana"`
+	Health        HealthConfig        `yaml:"health"`
+	GPUValidation GPUValidationConfig `yaml:"gpu_validation"`
+
+	// envRefs are the ${VAR} references of the file the config was parsed
+	// from, by path, so that marshaling it writes them back instead of the
+	// secrets they expand to
+	envRefs map[string]string
 }
 
 // ClusterConfig contains cluster-wide settings
@@ -24,6 +46,9 @@ type NodeConfig struct {
 	SSHKey  string            `yaml:"ssh_key"`
 	SSHPort int               `yaml:"ssh_port"`
 	Labels  map[string]string `yaml:"labels"`
+	// Source is the inventory that registered the node, e.g. "kubernetes";
+	// empty for nodes added by hand
+	Source string `yaml:"source,omitempty"`
 }
 
 // SSHConfig contains SSH connection settings
@@ -43,8 +68,30 @@ type RetryConfig struct {
 
 // AlertsConfig contains alert settings
 type AlertsConfig struct {
-	Presets []string          `yaml:"presets"`
-	Custom  []CustomAlertRule `yaml:"custom"`
+	Presets        []string          `yaml:"presets"`
+	Custom         []CustomAlertRule `yaml:"custom"`
+	Namespaces     []RuleNamespace   `yaml:"namespaces"`
+	Language       string            `yaml:"language"`        // annotation language, default: top-level language
+	GroupLanguages map[string]string `yaml:"group_languages"` // rule group (preset or "custom") -> annotation language
+}
+
+// RuleNamespace isolates a team's rule files in its own directory
+type RuleNamespace struct {
+	Name  string         `yaml:"name"`  // subdirectory under /etc/aami/rules
+	Owner string         `yaml:"owner"` // user owning the directory and files
+	Group string         `yaml:"group"` // group owning the directory and files
+	Mode  string         `yaml:"mode"`  // file mode in octal, default: "0644"
+	Quota NamespaceQuota `yaml:"quota"`
+}
+
+// NamespaceLabel is the node label assigning a node to a namespace
+const NamespaceLabel = "namespace"
+
+// NamespaceQuota limits what a namespace may hold; zero means unlimited
+type NamespaceQuota struct {
+	MaxTargets int `yaml:"max_targets"` // nodes labelled namespace=<name>
+	MaxGroups  int `yaml:"max_groups"`  // rule groups in the namespace's rule files
+	MaxRules   int `yaml:"max_rules"`   // alert rules in the namespace's rule files
 }
 
 // CustomAlertRule represents a custom alert rule
@@ -60,6 +107,11 @@ type NotificationsConfig struct {
 	Slack   *SlackConfig   `yaml:"slack"`
 	Email   *EmailConfig   `yaml:"email"`
 	Webhook *WebhookConfig `yaml:"webhook"`
+	// RelayURL is where Alertmanager reaches `aami notifications serve`,
+	// which renders the Slack and webhook templates; default:
+	// http://localhost:8115
+	RelayURL   string `yaml:"relay_url"`
+	RelayToken string `yaml:"relay_token"` // bearer token Alertmanager sends to the relay, supports ${ENV_VAR}
 }
 
 // SlackConfig contains Slack notification settings
@@ -67,6 +119,7 @@ type SlackConfig struct {
 	Enabled    bool   `yaml:"enabled"`
 	WebhookURL string `yaml:"webhook_url"`
 	Channel    string `yaml:"channel"`
+	Template   string `yaml:"template"` // Block Kit blocks template file, default: built-in
 }
 
 // EmailConfig contains email notification settings
@@ -76,19 +129,265 @@ type EmailConfig struct {
 	SMTPPort int      `yaml:"smtp_port"`
 	From     string   `yaml:"from"`
 	To       []string `yaml:"to"`
+	Template string   `yaml:"template"` // HTML body template file, default: built-in
 }
 
 // WebhookConfig contains webhook notification settings
 type WebhookConfig struct {
-	Enabled bool   `yaml:"enabled"`
-	URL     string `yaml:"url"`
+	Enabled  bool   `yaml:"enabled"`
+	URL      string `yaml:"url"`
+	Template string `yaml:"template"` // JSON payload template file, default: built-in
+}
+
+// ChatOpsConfig contains chat slash command settings
+type ChatOpsConfig struct {
+	SigningSecret string            `yaml:"signing_secret"` // Slack signing secret, supports ${ENV_VAR}
+	Token         string            `yaml:"token"`          // Mattermost slash command token
+	TeamID        string            `yaml:"team_id"`        // Slack workspace or Mattermost team ID; others are refused
+	Users         map[string]string `yaml:"users"`          // chat user ID (e.g. U012AB3CD) -> role (viewer, operator)
+	DefaultRole   string            `yaml:"default_role"`   // role for unlisted users, default: none
+}
+
+// ReplicationConfig contains settings for replicating to a secondary region
+type ReplicationConfig struct {
+	Token     string            `yaml:"token"`               // shared by primary and replicas, supports ${ENV_VAR}
+	Conflicts map[string]string `yaml:"conflicts,omitempty"` // entity type -> theirs|ours|newest|manual
+}
+
+// QueryProxyConfig contains settings for the Prometheus query proxy
+type QueryProxyConfig struct {
+	Tokens   []QueryToken `yaml:"tokens"`
+	Deny     []string     `yaml:"deny"`      // regular expressions of rejected queries
+	CacheTTL string       `yaml:"cache_ttl"` // default: "30s", "0" disables caching
+}
+
+// QueryToken is a query proxy client and its limits; zero means unlimited
+type QueryToken struct {
+	Name       string `yaml:"name"`
+	Token      string `yaml:"token"`       // bearer token, supports ${ENV_VAR}
+	MaxRange   string `yaml:"max_range"`   // longest time range, e.g. "7d"
+	MinStep    string `yaml:"min_step"`    // finest range query resolution, e.g. "30s"
+	MaxSeries  int    `yaml:"max_series"`  // series in a result
+	MaxSamples int    `yaml:"max_samples"` // samples loaded, from query stats
+}
+
+// SilencesConfig contains settings for the silence API (aami silence serve)
+type SilencesConfig struct {
+	Token string `yaml:"token"` // bearer token for API clients, supports ${ENV_VAR}
+}
+
+// CheckResultsConfig contains settings for the check result API
+// (aami check-results serve)
+type CheckResultsConfig struct {
+	Token     string `yaml:"token"`     // bearer token for reading results, supports ${ENV_VAR}
+	Retention string `yaml:"retention"` // how long results are kept, default: "30d"
+}
+
+// AgentAuthConfig contains settings for node agent credentials
+type AgentAuthConfig struct {
+	Required bool   `yaml:"required"` // reject agent requests without a credential
+	TTL      string `yaml:"ttl"`      // credential lifetime, default: "24h"
+	MTLS     bool   `yaml:"mtls"`     // require agent client certificates from the agent CA
+	CertTTL  string `yaml:"cert_ttl"` // agent certificate lifetime, default: "30d"
+}
+
+// AdminConfig contains settings for the admin API, such as the synthetic
+// alert test
+type AdminConfig struct {
+	Token string `yaml:"token"` // bearer token for admin requests, supports ${ENV_VAR}
+}
+
+// APIKey lets a team use the serve APIs for its own namespace only: it
+// reads and changes just the nodes labelled namespace=<namespace> and
+// what belongs to them
+type APIKey struct {
+	Name      string `yaml:"name"`
+	Key       string `yaml:"key"`       // bearer token, supports ${ENV_VAR}
+	Namespace string `yaml:"namespace"` // one of alerts.namespaces
+}
+
+// OIDCConfig lets people sign in with an OpenID Connect provider (aami
+// login) and use the serve APIs with its ID tokens. Their groups decide
+// what they see: operator_groups see every namespace, namespace_groups
+// map other groups to one namespace each.
+type OIDCConfig struct {
+	Issuer          string            `yaml:"issuer"`           // e.g. https://sso.example.com/realms/ops
+	ClientID        string            `yaml:"client_id"`        // public client of the CLI, with the device grant
+	Audience        string            `yaml:"audience"`         // aud of accepted tokens, default: client_id
+	UsernameClaim   string            `yaml:"username_claim"`   // default: "email"
+	GroupsClaim     string            `yaml:"groups_claim"`     // default: "groups"
+	OperatorGroups  []string          `yaml:"operator_groups"`  // empty: every user is an operator
+	NamespaceGroups map[string]string `yaml:"namespace_groups"` // group -> namespace
+}
+
+// CacheConfig contains how the serve commands cache the parsed config
+// between requests
+type CacheConfig struct {
+	TTL string `yaml:"ttl"` // default: "10s", "0" reads the file on every request
+}
+
+// StorageConfig contains where generated rule files, backups and the
+// debug bundles of nodes are stored
+type StorageConfig struct {
+	Rules        ObjectStoreConfig        `yaml:"rules"`
+	Backups      BackupStorageConfig      `yaml:"backups"`
+	DebugBundles DebugBundleStorageConfig `yaml:"debug_bundles"`
+}
+
+// ObjectStoreConfig selects a storage backend
+type ObjectStoreConfig struct {
+	Backend   string `yaml:"backend"`    // local (default), s3, gcs
+	Bucket    string `yaml:"bucket"`     // s3, gcs
+	Prefix    string `yaml:"prefix"`     // key prefix in the bucket
+	Region    string `yaml:"region"`     // s3, default: "us-east-1"
+	Endpoint  string `yaml:"endpoint"`   // S3-compatible endpoint, e.g. MinIO
+	AccessKey string `yaml:"access_key"` // supports ${ENV_VAR}; s3 falls back to AWS_ACCESS_KEY_ID
+	SecretKey string `yaml:"secret_key"` // supports ${ENV_VAR}; for gcs, an HMAC key
+}
+
+// BackupStorageConfig selects where backups are stored and how long they
+// are kept
+type BackupStorageConfig struct {
+	ObjectStoreConfig `yaml:",inline"`
+	Path              string `yaml:"path"`      // local backup directory, default: /var/lib/aami/backups
+	KeepLast          int    `yaml:"keep_last"` // backups kept, newest first; 0: no limit
+	MaxAge            string `yaml:"max_age"`   // older backups are pruned, e.g. "30d"
+}
+
+// DebugBundleStorageConfig selects where the debug bundles node agents
+// upload are stored, and how their download links are made
+type DebugBundleStorageConfig struct {
+	ObjectStoreConfig `yaml:",inline"`
+	Path              string `yaml:"path"`     // local directory, default: /var/lib/aami/debug-bundles
+	URL               string `yaml:"url"`      // external URL of 'aami check-results serve', for links to local bundles
+	LinkTTL           string `yaml:"link_ttl"` // lifetime of s3/gcs download links, default: "7d" (at most 7d)
+}
+
+// RegistryConfig selects where aami clusters keeps the registered
+// clusters; a shared backend gives a team of operators one cluster list
+type RegistryConfig struct {
+	Backend   string            `yaml:"backend"`      // file (default), http, etcd, s3, gcs
+	Path      string            `yaml:"path"`         // file, default: /etc/aami/clusters.yaml
+	URL       string            `yaml:"url"`          // http: the registry document on a config server
+	Token     string            `yaml:"token"`        // http: bearer token, supports ${ENV_VAR}
+	Endpoints []string          `yaml:"endpoints"`    // etcd: e.g. http://etcd-1:2379, tried in order
+	Username  string            `yaml:"username"`     // etcd, with authentication enabled
+	Password  string            `yaml:"password"`     // etcd, supports ${ENV_VAR}
+	Key       string            `yaml:"key"`          // etcd key, default: /aami/clusters; s3/gcs object key, default: clusters.yaml
+	Object    ObjectStoreConfig `yaml:"object_store"` // s3, gcs: bucket, prefix, region, endpoint and keys; its backend is ignored
+}
+
+// DiscoveryConfig contains settings for importing nodes from other
+// inventories
+type DiscoveryConfig struct {
+	Kubernetes KubernetesDiscoveryConfig `yaml:"kubernetes"`
+	Consul     ConsulDiscoveryConfig     `yaml:"consul"`
+}
+
+// KubernetesDiscoveryConfig contains settings for registering the nodes of
+// a Kubernetes cluster
+type KubernetesDiscoveryConfig struct {
+	Kubeconfig    string `yaml:"kubeconfig"`     // default: in-cluster service account, then $KUBECONFIG or ~/.kube/config
+	Context       string `yaml:"context"`        // kubeconfig context, default: current-context
+	LabelSelector string `yaml:"label_selector"` // nodes to register, e.g. "nvidia.com/gpu.present=true"
+	SSHUser       string `yaml:"ssh_user"`       // for registered nodes, overridden by the aami.io/ssh-user annotation
+	SSHKey        string `yaml:"ssh_key"`
+}
+
+// ConsulDiscoveryConfig contains settings for registering the nodes of a
+// Consul catalog
+type ConsulDiscoveryConfig struct {
+	Address    string            `yaml:"address"`    // default: $CONSUL_HTTP_ADDR, then "http://localhost:8500"
+	Token      string            `yaml:"token"`      // ACL token, supports ${ENV_VAR}; default: $CONSUL_HTTP_TOKEN
+	Datacenter string            `yaml:"datacenter"` // default: the agent's
+	Services   []string          `yaml:"services"`   // only nodes with a passing instance of these services; default: all nodes
+	NodeMeta   map[string]string `yaml:"node_meta"`  // only nodes with this metadata
+	Interval   string            `yaml:"interval"`   // sync schedule, default: "5m"
+	Conflict   string            `yaml:"conflict"`   // node of the same name added by hand: ours (default, keep it), theirs (take it over)
+	SSHUser    string            `yaml:"ssh_user"`   // for registered nodes, overridden by the aami_ssh_user metadata
+	SSHKey     string            `yaml:"ssh_key"`
+}
+
+// SlurmConfig contains settings for the Slurm integration
+type SlurmConfig struct {
+	REST         SlurmRESTConfig    `yaml:"rest"`
+	Prolog       PrologConfig       `yaml:"prolog"`
+	DrainPolicy  DrainPolicyConfig  `yaml:"drain_policy"`
+	Correlations CorrelationsConfig `yaml:"correlations"`
+}
+
+// PrologConfig contains the health gate the prolog hook runs before each
+// job (aami slurm prolog-check). Without checks every job is let through.
+type PrologConfig struct {
+	Checks          []PrologCheckConfig `yaml:"checks"`
+	FailAbove       int                 `yaml:"fail_above"`        // reject the job when the failed checks weigh more, default: 0 (any failure)
+	Timeout         string              `yaml:"timeout"`           // per check, default: "10s"
+	ConfigServerURL string              `yaml:"config_server_url"` // where policy checks are fetched, default: config_server_url of /etc/aami/agent.yaml
+	ResultsURL      string              `yaml:"results_url"`       // check result API the results are reported to, default: the config server
+	CredentialFile  string              `yaml:"credential_file"`   // agent credential, default: /etc/aami/agent-credential
+}
+
+// PrologCheckConfig is a check of the prolog health gate, with exactly one
+// of builtin, policy or command
+type PrologCheckConfig struct {
+	Name    string `yaml:"name"`    // default: the builtin or policy name
+	Builtin string `yaml:"builtin"` // nvidia-smi, xid or nvlink
+	Policy  string `yaml:"policy"`  // a check assigned to the node by the config server's script policies
+	Command string `yaml:"command"` // shell command, failing on a non-zero exit
+	Weight  *int   `yaml:"weight"`  // default: 1; 0 only reports the check
+	Timeout string `yaml:"timeout"` // default: the gate's
+}
+
+// SlurmRESTConfig contains the slurmrestd settings. Without an endpoint, or
+// while slurmrestd is unavailable, the Slurm commands are used.
+type SlurmRESTConfig struct {
+	Endpoint      string `yaml:"endpoint"`       // e.g. http://slurm-ctl:6820
+	APIVersion    string `yaml:"api_version"`    // default: v0.0.40
+	User          string `yaml:"user"`           // sent as X-SLURM-USER-NAME, and the user tokens are issued for
+	Token         string `yaml:"token"`          // JWT, supports ${ENV_VAR}; default: issued with scontrol token
+	TokenLifetime string `yaml:"token_lifetime"` // of issued tokens, default: "1h"
+}
+
+// CorrelationsConfig contains settings for the job-GPU correlation API
+// (aami slurm serve)
+type CorrelationsConfig struct {
+	URL            string `yaml:"url"`             // where epilog hooks report, e.g. http://aami-mgmt:8099; default: the local store
+	Token          string `yaml:"token"`           // bearer token for reading correlations, supports ${ENV_VAR}
+	Retention      string `yaml:"retention"`       // how long correlations are kept, default: "90d"
+	CredentialFile string `yaml:"credential_file"` // agent credential hooks report with, default: /etc/aami/agent-credential
+}
+
+// DrainPolicyConfig contains the rules aami slurm autodrain drains nodes by
+type DrainPolicyConfig struct {
+	Interval   string      `yaml:"interval"`   // evaluation schedule, default: "1m"
+	Cooldown   string      `yaml:"cooldown"`   // time before a drained node is drained again, default: "1h"
+	DryRun     bool        `yaml:"dry_run"`    // audit the drains without draining
+	Partitions []string    `yaml:"partitions"` // only drain nodes in these partitions; default: all
+	AuditLog   string      `yaml:"audit_log"`  // default: /var/log/aami/auto-drain.log
+	Rules      []DrainRule `yaml:"rules"`
+}
+
+// DrainRule drains the nodes whose GPUs meet its condition: one of xid,
+// ecc_dbe, temp_above, alert or expr
+type DrainRule struct {
+	Name       string   `yaml:"name"`
+	Xid        []int    `yaml:"xid"`        // an Xid error with one of these codes
+	ECCDBE     bool     `yaml:"ecc_dbe"`    // a new double-bit ECC error
+	TempAbove  float64  `yaml:"temp_above"` // GPU temperature in °C, for the whole "for"
+	For        string   `yaml:"for"`        // temp_above, default: "5m"
+	Alert      string   `yaml:"alert"`      // a firing Prometheus alert
+	Expr       string   `yaml:"expr"`       // a PromQL expression; its series' node label is drained
+	Partitions []string `yaml:"partitions"` // only drain nodes in these partitions; default: the policy's
+	Cooldown   string   `yaml:"cooldown"`   // default: the policy's
 }
 
 // PrometheusConfig contains Prometheus settings
 type PrometheusConfig struct {
-	Retention   string `yaml:"retention"`     // default: "15d"
-	StoragePath string `yaml:"storage_path"`  // default: "/var/lib/aami/prometheus"
-	Port        int    `yaml:"port"`          // default: 9090
+	Retention   string `yaml:"retention"`    // default: "15d"
+	StoragePath string `yaml:"storage_path"` // default: "/var/lib/aami/prometheus"
+	Port        int    `yaml:"port"`         // default: 9090
+	// RuleNamespace restricts this instance to a single rule namespace
+	RuleNamespace string `yaml:"rule_namespace"`
 }
 
 // GrafanaConfig contains Grafana settings
@@ -96,3 +395,31 @@ type GrafanaConfig struct {
 	Port          int    `yaml:"port"`           // default: 3000
 	AdminPassword string `yaml:"admin_password"` // supports ${ENV_VAR}
 }
+
+// HealthConfig contains settings for target health scores
+type HealthConfig struct {
+	// How much each part counts in a target's score. All unset means the
+	// defaults; a weight of 0 leaves that part out.
+	Weights HealthWeights `yaml:"weights"`
+}
+
+// GPUValidationConfig contains the stress suite 'aami gpu validate' runs
+// on a node
+type GPUValidationConfig struct {
+	Steps []GPUValidationStep `yaml:"steps"` // default: dcgm-diag level 3, bandwidth, nccl-allreduce
+}
+
+// GPUValidationStep is a step of the GPU validation suite
+type GPUValidationStep struct {
+	Name    string  `yaml:"name"`     // dcgm-diag, bandwidth, nccl-allreduce
+	Level   int     `yaml:"level"`    // dcgm-diag run level 1-4, default: 3
+	MinGBps float64 `yaml:"min_gbps"` // bandwidth: host-device, nccl-allreduce: bus bandwidth; 0: no minimum
+	Timeout string  `yaml:"timeout"`  // default: "30m"
+}
+
+// HealthWeights weighs the parts of a target's health score
+type HealthWeights struct {
+	GPU    float64 `yaml:"gpu"`    // GPU metrics, default: 0.5
+	Alerts float64 `yaml:"alerts"` // firing alerts, default: 0.3
+	Checks float64 `yaml:"checks"` // latest check results, default: 0.2
+}
@@ -3,9 +3,19 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fregataa/aami/internal/i18n"
 )
 
+// namespacePattern restricts namespace names to safe directory names
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // ValidationError represents a validation error
 type ValidationError struct {
 	Field   string
@@ -75,6 +85,129 @@ func (c *Config) Validate() []ValidationError {
 		})
 	}
 
+	languages := map[string]string{
+		"language":        c.Language,
+		"alerts.language": c.Alerts.Language,
+	}
+	for group, lang := range c.Alerts.GroupLanguages {
+		languages["alerts.group_languages."+group] = lang
+	}
+	fields := make([]string, 0, len(languages))
+	for field := range languages {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if _, err := i18n.Parse(languages[field]); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	seenNamespaces := make(map[string]bool)
+	for i, ns := range c.Alerts.Namespaces {
+		field := fmt.Sprintf("alerts.namespaces[%d]", i)
+		if !namespacePattern.MatchString(ns.Name) {
+			errors = append(errors, ValidationError{
+				Field:   field + ".name",
+				Message: "must match " + namespacePattern.String(),
+			})
+		} else if seenNamespaces[ns.Name] {
+			errors = append(errors, ValidationError{
+				Field:   field + ".name",
+				Message: "duplicate namespace",
+			})
+		}
+		seenNamespaces[ns.Name] = true
+		if ns.Mode != "" {
+			if _, err := strconv.ParseUint(ns.Mode, 8, 32); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   field + ".mode",
+					Message: "invalid octal file mode",
+				})
+			}
+		}
+		limits := []struct {
+			name  string
+			value int
+		}{
+			{"max_targets", ns.Quota.MaxTargets},
+			{"max_groups", ns.Quota.MaxGroups},
+			{"max_rules", ns.Quota.MaxRules},
+		}
+		for _, l := range limits {
+			if l.value < 0 {
+				errors = append(errors, ValidationError{
+					Field:   field + ".quota." + l.name,
+					Message: "must be non-negative",
+				})
+			}
+		}
+	}
+
+	if c.Prometheus.RuleNamespace != "" && !namespacePattern.MatchString(c.Prometheus.RuleNamespace) {
+		errors = append(errors, ValidationError{
+			Field:   "prometheus.rule_namespace",
+			Message: "must match " + namespacePattern.String(),
+		})
+	}
+
+	for i, key := range c.APIKeys {
+		field := fmt.Sprintf("api_keys[%d]", i)
+		if key.Key == "" {
+			errors = append(errors, ValidationError{
+				Field:   field + ".key",
+				Message: "required",
+			})
+		}
+		if !seenNamespaces[key.Namespace] {
+			errors = append(errors, ValidationError{
+				Field:   field + ".namespace",
+				Message: "must be one of alerts.namespaces",
+			})
+		}
+	}
+
+	if c.OIDC.Issuer != "" && c.OIDC.ClientID == "" && c.OIDC.Audience == "" {
+		errors = append(errors, ValidationError{
+			Field:   "oidc.client_id",
+			Message: "required with oidc.issuer",
+		})
+	}
+	for group, ns := range c.OIDC.NamespaceGroups {
+		if !seenNamespaces[ns] {
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("oidc.namespace_groups.%s", group),
+				Message: "must be one of alerts.namespaces",
+			})
+		}
+	}
+
+	if _, err := ParseCacheTTL(c.Cache.TTL); err != nil {
+		errors = append(errors, ValidationError{
+			Field:   "cache.ttl",
+			Message: err.Error(),
+		})
+	}
+
+	validRoles := map[string]bool{"viewer": true, "operator": true}
+	if c.ChatOps.DefaultRole != "" && !validRoles[c.ChatOps.DefaultRole] {
+		errors = append(errors, ValidationError{
+			Field:   "chatops.default_role",
+			Message: "must be viewer or operator",
+		})
+	}
+	for user, role := range c.ChatOps.Users {
+		if !validRoles[role] {
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("chatops.users.%s", user),
+				Message: "must be viewer or operator",
+			})
+		}
+	}
+
 	if c.Prometheus.Port != 0 && (c.Prometheus.Port < 1 || c.Prometheus.Port > 65535) {
 		errors = append(errors, ValidationError{
 			Field:   "prometheus.port",
@@ -89,6 +222,232 @@ func (c *Config) Validate() []ValidationError {
 		})
 	}
 
+	weights := c.Health.Weights
+	for _, w := range []struct {
+		name  string
+		value float64
+	}{{"gpu", weights.GPU}, {"alerts", weights.Alerts}, {"checks", weights.Checks}} {
+		if w.value < 0 {
+			errors = append(errors, ValidationError{
+				Field:   "health.weights." + w.name,
+				Message: "must be non-negative",
+			})
+		}
+	}
+
+	stores := map[string]ObjectStoreConfig{
+		"storage.rules":         c.Storage.Rules,
+		"storage.backups":       c.Storage.Backups.ObjectStoreConfig,
+		"storage.debug_bundles": c.Storage.DebugBundles.ObjectStoreConfig,
+	}
+	for _, field := range []string{"storage.backups", "storage.debug_bundles", "storage.rules"} {
+		store := stores[field]
+		switch store.Backend {
+		case "", "local":
+		case "s3", "gcs":
+			if store.Bucket == "" {
+				errors = append(errors, ValidationError{
+					Field:   field + ".bucket",
+					Message: "required for " + store.Backend,
+				})
+			}
+		default:
+			errors = append(errors, ValidationError{
+				Field:   field + ".backend",
+				Message: "must be local, s3 or gcs",
+			})
+		}
+	}
+	switch c.Registry.Backend {
+	case "", "file":
+	case "http":
+		if c.Registry.URL == "" {
+			errors = append(errors, ValidationError{
+				Field:   "cluster_registry.url",
+				Message: "required for http",
+			})
+		}
+	case "etcd":
+		if len(c.Registry.Endpoints) == 0 {
+			errors = append(errors, ValidationError{
+				Field:   "cluster_registry.endpoints",
+				Message: "required for etcd",
+			})
+		}
+	case "s3", "gcs":
+		if c.Registry.Object.Bucket == "" {
+			errors = append(errors, ValidationError{
+				Field:   "cluster_registry.object_store.bucket",
+				Message: "required for " + c.Registry.Backend,
+			})
+		}
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "cluster_registry.backend",
+			Message: "must be file, http, etcd, s3 or gcs",
+		})
+	}
+
+	errors = append(errors, c.Slurm.REST.validate()...)
+	errors = append(errors, c.Slurm.Prolog.validate()...)
+	errors = append(errors, c.Slurm.DrainPolicy.validate()...)
+
+	if c.Storage.Backups.KeepLast < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "storage.backups.keep_last",
+			Message: "must be non-negative",
+		})
+	}
+	if u := c.Storage.DebugBundles.URL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errors = append(errors, ValidationError{
+				Field:   "storage.debug_bundles.url",
+				Message: "must be an http or https URL",
+			})
+		}
+	}
+
+	return errors
+}
+
+// validate checks the slurmrestd endpoint and token lifetime
+func (r *SlurmRESTConfig) validate() []ValidationError {
+	var errors []ValidationError
+	if r.Endpoint != "" {
+		if u, err := url.Parse(r.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errors = append(errors, ValidationError{
+				Field:   "slurm.rest.endpoint",
+				Message: "must be an http or https URL",
+			})
+		}
+	}
+	if r.TokenLifetime != "" {
+		if d, err := time.ParseDuration(r.TokenLifetime); err != nil || d < time.Minute {
+			errors = append(errors, ValidationError{
+				Field:   "slurm.rest.token_lifetime",
+				Message: fmt.Sprintf("invalid duration %q (at least 1m)", r.TokenLifetime),
+			})
+		}
+	}
+	return errors
+}
+
+// validate checks that every prolog check has exactly one kind and a
+// unique name
+func (p *PrologConfig) validate() []ValidationError {
+	var errors []ValidationError
+	timeout := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("invalid duration %q", value),
+			})
+		}
+	}
+
+	timeout("slurm.prolog.timeout", p.Timeout)
+	if p.FailAbove < 0 {
+		errors = append(errors, ValidationError{Field: "slurm.prolog.fail_above", Message: "must be non-negative"})
+	}
+
+	names := make(map[string]bool)
+	for i, check := range p.Checks {
+		field := fmt.Sprintf("slurm.prolog.checks[%d]", i)
+		kinds := 0
+		for _, set := range []bool{check.Builtin != "", check.Policy != "", check.Command != ""} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: "needs exactly one of builtin, policy or command",
+			})
+		}
+		switch check.Builtin {
+		case "", "nvidia-smi", "xid", "nvlink":
+		default:
+			errors = append(errors, ValidationError{
+				Field:   field + ".builtin",
+				Message: fmt.Sprintf("unknown check %q (valid: nvidia-smi, xid, nvlink)", check.Builtin),
+			})
+		}
+
+		name := check.Name
+		if name == "" {
+			name = check.Builtin + check.Policy
+		}
+		if name == "" {
+			errors = append(errors, ValidationError{Field: field + ".name", Message: "required for a command"})
+		} else if names[name] {
+			errors = append(errors, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate check %q", name)})
+		}
+		names[name] = true
+
+		if check.Weight != nil && *check.Weight < 0 {
+			errors = append(errors, ValidationError{Field: field + ".weight", Message: "must be non-negative"})
+		}
+		timeout(field+".timeout", check.Timeout)
+	}
+	return errors
+}
+
+// validate checks the durations of the policy and that every rule has
+// exactly one condition
+func (p *DrainPolicyConfig) validate() []ValidationError {
+	var errors []ValidationError
+	duration := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: fmt.Sprintf("invalid duration %q", value),
+			})
+		}
+	}
+
+	duration("slurm.drain_policy.interval", p.Interval)
+	duration("slurm.drain_policy.cooldown", p.Cooldown)
+
+	names := make(map[string]bool)
+	for i, rule := range p.Rules {
+		field := fmt.Sprintf("slurm.drain_policy.rules[%d]", i)
+		if rule.Name == "" {
+			errors = append(errors, ValidationError{Field: field + ".name", Message: "required"})
+		} else if names[rule.Name] {
+			errors = append(errors, ValidationError{Field: field + ".name", Message: fmt.Sprintf("duplicate rule %q", rule.Name)})
+		}
+		names[rule.Name] = true
+
+		conditions := 0
+		for _, set := range []bool{len(rule.Xid) > 0, rule.ECCDBE, rule.TempAbove != 0, rule.Alert != "", rule.Expr != ""} {
+			if set {
+				conditions++
+			}
+		}
+		if conditions != 1 {
+			errors = append(errors, ValidationError{
+				Field:   field,
+				Message: "needs exactly one of xid, ecc_dbe, temp_above, alert or expr",
+			})
+		}
+		for _, code := range rule.Xid {
+			if code <= 0 {
+				errors = append(errors, ValidationError{Field: field + ".xid", Message: fmt.Sprintf("invalid Xid %d", code)})
+			}
+		}
+		if rule.TempAbove < 0 {
+			errors = append(errors, ValidationError{Field: field + ".temp_above", Message: "must be positive"})
+		}
+		duration(field+".for", rule.For)
+		duration(field+".cooldown", rule.Cooldown)
+	}
 	return errors
 }
 
@@ -0,0 +1,97 @@
+package configserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// BootstrapToken lets nodes register themselves into a group.
+type BootstrapToken struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	GroupID     string    `json:"group_id,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	MaxUses     int       `json:"max_uses"`
+	UseCount    int       `json:"use_count"`
+	Status      string    `json:"status,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BootstrapTokenRequest creates a bootstrap token.
+type BootstrapTokenRequest struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	GroupID     string    `json:"group_id,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	MaxUses     int       `json:"max_uses,omitempty"`
+}
+
+// RegisterRequest registers a node with a bootstrap token.
+type RegisterRequest struct {
+	Token     string            `json:"token"`
+	Hostname  string            `json:"hostname"`
+	IPAddress string            `json:"ip_address"`
+	Port      int               `json:"port,omitempty"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// Registration is a registered node and the credential of its agent.
+type Registration struct {
+	Target     Target `json:"target"`
+	Credential struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	} `json:"credential"`
+	Certificate *AgentCertificate `json:"certificate,omitempty"`
+}
+
+// AgentCertificate is the client certificate of an agent for mutual TLS,
+// PEM encoded, with its key and the CA that issued it.
+type AgentCertificate struct {
+	Certificate   string    `json:"certificate"`
+	PrivateKey    string    `json:"private_key"`
+	CACertificate string    `json:"ca_certificate"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// GetBootstrapToken returns a bootstrap token
+func (c *Client) GetBootstrapToken(ctx context.Context, id string) (*BootstrapToken, error) {
+	var token BootstrapToken
+	if err := c.do(ctx, http.MethodGet, "/api/v1/bootstrap-tokens/"+url.PathEscape(id), nil, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CreateBootstrapToken creates a bootstrap token; only the response holds
+// the token itself
+func (c *Client) CreateBootstrapToken(ctx context.Context, req BootstrapTokenRequest) (*BootstrapToken, error) {
+	var token BootstrapToken
+	if err := c.do(ctx, http.MethodPost, "/api/v1/bootstrap-tokens", req, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Register registers a node with a bootstrap token
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (*Registration, error) {
+	var reg Registration
+	if err := c.do(ctx, http.MethodPost, "/api/v1/bootstrap-tokens/register", req, &reg); err != nil {
+		return nil, err
+	}
+	return &reg, nil
+}
+
+// BootstrapScript returns scripts/node/bootstrap.sh as the Config Server
+// serves it to nodes
+func (c *Client) BootstrapScript(ctx context.Context) ([]byte, error) {
+	var script []byte
+	if err := c.do(ctx, http.MethodGet, "/bootstrap.sh", nil, &script); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
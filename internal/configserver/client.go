@@ -0,0 +1,367 @@
+// Package configserver is a client of the Config Server REST API, for the
+// CLI commands that manage its groups, targets and alert rules.
+package configserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client handles requests to the Config Server.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client of the Config Server at baseURL, authenticated
+// with a bearer token if one is given.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// BaseURL returns the address of the Config Server
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// APIError is a response of the Config Server with an error status.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("config server returned %s: %s", e.Status, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 of the Config Server
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Group is a monitoring group.
+type Group struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	Priority     int                    `json:"priority"`
+	IsDefaultOwn bool                   `json:"is_default_own"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// GroupRef is a group a target belongs to.
+type GroupRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Target is a monitored node.
+type Target struct {
+	ID          string            `json:"id"`
+	Hostname    string            `json:"hostname"`
+	IPAddress   string            `json:"ip_address"`
+	Port        int               `json:"port,omitempty"`
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Groups      []GroupRef        `json:"groups,omitempty"`
+	LastSeen    *time.Time        `json:"last_seen,omitempty"` // last agent heartbeat
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// Target statuses
+const (
+	TargetActive   = "active"
+	TargetInactive = "inactive"
+)
+
+// TargetRequest creates or replaces a target.
+type TargetRequest struct {
+	Hostname    string            `json:"hostname"`
+	IPAddress   string            `json:"ip_address"`
+	Port        int               `json:"port,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	GroupIDs    []string          `json:"group_ids"`
+}
+
+// Request returns the request that recreates the target
+func (t *Target) Request() TargetRequest {
+	req := TargetRequest{
+		Hostname:    t.Hostname,
+		IPAddress:   t.IPAddress,
+		Port:        t.Port,
+		Labels:      make(map[string]string, len(t.Labels)),
+		Annotations: make(map[string]string, len(t.Annotations)),
+		GroupIDs:    make([]string, 0, len(t.Groups)),
+	}
+	for k, v := range t.Labels {
+		req.Labels[k] = v
+	}
+	for k, v := range t.Annotations {
+		req.Annotations[k] = v
+	}
+	for _, g := range t.Groups {
+		req.GroupIDs = append(req.GroupIDs, g.ID)
+	}
+	return req
+}
+
+// AlertRule is an alert rule assigned to a group, made from a template or
+// written directly.
+type AlertRule struct {
+	ID            string                 `json:"id"`
+	GroupID       string                 `json:"group_id"`
+	TemplateID    string                 `json:"template_id,omitempty"`
+	Name          string                 `json:"name"`
+	Description   string                 `json:"description,omitempty"`
+	Severity      string                 `json:"severity"`
+	QueryTemplate string                 `json:"query_template,omitempty"`
+	Enabled       bool                   `json:"enabled"`
+	Config        map[string]interface{} `json:"config,omitempty"`
+	Priority      int                    `json:"priority"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+}
+
+// ListGroups returns all groups
+func (c *Client) ListGroups(ctx context.Context) ([]Group, error) {
+	var groups []Group
+	return groups, c.do(ctx, http.MethodGet, "/api/v1/groups", nil, &groups)
+}
+
+// ListTargets returns all targets
+func (c *Client) ListTargets(ctx context.Context) ([]Target, error) {
+	var targets []Target
+	return targets, c.do(ctx, http.MethodGet, "/api/v1/targets", nil, &targets)
+}
+
+// ListAlertRules returns all alert rules
+func (c *Client) ListAlertRules(ctx context.Context) ([]AlertRule, error) {
+	var rules []AlertRule
+	return rules, c.do(ctx, http.MethodGet, "/api/v1/alert-rules", nil, &rules)
+}
+
+// ListTargetsByGroup returns the targets of a group
+func (c *Client) ListTargetsByGroup(ctx context.Context, groupID string) ([]Target, error) {
+	var targets []Target
+	return targets, c.do(ctx, http.MethodGet, "/api/v1/targets/group/"+url.PathEscape(groupID), nil, &targets)
+}
+
+// FindTarget returns the target with a hostname or ID
+func (c *Client) FindTarget(ctx context.Context, ref string) (*Target, error) {
+	var target Target
+	err := c.do(ctx, http.MethodGet, "/api/v1/targets/hostname/"+url.PathEscape(ref), nil, &target)
+	if IsNotFound(err) {
+		err = c.do(ctx, http.MethodGet, "/api/v1/targets/"+url.PathEscape(ref), nil, &target)
+	}
+	if IsNotFound(err) {
+		return nil, fmt.Errorf("target %q not found", ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// UpdateTarget replaces a target
+func (c *Client) UpdateTarget(ctx context.Context, id string, req TargetRequest) (*Target, error) {
+	var target Target
+	if err := c.do(ctx, http.MethodPut, "/api/v1/targets/"+url.PathEscape(id), req, &target); err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// SetTargetStatus changes the status of a target
+func (c *Client) SetTargetStatus(ctx context.Context, id, status string) error {
+	body := struct {
+		Status string `json:"status"`
+	}{status}
+	return c.do(ctx, http.MethodPost, "/api/v1/targets/"+url.PathEscape(id)+"/status", body, nil)
+}
+
+// DeleteTarget soft-deletes a target; the server can restore it
+func (c *Client) DeleteTarget(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/targets/delete", idRequest{ID: id}, nil)
+}
+
+// FindGroup returns the group with an ID or name
+func (c *Client) FindGroup(ctx context.Context, ref string) (*Group, error) {
+	groups, err := c.ListGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		if groups[i].ID == ref || groups[i].Name == ref {
+			return &groups[i], nil
+		}
+	}
+	return nil, fmt.Errorf("group %q not found", ref)
+}
+
+// AlertRuleRequest creates or replaces an alert rule: from a template with
+// TemplateID and Config, or directly with Name, Severity and QueryTemplate.
+type AlertRuleRequest struct {
+	GroupID       string                 `json:"group_id"`
+	TemplateID    string                 `json:"template_id,omitempty"`
+	Name          string                 `json:"name,omitempty"`
+	Description   string                 `json:"description,omitempty"`
+	Severity      string                 `json:"severity,omitempty"`
+	QueryTemplate string                 `json:"query_template,omitempty"`
+	Enabled       bool                   `json:"enabled"`
+	Config        map[string]interface{} `json:"config,omitempty"`
+	Priority      int                    `json:"priority,omitempty"`
+}
+
+// Request returns the request that recreates the rule
+func (r *AlertRule) Request() AlertRuleRequest {
+	return AlertRuleRequest{
+		GroupID:       r.GroupID,
+		TemplateID:    r.TemplateID,
+		Name:          r.Name,
+		Description:   r.Description,
+		Severity:      r.Severity,
+		QueryTemplate: r.QueryTemplate,
+		Enabled:       r.Enabled,
+		Config:        r.Config,
+		Priority:      r.Priority,
+	}
+}
+
+// ListAlertRulesByGroup returns the alert rules of a group
+func (c *Client) ListAlertRulesByGroup(ctx context.Context, groupID string) ([]AlertRule, error) {
+	var rules []AlertRule
+	return rules, c.do(ctx, http.MethodGet, "/api/v1/alert-rules/group/"+url.PathEscape(groupID), nil, &rules)
+}
+
+// ListAlertRulesByTemplate returns the alert rules made from a template
+func (c *Client) ListAlertRulesByTemplate(ctx context.Context, templateID string) ([]AlertRule, error) {
+	var rules []AlertRule
+	return rules, c.do(ctx, http.MethodGet, "/api/v1/alert-rules/template/"+url.PathEscape(templateID), nil, &rules)
+}
+
+// GetAlertRule returns an alert rule
+func (c *Client) GetAlertRule(ctx context.Context, id string) (*AlertRule, error) {
+	var rule AlertRule
+	if err := c.do(ctx, http.MethodGet, "/api/v1/alert-rules/"+url.PathEscape(id), nil, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// FindAlertRule returns the alert rule with an ID, else the only one with
+// that name
+func (c *Client) FindAlertRule(ctx context.Context, ref string) (*AlertRule, error) {
+	rule, err := c.GetAlertRule(ctx, ref)
+	if !IsNotFound(err) {
+		return rule, err
+	}
+	rules, err := c.ListAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found *AlertRule
+	for i := range rules {
+		if rules[i].Name != ref {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several alert rules are named %s: use the ID", ref)
+		}
+		found = &rules[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("alert rule %q not found", ref)
+	}
+	return found, nil
+}
+
+// CreateAlertRule creates an alert rule
+func (c *Client) CreateAlertRule(ctx context.Context, req AlertRuleRequest) (*AlertRule, error) {
+	var rule AlertRule
+	if err := c.do(ctx, http.MethodPost, "/api/v1/alert-rules", req, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateAlertRule replaces an alert rule
+func (c *Client) UpdateAlertRule(ctx context.Context, id string, req AlertRuleRequest) (*AlertRule, error) {
+	var rule AlertRule
+	if err := c.do(ctx, http.MethodPut, "/api/v1/alert-rules/"+url.PathEscape(id), req, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// DeleteAlertRule soft-deletes an alert rule; the server can restore it
+func (c *Client) DeleteAlertRule(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/alert-rules/delete", idRequest{ID: id}, nil)
+}
+
+// idRequest is the body of the delete, restore and purge endpoints
+type idRequest struct {
+	ID string `json:"id"`
+}
+
+// do sends a request with a JSON body, if any, and decodes the response
+// into out, if given; a *[]byte out gets the response as is
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("config server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("config server: decode: %w", err)
+	}
+	return nil
+}
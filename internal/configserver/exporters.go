@@ -0,0 +1,38 @@
+package configserver
+
+import (
+	"context"
+	"net/http"
+)
+
+// Exporter is a Prometheus exporter of a target: where to scrape it, and
+// how
+type Exporter struct {
+	ID             string            `json:"id"`
+	TargetID       string            `json:"target_id"`
+	Type           string            `json:"type"` // e.g. node_exporter
+	Port           int               `json:"port"`
+	Path           string            `json:"path,omitempty"`
+	Scheme         string            `json:"scheme,omitempty"` // http or https
+	TLS            *ExporterTLS      `json:"tls_config,omitempty"`
+	ScrapeInterval string            `json:"scrape_interval,omitempty"`
+	ScrapeTimeout  string            `json:"scrape_timeout,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Enabled        bool              `json:"enabled"`
+}
+
+// ExporterTLS is how Prometheus connects to an exporter over HTTPS. Files
+// are paths on the Prometheus host.
+type ExporterTLS struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// ListExporters returns the exporters of all targets
+func (c *Client) ListExporters(ctx context.Context) ([]Exporter, error) {
+	var exporters []Exporter
+	return exporters, c.do(ctx, http.MethodGet, "/api/v1/exporters", nil, &exporters)
+}
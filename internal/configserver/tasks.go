@@ -0,0 +1,107 @@
+package configserver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Agent task types, run by the node agent
+const (
+	TaskDiagnostics     = "diagnostics"
+	TaskCheckNow        = "check-now"
+	TaskRestartExporter = "restart-exporter"
+	TaskConfigRefresh   = "config-refresh"
+	TaskGPUValidate     = "gpu-validate"
+)
+
+// TaskTypes returns the task types agents run
+func TaskTypes() []string {
+	return []string{TaskCheckNow, TaskRestartExporter, TaskDiagnostics, TaskConfigRefresh, TaskGPUValidate}
+}
+
+// Agent task statuses. A task is pending until a heartbeat delivers it,
+// then delivered until the agent reports its result on a later heartbeat.
+const (
+	TaskPending   = "pending"
+	TaskDelivered = "delivered"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+	TaskExpired   = "expired"
+	TaskCanceled  = "canceled"
+)
+
+// AgentTask is a task queued for the agent of a node.
+type AgentTask struct {
+	ID          string                 `json:"id"`
+	Hostname    string                 `json:"hostname"`
+	Type        string                 `json:"type"`
+	Priority    int                    `json:"priority"`
+	Args        map[string]interface{} `json:"args,omitempty"`
+	Status      string                 `json:"status"`
+	Message     string                 `json:"message,omitempty"` // result reported by the agent
+	RequestedBy string                 `json:"requested_by,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	ExpiresAt   *time.Time             `json:"expires_at,omitempty"`
+	DeliveredAt *time.Time             `json:"delivered_at,omitempty"`
+	FinishedAt  *time.Time             `json:"finished_at,omitempty"`
+}
+
+// Done reports whether the task reached a final status
+func (t *AgentTask) Done() bool {
+	switch t.Status {
+	case TaskSucceeded, TaskFailed, TaskExpired, TaskCanceled:
+		return true
+	}
+	return false
+}
+
+// AgentTaskRequest queues a task for the agent of a node.
+type AgentTaskRequest struct {
+	Type     string                 `json:"type"`
+	Priority int                    `json:"priority,omitempty"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+	// TTL is how long, in seconds, the task may wait for delivery before
+	// it expires; no expiry if zero
+	TTL int `json:"ttl,omitempty"`
+}
+
+// QueueAgentTask queues a task for the agent of a node; the agent picks it
+// up on its next heartbeat
+func (c *Client) QueueAgentTask(ctx context.Context, hostname string, req AgentTaskRequest) (*AgentTask, error) {
+	var task AgentTask
+	if err := c.do(ctx, http.MethodPost, "/api/v1/agents/"+url.PathEscape(hostname)+"/tasks", req, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// ListAgentTasks returns the tasks of a node, newest first, optionally only
+// those with a status
+func (c *Client) ListAgentTasks(ctx context.Context, hostname, status string) ([]AgentTask, error) {
+	path := "/api/v1/agents/" + url.PathEscape(hostname) + "/tasks"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(status)
+	}
+	var tasks []AgentTask
+	return tasks, c.do(ctx, http.MethodGet, path, nil, &tasks)
+}
+
+// GetAgentTask returns a task of a node
+func (c *Client) GetAgentTask(ctx context.Context, hostname, id string) (*AgentTask, error) {
+	var task AgentTask
+	if err := c.do(ctx, http.MethodGet, "/api/v1/agents/"+url.PathEscape(hostname)+"/tasks/"+url.PathEscape(id), nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// CancelAgentTask cancels a task that has not been delivered yet
+func (c *Client) CancelAgentTask(ctx context.Context, hostname, id string) (*AgentTask, error) {
+	var task AgentTask
+	if err := c.do(ctx, http.MethodPost, "/api/v1/agents/"+url.PathEscape(hostname)+"/tasks/"+url.PathEscape(id)+"/cancel", nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
@@ -0,0 +1,223 @@
+package configserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AlertTemplate is a reusable alert rule: a query template, the variables
+// its settings must fit and their default values.
+type AlertTemplate struct {
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Description   string                 `json:"description,omitempty"`
+	Severity      string                 `json:"severity"`
+	QueryTemplate string                 `json:"query_template"`
+	Variables     []TemplateVariable     `json:"variables,omitempty"`
+	DefaultConfig map[string]interface{} `json:"default_config,omitempty"`
+}
+
+// Types of template variables
+const (
+	VariableNumber   = "number"
+	VariableInteger  = "integer"
+	VariableString   = "string"
+	VariableBoolean  = "boolean"
+	VariableDuration = "duration" // Prometheus duration, e.g. 5m
+)
+
+// TemplateVariable describes a setting of an alert template. Min and Max
+// bound numbers; Enum, when given, lists the only values allowed.
+type TemplateVariable struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Min         *float64      `json:"min,omitempty"`
+	Max         *float64      `json:"max,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+}
+
+var promDuration = regexp.MustCompile(`^(\d+y)?(\d+w)?(\d+d)?(\d+h)?(\d+m)?(\d+s)?(\d+ms)?$`)
+
+// ListAlertTemplates returns all alert templates
+func (c *Client) ListAlertTemplates(ctx context.Context) ([]AlertTemplate, error) {
+	var templates []AlertTemplate
+	return templates, c.do(ctx, http.MethodGet, "/api/v1/alert-templates", nil, &templates)
+}
+
+// GetAlertTemplate returns an alert template
+func (c *Client) GetAlertTemplate(ctx context.Context, id string) (*AlertTemplate, error) {
+	var template AlertTemplate
+	if err := c.do(ctx, http.MethodGet, "/api/v1/alert-templates/"+url.PathEscape(id), nil, &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// Variable returns the variable of a template with a name
+func (t *AlertTemplate) Variable(name string) (TemplateVariable, bool) {
+	for _, v := range t.Variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return TemplateVariable{}, false
+}
+
+// Missing returns the required variables with no value in config nor a
+// default
+func (t *AlertTemplate) Missing(config map[string]interface{}) []TemplateVariable {
+	var missing []TemplateVariable
+	for _, v := range t.Variables {
+		if v.Required && config[v.Name] == nil && t.DefaultConfig[v.Name] == nil {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
+
+// ValidateConfig checks the settings of a rule made from the template:
+// each must be a variable of the template and fit it, and the required
+// ones must be set or have a default. Templates without variables take any
+// settings.
+func (t *AlertTemplate) ValidateConfig(config map[string]interface{}) error {
+	if len(t.Variables) == 0 {
+		return nil
+	}
+	var problems []string
+	for name, value := range config {
+		v, ok := t.Variable(name)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %s (want %s)", name, strings.Join(t.variableNames(), ", ")))
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if err := v.Check(value); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, v := range t.Missing(config) {
+		problems = append(problems, fmt.Sprintf("%s is required", v.Name))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid settings for template %s: %s", t.ID, strings.Join(problems, "; "))
+}
+
+func (t *AlertTemplate) variableNames() []string {
+	names := make([]string, len(t.Variables))
+	for i, v := range t.Variables {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// Check reports whether a value fits the variable
+func (v TemplateVariable) Check(value interface{}) error {
+	switch v.Type {
+	case VariableNumber, VariableInteger:
+		n, ok := number(value)
+		if !ok {
+			return fmt.Errorf("%s must be a number, not %v", v.Name, value)
+		}
+		if v.Type == VariableInteger && n != math.Trunc(n) {
+			return fmt.Errorf("%s must be an integer, not %v", v.Name, value)
+		}
+		if v.Min != nil && n < *v.Min {
+			return fmt.Errorf("%s must be at least %v, not %v", v.Name, *v.Min, value)
+		}
+		if v.Max != nil && n > *v.Max {
+			return fmt.Errorf("%s must be at most %v, not %v", v.Name, *v.Max, value)
+		}
+	case VariableString:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string, not %v", v.Name, value)
+		}
+	case VariableBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be true or false, not %v", v.Name, value)
+		}
+	case VariableDuration:
+		s, ok := value.(string)
+		if !ok || s == "" || !promDuration.MatchString(s) {
+			return fmt.Errorf("%s must be a duration such as 5m, not %v", v.Name, value)
+		}
+	}
+	if len(v.Enum) > 0 {
+		for _, allowed := range v.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s, not %v", v.Name, v.EnumText(), value)
+	}
+	return nil
+}
+
+// Parse reads a value of the variable's type from text, as given on a
+// command line; Check tells whether it fits. Variables of no known type
+// take JSON, else the text.
+func (v TemplateVariable) Parse(text string) (interface{}, error) {
+	var value interface{}
+	switch v.Type {
+	case VariableNumber, VariableInteger:
+		n, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, not %q", v.Name, text)
+		}
+		value = n
+	case VariableBoolean:
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, not %q", v.Name, text)
+		}
+		value = b
+	case VariableString, VariableDuration:
+		value = text
+	default:
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			value = text
+		}
+	}
+	return value, nil
+}
+
+// EnumText lists the allowed values of the variable
+func (v TemplateVariable) EnumText() string {
+	values := make([]string, len(v.Enum))
+	for i, e := range v.Enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+// number returns a JSON or YAML number as a float64
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
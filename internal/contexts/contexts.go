@@ -0,0 +1,169 @@
+// Package contexts stores the contexts of the CLI in ~/.aami/config: named
+// sets of endpoints and credentials, one of which is active, like the
+// contexts of a kubeconfig.
+package contexts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvContext selects the context for one shell, over current-context
+const EnvContext = "AAMI_CONTEXT"
+
+// Context is where a context's commands go and the credentials they use.
+// Empty endpoints fall back to the local stack.
+type Context struct {
+	Name            string `yaml:"name" json:"name"`
+	Server          string `yaml:"server,omitempty" json:"server,omitempty"` // config server
+	Token           string `yaml:"token,omitempty" json:"-"`                 // bearer token, supports ${ENV_VAR}
+	Namespace       string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	PrometheusURL   string `yaml:"prometheus-url,omitempty" json:"prometheus_url,omitempty"`
+	AlertmanagerURL string `yaml:"alertmanager-url,omitempty" json:"alertmanager_url,omitempty"`
+	OIDC            *Login `yaml:"oidc,omitempty" json:"oidc,omitempty"` // aami login
+}
+
+// Login is an OIDC login of a context: the provider it was made with and
+// the tokens it got. The ID token is sent to the config server.
+type Login struct {
+	Issuer       string    `yaml:"issuer" json:"issuer"`
+	ClientID     string    `yaml:"client-id" json:"client_id"`
+	User         string    `yaml:"user,omitempty" json:"user,omitempty"`
+	IDToken      string    `yaml:"id-token,omitempty" json:"-"`
+	RefreshToken string    `yaml:"refresh-token,omitempty" json:"-"`
+	Expiry       time.Time `yaml:"expiry,omitempty" json:"expiry,omitempty"`
+}
+
+// LoggedIn reports whether the context has an OIDC login
+func (c *Context) LoggedIn() bool {
+	return c.OIDC != nil && c.OIDC.IDToken != ""
+}
+
+// BearerToken returns the token with ${ENV_VAR} references expanded
+func (c *Context) BearerToken() string {
+	return envVar.ReplaceAllStringFunc(c.Token, func(match string) string {
+		return os.Getenv(match[2 : len(match)-1])
+	})
+}
+
+var envVar = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// File is a contexts file.
+type File struct {
+	CurrentContext string    `yaml:"current-context"`
+	Contexts       []Context `yaml:"contexts"`
+
+	path string
+}
+
+// DefaultPath returns ~/.aami/config
+func DefaultPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("find home directory: %w", err)
+	}
+	return filepath.Join(home, ".aami", "config"), nil
+}
+
+// Load reads a contexts file. A missing file has no contexts.
+func Load(path string) (*File, error) {
+	f := &File{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Path returns where the file is stored
+func (f *File) Path() string {
+	return f.path
+}
+
+// Save writes the file, readable only by its owner as it holds tokens
+func (f *File) Save() error {
+	sort.Slice(f.Contexts, func(i, j int) bool { return f.Contexts[i].Name < f.Contexts[j].Name })
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// Get returns the context with a name
+func (f *File) Get(name string) (*Context, bool) {
+	for i := range f.Contexts {
+		if f.Contexts[i].Name == name {
+			return &f.Contexts[i], true
+		}
+	}
+	return nil, false
+}
+
+// Set adds a context, or replaces the one with its name
+func (f *File) Set(c Context) {
+	if existing, ok := f.Get(c.Name); ok {
+		*existing = c
+		return
+	}
+	f.Contexts = append(f.Contexts, c)
+}
+
+// Delete removes a context, and makes no context current if it was
+func (f *File) Delete(name string) bool {
+	for i, c := range f.Contexts {
+		if c.Name == name {
+			f.Contexts = append(f.Contexts[:i], f.Contexts[i+1:]...)
+			if f.CurrentContext == name {
+				f.CurrentContext = ""
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns the context named name, else the one named by
+// $AAMI_CONTEXT, else the current context. It is nil when none is
+// selected.
+func (f *File) Active(name string) (*Context, error) {
+	if name == "" {
+		name = os.Getenv(EnvContext)
+	}
+	if name == "" {
+		name = f.CurrentContext
+	}
+	if name == "" {
+		return nil, nil
+	}
+	c, ok := f.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("context %q not found in %s", name, f.path)
+	}
+	return c, nil
+}
@@ -0,0 +1,251 @@
+// Package debugbundle stores the diagnostic bundles node agents upload for
+// support cases: a tarball of kernel messages, the NVIDIA bug report,
+// exporter logs and the agent's recent check results. Each bundle is kept
+// next to a JSON description, under <target>/ in a local directory or a
+// bucket.
+package debugbundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/storage"
+)
+
+// DefaultDir is where bundles are stored without object storage
+const DefaultDir = "/var/lib/aami/debug-bundles"
+
+// MaxSize is the largest bundle an agent may upload
+const MaxSize = 256 << 20
+
+// ErrNotFound is returned for a bundle that does not exist
+var ErrNotFound = errors.New("debug bundle not found")
+
+// Bundle describes an uploaded debug bundle.
+type Bundle struct {
+	ID         string    `json:"id"`
+	Target     string    `json:"target"`            // hostname of the node
+	TaskID     string    `json:"task_id,omitempty"` // agent task that collected it
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Files      []string  `json:"files"` // paths in the tarball
+	Location   string    `json:"location"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// Key is the storage key of the tarball
+func (b Bundle) Key() string {
+	return b.Target + "/" + b.ID + ".tar.gz"
+}
+
+// Store reads and writes bundles.
+type Store struct {
+	backend storage.Backend
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewStore creates a bundle store on a storage backend.
+func NewStore(backend storage.Backend) *Store {
+	return &Store{backend: backend, now: time.Now}
+}
+
+// Put stores a bundle uploaded by the agent of a node. The data must be a
+// gzipped tarball; taskID is the agent task that collected it, if any.
+func (s *Store) Put(target, taskID string, data []byte) (Bundle, error) {
+	if err := validName("target", target); err != nil {
+		return Bundle{}, err
+	}
+	if taskID != "" {
+		if err := validName("task", taskID); err != nil {
+			return Bundle{}, err
+		}
+	}
+	if len(data) > MaxSize {
+		return Bundle{}, fmt.Errorf("bundle is %d bytes, at most %d allowed", len(data), MaxSize)
+	}
+	files, err := listFiles(data)
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	now := s.now().UTC()
+	sum := sha256.Sum256(data)
+	b := Bundle{
+		ID:         now.Format("20060102-150405"),
+		Target:     target,
+		TaskID:     taskID,
+		Size:       int64(len(data)),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Files:      files,
+		UploadedAt: now,
+	}
+	if taskID != "" {
+		b.ID += "-" + taskID
+	}
+	b.Location = s.backend.Location(b.Key())
+
+	meta, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return Bundle{}, fmt.Errorf("marshal bundle: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.backend.Put(b.Key(), data); err != nil {
+		return Bundle{}, err
+	}
+	// The description is written last, so listed bundles are complete
+	if err := s.backend.Put(metaKey(target, b.ID), meta); err != nil {
+		return Bundle{}, err
+	}
+	return b, nil
+}
+
+// Get returns the description of a bundle.
+func (s *Store) Get(target, id string) (Bundle, error) {
+	if err := validName("target", target); err != nil {
+		return Bundle{}, err
+	}
+	if err := validName("bundle", id); err != nil {
+		return Bundle{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(metaKey(target, id))
+}
+
+// Open returns the tarball of a bundle.
+func (s *Store) Open(b Bundle) ([]byte, error) {
+	data, err := s.backend.Get(b.Key())
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, b.Target, b.ID)
+	}
+	return data, err
+}
+
+// FindTask returns the bundle a task of a node uploaded.
+func (s *Store) FindTask(target, taskID string) (Bundle, error) {
+	bundles, err := s.List(target)
+	if err != nil {
+		return Bundle{}, err
+	}
+	for _, b := range bundles {
+		if b.TaskID == taskID {
+			return b, nil
+		}
+	}
+	return Bundle{}, fmt.Errorf("%w: no bundle of task %s on %s", ErrNotFound, taskID, target)
+}
+
+// List returns the bundles of a node, or of every node if target is empty,
+// newest first.
+func (s *Store) List(target string) ([]Bundle, error) {
+	prefix := ""
+	if target != "" {
+		if err := validName("target", target); err != nil {
+			return nil, err
+		}
+		prefix = target + "/"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, err := s.backend.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	bundles := []Bundle{}
+	for _, obj := range objects {
+		if path.Ext(obj.Key) != ".json" || strings.Count(obj.Key, "/") != 1 {
+			continue
+		}
+		b, err := s.read(obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, b)
+	}
+	sort.Slice(bundles, func(i, j int) bool {
+		return bundles[i].UploadedAt.After(bundles[j].UploadedAt)
+	})
+	return bundles, nil
+}
+
+// Link returns a download link to a bundle that needs no credentials, if
+// the backend hands them out.
+func (s *Store) Link(b Bundle, ttl time.Duration) (string, bool, error) {
+	linker, ok := s.backend.(storage.Linker)
+	if !ok {
+		return "", false, nil
+	}
+	link, err := linker.Link(b.Key(), ttl)
+	return link, true, err
+}
+
+func (s *Store) read(key string) (Bundle, error) {
+	data, err := s.backend.Get(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return Bundle{}, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(key, ".json"))
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Bundle{}, fmt.Errorf("parse bundle %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func metaKey(target, id string) string {
+	return target + "/" + id + ".json"
+}
+
+// listFiles returns the paths of the regular files in a gzipped tarball,
+// failing if it is not one
+func listFiles(data []byte) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("bundle is not gzipped: %w", err)
+	}
+	defer gz.Close()
+
+	files := []string{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bundle is not a tarball: %w", err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			files = append(files, h.Name)
+		}
+	}
+	return files, nil
+}
+
+func validName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s is required", kind)
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid %s %q", kind, name)
+	}
+	return nil
+}
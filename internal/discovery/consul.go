@@ -0,0 +1,167 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// SourceConsul is the source of nodes registered from Consul
+const SourceConsul = "consul"
+
+// DefaultConsulAddress is the local Consul agent
+const DefaultConsulAddress = "http://localhost:8500"
+
+// Consul node metadata read by discovery
+const (
+	// LabelMetaPrefix marks metadata that becomes node labels:
+	// aami_label_rack=a1 becomes rack=a1
+	LabelMetaPrefix = "aami_label_"
+	// IgnoreMeta set to "true" leaves a node out
+	IgnoreMeta = "aami_ignore"
+	// SSHUserMeta overrides discovery.consul.ssh_user
+	SSHUserMeta = "aami_ssh_user"
+)
+
+// Consul discovers nodes from a Consul catalog: all its nodes, or the ones
+// running a passing instance of the configured services.
+type Consul struct {
+	cfg    config.ConsulDiscoveryConfig
+	client *http.Client
+}
+
+// NewConsul creates a Consul source.
+func NewConsul(cfg config.ConsulDiscoveryConfig) *Consul {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultConsulAddress
+	}
+	if !strings.Contains(cfg.Address, "://") {
+		cfg.Address = "http://" + cfg.Address
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return &Consul{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Address returns the Consul HTTP API address.
+func (c *Consul) Address() string { return c.cfg.Address }
+
+// consulNode is a catalog node
+type consulNode struct {
+	Node    string            `json:"Node"`
+	Address string            `json:"Address"`
+	Meta    map[string]string `json:"Meta"`
+}
+
+// Nodes lists the catalog's nodes as AAMI nodes, by name.
+func (c *Consul) Nodes(ctx context.Context) ([]config.NodeConfig, error) {
+	var catalog []consulNode
+	if len(c.cfg.Services) == 0 {
+		if err := c.get(ctx, "/v1/catalog/nodes", url.Values{}, &catalog); err != nil {
+			return nil, err
+		}
+	}
+	for _, service := range c.cfg.Services {
+		var entries []struct {
+			Node consulNode `json:"Node"`
+		}
+		query := url.Values{"passing": {"1"}}
+		if err := c.get(ctx, "/v1/health/service/"+url.PathEscape(service), query, &entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			catalog = append(catalog, e.Node)
+		}
+	}
+
+	seen := map[string]bool{}
+	var nodes []config.NodeConfig
+	for _, n := range catalog {
+		if seen[n.Node] {
+			continue
+		}
+		seen[n.Node] = true
+		if node, ok := c.toNode(n); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, nil
+}
+
+// toNode converts a catalog node; nodes ignored or not matching node_meta
+// are left out
+func (c *Consul) toNode(n consulNode) (config.NodeConfig, bool) {
+	if strings.EqualFold(n.Meta[IgnoreMeta], "true") || n.Address == "" {
+		return config.NodeConfig{}, false
+	}
+	for k, v := range c.cfg.NodeMeta {
+		if n.Meta[k] != v {
+			return config.NodeConfig{}, false
+		}
+	}
+
+	node := config.NodeConfig{
+		Name:    n.Node,
+		IP:      n.Address,
+		SSHUser: c.cfg.SSHUser,
+		SSHKey:  c.cfg.SSHKey,
+	}
+	if user := n.Meta[SSHUserMeta]; user != "" {
+		node.SSHUser = user
+	}
+	for key, value := range n.Meta {
+		if name := strings.TrimPrefix(key, LabelMetaPrefix); name != key && name != "" {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[name] = value
+		}
+	}
+	return node, true
+}
+
+// get reads a Consul API endpoint into out
+func (c *Consul) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	if c.cfg.Datacenter != "" {
+		query.Set("dc", c.cfg.Datacenter)
+	}
+	for k, v := range c.cfg.NodeMeta {
+		query.Add("node-meta", k+":"+v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Address+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul %s: HTTP %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("consul %s: decode: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Package discovery registers nodes from other inventories, such as a
+// Kubernetes cluster or a Consul catalog, in the AAMI node list.
+package discovery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Actions of a sync
+const (
+	ActionAdded    = "added"
+	ActionUpdated  = "updated"
+	ActionRemoved  = "removed"
+	ActionAdopted  = "adopted"
+	ActionConflict = "conflict"
+)
+
+// Change is what a sync did to a node
+type Change struct {
+	Action string `json:"action"`
+	Node   string `json:"node"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Conflict strategies for discovered nodes whose name is taken by a node
+// added by hand
+const (
+	ConflictOurs   = "ours"   // keep the node as it is
+	ConflictTheirs = "theirs" // the source takes the node over
+)
+
+// ParseConflict validates a conflict strategy; empty means ours.
+func ParseConflict(s string) (string, error) {
+	switch s {
+	case "":
+		return ConflictOurs, nil
+	case ConflictOurs, ConflictTheirs:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q (valid: ours, theirs)", s)
+}
+
+// Apply brings the nodes a source registered in line with what it
+// discovered: new nodes are added, changed ones updated, and the ones it no
+// longer sees removed.
+//
+// A discovered node whose name is taken by a node added by hand is a
+// conflict, resolved by conflict: ours leaves the node alone, theirs hands
+// it to the source. Nodes of other sources are always left alone, as are
+// discovered nodes with the IP of a node of another name.
+func Apply(cfg *config.Config, source string, discovered []config.NodeConfig, conflict string) []Change {
+	found := make(map[string]config.NodeConfig, len(discovered))
+	for _, n := range discovered {
+		n.Source = source
+		found[n.Name] = n
+	}
+
+	var changes []Change
+	ipOwners := map[string]string{}
+	nodes := make([]config.NodeConfig, 0, len(cfg.Nodes)+len(discovered))
+	for _, node := range cfg.Nodes {
+		d, ok := found[node.Name]
+		switch {
+		case node.Source == "" && ok && conflict == ConflictTheirs:
+			detail := "was added by hand"
+			if diff := nodeDiff(node, d); diff != "" {
+				detail += "; " + diff
+			}
+			changes = append(changes, Change{Action: ActionAdopted, Node: node.Name, Detail: detail})
+			node = d
+			delete(found, node.Name)
+		case node.Source != source:
+			if ok {
+				changes = append(changes, Change{Action: ActionConflict, Node: node.Name,
+					Detail: conflictDetail(node.Source)})
+				delete(found, node.Name)
+			}
+		case !ok:
+			changes = append(changes, Change{Action: ActionRemoved, Node: node.Name})
+			continue
+		default:
+			if diff := nodeDiff(node, d); diff != "" {
+				changes = append(changes, Change{Action: ActionUpdated, Node: node.Name, Detail: diff})
+				node = d
+			}
+			delete(found, node.Name)
+		}
+		nodes = append(nodes, node)
+		ipOwners[node.IP] = node.Name
+	}
+
+	added := make([]string, 0, len(found))
+	for name := range found {
+		added = append(added, name)
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		n := found[name]
+		if owner, ok := ipOwners[n.IP]; ok {
+			changes = append(changes, Change{Action: ActionConflict, Node: name,
+				Detail: fmt.Sprintf("%s is node %s", n.IP, owner)})
+			continue
+		}
+		nodes = append(nodes, n)
+		ipOwners[n.IP] = name
+		changes = append(changes, Change{Action: ActionAdded, Node: name, Detail: n.IP})
+	}
+
+	cfg.Nodes = nodes
+	return changes
+}
+
+// Changed reports whether changes modify the node list; conflicts do not.
+func Changed(changes []Change) bool {
+	for _, c := range changes {
+		if c.Action != ActionConflict {
+			return true
+		}
+	}
+	return false
+}
+
+func conflictDetail(owner string) string {
+	if owner == "" {
+		return "node of this name added by hand"
+	}
+	return "node of this name registered by " + owner
+}
+
+// nodeDiff describes how a registered node differs from its discovered state
+func nodeDiff(old, new config.NodeConfig) string {
+	var diffs []string
+	if old.IP != new.IP {
+		diffs = append(diffs, fmt.Sprintf("ip %s -> %s", old.IP, new.IP))
+	}
+	if old.SSHUser != new.SSHUser || old.SSHKey != new.SSHKey || old.SSHPort != new.SSHPort {
+		diffs = append(diffs, "ssh settings")
+	}
+	if !sameLabels(old.Labels, new.Labels) {
+		diffs = append(diffs, "labels")
+	}
+	return strings.Join(diffs, ", ")
+}
+
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// In-cluster service account, as mounted into every pod
+const (
+	serviceAccountDir       = "/var/run/secrets/kubernetes.io/serviceaccount"
+	serviceAccountTokenFile = serviceAccountDir + "/token"
+	serviceAccountCAFile    = serviceAccountDir + "/ca.crt"
+)
+
+// connection is how to reach the Kubernetes API server
+type connection struct {
+	server    string
+	token     string
+	tokenFile string // re-read on every request, as service account tokens rotate
+	tls       *tls.Config
+}
+
+// kubeconfig is the part of a kubeconfig file discovery needs
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string      `yaml:"token"`
+			TokenFile             string      `yaml:"tokenFile"`
+			ClientCertificate     string      `yaml:"client-certificate"`
+			ClientCertificateData string      `yaml:"client-certificate-data"`
+			ClientKey             string      `yaml:"client-key"`
+			ClientKeyData         string      `yaml:"client-key-data"`
+			Exec                  interface{} `yaml:"exec"`
+			AuthProvider          interface{} `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// connect finds the API server: the kubeconfig given, else the in-cluster
+// service account, else $KUBECONFIG or ~/.kube/config.
+func connect(path, context string) (*connection, error) {
+	if path != "" {
+		return loadKubeconfig(path, context)
+	}
+	if host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"); host != "" && port != "" {
+		return inCluster(host, port)
+	}
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return loadKubeconfig(filepath.SplitList(env)[0], context)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".kube", "config")
+		if _, err := os.Stat(path); err == nil {
+			return loadKubeconfig(path, context)
+		}
+	}
+	return nil, fmt.Errorf("no Kubernetes cluster found: not running in a pod, and no kubeconfig (set discovery.kubernetes.kubeconfig)")
+}
+
+func inCluster(host, port string) (*connection, error) {
+	pool, err := loadCAFile(serviceAccountCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &connection{
+		server:    "https://" + net.JoinHostPort(host, port),
+		tokenFile: serviceAccountTokenFile,
+		tls:       &tls.Config{RootCAs: pool},
+	}, nil
+}
+
+func loadKubeconfig(path, context string) (*connection, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read kubeconfig: %w", err)
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("parse kubeconfig %s: %w", path, err)
+	}
+	// Relative file references are relative to the kubeconfig
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(filepath.Dir(path), p)
+	}
+
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	var clusterName, userName string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == context {
+			clusterName, userName, found = c.Context.Cluster, c.Context.User, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("kubeconfig %s: context %q not found", path, context)
+	}
+
+	conn := &connection{tls: &tls.Config{}}
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		conn.server = c.Cluster.Server
+		conn.tls.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		switch {
+		case c.Cluster.CertificateAuthorityData != "":
+			pem, err := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: certificate-authority-data: %w", path, err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("kubeconfig %s: no certificates in certificate-authority-data", path)
+			}
+			conn.tls.RootCAs = pool
+		case c.Cluster.CertificateAuthority != "":
+			if conn.tls.RootCAs, err = loadCAFile(resolve(c.Cluster.CertificateAuthority)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !found || conn.server == "" {
+		return nil, fmt.Errorf("kubeconfig %s: cluster %q not found", path, clusterName)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		user := u.User
+		if user.Exec != nil || user.AuthProvider != nil {
+			return nil, fmt.Errorf("kubeconfig %s: user %q uses a credential plugin, which is not supported; use a service account token", path, userName)
+		}
+		conn.token = user.Token
+		conn.tokenFile = resolve(user.TokenFile)
+
+		certPEM, keyPEM := []byte(nil), []byte(nil)
+		if user.ClientCertificateData != "" {
+			if certPEM, err = base64.StdEncoding.DecodeString(user.ClientCertificateData); err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: client-certificate-data: %w", path, err)
+			}
+		} else if user.ClientCertificate != "" {
+			if certPEM, err = os.ReadFile(resolve(user.ClientCertificate)); err != nil {
+				return nil, fmt.Errorf("read client certificate: %w", err)
+			}
+		}
+		if user.ClientKeyData != "" {
+			if keyPEM, err = base64.StdEncoding.DecodeString(user.ClientKeyData); err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: client-key-data: %w", path, err)
+			}
+		} else if user.ClientKey != "" {
+			if keyPEM, err = os.ReadFile(resolve(user.ClientKey)); err != nil {
+				return nil, fmt.Errorf("read client key: %w", err)
+			}
+		}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: client certificate: %w", path, err)
+			}
+			conn.tls.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return conn, nil
+}
+
+func loadCAFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,275 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// SourceKubernetes is the source of nodes registered from Kubernetes
+const SourceKubernetes = "kubernetes"
+
+// Annotations read from Kubernetes Node objects
+const (
+	// LabelAnnotationPrefix marks annotations that become node labels:
+	// label.aami.io/rack=a1 becomes rack=a1
+	LabelAnnotationPrefix = "label.aami.io/"
+	// IgnoreAnnotation set to "true" leaves a node out
+	IgnoreAnnotation = "aami.io/ignore"
+	// SSHUserAnnotation overrides discovery.kubernetes.ssh_user
+	SSHUserAnnotation = "aami.io/ssh-user"
+)
+
+// Watch tuning
+const (
+	watchTimeout  = 5 * time.Minute  // the API server ends watches after this; nodes are relisted
+	settleDelay   = 5 * time.Second  // events within this are synced together
+	retryInterval = 30 * time.Second // after an error
+)
+
+// Kubernetes discovers nodes from a Kubernetes cluster through its API.
+type Kubernetes struct {
+	cfg    config.KubernetesDiscoveryConfig
+	conn   *connection
+	client *http.Client
+
+	// Logf reports watch progress and errors; nil discards them
+	Logf func(format string, args ...interface{})
+}
+
+// NewKubernetes connects to the cluster of cfg.
+func NewKubernetes(cfg config.KubernetesDiscoveryConfig) (*Kubernetes, error) {
+	conn, err := connect(cfg.Kubeconfig, cfg.Context)
+	if err != nil {
+		return nil, err
+	}
+	// No client timeout: watches stay open for watchTimeout
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conn.tls
+	return &Kubernetes{cfg: cfg, conn: conn, client: &http.Client{Transport: transport}}, nil
+}
+
+// Server returns the API server URL.
+func (k *Kubernetes) Server() string { return k.conn.server }
+
+// k8sNode is the part of a Node object discovery reads
+type k8sNode struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Status struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+// Nodes lists the cluster's nodes as AAMI nodes, by name, along with the
+// resource version to watch from.
+func (k *Kubernetes) Nodes(ctx context.Context) ([]config.NodeConfig, string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []k8sNode `json:"items"`
+	}
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	resp, err := k.get(ctx, url.Values{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", fmt.Errorf("decode node list: %w", err)
+	}
+
+	var nodes []config.NodeConfig
+	for _, item := range list.Items {
+		if node, ok := k.toNode(item); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes, list.Metadata.ResourceVersion, nil
+}
+
+// toNode converts a Node object; nodes ignored or without an address are
+// left out
+func (k *Kubernetes) toNode(n k8sNode) (config.NodeConfig, bool) {
+	ann := n.Metadata.Annotations
+	if strings.EqualFold(ann[IgnoreAnnotation], "true") {
+		return config.NodeConfig{}, false
+	}
+	addrs := map[string]string{}
+	for _, a := range n.Status.Addresses {
+		if _, ok := addrs[a.Type]; !ok {
+			addrs[a.Type] = a.Address
+		}
+	}
+	ip := addrs["InternalIP"]
+	if ip == "" {
+		ip = addrs["ExternalIP"]
+	}
+	if ip == "" {
+		return config.NodeConfig{}, false
+	}
+
+	node := config.NodeConfig{
+		Name:    n.Metadata.Name,
+		IP:      ip,
+		SSHUser: k.cfg.SSHUser,
+		SSHKey:  k.cfg.SSHKey,
+	}
+	if user := ann[SSHUserAnnotation]; user != "" {
+		node.SSHUser = user
+	}
+	for key, value := range ann {
+		if name := strings.TrimPrefix(key, LabelAnnotationPrefix); name != key && name != "" {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[name] = value
+		}
+	}
+	return node, true
+}
+
+// Watch calls sync with the cluster's nodes, then again whenever a node is
+// added, deleted or changes in a way AAMI sees, until ctx ends. Errors are
+// logged, and the nodes are synced again after retryInterval.
+func (k *Kubernetes) Watch(ctx context.Context, sync func([]config.NodeConfig) error) error {
+	for ctx.Err() == nil {
+		nodes, version, err := k.Nodes(ctx)
+		if err == nil {
+			if err = sync(nodes); err == nil {
+				err = k.waitForChange(ctx, version, nodes)
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		delay := settleDelay
+		if err != nil {
+			k.logf("kubernetes discovery: %v; retrying in %s", err, retryInterval)
+			delay = retryInterval
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	return nil
+}
+
+// waitForChange watches nodes from version until one of them differs from
+// synced or the watch ends. Status updates the kubelet makes every few
+// minutes leave the AAMI node as it is and are skipped.
+func (k *Kubernetes) waitForChange(ctx context.Context, version string, synced []config.NodeConfig) error {
+	known := make(map[string]config.NodeConfig, len(synced))
+	for _, n := range synced {
+		known[n.Name] = n
+	}
+
+	query := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {version},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {fmt.Sprint(int(watchTimeout.Seconds()))},
+	}
+	resp, err := k.get(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Each line is an event, {"type": "ADDED", "object": {...}}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event struct {
+			Type   string  `json:"type"`
+			Object k8sNode `json:"object"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("decode watch event: %w", err)
+		}
+		name := event.Object.Metadata.Name
+		old, wasKnown := known[name]
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			node, ok := k.toNode(event.Object)
+			if ok == wasKnown && (!ok || nodeDiff(old, node) == "") {
+				continue
+			}
+		case "DELETED":
+			if !wasKnown {
+				continue
+			}
+		case "ERROR":
+			// Usually 410 Gone: the version is too old, so relist
+			return nil
+		default: // BOOKMARK
+			continue
+		}
+		k.logf("kubernetes discovery: node %s %s", name, strings.ToLower(event.Type))
+		return nil
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// get requests the node list with query
+func (k *Kubernetes) get(ctx context.Context, query url.Values) (*http.Response, error) {
+	if k.cfg.LabelSelector != "" {
+		query.Set("labelSelector", k.cfg.LabelSelector)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimRight(k.conn.server, "/")+"/api/v1/nodes?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	token := k.conn.token
+	if k.conn.tokenFile != "" {
+		data, err := os.ReadFile(k.conn.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("list nodes: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (k *Kubernetes) logf(format string, args ...interface{}) {
+	if k.Logf != nil {
+		k.Logf(format, args...)
+	}
+}
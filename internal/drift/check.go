@@ -0,0 +1,127 @@
+package drift
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Drift states
+const (
+	StateModified  = "modified"
+	StateMissing   = "missing"
+	StateUnmanaged = "unmanaged"
+)
+
+// Drift is a generated file whose on-disk state differs from the manifest.
+type Drift struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	State    string `json:"state"`
+	Expected string `json:"expected_sha256,omitempty"`
+	Actual   string `json:"actual_sha256,omitempty"`
+}
+
+// watchedExtensions are the file types Prometheus loads from managed directories
+var watchedExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// Check compares every manifest entry with the file on disk. Files in rule
+// and target directories that AAMI did not generate are reported as
+// unmanaged, since Prometheus loads them too.
+func Check(m *Manifest) ([]Drift, error) {
+	var drifts []Drift
+	managedDirs := map[string]string{}
+
+	for _, e := range m.Sorted() {
+		if e.Kind == KindRules || e.Kind == KindTargets {
+			managedDirs[filepath.Dir(e.Path)] = e.Kind
+		}
+
+		data, err := os.ReadFile(e.Path)
+		if os.IsNotExist(err) {
+			drifts = append(drifts, Drift{Path: e.Path, Kind: e.Kind, State: StateMissing, Expected: e.SHA256})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", e.Path, err)
+		}
+		if sum := Hash(data); sum != e.SHA256 {
+			drifts = append(drifts, Drift{Path: e.Path, Kind: e.Kind, State: StateModified, Expected: e.SHA256, Actual: sum})
+		}
+	}
+
+	for dir, kind := range managedDirs {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			path := filepath.Join(dir, f.Name())
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !watchedExtensions[filepath.Ext(f.Name())] {
+				continue
+			}
+			if _, ok := m.Entries[path]; ok {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			drifts = append(drifts, Drift{Path: path, Kind: kind, State: StateUnmanaged, Actual: Hash(data)})
+		}
+	}
+
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Path < drifts[j].Path
+	})
+	return drifts, nil
+}
+
+// WriteMetrics writes drift results in Prometheus text format.
+func WriteMetrics(w io.Writer, m *Manifest, drifts []Drift, checkedAt time.Time) error {
+	counts := map[string]map[string]int{}
+	for _, e := range m.Entries {
+		if counts[e.Kind] == nil {
+			counts[e.Kind] = map[string]int{}
+		}
+	}
+	for _, d := range drifts {
+		if counts[d.Kind] == nil {
+			counts[d.Kind] = map[string]int{}
+		}
+		counts[d.Kind][d.State]++
+	}
+
+	kinds := make([]string, 0, len(counts))
+	for k := range counts {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+
+	detected := 0
+	if len(drifts) > 0 {
+		detected = 1
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP aami_drift_detected Generated files differ from what AAMI last wrote (1=drift)\n")
+	b.WriteString("# TYPE aami_drift_detected gauge\n")
+	fmt.Fprintf(&b, "aami_drift_detected %d\n", detected)
+	b.WriteString("# HELP aami_drift_files Drifted generated files by kind and state\n")
+	b.WriteString("# TYPE aami_drift_files gauge\n")
+	for _, kind := range kinds {
+		for _, state := range []string{StateModified, StateMissing, StateUnmanaged} {
+			fmt.Fprintf(&b, "aami_drift_files{kind=%q,state=%q} %d\n", kind, state, counts[kind][state])
+		}
+	}
+	b.WriteString("# HELP aami_drift_last_check_timestamp_seconds Time of the last drift check\n")
+	b.WriteString("# TYPE aami_drift_last_check_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "aami_drift_last_check_timestamp_seconds %d\n", checkedAt.Unix())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
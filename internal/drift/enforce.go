@@ -0,0 +1,191 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultAuditLog receives an event for every reverted change
+const DefaultAuditLog = "/var/log/aami/audit.log"
+
+// settleDelay lets AAMI finish writing a file and recording it in the
+// manifest before the change is judged
+const settleDelay = time.Second
+
+// AuditEvent records a reverted on-disk change.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Path   string    `json:"path"`
+	Kind   string    `json:"kind"`
+	State  string    `json:"state"`
+	Action string    `json:"action"` // restored, quarantined
+	Diff   string    `json:"diff,omitempty"`
+}
+
+// Enforcer restores generated files as soon as they drift.
+type Enforcer struct {
+	ManifestPath  string
+	AuditLog      string
+	QuarantineDir string // unmanaged files are moved here
+	Logf          func(format string, args ...interface{})
+}
+
+// Revert restores modified and missing files from the manifest and moves
+// unmanaged files to the quarantine directory. It returns the audit events.
+func (e *Enforcer) Revert(m *Manifest, drifts []Drift) ([]AuditEvent, error) {
+	var events []AuditEvent
+
+	for _, d := range drifts {
+		ev := AuditEvent{Time: time.Now().UTC(), Event: "drift_reverted", Path: d.Path, Kind: d.Kind, State: d.State}
+
+		switch d.State {
+		case StateModified, StateMissing:
+			expected, err := m.Content(m.Entries[d.Path])
+			if err != nil {
+				return events, err
+			}
+			if actual, err := os.ReadFile(d.Path); err == nil {
+				ev.Diff = Diff(string(expected), string(actual))
+			}
+			if err := restore(d.Path, expected); err != nil {
+				return events, err
+			}
+			ev.Action = "restored"
+
+		case StateUnmanaged:
+			actual, err := os.ReadFile(d.Path)
+			if err != nil {
+				continue
+			}
+			ev.Diff = Diff("", string(actual))
+			if err := os.MkdirAll(e.QuarantineDir, 0700); err != nil {
+				return events, fmt.Errorf("create quarantine directory: %w", err)
+			}
+			dest := filepath.Join(e.QuarantineDir, fmt.Sprintf("%s.%d", filepath.Base(d.Path), ev.Time.Unix()))
+			if err := os.Rename(d.Path, dest); err != nil {
+				return events, fmt.Errorf("quarantine %s: %w", d.Path, err)
+			}
+			ev.Action = "quarantined"
+		}
+
+		if err := e.audit(ev); err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// restore rewrites a file in place, keeping its mode and owner if it exists
+func restore(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return fmt.Errorf("restore %s: %w", path, err)
+	}
+	return nil
+}
+
+func (e *Enforcer) audit(ev AuditEvent) error {
+	if err := os.MkdirAll(filepath.Dir(e.AuditLog), 0755); err != nil {
+		return fmt.Errorf("create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(e.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal audit event: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Enforce reverts existing drift, then watches generated files with inotify
+// and reverts every change until stop is closed.
+func (e *Enforcer) Enforce(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	watched := map[string]bool{}
+	reconcile := func() {
+		m, err := LoadManifest(e.ManifestPath)
+		if err != nil {
+			e.Logf("load manifest: %v", err)
+			return
+		}
+
+		// Watch the manifest too, so newly generated files are picked up
+		dirs := []string{filepath.Dir(e.ManifestPath)}
+		for _, entry := range m.Entries {
+			dirs = append(dirs, filepath.Dir(entry.Path))
+		}
+		for _, dir := range dirs {
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				e.Logf("watch %s: %v", dir, err)
+				continue
+			}
+			watched[dir] = true
+		}
+
+		drifts, err := Check(m)
+		if err != nil {
+			e.Logf("check drift: %v", err)
+			return
+		}
+		events, err := e.Revert(m, drifts)
+		for _, ev := range events {
+			e.Logf("%s %s (%s)", ev.Action, ev.Path, ev.State)
+		}
+		if err != nil {
+			e.Logf("revert drift: %v", err)
+		}
+	}
+
+	reconcile()
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Base(ev.Name)[0] == '.' || filepath.Ext(ev.Name) == ".tmp" {
+				continue
+			}
+			settle = time.After(settleDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			e.Logf("watch error: %v", err)
+		case <-settle:
+			settle = nil
+			reconcile()
+		}
+	}
+}
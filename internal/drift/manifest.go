@@ -0,0 +1,174 @@
+// Package drift detects manual edits to files generated by AAMI.
+//
+// Every generated file is recorded in a manifest with its SHA-256 hash and a
+// copy of its content, so on-disk changes can be reported and reverted.
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultManifestPath is the manifest used by the generators
+const DefaultManifestPath = "/var/lib/aami/manifest.json"
+
+// Kinds of generated files
+const (
+	KindRules        = "rules"
+	KindTargets      = "targets"
+	KindPrometheus   = "prometheus"
+	KindAlertmanager = "alertmanager"
+)
+
+// Entry is a generated file as AAMI last wrote it.
+type Entry struct {
+	Path        string    `json:"path"`
+	Kind        string    `json:"kind"`
+	SHA256      string    `json:"sha256"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// Manifest tracks generated files.
+type Manifest struct {
+	path    string
+	Entries map[string]Entry `json:"entries"`
+}
+
+// mu serializes manifest updates within a process
+var mu sync.Mutex
+
+// LoadManifest reads a manifest; a missing file is an empty manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{path: path, Entries: map[string]Entry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]Entry{}
+	}
+	return m, nil
+}
+
+// Save writes the manifest atomically.
+func (m *Manifest) Save() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("create manifest directory: %w", err)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Sorted returns the entries sorted by path.
+func (m *Manifest) Sorted() []Entry {
+	entries := make([]Entry, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+// Content returns the content AAMI generated for an entry.
+func (m *Manifest) Content(e Entry) ([]byte, error) {
+	data, err := os.ReadFile(m.objectPath(e.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("read generated content for %s: %w", e.Path, err)
+	}
+	return data, nil
+}
+
+func (m *Manifest) objectsDir() string {
+	return filepath.Join(filepath.Dir(m.path), "manifest-objects")
+}
+
+func (m *Manifest) objectPath(sum string) string {
+	return filepath.Join(m.objectsDir(), sum)
+}
+
+// Record adds a generated file to the default manifest.
+func Record(kind, path string, content []byte) error {
+	return RecordIn(DefaultManifestPath, kind, path, content)
+}
+
+// RecordIn adds a generated file to the manifest at manifestPath and keeps a
+// copy of its content.
+func RecordIn(manifestPath, kind, path string, content []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolve path: %w", err)
+	}
+
+	m, err := LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	sum := Hash(content)
+	object := m.objectPath(sum)
+	if err := os.MkdirAll(m.objectsDir(), 0700); err != nil {
+		return fmt.Errorf("create manifest objects directory: %w", err)
+	}
+	if _, err := os.Stat(object); os.IsNotExist(err) {
+		if err := os.WriteFile(object, content, 0600); err != nil {
+			return fmt.Errorf("write manifest object: %w", err)
+		}
+	}
+
+	m.Entries[abs] = Entry{Path: abs, Kind: kind, SHA256: sum, GeneratedAt: time.Now().UTC()}
+	if err := m.Save(); err != nil {
+		return err
+	}
+	m.pruneObjects()
+	return nil
+}
+
+// pruneObjects removes stored content no entry refers to anymore
+func (m *Manifest) pruneObjects() {
+	used := make(map[string]bool, len(m.Entries))
+	for _, e := range m.Entries {
+		used[e.SHA256] = true
+	}
+	dir := m.objectsDir()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !used[f.Name()] {
+			os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+}
+
+// Hash returns the hex SHA-256 of content.
+func Hash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
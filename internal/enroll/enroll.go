@@ -0,0 +1,238 @@
+// Package enroll sets up the node agent on a node: its config file, its
+// credential and its systemd units, then waits for its first heartbeat.
+package enroll
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/configserver"
+)
+
+// Files written on the node. They are the ones the aami-agent package and
+// bootstrap.sh use, so an enrolled node looks like any other.
+const (
+	AgentConfigPath = "/etc/aami/agent.yaml"
+	CredentialPath  = "/etc/aami/agent-credential"
+	CertPath        = "/etc/aami/agent.crt"
+	KeyPath         = "/etc/aami/agent.key"
+	CAPath          = "/etc/aami/ca.crt"
+	AgentBinary     = "/usr/bin/aami-agent"
+	UnitDir         = "/etc/systemd/system"
+)
+
+// agentConfigVersion is the schema version of agent.yaml
+const agentConfigVersion = 1
+
+// AgentConfig is the agent.yaml of a node.
+type AgentConfig struct {
+	Version         int    `yaml:"version"`
+	ConfigServerURL string `yaml:"config_server_url"`
+	Hostname        string `yaml:"hostname"`
+	CredentialFile  string `yaml:"credential_file"`
+}
+
+// NewAgentConfig returns the config of an agent reporting to serverURL as
+// hostname
+func NewAgentConfig(serverURL, hostname string) AgentConfig {
+	return AgentConfig{
+		Version:         agentConfigVersion,
+		ConfigServerURL: serverURL,
+		Hostname:        hostname,
+		CredentialFile:  CredentialPath,
+	}
+}
+
+// Render returns the file
+func (c AgentConfig) Render() ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte("# Generated by aami node enroll\n"), data...), nil
+}
+
+// Unit is a systemd unit file.
+type Unit struct {
+	Name    string
+	Content string
+}
+
+// Units returns the units running the agent: a timer running it every
+// minute, or with watch a long-running service
+func Units(watch bool) []Unit {
+	if watch {
+		return []Unit{{Name: "aami-agent-watch.service", Content: agentWatchService}}
+	}
+	return []Unit{
+		{Name: "aami-agent.service", Content: agentService},
+		{Name: "aami-agent.timer", Content: agentTimer},
+	}
+}
+
+// EnabledUnit returns the unit to enable
+func EnabledUnit(watch bool) string {
+	if watch {
+		return "aami-agent-watch.service"
+	}
+	return "aami-agent.timer"
+}
+
+// unitDirs are where systemd finds units, packaged ones last
+var unitDirs = []string{UnitDir, "/usr/lib/systemd/system", "/lib/systemd/system"}
+
+// InstallUnits writes the units not installed yet, by the aami-agent
+// package or before, and enables and starts the agent. It returns the
+// paths written.
+func InstallUnits(ctx context.Context, watch bool) ([]string, error) {
+	var written []string
+	for _, u := range Units(watch) {
+		if installed(u.Name) {
+			continue
+		}
+		path := filepath.Join(UnitDir, u.Name)
+		if err := WriteFile(path, []byte(u.Content), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	if err := systemctl(ctx, "daemon-reload"); err != nil {
+		return written, err
+	}
+	if err := systemctl(ctx, "enable", "--now", EnabledUnit(watch)); err != nil {
+		return written, err
+	}
+	if !watch {
+		// Run the agent now rather than on the timer's next tick
+		if err := systemctl(ctx, "start", "--no-block", "aami-agent.service"); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// installed reports whether a unit file is present
+func installed(name string) bool {
+	for _, dir := range unitDirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func systemctl(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// WriteCertificate installs the agent's client certificate, its key and
+// the agent CA, where the agent looks for them
+func WriteCertificate(cert *configserver.AgentCertificate) error {
+	if err := WriteFile(KeyPath, []byte(cert.PrivateKey), 0600); err != nil {
+		return err
+	}
+	if err := WriteFile(CertPath, []byte(cert.Certificate), 0644); err != nil {
+		return err
+	}
+	if cert.CACertificate == "" {
+		return nil
+	}
+	return WriteFile(CAPath, []byte(cert.CACertificate), 0644)
+}
+
+// WriteFile writes a file atomically, creating its directory
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// WaitForHeartbeat polls the config server until the target with hostname
+// has sent a heartbeat after since, or ctx is done
+func WaitForHeartbeat(ctx context.Context, client *configserver.Client, hostname string, since time.Time, interval time.Duration) (*configserver.Target, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		target, err := client.FindTarget(ctx, hostname)
+		if err == nil && target.LastSeen != nil && !target.LastSeen.Before(since) {
+			return target, nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no heartbeat from %s yet", hostname)
+		case <-ticker.C:
+		}
+	}
+}
+
+// The units of deploy/packages/systemd, for nodes without the package
+const (
+	agentService = `[Unit]
+Description=AAMI node agent (dynamic checks)
+Documentation=https://github.com/fregataa/aami
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=` + AgentBinary + `
+TimeoutStartSec=5min
+
+# Checks run at low priority; see AAMI_NICE and AAMI_IONICE_CLASS
+Nice=10
+IOSchedulingClass=idle
+`
+
+	agentTimer = `[Unit]
+Description=Run the AAMI node agent every minute
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=1min
+AccuracySec=5s
+
+[Install]
+WantedBy=timers.target
+`
+
+	agentWatchService = `[Unit]
+Description=AAMI node agent (dynamic checks, long-running)
+Documentation=https://github.com/fregataa/aami
+Wants=network-online.target
+After=network-online.target
+# Replaces the timer; run one or the other
+Conflicts=aami-agent.timer
+
+[Service]
+Type=simple
+ExecStart=` + AgentBinary + ` --watch
+Restart=always
+RestartSec=10
+
+# Checks run at low priority; see AAMI_NICE and AAMI_IONICE_CLASS
+Nice=10
+IOSchedulingClass=idle
+
+[Install]
+WantedBy=multi-user.target
+`
+)
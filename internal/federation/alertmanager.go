@@ -0,0 +1,161 @@
+package federation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/notify"
+)
+
+// AlertmanagerConfig configures a clustered Alertmanager for the
+// federation: replicas gossip alerts, silences and notification state over
+// their mesh ports, and the shards and the central Prometheus send every
+// alert to all replicas, so notifications continue when one is down.
+type AlertmanagerConfig struct {
+	Replicas   int    `yaml:"replicas"`
+	BasePort   int    `yaml:"base_port"` // replica i uses base+2i (web) and base+2i+1 (mesh)
+	ConfigFile string `yaml:"config_file"`
+	DataDir    string `yaml:"data_dir"` // replica data is kept in DataDir/alertmanager-<n>
+}
+
+// DefaultAlertmanagerConfig returns the clustered Alertmanager settings for
+// a number of shards: one replica per shard, at least two for the cluster
+// to survive one replica and at most three, beyond which gossip grows
+// without adding availability.
+func DefaultAlertmanagerConfig(shards int) AlertmanagerConfig {
+	replicas := shards
+	if replicas < 2 {
+		replicas = 2
+	}
+	if replicas > 3 {
+		replicas = 3
+	}
+	return AlertmanagerConfig{
+		Replicas: replicas,
+		// Clear of the shard ports and of a standalone Alertmanager on 9093
+		BasePort:   9193,
+		ConfigFile: filepath.Join(notify.AlertmanagerDir, "alertmanager.yml"),
+		DataDir:    "/var/lib/aami",
+	}
+}
+
+// Ports returns the web and mesh ports of the i-th replica.
+func (a AlertmanagerConfig) Ports(i int) (web, mesh int) {
+	return a.BasePort + 2*i, a.BasePort + 2*i + 1
+}
+
+// AlertmanagerReplica is a replica of the clustered Alertmanager.
+type AlertmanagerReplica struct {
+	Name    string
+	WebPort int
+	Mesh    string // gossip address for --cluster.peer
+	Storage string
+}
+
+// AlertmanagerReplicas returns the replicas of a federation, none when it
+// uses a single Alertmanager.
+func AlertmanagerReplicas(fed FederationConfig) []AlertmanagerReplica {
+	a := fed.Alertmanager
+	var replicas []AlertmanagerReplica
+	for i := 0; i < a.Replicas; i++ {
+		web, mesh := a.Ports(i)
+		name := fmt.Sprintf("alertmanager-%d", i+1)
+		replicas = append(replicas, AlertmanagerReplica{
+			Name:    name,
+			WebPort: web,
+			Mesh:    fmt.Sprintf("127.0.0.1:%d", mesh),
+			Storage: filepath.Join(a.DataDir, name),
+		})
+	}
+	return replicas
+}
+
+// AlertmanagerServices returns the systemd services of the replicas.
+func AlertmanagerServices(fed FederationConfig) []string {
+	var services []string
+	for _, r := range AlertmanagerReplicas(fed) {
+		services = append(services, "aami-"+r.Name)
+	}
+	return services
+}
+
+// alertmanagerTargets returns the Alertmanagers the shards and the central
+// Prometheus send alerts to
+func (m *Manager) alertmanagerTargets() []string {
+	replicas := AlertmanagerReplicas(m.federation)
+	if len(replicas) == 0 {
+		return []string{"localhost:9093"}
+	}
+	var targets []string
+	for _, r := range replicas {
+		targets = append(targets, fmt.Sprintf("localhost:%d", r.WebPort))
+	}
+	return targets
+}
+
+// deployAlertmanager writes the units of the Alertmanager replicas, each
+// listing the others as cluster peers. The Alertmanager config is generated
+// from the notification settings unless it exists.
+func (m *Manager) deployAlertmanager() error {
+	a := m.federation.Alertmanager
+	if a.Replicas < 2 {
+		return fmt.Errorf("a clustered Alertmanager needs at least 2 replicas, got %d", a.Replicas)
+	}
+	if _, err := os.Stat(a.ConfigFile); os.IsNotExist(err) {
+		if err := notify.GenerateAlertmanagerConfig(m.config, filepath.Dir(a.ConfigFile)); err != nil {
+			return fmt.Errorf("generate alertmanager config: %w", err)
+		}
+	}
+
+	replicas := AlertmanagerReplicas(m.federation)
+	for _, r := range replicas {
+		if err := os.MkdirAll(r.Storage, 0755); err != nil {
+			return err
+		}
+		unit := componentUnit{
+			Description:   fmt.Sprintf("AAMI Alertmanager - %s", r.Name),
+			Documentation: "https://prometheus.io/docs/alerting/latest/alertmanager/",
+			Identifier:    r.Name,
+			Binary:        "/usr/bin/alertmanager",
+			User:          "alertmanager",
+			Args: []string{
+				"--config.file=" + a.ConfigFile,
+				"--storage.path=" + r.Storage,
+				fmt.Sprintf("--web.listen-address=:%d", r.WebPort),
+				"--cluster.listen-address=" + r.Mesh,
+			},
+		}
+		for _, peer := range replicas {
+			if peer.Name != r.Name {
+				unit.Args = append(unit.Args, "--cluster.peer="+peer.Mesh)
+			}
+		}
+		if err := unit.write(fmt.Sprintf("/etc/systemd/system/aami-%s.service", r.Name)); err != nil {
+			return fmt.Errorf("create service for %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkAlertmanagerStatus checks every replica and the cluster it sees
+func (m *Manager) checkAlertmanagerStatus() []AlertmanagerStatus {
+	var statuses []AlertmanagerStatus
+	for _, r := range AlertmanagerReplicas(m.federation) {
+		endpoint := fmt.Sprintf("localhost:%d", r.WebPort)
+		status := AlertmanagerStatus{Name: r.Name, Endpoint: endpoint}
+
+		s, err := alertmanager.NewClient("http://" + endpoint).Status()
+		if err != nil {
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+		status.Healthy = true
+		status.ClusterStatus = s.Cluster.Status
+		status.Peers = len(s.Cluster.Peers)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
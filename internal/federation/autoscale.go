@@ -0,0 +1,237 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AutoscalePolicy holds the per-shard limits beyond which a shard is split.
+type AutoscalePolicy struct {
+	MaxSeries         int64         // head series of a shard
+	MaxScrapeDuration time.Duration // slowest scrape of a shard
+}
+
+// DefaultAutoscalePolicy returns the default limits. A shard of ~200 GPU
+// nodes holds about 1M series; scrapes must finish well within the 15s
+// scrape interval.
+func DefaultAutoscalePolicy() AutoscalePolicy {
+	return AutoscalePolicy{
+		MaxSeries:         2000000,
+		MaxScrapeDuration: 10 * time.Second,
+	}
+}
+
+// ShardLoad is the measured load of a shard.
+type ShardLoad struct {
+	Shard          string        `json:"shard"`
+	Nodes          int           `json:"nodes"`
+	Series         int64         `json:"series"`
+	ScrapeDuration time.Duration `json:"scrape_duration"` // slowest target
+	Error          string        `json:"error,omitempty"`
+}
+
+// ScaleRecommendation is a shard to split into Parts shards.
+type ScaleRecommendation struct {
+	Shard  string `json:"shard"`
+	Parts  int    `json:"parts"`
+	Reason string `json:"reason"`
+}
+
+// CollectLoad reads the head series and scrape durations of every shard.
+func (m *Manager) CollectLoad(ctx context.Context) []ShardLoad {
+	var loads []ShardLoad
+	for _, shard := range m.federation.Shards {
+		load := ShardLoad{Shard: shard.Name, Nodes: len(shard.Nodes)}
+		ops := NewShardOperations(shard)
+
+		stats, err := ops.GetTSDBStats(ctx)
+		if err != nil {
+			load.Error = err.Error()
+			loads = append(loads, load)
+			continue
+		}
+		load.Series = stats.HeadStats.NumSeries
+
+		targets, err := ops.GetTargets(ctx)
+		if err != nil {
+			load.Error = err.Error()
+		}
+		for _, t := range targets {
+			if t.ScrapeDuration > load.ScrapeDuration {
+				load.ScrapeDuration = t.ScrapeDuration
+			}
+		}
+		loads = append(loads, load)
+	}
+	return loads
+}
+
+// Recommend returns the shards over the policy limits, with the number of
+// shards each should be split into.
+func Recommend(loads []ShardLoad, policy AutoscalePolicy) []ScaleRecommendation {
+	var recs []ScaleRecommendation
+	for _, load := range loads {
+		if load.Error != "" {
+			continue
+		}
+
+		parts := 1
+		var reason string
+		if policy.MaxSeries > 0 && load.Series > policy.MaxSeries {
+			parts = int((load.Series + policy.MaxSeries - 1) / policy.MaxSeries)
+			reason = fmt.Sprintf("%d series (max %d)", load.Series, policy.MaxSeries)
+		}
+		if policy.MaxScrapeDuration > 0 && load.ScrapeDuration > policy.MaxScrapeDuration {
+			if p := int((load.ScrapeDuration + policy.MaxScrapeDuration - 1) / policy.MaxScrapeDuration); p > parts {
+				parts = p
+			}
+			if reason != "" {
+				reason += ", "
+			}
+			reason += fmt.Sprintf("scrape takes %s (max %s)", load.ScrapeDuration.Round(time.Millisecond), policy.MaxScrapeDuration)
+		}
+		// A shard cannot be split finer than one node per shard
+		if parts > load.Nodes {
+			parts = load.Nodes
+		}
+		if parts < 2 {
+			continue
+		}
+		recs = append(recs, ScaleRecommendation{Shard: load.Shard, Parts: parts, Reason: reason})
+	}
+	return recs
+}
+
+// SplitShard splits a shard into parts shards: it deploys parts-1 new
+// shards and starts them, adds them to the global view, then moves an even
+// share of the nodes to each with ApplyRebalance, which records the moves.
+//
+// Starting the new shards and reloading the global view are not fatal: the
+// failures are returned in the record, to be fixed by hand.
+func (m *Manager) SplitShard(ctx context.Context, name string, parts int, user string) (*RebalanceRecord, error) {
+	var source ShardConfig
+	found := false
+	for _, shard := range m.federation.Shards {
+		if shard.Name == name {
+			source, found = shard, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown shard: %s", name)
+	}
+	if parts < 2 || parts > len(source.Nodes) {
+		return nil, fmt.Errorf("cannot split shard %s with %d nodes into %d shards", name, len(source.Nodes), parts)
+	}
+
+	// Ports and names follow the highest in use
+	names := make(map[string]bool)
+	port := 0
+	for _, shard := range m.federation.Shards {
+		names[shard.Name] = true
+		if shard.Prometheus.Port > port {
+			port = shard.Prometheus.Port
+		}
+	}
+
+	var added []ShardConfig
+	for n := 1; len(added) < parts-1; n++ {
+		shardName := fmt.Sprintf("shard-%d", n)
+		if names[shardName] {
+			continue
+		}
+		port++
+		shard := ShardConfig{Name: shardName, Racks: source.Racks}
+		shard.Prometheus.Port = port
+		shard.Prometheus.StoragePath = filepath.Join(m.dataDir, "prometheus-"+shardName)
+		shard.Prometheus.Retention = source.Prometheus.Retention
+		added = append(added, shard)
+	}
+
+	// Each new shard takes an even share from the end of the node list
+	per := len(source.Nodes) / parts
+	var moves []NodeMove
+	for i, shard := range added {
+		for _, node := range source.Nodes[len(source.Nodes)-(i+1)*per : len(source.Nodes)-i*per] {
+			moves = append(moves, NodeMove{Node: node, FromShard: name, ToShard: shard.Name})
+		}
+	}
+
+	// 1. Deploy and start the new shards, still without nodes
+	var warnings []string
+	for _, shard := range added {
+		m.federation.Shards = append(m.federation.Shards, shard)
+		if err := m.createDirectories(); err != nil {
+			return nil, fmt.Errorf("create directories: %w", err)
+		}
+		if err := m.deployShard(ctx, shard); err != nil {
+			return nil, fmt.Errorf("deploy shard %s: %w", shard.Name, err)
+		}
+		if err := NewShardOperations(shard).Start(ctx); err != nil {
+			warnings = append(warnings, fmt.Sprintf("start aami-prometheus-%s: %v", shard.Name, err))
+		}
+	}
+
+	// 2. Add them to the global view
+	warnings = append(warnings, m.redeployGlobalView(ctx)...)
+
+	// 3. Move the nodes: the new shards first, then the source
+	record, err := m.ApplyRebalance(ctx, moves, user)
+	if record != nil {
+		record.Errors = append(warnings, record.Errors...)
+	}
+	return record, err
+}
+
+// redeployGlobalView regenerates what queries the shards after shards were
+// added, and reloads or restarts it. It returns the steps that failed.
+func (m *Manager) redeployGlobalView(ctx context.Context) []string {
+	switch m.federation.Type {
+	case FederationTypeRemoteWrite:
+		// The shards push; the backend needs no change
+		return nil
+	case FederationTypeThanos:
+		if err := m.deployThanos(); err != nil {
+			return []string{fmt.Sprintf("deploy thanos: %v", err)}
+		}
+		// Start the new sidecars, and restart Thanos Query, which takes
+		// its endpoints as flags
+		commands := [][]string{{"daemon-reload"}}
+		for _, service := range ThanosServices(m.federation) {
+			if service == "aami-thanos-query" {
+				commands = append(commands, []string{"restart", service})
+			} else {
+				commands = append(commands, []string{"start", service})
+			}
+		}
+		var failed []string
+		for _, args := range commands {
+			if err := exec.CommandContext(ctx, "systemctl", args...).Run(); err != nil {
+				failed = append(failed, fmt.Sprintf("systemctl %s: %v", strings.Join(args, " "), err))
+			}
+		}
+		return failed
+	default:
+		if err := m.deployCentral(ctx); err != nil {
+			return []string{fmt.Sprintf("deploy central: %v", err)}
+		}
+		url := fmt.Sprintf("http://localhost:%d/-/reload", m.federation.Central.Port)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+		if err != nil {
+			return []string{fmt.Sprintf("reload central: %v", err)}
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return []string{fmt.Sprintf("reload central: %s", resp.Status)}
+		}
+		return nil
+	}
+}
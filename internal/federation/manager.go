@@ -11,6 +11,8 @@ import (
 	"text/template"
 	"time"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/fregataa/aami/internal/config"
 )
 
@@ -152,14 +154,60 @@ func (m *Manager) Deploy(ctx context.Context) error {
 		}
 	}
 
-	// 3. Deploy central Prometheus
-	if err := m.deployCentral(ctx); err != nil {
-		return fmt.Errorf("deploy central: %w", err)
+	// 3. Deploy the global view: Thanos, or a central Prometheus. With
+	// remote write, the shards push to a backend that is already running.
+	switch m.federation.Type {
+	case FederationTypeThanos:
+		if err := m.deployThanos(); err != nil {
+			return fmt.Errorf("deploy thanos: %w", err)
+		}
+	case FederationTypeRemoteWrite:
+	default:
+		if err := m.deployCentral(ctx); err != nil {
+			return fmt.Errorf("deploy central: %w", err)
+		}
+	}
+
+	// 4. Deploy the clustered Alertmanager
+	if m.federation.Alertmanager.Replicas > 0 {
+		if err := m.deployAlertmanager(); err != nil {
+			return fmt.Errorf("deploy alertmanager: %w", err)
+		}
+	}
+
+	// 5. Record the deployed federation for status, rebalance and disable
+	if err := SaveConfig(m.federation, filepath.Join(m.configDir, "federation", "federation.yaml")); err != nil {
+		return fmt.Errorf("save federation config: %w", err)
 	}
 
 	return nil
 }
 
+// DefaultConfigPath is where the deployed federation config is recorded
+const DefaultConfigPath = "/etc/aami/federation/federation.yaml"
+
+// LoadConfig reads a recorded federation config.
+func LoadConfig(path string) (FederationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FederationConfig{}, err
+	}
+	var fed FederationConfig
+	if err := yaml.Unmarshal(data, &fed); err != nil {
+		return FederationConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return fed, nil
+}
+
+// SaveConfig records a federation config.
+func SaveConfig(fed FederationConfig, path string) error {
+	data, err := yaml.Marshal(fed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 func (m *Manager) createDirectories() error {
 	dirs := []string{
 		filepath.Join(m.configDir, "federation"),
@@ -170,7 +218,7 @@ func (m *Manager) createDirectories() error {
 		dirs = append(dirs, shard.Prometheus.StoragePath)
 	}
 
-	if m.federation.Central.StoragePath != "" {
+	if m.federation.Central.StoragePath != "" && m.federation.Type == FederationTypePrometheus {
 		dirs = append(dirs, m.federation.Central.StoragePath)
 	}
 
@@ -227,7 +275,35 @@ scrape_configs:
       - files:
           - '{{ .TargetsDir }}/{{ .Name }}-dcgm.json'
         refresh_interval: 30s
+{{ with .RemoteWrite }}
+remote_write:
+  - url: '{{ .URL }}'{{ if .TenantID }}
+    headers:
+      X-Scope-OrgID: '{{ .TenantID }}'{{ end }}
+    write_relabel_configs:
+      - source_labels: [__name__]
+        regex: '{{ .KeepMetrics }}'
+        action: keep{{ range .DropLabels }}
+      - regex: '{{ . }}'
+        action: labeldrop{{ end }}
+    queue_config:
+      capacity: {{ .Queue.Capacity }}
+      min_shards: {{ .Queue.MinShards }}
+      max_shards: {{ .Queue.MaxShards }}
+      max_samples_per_send: {{ .Queue.MaxSamplesPerSend }}
+      batch_send_deadline: {{ .Queue.BatchSendDeadline }}
+      min_backoff: {{ .Queue.MinBackoff }}
+      max_backoff: {{ .Queue.MaxBackoff }}
+{{ end }}{{ if .EvaluateRules }}
+# Without a central Prometheus, each shard evaluates the rules for its nodes
+rule_files:
+  - '/etc/aami/rules/*.yaml'
 
+alerting:
+  alertmanagers:
+    - static_configs:
+        - targets: [{{ range $i, $t := .Alertmanagers }}{{ if $i }}, {{ end }}'{{ $t }}'{{ end }}]
+{{ end }}
 storage:
   tsdb:
     path: {{ .StoragePath }}
@@ -235,11 +311,14 @@ storage:
 `
 
 type shardTemplateData struct {
-	Name        string
-	ClusterName string
-	TargetsDir  string
-	StoragePath string
-	Retention   string
+	Name          string
+	ClusterName   string
+	TargetsDir    string
+	StoragePath   string
+	Retention     string
+	EvaluateRules bool
+	RemoteWrite   *RemoteWriteConfig
+	Alertmanagers []string
 }
 
 func (m *Manager) generateShardConfig(shard ShardConfig, outputPath string) error {
@@ -254,6 +333,12 @@ func (m *Manager) generateShardConfig(shard ShardConfig, outputPath string) erro
 		TargetsDir:  filepath.Join(m.dataDir, "targets"),
 		StoragePath: shard.Prometheus.StoragePath,
 		Retention:   shard.Prometheus.Retention,
+		// Thanos Query and the remote write backends do not evaluate rules
+		EvaluateRules: m.federation.Type != FederationTypePrometheus,
+		Alertmanagers: m.alertmanagerTargets(),
+	}
+	if m.federation.Type == FederationTypeRemoteWrite {
+		data.RemoteWrite = &m.federation.RemoteWrite
 	}
 
 	f, err := os.Create(outputPath)
@@ -319,7 +404,9 @@ ExecStart=/usr/bin/prometheus \
     --storage.tsdb.path={{ .StoragePath }} \
     --storage.tsdb.retention.time={{ .Retention }} \
     --web.listen-address=:{{ .Port }} \
-    --web.enable-lifecycle \
+    --web.enable-lifecycle \{{ if .Thanos }}
+    --storage.tsdb.min-block-duration=2h \
+    --storage.tsdb.max-block-duration=2h \{{ end }}
     --web.enable-admin-api
 
 SyslogIdentifier=prometheus-{{ .Name }}
@@ -336,6 +423,7 @@ type serviceTemplateData struct {
 	StoragePath string
 	Retention   string
 	Port        int
+	Thanos      bool // fixed 2h blocks, so the sidecar uploads them uncompacted
 }
 
 func (m *Manager) createShardService(shard ShardConfig, servicePath string) error {
@@ -350,6 +438,7 @@ func (m *Manager) createShardService(shard ShardConfig, servicePath string) erro
 		StoragePath: shard.Prometheus.StoragePath,
 		Retention:   shard.Prometheus.Retention,
 		Port:        shard.Prometheus.Port,
+		Thanos:      m.federation.Type == FederationTypeThanos,
 	}
 
 	f, err := os.Create(servicePath)
@@ -394,7 +483,7 @@ rule_files:
 alerting:
   alertmanagers:
     - static_configs:
-        - targets: ['localhost:9093']
+        - targets: [{{ range $i, $t := .Alertmanagers }}{{ if $i }}, {{ end }}'{{ $t }}'{{ end }}]
 
 storage:
   tsdb:
@@ -403,10 +492,11 @@ storage:
 `
 
 type centralTemplateData struct {
-	ClusterName  string
-	StoragePath  string
-	RetentionRaw string
-	Shards       []struct {
+	ClusterName   string
+	StoragePath   string
+	RetentionRaw  string
+	Alertmanagers []string
+	Shards        []struct {
 		Name string
 		Port int
 	}
@@ -421,9 +511,10 @@ func (m *Manager) deployCentral(ctx context.Context) error {
 	}
 
 	data := centralTemplateData{
-		ClusterName:  m.config.Cluster.Name,
-		StoragePath:  m.federation.Central.StoragePath,
-		RetentionRaw: m.federation.Central.RetentionRaw,
+		ClusterName:   m.config.Cluster.Name,
+		StoragePath:   m.federation.Central.StoragePath,
+		RetentionRaw:  m.federation.Central.RetentionRaw,
+		Alertmanagers: m.alertmanagerTargets(),
 	}
 
 	for _, shard := range m.federation.Shards {
@@ -501,7 +592,12 @@ func (m *Manager) GetStatus(ctx context.Context) (*FederationStatus, error) {
 	}
 
 	// Check central
-	status.Central = m.checkCentralStatus(ctx)
+	if m.federation.Type == FederationTypeRemoteWrite {
+		status.Central = m.checkRemoteWriteStatus(ctx)
+	} else {
+		status.Central = m.checkCentralStatus(ctx)
+	}
+	status.Alertmanagers = m.checkAlertmanagerStatus()
 
 	return status, nil
 }
@@ -602,3 +698,56 @@ func (m *Manager) GetConfig() FederationConfig {
 func (m *Manager) SetShards(shards []ShardConfig) {
 	m.federation.Shards = shards
 }
+
+// SetAlertmanager sets the clustered Alertmanager configuration.
+func (m *Manager) SetAlertmanager(a AlertmanagerConfig) {
+	m.federation.Alertmanager = a
+}
+
+const componentServiceTemplate = `[Unit]
+Description={{ .Description }}
+Documentation={{ .Documentation }}
+After=network-online.target{{ if .After }} {{ .After }}{{ end }}
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{ .User }}
+Group={{ .User }}
+ExecStart={{ .Binary }}{{ range .Args }} \
+    {{ . }}{{ end }}
+
+SyslogIdentifier={{ .Identifier }}
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// componentUnit is a systemd service running a component of the
+// federation next to the shards
+type componentUnit struct {
+	Description   string
+	Documentation string
+	After         string
+	Identifier    string
+	Binary        string
+	User          string
+	Args          []string
+}
+
+func (u componentUnit) write(path string) error {
+	tmpl, err := template.New("component").Parse(componentServiceTemplate)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return tmpl.Execute(f, u)
+}
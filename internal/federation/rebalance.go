@@ -0,0 +1,179 @@
+package federation
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultRebalanceHistory receives a record for every applied rebalance
+const DefaultRebalanceHistory = "/var/log/aami/federation-rebalance.log"
+
+// RebalanceRecord records an applied rebalance.
+type RebalanceRecord struct {
+	Time     time.Time  `json:"time"`
+	User     string     `json:"user"`
+	Moves    []NodeMove `json:"moves"`
+	Reloaded []string   `json:"reloaded,omitempty"` // shards reloaded, in order
+	Errors   []string   `json:"errors,omitempty"`   // reloads that failed
+}
+
+// ApplyRebalance moves nodes between shards. The shards gaining nodes are
+// updated and reloaded first, then the shards losing them, so a moved node
+// is briefly scraped by both shards rather than by neither. The new shards
+// are recorded in federation.yaml and the moves in the rebalance history.
+//
+// A failed reload does not stop the rebalance: the shard picks up its
+// targets file within the file_sd refresh interval. Failed reloads are
+// returned in the record.
+func (m *Manager) ApplyRebalance(ctx context.Context, moves []NodeMove, user string) (*RebalanceRecord, error) {
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("no moves to apply")
+	}
+
+	index := make(map[string]int)
+	final := make([]ShardConfig, len(m.federation.Shards))
+	interim := make([]ShardConfig, len(m.federation.Shards))
+	for i, shard := range m.federation.Shards {
+		index[shard.Name] = i
+		final[i] = shard
+		final[i].Nodes = append([]string(nil), shard.Nodes...)
+		interim[i] = shard
+		interim[i].Nodes = append([]string(nil), shard.Nodes...)
+	}
+
+	gaining := make(map[string]bool)
+	losing := make(map[string]bool)
+	for _, move := range moves {
+		from, ok := index[move.FromShard]
+		if !ok {
+			return nil, fmt.Errorf("unknown shard: %s", move.FromShard)
+		}
+		to, ok := index[move.ToShard]
+		if !ok {
+			return nil, fmt.Errorf("unknown shard: %s", move.ToShard)
+		}
+		if from == to {
+			return nil, fmt.Errorf("node %s is already in shard %s", move.Node, move.ToShard)
+		}
+		pos := -1
+		for k, node := range final[from].Nodes {
+			if node == move.Node {
+				pos = k
+				break
+			}
+		}
+		if pos < 0 {
+			return nil, fmt.Errorf("node %s is not in shard %s", move.Node, move.FromShard)
+		}
+
+		final[from].Nodes = append(final[from].Nodes[:pos], final[from].Nodes[pos+1:]...)
+		final[to].Nodes = append(final[to].Nodes, move.Node)
+		// Until the losing shards are updated, the node is in both
+		interim[to].Nodes = append(interim[to].Nodes, move.Node)
+		gaining[move.ToShard] = true
+		losing[move.FromShard] = true
+	}
+
+	if errs := NewShardValidator().ValidateAll(final); len(errs) > 0 {
+		return nil, fmt.Errorf("rebalance would leave invalid shards: %s", strings.Join(errs, "; "))
+	}
+
+	record := &RebalanceRecord{
+		Time:  time.Now().UTC().Truncate(time.Second),
+		User:  user,
+		Moves: moves,
+	}
+
+	// 1. Shards gaining nodes, 2. shards losing nodes
+	if err := m.updateShards(ctx, interim, gaining, record); err != nil {
+		return nil, err
+	}
+	if err := m.updateShards(ctx, final, losing, record); err != nil {
+		return nil, err
+	}
+
+	// 3. Record the new shards and the moves
+	m.federation.Shards = final
+	if err := SaveConfig(m.federation, filepath.Join(m.configDir, "federation", "federation.yaml")); err != nil {
+		return nil, fmt.Errorf("save federation config: %w", err)
+	}
+	if err := appendRebalanceHistory(DefaultRebalanceHistory, record); err != nil {
+		return record, fmt.Errorf("record rebalance history: %w", err)
+	}
+
+	return record, nil
+}
+
+// updateShards rewrites the targets files of the named shards, then
+// reloads them
+func (m *Manager) updateShards(ctx context.Context, shards []ShardConfig, names map[string]bool, record *RebalanceRecord) error {
+	for _, shard := range shards {
+		if !names[shard.Name] {
+			continue
+		}
+		targetsPath := filepath.Join(m.dataDir, "targets", fmt.Sprintf("%s-nodes.json", shard.Name))
+		if err := m.generateShardTargets(shard, targetsPath); err != nil {
+			return fmt.Errorf("write targets of %s: %w", shard.Name, err)
+		}
+	}
+
+	for _, shard := range shards {
+		if !names[shard.Name] {
+			continue
+		}
+		if err := NewShardOperations(shard).Reload(ctx); err != nil {
+			record.Errors = append(record.Errors, fmt.Sprintf("reload %s: %v", shard.Name, err))
+			continue
+		}
+		record.Reloaded = append(record.Reloaded, shard.Name)
+	}
+	return nil
+}
+
+func appendRebalanceHistory(path string, record *RebalanceRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// LoadRebalanceHistory reads the applied rebalances, oldest first.
+func LoadRebalanceHistory(path string) ([]RebalanceRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []RebalanceRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r RebalanceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,118 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Remote write backends
+const (
+	RemoteWriteVictoriaMetrics = "victoriametrics"
+	RemoteWriteMimir           = "mimir"
+)
+
+// RemoteWriteConfig configures the remote-write deployment mode: each shard
+// pushes its samples to a central VictoriaMetrics or Mimir, which takes the
+// place of the central Prometheus. The shards evaluate the alert rules.
+type RemoteWriteConfig struct {
+	URL         string           `yaml:"url"`                 // write endpoint, e.g. http://vm:8428/api/v1/write
+	Backend     string           `yaml:"backend"`             // victoriametrics or mimir
+	TenantID    string           `yaml:"tenant_id,omitempty"` // Mimir X-Scope-OrgID
+	KeepMetrics string           `yaml:"keep_metrics"`        // only series whose name matches are sent
+	DropLabels  []string         `yaml:"drop_labels,omitempty"`
+	Queue       RemoteWriteQueue `yaml:"queue"`
+}
+
+// RemoteWriteQueue tunes the remote write queue of a shard.
+type RemoteWriteQueue struct {
+	Capacity          int    `yaml:"capacity"`
+	MinShards         int    `yaml:"min_shards"`
+	MaxShards         int    `yaml:"max_shards"`
+	MaxSamplesPerSend int    `yaml:"max_samples_per_send"`
+	BatchSendDeadline string `yaml:"batch_send_deadline"`
+	MinBackoff        string `yaml:"min_backoff"`
+	MaxBackoff        string `yaml:"max_backoff"`
+}
+
+// DefaultRemoteWriteConfig returns the default remote write settings for a
+// backend. The queue is sized for a shard of ~200 GPU nodes.
+func DefaultRemoteWriteConfig(backend, rawURL string) RemoteWriteConfig {
+	return RemoteWriteConfig{
+		URL:     rawURL,
+		Backend: backend,
+		// The series the central Prometheus federates, and recording rules
+		KeepMetrics: "DCGM_.*|node_.*|up|.*:.*",
+		Queue: RemoteWriteQueue{
+			Capacity:          20000,
+			MinShards:         1,
+			MaxShards:         30,
+			MaxSamplesPerSend: 5000,
+			BatchSendDeadline: "5s",
+			MinBackoff:        "30ms",
+			MaxBackoff:        "5s",
+		},
+	}
+}
+
+// Validate checks the backend and the write URL.
+func (r RemoteWriteConfig) Validate() error {
+	switch r.Backend {
+	case RemoteWriteVictoriaMetrics, RemoteWriteMimir:
+	default:
+		return fmt.Errorf("unknown remote write backend: %s (must be %s or %s)",
+			r.Backend, RemoteWriteVictoriaMetrics, RemoteWriteMimir)
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid remote write url: %s", r.URL)
+	}
+	if r.TenantID != "" && r.Backend != RemoteWriteMimir {
+		return fmt.Errorf("a tenant ID is only used by %s", RemoteWriteMimir)
+	}
+	return nil
+}
+
+// healthURL returns the readiness endpoint of the backend
+func (r RemoteWriteConfig) healthURL() (string, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return "", err
+	}
+	path := "/health"
+	if r.Backend == RemoteWriteMimir {
+		path = "/ready"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: path}).String(), nil
+}
+
+// checkRemoteWriteStatus checks the backend the shards push to, which is
+// the global view of a remote-write federation
+func (m *Manager) checkRemoteWriteStatus(ctx context.Context) CentralStatus {
+	rw := m.federation.RemoteWrite
+	status := CentralStatus{Endpoint: rw.URL}
+
+	healthURL, err := rw.healthURL()
+	if err != nil {
+		return status
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return status
+	}
+	if rw.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", rw.TenantID)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return status
+	}
+	defer resp.Body.Close()
+
+	status.Healthy = resp.StatusCode == 200
+	return status
+}
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/fregataa/aami/internal/metrics"
 )
 
 // ShardOperations provides operations on individual shards.
@@ -51,7 +53,9 @@ func (s *ShardOperations) Restart(ctx context.Context) error {
 }
 
 // Reload reloads the shard configuration without restart.
-func (s *ShardOperations) Reload(ctx context.Context) error {
+func (s *ShardOperations) Reload(ctx context.Context) (err error) {
+	defer func() { metrics.Reloads.Inc(metrics.Result(err)) }()
+
 	url := fmt.Sprintf("http://localhost:%d/-/reload", s.shard.Prometheus.Port)
 	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
@@ -91,12 +95,13 @@ func (s *ShardOperations) GetTargets(ctx context.Context) ([]TargetInfo, error)
 		Status string `json:"status"`
 		Data   struct {
 			ActiveTargets []struct {
-				Labels       map[string]string `json:"labels"`
-				ScrapeURL    string            `json:"scrapeUrl"`
-				Health       string            `json:"health"`
-				LastScrape   time.Time         `json:"lastScrape"`
-				LastError    string            `json:"lastError"`
-				ScrapePool   string            `json:"scrapePool"`
+				Labels             map[string]string `json:"labels"`
+				ScrapeURL          string            `json:"scrapeUrl"`
+				Health             string            `json:"health"`
+				LastScrape         time.Time         `json:"lastScrape"`
+				LastError          string            `json:"lastError"`
+				ScrapePool         string            `json:"scrapePool"`
+				LastScrapeDuration float64           `json:"lastScrapeDuration"`
 			} `json:"activeTargets"`
 		} `json:"data"`
 	}
@@ -108,12 +113,13 @@ func (s *ShardOperations) GetTargets(ctx context.Context) ([]TargetInfo, error)
 	var targets []TargetInfo
 	for _, t := range result.Data.ActiveTargets {
 		targets = append(targets, TargetInfo{
-			Labels:     t.Labels,
-			ScrapeURL:  t.ScrapeURL,
-			Health:     t.Health,
-			LastScrape: t.LastScrape,
-			LastError:  t.LastError,
-			ScrapePool: t.ScrapePool,
+			Labels:         t.Labels,
+			ScrapeURL:      t.ScrapeURL,
+			Health:         t.Health,
+			LastScrape:     t.LastScrape,
+			LastError:      t.LastError,
+			ScrapePool:     t.ScrapePool,
+			ScrapeDuration: time.Duration(t.LastScrapeDuration * float64(time.Second)),
 		})
 	}
 
@@ -122,12 +128,13 @@ func (s *ShardOperations) GetTargets(ctx context.Context) ([]TargetInfo, error)
 
 // TargetInfo represents information about a scrape target.
 type TargetInfo struct {
-	Labels     map[string]string `json:"labels"`
-	ScrapeURL  string            `json:"scrape_url"`
-	Health     string            `json:"health"`
-	LastScrape time.Time         `json:"last_scrape"`
-	LastError  string            `json:"last_error,omitempty"`
-	ScrapePool string            `json:"scrape_pool"`
+	Labels         map[string]string `json:"labels"`
+	ScrapeURL      string            `json:"scrape_url"`
+	Health         string            `json:"health"`
+	LastScrape     time.Time         `json:"last_scrape"`
+	LastError      string            `json:"last_error,omitempty"`
+	ScrapePool     string            `json:"scrape_pool"`
+	ScrapeDuration time.Duration     `json:"scrape_duration"`
 }
 
 // GetRuntimeInfo returns runtime information about the shard.
@@ -386,24 +393,30 @@ func (r *ShardRebalancer) SuggestRebalance() []NodeMove {
 
 	target := total / len(r.shards)
 
+	// Nodes each shard gains from the moves so far
+	gained := make([]int, len(r.shards))
+
 	// Find shards with excess and deficit
 	for i := range r.shards {
 		excess := len(r.shards[i].Nodes) - target
+		next := 0 // the first node of shard i not moved yet
 		if excess > 0 {
 			// Find shards that need nodes
 			for j := range r.shards {
 				if i == j {
 					continue
 				}
-				deficit := target - len(r.shards[j].Nodes)
+				deficit := target - len(r.shards[j].Nodes) - gained[j]
 				if deficit > 0 {
 					moveCount := min(excess, deficit)
-					for k := 0; k < moveCount && k < len(r.shards[i].Nodes); k++ {
+					for k := 0; k < moveCount && next < len(r.shards[i].Nodes); k++ {
 						moves = append(moves, NodeMove{
-							Node:       r.shards[i].Nodes[k],
+							Node:       r.shards[i].Nodes[next],
 							FromShard:  r.shards[i].Name,
 							ToShard:    r.shards[j].Name,
 						})
+						next++
+						gained[j]++
 					}
 					excess -= moveCount
 				}
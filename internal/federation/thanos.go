@@ -0,0 +1,193 @@
+package federation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ThanosConfig configures the Thanos deployment mode: a sidecar next to
+// each shard uploads its blocks to object storage, a store gateway serves
+// the uploaded blocks, and Thanos Query takes the place of the central
+// Prometheus as the global view, on the central port.
+type ThanosConfig struct {
+	ObjectStorageConfig string `yaml:"objstore_config"`   // Thanos objstore.yml, copied into the federation directory
+	SidecarBasePort     int    `yaml:"sidecar_base_port"` // shard i uses base+2i (gRPC) and base+2i+1 (HTTP)
+	StoreGRPCPort       int    `yaml:"store_grpc_port"`
+	StoreHTTPPort       int    `yaml:"store_http_port"`
+	QueryGRPCPort       int    `yaml:"query_grpc_port"`
+	StoreDataDir        string `yaml:"store_data_dir"`
+}
+
+// DefaultThanosConfig returns the default Thanos ports and paths.
+func DefaultThanosConfig() ThanosConfig {
+	return ThanosConfig{
+		SidecarBasePort: 10911,
+		StoreGRPCPort:   10905,
+		StoreHTTPPort:   10906,
+		QueryGRPCPort:   10903,
+		StoreDataDir:    "/var/lib/aami/thanos-store",
+	}
+}
+
+// SidecarPorts returns the gRPC and HTTP ports of the sidecar of the i-th
+// shard.
+func (t ThanosConfig) SidecarPorts(i int) (grpc, http int) {
+	return t.SidecarBasePort + 2*i, t.SidecarBasePort + 2*i + 1
+}
+
+// ObjectStorage is a Thanos object storage configuration (objstore.yml).
+type ObjectStorage struct {
+	Type   string                 `yaml:"type"`
+	Config map[string]interface{} `yaml:"config"`
+	Prefix string                 `yaml:"prefix,omitempty"`
+}
+
+// objectStorageKeys lists the config keys each supported provider needs
+var objectStorageKeys = map[string][]string{
+	"S3":         {"bucket", "endpoint"},
+	"GCS":        {"bucket"},
+	"AZURE":      {"storage_account", "container"},
+	"SWIFT":      {"auth_url", "container_name"},
+	"COS":        {"bucket", "region"},
+	"ALIYUNOSS":  {"endpoint", "bucket"},
+	"BOS":        {"bucket", "endpoint"},
+	"OCI":        {"bucket"},
+	"FILESYSTEM": {"directory"},
+}
+
+// LoadObjectStorage reads and validates an objstore.yml.
+func LoadObjectStorage(path string) (*ObjectStorage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read object storage config: %w", err)
+	}
+	var o ObjectStorage
+	if err := yaml.Unmarshal(data, &o); err != nil {
+		return nil, fmt.Errorf("parse object storage config: %w", err)
+	}
+	if errs := o.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid object storage config %s: %s", path, strings.Join(errs, "; "))
+	}
+	return &o, nil
+}
+
+// Validate checks the provider and the keys it needs.
+func (o ObjectStorage) Validate() []string {
+	required, ok := objectStorageKeys[strings.ToUpper(o.Type)]
+	if !ok {
+		var types []string
+		for t := range objectStorageKeys {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return []string{fmt.Sprintf("unsupported type %q: must be one of %s", o.Type, strings.Join(types, ", "))}
+	}
+
+	var errs []string
+	for _, key := range required {
+		if v, ok := o.Config[key]; !ok || v == nil || v == "" {
+			errs = append(errs, fmt.Sprintf("%s requires config.%s", strings.ToUpper(o.Type), key))
+		}
+	}
+	return errs
+}
+
+// objstorePath is where the object storage config of the Thanos
+// components is kept
+func (m *Manager) objstorePath() string {
+	return filepath.Join(m.configDir, "federation", "objstore.yaml")
+}
+
+// deployThanos writes the object storage config and the units of the
+// sidecars, the store gateway and Thanos Query.
+func (m *Manager) deployThanos() error {
+	t := m.federation.Thanos
+	if t.ObjectStorageConfig == "" {
+		return fmt.Errorf("thanos requires an object storage config")
+	}
+	if _, err := LoadObjectStorage(t.ObjectStorageConfig); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(t.ObjectStorageConfig)
+	if err != nil {
+		return err
+	}
+	// Holds the storage credentials
+	if err := os.WriteFile(m.objstorePath(), data, 0600); err != nil {
+		return fmt.Errorf("write object storage config: %w", err)
+	}
+	if err := os.MkdirAll(t.StoreDataDir, 0755); err != nil {
+		return err
+	}
+
+	var endpoints []string
+	for i, shard := range m.federation.Shards {
+		grpc, http := t.SidecarPorts(i)
+		endpoints = append(endpoints, fmt.Sprintf("localhost:%d", grpc))
+		unit := thanosUnit(fmt.Sprintf("AAMI Thanos Sidecar - %s", shard.Name), fmt.Sprintf("thanos-sidecar-%s", shard.Name),
+			"sidecar",
+			"--tsdb.path="+shard.Prometheus.StoragePath,
+			fmt.Sprintf("--prometheus.url=http://localhost:%d", shard.Prometheus.Port),
+			"--objstore.config-file="+m.objstorePath(),
+			fmt.Sprintf("--grpc-address=0.0.0.0:%d", grpc),
+			fmt.Sprintf("--http-address=0.0.0.0:%d", http),
+		)
+		unit.After = fmt.Sprintf("aami-prometheus-%s.service", shard.Name)
+		if err := unit.write(fmt.Sprintf("/etc/systemd/system/aami-thanos-sidecar-%s.service", shard.Name)); err != nil {
+			return fmt.Errorf("create sidecar service for %s: %w", shard.Name, err)
+		}
+	}
+
+	store := thanosUnit("AAMI Thanos Store Gateway", "thanos-store",
+		"store",
+		"--data-dir="+t.StoreDataDir,
+		"--objstore.config-file="+m.objstorePath(),
+		fmt.Sprintf("--grpc-address=0.0.0.0:%d", t.StoreGRPCPort),
+		fmt.Sprintf("--http-address=0.0.0.0:%d", t.StoreHTTPPort),
+	)
+	if err := store.write("/etc/systemd/system/aami-thanos-store.service"); err != nil {
+		return fmt.Errorf("create store gateway service: %w", err)
+	}
+	endpoints = append(endpoints, fmt.Sprintf("localhost:%d", t.StoreGRPCPort))
+
+	query := thanosUnit("AAMI Thanos Query - Global View", "thanos-query",
+		"query",
+		fmt.Sprintf("--http-address=0.0.0.0:%d", m.federation.Central.Port),
+		fmt.Sprintf("--grpc-address=0.0.0.0:%d", t.QueryGRPCPort),
+		"--query.replica-label=replica",
+	)
+	for _, e := range endpoints {
+		query.Args = append(query.Args, "--endpoint="+e)
+	}
+	if err := query.write("/etc/systemd/system/aami-thanos-query.service"); err != nil {
+		return fmt.Errorf("create query service: %w", err)
+	}
+	return nil
+}
+
+// ThanosServices returns the systemd services of a Thanos deployment, in
+// start order.
+func ThanosServices(fed FederationConfig) []string {
+	var services []string
+	for _, shard := range fed.Shards {
+		services = append(services, "aami-thanos-sidecar-"+shard.Name)
+	}
+	return append(services, "aami-thanos-store", "aami-thanos-query")
+}
+
+// thanosUnit returns the unit of a Thanos component
+func thanosUnit(description, identifier string, args ...string) componentUnit {
+	return componentUnit{
+		Description:   description,
+		Documentation: "https://thanos.io/tip/thanos/getting-started.md/",
+		Identifier:    identifier,
+		Binary:        "/usr/bin/thanos",
+		User:          "prometheus",
+		Args:          args,
+	}
+}
@@ -10,15 +10,20 @@ const (
 	FederationTypePrometheus FederationType = "prometheus"
 	// FederationTypeThanos uses Thanos for long-term storage and global view.
 	FederationTypeThanos FederationType = "thanos"
+	// FederationTypeRemoteWrite pushes shard samples to VictoriaMetrics or Mimir.
+	FederationTypeRemoteWrite FederationType = "remote-write"
 )
 
 // FederationConfig holds the federation configuration.
 type FederationConfig struct {
-	Enabled     bool           `yaml:"enabled"`
-	Type        FederationType `yaml:"type"`
-	Shards      []ShardConfig  `yaml:"shards"`
-	CentralNode string         `yaml:"central_node"`
-	Central     CentralConfig  `yaml:"central"`
+	Enabled      bool               `yaml:"enabled"`
+	Type         FederationType     `yaml:"type"`
+	Shards       []ShardConfig      `yaml:"shards"`
+	CentralNode  string             `yaml:"central_node"`
+	Central      CentralConfig      `yaml:"central"`
+	Thanos       ThanosConfig       `yaml:"thanos,omitempty"`
+	RemoteWrite  RemoteWriteConfig  `yaml:"remote_write,omitempty"`
+	Alertmanager AlertmanagerConfig `yaml:"alertmanager,omitempty"` // clustered; none keeps the single Alertmanager
 }
 
 // ShardConfig defines a single Prometheus shard configuration.
@@ -63,6 +68,8 @@ type FederationStatus struct {
 	HealthyCount int           `json:"healthy_count"`
 	Shards       []ShardStatus `json:"shards"`
 	Central      CentralStatus `json:"central"`
+
+	Alertmanagers []AlertmanagerStatus `json:"alertmanagers,omitempty"`
 }
 
 // CentralStatus represents the central Prometheus status.
@@ -73,6 +80,16 @@ type CentralStatus struct {
 	MetricCount int64     `json:"metric_count"`
 }
 
+// AlertmanagerStatus represents the status of an Alertmanager replica.
+type AlertmanagerStatus struct {
+	Name          string `json:"name"`
+	Endpoint      string `json:"endpoint"`
+	Healthy       bool   `json:"healthy"`
+	ClusterStatus string `json:"cluster_status"` // ready, settling, disabled
+	Peers         int    `json:"peers"`          // cluster members the replica sees, itself included
+	Error         string `json:"error,omitempty"`
+}
+
 // ShardingStrategy defines how nodes are distributed across shards.
 type ShardingStrategy string
 
@@ -0,0 +1,141 @@
+package feed
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/health"
+)
+
+// cacheTTL bounds how often feed requests reach Prometheus and Alertmanager
+const cacheTTL = 30 * time.Second
+
+// maxCriticals caps the alerts listed in a feed
+const maxCriticals = 20
+
+// Status is the compact status document served by a feed.
+type Status struct {
+	Cluster      string     `json:"cluster"`
+	Status       string     `json:"status"`       // healthy, warning, critical, unknown
+	HealthScore  *float64   `json:"health_score"` // null when Prometheus is unreachable
+	GPUs         int        `json:"gpus"`
+	CriticalGPUs int        `json:"critical_gpus"`
+	Criticals    []Critical `json:"criticals"`
+	GeneratedAt  time.Time  `json:"generated_at"`
+}
+
+// Critical is an active critical alert.
+type Critical struct {
+	Alert string    `json:"alert"`
+	Node  string    `json:"node"`
+	Since time.Time `json:"since"`
+}
+
+// Handler serves signed status feeds.
+type Handler struct {
+	store   *Store
+	cluster string
+	prom    *health.PrometheusClient
+	am      *alertmanager.Client
+
+	mu        sync.Mutex
+	cached    *Status
+	expiresAt time.Time
+}
+
+// NewHandler creates a feed handler.
+func NewHandler(store *Store, cluster string, prom *health.PrometheusClient, am *alertmanager.Client) *Handler {
+	return &Handler{store: store, cluster: cluster, prom: prom, am: am}
+}
+
+// ServeHTTP handles GET /feed/<name>?sig=<signature> (under /api/v1). The store is reloaded on
+// every request so revocations apply immediately.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := h.store.Load(); err != nil {
+		http.Error(w, "feed store unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/feed/")
+	if _, err := h.store.Verify(name, r.URL.Query().Get("sig"), time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=30")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(h.status())
+}
+
+func (h *Handler) status() *Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if h.cached != nil && now.Before(h.expiresAt) {
+		return h.cached
+	}
+
+	h.cached = Collect(h.cluster, h.prom, h.am)
+	h.expiresAt = now.Add(cacheTTL)
+	return h.cached
+}
+
+// Collect builds a status document. Unreachable sources are reported as
+// unknown rather than failing the feed.
+func Collect(cluster string, prom *health.PrometheusClient, am *alertmanager.Client) *Status {
+	st := &Status{
+		Cluster:     cluster,
+		Status:      health.StatusUnknown,
+		Criticals:   []Critical{},
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if metrics, err := prom.CollectAllMetrics(); err == nil && len(metrics) > 0 {
+		ch := health.NewCalculator().CalculateClusterHealth(metrics)
+		score := ch.OverallScore
+		st.HealthScore = &score
+		st.Status = ch.Status
+		st.GPUs = ch.TotalGPUs
+		st.CriticalGPUs = ch.CriticalGPUs
+	}
+
+	if alerts, err := am.ListAlerts(`severity="critical"`); err == nil {
+		for _, a := range alerts {
+			if a.Status.State != "active" {
+				continue
+			}
+			node := a.Labels["node"]
+			if node == "" {
+				node = a.Labels["instance"]
+			}
+			st.Criticals = append(st.Criticals, Critical{
+				Alert: a.Labels["alertname"],
+				Node:  node,
+				Since: a.StartsAt,
+			})
+		}
+		sort.Slice(st.Criticals, func(i, j int) bool {
+			return st.Criticals[i].Since.After(st.Criticals[j].Since)
+		})
+		if len(st.Criticals) > maxCriticals {
+			st.Criticals = st.Criticals[:maxCriticals]
+		}
+		if len(st.Criticals) > 0 {
+			st.Status = health.StatusCritical
+		}
+	}
+
+	return st
+}
@@ -0,0 +1,215 @@
+// Package feed provides read-only status feeds reachable through signed URLs.
+package feed
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultStorePath is where feeds and the signing secret are kept
+const DefaultStorePath = "/etc/aami/feeds.yaml"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Feed is a named, revocable status feed.
+type Feed struct {
+	Name      string    `yaml:"name"`
+	Nonce     string    `yaml:"nonce"` // regenerated on create, so old URLs stay invalid
+	CreatedAt time.Time `yaml:"created_at"`
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+	Revoked   bool      `yaml:"revoked"`
+}
+
+// Active reports whether the feed can be served at the given time.
+func (f Feed) Active(now time.Time) bool {
+	return !f.Revoked && (f.ExpiresAt.IsZero() || now.Before(f.ExpiresAt))
+}
+
+// StoreConfig is the on-disk format for the feed store.
+type StoreConfig struct {
+	Secret string `yaml:"secret"`
+	Feeds  []Feed `yaml:"feeds"`
+}
+
+// Store manages status feeds.
+type Store struct {
+	path   string
+	secret string
+	feeds  map[string]Feed
+	mu     sync.RWMutex
+}
+
+// NewStore creates a new feed store.
+func NewStore(path string) *Store {
+	return &Store{
+		path:  path,
+		feeds: make(map[string]Feed),
+	}
+}
+
+// Load reads the store from disk.
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read feeds: %w", err)
+	}
+
+	var config StoreConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parse feeds: %w", err)
+	}
+
+	s.secret = config.Secret
+	s.feeds = make(map[string]Feed)
+	for _, f := range config.Feeds {
+		s.feeds[f.Name] = f
+	}
+
+	return nil
+}
+
+func (s *Store) saveUnlocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	config := StoreConfig{Secret: s.secret}
+	for _, f := range s.feeds {
+		config.Feeds = append(config.Feeds, f)
+	}
+	sort.Slice(config.Feeds, func(i, j int) bool {
+		return config.Feeds[i].Name < config.Feeds[j].Name
+	})
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal feeds: %w", err)
+	}
+
+	// Write with restricted permissions (contains the signing secret)
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("write feeds: %w", err)
+	}
+
+	return nil
+}
+
+// Create adds a feed, or re-issues a revoked one with a new signature.
+// A zero ttl never expires.
+func (s *Store) Create(name string, ttl time.Duration) (Feed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !namePattern.MatchString(name) {
+		return Feed{}, fmt.Errorf("invalid feed name: %s", name)
+	}
+	if existing, ok := s.feeds[name]; ok && !existing.Revoked {
+		return Feed{}, fmt.Errorf("feed already exists: %s", name)
+	}
+
+	if s.secret == "" {
+		secret, err := randomHex(32)
+		if err != nil {
+			return Feed{}, err
+		}
+		s.secret = secret
+	}
+
+	nonce, err := randomHex(16)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	f := Feed{Name: name, Nonce: nonce, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if ttl > 0 {
+		f.ExpiresAt = f.CreatedAt.Add(ttl)
+	}
+
+	s.feeds[name] = f
+	return f, s.saveUnlocked()
+}
+
+// Revoke invalidates a feed's URL.
+func (s *Store) Revoke(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.feeds[name]
+	if !ok {
+		return fmt.Errorf("feed not found: %s", name)
+	}
+	f.Revoked = true
+	s.feeds[name] = f
+	return s.saveUnlocked()
+}
+
+// List returns all feeds sorted by name.
+func (s *Store) List() []Feed {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	feeds := make([]Feed, 0, len(s.feeds))
+	for _, f := range s.feeds {
+		feeds = append(feeds, f)
+	}
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].Name < feeds[j].Name
+	})
+	return feeds
+}
+
+// Signature returns the URL signature for a feed.
+func (s *Store) Signature(f Feed) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(f.Name + ":" + f.Nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Path returns the signed URL path for a feed.
+func (s *Store) Path(f Feed) string {
+	return fmt.Sprintf("/api/v1/feed/%s?sig=%s", f.Name, s.Signature(f))
+}
+
+// Verify returns the feed if the signature is valid and the feed is active.
+func (s *Store) Verify(name, sig string, now time.Time) (Feed, error) {
+	s.mu.RLock()
+	f, ok := s.feeds[name]
+	s.mu.RUnlock()
+
+	if !ok || !hmac.Equal([]byte(s.Signature(f)), []byte(sig)) {
+		return Feed{}, fmt.Errorf("invalid feed signature")
+	}
+	if !f.Active(now) {
+		return Feed{}, fmt.Errorf("feed revoked or expired")
+	}
+	return f, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
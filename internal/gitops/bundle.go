@@ -0,0 +1,311 @@
+// Package gitops exports the whole AAMI configuration — config.yaml, the
+// rule files under the rules directory and the notification templates the
+// config refers to — as one deterministic YAML bundle, and plans the
+// changes that applying a bundle makes, so the configuration can be kept
+// in git and reviewed as a diff.
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+	"github.com/fregataa/aami/internal/notify"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+// Bundle identification
+const (
+	APIVersion = "aami/v1"
+	Kind       = "ConfigBundle"
+)
+
+// Bundle is the exported configuration.
+type Bundle struct {
+	APIVersion string         `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string         `yaml:"kind" json:"kind"`
+	Config     *config.Config `yaml:"config" json:"config"`
+	Rules      []File         `yaml:"rules,omitempty" json:"rules,omitempty"`         // by key under prometheus.RulesDir
+	Templates  []File         `yaml:"templates,omitempty" json:"templates,omitempty"` // by absolute path
+}
+
+// File is a file of a bundle.
+type File struct {
+	Path    string `yaml:"path" json:"path"`
+	Content string `yaml:"content" json:"content"`
+}
+
+// Export builds the bundle of cfg and the files it owns. Files are sorted
+// by path, so exporting an unchanged configuration gives the same bundle.
+func Export(cfg *config.Config) (*Bundle, error) {
+	b := &Bundle{APIVersion: APIVersion, Kind: Kind, Config: cfg}
+
+	rules, err := prometheus.LocalRuleFiles()
+	if err != nil {
+		return nil, err
+	}
+	for key, p := range rules {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read rule file: %w", err)
+		}
+		b.Rules = append(b.Rules, File{Path: key, Content: string(data)})
+	}
+
+	for _, p := range TemplatePaths(cfg) {
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read template: %w", err)
+		}
+		b.Templates = append(b.Templates, File{Path: p, Content: string(data)})
+	}
+
+	sortFiles(b.Rules)
+	sortFiles(b.Templates)
+	return b, nil
+}
+
+// TemplatePaths returns the notification template files cfg refers to.
+func TemplatePaths(cfg *config.Config) []string {
+	var configured []string
+	if n := cfg.Notifications.Slack; n != nil {
+		configured = append(configured, n.Template)
+	}
+	if n := cfg.Notifications.Email; n != nil {
+		configured = append(configured, n.Template)
+	}
+	if n := cfg.Notifications.Webhook; n != nil {
+		configured = append(configured, n.Template)
+	}
+
+	var paths []string
+	for _, p := range configured {
+		if p != "" && !contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Marshal renders the bundle as YAML.
+func (b *Bundle) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(b); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse reads a bundle and checks that it can be applied: a known
+// apiVersion and kind, a valid config, rule files within the rules
+// directory and only the templates the config refers to, within
+// notify.TemplatesDir.
+func Parse(data []byte) (*Bundle, error) {
+	// The config is parsed like config.yaml: ${VAR} references expand and
+	// defaults apply, so bundles can leave secrets out of git
+	var raw struct {
+		APIVersion string    `yaml:"apiVersion"`
+		Kind       string    `yaml:"kind"`
+		Config     yaml.Node `yaml:"config"`
+		Rules      []File    `yaml:"rules"`
+		Templates  []File    `yaml:"templates"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	if raw.APIVersion != APIVersion || raw.Kind != Kind {
+		return nil, fmt.Errorf("not a config bundle: want apiVersion %s and kind %s", APIVersion, Kind)
+	}
+	if raw.Config.Kind == 0 {
+		return nil, fmt.Errorf("bundle has no config")
+	}
+	cfgData, err := yaml.Marshal(&raw.Config)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Parse(cfgData)
+	if err != nil {
+		return nil, fmt.Errorf("parse config in bundle: %w", err)
+	}
+	b := Bundle{APIVersion: raw.APIVersion, Kind: raw.Kind, Config: cfg, Rules: raw.Rules, Templates: raw.Templates}
+	if errs := b.Config.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config in bundle: %v", errs[0])
+	}
+
+	for _, f := range b.Rules {
+		if err := validRuleKey(f.Path); err != nil {
+			return nil, err
+		}
+	}
+	templates := TemplatePaths(b.Config)
+	for _, f := range b.Templates {
+		if !contains(templates, f.Path) {
+			return nil, fmt.Errorf("template %s is not referenced by the config", f.Path)
+		}
+		if _, err := TemplatePath(f.Path); err != nil {
+			return nil, err
+		}
+	}
+	sortFiles(b.Rules)
+	sortFiles(b.Templates)
+	return &b, nil
+}
+
+// validRuleKey accepts <file>.yaml and <namespace>/<file>.yaml, the keys
+// of prometheus.LocalRuleFiles
+func validRuleKey(key string) error {
+	ext := path.Ext(key)
+	parts := strings.Split(key, "/")
+	if (ext != ".yaml" && ext != ".yml") || len(parts) > 2 || path.Clean(key) != key {
+		return fmt.Errorf("invalid rule file path %q: want <file>.yaml or <namespace>/<file>.yaml", key)
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.HasPrefix(p, ".") {
+			return fmt.Errorf("invalid rule file path %q", key)
+		}
+	}
+	return nil
+}
+
+// Change kinds
+const (
+	KindConfig   = "config"
+	KindRules    = "rules"
+	KindTemplate = "template"
+)
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is one difference between the current configuration and a bundle.
+type Change struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Diff   string `json:"diff,omitempty"` // line diff from current to desired
+}
+
+// Plan returns the changes that turn current into desired: the config
+// first, then rule files, then templates. Rule files missing from desired
+// are deleted only with prune; templates are never deleted, as they may
+// live outside AAMI's directories.
+func Plan(current, desired *Bundle, prune bool) ([]Change, error) {
+	var changes []Change
+
+	before, err := yaml.Marshal(current.Config)
+	if err != nil {
+		return nil, err
+	}
+	after, err := yaml.Marshal(desired.Config)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(before, after) {
+		changes = append(changes, Change{
+			Kind:   KindConfig,
+			Path:   "config.yaml",
+			Action: ActionUpdate,
+			Diff:   drift.Diff(string(before), string(after)),
+		})
+	}
+
+	changes = append(changes, planFiles(KindRules, current.Rules, desired.Rules, prune)...)
+	changes = append(changes, planFiles(KindTemplate, current.Templates, desired.Templates, false)...)
+	return changes, nil
+}
+
+func planFiles(kind string, current, desired []File, prune bool) []Change {
+	have := make(map[string]string, len(current))
+	for _, f := range current {
+		have[f.Path] = f.Content
+	}
+	want := make(map[string]bool, len(desired))
+
+	var changes []Change
+	for _, f := range desired {
+		want[f.Path] = true
+		content, ok := have[f.Path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: kind, Path: f.Path, Action: ActionCreate})
+		case content != f.Content:
+			changes = append(changes, Change{Kind: kind, Path: f.Path, Action: ActionUpdate, Diff: drift.Diff(content, f.Content)})
+		}
+	}
+	if prune {
+		for _, f := range current {
+			if !want[f.Path] {
+				changes = append(changes, Change{Kind: kind, Path: f.Path, Action: ActionDelete})
+			}
+		}
+	}
+	return changes
+}
+
+// File returns the content of the bundle file of a change.
+func (b *Bundle) File(c Change) string {
+	files := b.Rules
+	if c.Kind == KindTemplate {
+		files = b.Templates
+	}
+	for _, f := range files {
+		if f.Path == c.Path {
+			return f.Content
+		}
+	}
+	return ""
+}
+
+// RulePath returns where a rule file key lives on disk, and its namespace
+// ("" for the root rules directory).
+func RulePath(key string) (path, namespace string) {
+	if ns, _, ok := strings.Cut(key, "/"); ok {
+		namespace = ns
+	}
+	return filepath.Join(prometheus.RulesDir, filepath.FromSlash(key)), namespace
+}
+
+// TemplatePath returns the cleaned path of a template of a bundle, or an
+// error if it is not under notify.TemplatesDir, so an import cannot write
+// files elsewhere.
+func TemplatePath(p string) (string, error) {
+	cleaned := filepath.Clean(p)
+	if !filepath.IsAbs(cleaned) || !strings.HasPrefix(cleaned, notify.TemplatesDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("template %s is outside %s", p, notify.TemplatesDir)
+	}
+	return cleaned, nil
+}
+
+func sortFiles(files []File) {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
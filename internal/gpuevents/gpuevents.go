@@ -0,0 +1,183 @@
+// Package gpuevents is the table of NVIDIA Xid (GPU driver) and SXid
+// (NVSwitch) codes AAMI recognizes, with what each means, how severe it is
+// and what to do about it, in a line. The Slurm analyzer, drain policy and
+// alert annotations use it, so that outputs read "Xid 79: GPU fell off the
+// bus — reseat or replace the GPU" rather than a bare code. 'aami explain
+// xid' has the longer descriptions.
+package gpuevents
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of codes
+const (
+	KindXid  = "xid"  // logged by the GPU driver as "NVRM: Xid"
+	KindSXid = "sxid" // logged by the NVSwitch driver as "nvidia-nvswitch: SXid"
+)
+
+// References list every code of a kind, for unknown ones
+var References = map[string]string{
+	KindXid:  "https://docs.nvidia.com/deploy/xid-errors/",
+	KindSXid: "https://docs.nvidia.com/datacenter/tesla/fabric-manager-user-guide/",
+}
+
+// Severities, as in GPU events and alert labels
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Code is a known Xid or SXid code.
+type Code struct {
+	Kind     string `json:"kind"`
+	Code     int    `json:"code"`
+	Meaning  string `json:"meaning"`
+	Severity string `json:"severity"`
+	Action   string `json:"action"`
+}
+
+// Label returns "Xid <code>" or "SXid <code>"
+func (c Code) Label() string {
+	return label(c.Kind, c.Code)
+}
+
+// String returns the code with its meaning and action, e.g.
+// "Xid 79: GPU fell off the bus — reseat or replace the GPU"
+func (c Code) String() string {
+	return fmt.Sprintf("%s: %s — %s", c.Label(), c.Meaning, c.Action)
+}
+
+// xids holds the Xid codes. Severities agree with 'aami explain xid'.
+var xids = map[int]Code{
+	8:   {Meaning: "GPU failed to initialize", Severity: SeverityCritical, Action: "reinstall the driver, then reseat the GPU"},
+	13:  {Meaning: "graphics engine exception", Severity: SeverityCritical, Action: "check the application; if other jobs hit it too, run 'aami gpu validate'"},
+	31:  {Meaning: "GPU memory page fault", Severity: SeverityCritical, Action: "check the application; if other jobs hit it too, run 'aami gpu validate'"},
+	32:  {Meaning: "invalid or corrupted push buffer", Severity: SeverityCritical, Action: "check PCIe errors and reseat the GPU if it repeats"},
+	38:  {Meaning: "driver firmware error", Severity: SeverityCritical, Action: "update the driver and GPU firmware"},
+	43:  {Meaning: "GPU stopped processing", Severity: SeverityCritical, Action: "reset the GPU; check the application if it repeats"},
+	45:  {Meaning: "preemptive cleanup of a killed process", Severity: SeverityWarning, Action: "none unless it follows another Xid"},
+	48:  {Meaning: "double-bit ECC error", Severity: SeverityCritical, Action: "drain the node, reset the GPU and check retired pages"},
+	54:  {Meaning: "auxiliary power not connected", Severity: SeverityCritical, Action: "check the GPU's power cables"},
+	56:  {Meaning: "display engine error", Severity: SeverityWarning, Action: "update the driver"},
+	61:  {Meaning: "internal micro-controller halt", Severity: SeverityCritical, Action: "reset the GPU and update the driver"},
+	62:  {Meaning: "ECC memory page retired", Severity: SeverityWarning, Action: "reset the GPU when idle to apply the retirement"},
+	63:  {Meaning: "row remapping failed", Severity: SeverityWarning, Action: "reset the GPU; replace it if remapping keeps failing"},
+	64:  {Meaning: "high ECC error rate", Severity: SeverityWarning, Action: "drain the node and plan a GPU replacement"},
+	68:  {Meaning: "video processor exception", Severity: SeverityWarning, Action: "update the driver"},
+	69:  {Meaning: "graphics engine class error", Severity: SeverityCritical, Action: "reset the GPU and update the driver"},
+	74:  {Meaning: "GPU recovered with a reset", Severity: SeverityWarning, Action: "watch for repeats and run 'aami gpu validate'"},
+	79:  {Meaning: "GPU fell off the bus", Severity: SeverityCritical, Action: "reseat or replace the GPU"},
+	92:  {Meaning: "high single-bit ECC error rate", Severity: SeverityWarning, Action: "plan a GPU replacement if the rate keeps growing"},
+	94:  {Meaning: "contained ECC error", Severity: SeverityWarning, Action: "reset the GPU; the affected job must restart"},
+	95:  {Meaning: "uncontained ECC error", Severity: SeverityCritical, Action: "drain the node and reset the GPU"},
+	109: {Meaning: "context switch timeout", Severity: SeverityWarning, Action: "check the application; reset the GPU if it repeats"},
+	119: {Meaning: "GSP firmware timeout", Severity: SeverityCritical, Action: "reset the GPU and update the driver"},
+	120: {Meaning: "GSP firmware error", Severity: SeverityCritical, Action: "reset the GPU and update the driver"},
+}
+
+// sxids holds the SXid codes. Fatal SXids are critical: the NVSwitch stops
+// routing until it is reset.
+var sxids = map[int]Code{
+	11004: {Meaning: "NVSwitch ingress invalid ACL", Severity: SeverityCritical, Action: "check the fabric manager config and restart it"},
+	12028: {Meaning: "NVSwitch egress non-posted PRIV error", Severity: SeverityWarning, Action: "none unless it repeats; then update the driver"},
+	20034: {Meaning: "NVLink fault on an NVSwitch port, the link went down", Severity: SeverityCritical, Action: "drain the node and reboot it; reseat the baseboard if it repeats"},
+	22013: {Meaning: "NVLink link down requested by the NVSwitch", Severity: SeverityCritical, Action: "drain the node and reboot it"},
+}
+
+// Lookup returns a known code.
+func Lookup(kind string, code int) (Code, bool) {
+	var c Code
+	var ok bool
+	switch kind {
+	case KindXid:
+		c, ok = xids[code]
+	case KindSXid:
+		c, ok = sxids[code]
+	}
+	if !ok {
+		return Code{}, false
+	}
+	c.Kind, c.Code = kind, code
+	return c, true
+}
+
+// Codes returns the known codes of a kind, in order.
+func Codes(kind string) []Code {
+	table := xids
+	if kind == KindSXid {
+		table = sxids
+	}
+	codes := make([]Code, 0, len(table))
+	for code := range table {
+		c, _ := Lookup(kind, code)
+		codes = append(codes, c)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].Code < codes[j].Code
+	})
+	return codes
+}
+
+// Describe returns a code with its meaning and action if it is known, else
+// just the code, e.g. "Xid 79: GPU fell off the bus — reseat or replace
+// the GPU" or "Xid 150".
+func Describe(kind string, code int) string {
+	if c, ok := Lookup(kind, code); ok {
+		return c.String()
+	}
+	return label(kind, code)
+}
+
+// ParseValue reads a code from a metric sample value, such as "79" or
+// "79.00" of DCGM_FI_DEV_XID_ERRORS.
+func ParseValue(value string) (int, bool) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 || f != float64(int(f)) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// Severity returns the severity of a code, or fallback for an unknown one.
+func Severity(kind string, code int, fallback string) string {
+	if c, ok := Lookup(kind, code); ok {
+		return c.Severity
+	}
+	return fallback
+}
+
+// AnnotationTemplate returns a Prometheus annotation template giving the
+// meaning and action of the code in the alert's $value, for rules whose
+// value is the code itself (DCGM_FI_DEV_XID_ERRORS holds the last Xid).
+// Unknown codes point to NVIDIA's list.
+func AnnotationTemplate(kind string) string {
+	var sb strings.Builder
+	for i, c := range Codes(kind) {
+		if i > 0 {
+			sb.WriteString("{{ else if")
+		} else {
+			sb.WriteString("{{ if")
+		}
+		fmt.Fprintf(&sb, " eq $value %d.0 }}%s — %s", c.Code, c.Meaning, c.Action)
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	fmt.Fprintf(&sb, "{{ else }}unlisted code, see %s{{ end }}", References[kind])
+	return sb.String()
+}
+
+func label(kind string, code int) string {
+	return fmt.Sprintf("%s %d", kindName(kind), code)
+}
+
+func kindName(kind string) string {
+	if kind == KindSXid {
+		return "SXid"
+	}
+	return "Xid"
+}
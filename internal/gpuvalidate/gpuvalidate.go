@@ -0,0 +1,280 @@
+// Package gpuvalidate describes the GPU burn-in suite node agents run for
+// 'aami gpu validate', and stores the reports they post: DCGM diagnostics,
+// host-device bandwidth and NCCL all-reduce, each with its outcome. A report
+// is posted when the run starts and again as each step finishes, so it shows
+// the progress of a running validation.
+package gpuvalidate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir is where reports are stored
+const DefaultDir = "/var/lib/aami/gpu-validations"
+
+// ErrNotFound is returned for a report that does not exist
+var ErrNotFound = errors.New("no validation report")
+
+// Steps of the suite
+const (
+	StepDCGMDiag      = "dcgm-diag"      // dcgmi diag -r <level>
+	StepBandwidth     = "bandwidth"      // CUDA bandwidthTest, host-device
+	StepNCCLAllReduce = "nccl-allreduce" // all_reduce_perf of nccl-tests over every GPU
+)
+
+// StepNames returns the steps the agent runs
+func StepNames() []string {
+	return []string{StepDCGMDiag, StepBandwidth, StepNCCLAllReduce}
+}
+
+// Statuses of reports and steps
+const (
+	StatusPending = "pending" // steps only
+	StatusRunning = "running"
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+)
+
+// DefaultStepTimeout bounds a step without a timeout
+const DefaultStepTimeout = 30 * time.Minute
+
+// Step is a step of the suite, as sent to the agent.
+type Step struct {
+	Name    string  `json:"name"`
+	Level   int     `json:"level,omitempty"`    // dcgm-diag run level, 1-4
+	MinGBps float64 `json:"min_gbps,omitempty"` // minimum bandwidth in GB/s
+	Timeout int     `json:"timeout,omitempty"`  // seconds
+}
+
+// DefaultSuite returns the steps run without gpu_validation.steps: a
+// level 3 DCGM diagnostic, the bandwidth test and NCCL all-reduce.
+func DefaultSuite() []Step {
+	return []Step{
+		{Name: StepDCGMDiag, Level: 3},
+		{Name: StepBandwidth},
+		{Name: StepNCCLAllReduce},
+	}
+}
+
+// Validate checks the step's name and settings.
+func (s Step) Validate() error {
+	switch s.Name {
+	case StepDCGMDiag:
+		if s.Level < 1 || s.Level > 4 {
+			return fmt.Errorf("%s: level must be 1-4", s.Name)
+		}
+	case StepBandwidth, StepNCCLAllReduce:
+		if s.Level != 0 {
+			return fmt.Errorf("%s: level only applies to %s", s.Name, StepDCGMDiag)
+		}
+	default:
+		return fmt.Errorf("unknown step %q (valid: %s)", s.Name, strings.Join(StepNames(), ", "))
+	}
+	if s.MinGBps < 0 {
+		return fmt.Errorf("%s: min_gbps must be non-negative", s.Name)
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("%s: timeout must be non-negative", s.Name)
+	}
+	return nil
+}
+
+// StepResult is the outcome of a step on the node.
+type StepResult struct {
+	Name            string             `json:"name"`
+	Status          string             `json:"status"`
+	Message         string             `json:"message,omitempty"`
+	Metrics         map[string]float64 `json:"metrics,omitempty"` // e.g. h2d_gbps, busbw_gbps
+	Output          string             `json:"output,omitempty"`  // tail of the tool's output
+	DurationSeconds float64            `json:"duration_seconds,omitempty"`
+}
+
+// Report is a validation run on a node, identified by its agent task.
+type Report struct {
+	ID         string       `json:"id"`     // agent task ID
+	Target     string       `json:"target"` // hostname of the node
+	Status     string       `json:"status"`
+	Steps      []StepResult `json:"steps"`
+	GPUs       int          `json:"gpus,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// Done reports whether the run finished
+func (r Report) Done() bool {
+	return r.Status == StatusPassed || r.Status == StatusFailed
+}
+
+// Validate checks the fields an agent must report.
+func (r Report) Validate() error {
+	if err := validName("id", r.ID); err != nil {
+		return err
+	}
+	if err := validName("target", r.Target); err != nil {
+		return err
+	}
+	switch r.Status {
+	case StatusRunning, StatusPassed, StatusFailed:
+	default:
+		return fmt.Errorf("invalid status %q (valid: %s, %s, %s)", r.Status, StatusRunning, StatusPassed, StatusFailed)
+	}
+	for _, s := range r.Steps {
+		switch s.Status {
+		case StatusPending, StatusRunning, StatusPassed, StatusFailed:
+		default:
+			return fmt.Errorf("step %s: invalid status %q", s.Name, s.Status)
+		}
+	}
+	return nil
+}
+
+// Store reads and writes reports, one JSON file per run under a directory
+// per node.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewStore creates a report store.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, now: time.Now}
+}
+
+// Put validates and stores a report, replacing the one posted before for
+// the same run.
+func (s *Store) Put(r Report) (Report, error) {
+	if err := r.Validate(); err != nil {
+		return Report{}, err
+	}
+	now := s.now().UTC()
+	r.UpdatedAt = now
+	if r.StartedAt.IsZero() {
+		r.StartedAt = now
+	}
+	if r.Done() && r.FinishedAt == nil {
+		r.FinishedAt = &now
+	}
+	if r.Steps == nil {
+		r.Steps = []StepResult{}
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return Report{}, fmt.Errorf("marshal report: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.dir, r.Target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Report{}, fmt.Errorf("create report directory: %w", err)
+	}
+	path := filepath.Join(dir, r.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return Report{}, fmt.Errorf("write report: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Report{}, fmt.Errorf("write report: %w", err)
+	}
+	return r, nil
+}
+
+// Get returns a report of a node.
+func (s *Store) Get(target, id string) (Report, error) {
+	if err := validName("target", target); err != nil {
+		return Report{}, err
+	}
+	if err := validName("id", id); err != nil {
+		return Report{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(filepath.Join(s.dir, target, id+".json"))
+}
+
+// List returns the reports of a node, or of every node if target is empty,
+// newest first.
+func (s *Store) List(target string) ([]Report, error) {
+	dirs := []string{target}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if target == "" {
+		entries, err := os.ReadDir(s.dir)
+		if os.IsNotExist(err) {
+			return []Report{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read report directory: %w", err)
+		}
+		dirs = dirs[:0]
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs = append(dirs, e.Name())
+			}
+		}
+	} else if err := validName("target", target); err != nil {
+		return nil, err
+	}
+
+	reports := []Report{}
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(filepath.Join(s.dir, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read report directory: %w", err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			r, err := s.read(filepath.Join(s.dir, dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, r)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].StartedAt.After(reports[j].StartedAt)
+	})
+	return reports, nil
+}
+
+func (s *Store) read(path string) (Report, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Report{}, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	if err != nil {
+		return Report{}, fmt.Errorf("read report: %w", err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Report{}, fmt.Errorf("parse report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+func validName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("%s is required", kind)
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid %s %q", kind, name)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+// Package hardware stores the hardware inventory reported by node agents:
+// CPU, memory, GPUs, network and InfiniBand adapters, and the kernel and
+// driver versions. Only the latest inventory of each node is kept, one JSON
+// file per node, replaced on every report.
+package hardware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir is where inventories are stored
+const DefaultDir = "/var/lib/aami/hardware"
+
+// ErrNotFound is returned for a node that has not reported its inventory
+var ErrNotFound = errors.New("no inventory reported")
+
+// Network adapter types
+const (
+	NICEthernet   = "ethernet"
+	NICInfiniBand = "infiniband"
+)
+
+// Inventory is the hardware of a node as its agent last collected it.
+type Inventory struct {
+	Target      string            `json:"target"` // hostname of the node
+	CPU         CPU               `json:"cpu"`
+	MemoryBytes int64             `json:"memory_bytes"`
+	GPUs        []GPU             `json:"gpus"`
+	NICs        []NIC             `json:"nics"`
+	HCAs        []HCA             `json:"hcas"` // InfiniBand adapters
+	OS          string            `json:"os,omitempty"`
+	Kernel      string            `json:"kernel"`
+	Drivers     map[string]string `json:"drivers,omitempty"` // e.g. nvidia, cuda, ofed
+	CollectedAt time.Time         `json:"collected_at"`
+	ReportedAt  time.Time         `json:"reported_at"`
+}
+
+// CPU describes the processors of a node.
+type CPU struct {
+	Model   string `json:"model"`
+	Sockets int    `json:"sockets"`
+	Cores   int    `json:"cores"`   // physical cores, all sockets
+	Threads int    `json:"threads"` // logical CPUs
+}
+
+// GPU is one GPU of a node.
+type GPU struct {
+	Index     int    `json:"index"`
+	Model     string `json:"model"`
+	UUID      string `json:"uuid"`
+	Serial    string `json:"serial,omitempty"`
+	VBIOS     string `json:"vbios,omitempty"`
+	MemoryMiB int64  `json:"memory_mib,omitempty"`
+}
+
+// NIC is a physical network interface of a node.
+type NIC struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // ethernet or infiniband (IPoIB)
+	Driver    string `json:"driver,omitempty"`
+	MAC       string `json:"mac,omitempty"`
+	SpeedMbps int    `json:"speed_mbps,omitempty"` // 0 if the link is down or unknown
+}
+
+// HCA is an InfiniBand host channel adapter.
+type HCA struct {
+	Name     string    `json:"name"` // e.g. mlx5_0
+	Model    string    `json:"model,omitempty"`
+	BoardID  string    `json:"board_id,omitempty"`
+	Firmware string    `json:"firmware,omitempty"`
+	Ports    []HCAPort `json:"ports,omitempty"`
+}
+
+// HCAPort is a port of an InfiniBand adapter.
+type HCAPort struct {
+	Port  int    `json:"port"`
+	State string `json:"state,omitempty"` // e.g. ACTIVE
+	Rate  string `json:"rate,omitempty"`  // e.g. 400 Gb/sec (4X NDR)
+}
+
+// Validate checks the fields an agent must report.
+func (inv Inventory) Validate() error {
+	if inv.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if strings.ContainsAny(inv.Target, `/\`) || strings.HasPrefix(inv.Target, ".") {
+		return fmt.Errorf("invalid target %q", inv.Target)
+	}
+	if inv.MemoryBytes < 0 {
+		return fmt.Errorf("memory_bytes must be non-negative")
+	}
+	for _, n := range inv.NICs {
+		if n.Type != NICEthernet && n.Type != NICInfiniBand {
+			return fmt.Errorf("nic %s: invalid type %q (valid: %s, %s)", n.Name, n.Type, NICEthernet, NICInfiniBand)
+		}
+	}
+	return nil
+}
+
+// GPUModels returns the GPU models of the inventory and how many GPUs of
+// each, e.g. "8x NVIDIA H100 80GB HBM3".
+func (inv Inventory) GPUModels() []string {
+	counts := map[string]int{}
+	var models []string
+	for _, g := range inv.GPUs {
+		if counts[g.Model] == 0 {
+			models = append(models, g.Model)
+		}
+		counts[g.Model]++
+	}
+	out := make([]string, len(models))
+	for i, m := range models {
+		out[i] = fmt.Sprintf("%dx %s", counts[m], m)
+	}
+	return out
+}
+
+// Store reads and writes inventories.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewStore creates an inventory store.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, now: time.Now}
+}
+
+// Put validates and stores the inventory of a node, replacing the one it
+// reported before. An inventory without a collection time is taken to be
+// collected when reported.
+func (s *Store) Put(inv Inventory) (Inventory, error) {
+	if err := inv.Validate(); err != nil {
+		return Inventory{}, err
+	}
+	now := s.now().UTC()
+	inv.ReportedAt = now
+	if inv.CollectedAt.IsZero() {
+		inv.CollectedAt = now
+	}
+	if inv.GPUs == nil {
+		inv.GPUs = []GPU{}
+	}
+	if inv.NICs == nil {
+		inv.NICs = []NIC{}
+	}
+	if inv.HCAs == nil {
+		inv.HCAs = []HCA{}
+	}
+
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return Inventory{}, fmt.Errorf("marshal inventory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return Inventory{}, fmt.Errorf("create inventory directory: %w", err)
+	}
+	path := s.path(inv.Target)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return Inventory{}, fmt.Errorf("write inventory: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Inventory{}, fmt.Errorf("write inventory: %w", err)
+	}
+	return inv, nil
+}
+
+// Get returns the latest inventory of a node.
+func (s *Store) Get(target string) (Inventory, error) {
+	if err := (Inventory{Target: target}).Validate(); err != nil {
+		return Inventory{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(s.path(target))
+}
+
+// List returns the latest inventory of every node, by target.
+func (s *Store) List() ([]Inventory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Inventory{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read inventory directory: %w", err)
+	}
+	inventories := []Inventory{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		inv, err := s.read(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		inventories = append(inventories, inv)
+	}
+	sort.Slice(inventories, func(i, j int) bool {
+		return inventories[i].Target < inventories[j].Target
+	})
+	return inventories, nil
+}
+
+func (s *Store) path(target string) string {
+	return filepath.Join(s.dir, target+".json")
+}
+
+func (s *Store) read(path string) (Inventory, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Inventory{}, fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	if err != nil {
+		return Inventory{}, fmt.Errorf("read inventory: %w", err)
+	}
+	var inv Inventory
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return Inventory{}, fmt.Errorf("parse inventory %s: %w", path, err)
+	}
+	return inv, nil
+}
@@ -33,7 +33,8 @@ type PrometheusResponse struct {
 		ResultType string `json:"resultType"`
 		Result     []struct {
 			Metric map[string]string `json:"metric"`
-			Value  []interface{}     `json:"value"` // [timestamp, value]
+			Value  []interface{}     `json:"value"`            // [timestamp, value]
+			Values [][]interface{}   `json:"values,omitempty"` // range queries
 		} `json:"result"`
 	} `json:"data"`
 	Error     string `json:"error,omitempty"`
@@ -46,7 +47,24 @@ func (c *PrometheusClient) Query(query string) (*PrometheusResponse, error) {
 
 	params := url.Values{}
 	params.Set("query", query)
+	return c.get(endpoint, params)
+}
+
+// QueryRange executes a range query against Prometheus; each series has
+// its samples in Values.
+func (c *PrometheusClient) QueryRange(query string, start, end time.Time, step time.Duration) (*PrometheusResponse, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/query_range", c.baseURL)
+
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatInt(int64(step/time.Second), 10))
+	return c.get(endpoint, params)
+}
 
+// get runs a query and decodes its response
+func (c *PrometheusClient) get(endpoint string, params url.Values) (*PrometheusResponse, error) {
 	resp, err := c.httpClient.Get(endpoint + "?" + params.Encode())
 	if err != nil {
 		return nil, fmt.Errorf("prometheus query failed: %w", err)
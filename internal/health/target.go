@@ -0,0 +1,170 @@
+package health
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// TargetWeights defines how much GPU metrics, firing alerts and check
+// results count in the health score of a target.
+type TargetWeights struct {
+	GPU    float64 `yaml:"gpu"`    // Default: 0.50
+	Alerts float64 `yaml:"alerts"` // Default: 0.30
+	Checks float64 `yaml:"checks"` // Default: 0.20
+}
+
+// DefaultTargetWeights returns the default target scoring weights.
+func DefaultTargetWeights() TargetWeights {
+	return TargetWeights{
+		GPU:    0.50,
+		Alerts: 0.30,
+		Checks: 0.20,
+	}
+}
+
+// Points each firing alert takes off the alert score, by severity. Alerts
+// of other severities take alertPenaltyOther.
+var alertPenalties = map[string]float64{
+	"critical": 40,
+	"warning":  15,
+}
+
+const alertPenaltyOther = 5
+
+// TargetInputs is what the health score of a target is calculated from.
+type TargetInputs struct {
+	Target   string
+	Hostname string
+	// GPUs are the GPU metrics of the target, nil if it has none
+	GPUs *NodeMetrics
+	// Alerts are the severities of the target's firing alerts
+	Alerts []string
+	// Passing and Failing count the latest result of each check on the
+	// target; both are 0 if it reported none
+	Passing int
+	Failing int
+}
+
+// TargetHealth represents the health of a target: its GPUs, firing alerts
+// and check results.
+type TargetHealth struct {
+	Target       string           `json:"target"`
+	Hostname     string           `json:"hostname"`
+	OverallScore float64          `json:"overall_score"` // 0-100
+	Status       string           `json:"status"`        // healthy, warning, critical, unknown
+	Components   []ComponentScore `json:"components"`
+	GPUs         []GPUHealth      `json:"gpus"`
+	CollectedAt  time.Time        `json:"collected_at"`
+}
+
+// CalculateTargetHealth calculates the health score of a target. Components
+// with no data (no GPU metrics, no check results) have status unknown and
+// do not count; a target with none has status unknown.
+func (c *Calculator) CalculateTargetHealth(in TargetInputs, w TargetWeights) TargetHealth {
+	health := TargetHealth{
+		Target:      in.Target,
+		Hostname:    in.Hostname,
+		GPUs:        []GPUHealth{},
+		CollectedAt: time.Now(),
+	}
+
+	gpuScore := ComponentScore{Name: "GPUs", Weight: w.GPU, Status: StatusUnknown, Message: "No GPU metrics"}
+	if in.GPUs != nil && len(in.GPUs.GPUs) > 0 {
+		node := c.CalculateNodeHealth(*in.GPUs)
+		health.GPUs = node.GPUs
+		gpuScore.Score = node.OverallScore
+		gpuScore.RawValue = float64(len(node.GPUs))
+		gpuScore.Status = node.Status
+		gpuScore.Message = fmt.Sprintf("%d GPU(s), %d warning, %d critical",
+			len(node.GPUs), node.WarningGPUs, node.CriticalGPUs)
+	}
+
+	health.Components = []ComponentScore{
+		gpuScore,
+		calculateAlertScore(in.Alerts, w.Alerts),
+		calculateCheckScore(in.Passing, in.Failing, w.Checks),
+	}
+
+	var totalWeight float64
+	var weightedSum float64
+	for i := range health.Components {
+		comp := &health.Components[i]
+		if comp.Status == StatusUnknown {
+			continue
+		}
+		comp.Weighted = comp.Score * comp.Weight / 100
+		weightedSum += comp.Weighted
+		totalWeight += comp.Weight
+	}
+
+	if totalWeight > 0 {
+		health.OverallScore = weightedSum / totalWeight * 100
+		health.Status = GetStatusFromScore(health.OverallScore)
+	} else {
+		health.Status = StatusUnknown
+	}
+
+	return health
+}
+
+// calculateAlertScore scores a target's firing alerts: 100 less a penalty
+// per alert by severity.
+func calculateAlertScore(severities []string, weight float64) ComponentScore {
+	score := ComponentScore{
+		Name:     "Alerts",
+		Weight:   weight,
+		RawValue: float64(len(severities)),
+	}
+
+	penalty, critical := 0.0, 0
+	for _, s := range severities {
+		p, ok := alertPenalties[s]
+		if !ok {
+			p = alertPenaltyOther
+		}
+		penalty += p
+		if s == "critical" {
+			critical++
+		}
+	}
+	score.Score = math.Max(0, 100-penalty)
+
+	switch {
+	case len(severities) == 0:
+		score.Status = StatusHealthy
+		score.Message = "No firing alerts"
+	case critical > 0:
+		score.Status = StatusCritical
+		score.Message = fmt.Sprintf("%d firing, %d critical", len(severities), critical)
+	default:
+		score.Status = StatusWarning
+		score.Message = fmt.Sprintf("%d firing", len(severities))
+	}
+	return score
+}
+
+// calculateCheckScore scores the latest check results of a target: the
+// share of checks passing.
+func calculateCheckScore(passing, failing int, weight float64) ComponentScore {
+	score := ComponentScore{
+		Name:     "Checks",
+		Weight:   weight,
+		RawValue: float64(failing),
+	}
+
+	total := passing + failing
+	if total == 0 {
+		score.Status = StatusUnknown
+		score.Message = "No check results"
+		return score
+	}
+	score.Score = float64(passing) / float64(total) * 100
+	score.Status = GetStatusFromScore(score.Score)
+	if failing == 0 {
+		score.Message = fmt.Sprintf("%d passing", passing)
+	} else {
+		score.Message = fmt.Sprintf("%d of %d failing", failing, total)
+	}
+	return score
+}
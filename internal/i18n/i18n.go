@@ -0,0 +1,79 @@
+// Package i18n translates operator-facing text: CLI messages and the
+// summaries and descriptions of generated alert rules.
+//
+// Messages are looked up by their English text, so call sites stay
+// readable and a message without a translation falls back to English.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lang is a supported language
+type Lang string
+
+const (
+	EN Lang = "en"
+	KO Lang = "ko"
+)
+
+// catalogs holds the translations of each language, keyed by English text
+var catalogs = map[Lang]map[string]string{
+	KO: ko,
+}
+
+// current is the language of CLI messages
+var current = EN
+
+// Supported returns the supported languages
+func Supported() []Lang {
+	return []Lang{EN, KO}
+}
+
+// Parse parses a language name such as "ko", "ko-KR" or "ko_KR.UTF-8".
+// An empty name is English.
+func Parse(name string) (Lang, error) {
+	if name == "" {
+		return EN, nil
+	}
+	base := strings.ToLower(name)
+	if i := strings.IndexAny(base, "-_."); i >= 0 {
+		base = base[:i]
+	}
+	for _, l := range Supported() {
+		if Lang(base) == l {
+			return l, nil
+		}
+	}
+	return EN, fmt.Errorf("unsupported language %q (supported: en, ko)", name)
+}
+
+// SetLang sets the language of CLI messages
+func SetLang(l Lang) {
+	current = l
+}
+
+// Current returns the language of CLI messages
+func Current() Lang {
+	return current
+}
+
+// T translates a CLI message into the current language. With args, the
+// translation is used as a fmt format.
+func T(msg string, args ...interface{}) string {
+	return In(current, msg, args...)
+}
+
+// In translates a message into lang. With args, the translation is used
+// as a fmt format; without, it is returned as is, so text containing %
+// (such as alert annotations) needs no escaping.
+func In(lang Lang, msg string, args ...interface{}) string {
+	if translated, ok := catalogs[lang][msg]; ok {
+		msg = translated
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
@@ -0,0 +1,236 @@
+package i18n
+
+// ko holds the Korean translations
+var ko = map[string]string{
+	// aami status
+	"AAMI Status":         "AAMI 상태",
+	"Cluster":             "클러스터",
+	"  Name:  %s":         "  이름:  %s",
+	"  Nodes: %d":         "  노드:  %d",
+	"  Context: %s":       "  컨텍스트: %s",
+	"  Alert Presets: %s": "  알림 프리셋: %s",
+	"Components":          "구성 요소",
+	"Notifications":       "알림 채널",
+	"enabled":             "활성화",
+	"disabled":            "비활성화",
+	"running":             "실행 중",
+	"not running":         "실행 중 아님",
+	"unhealthy (%d)":      "비정상 (%d)",
+	"(port %d)":           "(포트 %d)",
+
+	// aami alerts
+	"Available Alert Presets:": "사용 가능한 알림 프리셋:",
+	"Preset":                   "프리셋",
+	"Description":              "설명",
+	"Rules":                    "규칙",
+	"Apply a preset with: aami alerts apply-preset <name>":         "프리셋 적용: aami alerts apply-preset <name>",
+	"Apply a preset with: aami alerts apply-preset gpu-production": "프리셋 적용: aami alerts apply-preset gpu-production",
+	"Applied preset %s (%d rules)":                                 "프리셋 %s 적용됨 (규칙 %d개)",
+	"  Rules file: %s":                                             "  규칙 파일: %s",
+	"Note: Reload Prometheus to activate the rules:":               "참고: 규칙을 활성화하려면 Prometheus를 다시 로드하세요:",
+	"No alert rules configured.":                                   "설정된 알림 규칙이 없습니다.",
+	"Active Alert Rules:":                                          "활성 알림 규칙:",
+	"Not validated: %s":                                            "검증되지 않음: %s",
+	"promtool check rules passed":                                  "promtool check rules 통과",
+	"promtool check rules failed:":                                 "promtool check rules 실패:",
+	"%s: %d test file(s) passed":                                   "%s: 테스트 파일 %d개 통과",
+	"%s: tests failed":                                             "%s: 테스트 실패",
+	"Basic GPU monitoring alerts":                                  "기본 GPU 모니터링 알림",
+	"Comprehensive GPU monitoring for production":                  "프로덕션용 종합 GPU 모니터링",
+	"InfiniBand and NVLink link health":                            "InfiniBand 및 NVLink 링크 상태",
+	"Custom rules from the config":                                 "설정 파일의 사용자 정의 규칙",
+
+	// aami nodes
+	"Added %d nodes from %s": "%[2]s에서 노드 %[1]d개 추가됨",
+	"Node %s added":          "노드 %s 추가됨",
+	"Node %s removed":        "노드 %s 제거됨",
+	"No nodes configured.":   "설정된 노드가 없습니다.",
+	"Add nodes with: aami nodes add <name> --ip <ip> --user <user> --key <key>": "노드 추가: aami nodes add <name> --ip <ip> --user <user> --key <key>",
+	"Name":                                  "이름",
+	"Port":                                  "포트",
+	"User":                                  "사용자",
+	"Labels":                                "레이블",
+	"SSH Key":                               "SSH 키",
+	"Source":                                "출처",
+	"Total: %d nodes":                       "총 노드 %d개",
+	"No nodes match selector %s":            "셀렉터 %s에 맞는 노드가 없습니다",
+	"Installing exporters on %d node(s)...": "노드 %d개에 익스포터 설치 중...",
+	"Installation complete":                 "설치 완료",
+	"  Succeeded: %s":                       "  성공: %s",
+	"  Failed:    %s":                       "  실패: %s",
+	"Testing SSH connection to %d node(s)...": "노드 %d개에 SSH 연결 테스트 중...",
+	"Results: %s succeeded, %s failed":        "결과: 성공 %s, 실패 %s",
+
+	// aami silence
+	"Created silence %s until %s":       "사일런스 %s 생성됨 (%s까지)",
+	"  Matchers: %s":                    "  매처: %s",
+	"No silences.":                      "사일런스가 없습니다.",
+	"State":                             "상태",
+	"Matchers":                          "매처",
+	"Ends":                              "종료",
+	"Rule":                              "규칙",
+	"Created By":                        "생성자",
+	"Reason":                            "사유",
+	"Expired silence %s":                "사일런스 %s 만료됨",
+	"%s is not a preset or custom rule": "%s은(는) 프리셋 또는 사용자 정의 규칙이 아닙니다",
+
+	// aami check-results
+	"No check results.":          "검사 결과가 없습니다.",
+	"Reported":                   "보고 시각",
+	"Target":                     "대상",
+	"Check":                      "검사",
+	"Status":                     "상태",
+	"Duration":                   "소요 시간",
+	"Detail":                     "상세",
+	"Page %d of %d (%d results)": "%[1]d/%[2]d 페이지 (결과 %[3]d개)",
+	"Targets":                    "대상 수",
+	"Passing":                    "정상",
+	"Failing":                    "실패",
+	"Runs":                       "실행",
+	"Failures":                   "실패 횟수",
+	"Last Failure":               "마지막 실패",
+	"Failing now:":               "현재 실패 중:",
+	"Removed %d day(s) of check results older than %s": "%[2]s보다 오래된 검사 결과 %[1]d일치 삭제됨",
+
+	// aami agents
+	"Issued credential for %s, expires %s":                                  "%s 자격 증명 발급됨 (%s 만료)",
+	"  Written to %s; install it on the node as /etc/aami/agent-credential": "  %s에 저장됨. 노드의 /etc/aami/agent-credential로 설치하세요",
+	"Issued certificate for %s, expires %s":                                 "%s 인증서 발급됨 (%s 만료)",
+	"  Written to %s; install the files on the node in /etc/aami":           "  %s에 저장됨. 노드의 /etc/aami에 파일을 설치하세요",
+	"Revoked all credentials of %s":                                         "%s의 모든 자격 증명 폐기됨",
+	"No revocations.":                                                       "폐기 내역이 없습니다.",
+	"Revoked":                                                               "폐기 시각",
+	"Revoked By":                                                            "폐기자",
+
+	// aami storage
+	"  Uploaded to: %s":  "  업로드 위치: %s",
+	"local (%s)":         "로컬 (%s)",
+	"%s, copied from %s": "%[2]s에서 복사한 %[1]s",
+	"Rules:":             "규칙:",
+	"Backups:":           "백업:",
+	"Lifecycle:":         "수명 주기:",
+	"keep last %d":       "최근 %d개 보관",
+	"prune after %s":     "%s 후 정리",
+	"keep all":           "모두 보관",
+	"Dry run: %d rule file(s) to upload, %d to remove": "시험 실행: 업로드할 규칙 파일 %d개, 삭제할 파일 %d개",
+	"Uploaded %d rule file(s) to %s, removed %d":       "규칙 파일 %[1]d개를 %[2]s에 업로드함, %[3]d개 삭제함",
+	"Nothing stored in %s":                             "%s에 저장된 항목이 없습니다",
+	"Key":                                              "키",
+	"Size":                                             "크기",
+	"Modified":                                         "수정 시각",
+
+	// aami doctor
+	"No notification channel is enabled": "활성화된 알림 채널이 없습니다",
+	"%s is not writable":                 "%s에 쓸 수 없습니다",
+	"Run 'aami doctor --e2e' to send a synthetic alert through the pipeline.": "'aami doctor --e2e'로 합성 알림을 전체 파이프라인에 보내 볼 수 있습니다.",
+	"End-to-end test of group %s (waiting up to %s per step)":                 "그룹 %s 종단 간 테스트 (단계마다 최대 %s 대기)",
+	"Alerts reach their channels (test %s, %s)":                               "알림이 채널까지 전달됩니다 (테스트 %s, %s)",
+	"inject rule":             "규칙 주입",
+	"prometheus rule loaded":  "Prometheus 규칙 로드",
+	"prometheus alert firing": "Prometheus 알림 발생",
+	"alertmanager received":   "Alertmanager 수신",
+	"notification delivered":  "알림 전송",
+	"clean up":                "정리",
+	"Start Prometheus (systemctl start prometheus) and check that it listens on prometheus.port (%d)": "Prometheus를 시작하고(systemctl start prometheus) prometheus.port(%d)에서 수신하는지 확인하세요",
+	"Start Alertmanager (systemctl start alertmanager), or give its address with --alertmanager-url":  "Alertmanager를 시작하거나(systemctl start alertmanager) --alertmanager-url로 주소를 지정하세요",
+	"Enable a notification channel, e.g. with 'aami config notifications slack'":                      "'aami config notifications slack' 등으로 알림 채널을 활성화하세요",
+	"Run as root, or give this user write access to %s":                                               "root로 실행하거나 이 사용자에게 %s 쓰기 권한을 주세요",
+	"Run as root, or create %s writable by this user":                                                 "root로 실행하거나 이 사용자가 쓸 수 있는 %s를 만드세요",
+	"How to fix:":    "해결 방법:",
+	"Not configured": "설정되지 않음",
+	"Fix %s, or give the address with --config-server-url":                                           "%s를 고치거나 --config-server-url로 주소를 지정하세요",
+	"Check that the config server runs and that %s is reachable from this host":                      "Config Server가 실행 중이고 이 호스트에서 %s에 접근할 수 있는지 확인하세요",
+	"Fix slurm.rest in the config":                                                                   "설정의 slurm.rest를 고치세요",
+	"Controller unreachable":                                                                         "컨트롤러에 연결할 수 없음",
+	"Check that slurmctld runs (scontrol ping) and that this host can reach it":                      "slurmctld가 실행 중이고(scontrol ping) 이 호스트에서 접근할 수 있는지 확인하세요",
+	"slurmrestd unreachable, using Slurm commands":                                                   "slurmrestd에 연결할 수 없어 Slurm 명령을 사용합니다",
+	"Check that slurmrestd runs at %s and that slurm.rest.token or slurm.rest.user can authenticate": "slurmrestd가 %s에서 실행 중이고 slurm.rest.token 또는 slurm.rest.user로 인증할 수 있는지 확인하세요",
+	"%d partition(s)": "파티션 %d개",
+	"%d namespace(s) with the configured ownership and mode": "네임스페이스 %d개의 소유자와 권한이 설정과 같습니다",
+	"%d permission problem(s)":                               "권한 문제 %d개",
+	"Fix alerts.namespaces in the config":                    "설정의 alerts.namespaces를 고치세요",
+	"As root, run: %s":                                       "root로 실행하세요: %s",
+	"No nodes configured":                                    "설정된 노드가 없습니다",
+	"Could not query Prometheus":                             "Prometheus에 질의할 수 없습니다",
+	"Fix Prometheus first":                                   "먼저 Prometheus를 고치세요",
+	"node_exporter %s on all %d node(s)":                     "노드 %[2]d개 모두 node_exporter %[1]s",
+	"%d node(s) not at node_exporter %s":                     "node_exporter %[2]s가 아닌 노드 %[1]d개",
+	"%d node(s) not reporting":                               "보고하지 않는 노드 %d개",
+	"not reporting: %s":                                      "보고 안 함: %s",
+	"Run 'aami nodes install %s'":                            "'aami nodes install %s'를 실행하세요",
+
+	// aami discovery
+	"Watching nodes of %s":       "%s의 노드를 감시합니다",
+	"Nodes are up to date":       "노드가 최신 상태입니다",
+	"Nodes updated":              "노드가 갱신되었습니다",
+	"Dry run: nothing was saved": "시험 실행: 저장하지 않았습니다",
+	"Action":                     "작업",
+	"Node":                       "노드",
+	"Details":                    "세부 정보",
+	"added":                      "추가",
+	"updated":                    "갱신",
+	"removed":                    "삭제",
+	"adopted":                    "인수",
+	"conflict":                   "충돌",
+
+	// aami config export/import
+	"Exported configuration to %s": "설정을 %s(으)로 내보냈습니다",
+	"Configuration is up to date":  "설정이 최신 상태입니다",
+	"Applied %d changes":           "변경 사항 %d개를 적용했습니다",
+	"Kind":                         "종류",
+	"Path":                         "경로",
+	"create":                       "생성",
+	"update":                       "변경",
+	"delete":                       "삭제",
+
+	// aami namespaces
+	"No namespaces configured": "설정된 네임스페이스가 없습니다",
+	"Namespace":                "네임스페이스",
+	"Groups":                   "그룹 수",
+	"Alert Rules":              "알림 규칙 수",
+
+	// aami webhooks
+	"Webhook %s registered":  "웹훅 %s 등록됨",
+	"Webhook %s removed":     "웹훅 %s 삭제됨",
+	"Events":                 "이벤트",
+	"Secret":                 "시크릿",
+	"Created":                "생성 시각",
+	"No webhooks registered": "등록된 웹훅이 없습니다",
+	"Delivered %s to %s":     "%s를 %s에 전달했습니다",
+	"No dead letters":        "전달 실패 항목이 없습니다",
+	"Event":                  "이벤트",
+	"Attempts":               "시도",
+	"Failed":                 "실패 시각",
+	"Error":                  "오류",
+	"Redelivered %s":         "%s 재전달 완료",
+
+	// Alert annotations of the presets and custom rules
+	"GPU temperature critical on {{ $labels.instance }}":                                                                        "{{ $labels.instance }} GPU 온도 위험",
+	"GPU temperature warning on {{ $labels.instance }}":                                                                         "{{ $labels.instance }} GPU 온도 경고",
+	"GPU {{ $labels.gpu }} temperature is {{ $value }}°C":                                                                       "GPU {{ $labels.gpu }} 온도 {{ $value }}°C",
+	"GPU memory usage high on {{ $labels.instance }}":                                                                           "{{ $labels.instance }} GPU 메모리 사용률 높음",
+	"GPU {{ $labels.gpu }} memory usage is {{ $value }}%":                                                                       "GPU {{ $labels.gpu }} 메모리 사용률 {{ $value }}%",
+	"Possible GPU memory leak on {{ $labels.instance }}":                                                                        "{{ $labels.instance }} GPU 메모리 누수 의심",
+	"GPU {{ $labels.gpu }} has high memory usage but low utilization":                                                           "GPU {{ $labels.gpu }}의 메모리 사용량은 높지만 연산 사용률은 낮습니다",
+	"High ECC error count on {{ $labels.instance }}":                                                                            "{{ $labels.instance }} ECC 오류 다수 발생",
+	"GPU {{ $labels.gpu }} has reported over 100 ECC errors in 24h":                                                             "GPU {{ $labels.gpu }}에서 24시간 동안 ECC 오류가 100건 넘게 보고되었습니다",
+	"Xid error on {{ $labels.instance }}":                                                                                       "{{ $labels.instance }} Xid 오류",
+	"GPU {{ $labels.gpu }} reported Xid {{ $value }}":                                                                           "GPU {{ $labels.gpu }}에서 Xid {{ $value }} 보고",
+	"NVLink error on {{ $labels.instance }}":                                                                                    "{{ $labels.instance }} NVLink 오류",
+	"GPU {{ $labels.gpu }} has NVLink CRC errors":                                                                               "GPU {{ $labels.gpu }}에서 NVLink CRC 오류가 발생했습니다",
+	"Node {{ $labels.instance }} is down":                                                                                       "노드 {{ $labels.instance }} 다운",
+	"Node exporter has been unreachable for more than 1 minute":                                                                 "1분 넘게 node exporter에 연결할 수 없습니다",
+	"%s on {{ $labels.instance }}":                                                                                              "{{ $labels.instance }} %s",
+	"InfiniBand port down on {{ $labels.instance }}":                                                                            "{{ $labels.instance }} InfiniBand 포트 다운",
+	"Port {{ $labels.port }} of {{ $labels.device }} is not active with its link up":                                            "{{ $labels.device }}의 포트 {{ $labels.port }}가 링크가 올라온 활성 상태가 아닙니다",
+	"InfiniBand port degraded on {{ $labels.instance }}":                                                                        "{{ $labels.instance }} InfiniBand 포트 속도 저하",
+	"Port {{ $labels.port }} of {{ $labels.device }} runs slower than the node's fastest port; its link width or speed dropped": "{{ $labels.device }}의 포트 {{ $labels.port }}가 노드에서 가장 빠른 포트보다 느립니다. 링크 폭이나 속도가 떨어졌습니다",
+	"InfiniBand symbol errors on {{ $labels.instance }}":                                                                        "{{ $labels.instance }} InfiniBand 심볼 오류",
+	"Port {{ $labels.port }} of {{ $labels.device }} has over 10 symbol errors in 1h; check the cable and transceiver":          "{{ $labels.device }}의 포트 {{ $labels.port }}에서 1시간 동안 심볼 오류가 10건 넘게 발생했습니다. 케이블과 트랜시버를 점검하세요",
+	"InfiniBand link flapping on {{ $labels.instance }}":                                                                        "{{ $labels.instance }} InfiniBand 링크 불안정",
+	"Port {{ $labels.port }} of {{ $labels.device }} went down or retrained in the last hour":                                   "{{ $labels.device }}의 포트 {{ $labels.port }}가 최근 1시간 동안 다운되었거나 재학습했습니다",
+	"NVLink down on {{ $labels.instance }}":                                                                                     "{{ $labels.instance }} NVLink 다운",
+	"GPU {{ $labels.gpu }} has {{ $value }} active NVLinks, fewer than the other GPUs of the node":                              "GPU {{ $labels.gpu }}의 활성 NVLink가 {{ $value }}개로, 노드의 다른 GPU보다 적습니다",
+	"NVLink retraining on {{ $labels.instance }}":                                                                               "{{ $labels.instance }} NVLink 재학습",
+	"NVLink {{ $labels.link }} of GPU {{ $labels.gpu }} retrained in the last hour":                                             "GPU {{ $labels.gpu }}의 NVLink {{ $labels.link }}가 최근 1시간 동안 재학습했습니다",
+}
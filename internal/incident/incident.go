@@ -0,0 +1,336 @@
+// Package incident groups alerts, actions, and notes under a single incident.
+package incident
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDir is where incident files are stored
+const DefaultDir = "/var/lib/aami/incidents"
+
+// Incident statuses
+const (
+	StatusOpen      = "open"
+	StatusMitigated = "mitigated"
+	StatusResolved  = "resolved"
+)
+
+// Event types
+const (
+	EventOpened   = "opened"
+	EventAlert    = "alert"
+	EventAck      = "ack"
+	EventDrain    = "drain"
+	EventPlaybook = "playbook"
+	EventAction   = "action"
+	EventNote     = "note"
+	EventStatus   = "status"
+)
+
+// idPrefix prefixes incident IDs (INC-0001)
+const idPrefix = "INC-"
+
+// Incident groups related alerts and the actions taken on them.
+type Incident struct {
+	ID             string     `yaml:"id" json:"id"`
+	Title          string     `yaml:"title" json:"title"`
+	Severity       string     `yaml:"severity" json:"severity"`
+	Status         string     `yaml:"status" json:"status"`
+	CreatedAt      time.Time  `yaml:"created_at" json:"created_at"`
+	AcknowledgedAt *time.Time `yaml:"acknowledged_at,omitempty" json:"acknowledged_at,omitempty"`
+	MitigatedAt    *time.Time `yaml:"mitigated_at,omitempty" json:"mitigated_at,omitempty"`
+	ResolvedAt     *time.Time `yaml:"resolved_at,omitempty" json:"resolved_at,omitempty"`
+	Alerts         []AlertRef `yaml:"alerts,omitempty" json:"alerts,omitempty"`
+	Events         []Event    `yaml:"events" json:"events"`
+
+	// Version is incremented on every save; a save based on an older
+	// version is rejected with a *ConflictError
+	Version int `yaml:"version" json:"version"`
+}
+
+// AlertRef links an alert to an incident.
+type AlertRef struct {
+	Name     string    `yaml:"name" json:"name"`
+	Node     string    `yaml:"node,omitempty" json:"node,omitempty"`
+	StartsAt time.Time `yaml:"starts_at,omitempty" json:"starts_at,omitempty"`
+}
+
+// Event is a timeline entry.
+type Event struct {
+	Time    time.Time `yaml:"time" json:"time"`
+	Type    string    `yaml:"type" json:"type"`
+	Author  string    `yaml:"author,omitempty" json:"author,omitempty"`
+	Message string    `yaml:"message" json:"message"`
+}
+
+// AddEvent appends a timeline entry.
+func (inc *Incident) AddEvent(eventType, author, message string, at time.Time) {
+	inc.Events = append(inc.Events, Event{Time: at, Type: eventType, Author: author, Message: message})
+}
+
+// LinkAlert adds an alert to the incident unless it is already linked.
+func (inc *Incident) LinkAlert(ref AlertRef, author string, at time.Time) bool {
+	for _, a := range inc.Alerts {
+		if a.Name == ref.Name && a.Node == ref.Node {
+			return false
+		}
+	}
+	inc.Alerts = append(inc.Alerts, ref)
+
+	msg := ref.Name
+	if ref.Node != "" {
+		msg += " on " + ref.Node
+	}
+	inc.AddEvent(EventAlert, author, msg, at)
+	return true
+}
+
+// Acknowledge records the first acknowledgment.
+func (inc *Incident) Acknowledge(author string, at time.Time) error {
+	if inc.AcknowledgedAt != nil {
+		return fmt.Errorf("%s already acknowledged at %s", inc.ID, inc.AcknowledgedAt.Format(time.RFC3339))
+	}
+	inc.AcknowledgedAt = &at
+	inc.AddEvent(EventAck, author, "Acknowledged", at)
+	return nil
+}
+
+// SetStatus moves the incident to a new status. Mitigating or resolving an
+// unacknowledged incident also acknowledges it.
+func (inc *Incident) SetStatus(status, author, message string, at time.Time) error {
+	switch status {
+	case StatusOpen, StatusMitigated, StatusResolved:
+	default:
+		return fmt.Errorf("invalid status: %s (open, mitigated, resolved)", status)
+	}
+	if status == inc.Status {
+		return fmt.Errorf("%s is already %s", inc.ID, status)
+	}
+
+	if status != StatusOpen && inc.AcknowledgedAt == nil {
+		inc.AcknowledgedAt = &at
+	}
+	switch status {
+	case StatusOpen:
+		inc.MitigatedAt = nil
+		inc.ResolvedAt = nil
+	case StatusMitigated:
+		inc.MitigatedAt = &at
+		inc.ResolvedAt = nil
+	case StatusResolved:
+		if inc.MitigatedAt == nil {
+			inc.MitigatedAt = &at
+		}
+		inc.ResolvedAt = &at
+	}
+
+	text := fmt.Sprintf("%s → %s", inc.Status, status)
+	if message != "" {
+		text += ": " + message
+	}
+	inc.Status = status
+	inc.AddEvent(EventStatus, author, text, at)
+	return nil
+}
+
+// Store reads and writes incidents, one YAML file per incident.
+type Store struct {
+	dir string
+}
+
+// NewStore creates an incident store.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Create assigns the next ID to a new incident and saves it.
+func (s *Store) Create(title, severity, author string, at time.Time) (*Incident, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create incident directory: %w", err)
+	}
+
+	// The lock keeps another operator from taking the same ID
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	next := 1
+	for _, inc := range all {
+		if n, err := strconv.Atoi(strings.TrimPrefix(inc.ID, idPrefix)); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	inc := &Incident{
+		ID:        fmt.Sprintf("%s%04d", idPrefix, next),
+		Title:     title,
+		Severity:  severity,
+		Status:    StatusOpen,
+		CreatedAt: at,
+	}
+	inc.AddEvent(EventOpened, author, title, at)
+
+	return inc, s.save(inc)
+}
+
+// Get loads an incident by ID.
+func (s *Store) Get(id string) (*Incident, error) {
+	id = NormalizeID(id)
+
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("incident not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read incident: %w", err)
+	}
+
+	var inc Incident
+	if err := yaml.Unmarshal(data, &inc); err != nil {
+		return nil, fmt.Errorf("parse incident %s: %w", id, err)
+	}
+	return &inc, nil
+}
+
+// NormalizeID accepts "INC-0001", "inc-1" or "1" and returns "INC-0001".
+func NormalizeID(id string) string {
+	num := strings.TrimPrefix(strings.ToUpper(id), idPrefix)
+	if n, err := strconv.Atoi(num); err == nil {
+		return fmt.Sprintf("%s%04d", idPrefix, n)
+	}
+	return idPrefix + num
+}
+
+// ConflictError is returned when an incident was saved by someone else
+// since it was loaded.
+type ConflictError struct {
+	ID      string
+	Version int // version the change was based on
+	Current int // version on disk
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s was changed by someone else (version %d, now %d)", e.ID, e.Version, e.Current)
+}
+
+// Save writes an incident to disk and increments its version. It fails with
+// a *ConflictError if the incident on disk is no longer the version inc was
+// loaded from, so concurrent updates do not overwrite each other.
+func (s *Store) Save(inc *Incident) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("create incident directory: %w", err)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.save(inc)
+}
+
+// save checks the version of an incident and writes it; the store must be
+// locked.
+func (s *Store) save(inc *Incident) error {
+	current, err := s.version(inc.ID)
+	if err != nil {
+		return err
+	}
+	if current != inc.Version {
+		return &ConflictError{ID: inc.ID, Version: inc.Version, Current: current}
+	}
+
+	inc.Version++
+	data, err := yaml.Marshal(inc)
+	if err != nil {
+		inc.Version--
+		return fmt.Errorf("marshal incident: %w", err)
+	}
+
+	tmp := s.path(inc.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		inc.Version--
+		return fmt.Errorf("write incident: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(inc.ID)); err != nil {
+		inc.Version--
+		return fmt.Errorf("write incident: %w", err)
+	}
+	return nil
+}
+
+// lock takes an exclusive lock on the store, held until unlock is called.
+// It is a file lock, so it also keeps out other aami processes.
+func (s *Store) lock() (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("lock incidents: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock incidents: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// version returns the saved version of an incident, or 0 if it does not
+// exist yet. Incidents saved before versioning also read as 0.
+func (s *Store) version(id string) (int, error) {
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read incident: %w", err)
+	}
+	var saved struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &saved); err != nil {
+		return 0, fmt.Errorf("parse incident %s: %w", id, err)
+	}
+	return saved.Version, nil
+}
+
+// List returns all incidents, newest first.
+func (s *Store) List() ([]*Incident, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, idPrefix+"*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var incidents []*Incident
+	for _, f := range files {
+		inc, err := s.Get(strings.TrimSuffix(filepath.Base(f), ".yaml"))
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, inc)
+	}
+
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].CreatedAt.After(incidents[j].CreatedAt)
+	})
+	return incidents, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".yaml")
+}
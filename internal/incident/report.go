@@ -0,0 +1,162 @@
+package incident
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// NoGroup collects incidents that have no value for the grouping key
+const NoGroup = "(none)"
+
+// Stats summarizes acknowledgment and resolution times for a group.
+type Stats struct {
+	Group        string        `json:"group"`
+	Incidents    int           `json:"incidents"`
+	Acknowledged int           `json:"acknowledged"`
+	Resolved     int           `json:"resolved"`
+	MTTA         time.Duration `json:"-"`
+	MTTR         time.Duration `json:"-"`
+}
+
+// MarshalJSON reports MTTA and MTTR in seconds.
+func (s Stats) MarshalJSON() ([]byte, error) {
+	type plain Stats
+	return json.Marshal(struct {
+		plain
+		MTTASeconds float64 `json:"mtta_seconds"`
+		MTTRSeconds float64 `json:"mttr_seconds"`
+	}{plain(s), s.MTTA.Seconds(), s.MTTR.Seconds()})
+}
+
+// GroupFunc returns the groups an incident counts towards.
+type GroupFunc func(inc *Incident) []string
+
+// ByRule groups incidents by linked alert name.
+func ByRule(inc *Incident) []string {
+	var groups []string
+	for _, a := range inc.Alerts {
+		groups = append(groups, a.Name)
+	}
+	return groups
+}
+
+// ByNode groups incidents by linked alert node.
+func ByNode(inc *Incident) []string {
+	var groups []string
+	for _, a := range inc.Alerts {
+		if a.Node != "" {
+			groups = append(groups, a.Node)
+		}
+	}
+	return groups
+}
+
+// BySeverity groups incidents by severity.
+func BySeverity(inc *Incident) []string {
+	return []string{inc.Severity}
+}
+
+// ByNodeLabel groups incidents by a label of the nodes in linked alerts.
+func ByNodeLabel(key string, nodeLabels map[string]map[string]string) GroupFunc {
+	return func(inc *Incident) []string {
+		var groups []string
+		for _, a := range inc.Alerts {
+			if v := nodeLabels[a.Node][key]; v != "" {
+				groups = append(groups, v)
+			}
+		}
+		return groups
+	}
+}
+
+// Reliability computes MTTA and MTTR per group for incidents opened since
+// the given time. An incident counts once per distinct group.
+func Reliability(incidents []*Incident, since time.Time, groupBy GroupFunc) []Stats {
+	type acc struct {
+		stats        Stats
+		ackTotal     time.Duration
+		resolveTotal time.Duration
+	}
+	byGroup := map[string]*acc{}
+
+	for _, inc := range incidents {
+		if inc.CreatedAt.Before(since) {
+			continue
+		}
+
+		seen := map[string]bool{}
+		groups := groupBy(inc)
+		if len(groups) == 0 {
+			groups = []string{NoGroup}
+		}
+		for _, g := range groups {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+
+			a, ok := byGroup[g]
+			if !ok {
+				a = &acc{stats: Stats{Group: g}}
+				byGroup[g] = a
+			}
+			a.stats.Incidents++
+			if inc.AcknowledgedAt != nil {
+				a.stats.Acknowledged++
+				a.ackTotal += inc.AcknowledgedAt.Sub(inc.CreatedAt)
+			}
+			if inc.ResolvedAt != nil {
+				a.stats.Resolved++
+				a.resolveTotal += inc.ResolvedAt.Sub(inc.CreatedAt)
+			}
+		}
+	}
+
+	stats := make([]Stats, 0, len(byGroup))
+	for _, a := range byGroup {
+		if a.stats.Acknowledged > 0 {
+			a.stats.MTTA = a.ackTotal / time.Duration(a.stats.Acknowledged)
+		}
+		if a.stats.Resolved > 0 {
+			a.stats.MTTR = a.resolveTotal / time.Duration(a.stats.Resolved)
+		}
+		stats = append(stats, a.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Group < stats[j].Group
+	})
+	return stats
+}
+
+// WriteMetrics writes the stats in Prometheus text format, for the node
+// exporter textfile collector.
+func WriteMetrics(w io.Writer, groupBy string, stats []Stats) error {
+	metrics := []struct {
+		name, help string
+		value      func(s Stats) (float64, bool)
+	}{
+		{"aami_incidents", "Incidents opened in the report window",
+			func(s Stats) (float64, bool) { return float64(s.Incidents), true }},
+		{"aami_incident_mtta_seconds", "Mean time to acknowledge incidents",
+			func(s Stats) (float64, bool) { return s.MTTA.Seconds(), s.Acknowledged > 0 }},
+		{"aami_incident_mttr_seconds", "Mean time to resolve incidents",
+			func(s Stats) (float64, bool) { return s.MTTR.Seconds(), s.Resolved > 0 }},
+	}
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name); err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if v, ok := m.value(s); ok {
+				if _, err := fmt.Fprintf(w, "%s{group_by=%q,group=%q} %g\n", m.name, groupBy, s.Group, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
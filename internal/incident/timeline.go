@@ -0,0 +1,63 @@
+package incident
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Timeline returns the incident's events in chronological order.
+func (inc *Incident) Timeline() []Event {
+	events := make([]Event, len(inc.Events))
+	copy(events, inc.Events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// Markdown renders a postmortem draft with the incident timeline.
+func (inc *Incident) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s: %s\n\n", inc.ID, inc.Title)
+	fmt.Fprintf(&b, "| Field | Value |\n|-------|-------|\n")
+	fmt.Fprintf(&b, "| Severity | %s |\n", inc.Severity)
+	fmt.Fprintf(&b, "| Status | %s |\n", inc.Status)
+	fmt.Fprintf(&b, "| Opened | %s |\n", inc.CreatedAt.Format(time.RFC3339))
+	if inc.AcknowledgedAt != nil {
+		fmt.Fprintf(&b, "| Time to acknowledge | %s |\n", inc.AcknowledgedAt.Sub(inc.CreatedAt).Round(time.Second))
+	}
+	if inc.MitigatedAt != nil {
+		fmt.Fprintf(&b, "| Time to mitigate | %s |\n", inc.MitigatedAt.Sub(inc.CreatedAt).Round(time.Second))
+	}
+	if inc.ResolvedAt != nil {
+		fmt.Fprintf(&b, "| Time to resolve | %s |\n", inc.ResolvedAt.Sub(inc.CreatedAt).Round(time.Second))
+	}
+
+	if len(inc.Alerts) > 0 {
+		b.WriteString("\n## Alerts\n\n")
+		for _, a := range inc.Alerts {
+			line := "- " + a.Name
+			if a.Node != "" {
+				line += " on " + a.Node
+			}
+			if !a.StartsAt.IsZero() {
+				line += fmt.Sprintf(" (since %s)", a.StartsAt.Format(time.RFC3339))
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	b.WriteString("\n## Timeline\n\n")
+	b.WriteString("| Time (UTC) | Type | Author | Event |\n|------------|------|--------|-------|\n")
+	for _, e := range inc.Timeline() {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n",
+			e.Time.UTC().Format("2006-01-02 15:04:05"), e.Type, e.Author,
+			strings.ReplaceAll(e.Message, "|", "\\|"))
+	}
+
+	b.WriteString("\n## Root Cause\n\n_TBD_\n\n## Follow-up Actions\n\n- [ ] _TBD_\n")
+	return b.String()
+}
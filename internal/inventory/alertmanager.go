@@ -0,0 +1,54 @@
+package inventory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// FormatAlertmanager is the Alertmanager cluster peer list.
+const FormatAlertmanager = "alertmanager"
+
+// AlertmanagerPeers lists the Alertmanager instances that form one cluster.
+type AlertmanagerPeers struct {
+	Cluster string             `json:"cluster,omitempty"`
+	Peers   []AlertmanagerPeer `json:"peers"`
+}
+
+// AlertmanagerPeer is an Alertmanager instance of the cluster.
+type AlertmanagerPeer struct {
+	Node    string `json:"node,omitempty"`
+	Address string `json:"address"` // gossip address for --cluster.peer
+	URL     string `json:"url"`     // web and API URL
+}
+
+// BuildAlertmanagerPeers builds the peer list of the Alertmanager cluster.
+func BuildAlertmanagerPeers(cfg *config.Config) *AlertmanagerPeers {
+	out := &AlertmanagerPeers{Cluster: cfg.Cluster.Name}
+	for _, h := range componentHosts(cfg, RoleAlertmanager) {
+		out.Peers = append(out.Peers, AlertmanagerPeer{
+			Node:    h.Node,
+			Address: fmt.Sprintf("%s:%d", h.Address, DefaultAlertmanagerClusterPort),
+			URL:     fmt.Sprintf("http://%s:%d", h.Address, DefaultAlertmanagerPort),
+		})
+	}
+	return out
+}
+
+// Flags returns the --cluster.peer flags the Alertmanager on node, given by
+// name or IP, joins the cluster with. A node does not list itself; a lone
+// instance gets none.
+func (p *AlertmanagerPeers) Flags(node string) string {
+	var flags []string
+	for _, peer := range p.Peers {
+		if node != "" && (peer.Node == node || strings.HasPrefix(peer.Address, node+":")) {
+			continue
+		}
+		flags = append(flags, "--cluster.peer="+peer.Address)
+	}
+	if len(p.Peers) < 2 {
+		return ""
+	}
+	return strings.Join(flags, " ")
+}
@@ -0,0 +1,155 @@
+// Package inventory exports AAMI nodes to configuration management tools.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// FormatAnsible is the Ansible dynamic inventory JSON format.
+const FormatAnsible = "ansible"
+
+// AllGroup is the implicit group containing every host.
+const AllGroup = "all"
+
+var groupNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// AnsibleGroup is a group entry in an Ansible dynamic inventory.
+type AnsibleGroup struct {
+	Hosts    []string               `json:"hosts,omitempty"`
+	Children []string               `json:"children,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+}
+
+// AnsibleMeta holds per-host variables so Ansible can skip --host calls.
+type AnsibleMeta struct {
+	HostVars map[string]map[string]interface{} `json:"hostvars"`
+}
+
+// AnsibleInventory is the --list output of an Ansible dynamic inventory script.
+type AnsibleInventory struct {
+	Groups map[string]*AnsibleGroup
+	Meta   AnsibleMeta
+}
+
+// MarshalJSON flattens groups next to _meta as Ansible expects.
+func (inv *AnsibleInventory) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(inv.Groups)+1)
+	for name, group := range inv.Groups {
+		out[name] = group
+	}
+	out["_meta"] = inv.Meta
+	return json.Marshal(out)
+}
+
+// reservedGroups are names Ansible gives its implicit groups, or uses for
+// the _meta key of the inventory
+var reservedGroups = map[string]bool{AllGroup: true, "ungrouped": true, "_meta": true}
+
+// BuildAnsible builds an Ansible dynamic inventory from the configured nodes.
+// Every node label key=value becomes a group named "<key>_<value>", and all
+// hosts are also placed in a group named after the cluster. A cluster group
+// whose name is reserved by Ansible ("all", "ungrouped") or taken by a label
+// group is prefixed with "cluster_". Labels whose group names collide once
+// sanitized share a group, with each host listed once.
+func BuildAnsible(cfg *config.Config) *AnsibleInventory {
+	inv := &AnsibleInventory{
+		Groups: map[string]*AnsibleGroup{},
+		Meta:   AnsibleMeta{HostVars: map[string]map[string]interface{}{}},
+	}
+
+	members := map[string]map[string]bool{AllGroup: {}}
+	add := func(group, host string) {
+		if members[group] == nil {
+			members[group] = map[string]bool{}
+		}
+		members[group][host] = true
+	}
+
+	for _, node := range cfg.Nodes {
+		add(AllGroup, node.Name)
+		inv.Meta.HostVars[node.Name] = AnsibleHostVars(cfg, node)
+		for k, v := range node.Labels {
+			name := GroupName(k + "_" + v)
+			if reservedGroups[name] {
+				name = "label_" + name
+			}
+			add(name, node.Name)
+		}
+	}
+
+	if cfg.Cluster.Name != "" {
+		clusterGroup := GroupName(cfg.Cluster.Name)
+		for reservedGroups[clusterGroup] || members[clusterGroup] != nil {
+			clusterGroup = "cluster_" + clusterGroup
+		}
+		members[clusterGroup] = map[string]bool{}
+		for _, node := range cfg.Nodes {
+			add(clusterGroup, node.Name)
+		}
+	}
+
+	all := &AnsibleGroup{}
+	for name, hosts := range members {
+		group := all
+		if name != AllGroup {
+			group = &AnsibleGroup{}
+			all.Children = append(all.Children, name)
+		}
+		for host := range hosts {
+			group.Hosts = append(group.Hosts, host)
+		}
+		sort.Strings(group.Hosts)
+		inv.Groups[name] = group
+	}
+	sort.Strings(all.Children)
+
+	return inv
+}
+
+// AnsibleHostVars returns the variables Ansible needs to reach a node.
+func AnsibleHostVars(cfg *config.Config, node config.NodeConfig) map[string]interface{} {
+	vars := map[string]interface{}{
+		"ansible_host": node.IP,
+	}
+	if node.SSHUser != "" {
+		vars["ansible_user"] = node.SSHUser
+	}
+	if node.SSHPort != 0 {
+		vars["ansible_port"] = node.SSHPort
+	}
+	if node.SSHKey != "" {
+		vars["ansible_ssh_private_key_file"] = node.SSHKey
+	}
+	if cfg.Cluster.Name != "" {
+		vars["aami_cluster"] = cfg.Cluster.Name
+	}
+	if len(node.Labels) > 0 {
+		vars["aami_labels"] = node.Labels
+	}
+	return vars
+}
+
+// HostVars returns the variables for a single host, as used by --host.
+func HostVars(cfg *config.Config, name string) (map[string]interface{}, error) {
+	for _, node := range cfg.Nodes {
+		if node.Name == name || node.IP == name {
+			return AnsibleHostVars(cfg, node), nil
+		}
+	}
+	return nil, fmt.Errorf("host not found: %s", name)
+}
+
+// GroupName converts an arbitrary string into a valid Ansible group name.
+func GroupName(s string) string {
+	name := groupNameSanitizer.ReplaceAllString(strings.ToLower(s), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
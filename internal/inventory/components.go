@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// RoleLabel is the node label listing the monitoring components a node runs,
+// e.g. "role: alertmanager" or "role: prometheus,alertmanager". Components
+// no node lists are assumed to run on the local host, next to aami itself.
+const RoleLabel = "role"
+
+// Component roles
+const (
+	RolePrometheus   = "prometheus"
+	RoleAlertmanager = "alertmanager"
+)
+
+// Default component ports
+const (
+	DefaultAlertmanagerPort        = 9093
+	DefaultAlertmanagerClusterPort = 9094
+)
+
+// localHost stands in for the nodes of a component no node lists
+const localHost = "localhost"
+
+// componentHost is a host running a monitoring component
+type componentHost struct {
+	Node    string // node name, empty for the local host
+	Address string
+}
+
+// componentHosts returns the hosts running a component, in node order
+func componentHosts(cfg *config.Config, role string) []componentHost {
+	var hosts []componentHost
+	for _, node := range cfg.Nodes {
+		if HasRole(node, role) {
+			hosts = append(hosts, componentHost{Node: node.Name, Address: node.IP})
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = append(hosts, componentHost{Address: localHost})
+	}
+	return hosts
+}
+
+// HasRole reports whether a node's role label lists role.
+func HasRole(node config.NodeConfig, role string) bool {
+	for _, r := range strings.Split(node.Labels[RoleLabel], ",") {
+		if strings.EqualFold(strings.TrimSpace(r), role) {
+			return true
+		}
+	}
+	return false
+}
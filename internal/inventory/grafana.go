@@ -0,0 +1,89 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// FormatGrafana is Grafana's datasource provisioning file format.
+const FormatGrafana = "grafana"
+
+// GrafanaProvisioning is a Grafana datasource provisioning file, as read from
+// /etc/grafana/provisioning/datasources.
+type GrafanaProvisioning struct {
+	APIVersion  int                 `yaml:"apiVersion" json:"apiVersion"`
+	Datasources []GrafanaDatasource `yaml:"datasources" json:"datasources"`
+}
+
+// GrafanaDatasource is a datasource in a provisioning file.
+type GrafanaDatasource struct {
+	Name      string                 `yaml:"name" json:"name"`
+	Type      string                 `yaml:"type" json:"type"`
+	UID       string                 `yaml:"uid" json:"uid"`
+	Access    string                 `yaml:"access" json:"access"`
+	URL       string                 `yaml:"url" json:"url"`
+	IsDefault bool                   `yaml:"isDefault" json:"isDefault"`
+	Editable  bool                   `yaml:"editable" json:"editable"`
+	JSONData  map[string]interface{} `yaml:"jsonData,omitempty" json:"jsonData,omitempty"`
+}
+
+// BuildGrafana builds the datasources for the Prometheus and Alertmanager
+// instances of the inventory. The first Prometheus is the default
+// datasource; the Alertmanager datasource points at the first Alertmanager,
+// as cluster peers share their alerts and silences.
+func BuildGrafana(cfg *config.Config) *GrafanaProvisioning {
+	prov := &GrafanaProvisioning{APIVersion: 1}
+
+	prometheus := componentHosts(cfg, RolePrometheus)
+	for i, h := range prometheus {
+		name, uid := "Prometheus", "aami-prometheus"
+		if len(prometheus) > 1 {
+			name = fmt.Sprintf("Prometheus (%s)", h.Node)
+			uid = "aami-prometheus-" + GroupName(h.Node)
+		}
+		prov.Datasources = append(prov.Datasources, GrafanaDatasource{
+			Name:      name,
+			Type:      "prometheus",
+			UID:       uid,
+			Access:    "proxy",
+			URL:       fmt.Sprintf("http://%s:%d", h.Address, cfg.Prometheus.Port),
+			IsDefault: i == 0,
+			JSONData: map[string]interface{}{
+				"timeInterval": "15s",
+				"httpMethod":   "POST",
+			},
+		})
+	}
+
+	am := componentHosts(cfg, RoleAlertmanager)[0]
+	prov.Datasources = append(prov.Datasources, GrafanaDatasource{
+		Name:   "Alertmanager",
+		Type:   "alertmanager",
+		UID:    "aami-alertmanager",
+		Access: "proxy",
+		URL:    fmt.Sprintf("http://%s:%d", am.Address, DefaultAlertmanagerPort),
+		JSONData: map[string]interface{}{
+			"implementation":             "prometheus",
+			"handleGrafanaManagedAlerts": false,
+		},
+	})
+
+	return prov
+}
+
+// RenderGrafana renders datasources as a provisioning file
+func RenderGrafana(prov *GrafanaProvisioning) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(prov); err != nil {
+		return nil, fmt.Errorf("marshal datasources: %w", err)
+	}
+	return buf.Bytes(), nil
+}
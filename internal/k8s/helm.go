@@ -0,0 +1,159 @@
+// Package k8s renders AAMI configuration for Kubernetes deployments.
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/notify"
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+// alertmanagerTemplateDir is where kube-prometheus-stack mounts
+// alertmanager.templateFiles
+const alertmanagerTemplateDir = "/etc/alertmanager/config"
+
+// invalidNameChars are replaced when turning rule file paths into resource names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// HelmValues are kube-prometheus-stack values derived from AAMI state.
+type HelmValues struct {
+	Prometheus                   map[string]interface{} `yaml:"prometheus"`
+	Alertmanager                 map[string]interface{} `yaml:"alertmanager"`
+	Grafana                      map[string]interface{} `yaml:"grafana"`
+	AdditionalPrometheusRulesMap map[string]interface{} `yaml:"additionalPrometheusRulesMap"`
+}
+
+// RenderHelmValues builds kube-prometheus-stack values from the AAMI config
+// and the generated rule files:
+//
+//   - every rule file becomes a PrometheusRule (additionalPrometheusRulesMap)
+//   - node_exporter and DCGM targets become additional scrape configs
+//   - the Alertmanager config and templates are rendered as for
+//     `aami notifications generate`
+//   - retention and the Grafana admin password are carried over
+func RenderHelmValues(cfg *config.Config) ([]byte, error) {
+	rules, err := ruleResources(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	amConfig, templates, err := notify.BuildAlertmanagerConfig(cfg,
+		filepath.Join(alertmanagerTemplateDir, notify.TemplateFile))
+	if err != nil {
+		return nil, fmt.Errorf("render alertmanager config: %w", err)
+	}
+	var amValues map[string]interface{}
+	if err := yaml.Unmarshal(amConfig, &amValues); err != nil {
+		return nil, fmt.Errorf("parse alertmanager config: %w", err)
+	}
+
+	clusterLabels := map[string]string{}
+	if cfg.Cluster.Name != "" {
+		clusterLabels["cluster"] = cfg.Cluster.Name
+	}
+
+	values := HelmValues{
+		Prometheus: map[string]interface{}{
+			"prometheusSpec": map[string]interface{}{
+				"retention":      cfg.Prometheus.Retention,
+				"externalLabels": clusterLabels,
+				"additionalScrapeConfigs": []interface{}{
+					scrapeConfig("node", prometheus.NodeTargets(cfg.Nodes)),
+					scrapeConfig("dcgm", prometheus.DCGMTargets(cfg.Nodes)),
+				},
+				// Pick up the AAMI PrometheusRules without matching Helm release labels
+				"ruleSelectorNilUsesHelmValues": false,
+			},
+		},
+		Alertmanager: map[string]interface{}{
+			"config": amValues,
+			"templateFiles": map[string]string{
+				notify.TemplateFile: string(templates),
+			},
+		},
+		Grafana: map[string]interface{}{
+			"adminPassword": cfg.Grafana.AdminPassword,
+		},
+		AdditionalPrometheusRulesMap: rules,
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n")
+	buf.WriteString("# kube-prometheus-stack values; contains credentials, store as a secret\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(values); err != nil {
+		return nil, fmt.Errorf("marshal helm values: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ruleResources loads the rule files this Prometheus instance would load,
+// keyed by a resource name derived from their path
+func ruleResources(cfg *config.Config) (map[string]interface{}, error) {
+	var paths []string
+	for _, glob := range prometheus.RuleFileGlobs(cfg) {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("list rule files: %w", err)
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	rules := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read rule file: %w", err)
+		}
+		var content map[string]interface{}
+		if err := yaml.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("parse rule file %s: %w", path, err)
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		name := ruleResourceName(path)
+		if _, ok := rules[name]; ok {
+			return nil, fmt.Errorf("rule files map to the same resource name %q: %s", name, path)
+		}
+		rules[name] = content
+	}
+	return rules, nil
+}
+
+// ruleResourceName turns /etc/aami/rules/<ns>/<file>.yaml into aami-<ns>-<file>
+func ruleResourceName(path string) string {
+	rel, err := filepath.Rel(prometheus.RulesDir, path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel))
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(rel), "-")
+	return "aami-" + strings.Trim(name, "-")
+}
+
+func scrapeConfig(job string, targets []prometheus.Target) map[string]interface{} {
+	staticConfigs := make([]map[string]interface{}, 0, len(targets))
+	for _, t := range targets {
+		staticConfigs = append(staticConfigs, map[string]interface{}{
+			"targets": t.Targets,
+			"labels":  t.Labels,
+		})
+	}
+	return map[string]interface{}{
+		"job_name":       job,
+		"static_configs": staticConfigs,
+	}
+}
@@ -0,0 +1,279 @@
+// Package maintenance keeps maintenance windows: recurring (cron) or
+// one-off periods in which a group or some targets are worked on. While a
+// window is open, Sync keeps an Alertmanager silence for its targets, so
+// planned work pages no one, and ends it when the window closes or is
+// deleted.
+package maintenance
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultStorePath is where maintenance windows are kept
+const DefaultStorePath = "/var/lib/aami/maintenance-windows.yaml"
+
+// maxDuration bounds how long a window stays open
+const maxDuration = 31 * 24 * time.Hour
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Window is a maintenance window. It applies to a config server group or
+// to targets, and opens on a cron schedule or once at Start.
+type Window struct {
+	Name      string     `yaml:"name" json:"name"`
+	Group     string     `yaml:"group,omitempty" json:"group,omitempty"`       // config server group, ID or name
+	Targets   []string   `yaml:"targets,omitempty" json:"targets,omitempty"`   // node names
+	Schedule  string     `yaml:"schedule,omitempty" json:"schedule,omitempty"` // cron, e.g. "0 2 * * sat"
+	Start     *time.Time `yaml:"start,omitempty" json:"start,omitempty"`       // one-off window
+	Duration  string     `yaml:"duration" json:"duration"`                     // e.g. 4h, 1d
+	Timezone  string     `yaml:"timezone,omitempty" json:"timezone,omitempty"` // of the schedule, default: local
+	Reason    string     `yaml:"reason" json:"reason"`
+	CreatedBy string     `yaml:"created_by" json:"created_by"`
+	CreatedAt time.Time  `yaml:"created_at" json:"created_at"`
+}
+
+// Validate checks a window before it is stored
+func (w Window) Validate() error {
+	if !namePattern.MatchString(w.Name) {
+		return fmt.Errorf("invalid window name: %q", w.Name)
+	}
+	if (w.Group == "") == (len(w.Targets) == 0) {
+		return fmt.Errorf("window %s needs either a group or targets", w.Name)
+	}
+	if (w.Schedule == "") == (w.Start == nil) {
+		return fmt.Errorf("window %s needs either a schedule or a start", w.Name)
+	}
+	if _, err := w.duration(); err != nil {
+		return err
+	}
+	if _, err := w.location(); err != nil {
+		return err
+	}
+	if w.Schedule != "" {
+		if _, err := ParseSchedule(w.Schedule); err != nil {
+			return err
+		}
+	}
+	if strings.TrimSpace(w.Reason) == "" {
+		return fmt.Errorf("window %s needs a reason", w.Name)
+	}
+	return nil
+}
+
+// Open returns the period of the window open at now, if any
+func (w Window) Open(now time.Time) (start, end time.Time, open bool, err error) {
+	d, err := w.duration()
+	if err != nil {
+		return time.Time{}, time.Time{}, false, err
+	}
+	if w.Start != nil {
+		start = *w.Start
+	} else {
+		s, loc, err := w.schedule()
+		if err != nil {
+			return time.Time{}, time.Time{}, false, err
+		}
+		var ok bool
+		if start, ok = s.Last(now.In(loc), d); !ok {
+			return time.Time{}, time.Time{}, false, nil
+		}
+	}
+	end = start.Add(d)
+	return start, end, !now.Before(start) && now.Before(end), nil
+}
+
+// Next returns the next period of the window starting after now; a one-off
+// window has none once it started
+func (w Window) Next(now time.Time) (start, end time.Time, ok bool) {
+	d, err := w.duration()
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	if w.Start != nil {
+		return *w.Start, w.Start.Add(d), w.Start.After(now)
+	}
+	s, loc, err := w.schedule()
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	if start, ok = s.Next(now.In(loc)); !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(d), true
+}
+
+// Expired reports whether a one-off window is over for good
+func (w Window) Expired(now time.Time) bool {
+	if w.Start == nil {
+		return false
+	}
+	d, err := w.duration()
+	return err == nil && !now.Before(w.Start.Add(d))
+}
+
+func (w Window) duration() (time.Duration, error) {
+	d, err := parseDuration(w.Duration)
+	if err != nil {
+		return 0, fmt.Errorf("window %s: %w", w.Name, err)
+	}
+	if d > maxDuration {
+		return 0, fmt.Errorf("window %s: duration over %s", w.Name, maxDuration)
+	}
+	return d, nil
+}
+
+func (w Window) location() (*time.Location, error) {
+	if w.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("window %s: unknown timezone %q", w.Name, w.Timezone)
+	}
+	return loc, nil
+}
+
+func (w Window) schedule() (*Schedule, *time.Location, error) {
+	s, err := ParseSchedule(w.Schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	loc, err := w.location()
+	return s, loc, err
+}
+
+// parseDuration parses a Go duration or a number of days such as 1d
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration: %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return d, nil
+}
+
+// storeFile is the on-disk format of the store
+type storeFile struct {
+	Windows []Window `yaml:"windows"`
+}
+
+// Store keeps maintenance windows in a YAML file.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a store of windows kept at path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// List returns the windows sorted by name.
+func (s *Store) List() ([]Window, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get returns a window by name.
+func (s *Store) Get(name string) (Window, bool, error) {
+	windows, err := s.List()
+	if err != nil {
+		return Window{}, false, err
+	}
+	for _, w := range windows {
+		if w.Name == name {
+			return w, true, nil
+		}
+	}
+	return Window{}, false, nil
+}
+
+// Create validates and adds a window.
+func (s *Store) Create(w Window) (Window, error) {
+	if err := w.Validate(); err != nil {
+		return Window{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows, err := s.load()
+	if err != nil {
+		return Window{}, err
+	}
+	for _, existing := range windows {
+		if existing.Name == w.Name {
+			return Window{}, fmt.Errorf("window already exists: %s", w.Name)
+		}
+	}
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	return w, s.save(append(windows, w))
+}
+
+// Delete removes a window.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	windows, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, w := range windows {
+		if w.Name == name {
+			return s.save(append(windows[:i], windows[i+1:]...))
+		}
+	}
+	return fmt.Errorf("window not found: %s", name)
+}
+
+func (s *Store) load() ([]Window, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read maintenance windows: %w", err)
+	}
+	var f storeFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse maintenance windows: %w", err)
+	}
+	sort.Slice(f.Windows, func(i, j int) bool { return f.Windows[i].Name < f.Windows[j].Name })
+	return f.Windows, nil
+}
+
+func (s *Store) save(windows []Window) error {
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Name < windows[j].Name })
+	data, err := yaml.Marshal(storeFile{Windows: windows})
+	if err != nil {
+		return fmt.Errorf("marshal maintenance windows: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write maintenance windows: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,137 @@
+package maintenance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fregataa/aami/internal/silence"
+)
+
+// DefaultCreator is the creator of silences of windows without one
+const DefaultCreator = "aami-maintenance"
+
+// Resolver returns the nodes of a config server group
+type Resolver func(group string) ([]string, error)
+
+// Action is what Sync did for a window.
+type Action struct {
+	Window  string    `json:"window"`
+	Action  string    `json:"action"` // silenced, unsilenced, failed
+	Silence string    `json:"silence,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Sync keeps one silence for each open window, created for the rest of
+// the window, and expires the silences of windows that closed or no longer
+// exist. It is meant to run every minute or so; windows already silenced
+// are left alone.
+func Sync(windows []Window, m *silence.Manager, resolve Resolver, now time.Time) ([]Action, error) {
+	silences, err := m.List(false)
+	if err != nil {
+		return nil, err
+	}
+	// Silences of each window, still pending or active
+	byWindow := map[string][]silence.Silence{}
+	for _, s := range silences {
+		if s.Metadata != nil && s.Metadata.Window != "" {
+			byWindow[s.Metadata.Window] = append(byWindow[s.Metadata.Window], s)
+		}
+	}
+
+	var actions []Action
+	open := map[string]bool{}
+	for _, w := range windows {
+		_, end, isOpen, err := w.Open(now)
+		if err != nil {
+			actions = append(actions, Action{Window: w.Name, Action: "failed", Error: err.Error()})
+			continue
+		}
+		if !isOpen {
+			continue
+		}
+		open[w.Name] = true
+		if covered(byWindow[w.Name], end) {
+			continue
+		}
+
+		s, err := silenceWindow(w, m, resolve, end.Sub(now))
+		if err != nil {
+			actions = append(actions, Action{Window: w.Name, Action: "failed", Error: err.Error()})
+			continue
+		}
+		actions = append(actions, Action{Window: w.Name, Action: "silenced", Silence: s.ID, Until: s.EndsAt})
+	}
+
+	for name, list := range byWindow {
+		if open[name] {
+			continue
+		}
+		for _, s := range list {
+			a := Action{Window: name, Action: "unsilenced", Silence: s.ID}
+			if err := m.Expire(s.ID); err != nil {
+				a.Action, a.Error = "failed", err.Error()
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions, nil
+}
+
+// Close expires the silences of a window, when it is deleted
+func Close(m *silence.Manager, name string) (int, error) {
+	silences, err := m.List(false)
+	if err != nil {
+		return 0, err
+	}
+	closed := 0
+	for _, s := range silences {
+		if s.Metadata == nil || s.Metadata.Window != name {
+			continue
+		}
+		if err := m.Expire(s.ID); err != nil {
+			return closed, err
+		}
+		closed++
+	}
+	return closed, nil
+}
+
+// covered reports whether a silence lasts until about end; one a minute
+// short is from the same period, created a little later
+func covered(silences []silence.Silence, end time.Time) bool {
+	for _, s := range silences {
+		if !s.EndsAt.Before(end.Add(-time.Minute)) {
+			return true
+		}
+	}
+	return false
+}
+
+// silenceWindow silences the targets of a window for d
+func silenceWindow(w Window, m *silence.Manager, resolve Resolver, d time.Duration) (*silence.Silence, error) {
+	nodes := w.Targets
+	if w.Group != "" {
+		if resolve == nil {
+			return nil, fmt.Errorf("group %s cannot be resolved without a config server", w.Group)
+		}
+		var err error
+		if nodes, err = resolve(w.Group); err != nil {
+			return nil, err
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("group %s has no targets", w.Group)
+		}
+	}
+	creator := w.CreatedBy
+	if creator == "" {
+		creator = DefaultCreator
+	}
+	return m.Create(silence.Request{
+		Nodes:     nodes,
+		Window:    w.Name,
+		Duration:  d,
+		Reason:    fmt.Sprintf("Maintenance window %s: %s", w.Name, w.Reason),
+		CreatedBy: creator,
+	})
+}
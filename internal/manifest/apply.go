@@ -0,0 +1,212 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+)
+
+// DefaultStatePath records the manifests of the last apply
+const DefaultStatePath = "/var/lib/aami/last-applied.yaml"
+
+// State holds the spec last applied of every resource, by key. Only
+// resources recorded here are pruned.
+type State struct {
+	Applied map[string]map[string]interface{} `yaml:"applied"`
+}
+
+// LoadState reads the state; a missing file is an empty state.
+func LoadState(path string) (*State, error) {
+	s := &State{Applied: map[string]map[string]interface{}{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if s.Applied == nil {
+		s.Applied = map[string]map[string]interface{}{}
+	}
+	return s, nil
+}
+
+// Save writes the state.
+func (s *State) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is what applying changed for one resource.
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Diff   string `json:"diff,omitempty"` // line diff of an update
+}
+
+// Apply applies resources to cfg and records them in state, both in
+// place; the caller saves them, or drops them for a dry run. With prune,
+// the resources of the last apply that are no longer given are deleted
+// from cfg. Resources that are already as declared give no change.
+func Apply(cfg *config.Config, state *State, resources []Resource, prune bool) ([]Change, error) {
+	if err := Sort(resources); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	given := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		given[r.Key()] = true
+		last := state.Applied[r.Key()]
+
+		var c Change
+		var err error
+		switch r.Kind {
+		case KindNode:
+			c, err = applyObject(&cfg.Nodes, nodeName, r, last)
+		case KindAlertRule:
+			c, err = applyObject(&cfg.Alerts.Custom, ruleName, r, last)
+		case KindNamespace:
+			c, err = applyObject(&cfg.Alerts.Namespaces, namespaceName, r, last)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.Action != "" {
+			changes = append(changes, c)
+		}
+
+		spec := r.Spec
+		if spec == nil {
+			spec = map[string]interface{}{}
+		}
+		state.Applied[r.Key()] = spec
+	}
+
+	if err := check(cfg, given); err != nil {
+		return nil, err
+	}
+	if !prune {
+		return changes, nil
+	}
+
+	var stale []string
+	for key := range state.Applied {
+		if !given[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		kind, name, _ := strings.Cut(key, "/")
+		var deleted bool
+		switch kind {
+		case KindNode:
+			deleted = deleteObject(&cfg.Nodes, nodeName, name)
+		case KindAlertRule:
+			deleted = deleteObject(&cfg.Alerts.Custom, ruleName, name)
+		case KindNamespace:
+			deleted = deleteObject(&cfg.Alerts.Namespaces, namespaceName, name)
+		}
+		if deleted {
+			changes = append(changes, Change{Action: ActionDelete, Kind: kind, Name: name})
+		}
+		delete(state.Applied, key)
+	}
+	return changes, nil
+}
+
+func nodeName(n *config.NodeConfig) *string          { return &n.Name }
+func ruleName(r *config.CustomAlertRule) *string     { return &r.Name }
+func namespaceName(ns *config.RuleNamespace) *string { return &ns.Name }
+
+// applyObject merges a manifest into the object of the same name in list,
+// or appends a new one
+func applyObject[T any](list *[]T, nameOf func(*T) *string, r Resource, last map[string]interface{}) (Change, error) {
+	i := indexOf(*list, nameOf, r.Metadata.Name)
+	var obj T
+	var before []byte
+	if i >= 0 {
+		obj = (*list)[i]
+		before, _ = yaml.Marshal(obj)
+	}
+
+	patch, err := yaml.Marshal(ThreeWayPatch(last, r.Spec))
+	if err != nil {
+		return Change{}, err
+	}
+	if err := config.Patch(&obj, patch); err != nil {
+		return Change{}, fmt.Errorf("%s: %w", r.Key(), err)
+	}
+	*nameOf(&obj) = r.Metadata.Name
+
+	if i < 0 {
+		*list = append(*list, obj)
+		return Change{Action: ActionCreate, Kind: r.Kind, Name: r.Metadata.Name}, nil
+	}
+	after, _ := yaml.Marshal(obj)
+	if bytes.Equal(before, after) {
+		return Change{}, nil
+	}
+	(*list)[i] = obj
+	return Change{Action: ActionUpdate, Kind: r.Kind, Name: r.Metadata.Name, Diff: drift.Diff(string(before), string(after))}, nil
+}
+
+func deleteObject[T any](list *[]T, nameOf func(*T) *string, name string) bool {
+	i := indexOf(*list, nameOf, name)
+	if i < 0 {
+		return false
+	}
+	*list = append((*list)[:i], (*list)[i+1:]...)
+	return true
+}
+
+func indexOf[T any](list []T, nameOf func(*T) *string, name string) int {
+	for i := range list {
+		if *nameOf(&list[i]) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// check rejects applied objects missing the fields the config needs
+func check(cfg *config.Config, given map[string]bool) error {
+	for _, n := range cfg.Nodes {
+		if given[KindNode+"/"+n.Name] && net.ParseIP(n.IP) == nil {
+			return fmt.Errorf("%s/%s: spec.ip must be an IP address", KindNode, n.Name)
+		}
+	}
+	for _, r := range cfg.Alerts.Custom {
+		if given[KindAlertRule+"/"+r.Name] && r.Expr == "" {
+			return fmt.Errorf("%s/%s: spec.expr is required", KindAlertRule, r.Name)
+		}
+	}
+	return nil
+}
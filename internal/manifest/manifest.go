@@ -0,0 +1,188 @@
+// Package manifest applies declarative resource manifests to the config,
+// the way kubectl apply does:
+//
+//	apiVersion: aami/v1
+//	kind: Node
+//	metadata:
+//	  name: gpu-01
+//	spec:
+//	  ip: 10.0.0.1
+//	  labels: {rack: a1}
+//
+// Each apply records the manifests it applied. The next apply merges three
+// ways: fields in the manifest are set, fields removed from the manifest
+// since the last apply are removed, and fields set by other means (aami
+// nodes patch, discovery) are kept.
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIVersion of the manifests
+const APIVersion = "aami/v1"
+
+// Kinds of resources
+const (
+	KindNode      = "Node"      // nodes: a monitored target
+	KindAlertRule = "AlertRule" // alerts.custom: a custom alert rule
+	KindNamespace = "Namespace" // alerts.namespaces: a team's rules and quota
+)
+
+// Kinds lists the supported kinds in the order they are applied.
+var Kinds = []string{KindNamespace, KindNode, KindAlertRule}
+
+// Resource is one manifest.
+type Resource struct {
+	APIVersion string                 `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string                 `yaml:"kind" json:"kind"`
+	Metadata   Metadata               `yaml:"metadata" json:"metadata"`
+	Spec       map[string]interface{} `yaml:"spec" json:"spec"` // fields as in the config file, without name
+}
+
+// Metadata identifies a resource.
+type Metadata struct {
+	Name string `yaml:"name" json:"name"`
+}
+
+// Key returns "<kind>/<name>", unique among resources.
+func (r Resource) Key() string {
+	return r.Kind + "/" + r.Metadata.Name
+}
+
+// Parse reads the manifests of a YAML stream of one or more documents.
+func Parse(data []byte) ([]Resource, error) {
+	var resources []Resource
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var r Resource
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+		if r.Kind == "" && r.APIVersion == "" && r.Metadata.Name == "" && r.Spec == nil {
+			continue // empty document
+		}
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+func (r Resource) validate() error {
+	if !knownKind(r.Kind) {
+		return fmt.Errorf("unsupported kind %q: must be one of %s", r.Kind, strings.Join(Kinds, ", "))
+	}
+	if r.APIVersion != APIVersion {
+		return fmt.Errorf("%s: unsupported apiVersion %q, want %s", r.Key(), r.APIVersion, APIVersion)
+	}
+	if r.Metadata.Name == "" {
+		return fmt.Errorf("%s manifest without metadata.name", r.Kind)
+	}
+	if _, ok := r.Spec["name"]; ok {
+		return fmt.Errorf("%s: the name is set in metadata.name, not spec", r.Key())
+	}
+	return nil
+}
+
+func knownKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadPath reads the manifests in a file, or in the *.yaml and *.yml files
+// of a directory.
+func ReadPath(path string) ([]Resource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+	}
+
+	var resources []Resource
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		resources = append(resources, rs...)
+	}
+	return resources, nil
+}
+
+// Sort orders resources by kind, in the order of Kinds, keeping the order
+// of the manifests within a kind. It rejects a resource given twice.
+func Sort(resources []Resource) error {
+	seen := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		if seen[r.Key()] {
+			return fmt.Errorf("%s is given more than once", r.Key())
+		}
+		seen[r.Key()] = true
+	}
+	rank := make(map[string]int, len(Kinds))
+	for i, k := range Kinds {
+		rank[k] = i
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		return rank[resources[i].Kind] < rank[resources[j].Kind]
+	})
+	return nil
+}
+
+// ThreeWayPatch returns the merge patch that turns the live object into
+// the desired one: the desired fields, and null for the fields of the last
+// applied manifest that the desired one no longer has. Objects are
+// compared recursively, so a label dropped from the manifest is removed
+// while labels set elsewhere stay.
+func ThreeWayPatch(last, desired map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{}, len(desired))
+	for key, value := range desired {
+		lastObj, lastIsObj := last[key].(map[string]interface{})
+		desiredObj, desiredIsObj := value.(map[string]interface{})
+		if lastIsObj && desiredIsObj {
+			patch[key] = ThreeWayPatch(lastObj, desiredObj)
+			continue
+		}
+		patch[key] = value
+	}
+	for key := range last {
+		if _, ok := desired[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
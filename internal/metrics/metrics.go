@@ -0,0 +1,222 @@
+// Package metrics counts what AAMI's own processes do — HTTP requests,
+// rule and target generation, Prometheus reloads — for the /metrics
+// endpoint of the serve commands, in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of generations and reloads
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Kinds of generated files
+const (
+	KindRules   = "rules"
+	KindTargets = "targets"
+)
+
+var (
+	// HTTPRequests observes the duration of every request to a serve
+	// command, by matched route, method and status code.
+	HTTPRequests = NewHistogram("aami_http_request_duration_seconds",
+		"Duration of HTTP requests to AAMI's API.", DefaultBuckets, "route", "method", "code")
+
+	// Generations counts the rule and target files AAMI writes, by kind
+	// (rules, targets) and result.
+	Generations = NewCounter("aami_generations_total",
+		"Rule and target files generated.", "kind", "result")
+
+	// GenerationsCoalesced counts the generation requests merged into one
+	// already queued, by kind.
+	GenerationsCoalesced = NewCounter("aami_generation_requests_coalesced_total",
+		"Generation requests coalesced with a queued generation.", "kind")
+
+	// Reloads counts the reload requests sent to Prometheus, by result.
+	Reloads = NewCounter("aami_prometheus_reloads_total",
+		"Prometheus configuration reloads requested.", "result")
+
+	// DriftHeals counts the drifted generated files 'aami drift check
+	// --heal' put right, by kind and action (restored, quarantined).
+	DriftHeals = NewCounter("aami_drift_heals_total",
+		"Drifted generated files restored or quarantined.", "kind", "action")
+)
+
+// DefaultBuckets are the upper bounds in seconds of the request histogram.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Result returns ResultFailure for a non-nil error, else ResultSuccess.
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// Counter is a counter with labels.
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by joined label values
+}
+
+// NewCounter creates a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// Inc adds one to the counter of the label values, given in the order of
+// the label names.
+func (c *Counter) Inc(values ...string) {
+	key := labelKey(c.name, c.labels, values)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+// WriteText writes the counter in the Prometheus text format.
+func (c *Counter) WriteText(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram is a histogram with labels.
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries // by joined label values
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds and
+// label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+// Observe records a value for the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := labelKey(h.name, h.labels, values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration, values ...string) {
+	h.Observe(d.Seconds(), values...)
+}
+
+// WriteText writes the histogram in the Prometheus text format.
+func (h *Histogram) WriteText(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(s.sum))
+		if _, err := fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteText writes every metric of this package.
+func WriteText(w io.Writer) error {
+	for _, m := range []interface{ WriteText(io.Writer) error }{HTTPRequests, Generations, GenerationsCoalesced, Reloads, DriftHeals} {
+		if err := m.WriteText(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelSep joins label values into map keys; it is not valid UTF-8, so
+// it does not occur in label values
+const labelSep = "\xff"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func labelKey(name string, labels, values []string) string {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", name, len(labels), len(values)))
+	}
+	return strings.Join(values, labelSep)
+}
+
+// formatLabels renders the labels of a key, plus le if set
+func formatLabels(labels []string, key, le string) string {
+	var pairs []string
+	if len(labels) > 0 {
+		for i, v := range strings.Split(key, labelSep) {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(v)))
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
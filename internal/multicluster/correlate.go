@@ -0,0 +1,213 @@
+package multicluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// targetLabels identify the cluster, node or GPU an alert fired for rather
+// than the condition; alerts differing only in them are the same alert on
+// different targets
+var targetLabels = map[string]bool{
+	"alertname":  true,
+	"severity":   true,
+	"cluster":    true,
+	"node":       true,
+	"instance":   true,
+	"hostname":   true,
+	"Hostname":   true,
+	"gpu":        true,
+	"UUID":       true,
+	"device":     true,
+	"pci_bus_id": true,
+}
+
+// CorrelationPolicy decides when alerts firing in several clusters are a
+// fleet-wide incident.
+type CorrelationPolicy struct {
+	MinClusters int           // clusters the alert starts firing in
+	Window      time.Duration // within which it starts firing in all of them; 0: any time
+}
+
+// DefaultCorrelationPolicy returns the default policy: the same alert
+// starting in two clusters within 15 minutes, as after a driver rollout or
+// an upstream network fault.
+func DefaultCorrelationPolicy() CorrelationPolicy {
+	return CorrelationPolicy{MinClusters: 2, Window: 15 * time.Minute}
+}
+
+// AlertGroup is an alert, by rule and labels, with everywhere it fires.
+type AlertGroup struct {
+	AlertName  string            `json:"alert_name"`
+	Severity   string            `json:"severity"`
+	Labels     map[string]string `json:"labels,omitempty"` // without target labels
+	Clusters   []string          `json:"clusters"`
+	Nodes      int               `json:"nodes"` // cluster/node pairs
+	Alerts     []GlobalAlert     `json:"alerts"`
+	FirstFired time.Time         `json:"first_fired"`
+	// Burst is the most clusters the alert started firing in within the
+	// policy window
+	Burst     int  `json:"burst"`
+	FleetWide bool `json:"fleet_wide"`
+}
+
+// AlertCorrelation is the alerts of all clusters, deduplicated and grouped.
+type AlertCorrelation struct {
+	Groups     []AlertGroup `json:"groups"`     // fleet-wide first, then by severity and clusters
+	Duplicates int          `json:"duplicates"` // alerts reported more than once, dropped
+}
+
+// Incidents returns the fleet-wide groups.
+func (c *AlertCorrelation) Incidents() []AlertGroup {
+	var incidents []AlertGroup
+	for _, g := range c.Groups {
+		if g.FleetWide {
+			incidents = append(incidents, g)
+		}
+	}
+	return incidents
+}
+
+// CorrelateAlerts drops alerts reported twice, as by the replicas of a
+// clustered Alertmanager, and groups the rest by rule and labels. Groups
+// that started firing in enough clusters within the policy window are
+// fleet-wide.
+func CorrelateAlerts(alerts []GlobalAlert, policy CorrelationPolicy) *AlertCorrelation {
+	result := &AlertCorrelation{Groups: []AlertGroup{}}
+
+	// 1. Deduplicate, keeping the earliest firing
+	unique := make(map[string]int)
+	var deduped []GlobalAlert
+	for _, alert := range alerts {
+		key := fmt.Sprintf("%s\x00%s\x00%d\x00%s", alert.Cluster, alert.Node, alert.GPU, groupKey(alert))
+		if i, ok := unique[key]; ok {
+			result.Duplicates++
+			if alert.FiredAt.Before(deduped[i].FiredAt) {
+				deduped[i].FiredAt = alert.FiredAt
+			}
+			continue
+		}
+		unique[key] = len(deduped)
+		deduped = append(deduped, alert)
+	}
+
+	// 2. Group by rule and labels
+	index := make(map[string]int)
+	for _, alert := range deduped {
+		key := groupKey(alert)
+		i, ok := index[key]
+		if !ok {
+			i = len(result.Groups)
+			index[key] = i
+			result.Groups = append(result.Groups, AlertGroup{
+				AlertName: alert.AlertName,
+				Severity:  alert.Severity,
+				Labels:    conditionLabels(alert.Labels),
+			})
+		}
+		result.Groups[i].Alerts = append(result.Groups[i].Alerts, alert)
+	}
+
+	// 3. Where and when each group fires
+	for i := range result.Groups {
+		g := &result.Groups[i]
+		started := make(map[string]time.Time) // cluster -> first firing
+		nodes := make(map[string]bool)
+		for _, alert := range g.Alerts {
+			if t, ok := started[alert.Cluster]; !ok || alert.FiredAt.Before(t) {
+				started[alert.Cluster] = alert.FiredAt
+			}
+			nodes[alert.Cluster+"/"+alert.Node] = true
+		}
+		g.Nodes = len(nodes)
+
+		var starts []time.Time
+		for cluster, t := range started {
+			g.Clusters = append(g.Clusters, cluster)
+			starts = append(starts, t)
+		}
+		sort.Strings(g.Clusters)
+		sort.Slice(starts, func(a, b int) bool { return starts[a].Before(starts[b]) })
+		g.FirstFired = starts[0]
+		g.Burst = burst(starts, policy.Window)
+		g.FleetWide = policy.MinClusters > 0 && g.Burst >= policy.MinClusters
+
+		sort.Slice(g.Alerts, func(a, b int) bool {
+			if g.Alerts[a].Cluster != g.Alerts[b].Cluster {
+				return g.Alerts[a].Cluster < g.Alerts[b].Cluster
+			}
+			return g.Alerts[a].Node < g.Alerts[b].Node
+		})
+	}
+
+	sort.SliceStable(result.Groups, func(i, j int) bool {
+		a, b := result.Groups[i], result.Groups[j]
+		if a.FleetWide != b.FleetWide {
+			return a.FleetWide
+		}
+		if a.Severity != b.Severity {
+			return severityOrder(a.Severity) < severityOrder(b.Severity)
+		}
+		if len(a.Clusters) != len(b.Clusters) {
+			return len(a.Clusters) > len(b.Clusters)
+		}
+		return a.FirstFired.Before(b.FirstFired)
+	})
+
+	return result
+}
+
+// burst returns the most of the sorted times within a window of each
+// other; all of them without a window
+func burst(times []time.Time, window time.Duration) int {
+	if window <= 0 {
+		return len(times)
+	}
+	most, first := 0, 0
+	for last := range times {
+		for times[last].Sub(times[first]) > window {
+			first++
+		}
+		if n := last - first + 1; n > most {
+			most = n
+		}
+	}
+	return most
+}
+
+// groupKey identifies an alert by rule, severity and condition labels
+func groupKey(alert GlobalAlert) string {
+	return alert.AlertName + "\x00" + alert.Severity + "\x00" + FormatLabels(conditionLabels(alert.Labels))
+}
+
+// conditionLabels returns the labels of an alert without target labels
+func conditionLabels(labels map[string]string) map[string]string {
+	var condition map[string]string
+	for k, v := range labels {
+		if targetLabels[k] {
+			continue
+		}
+		if condition == nil {
+			condition = make(map[string]string)
+		}
+		condition[k] = v
+	}
+	return condition
+}
+
+// FormatLabels formats labels as key="value" pairs, by key.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
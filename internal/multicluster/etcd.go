@@ -0,0 +1,212 @@
+package multicluster
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// DefaultEtcdKey is the etcd key of the registry
+const DefaultEtcdKey = "/aami/clusters"
+
+// etcdStore keeps the registry in an etcd key through the v3 JSON gateway,
+// versioned by the key's mod_revision: writes are transactions that only
+// put the key if its revision is still the one read.
+type etcdStore struct {
+	endpoints []string
+	key       string
+	username  string
+	password  string
+	token     string // from /v3/auth/authenticate, when username is set
+	client    *http.Client
+}
+
+func newEtcdStore(c config.RegistryConfig) (*etcdStore, error) {
+	e := &etcdStore{
+		key:      c.Key,
+		username: c.Username,
+		password: c.Password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if e.key == "" {
+		e.key = DefaultEtcdKey
+	}
+	for _, endpoint := range c.Endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		e.endpoints = append(e.endpoints, strings.TrimRight(endpoint, "/"))
+	}
+	if len(e.endpoints) == 0 {
+		return nil, fmt.Errorf("etcd cluster registry needs endpoints")
+	}
+	return e, nil
+}
+
+// etcdKV is a key-value pair of a range response; the gateway encodes bytes
+// in base64 and int64 as strings, and leaves out zero values
+type etcdKV struct {
+	Value       string `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+func (e *etcdStore) Read() ([]byte, string, error) {
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := e.call("/v3/kv/range", map[string]string{"key": encodeEtcd([]byte(e.key))}, &resp); err != nil {
+		return nil, "", err
+	}
+	if len(resp.KVs) == 0 {
+		return nil, "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.KVs[0].Value)
+	if err != nil {
+		return nil, "", fmt.Errorf("etcd: decode %s: %w", e.key, err)
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, resp.KVs[0].ModRevision, nil
+}
+
+func (e *etcdStore) Write(data []byte, version string) (string, error) {
+	key := encodeEtcd([]byte(e.key))
+	compare := map[string]string{"key": key, "result": "EQUAL"}
+	if version == "" {
+		// The key must not exist
+		compare["target"] = "CREATE"
+		compare["create_revision"] = "0"
+	} else {
+		compare["target"] = "MOD"
+		compare["mod_revision"] = version
+	}
+	txn := map[string]interface{}{
+		"compare": []interface{}{compare},
+		"success": []interface{}{
+			map[string]interface{}{"request_put": map[string]string{"key": key, "value": encodeEtcd(data)}},
+		},
+	}
+
+	var resp struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := e.call("/v3/kv/txn", txn, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Succeeded {
+		return "", ErrConflict
+	}
+	// The put is the transaction's only write, so its revision is the key's
+	return resp.Header.Revision, nil
+}
+
+func (e *etcdStore) Location() string {
+	var hosts []string
+	for _, endpoint := range e.endpoints {
+		hosts = append(hosts, endpoint[strings.Index(endpoint, "://")+3:])
+	}
+	return fmt.Sprintf("etcd://%s%s", strings.Join(hosts, ","), e.key)
+}
+
+// call posts a request to the gateway, trying the endpoints in order until
+// one answers
+func (e *etcdStore) call(path string, body, out interface{}) error {
+	var lastErr error
+	for _, endpoint := range e.endpoints {
+		err := e.post(endpoint, path, body, out, true)
+		if err == nil {
+			return nil
+		}
+		if _, unreachable := err.(*etcdUnreachable); !unreachable {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// etcdUnreachable is an endpoint that did not answer
+type etcdUnreachable struct {
+	err error
+}
+
+func (u *etcdUnreachable) Error() string { return u.err.Error() }
+
+func (e *etcdStore) post(endpoint, path string, body, out interface{}, retryAuth bool) error {
+	if e.username != "" && e.token == "" {
+		if err := e.authenticate(endpoint); err != nil {
+			return err
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return &etcdUnreachable{fmt.Errorf("etcd %s: %w", endpoint, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized && e.username != "" && retryAuth {
+		// The token expired
+		e.token = ""
+		return e.post(endpoint, path, body, out, false)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s%s: %s: %s", endpoint, path, resp.Status, readLimited(resp.Body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("etcd %s%s: decode: %w", endpoint, path, err)
+	}
+	return nil
+}
+
+// authenticate gets a token for the user
+func (e *etcdStore) authenticate(endpoint string) error {
+	payload, err := json.Marshal(map[string]string{"name": e.username, "password": e.password})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(endpoint+"/v3/auth/authenticate", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return &etcdUnreachable{fmt.Errorf("etcd %s: %w", endpoint, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s: authenticate %s: %s: %s", endpoint, e.username, resp.Status, readLimited(resp.Body))
+	}
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return fmt.Errorf("etcd %s: authenticate: decode: %w", endpoint, err)
+	}
+	e.token = auth.Token
+	return nil
+}
+
+func encodeEtcd(data []byte) string {
+	return base64.StdEncoding.EncodeToString(data)
+}
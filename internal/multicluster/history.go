@@ -0,0 +1,328 @@
+package multicluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHistoryDir is where aami clusters watch keeps status snapshots
+const DefaultHistoryDir = "/var/lib/aami/cluster-history"
+
+// DefaultHistoryRetention is how long snapshots are kept
+const DefaultHistoryRetention = 90 * 24 * time.Hour
+
+// historyDayLayout names the file of each day
+const historyDayLayout = "2006-01-02"
+
+// Snapshot is the status of every cluster at one poll.
+type Snapshot struct {
+	Time     time.Time       `json:"time"`
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// HistoryStore keeps snapshots in one JSON Lines file per day, so that
+// retention removes whole files and never rewrites the ones still kept.
+type HistoryStore struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewHistoryStore creates a snapshot store in dir.
+func NewHistoryStore(dir string) *HistoryStore {
+	return &HistoryStore{dir: dir, now: time.Now}
+}
+
+// Append stores a snapshot in the file of its day.
+func (h *HistoryStore) Append(s Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return fmt.Errorf("create history directory: %w", err)
+	}
+	path := filepath.Join(h.dir, s.Time.UTC().Format(historyDayLayout)+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// Since returns the snapshots taken at or after since, oldest first. Day
+// files before since are not opened.
+func (h *HistoryStore) Since(since time.Time) ([]Snapshot, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	days, err := h.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, day := range days {
+		if day < since.UTC().Format(historyDayLayout) {
+			continue
+		}
+		daySnapshots, err := h.readDay(day)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range daySnapshots {
+			if !s.Time.Before(since) {
+				snapshots = append(snapshots, s)
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// Prune removes the days older than the retention period and returns how
+// many day files were removed.
+func (h *HistoryStore) Prune(retention time.Duration) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	days, err := h.days()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := h.now().UTC().Add(-retention).Format(historyDayLayout)
+	removed := 0
+	for _, day := range days {
+		if day >= cutoff {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.dir, day+".jsonl")); err != nil {
+			return removed, fmt.Errorf("remove history of %s: %w", day, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// readDay returns the snapshots of a day. A line cut short by a crash is
+// skipped rather than failing every read.
+func (h *HistoryStore) readDay(day string) ([]Snapshot, error) {
+	f, err := os.Open(filepath.Join(h.dir, day+".jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	defer f.Close()
+
+	var snapshots []Snapshot
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var s Snapshot
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history of %s: %w", day, err)
+	}
+	return snapshots, nil
+}
+
+// days returns the days with snapshots, oldest first.
+func (h *HistoryStore) days() ([]string, error) {
+	entries, err := os.ReadDir(h.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read history directory: %w", err)
+	}
+
+	var days []string
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(historyDayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// Collector polls every cluster on an interval and stores the snapshots.
+type Collector struct {
+	registry   *Registry
+	aggregator *Aggregator
+	store      *HistoryStore
+	retention  time.Duration
+}
+
+// NewCollector creates a collector of the registry's clusters.
+func NewCollector(registry *Registry, store *HistoryStore, retention time.Duration) *Collector {
+	return &Collector{
+		registry:   registry,
+		aggregator: NewAggregator(registry),
+		store:      store,
+		retention:  retention,
+	}
+}
+
+// Collect reloads the registry, so that clusters added or removed by other
+// operators are picked up, polls every cluster and stores the snapshot.
+func (c *Collector) Collect(ctx context.Context) (Snapshot, error) {
+	if err := c.registry.Load(); err != nil {
+		return Snapshot{}, err
+	}
+	if err := c.aggregator.Refresh(); err != nil {
+		return Snapshot{}, err
+	}
+
+	statuses, err := c.aggregator.GetAggregatedStatus(ctx)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Time: time.Now().UTC().Truncate(time.Second), Clusters: statuses}
+	if err := c.store.Append(snapshot); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// Run collects every interval until ctx is done, passing each snapshot or
+// error to report. Days beyond the retention are pruned once a day.
+func (c *Collector) Run(ctx context.Context, interval time.Duration, report func(Snapshot, error)) {
+	defer c.aggregator.Close()
+
+	var lastPrune time.Time
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		snapshot, err := c.Collect(pollCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		report(snapshot, err)
+
+		if c.retention > 0 && time.Since(lastPrune) > 24*time.Hour {
+			if _, err := c.store.Prune(c.retention); err != nil {
+				report(Snapshot{}, err)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// DayTrend is a cluster's availability and health over a day.
+type DayTrend struct {
+	Day          string  `json:"day"`
+	Polls        int     `json:"polls"`
+	Availability float64 `json:"availability"` // percentage of polls answered
+	AvgHealth    float64 `json:"avg_health"`   // of the polls answered; 0 without any
+	MinHealth    float64 `json:"min_health"`
+	MaxAlerts    int     `json:"max_alerts"`
+}
+
+// ClusterTrend is a cluster's availability and health over a period.
+type ClusterTrend struct {
+	Cluster      string     `json:"cluster"`
+	Polls        int        `json:"polls"`
+	Availability float64    `json:"availability"`
+	AvgHealth    float64    `json:"avg_health"`
+	Days         []DayTrend `json:"days"` // oldest first
+}
+
+// Trends summarizes snapshots per cluster and day (UTC), by cluster name.
+func Trends(snapshots []Snapshot) []ClusterTrend {
+	type acc struct {
+		polls, answered int
+		health, min     float64
+		maxAlerts       int
+	}
+	add := func(a *acc, s ClusterStatus) {
+		a.polls++
+		if !s.Connected {
+			return
+		}
+		if a.answered == 0 || s.HealthScore < a.min {
+			a.min = s.HealthScore
+		}
+		a.answered++
+		a.health += s.HealthScore
+		if s.AlertsActive > a.maxAlerts {
+			a.maxAlerts = s.AlertsActive
+		}
+	}
+	ratio := func(n float64, d int) float64 {
+		if d == 0 {
+			return 0
+		}
+		return n / float64(d)
+	}
+
+	totals := make(map[string]*acc)
+	days := make(map[string]map[string]*acc)
+	for _, snapshot := range snapshots {
+		day := snapshot.Time.UTC().Format(historyDayLayout)
+		for _, s := range snapshot.Clusters {
+			if totals[s.Name] == nil {
+				totals[s.Name] = &acc{}
+				days[s.Name] = make(map[string]*acc)
+			}
+			if days[s.Name][day] == nil {
+				days[s.Name][day] = &acc{}
+			}
+			add(totals[s.Name], s)
+			add(days[s.Name][day], s)
+		}
+	}
+
+	var trends []ClusterTrend
+	for name, total := range totals {
+		trend := ClusterTrend{
+			Cluster:      name,
+			Polls:        total.polls,
+			Availability: 100 * ratio(float64(total.answered), total.polls),
+			AvgHealth:    ratio(total.health, total.answered),
+		}
+		for day, a := range days[name] {
+			trend.Days = append(trend.Days, DayTrend{
+				Day:          day,
+				Polls:        a.polls,
+				Availability: 100 * ratio(float64(a.answered), a.polls),
+				AvgHealth:    ratio(a.health, a.answered),
+				MinHealth:    a.min,
+				MaxAlerts:    a.maxAlerts,
+			})
+		}
+		sort.Slice(trend.Days, func(i, j int) bool { return trend.Days[i].Day < trend.Days[j].Day })
+		trends = append(trends, trend)
+	}
+	sort.Slice(trends, func(i, j int) bool { return trends[i].Cluster < trends[j].Cluster })
+	return trends
+}
@@ -0,0 +1,116 @@
+package multicluster
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// httpStore keeps the registry on a config server: a document read with
+// GET and replaced with PUT, versioned by its ETag. The PUT carries
+// If-Match with the ETag read, or If-None-Match: * when there was no
+// document, and the server answers 412 Precondition Failed when the
+// document changed meanwhile.
+type httpStore struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newHTTPStore(c config.RegistryConfig) (*httpStore, error) {
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return nil, fmt.Errorf("invalid cluster registry url %q", c.URL)
+	}
+	return &httpStore{
+		url:    c.URL,
+		token:  c.Token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (h *httpStore) Read() ([]byte, string, error) {
+	resp, err := h.do(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("read %s: %s: %s", h.url, resp.Status, readLimited(resp.Body))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read %s: %w", h.url, err)
+	}
+	version := resp.Header.Get("ETag")
+	if version == "" {
+		return nil, "", fmt.Errorf("read %s: the config server sent no ETag", h.url)
+	}
+	return data, version, nil
+}
+
+func (h *httpStore) Write(data []byte, version string) (string, error) {
+	headers := map[string]string{"Content-Type": "application/yaml"}
+	if version == "" {
+		headers["If-None-Match"] = "*"
+	} else {
+		headers["If-Match"] = version
+	}
+
+	resp, err := h.do(http.MethodPut, data, headers)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return "", ErrConflict
+	default:
+		return "", fmt.Errorf("write %s: %s: %s", h.url, resp.Status, readLimited(resp.Body))
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag, nil
+	}
+	// Without the new ETag, read it back
+	_, etag, err := h.Read()
+	return etag, err
+}
+
+func (h *httpStore) Location() string {
+	return h.url
+}
+
+func (h *httpStore) do(method string, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("config server: %w", err)
+	}
+	return resp, nil
+}
+
+// readLimited reads the start of an error response body
+func readLimited(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, 512))
+	return strings.TrimSpace(string(data))
+}
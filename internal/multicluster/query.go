@@ -0,0 +1,261 @@
+package multicluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryPoint is a sample value at a time. Values are kept as Prometheus
+// returns them, so NaN and Inf survive.
+type QueryPoint struct {
+	Time  time.Time `json:"time"`
+	Value string    `json:"value"`
+}
+
+// QuerySeries is a series of a query result, labelled with its cluster.
+type QuerySeries struct {
+	Cluster string            `json:"cluster"`
+	Metric  map[string]string `json:"metric"`
+	Points  []QueryPoint      `json:"points"` // one for vectors and scalars
+}
+
+// GlobalQueryResult is a PromQL query answered by every cluster.
+type GlobalQueryResult struct {
+	Query      string            `json:"query"`
+	Time       time.Time         `json:"time"`
+	ResultType string            `json:"result_type"` // vector, matrix, scalar, string
+	Series     []QuerySeries     `json:"series"`
+	Errors     map[string]string `json:"errors,omitempty"` // by cluster
+}
+
+// queryURL returns the Prometheus API the cluster is queried through: its
+// Prometheus, or the aami query-proxy at its endpoint
+func (c *Client) queryURL() string {
+	if c.config.PrometheusURL != "" {
+		return strings.TrimRight(c.config.PrometheusURL, "/")
+	}
+	return strings.TrimRight(c.baseURL, "/")
+}
+
+// Query runs an instant PromQL query on the cluster's Prometheus.
+func (c *Client) Query(ctx context.Context, promql string, ts time.Time) (string, []QuerySeries, error) {
+	params := url.Values{}
+	params.Set("query", promql)
+	params.Set("time", strconv.FormatFloat(float64(ts.UnixNano())/1e9, 'f', 3, 64))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.queryURL()+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	if result.Status != "success" {
+		return "", nil, fmt.Errorf("query failed: %s", result.Error)
+	}
+
+	series, err := parseQueryResult(result.Data.ResultType, result.Data.Result)
+	if err != nil {
+		return "", nil, fmt.Errorf("decode %s result: %w", result.Data.ResultType, err)
+	}
+	for i := range series {
+		series[i].Cluster = c.config.Name
+		if series[i].Metric == nil {
+			series[i].Metric = make(map[string]string)
+		}
+		// As Prometheus does on a label conflict, keep a differing
+		// cluster label of the series as exported_cluster
+		if v, ok := series[i].Metric["cluster"]; ok && v != c.config.Name {
+			series[i].Metric["exported_cluster"] = v
+		}
+		series[i].Metric["cluster"] = c.config.Name
+	}
+	return result.Data.ResultType, series, nil
+}
+
+// parseQueryResult decodes the result of a Prometheus query response
+func parseQueryResult(resultType string, raw json.RawMessage) ([]QuerySeries, error) {
+	switch resultType {
+	case "vector":
+		var samples []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &samples); err != nil {
+			return nil, err
+		}
+		series := make([]QuerySeries, 0, len(samples))
+		for _, s := range samples {
+			p, err := parsePoint(s.Value)
+			if err != nil {
+				return nil, err
+			}
+			series = append(series, QuerySeries{Metric: s.Metric, Points: []QueryPoint{p}})
+		}
+		return series, nil
+	case "matrix":
+		var ranges []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]interface{}  `json:"values"`
+		}
+		if err := json.Unmarshal(raw, &ranges); err != nil {
+			return nil, err
+		}
+		series := make([]QuerySeries, 0, len(ranges))
+		for _, r := range ranges {
+			s := QuerySeries{Metric: r.Metric}
+			for _, v := range r.Values {
+				p, err := parsePoint(v)
+				if err != nil {
+					return nil, err
+				}
+				s.Points = append(s.Points, p)
+			}
+			series = append(series, s)
+		}
+		return series, nil
+	case "scalar", "string":
+		var v [2]interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		p, err := parsePoint(v)
+		if err != nil {
+			return nil, err
+		}
+		return []QuerySeries{{Points: []QueryPoint{p}}}, nil
+	default:
+		return nil, fmt.Errorf("unknown result type")
+	}
+}
+
+// parsePoint decodes a [<unix time>, "<value>"] pair
+func parsePoint(v [2]interface{}) (QueryPoint, error) {
+	ts, ok := v[0].(float64)
+	if !ok {
+		return QueryPoint{}, fmt.Errorf("invalid sample time: %v", v[0])
+	}
+	value, ok := v[1].(string)
+	if !ok {
+		return QueryPoint{}, fmt.Errorf("invalid sample value: %v", v[1])
+	}
+	sec := int64(ts)
+	return QueryPoint{
+		Time:  time.Unix(sec, int64((ts-float64(sec))*1e9)).UTC().Round(time.Millisecond),
+		Value: value,
+	}, nil
+}
+
+// Query runs an instant PromQL query on the clusters in names, or on all
+// clusters when names is empty, and merges the results. Clusters that fail
+// are reported in Errors.
+func (a *Aggregator) Query(ctx context.Context, promql string, ts time.Time, names []string) (*GlobalQueryResult, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	clients := make(map[string]*Client)
+	if len(names) == 0 {
+		clients = a.clients
+	}
+	for _, name := range names {
+		client, ok := a.clients[name]
+		if !ok {
+			return nil, fmt.Errorf("cluster not found: %s", name)
+		}
+		clients[name] = client
+	}
+
+	type clusterResult struct {
+		cluster    string
+		resultType string
+		series     []QuerySeries
+		err        error
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan clusterResult, len(clients))
+
+	for name, client := range clients {
+		wg.Add(1)
+		go func(name string, c *Client) {
+			defer wg.Done()
+
+			resultType, series, err := c.Query(ctx, promql, ts)
+			results <- clusterResult{cluster: name, resultType: resultType, series: series, err: err}
+		}(name, client)
+	}
+
+	// Wait and collect results
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	merged := &GlobalQueryResult{Query: promql, Time: ts.UTC(), Series: []QuerySeries{}}
+	for r := range results {
+		if r.err != nil {
+			if merged.Errors == nil {
+				merged.Errors = make(map[string]string)
+			}
+			merged.Errors[r.cluster] = r.err.Error()
+			continue
+		}
+		merged.ResultType = r.resultType
+		merged.Series = append(merged.Series, r.series...)
+	}
+
+	// Sort by cluster, then by labels
+	sort.Slice(merged.Series, func(i, j int) bool {
+		if merged.Series[i].Cluster != merged.Series[j].Cluster {
+			return merged.Series[i].Cluster < merged.Series[j].Cluster
+		}
+		return FormatMetric(merged.Series[i].Metric) < FormatMetric(merged.Series[j].Metric)
+	})
+
+	return merged, nil
+}
+
+// FormatMetric formats a series' labels as PromQL does:
+// name{label="value", ...}.
+func FormatMetric(metric map[string]string) string {
+	labels := make(map[string]string, len(metric))
+	for k, v := range metric {
+		if k != "__name__" {
+			labels[k] = v
+		}
+	}
+	return metric["__name__"] + "{" + FormatLabels(labels) + "}"
+}
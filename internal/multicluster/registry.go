@@ -1,18 +1,22 @@
 package multicluster
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
 	"sort"
 	"sync"
 
 	"gopkg.in/yaml.v3"
 )
 
+// conflictRetries is how often a change is reapplied to a registry that
+// someone else changed meanwhile
+const conflictRetries = 3
+
 // Registry manages registered clusters.
 type Registry struct {
-	path     string
+	store    Store
+	version  string // of the document loaded from the store
 	clusters map[string]ClusterConfig
 	mu       sync.RWMutex
 }
@@ -22,24 +26,34 @@ type RegistryConfig struct {
 	Clusters []ClusterConfig `yaml:"clusters"`
 }
 
-// NewRegistry creates a new cluster registry.
+// NewRegistry creates a new cluster registry kept in a local file.
 func NewRegistry(path string) *Registry {
+	return NewRegistryWithStore(NewFileStore(path))
+}
+
+// NewRegistryWithStore creates a cluster registry kept in store.
+func NewRegistryWithStore(store Store) *Registry {
 	return &Registry{
-		path:     path,
+		store:    store,
 		clusters: make(map[string]ClusterConfig),
 	}
 }
 
-// Load reads the registry from disk.
+// Location describes where the registry is kept.
+func (r *Registry) Location() string {
+	return r.store.Location()
+}
+
+// Load reads the registry from its store.
 func (r *Registry) Load() error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	data, err := os.ReadFile(r.path)
-	if os.IsNotExist(err) {
-		// No clusters configured yet
-		return nil
-	}
+	return r.loadUnlocked()
+}
+
+func (r *Registry) loadUnlocked() error {
+	data, version, err := r.store.Read()
 	if err != nil {
 		return fmt.Errorf("read registry: %w", err)
 	}
@@ -53,25 +67,21 @@ func (r *Registry) Load() error {
 	for _, c := range config.Clusters {
 		r.clusters[c.Name] = c
 	}
+	r.version = version
 
 	return nil
 }
 
-// Save writes the registry to disk.
+// Save writes the registry to its store. It fails with ErrConflict when
+// the registry was changed since it was loaded.
 func (r *Registry) Save() error {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	return r.saveUnlocked()
 }
 
 func (r *Registry) saveUnlocked() error {
-	// Ensure directory exists
-	dir := filepath.Dir(r.path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("create directory: %w", err)
-	}
-
 	// Build config
 	var clusters []ClusterConfig
 	for _, c := range r.clusters {
@@ -90,59 +100,84 @@ func (r *Registry) saveUnlocked() error {
 		return fmt.Errorf("marshal registry: %w", err)
 	}
 
-	// Write with restricted permissions (contains API keys)
-	if err := os.WriteFile(r.path, data, 0600); err != nil {
+	version, err := r.store.Write(data, r.version)
+	if errors.Is(err, ErrConflict) {
+		return err
+	}
+	if err != nil {
 		return fmt.Errorf("write registry: %w", err)
 	}
+	r.version = version
 
 	return nil
 }
 
-// Add registers a new cluster.
-func (r *Registry) Add(cluster ClusterConfig) error {
+// modify applies change to the clusters and saves them. When someone else
+// changed the registry meanwhile, it reloads the registry and applies
+// change again, so that neither change is lost.
+func (r *Registry) modify(change func() error) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if cluster.Name == "" {
-		return fmt.Errorf("cluster name is required")
+	for attempt := 1; ; attempt++ {
+		if err := change(); err != nil {
+			return err
+		}
+		err := r.saveUnlocked()
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		if attempt == conflictRetries {
+			return fmt.Errorf("%w (%s), try again", err, r.store.Location())
+		}
+		if err := r.loadUnlocked(); err != nil {
+			return err
+		}
 	}
+}
 
-	if cluster.Endpoint == "" {
-		return fmt.Errorf("cluster endpoint is required")
-	}
+// Add registers a new cluster.
+func (r *Registry) Add(cluster ClusterConfig) error {
+	return r.modify(func() error {
+		if cluster.Name == "" {
+			return fmt.Errorf("cluster name is required")
+		}
 
-	if _, exists := r.clusters[cluster.Name]; exists {
-		return fmt.Errorf("cluster already exists: %s", cluster.Name)
-	}
+		if cluster.Endpoint == "" {
+			return fmt.Errorf("cluster endpoint is required")
+		}
 
-	r.clusters[cluster.Name] = cluster
-	return r.saveUnlocked()
+		if _, exists := r.clusters[cluster.Name]; exists {
+			return fmt.Errorf("cluster already exists: %s", cluster.Name)
+		}
+
+		r.clusters[cluster.Name] = cluster
+		return nil
+	})
 }
 
 // Update modifies an existing cluster.
 func (r *Registry) Update(cluster ClusterConfig) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if _, exists := r.clusters[cluster.Name]; !exists {
-		return fmt.Errorf("cluster not found: %s", cluster.Name)
-	}
+	return r.modify(func() error {
+		if _, exists := r.clusters[cluster.Name]; !exists {
+			return fmt.Errorf("cluster not found: %s", cluster.Name)
+		}
 
-	r.clusters[cluster.Name] = cluster
-	return r.saveUnlocked()
+		r.clusters[cluster.Name] = cluster
+		return nil
+	})
 }
 
 // Remove unregisters a cluster.
 func (r *Registry) Remove(name string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if _, exists := r.clusters[name]; !exists {
-		return fmt.Errorf("cluster not found: %s", name)
-	}
+	return r.modify(func() error {
+		if _, exists := r.clusters[name]; !exists {
+			return fmt.Errorf("cluster not found: %s", name)
+		}
 
-	delete(r.clusters, name)
-	return r.saveUnlocked()
+		delete(r.clusters, name)
+		return nil
+	})
 }
 
 // Get retrieves a cluster by name.
@@ -206,88 +241,79 @@ func (r *Registry) GetByLabel(key, value string) []ClusterConfig {
 
 // SetLabel sets a label on a cluster.
 func (r *Registry) SetLabel(name, key, value string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	cluster, exists := r.clusters[name]
-	if !exists {
-		return fmt.Errorf("cluster not found: %s", name)
-	}
-
-	if cluster.Labels == nil {
-		cluster.Labels = make(map[string]string)
-	}
-	cluster.Labels[key] = value
-	r.clusters[name] = cluster
+	return r.modify(func() error {
+		cluster, exists := r.clusters[name]
+		if !exists {
+			return fmt.Errorf("cluster not found: %s", name)
+		}
 
-	return r.saveUnlocked()
+		if cluster.Labels == nil {
+			cluster.Labels = make(map[string]string)
+		}
+		cluster.Labels[key] = value
+		r.clusters[name] = cluster
+		return nil
+	})
 }
 
 // RemoveLabel removes a label from a cluster.
 func (r *Registry) RemoveLabel(name, key string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	cluster, exists := r.clusters[name]
-	if !exists {
-		return fmt.Errorf("cluster not found: %s", name)
-	}
-
-	if cluster.Labels != nil {
-		delete(cluster.Labels, key)
-		r.clusters[name] = cluster
-	}
+	return r.modify(func() error {
+		cluster, exists := r.clusters[name]
+		if !exists {
+			return fmt.Errorf("cluster not found: %s", name)
+		}
 
-	return r.saveUnlocked()
+		if cluster.Labels != nil {
+			delete(cluster.Labels, key)
+			r.clusters[name] = cluster
+		}
+		return nil
+	})
 }
 
 // Rename changes the name of a cluster.
 func (r *Registry) Rename(oldName, newName string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if newName == "" {
-		return fmt.Errorf("new name is required")
-	}
-
-	cluster, exists := r.clusters[oldName]
-	if !exists {
-		return fmt.Errorf("cluster not found: %s", oldName)
-	}
+	return r.modify(func() error {
+		if newName == "" {
+			return fmt.Errorf("new name is required")
+		}
 
-	if _, exists := r.clusters[newName]; exists {
-		return fmt.Errorf("cluster already exists: %s", newName)
-	}
+		cluster, exists := r.clusters[oldName]
+		if !exists {
+			return fmt.Errorf("cluster not found: %s", oldName)
+		}
 
-	delete(r.clusters, oldName)
-	cluster.Name = newName
-	r.clusters[newName] = cluster
+		if _, exists := r.clusters[newName]; exists {
+			return fmt.Errorf("cluster already exists: %s", newName)
+		}
 
-	return r.saveUnlocked()
+		delete(r.clusters, oldName)
+		cluster.Name = newName
+		r.clusters[newName] = cluster
+		return nil
+	})
 }
 
 // Clear removes all clusters.
 func (r *Registry) Clear() error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	r.clusters = make(map[string]ClusterConfig)
-	return r.saveUnlocked()
+	return r.modify(func() error {
+		r.clusters = make(map[string]ClusterConfig)
+		return nil
+	})
 }
 
 // Import adds multiple clusters from a config.
 func (r *Registry) Import(clusters []ClusterConfig) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	for _, c := range clusters {
-		if c.Name == "" || c.Endpoint == "" {
-			continue
+	return r.modify(func() error {
+		for _, c := range clusters {
+			if c.Name == "" || c.Endpoint == "" {
+				continue
+			}
+			r.clusters[c.Name] = c
 		}
-		r.clusters[c.Name] = c
-	}
-
-	return r.saveUnlocked()
+		return nil
+	})
 }
 
 // Export returns all clusters as a config.
@@ -0,0 +1,171 @@
+package multicluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/storage"
+)
+
+// DefaultRegistryPath is the registry of the file backend
+const DefaultRegistryPath = "/etc/aami/clusters.yaml"
+
+// Registry backends
+const (
+	StoreFile = "file"
+	StoreHTTP = "http"
+	StoreEtcd = "etcd"
+	StoreS3   = "s3"
+	StoreGCS  = "gcs"
+)
+
+// ErrConflict is returned by Store.Write when the registry was changed
+// since it was read
+var ErrConflict = errors.New("cluster registry was changed by someone else")
+
+// Store keeps the registry document, a clusters.yaml, locally or where a
+// team of operators shares it. Versions are opaque: the registry writes
+// back the version it read, so that concurrent changes are not lost.
+type Store interface {
+	// Read returns the document and its version; nil data and an empty
+	// version when there is none yet
+	Read() ([]byte, string, error)
+	// Write replaces the document if it is still at version, an empty
+	// version meaning that there must be none, and returns the new version.
+	// It returns ErrConflict otherwise.
+	Write(data []byte, version string) (string, error)
+	// Location describes where the registry is kept, for messages
+	Location() string
+}
+
+// NewStore returns the store the settings select.
+func NewStore(c config.RegistryConfig) (Store, error) {
+	switch c.Backend {
+	case "", StoreFile:
+		path := c.Path
+		if path == "" {
+			path = DefaultRegistryPath
+		}
+		return NewFileStore(path), nil
+	case StoreHTTP:
+		return newHTTPStore(c)
+	case StoreEtcd:
+		return newEtcdStore(c)
+	case StoreS3, StoreGCS:
+		object := c.Object
+		object.Backend = c.Backend
+		backend, err := storage.New(object, "")
+		if err != nil {
+			return nil, err
+		}
+		key := c.Key
+		if key == "" {
+			key = "clusters.yaml"
+		}
+		return &objectStore{backend: backend, key: strings.TrimPrefix(key, "/")}, nil
+	default:
+		return nil, fmt.Errorf("unknown cluster registry backend %q (valid: file, http, etcd, s3, gcs)", c.Backend)
+	}
+}
+
+// contentVersion versions a document by its content, for backends without
+// versions of their own
+func contentVersion(data []byte) string {
+	if data == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileStore keeps the registry in a local file.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store keeping the registry in path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Read reads the file.
+func (f *FileStore) Read() ([]byte, string, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		// No clusters configured yet
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+// Write writes the file unless it changed since it was read.
+func (f *FileStore) Write(data []byte, version string) (string, error) {
+	_, currentVersion, err := f.Read()
+	if err != nil {
+		return "", err
+	}
+	if currentVersion != version {
+		return "", ErrConflict
+	}
+
+	// Ensure directory exists
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
+	// Write with restricted permissions (contains API keys)
+	if err := os.WriteFile(f.path, data, 0600); err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
+
+// Location returns the file's path.
+func (f *FileStore) Location() string {
+	return f.path
+}
+
+// objectStore keeps the registry as an object in S3 or GCS. Object storage
+// has no compare-and-swap that every S3-compatible service supports, so a
+// change made between the check and the upload can still be lost.
+type objectStore struct {
+	backend storage.Backend
+	key     string
+}
+
+func (o *objectStore) Read() ([]byte, string, error) {
+	data, err := o.backend.Get(o.key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentVersion(data), nil
+}
+
+func (o *objectStore) Write(data []byte, version string) (string, error) {
+	_, currentVersion, err := o.Read()
+	if err != nil {
+		return "", err
+	}
+	if currentVersion != version {
+		return "", ErrConflict
+	}
+	if err := o.backend.Put(o.key, data); err != nil {
+		return "", err
+	}
+	return contentVersion(data), nil
+}
+
+func (o *objectStore) Location() string {
+	return o.backend.Location(o.key)
+}
@@ -12,6 +12,10 @@ type ClusterConfig struct {
 	TLSCACert string `yaml:"tls_ca_cert,omitempty" json:"tls_ca_cert,omitempty"`
 	SkipTLS   bool   `yaml:"skip_tls_verify,omitempty" json:"skip_tls_verify,omitempty"`
 	Labels    map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+
+	// PrometheusURL is queried by aami clusters query; without it, the
+	// query API at Endpoint (an aami query-proxy) is used
+	PrometheusURL string `yaml:"prometheus_url,omitempty" json:"prometheus_url,omitempty"`
 }
 
 // ClusterStatus represents the current status of a cluster.
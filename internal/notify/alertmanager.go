@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+)
+
+// AlertmanagerDir is the default output directory for the Alertmanager config
+const AlertmanagerDir = "/etc/aami/alertmanager"
+
+// TemplatesDir is where the notification templates the config refers to
+// are kept
+const TemplatesDir = "/etc/aami/templates"
+
+// TemplateFile holds the channel templates referenced by alertmanager.yml
+const TemplateFile = "aami.tmpl"
+
+// templateNames are the Alertmanager template names defined per channel
+var templateNames = map[string]string{
+	ChannelEmail: "aami.email.html",
+}
+
+type alertmanagerConfig struct {
+	Global    map[string]interface{} `yaml:"global"`
+	Templates []string               `yaml:"templates"`
+	Route     amRoute                `yaml:"route"`
+	Receivers []amReceiver           `yaml:"receivers"`
+}
+
+type amRoute struct {
+	Receiver       string   `yaml:"receiver"`
+	GroupBy        []string `yaml:"group_by"`
+	GroupWait      string   `yaml:"group_wait"`
+	GroupInterval  string   `yaml:"group_interval"`
+	RepeatInterval string   `yaml:"repeat_interval"`
+}
+
+type amReceiver struct {
+	Name           string            `yaml:"name"`
+	EmailConfigs   []amEmailConfig   `yaml:"email_configs,omitempty"`
+	WebhookConfigs []amWebhookConfig `yaml:"webhook_configs,omitempty"`
+}
+
+type amEmailConfig struct {
+	To           string            `yaml:"to"`
+	SendResolved bool              `yaml:"send_resolved"`
+	Headers      map[string]string `yaml:"headers"`
+	HTML         string            `yaml:"html"`
+}
+
+type amWebhookConfig struct {
+	URL          string        `yaml:"url"`
+	SendResolved bool          `yaml:"send_resolved"`
+	HTTPConfig   *amHTTPConfig `yaml:"http_config,omitempty"`
+}
+
+type amHTTPConfig struct {
+	Authorization struct {
+		Credentials string `yaml:"credentials"`
+	} `yaml:"authorization"`
+}
+
+// GenerateAlertmanagerConfig writes alertmanager.yml and the channel template
+// file into dir.
+func GenerateAlertmanagerConfig(cfg *config.Config, dir string) error {
+	data, templates, err := BuildAlertmanagerConfig(cfg, filepath.Join(dir, TemplateFile))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create alertmanager directory: %w", err)
+	}
+
+	// Templates first, so alertmanager.yml never references a missing file
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{filepath.Join(dir, TemplateFile), templates},
+		{filepath.Join(dir, "alertmanager.yml"), data},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, f.content, 0644); err != nil {
+			return fmt.Errorf("write %s: %w", filepath.Base(f.path), err)
+		}
+		if err := drift.Record(drift.KindAlertmanager, f.path, f.content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BuildAlertmanagerConfig renders alertmanager.yml and the channel template
+// file that it loads from templatePath. Every template is rendered against
+// sample data first, so a broken template fails here instead of at
+// notification time.
+//
+// Alertmanager renders the email template itself. Slack and webhook
+// notifications go to the relay of `aami notifications serve` at
+// notifications.relay_url, which renders their templates: Alertmanager can
+// send neither Block Kit blocks nor a payload of its own.
+func BuildAlertmanagerConfig(cfg *config.Config, templatePath string) ([]byte, []byte, error) {
+	var defines strings.Builder
+	for _, channel := range Channels {
+		text, err := LoadTemplate(channel, TemplatePath(cfg, channel))
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := Render(channel, text, SampleData(cfg, false)); err != nil {
+			return nil, nil, err
+		}
+		if name, ok := templateNames[channel]; ok {
+			fmt.Fprintf(&defines, "{{ define %q }}%s{{ end }}\n\n", name, text)
+		}
+	}
+
+	receiver := amReceiver{Name: "aami"}
+	global := map[string]interface{}{"resolve_timeout": "5m"}
+	n := cfg.Notifications
+
+	if n.Email != nil && n.Email.Enabled {
+		global["smtp_smarthost"] = fmt.Sprintf("%s:%d", n.Email.SMTPHost, n.Email.SMTPPort)
+		global["smtp_from"] = n.Email.From
+		for _, to := range n.Email.To {
+			receiver.EmailConfigs = append(receiver.EmailConfigs, amEmailConfig{
+				To:           to,
+				SendResolved: true,
+				Headers:      map[string]string{"Subject": "[{{ .Status | toUpper }}] {{ .GroupLabels.alertname }}"},
+				HTML:         fmt.Sprintf("{{ template %q . }}", templateNames[ChannelEmail]),
+			})
+		}
+	}
+
+	if (n.Slack != nil && n.Slack.Enabled) || (n.Webhook != nil && n.Webhook.Enabled) {
+		relay := amWebhookConfig{URL: RelayURL(cfg), SendResolved: true}
+		if n.RelayToken != "" {
+			relay.HTTPConfig = &amHTTPConfig{}
+			relay.HTTPConfig.Authorization.Credentials = n.RelayToken
+		}
+		receiver.WebhookConfigs = append(receiver.WebhookConfigs, relay)
+	}
+
+	amCfg := alertmanagerConfig{
+		Global:    global,
+		Templates: []string{templatePath},
+		Route: amRoute{
+			Receiver:       receiver.Name,
+			GroupBy:        []string{"alertname", "node"},
+			GroupWait:      "30s",
+			GroupInterval:  "5m",
+			RepeatInterval: "4h",
+		},
+		Receivers: []amReceiver{receiver},
+	}
+
+	var data bytes.Buffer
+	data.WriteString("# Generated by AAMI - Do not edit manually\n")
+	encoder := yaml.NewEncoder(&data)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(amCfg); err != nil {
+		return nil, nil, fmt.Errorf("marshal alertmanager config: %w", err)
+	}
+
+	return data.Bytes(), []byte(defines.String()), nil
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// slackMaxBlocks is the most blocks Slack accepts in a message
+const slackMaxBlocks = 50
+
+// RelayChannels are the channels whose templates Alertmanager cannot
+// render: Slack, as its slack_configs post text only, and webhook, as its
+// webhook_configs post a fixed payload. Alertmanager sends their
+// notifications to `aami notifications serve`, which renders and sends
+// them with Relay.
+var RelayChannels = []string{ChannelSlack, ChannelWebhook}
+
+// DefaultRelayURL is where Alertmanager reaches the relay if
+// notifications.relay_url is not set
+const DefaultRelayURL = "http://localhost:8115"
+
+// RelayPath is the path of the relay's Alertmanager webhook receiver
+const RelayPath = "/api/v1/notifications/alertmanager"
+
+// RelayURL returns the URL of the relay's Alertmanager webhook receiver.
+func RelayURL(cfg *config.Config) string {
+	base := cfg.Notifications.RelayURL
+	if base == "" {
+		base = DefaultRelayURL
+	}
+	return strings.TrimRight(base, "/") + RelayPath
+}
+
+// Relay renders a notification Alertmanager posted with the templates of
+// the enabled relay channels and sends it to them.
+func Relay(cfg *config.Config, data *Data) error {
+	enabled := EnabledChannels(cfg)
+	var errs []error
+	for _, channel := range RelayChannels {
+		if !contains(enabled, channel) {
+			continue
+		}
+		text, err := LoadTemplate(channel, TemplatePath(cfg, channel))
+		if err == nil {
+			var message string
+			if message, err = Render(channel, text, data); err == nil {
+				err = Send(cfg, channel, message, data)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// subject is the one-line summary of a notification: the email subject and
+// the Slack fallback text
+func subject(data *Data) string {
+	return fmt.Sprintf("[%s] %s", strings.ToUpper(data.Status), data.GroupLabels["alertname"])
+}
+
+// Send delivers a rendered message to a configured channel.
+func Send(cfg *config.Config, channel, message string, data *Data) error {
+	n := cfg.Notifications
+
+	switch channel {
+	case ChannelSlack:
+		if n.Slack == nil || n.Slack.WebhookURL == "" {
+			return fmt.Errorf("slack webhook_url is not configured")
+		}
+		blocks, err := slackBlocks(message)
+		if err != nil {
+			return err
+		}
+		if len(blocks) > slackMaxBlocks {
+			more := fmt.Sprintf(`{"type": "context", "elements": [{"type": "mrkdwn", "text": "%d more blocks not shown"}]}`,
+				len(blocks)-slackMaxBlocks+1)
+			blocks = append(blocks[:slackMaxBlocks-1], json.RawMessage(more))
+		}
+		payload, err := json.Marshal(map[string]interface{}{
+			"channel": n.Slack.Channel,
+			"text":    subject(data), // shown in notifications and by clients without blocks
+			"blocks":  blocks,
+		})
+		if err != nil {
+			return fmt.Errorf("marshal slack payload: %w", err)
+		}
+		return post(n.Slack.WebhookURL, payload)
+
+	case ChannelWebhook:
+		if n.Webhook == nil || n.Webhook.URL == "" {
+			return fmt.Errorf("webhook url is not configured")
+		}
+		return post(n.Webhook.URL, []byte(message))
+
+	case ChannelEmail:
+		if n.Email == nil || n.Email.SMTPHost == "" || len(n.Email.To) == 0 {
+			return fmt.Errorf("email smtp_host and to are not configured")
+		}
+		msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s",
+			n.Email.From, strings.Join(n.Email.To, ", "), subject(data), message)
+		addr := fmt.Sprintf("%s:%d", n.Email.SMTPHost, n.Email.SMTPPort)
+		if err := smtp.SendMail(addr, nil, n.Email.From, n.Email.To, []byte(msg)); err != nil {
+			return fmt.Errorf("send email: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown channel: %s", channel)
+}
+
+func post(url string, body []byte) error {
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post notification: HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
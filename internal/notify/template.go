@@ -0,0 +1,377 @@
+// Package notify renders notification messages for AAMI alert channels.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Notification channels
+const (
+	ChannelSlack   = "slack"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// Channels lists the supported notification channels.
+var Channels = []string{ChannelSlack, ChannelEmail, ChannelWebhook}
+
+// DefaultTemplates are used for channels without a configured template.
+var DefaultTemplates = map[string]string{
+	ChannelSlack: `[
+  {"type": "header", "text": {"type": "plain_text", "emoji": true, "text": {{ printf "%s [%s] %s" (eq .Status "firing" | ternary ":red_circle:" ":large_green_circle:") (.Status | toUpper) .GroupLabels.alertname | toJSON }}}}
+  {{- range .Alerts }},
+  {"type": "section", "fields": [
+    {"type": "mrkdwn", "text": {{ printf "*Node*\n` + "`%s`" + `" .Labels.node | toJSON }}},
+    {"type": "mrkdwn", "text": {{ printf "*Severity*\n%s" .Labels.severity | toJSON }}}
+  ]},
+  {"type": "section", "text": {"type": "mrkdwn", "text": {{ .Annotations.summary | toJSON }}}},
+  {"type": "context", "elements": [{"type": "mrkdwn", "text": {{ printf "Started %s" (.StartsAt.Format "2006-01-02 15:04:05 MST") | toJSON }}}]}
+  {{- end }}
+]
+`,
+
+	ChannelEmail: `<!DOCTYPE html>
+<html>
+<body>
+  <h2>[{{ .Status | toUpper }}] {{ .GroupLabels.alertname }}</h2>
+  <table>
+    <tr><th>Node</th><th>Severity</th><th>Summary</th><th>Started</th></tr>
+    {{- range .Alerts }}
+    <tr>
+      <td>{{ .Labels.node }}</td>
+      <td>{{ .Labels.severity }}</td>
+      <td>{{ .Annotations.summary }}</td>
+      <td>{{ .StartsAt.Format "2006-01-02 15:04:05 MST" }}</td>
+    </tr>
+    {{- end }}
+  </table>
+</body>
+</html>
+`,
+
+	ChannelWebhook: `{
+  "status": {{ .Status | toJSON }},
+  "alertname": {{ .GroupLabels.alertname | toJSON }},
+  "alerts": [
+    {{- range $i, $a := .Alerts }}{{ if $i }},{{ end }}
+    {"node": {{ $a.Labels.node | toJSON }}, "severity": {{ $a.Labels.severity | toJSON }}, "summary": {{ $a.Annotations.summary | toJSON }}}
+    {{- end }}
+  ]
+}
+`,
+}
+
+// KV is a set of labels or annotations.
+type KV map[string]string
+
+// Pair is a single label or annotation.
+type Pair struct {
+	Name  string
+	Value string
+}
+
+// SortedPairs returns the pairs sorted by name.
+func (kv KV) SortedPairs() []Pair {
+	pairs := make([]Pair, 0, len(kv))
+	for _, name := range kv.Names() {
+		pairs = append(pairs, Pair{Name: name, Value: kv[name]})
+	}
+	return pairs
+}
+
+// Names returns the sorted names.
+func (kv KV) Names() []string {
+	names := make([]string, 0, len(kv))
+	for name := range kv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Values returns the values sorted by name.
+func (kv KV) Values() []string {
+	values := make([]string, 0, len(kv))
+	for _, name := range kv.Names() {
+		values = append(values, kv[name])
+	}
+	return values
+}
+
+// Alert is a single alert in a notification.
+type Alert struct {
+	Status       string    `json:"status"`
+	Labels       KV        `json:"labels"`
+	Annotations  KV        `json:"annotations"`
+	StartsAt     time.Time `json:"startsAt"`
+	EndsAt       time.Time `json:"endsAt"`
+	GeneratorURL string    `json:"generatorURL"`
+	Fingerprint  string    `json:"fingerprint"`
+}
+
+// Alerts is a list of alerts.
+type Alerts []Alert
+
+// Firing returns the firing alerts.
+func (as Alerts) Firing() []Alert {
+	return as.withStatus("firing")
+}
+
+// Resolved returns the resolved alerts.
+func (as Alerts) Resolved() []Alert {
+	return as.withStatus("resolved")
+}
+
+func (as Alerts) withStatus(status string) []Alert {
+	var out []Alert
+	for _, a := range as {
+		if a.Status == status {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Data is the notification data passed to templates. It mirrors the data
+// Alertmanager passes to its notification templates, and decodes from the
+// payload it posts to webhook receivers.
+type Data struct {
+	Receiver          string `json:"receiver"`
+	Status            string `json:"status"`
+	Alerts            Alerts `json:"alerts"`
+	GroupLabels       KV     `json:"groupLabels"`
+	CommonLabels      KV     `json:"commonLabels"`
+	CommonAnnotations KV     `json:"commonAnnotations"`
+	ExternalURL       string `json:"externalURL"`
+}
+
+// funcs is the subset of Alertmanager template functions available to
+// templates, and the functions for the JSON that the Slack and webhook
+// templates render. Those are rendered by `aami notifications serve`; the
+// email template, rendered by Alertmanager, can only use Alertmanager's.
+var funcs = map[string]interface{}{
+	"toUpper":   strings.ToUpper,
+	"toLower":   strings.ToLower,
+	"title":     title,
+	"trimSpace": strings.TrimSpace,
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	"match": regexp.MatchString,
+	"safeHtml": func(text string) htmltemplate.HTML {
+		return htmltemplate.HTML(text)
+	},
+	"reReplaceAll": func(pattern, repl, text string) string {
+		return regexp.MustCompile(pattern).ReplaceAllString(text, repl)
+	},
+	"stringSlice": func(s ...string) []string {
+		return s
+	},
+	"toJSON": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"ternary": func(yes, no interface{}, cond bool) interface{} {
+		if cond {
+			return yes
+		}
+		return no
+	},
+}
+
+func title(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// IsChannel reports whether name is a supported channel.
+func IsChannel(name string) bool {
+	_, ok := DefaultTemplates[name]
+	return ok
+}
+
+// TemplatePath returns the template file configured for a channel, if any.
+func TemplatePath(cfg *config.Config, channel string) string {
+	n := cfg.Notifications
+	switch channel {
+	case ChannelSlack:
+		if n.Slack != nil {
+			return n.Slack.Template
+		}
+	case ChannelEmail:
+		if n.Email != nil {
+			return n.Email.Template
+		}
+	case ChannelWebhook:
+		if n.Webhook != nil {
+			return n.Webhook.Template
+		}
+	}
+	return ""
+}
+
+// EnabledChannels returns the channels enabled in the configuration. The
+// names match Alertmanager's integration names.
+func EnabledChannels(cfg *config.Config) []string {
+	n := cfg.Notifications
+	var channels []string
+	if n.Slack != nil && n.Slack.Enabled {
+		channels = append(channels, ChannelSlack)
+	}
+	if n.Email != nil && n.Email.Enabled {
+		channels = append(channels, ChannelEmail)
+	}
+	if n.Webhook != nil && n.Webhook.Enabled {
+		channels = append(channels, ChannelWebhook)
+	}
+	return channels
+}
+
+// LoadTemplate returns the template text for a channel: the file at path if
+// set, otherwise the built-in default.
+func LoadTemplate(channel, path string) (string, error) {
+	if !IsChannel(channel) {
+		return "", fmt.Errorf("unknown channel: %s", channel)
+	}
+	if path == "" {
+		return DefaultTemplates[channel], nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read %s template: %w", channel, err)
+	}
+	return string(data), nil
+}
+
+// Render executes a channel template against data. Email templates are
+// HTML-escaped; Slack output must be a JSON array of Block Kit blocks and
+// webhook output valid JSON.
+func Render(channel, text string, data *Data) (string, error) {
+	var buf bytes.Buffer
+
+	if channel == ChannelEmail {
+		tmpl, err := htmltemplate.New(channel).Funcs(htmltemplate.FuncMap(funcs)).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("parse %s template: %w", channel, err)
+		}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("render %s template: %w", channel, err)
+		}
+		return buf.String(), nil
+	}
+
+	tmpl, err := texttemplate.New(channel).Funcs(texttemplate.FuncMap(funcs)).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse %s template: %w", channel, err)
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("render %s template: %w", channel, err)
+	}
+
+	switch channel {
+	case ChannelSlack:
+		if _, err := slackBlocks(buf.String()); err != nil {
+			return "", fmt.Errorf("render %s template: %w", channel, err)
+		}
+	case ChannelWebhook:
+		if !json.Valid(buf.Bytes()) {
+			return "", fmt.Errorf("render %s template: output is not valid JSON", channel)
+		}
+	}
+	return buf.String(), nil
+}
+
+// slackBlocks parses a rendered Slack message into its blocks
+func slackBlocks(message string) ([]json.RawMessage, error) {
+	var blocks []json.RawMessage
+	if err := json.Unmarshal([]byte(message), &blocks); err != nil {
+		return nil, fmt.Errorf("output is not a JSON array of Block Kit blocks: %w", err)
+	}
+	for i, block := range blocks {
+		var b struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(block, &b); err != nil || b.Type == "" {
+			return nil, fmt.Errorf("block %d has no type", i+1)
+		}
+	}
+	return blocks, nil
+}
+
+// Preview renders a channel template against sample data: text if given,
+// else the configured template.
+func Preview(cfg *config.Config, channel, text string, resolved bool) (string, *Data, error) {
+	if text == "" {
+		var err error
+		if text, err = LoadTemplate(channel, TemplatePath(cfg, channel)); err != nil {
+			return "", nil, err
+		}
+	} else if !IsChannel(channel) {
+		return "", nil, fmt.Errorf("unknown channel: %s", channel)
+	}
+	data := SampleData(cfg, resolved)
+	message, err := Render(channel, text, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return message, data, nil
+}
+
+// SampleData returns example notification data for previews and test sends.
+func SampleData(cfg *config.Config, resolved bool) *Data {
+	node := "gpu-node-01"
+	if len(cfg.Nodes) > 0 {
+		node = cfg.Nodes[0].Name
+	}
+
+	status := "firing"
+	startsAt := time.Now().Add(-10 * time.Minute).Truncate(time.Second)
+	var endsAt time.Time
+	if resolved {
+		status = "resolved"
+		endsAt = startsAt.Add(5 * time.Minute)
+	}
+
+	labels := KV{
+		"alertname": "GPUTemperatureCritical",
+		"severity":  "critical",
+		"node":      node,
+		"cluster":   cfg.Cluster.Name,
+	}
+	annotations := KV{
+		"summary":     fmt.Sprintf("GPU temperature critical on %s", node),
+		"description": "GPU 0 temperature is 91°C (threshold: 85°C)",
+	}
+
+	return &Data{
+		Receiver: "aami",
+		Status:   status,
+		Alerts: Alerts{{
+			Status:       status,
+			Labels:       labels,
+			Annotations:  annotations,
+			StartsAt:     startsAt,
+			EndsAt:       endsAt,
+			GeneratorURL: "http://localhost:9090/graph",
+			Fingerprint:  "0000000000000000",
+		}},
+		GroupLabels:       KV{"alertname": labels["alertname"]},
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+		ExternalURL:       "http://localhost:9093",
+	}
+}
@@ -0,0 +1,214 @@
+// Package oidc signs people in with an OpenID Connect provider. The CLI
+// logs in with the device authorization grant (RFC 8628), which needs no
+// browser on the machine running it, and the serve APIs verify the ID
+// tokens it sends, so human access follows SSO identities. Node agents
+// keep their own credentials and certificates.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Scopes are requested at login: an ID token with the user's email and
+// groups, and a refresh token to renew it
+var Scopes = []string{"openid", "email", "profile", "groups", "offline_access"}
+
+// Errors of the device flow
+var (
+	ErrAccessDenied = errors.New("login denied")
+	ErrExpiredCode  = errors.New("login code expired")
+)
+
+// Provider is the discovery document of an issuer.
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// httpClient is used for every request to a provider
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Discover reads the provider's /.well-known/openid-configuration
+func Discover(ctx context.Context, issuer string) (*Provider, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var p Provider
+	if err := doJSON(req, &p); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", issuer, err)
+	}
+	if strings.TrimRight(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discover %s: provider reports issuer %q", issuer, p.Issuer)
+	}
+	return &p, nil
+}
+
+// DeviceCode is a pending device login: the user opens VerificationURI
+// and enters UserCode while the CLI polls.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// Token is the result of a login or a refresh.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresIn    int       `json:"expires_in"`
+	Expiry       time.Time `json:"-"`
+}
+
+// StartDevice asks the provider for a device code
+func (p *Provider) StartDevice(ctx context.Context, clientID string) (*DeviceCode, error) {
+	if p.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("%s does not support device login", p.Issuer)
+	}
+	form := url.Values{
+		"client_id": {clientID},
+		"scope":     {strings.Join(Scopes, " ")},
+	}
+	var code DeviceCode
+	if err := p.post(ctx, p.DeviceAuthorizationEndpoint, form, &code); err != nil {
+		return nil, fmt.Errorf("start device login: %w", err)
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+	return &code, nil
+}
+
+// PollDevice waits until the user has approved the login, and returns
+// its tokens. It polls at the interval the provider asks for.
+func (p *Provider) PollDevice(ctx context.Context, clientID string, code *DeviceCode) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if code.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {code.DeviceCode},
+		"client_id":   {clientID},
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, ErrExpiredCode
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token, err := p.token(ctx, form)
+		var oerr *Error
+		if !errors.As(err, &oerr) {
+			return token, err
+		}
+		switch oerr.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, ErrAccessDenied
+		case "expired_token":
+			return nil, ErrExpiredCode
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Refresh renews the tokens of a login. Providers may not return a new
+// refresh token; the old one is kept then.
+func (p *Provider) Refresh(ctx context.Context, clientID, refreshToken string) (*Token, error) {
+	token, err := p.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {clientID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("refresh login: %w", err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+// token calls the token endpoint
+func (p *Provider) token(ctx context.Context, form url.Values) (*Token, error) {
+	var token Token
+	if err := p.post(ctx, p.TokenEndpoint, form, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%s returned no ID token", p.Issuer)
+	}
+	token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	if claims, err := UnverifiedClaims(token.IDToken); err == nil && claims.ExpiresAt > 0 {
+		token.Expiry = time.Unix(claims.ExpiresAt, 0)
+	}
+	return &token, nil
+}
+
+// Error is an OAuth error response.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+func (p *Provider) post(ctx context.Context, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doJSON(req, out)
+}
+
+// doJSON sends a request and decodes its JSON response, or its OAuth error
+func doJSON(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var oerr Error
+		if err := json.NewDecoder(resp.Body).Decode(&oerr); err == nil && oerr.Code != "" {
+			return &oerr
+		}
+		return fmt.Errorf("%s: %s", req.URL.Redacted(), resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of token verification
+var (
+	ErrMalformed = errors.New("malformed token")
+	ErrSignature = errors.New("invalid token signature")
+	ErrExpired   = errors.New("token expired")
+)
+
+// leeway absorbs clock skew between the provider and the server
+const leeway = time.Minute
+
+// keyRefetch is how often at most the keys of an issuer are fetched again
+// for a token signed with a key not seen yet
+const keyRefetch = time.Minute
+
+// Claims are the claims of an ID token.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+
+	raw map[string]interface{}
+}
+
+// String returns a string claim, such as email
+func (c Claims) String(name string) string {
+	s, _ := c.raw[name].(string)
+	return s
+}
+
+// Strings returns a claim holding a list of strings, such as groups. A
+// single string is a list of one.
+func (c Claims) Strings(name string) []string {
+	switch v := c.raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// audience is the aud claim: one string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// LooksLikeJWT reports whether a bearer token has the shape of a JWT, so
+// it is worth verifying as one
+func LooksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+// UnverifiedClaims decodes the claims of a token without checking it, for
+// the CLI to see when its own token expires
+func UnverifiedClaims(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+	return decodeClaims(parts[1])
+}
+
+// Verifier checks ID tokens of one issuer for one audience.
+type Verifier struct {
+	issuer   string
+	audience string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// verifiers are shared by the requests of a serve command, so keys are
+// fetched once per issuer
+var verifiers sync.Map
+
+// NewVerifier returns the verifier of tokens of issuer for audience,
+// usually the client ID of the CLI
+func NewVerifier(issuer, audience string) *Verifier {
+	key := issuer + "\x00" + audience
+	if v, ok := verifiers.Load(key); ok {
+		return v.(*Verifier)
+	}
+	v, _ := verifiers.LoadOrStore(key, &Verifier{
+		issuer:   strings.TrimRight(issuer, "/"),
+		audience: audience,
+		now:      time.Now,
+	})
+	return v.(*Verifier)
+}
+
+// Verify checks a token's signature against the issuer's keys, and its
+// issuer, audience and lifetime, and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrMalformed
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	claims, err := decodeClaims(parts[1])
+	if err != nil {
+		return Claims{}, err
+	}
+	now := v.now()
+	if strings.TrimRight(claims.Issuer, "/") != v.issuer {
+		return Claims{}, fmt.Errorf("token issued by %q, not %q", claims.Issuer, v.issuer)
+	}
+	if !claims.Audience.contains(v.audience) {
+		return Claims{}, fmt.Errorf("token not issued for %q", v.audience)
+	}
+	if claims.ExpiresAt == 0 || !now.Before(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return Claims{}, ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return Claims{}, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+// key returns the signing key with an ID, fetching the issuer's keys when
+// it is not known yet
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if !v.fetched.IsZero() && v.now().Sub(v.fetched) < keyRefetch {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	keys, err := fetchKeys(ctx, v.issuer)
+	v.fetched = v.now()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a key by ID; tokens without one match an only key
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if key, ok := v.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys reads the signing keys of an issuer from its JWKS
+func fetchKeys(ctx context.Context, issuer string) (map[string]crypto.PublicKey, error) {
+	p, err := Discover(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetch keys of %s: %w", issuer, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature checks an RS256 or ES256 signature
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return ErrSignature
+		}
+	case "ES256":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return ErrSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrSignature
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeClaims(segment string) (Claims, error) {
+	var claims Claims
+	if err := decodeSegment(segment, &claims); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	if err := decodeSegment(segment, &claims.raw); err != nil {
+		return Claims{}, ErrMalformed
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
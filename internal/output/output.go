@@ -0,0 +1,137 @@
+// Package output renders command results in the formats selected by
+// --output: tables for people, JSON and YAML for scripts.
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is an output format
+type Format string
+
+// Output formats. Wide is a table with the columns left out of Table.
+const (
+	Table Format = "table"
+	Wide  Format = "wide"
+	JSON  Format = "json"
+	YAML  Format = "yaml"
+)
+
+// Formats are the formats every command accepts
+var Formats = []Format{Table, Wide, JSON, YAML}
+
+// Usage returns the help of an --output flag accepting the common formats
+// and a command's own
+func Usage(extra ...Format) string {
+	names := make([]string, 0, len(Formats)+len(extra))
+	for _, f := range append(append([]Format(nil), Formats...), extra...) {
+		names = append(names, string(f))
+	}
+	return fmt.Sprintf("Output format (%s)", strings.Join(names, ", "))
+}
+
+// Parse returns the format named s, one of the common formats or of extra
+func Parse(s string, extra ...Format) (Format, error) {
+	f := Format(strings.ToLower(s))
+	for _, known := range append(append([]Format(nil), Formats...), extra...) {
+		if f == known {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format: %s", s)
+}
+
+// Structured reports whether the format is JSON or YAML
+func (f Format) Structured() bool {
+	return f == JSON || f == YAML
+}
+
+// Write writes v to w as JSON or YAML. YAML has the field names and order
+// of the JSON encoding, so both formats describe a result the same way.
+func Write(w io.Writer, f Format, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch f {
+	case JSON:
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case YAML:
+		// JSON is YAML; decoding it keeps the key order of the struct fields
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		blockStyle(&doc)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	default:
+		return fmt.Errorf("%s is not a structured output format", f)
+	}
+}
+
+// blockStyle clears the flow style and quoting of decoded JSON, so nodes are
+// written as block YAML. Strings that would read as another type stay
+// quoted by the encoder; so do the booleans of YAML 1.1, for older parsers.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" && yaml11Bools[strings.ToLower(n.Value)] {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// yaml11Bools are the booleans of YAML 1.1 that YAML 1.2 reads as strings
+var yaml11Bools = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true, "on": true, "off": true,
+}
+
+// Column is a table column. Wide columns are only shown in wide output.
+type Column struct {
+	Header string
+	Wide   bool
+}
+
+// Columns are the columns of a table
+type Columns []Column
+
+// Headers returns the headers of the columns shown in format f
+func (c Columns) Headers(f Format) []string {
+	headers := make([]string, 0, len(c))
+	for _, col := range c {
+		if !col.Wide || f == Wide {
+			headers = append(headers, col.Header)
+		}
+	}
+	return headers
+}
+
+// Row returns the cells of a row shown in format f; cells has one cell per
+// column
+func (c Columns) Row(f Format, cells ...string) []string {
+	row := make([]string, 0, len(cells))
+	for i, cell := range cells {
+		if i >= len(c) || !c[i].Wide || f == Wide {
+			row = append(row, cell)
+		}
+	}
+	return row
+}
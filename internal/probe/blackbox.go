@@ -0,0 +1,109 @@
+package probe
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/prometheus"
+)
+
+// DefaultBlackboxAddress is where Prometheus reaches blackbox_exporter
+const DefaultBlackboxAddress = "localhost:9115"
+
+// Host is a host a probe checks
+type Host struct {
+	Name    string // node name
+	Address string // IP or DNS name
+}
+
+// Resolver returns the hosts a probe checks: the targets of its group, or
+// its targets
+type Resolver func(p Probe) ([]Host, error)
+
+// module is a blackbox_exporter module
+type module struct {
+	Prober  string      `yaml:"prober"`
+	Timeout string      `yaml:"timeout"`
+	ICMP    *ipSettings `yaml:"icmp,omitempty"`
+	TCP     *ipSettings `yaml:"tcp,omitempty"`
+	HTTP    *httpModule `yaml:"http,omitempty"`
+}
+
+type ipSettings struct {
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+}
+
+type httpModule struct {
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+	ValidStatusCodes    []int  `yaml:"valid_status_codes,omitempty"`
+}
+
+// RenderModules renders the blackbox_exporter configuration holding a
+// module per probe
+func RenderModules(probes []Probe) ([]byte, error) {
+	modules := map[string]module{}
+	for _, p := range probes {
+		m := module{Prober: p.Type, Timeout: p.Timeout}
+		if m.Timeout == "" {
+			m.Timeout = defaultTimeout
+		}
+		ip := &ipSettings{PreferredIPProtocol: "ip4"}
+		switch p.Type {
+		case TypeICMP:
+			m.ICMP = ip
+		case TypeTCP:
+			m.TCP = ip
+		case TypeHTTP:
+			m.HTTP = &httpModule{PreferredIPProtocol: ip.PreferredIPProtocol, ValidStatusCodes: p.StatusCodes}
+		}
+		modules[p.Module()] = m
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n")
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(struct {
+		Modules map[string]module `yaml:"modules"`
+	}{modules}); err != nil {
+		return nil, fmt.Errorf("marshal blackbox modules: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ScrapeConfigs returns a scrape job per probe, sending each host to
+// blackbox_exporter at blackbox as the target of the probe's module. The
+// probe and node labels tell the probe_success series apart.
+func ScrapeConfigs(probes []Probe, resolve Resolver, blackbox string) ([]prometheus.ScrapeConfig, error) {
+	configs := make([]prometheus.ScrapeConfig, 0, len(probes))
+	for _, p := range probes {
+		hosts, err := resolve(p)
+		if err != nil {
+			return nil, fmt.Errorf("probe %s: %w", p.Name, err)
+		}
+		sc := prometheus.ScrapeConfig{
+			JobName:        p.Job(),
+			ScrapeInterval: p.Interval,
+			ScrapeTimeout:  p.Timeout,
+			MetricsPath:    "/probe",
+			Params:         url.Values{"module": {p.Module()}},
+			StaticConfigs:  []prometheus.Target{},
+			RelabelConfigs: []prometheus.RelabelConfig{
+				{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+				{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+				{TargetLabel: "__address__", Replacement: blackbox},
+			},
+		}
+		for _, h := range hosts {
+			sc.StaticConfigs = append(sc.StaticConfigs, prometheus.Target{
+				Targets: []string{p.Address(h.Address)},
+				Labels:  map[string]string{"probe": p.Name, "probe_type": p.Type, "node": h.Name},
+			})
+		}
+		configs = append(configs, sc)
+	}
+	return configs, nil
+}
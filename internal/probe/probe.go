@@ -0,0 +1,270 @@
+// Package probe keeps blackbox probes: ICMP, TCP or HTTP checks of a
+// config server group or of some targets, run by blackbox_exporter. It
+// renders the exporter's modules and the Prometheus scrape jobs that run
+// the probes.
+package probe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultStorePath is where probes are kept
+const DefaultStorePath = "/var/lib/aami/probes.yaml"
+
+// Types of probes
+const (
+	TypeICMP = "icmp"
+	TypeTCP  = "tcp"
+	TypeHTTP = "http"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Probe is a blackbox check of the targets of a config server group, or of
+// some targets
+type Probe struct {
+	Name        string    `yaml:"name" json:"name"`
+	Type        string    `yaml:"type" json:"type"`                                     // icmp, tcp, http
+	Group       string    `yaml:"group,omitempty" json:"group,omitempty"`               // config server group, ID or name
+	Targets     []string  `yaml:"targets,omitempty" json:"targets,omitempty"`           // node names
+	Port        int       `yaml:"port,omitempty" json:"port,omitempty"`                 // tcp and http
+	Scheme      string    `yaml:"scheme,omitempty" json:"scheme,omitempty"`             // http: http or https, default: http
+	Path        string    `yaml:"path,omitempty" json:"path,omitempty"`                 // http, default: /
+	StatusCodes []int     `yaml:"status_codes,omitempty" json:"status_codes,omitempty"` // http, default: 2xx
+	Interval    string    `yaml:"interval,omitempty" json:"interval,omitempty"`         // scrape interval, default: Prometheus'
+	Timeout     string    `yaml:"timeout,omitempty" json:"timeout,omitempty"`           // default: 5s
+	CreatedBy   string    `yaml:"created_by" json:"created_by"`
+	CreatedAt   time.Time `yaml:"created_at" json:"created_at"`
+}
+
+// defaultTimeout bounds a probe without a timeout
+const defaultTimeout = "5s"
+
+// Validate checks a probe before it is stored
+func (p Probe) Validate() error {
+	if !namePattern.MatchString(p.Name) {
+		return fmt.Errorf("invalid probe name: %q", p.Name)
+	}
+	if (p.Group == "") == (len(p.Targets) == 0) {
+		return fmt.Errorf("probe %s needs either a group or targets", p.Name)
+	}
+	switch p.Type {
+	case TypeICMP:
+		if p.Port != 0 {
+			return fmt.Errorf("probe %s: icmp probes take no port", p.Name)
+		}
+	case TypeTCP:
+		if p.Port == 0 {
+			return fmt.Errorf("probe %s: tcp probes need a port", p.Name)
+		}
+	case TypeHTTP:
+	default:
+		return fmt.Errorf("probe %s: unknown type %q (valid: icmp, tcp, http)", p.Name, p.Type)
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		return fmt.Errorf("probe %s: invalid port %d", p.Name, p.Port)
+	}
+	if p.Type != TypeHTTP && (p.Scheme != "" || p.Path != "" || len(p.StatusCodes) > 0) {
+		return fmt.Errorf("probe %s: scheme, path and status codes are for http probes", p.Name)
+	}
+	switch p.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("probe %s: invalid scheme %q (valid: http, https)", p.Name, p.Scheme)
+	}
+	if p.Path != "" && !strings.HasPrefix(p.Path, "/") {
+		return fmt.Errorf("probe %s: path must start with /", p.Name)
+	}
+	for _, code := range p.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("probe %s: invalid status code %d", p.Name, code)
+		}
+	}
+	var durations [2]time.Duration
+	for i, d := range []string{p.Interval, p.Timeout} {
+		if d == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("probe %s: invalid duration %q", p.Name, d)
+		}
+		durations[i] = v
+	}
+	// Prometheus rejects scrape jobs that time out after the next scrape
+	if interval, timeout := durations[0], durations[1]; interval > 0 && timeout > interval {
+		return fmt.Errorf("probe %s: timeout %s is longer than the interval %s", p.Name, p.Timeout, p.Interval)
+	}
+	return nil
+}
+
+// Module is the name of the probe's blackbox_exporter module
+func (p Probe) Module() string {
+	return "aami_" + p.Name
+}
+
+// Job is the name of the probe's scrape job
+func (p Probe) Job() string {
+	return "probe_" + p.Name
+}
+
+// Address is what the probe checks on a host: the host for icmp,
+// host:port for tcp, and a URL for http
+func (p Probe) Address(host string) string {
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	switch p.Type {
+	case TypeTCP:
+		return host + ":" + strconv.Itoa(p.Port)
+	case TypeHTTP:
+		scheme := p.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		if p.Port != 0 {
+			host += ":" + strconv.Itoa(p.Port)
+		}
+		return scheme + "://" + host + p.Path
+	}
+	return host
+}
+
+// AppliesTo reports whether the probe checks a target, given its hostname
+// and the IDs and names of its groups
+func (p Probe) AppliesTo(hostname string, groups []string) bool {
+	for _, t := range p.Targets {
+		if t == hostname {
+			return true
+		}
+	}
+	if p.Group == "" {
+		return false
+	}
+	for _, g := range groups {
+		if g == p.Group {
+			return true
+		}
+	}
+	return false
+}
+
+// storeFile is the on-disk format of the store
+type storeFile struct {
+	Probes []Probe `yaml:"probes"`
+}
+
+// Store keeps probes in a YAML file.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a store of probes kept at path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// List returns the probes sorted by name.
+func (s *Store) List() ([]Probe, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Get returns a probe by name.
+func (s *Store) Get(name string) (Probe, bool, error) {
+	probes, err := s.List()
+	if err != nil {
+		return Probe{}, false, err
+	}
+	for _, p := range probes {
+		if p.Name == name {
+			return p, true, nil
+		}
+	}
+	return Probe{}, false, nil
+}
+
+// Create validates and adds a probe.
+func (s *Store) Create(p Probe) (Probe, error) {
+	if err := p.Validate(); err != nil {
+		return Probe{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	probes, err := s.load()
+	if err != nil {
+		return Probe{}, err
+	}
+	for _, existing := range probes {
+		if existing.Name == p.Name {
+			return Probe{}, fmt.Errorf("probe already exists: %s", p.Name)
+		}
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	return p, s.save(append(probes, p))
+}
+
+// Delete removes a probe.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	probes, err := s.load()
+	if err != nil {
+		return err
+	}
+	for i, p := range probes {
+		if p.Name == name {
+			return s.save(append(probes[:i], probes[i+1:]...))
+		}
+	}
+	return fmt.Errorf("probe not found: %s", name)
+}
+
+func (s *Store) load() ([]Probe, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read probes: %w", err)
+	}
+	var f storeFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse probes: %w", err)
+	}
+	sort.Slice(f.Probes, func(i, j int) bool { return f.Probes[i].Name < f.Probes[j].Name })
+	return f.Probes, nil
+}
+
+func (s *Store) save(probes []Probe) error {
+	sort.Slice(probes, func(i, j int) bool { return probes[i].Name < probes[j].Name })
+	data, err := yaml.Marshal(storeFile{Probes: probes})
+	if err != nil {
+		return fmt.Errorf("marshal probes: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write probes: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
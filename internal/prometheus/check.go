@@ -0,0 +1,52 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// RuleCheck is the result of validating a rule file with promtool
+type RuleCheck struct {
+	Valid   bool   `json:"valid"`
+	Skipped bool   `json:"skipped,omitempty"` // promtool is not installed
+	Output  string `json:"output"`
+}
+
+// CheckRules validates rule file content with 'promtool check rules'. The
+// content goes to a temporary file that is removed afterwards; name is shown
+// in place of the temporary path in promtool's output.
+func CheckRules(ctx context.Context, name string, content []byte) (*RuleCheck, error) {
+	promtool, err := exec.LookPath("promtool")
+	if err != nil {
+		return &RuleCheck{Skipped: true, Output: "promtool not found in PATH"}, nil
+	}
+
+	f, err := os.CreateTemp("", "aami-rules-*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary rules file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write temporary rules file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("write temporary rules file: %w", err)
+	}
+
+	out, err := exec.CommandContext(ctx, promtool, "check", "rules", f.Name()).CombinedOutput()
+	check := &RuleCheck{
+		Valid:  err == nil,
+		Output: strings.ReplaceAll(string(out), f.Name(), name),
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("run promtool: %w", err)
+	}
+	return check, nil
+}
@@ -1,6 +1,7 @@
 package prometheus
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -8,6 +9,8 @@ import (
 	"text/template"
 
 	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+	"github.com/fregataa/aami/internal/metrics"
 )
 
 const prometheusConfigTemplate = `# Generated by AAMI - Do not edit manually
@@ -21,7 +24,9 @@ alerting:
         - targets: ['localhost:9093']
 
 rule_files:
-  - '/etc/aami/rules/*.yaml'
+{{- range ruleFiles . }}
+  - '{{ . }}'
+{{- end }}
 
 scrape_configs:
   - job_name: 'prometheus'
@@ -43,28 +48,38 @@ scrape_configs:
 
 // GenerateConfig generates the Prometheus configuration file
 func GenerateConfig(cfg *config.Config, outputPath string) error {
-	tmpl, err := template.New("prometheus").Parse(prometheusConfigTemplate)
+	tmpl, err := template.New("prometheus").
+		Funcs(template.FuncMap{"ruleFiles": RuleFileGlobs}).
+		Parse(prometheusConfigTemplate)
 	if err != nil {
 		return fmt.Errorf("parse template: %w", err)
 	}
 
-	f, err := os.Create(outputPath)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, cfg); err != nil {
+		return fmt.Errorf("render template: %w", err)
+	}
+
+	if err := os.WriteFile(outputPath, buf.Bytes(), 0644); err != nil {
 		return fmt.Errorf("create file: %w", err)
 	}
-	defer f.Close()
 
-	return tmpl.Execute(f, cfg)
+	return drift.Record(drift.KindPrometheus, outputPath, buf.Bytes())
 }
 
 // Target represents a Prometheus scrape target
 type Target struct {
-	Targets []string          `json:"targets"`
-	Labels  map[string]string `json:"labels"`
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels,omitempty"`
 }
 
 // GenerateNodeTargets generates the file_sd JSON for node_exporter
 func GenerateNodeTargets(nodes []config.NodeConfig, outputDir string) error {
+	return writeTargets(NodeTargets(nodes), filepath.Join(outputDir, "nodes.json"))
+}
+
+// NodeTargets returns the node_exporter targets for nodes
+func NodeTargets(nodes []config.NodeConfig) []Target {
 	var targets []Target
 
 	for _, node := range nodes {
@@ -84,11 +99,16 @@ func GenerateNodeTargets(nodes []config.NodeConfig, outputDir string) error {
 		targets = append(targets, target)
 	}
 
-	return writeTargets(targets, filepath.Join(outputDir, "nodes.json"))
+	return targets
 }
 
 // GenerateDCGMTargets generates the file_sd JSON for dcgm_exporter
 func GenerateDCGMTargets(nodes []config.NodeConfig, outputDir string) error {
+	return writeTargets(DCGMTargets(nodes), filepath.Join(outputDir, "dcgm.json"))
+}
+
+// DCGMTargets returns the dcgm_exporter targets for nodes
+func DCGMTargets(nodes []config.NodeConfig) []Target {
 	var targets []Target
 
 	for _, node := range nodes {
@@ -108,11 +128,16 @@ func GenerateDCGMTargets(nodes []config.NodeConfig, outputDir string) error {
 		targets = append(targets, target)
 	}
 
-	return writeTargets(targets, filepath.Join(outputDir, "dcgm.json"))
+	return targets
 }
 
+// DefaultTargetsDir is where the file_sd target files are written
+const DefaultTargetsDir = "/var/lib/aami/targets"
+
 // GenerateAllTargets generates all target files
-func GenerateAllTargets(nodes []config.NodeConfig, outputDir string) error {
+func GenerateAllTargets(nodes []config.NodeConfig, outputDir string) (err error) {
+	defer func() { metrics.Generations.Inc(metrics.KindTargets, metrics.Result(err)) }()
+
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("create targets directory: %w", err)
 	}
@@ -134,5 +159,9 @@ func writeTargets(targets []Target, path string) error {
 		return fmt.Errorf("marshal targets: %w", err)
 	}
 
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	return drift.Record(drift.KindTargets, path, data)
 }
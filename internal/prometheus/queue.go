@@ -0,0 +1,324 @@
+package prometheus
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/metrics"
+)
+
+// Defaults of a GenerationQueue
+const (
+	DefaultDebounce        = 2 * time.Second
+	DefaultMaxWait         = 10 * time.Second
+	DefaultGenerateTries   = 5
+	DefaultGenerateBackoff = 2 * time.Second
+	DefaultReloadTries     = 5
+	DefaultReloadBackoff   = time.Second
+)
+
+// GenerationQueue regenerates rule groups and reloads Prometheus in the
+// background, one generation at a time, so bulk rule changes do not
+// rewrite the same files and reload Prometheus once per change. Requests
+// for a group within Debounce of each other are coalesced into one
+// generation, but a group waits no longer than MaxWait after its first
+// request, so a steady stream of changes still gets written. The groups
+// due together share one reload; failed generations and reloads are
+// retried with exponential backoff.
+type GenerationQueue struct {
+	Debounce        time.Duration
+	MaxWait         time.Duration // from the first request; no limit if zero
+	GenerateTries   int
+	GenerateBackoff time.Duration // doubled after every failed try
+	ReloadTries     int
+	ReloadBackoff   time.Duration // doubled after every failed try
+
+	generate func(group string) error
+	reload   func() error
+
+	reloadMu sync.Mutex // one reload at a time, including Reload
+	wake     chan struct{}
+
+	mu       sync.Mutex
+	pending  map[string]pendingGroup
+	failures map[string]int // group -> failed generations in a row
+	status   GenerationStatus
+}
+
+// pendingGroup is a group waiting in a GenerationQueue
+type pendingGroup struct {
+	first time.Time // first request since the last generation
+	due   time.Time
+}
+
+// GenerationStatus is the state of a GenerationQueue.
+type GenerationStatus struct {
+	Pending    []string               `json:"pending"`
+	Running    string                 `json:"running,omitempty"` // group being generated
+	Groups     map[string]GroupStatus `json:"groups"`
+	LastReload *ReloadStatus          `json:"last_reload,omitempty"`
+}
+
+// GroupStatus is the generation history of a rule group.
+type GroupStatus struct {
+	Requests    int        `json:"requests"`
+	Generations int        `json:"generations"` // fewer than requests when coalesced
+	Failures    int        `json:"failures"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// ReloadStatus is the outcome of the last reload.
+type ReloadStatus struct {
+	At       time.Time `json:"at"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NewGenerationQueue creates a queue that regenerates a group with
+// generate and reloads Prometheus with reload. It does nothing until Run.
+func NewGenerationQueue(generate func(group string) error, reload func() error) *GenerationQueue {
+	return &GenerationQueue{
+		Debounce:        DefaultDebounce,
+		MaxWait:         DefaultMaxWait,
+		GenerateTries:   DefaultGenerateTries,
+		GenerateBackoff: DefaultGenerateBackoff,
+		ReloadTries:     DefaultReloadTries,
+		ReloadBackoff:   DefaultReloadBackoff,
+		generate:        generate,
+		reload:          reload,
+		wake:            make(chan struct{}, 1),
+		pending:         map[string]pendingGroup{},
+		failures:        map[string]int{},
+		status:          GenerationStatus{Groups: map[string]GroupStatus{}},
+	}
+}
+
+// Enqueue requests the regeneration of a group. A request for a group that
+// is already pending postpones it by Debounce, up to MaxWait after the
+// first request.
+func (q *GenerationQueue) Enqueue(group string) {
+	q.mu.Lock()
+	now := time.Now()
+	p, ok := q.pending[group]
+	if ok {
+		metrics.GenerationsCoalesced.Inc(metrics.KindRules)
+	} else {
+		p.first = now
+	}
+	p.due = now.Add(q.Debounce)
+	if q.MaxWait > 0 && p.due.After(p.first.Add(q.MaxWait)) {
+		p.due = p.first.Add(q.MaxWait)
+	}
+	q.pending[group] = p
+	delete(q.failures, group)
+	s := q.status.Groups[group]
+	s.Requests++
+	q.status.Groups[group] = s
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// signal wakes Run up to look at the queue again
+func (q *GenerationQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the state of the queue.
+func (q *GenerationQueue) Status() GenerationStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	status := q.status
+	status.Pending = make([]string, 0, len(q.pending))
+	for group := range q.pending {
+		status.Pending = append(status.Pending, group)
+	}
+	sort.Strings(status.Pending)
+	status.Groups = make(map[string]GroupStatus, len(q.status.Groups))
+	for group, s := range q.status.Groups {
+		status.Groups[group] = s
+	}
+	if q.status.LastReload != nil {
+		last := *q.status.LastReload
+		status.LastReload = &last
+	}
+	return status
+}
+
+// Run works through the queue until ctx is done.
+func (q *GenerationQueue) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		due, next := q.due(time.Now())
+		if len(due) > 0 {
+			q.run(ctx, due)
+			continue
+		}
+
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// due takes the groups that are due off the queue and returns them, and
+// when the next of the others is due
+func (q *GenerationQueue) due(now time.Time) ([]string, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []string
+	var next time.Time
+	for group, p := range q.pending {
+		if !p.due.After(now) {
+			due = append(due, group)
+			delete(q.pending, group)
+		} else if next.IsZero() || p.due.Before(next) {
+			next = p.due
+		}
+	}
+	sort.Strings(due)
+	return due, next
+}
+
+// run generates the groups and reloads Prometheus once if any succeeded.
+// Groups that failed are queued again, after GenerateBackoff doubled per
+// failure in a row, until GenerateTries fail.
+func (q *GenerationQueue) run(ctx context.Context, groups []string) {
+	generated := false
+	for _, group := range groups {
+		q.setRunning(group)
+		err := q.generate(group)
+
+		q.mu.Lock()
+		s := q.status.Groups[group]
+		s.Generations++
+		now := time.Now()
+		s.LastRun = &now
+		s.LastError = ""
+		if err != nil {
+			s.Failures++
+			s.LastError = err.Error()
+			q.retry(group, now)
+		} else {
+			delete(q.failures, group)
+		}
+		q.status.Groups[group] = s
+		q.status.Running = ""
+		q.mu.Unlock()
+
+		if err == nil {
+			generated = true
+		}
+	}
+	if generated {
+		q.reloadWithRetry(ctx)
+	}
+}
+
+// retry queues a group whose generation failed again, unless it was
+// requested meanwhile or has failed GenerateTries times in a row. q.mu
+// must be held.
+func (q *GenerationQueue) retry(group string, now time.Time) {
+	q.failures[group]++
+	failures := q.failures[group]
+	if failures >= q.GenerateTries {
+		delete(q.failures, group)
+		return
+	}
+	if _, ok := q.pending[group]; ok {
+		return
+	}
+	backoff := q.GenerateBackoff << (failures - 1)
+	q.pending[group] = pendingGroup{first: now, due: now.Add(backoff)}
+	q.signal()
+}
+
+func (q *GenerationQueue) setRunning(group string) {
+	q.mu.Lock()
+	q.status.Running = group
+	q.mu.Unlock()
+}
+
+// reloadWithRetry reloads Prometheus, retrying with backoff
+func (q *GenerationQueue) reloadWithRetry(ctx context.Context) {
+	backoff := q.ReloadBackoff
+	var err error
+	attempts := 1
+retry:
+	for ; ; attempts++ {
+		if err = q.Reload(); err == nil || attempts >= q.ReloadTries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			break retry
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	status := &ReloadStatus{At: time.Now(), Attempts: attempts}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	q.mu.Lock()
+	q.status.LastReload = status
+	q.mu.Unlock()
+}
+
+// Reload reloads Prometheus once. Reloads are serialized with those of
+// the queue, so callers that write rule files of their own, such as the
+// synthetic alert test, do not reload Prometheus concurrently with it.
+func (q *GenerationQueue) Reload() error {
+	q.reloadMu.Lock()
+	defer q.reloadMu.Unlock()
+	return q.reload()
+}
+
+// Reload asks the Prometheus server at baseURL to reload its
+// configuration and rules; it must run with --web.enable-lifecycle.
+func Reload(ctx context.Context, baseURL string) (err error) {
+	defer func() { metrics.Reloads.Inc(metrics.Result(err)) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(baseURL, "/")+"/-/reload", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("reload prometheus: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/quota"
+)
+
+// CountRules counts the rule groups and alert rules of a rule file;
+// recording rules are not alert rules.
+func CountRules(content []byte) (groups, alerts int, err error) {
+	var file struct {
+		Groups []struct {
+			Rules []struct {
+				Alert string `yaml:"alert"`
+			} `yaml:"rules"`
+		} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return 0, 0, fmt.Errorf("parse rule file: %w", err)
+	}
+	for _, g := range file.Groups {
+		for _, r := range g.Rules {
+			if r.Alert != "" {
+				alerts++
+			}
+		}
+	}
+	return len(file.Groups), alerts, nil
+}
+
+// NamespaceRules counts the rule groups and alert rules in a namespace's
+// directory, leaving out the file named skip.
+func NamespaceRules(namespace, skip string) (groups, alerts int, err error) {
+	dir := filepath.Join(RulesDir, namespace)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || e.Name() == skip || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+		g, a, err := CountRules(content)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		groups += g
+		alerts += a
+	}
+	return groups, alerts, nil
+}
+
+// checkRuleQuota returns a quota.ExceededError if writing content as
+// filename would take the namespace over its group or rule quota
+func checkRuleQuota(ns config.RuleNamespace, filename string, content []byte) error {
+	if ns.Quota.MaxGroups <= 0 && ns.Quota.MaxRules <= 0 {
+		return nil
+	}
+	otherGroups, otherAlerts, err := NamespaceRules(ns.Name, filename)
+	if err != nil {
+		return fmt.Errorf("count rules of namespace %s: %w", ns.Name, err)
+	}
+	oldGroups, oldAlerts := 0, 0
+	if old, err := os.ReadFile(filepath.Join(RulesDir, ns.Name, filename)); err == nil {
+		oldGroups, oldAlerts, _ = CountRules(old)
+	}
+	newGroups, newAlerts, err := CountRules(content)
+	if err != nil {
+		return err
+	}
+
+	if err := quota.Check(ns.Name, quota.ResourceGroups, ns.Quota.MaxGroups,
+		otherGroups+oldGroups, otherGroups+newGroups); err != nil {
+		return err
+	}
+	return quota.Check(ns.Name, quota.ResourceRules, ns.Quota.MaxRules,
+		otherAlerts+oldAlerts, otherAlerts+newAlerts)
+}
+
+// NamespaceUsage reports a namespace's consumption of its quota.
+func NamespaceUsage(cfg *config.Config, namespace string) (*quota.Usage, error) {
+	ns := FindRuleNamespace(cfg, namespace)
+	groups, alerts, err := NamespaceRules(namespace, "")
+	if err != nil {
+		return nil, err
+	}
+	return &quota.Usage{
+		Namespace: namespace,
+		Targets:   quota.Resource{Used: quota.Targets(cfg)[namespace], Limit: ns.Quota.MaxTargets},
+		Groups:    quota.Resource{Used: groups, Limit: ns.Quota.MaxGroups},
+		Rules:     quota.Resource{Used: alerts, Limit: ns.Quota.MaxRules},
+	}, nil
+}
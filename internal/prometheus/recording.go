@@ -0,0 +1,142 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// CapacityRulesFile is the rule file of the long-term capacity pack
+const CapacityRulesFile = "aami-capacity-1h.yaml"
+
+// capacityInterval is the resolution of the capacity series
+const capacityInterval = "1h"
+
+// RecordingRule is a Prometheus recording rule
+type RecordingRule struct {
+	Record string `yaml:"record"`
+	Expr   string `yaml:"expr"`
+}
+
+// RecordingGroup is a group of recording rules evaluated together
+type RecordingGroup struct {
+	Name     string          `yaml:"name"`
+	Interval string          `yaml:"interval"`
+	Rules    []RecordingRule `yaml:"rules"`
+}
+
+// capacitySeries are the hourly aggregates recorded at every level. expr
+// is formatted with the " by (...)" clause of the level.
+var capacitySeries = []struct {
+	name string
+	expr string
+}{
+	{"gpu_utilization:avg1h", "avg%s (avg_over_time(DCGM_FI_DEV_GPU_UTIL[1h]))"},
+	{"gpu_utilization:max1h", "max%s (max_over_time(DCGM_FI_DEV_GPU_UTIL[1h]))"},
+	{"gpu_power_watts:sum_avg1h", "sum%s (avg_over_time(DCGM_FI_DEV_POWER_USAGE[1h]))"},
+	{"gpu_count:max1h", "count%s (max_over_time(DCGM_FI_DEV_GPU_UTIL[1h]))"},
+}
+
+// alertSeries counts the alerts that fired during the hour
+var alertSeries = struct {
+	name string
+	expr string
+}{"alerts_firing:count1h", `count%s (max_over_time(ALERTS{alertstate="firing"}[1h]))`}
+
+// CapacityRules returns recording rules that pre-aggregate GPU utilization,
+// power, GPU count and firing alerts per hour, so dashboards spanning months
+// read a few hourly series instead of raw samples. Levels follow the
+// groupings of 'aami report': the cluster, each node, each node label key
+// in the config, and alerts by rule and severity.
+func CapacityRules(cfg *config.Config) []RecordingGroup {
+	levels := []struct {
+		name   string
+		labels []string
+	}{
+		{"cluster", nil},
+		{"node", []string{"node"}},
+	}
+	for _, key := range nodeLabelKeys(cfg.Nodes) {
+		if key == "node" || key == "cluster" {
+			continue
+		}
+		levels = append(levels, struct {
+			name   string
+			labels []string
+		}{key, []string{key}})
+	}
+
+	var groups []RecordingGroup
+	for _, level := range levels {
+		g := RecordingGroup{Name: "aami_capacity_" + level.name, Interval: capacityInterval}
+		for _, s := range capacitySeries {
+			g.Rules = append(g.Rules, RecordingRule{
+				Record: level.name + ":" + s.name,
+				Expr:   fmt.Sprintf(s.expr, byClause(level.labels)),
+			})
+		}
+		g.Rules = append(g.Rules, RecordingRule{
+			Record: level.name + ":" + alertSeries.name,
+			Expr:   fmt.Sprintf(alertSeries.expr, byClause(level.labels)),
+		})
+		groups = append(groups, g)
+	}
+
+	alerts := RecordingGroup{Name: "aami_capacity_alerts", Interval: capacityInterval}
+	for _, by := range [][]string{{"alertname", "severity"}, {"severity"}} {
+		alerts.Rules = append(alerts.Rules, RecordingRule{
+			Record: strings.Join(by, "_") + ":" + alertSeries.name,
+			Expr:   fmt.Sprintf(alertSeries.expr, byClause(by)),
+		})
+	}
+	return append(groups, alerts)
+}
+
+// RenderRecordingRules renders recording rule groups as a rule file
+func RenderRecordingRules(groups []RecordingGroup) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n")
+	buf.WriteString("# Hourly aggregates for long-term capacity dashboards\n\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(struct {
+		Groups []RecordingGroup `yaml:"groups"`
+	}{groups})
+	if err != nil {
+		return nil, fmt.Errorf("marshal recording rules: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// labelName matches valid Prometheus label names
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// nodeLabelKeys returns the valid label keys used by any node, sorted
+func nodeLabelKeys(nodes []config.NodeConfig) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, node := range nodes {
+		for key := range node.Labels {
+			if !seen[key] && labelName.MatchString(key) {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func byClause(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return " by (" + strings.Join(labels, ", ") + ")"
+}
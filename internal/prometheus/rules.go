@@ -0,0 +1,254 @@
+package prometheus
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/drift"
+	"github.com/fregataa/aami/internal/metrics"
+)
+
+// RulesDir is the root directory for generated rule files
+const RulesDir = "/etc/aami/rules"
+
+// defaultNamespaceFileMode leaves namespaced rules readable by Prometheus,
+// which runs as a user of its own. Namespaces that want their rules
+// private to an owner and group Prometheus is in set a mode such as 0640.
+const defaultNamespaceFileMode = 0644
+
+// RuleFileGlobs returns the rule_files patterns a Prometheus instance loads.
+// An instance bound to a namespace only sees that namespace's directory.
+func RuleFileGlobs(cfg *config.Config) []string {
+	if cfg.Prometheus.RuleNamespace != "" {
+		return []string{filepath.Join(RulesDir, cfg.Prometheus.RuleNamespace, "*.yaml")}
+	}
+	return []string{
+		filepath.Join(RulesDir, "*.yaml"),
+		filepath.Join(RulesDir, "*", "*.yaml"),
+	}
+}
+
+// FindRuleNamespace returns the configured namespace with the given name.
+// Unconfigured namespaces get default ownership (the current user).
+func FindRuleNamespace(cfg *config.Config, name string) config.RuleNamespace {
+	for _, ns := range cfg.Alerts.Namespaces {
+		if ns.Name == name {
+			return ns
+		}
+	}
+	return config.RuleNamespace{Name: name}
+}
+
+// WriteRuleFile writes a rule file into the root rules directory, or into
+// the namespace subdirectory with the namespace's ownership and permissions
+// when ns.Name is set. It returns the path written.
+func WriteRuleFile(ns config.RuleNamespace, filename string, content []byte) (_ string, err error) {
+	defer func() { metrics.Generations.Inc(metrics.KindRules, metrics.Result(err)) }()
+
+	if ns.Name == "" {
+		if err := os.MkdirAll(RulesDir, 0755); err != nil {
+			return "", fmt.Errorf("create rules directory: %w", err)
+		}
+		path := filepath.Join(RulesDir, filename)
+		if err := writeFileAtomic(path, content, 0644, -1, -1); err != nil {
+			return "", fmt.Errorf("write rules file: %w", err)
+		}
+		return path, drift.Record(drift.KindRules, path, content)
+	}
+
+	if ns.Name != filepath.Base(ns.Name) || ns.Name == "." || ns.Name == ".." {
+		return "", fmt.Errorf("invalid namespace name: %s", ns.Name)
+	}
+	if err := checkRuleQuota(ns, filename, content); err != nil {
+		return "", err
+	}
+
+	mode, dirMode, err := NamespaceModes(ns)
+	if err != nil {
+		return "", err
+	}
+
+	uid, gid, err := lookupOwner(ns.Owner, ns.Group)
+	if err != nil {
+		return "", fmt.Errorf("resolve owner for namespace %s: %w", ns.Name, err)
+	}
+
+	dir := filepath.Join(RulesDir, ns.Name)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return "", fmt.Errorf("create namespace directory: %w", err)
+	}
+	if err := os.Chmod(dir, dirMode); err != nil {
+		return "", fmt.Errorf("chmod namespace directory: %w", err)
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return "", fmt.Errorf("chown namespace directory: %w", err)
+		}
+	}
+	path := filepath.Join(dir, filename)
+	if err := writeFileAtomic(path, content, mode, uid, gid); err != nil {
+		return "", fmt.Errorf("write rules file: %w", err)
+	}
+
+	return path, drift.Record(drift.KindRules, path, content)
+}
+
+// writeFileAtomic writes a file under a hidden temporary name in its
+// directory, sets its mode and owner (uid and gid -1 keep them), and
+// renames it into place, so Prometheus never loads a partly written file
+func writeFileAtomic(path string, content []byte, mode os.FileMode, uid, gid int) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	err := os.WriteFile(tmp, content, mode)
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil && (uid >= 0 || gid >= 0) {
+		err = os.Chown(tmp, uid, gid)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// NamespaceModes returns the modes of a namespace's rule files and
+// directory.
+func NamespaceModes(ns config.RuleNamespace) (file, dir os.FileMode, err error) {
+	file = os.FileMode(defaultNamespaceFileMode)
+	if ns.Mode != "" {
+		m, err := strconv.ParseUint(ns.Mode, 8, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse mode for namespace %s: %w", ns.Name, err)
+		}
+		file = os.FileMode(m)
+	}
+	// Directories need the execute bit wherever the file grants read access.
+	return file, file | (file&0444)>>2 | 0700, nil
+}
+
+// NamespacePermissionProblems returns how the directory and rule files of
+// a namespace differ from the ownership and modes WriteRuleFile gives
+// them. A namespace without a directory yet has none.
+func NamespacePermissionProblems(ns config.RuleNamespace) ([]string, error) {
+	mode, dirMode, err := NamespaceModes(ns)
+	if err != nil {
+		return nil, err
+	}
+	uid, gid, err := lookupOwner(ns.Owner, ns.Group)
+	if err != nil {
+		return nil, fmt.Errorf("resolve owner for namespace %s: %w", ns.Name, err)
+	}
+
+	dir := filepath.Join(RulesDir, ns.Name)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var paths []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+
+	group := ns.Group
+	if group == "" {
+		group = "the primary group of " + ns.Owner
+	}
+
+	var problems []string
+	check := func(path string, want os.FileMode) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if got := info.Mode().Perm(); got != want {
+			problems = append(problems, fmt.Sprintf("%s has mode %04o, want %04o", path, got, want))
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if uid >= 0 && int(st.Uid) != uid {
+				problems = append(problems, fmt.Sprintf("%s is owned by uid %d, want %s", path, st.Uid, ns.Owner))
+			}
+			if gid >= 0 && int(st.Gid) != gid {
+				problems = append(problems, fmt.Sprintf("%s has gid %d, want %s", path, st.Gid, group))
+			}
+		}
+		return nil
+	}
+	if err := check(dir, dirMode); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if err := check(path, mode); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// RuleKey returns the storage key of a rule file under RulesDir: its path
+// relative to RulesDir, so namespaces keep their directories in a bucket.
+func RuleKey(path string) (string, error) {
+	rel, err := filepath.Rel(RulesDir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not under %s", path, RulesDir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// LocalRuleFiles returns the rule files in RulesDir and its namespace
+// directories, by storage key.
+func LocalRuleFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, pattern := range []string{"*.yaml", "*.yml", "*/*.yaml", "*/*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(RulesDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			key, err := RuleKey(path)
+			if err != nil {
+				return nil, err
+			}
+			files[key] = path
+		}
+	}
+	return files, nil
+}
+
+// lookupOwner resolves user and group names to ids; -1 leaves them unchanged.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		if group == "" {
+			gid, _ = strconv.Atoi(u.Gid)
+		}
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
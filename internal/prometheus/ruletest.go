@@ -0,0 +1,148 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleTestsDir holds user-written promtool unit tests, one subdirectory per
+// rule group (e.g. /etc/aami/rule-tests/gpu-basic/*.yaml). It is outside
+// RulesDir so Prometheus does not load the tests as rule files.
+const RuleTestsDir = "/etc/aami/rule-tests"
+
+// SeriesInput is an input series of a rule unit test, in promtool notation
+type SeriesInput struct {
+	Series string `yaml:"series" json:"series"`
+	Values string `yaml:"values" json:"values"`
+}
+
+// ExpectedAlert is an alert a unit test expects to be firing
+type ExpectedAlert struct {
+	Labels      map[string]string `yaml:"exp_labels" json:"exp_labels"`
+	Annotations map[string]string `yaml:"exp_annotations,omitempty" json:"exp_annotations,omitempty"`
+}
+
+// AlertTest checks the alerts of one rule at one point in time
+type AlertTest struct {
+	EvalTime  string          `yaml:"eval_time" json:"eval_time"`
+	Alertname string          `yaml:"alertname" json:"alertname"`
+	Alerts    []ExpectedAlert `yaml:"exp_alerts" json:"exp_alerts"`
+}
+
+// RuleTestCase is a promtool test case: input series and expected alerts
+type RuleTestCase struct {
+	Interval string        `yaml:"interval" json:"interval"`
+	Input    []SeriesInput `yaml:"input_series" json:"input_series"`
+	Alerts   []AlertTest   `yaml:"alert_rule_test" json:"alert_rule_test"`
+}
+
+// RenderRuleTests renders a promtool test file for the rule file ruleFile
+func RenderRuleTests(ruleFile string, cases []RuleTestCase) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(struct {
+		RuleFiles          []string       `yaml:"rule_files"`
+		EvaluationInterval string         `yaml:"evaluation_interval"`
+		Tests              []RuleTestCase `yaml:"tests"`
+	}{[]string{ruleFile}, "1m", cases})
+	if err != nil {
+		return nil, fmt.Errorf("marshal rule tests: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// LoadRuleTests reads the user-written test files of a rule group, keyed by
+// file name. Their rule_files are pointed at ruleFile, so the tests run
+// against the rules as AAMI generates them.
+func LoadRuleTests(group, ruleFile string) (map[string][]byte, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(RuleTestsDir, group, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	tests := map[string][]byte{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("read rule tests: %w", err)
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parse rule tests %s: %w", f, err)
+		}
+		if doc == nil {
+			continue
+		}
+		doc["rule_files"] = []string{ruleFile}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("marshal rule tests %s: %w", f, err)
+		}
+		tests[filepath.Base(f)] = data
+	}
+	return tests, nil
+}
+
+// TestRules runs 'promtool test rules' on test files (name -> content)
+// against a rule file. Everything is written to a temporary directory that
+// is removed afterwards.
+func TestRules(ctx context.Context, ruleFile string, rules []byte, tests map[string][]byte) (*RuleCheck, error) {
+	promtool, err := exec.LookPath("promtool")
+	if err != nil {
+		return &RuleCheck{Skipped: true, Output: "promtool not found in PATH"}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "aami-rule-tests-")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, ruleFile), rules, 0644); err != nil {
+		return nil, fmt.Errorf("write rules file: %w", err)
+	}
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"test", "rules"}
+	for _, name := range names {
+		content := tests[name]
+		if name == ruleFile {
+			name = "test-" + name
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return nil, fmt.Errorf("write rule tests: %w", err)
+		}
+		args = append(args, name)
+	}
+
+	cmd := exec.CommandContext(ctx, promtool, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("run promtool: %w", err)
+	}
+	return &RuleCheck{
+		Valid:  err == nil,
+		Output: strings.ReplaceAll(string(out), dir+string(filepath.Separator), ""),
+	}, nil
+}
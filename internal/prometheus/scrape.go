@@ -0,0 +1,167 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// ScrapeTarget is an exporter to scrape, with the settings its scrape job
+// needs
+type ScrapeTarget struct {
+	Job      string // exporter type, e.g. node or gpu_health
+	Address  string // host:port
+	Node     string
+	Path     string
+	Scheme   string
+	TLS      *TLSConfig
+	Interval string
+	Timeout  string
+	Labels   map[string]string
+}
+
+// TLSConfig is the tls_config of a scrape job
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+}
+
+// RelabelConfig is a relabel_configs entry of a scrape job
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,omitempty" json:"source_labels,omitempty"`
+	Regex        string   `yaml:"regex,omitempty" json:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty" json:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty" json:"action,omitempty"`
+}
+
+// ScrapeConfig is a scrape_configs entry of prometheus.yml
+type ScrapeConfig struct {
+	JobName        string          `yaml:"job_name" json:"job_name"`
+	ScrapeInterval string          `yaml:"scrape_interval,omitempty" json:"scrape_interval,omitempty"`
+	ScrapeTimeout  string          `yaml:"scrape_timeout,omitempty" json:"scrape_timeout,omitempty"`
+	MetricsPath    string          `yaml:"metrics_path,omitempty" json:"metrics_path,omitempty"`
+	Params         url.Values      `yaml:"params,omitempty" json:"params,omitempty"`
+	Scheme         string          `yaml:"scheme,omitempty" json:"scheme,omitempty"`
+	TLSConfig      *TLSConfig      `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+	StaticConfigs  []Target        `yaml:"static_configs" json:"static_configs"`
+	RelabelConfigs []RelabelConfig `yaml:"relabel_configs,omitempty" json:"relabel_configs,omitempty"`
+}
+
+// Default exporter ports of nodes
+const (
+	NodeExporterPort = 9100
+	DCGMExporterPort = 9400
+)
+
+// NodeScrapeTargets returns the node_exporter and dcgm_exporter of nodes,
+// as the file_sd targets list them
+func NodeScrapeTargets(nodes []config.NodeConfig) []ScrapeTarget {
+	var targets []ScrapeTarget
+	for _, job := range []struct {
+		name string
+		port int
+	}{{"node", NodeExporterPort}, {"dcgm", DCGMExporterPort}} {
+		for _, node := range nodes {
+			targets = append(targets, ScrapeTarget{
+				Job:     job.name,
+				Address: fmt.Sprintf("%s:%d", node.IP, job.port),
+				Node:    node.Name,
+				Labels:  node.Labels,
+			})
+		}
+	}
+	return targets
+}
+
+// ScrapeConfigs returns one scrape job per exporter type. Exporters of a
+// type whose scheme, path, TLS or intervals differ get a job of their own,
+// named <type>-2 and so on; relabeling sets the job label back to the type
+// so rules and dashboards see a single job.
+func ScrapeConfigs(targets []ScrapeTarget) []ScrapeConfig {
+	byKey := map[string]*ScrapeConfig{}
+	var keys []string
+	for _, t := range targets {
+		key := scrapeKey(t)
+		sc, ok := byKey[key]
+		if !ok {
+			sc = &ScrapeConfig{
+				ScrapeInterval: t.Interval,
+				ScrapeTimeout:  t.Timeout,
+				MetricsPath:    t.Path,
+				Scheme:         t.Scheme,
+				TLSConfig:      t.TLS,
+				RelabelConfigs: []RelabelConfig{{TargetLabel: "job", Replacement: t.Job}},
+			}
+			byKey[key] = sc
+			keys = append(keys, key)
+		}
+
+		labels := map[string]string{}
+		for k, v := range t.Labels {
+			labels[k] = v
+		}
+		if t.Node != "" {
+			labels["node"] = t.Node
+		}
+		sc.StaticConfigs = append(sc.StaticConfigs, Target{Targets: []string{t.Address}, Labels: labels})
+	}
+
+	// Jobs of a type are numbered in key order, so names stay put as
+	// targets come and go
+	sort.Strings(keys)
+	configs := make([]ScrapeConfig, 0, len(keys))
+	count := map[string]int{}
+	for _, key := range keys {
+		sc := byKey[key]
+		job := sc.RelabelConfigs[0].Replacement
+		count[job]++
+		sc.JobName = job
+		if count[job] > 1 {
+			sc.JobName = fmt.Sprintf("%s-%d", job, count[job])
+		}
+		sort.Slice(sc.StaticConfigs, func(i, j int) bool {
+			return sc.StaticConfigs[i].Targets[0] < sc.StaticConfigs[j].Targets[0]
+		})
+		configs = append(configs, *sc)
+	}
+	return configs
+}
+
+// scrapeKey identifies the scrape job of a target
+func scrapeKey(t ScrapeTarget) string {
+	tls := ""
+	if t.TLS != nil {
+		tls = fmt.Sprintf("%+v", *t.TLS)
+	}
+	return strings.Join([]string{t.Job, t.Scheme, t.Path, t.Interval, t.Timeout, tls}, "\x00")
+}
+
+// RenderScrapeConfigs renders scrape jobs as a prometheus.yml fragment,
+// for scrape_config_files or to paste under scrape_configs
+func RenderScrapeConfigs(configs []ScrapeConfig) ([]byte, error) {
+	if configs == nil {
+		configs = []ScrapeConfig{}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by AAMI - Do not edit manually\n")
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(struct {
+		ScrapeConfigs []ScrapeConfig `yaml:"scrape_configs"`
+	}{configs})
+	if err != nil {
+		return nil, fmt.Errorf("marshal scrape configs: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package queryproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// maxCacheEntries bounds the memory used by cached results
+const maxCacheEntries = 1000
+
+// cacheEntry is a successful query response and its cost, so limits can be
+// checked again for tokens with lower limits.
+type cacheEntry struct {
+	body    []byte
+	series  int
+	samples int
+	expires time.Time
+}
+
+// cache keeps responses of identical queries for a short time, so a
+// dashboard opened by many users queries Prometheus once.
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: make(map[string]cacheEntry)}
+}
+
+// cacheKey identifies a query by its path and parameters.
+func cacheKey(path string, params url.Values) string {
+	sum := sha256.Sum256([]byte(path + "?" + params.Encode()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *cache) get(key string, now time.Time) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *cache) put(key string, e cacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		for k, old := range c.entries {
+			if !now.Before(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= maxCacheEntries {
+		// Still full of live entries: make room by dropping any one
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = e
+}
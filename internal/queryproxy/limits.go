@@ -0,0 +1,168 @@
+package queryproxy
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Limits are the parsed limits of a token; zero means unlimited.
+type Limits struct {
+	MaxRange   time.Duration
+	MinStep    time.Duration
+	MaxSeries  int
+	MaxSamples int
+}
+
+// ParseLimits parses the limits of a configured token.
+func ParseLimits(t config.QueryToken) (Limits, error) {
+	l := Limits{MaxSeries: t.MaxSeries, MaxSamples: t.MaxSamples}
+	var err error
+	if t.MaxRange != "" {
+		if l.MaxRange, err = ParseDuration(t.MaxRange); err != nil {
+			return Limits{}, fmt.Errorf("token %s: max_range: %w", t.Name, err)
+		}
+	}
+	if t.MinStep != "" {
+		if l.MinStep, err = ParseDuration(t.MinStep); err != nil {
+			return Limits{}, fmt.Errorf("token %s: min_step: %w", t.Name, err)
+		}
+	}
+	return l, nil
+}
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+var durationPart = regexp.MustCompile(`^([0-9]+)(ms|s|m|h|d|w|y)`)
+
+// ParseDuration parses a Prometheus duration such as "30s", "7d" or "1h30m".
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	var d time.Duration
+	for rest := s; rest != ""; {
+		m := durationPart.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		d += time.Duration(n) * durationUnits[m[2]]
+		rest = rest[len(m[0]):]
+	}
+	return d, nil
+}
+
+// parseStep parses a query_range step, given as a duration or in seconds.
+func parseStep(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return ParseDuration(s)
+}
+
+// parseTime parses a query time, given as RFC 3339 or a Unix timestamp.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// rangeSelector matches range vector selectors and subqueries, e.g. [5m]
+// or [1d:1m]; offsets are not counted.
+var rangeSelector = regexp.MustCompile(`\[\s*([0-9a-z]+)\s*(?::[^\]]*)?\]`)
+
+// longestRange returns the longest range selector in a query.
+func longestRange(query string) time.Duration {
+	var longest time.Duration
+	for _, m := range rangeSelector.FindAllStringSubmatch(query, -1) {
+		if d, err := ParseDuration(m[1]); err == nil && d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// CheckQuery rejects a query before it reaches Prometheus. For range
+// queries, start, end and step are the request parameters; they are empty
+// for instant queries.
+func (l Limits) CheckQuery(query, start, end, step string) error {
+	if l.MaxRange > 0 {
+		if r := longestRange(query); r > l.MaxRange {
+			return fmt.Errorf("range selector [%s] exceeds the limit of %s", formatDuration(r), formatDuration(l.MaxRange))
+		}
+	}
+
+	if start == "" && end == "" && step == "" {
+		return nil
+	}
+
+	from, err := parseTime(start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	to, err := parseTime(end)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if l.MaxRange > 0 && to.Sub(from) > l.MaxRange {
+		return fmt.Errorf("query range of %s exceeds the limit of %s", formatDuration(to.Sub(from)), formatDuration(l.MaxRange))
+	}
+
+	res, err := parseStep(step)
+	if err != nil {
+		return fmt.Errorf("step: %w", err)
+	}
+	if l.MinStep > 0 && res < l.MinStep {
+		return fmt.Errorf("step of %s is finer than the limit of %s", formatDuration(res), formatDuration(l.MinStep))
+	}
+	return nil
+}
+
+// CheckResult rejects a result with too many series or samples.
+func (l Limits) CheckResult(series, samples int) error {
+	if l.MaxSeries > 0 && series > l.MaxSeries {
+		return fmt.Errorf("query returned %d series, the limit is %d", series, l.MaxSeries)
+	}
+	if l.MaxSamples > 0 && samples > l.MaxSamples {
+		return fmt.Errorf("query loaded %d samples, the limit is %d", samples, l.MaxSamples)
+	}
+	return nil
+}
+
+// formatDuration prints durations of whole days as days, like Prometheus.
+func formatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return fmt.Sprintf("%dd", d/day)
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,323 @@
+// Package queryproxy fronts Prometheus for dashboards and scripts with
+// per-token query cost limits, a deny-list of expensive queries and a short
+// cache of identical queries, so one dashboard cannot overload a shared
+// Prometheus.
+package queryproxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// DefaultCacheTTL is used when query_proxy.cache_ttl is not set
+const DefaultCacheTTL = 30 * time.Second
+
+// maxResponseSize limits the Prometheus responses the proxy buffers
+const maxResponseSize = 256 << 20
+
+// queryPaths are limited and cached; readPaths are only passed through.
+// Paths are relative to /api/v1.
+var (
+	queryPaths = map[string]bool{"/query": true, "/query_range": true}
+	readPaths  = map[string]bool{"/series": true, "/labels": true, "/metadata": true, "/status/buildinfo": true}
+)
+
+// Proxy serves the Prometheus query API under /api/v1.
+type Proxy struct {
+	load     func() (*config.Config, error)
+	upstream string
+	client   *http.Client
+	cache    *cache
+	now      func() time.Time
+}
+
+// New creates a proxy to the Prometheus at prometheusURL. The config is
+// loaded on every request so token and limit changes apply without a
+// restart.
+func New(load func() (*config.Config, error), prometheusURL string) *Proxy {
+	return &Proxy{
+		load:     load,
+		upstream: strings.TrimSuffix(prometheusURL, "/"),
+		client:   &http.Client{Timeout: 2 * time.Minute},
+		cache:    newCache(),
+		now:      time.Now,
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "bad_data", "method not allowed")
+		return
+	}
+	path := r.URL.Path
+	if !queryPaths[path] && !readPaths[path] && !strings.HasPrefix(path, "/label/") {
+		writeError(w, http.StatusNotFound, "not_found", fmt.Sprintf("%s is not served by the query proxy", path))
+		return
+	}
+
+	cfg, err := p.load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	token, err := authenticate(cfg, r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized", err.Error())
+		return
+	}
+	limits, err := ParseLimits(token)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+
+	// Selectors are checked against the deny-list wherever they appear
+	selectors := append([]string{r.Form.Get("query")}, r.Form["match[]"]...)
+	for _, s := range selectors {
+		if err := checkDenied(cfg.QueryProxy.Deny, s); err != nil {
+			writeError(w, http.StatusForbidden, "denied", err.Error())
+			return
+		}
+	}
+
+	if queryPaths[path] {
+		p.query(w, r, cfg, limits)
+		return
+	}
+
+	resp, err := p.forward(r, r.Form)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "unavailable", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// query runs an instant or range query within the token's limits.
+func (p *Proxy) query(w http.ResponseWriter, r *http.Request, cfg *config.Config, limits Limits) {
+	params := r.Form
+	var err error
+	if r.URL.Path == "/query_range" {
+		err = limits.CheckQuery(params.Get("query"), params.Get("start"), params.Get("end"), params.Get("step"))
+	} else {
+		err = limits.CheckQuery(params.Get("query"), "", "", "")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_data", err.Error())
+		return
+	}
+
+	ttl, err := cacheTTL(cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	key := cacheKey(r.URL.Path, params)
+	if ttl > 0 {
+		if e, ok := p.cache.get(key, p.now()); ok {
+			if err := limits.CheckResult(e.series, e.samples); err != nil {
+				writeError(w, http.StatusUnprocessableEntity, "execution", err.Error())
+				return
+			}
+			writeJSON(w, "hit", e.body)
+			return
+		}
+	}
+
+	// Query stats are always requested, to count the samples loaded
+	upstream := url.Values{}
+	for k, v := range params {
+		upstream[k] = v
+	}
+	upstream.Set("stats", "all")
+
+	resp, err := p.forward(r, upstream)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "unavailable", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "unavailable", fmt.Sprintf("read response: %v", err))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		return
+	}
+
+	body, series, samples, err := measure(body, params.Get("stats") != "")
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "unavailable", err.Error())
+		return
+	}
+	if ttl > 0 {
+		p.cache.put(key, cacheEntry{body: body, series: series, samples: samples, expires: p.now().Add(ttl)}, p.now())
+	}
+	if err := limits.CheckResult(series, samples); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "execution", err.Error())
+		return
+	}
+	writeJSON(w, "miss", body)
+}
+
+// forward sends a request to Prometheus with the given parameters.
+func (p *Proxy) forward(r *http.Request, params url.Values) (*http.Response, error) {
+	endpoint := p.upstream + "/api/v1" + r.URL.Path
+
+	var req *http.Request
+	var err error
+	if r.Method == http.MethodPost {
+		req, err = http.NewRequestWithContext(r.Context(), http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus query failed: %w", err)
+	}
+	return resp, nil
+}
+
+// measure counts the series and loaded samples of a query response, and
+// drops the query stats unless the client asked for them.
+func measure(body []byte, keepStats bool) ([]byte, int, int, error) {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, 0, 0, fmt.Errorf("parse response: %w", err)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(resp["data"], &data); err != nil {
+		return nil, 0, 0, fmt.Errorf("parse response data: %w", err)
+	}
+
+	var resultType string
+	json.Unmarshal(data["resultType"], &resultType)
+
+	series := 0
+	if resultType == "vector" || resultType == "matrix" {
+		var result []json.RawMessage
+		if err := json.Unmarshal(data["result"], &result); err != nil {
+			return nil, 0, 0, fmt.Errorf("parse response result: %w", err)
+		}
+		series = len(result)
+	}
+
+	// Older Prometheus versions ignore stats=all; samples are then 0
+	var stats struct {
+		Samples struct {
+			TotalQueryableSamples int `json:"totalQueryableSamples"`
+		} `json:"samples"`
+	}
+	if raw, ok := data["stats"]; ok {
+		json.Unmarshal(raw, &stats)
+	}
+
+	if !keepStats {
+		if _, ok := data["stats"]; ok {
+			delete(data, "stats")
+			raw, err := json.Marshal(data)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			resp["data"] = raw
+			if body, err = json.Marshal(resp); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+	}
+	return body, series, stats.Samples.TotalQueryableSamples, nil
+}
+
+// authenticate returns the configured token of a request's bearer token.
+func authenticate(cfg *config.Config, r *http.Request) (config.QueryToken, error) {
+	if len(cfg.QueryProxy.Tokens) == 0 {
+		return config.QueryToken{}, fmt.Errorf("query proxy tokens are not configured")
+	}
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer == "" {
+		return config.QueryToken{}, fmt.Errorf("missing bearer token")
+	}
+	for _, t := range cfg.QueryProxy.Tokens {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(bearer)) == 1 {
+			return t, nil
+		}
+	}
+	return config.QueryToken{}, fmt.Errorf("invalid token")
+}
+
+// checkDenied rejects a query matching a deny-list pattern.
+func checkDenied(patterns []string, query string) error {
+	if query == "" {
+		return nil
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		if re.MatchString(query) {
+			return fmt.Errorf("query matches denied pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// cacheTTL returns how long identical queries are cached.
+func cacheTTL(cfg *config.Config) (time.Duration, error) {
+	switch cfg.QueryProxy.CacheTTL {
+	case "":
+		return DefaultCacheTTL, nil
+	case "0":
+		return 0, nil
+	}
+	ttl, err := ParseDuration(cfg.QueryProxy.CacheTTL)
+	if err != nil {
+		return 0, fmt.Errorf("query_proxy.cache_ttl: %w", err)
+	}
+	return ttl, nil
+}
+
+func writeJSON(w http.ResponseWriter, cacheStatus string, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-AAMI-Cache", cacheStatus)
+	w.Write(body)
+}
+
+// writeError answers in the Prometheus API error format, so dashboards
+// show the reason.
+func writeError(w http.ResponseWriter, status int, errorType, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     msg,
+	})
+}
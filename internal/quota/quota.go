@@ -0,0 +1,100 @@
+// Package quota enforces the per-namespace limits of alerts.namespaces:
+// targets, rule groups and alert rules.
+package quota
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Resources a namespace quota limits
+const (
+	ResourceTargets = "targets"
+	ResourceGroups  = "groups"
+	ResourceRules   = "alert_rules"
+)
+
+// ExceededError reports a change that would take a namespace over its
+// quota. It is returned as is, so callers can tell it apart with errors.As.
+type ExceededError struct {
+	Namespace string `json:"namespace"`
+	Resource  string `json:"resource"`
+	Limit     int    `json:"limit"`
+	Used      int    `json:"used"`      // before the change
+	Requested int    `json:"requested"` // after the change
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("namespace %s: %s quota exceeded: %d requested, limit %d (%d in use)",
+		e.Namespace, e.Resource, e.Requested, e.Limit, e.Used)
+}
+
+// Check returns an ExceededError if going from used to requested takes a
+// resource over limit. Changes that do not add to a resource are always
+// allowed, so a lowered quota does not block cleaning up.
+func Check(namespace, resource string, limit, used, requested int) error {
+	if limit <= 0 || requested <= limit || requested <= used {
+		return nil
+	}
+	return &ExceededError{Namespace: namespace, Resource: resource,
+		Limit: limit, Used: used, Requested: requested}
+}
+
+// Resource is the consumption of one resource
+type Resource struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"` // 0: unlimited
+}
+
+// Usage is the consumption of a namespace's quota
+type Usage struct {
+	Namespace string   `json:"namespace"`
+	Targets   Resource `json:"targets"`
+	Groups    Resource `json:"groups"`
+	Rules     Resource `json:"alert_rules"`
+}
+
+// Targets counts the nodes of each namespace, by the namespace label.
+func Targets(cfg *config.Config) map[string]int {
+	counts := map[string]int{}
+	for _, n := range cfg.Nodes {
+		if ns := n.Labels[config.NamespaceLabel]; ns != "" {
+			counts[ns]++
+		}
+	}
+	return counts
+}
+
+// CheckTargets returns an ExceededError if after has more nodes in a
+// namespace than both its quota and before allow.
+func CheckTargets(before, after *config.Config) error {
+	var old map[string]int
+	if before != nil {
+		old = Targets(before)
+	}
+	counts := Targets(after)
+	names := make([]string, 0, len(counts))
+	for ns := range counts {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit := findQuota(after, name).MaxTargets
+		if err := Check(name, ResourceTargets, limit, old[name], counts[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findQuota returns the quota of a namespace; unconfigured namespaces have none
+func findQuota(cfg *config.Config, name string) config.NamespaceQuota {
+	for _, ns := range cfg.Alerts.Namespaces {
+		if ns.Name == name {
+			return ns.Quota
+		}
+	}
+	return config.NamespaceQuota{}
+}
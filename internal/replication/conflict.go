@@ -0,0 +1,299 @@
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultConflictDir holds conflicts queued for manual review. Like the
+// replica state, it is outside the replicated paths.
+const DefaultConflictDir = "/var/lib/aami/conflicts"
+
+// Strategy decides what an import does with a file that was changed on
+// this node and differs from the primary's version.
+type Strategy string
+
+const (
+	// StrategyTheirs takes the primary's version (the default)
+	StrategyTheirs Strategy = "theirs"
+	// StrategyOurs keeps the local version until the primary changes the
+	// file again
+	StrategyOurs Strategy = "ours"
+	// StrategyNewest keeps whichever version was modified last. A file
+	// deleted on the primary has no modification time, so the local
+	// version is kept.
+	StrategyNewest Strategy = "newest"
+	// StrategyManual keeps the local version and queues the conflict
+	// for review
+	StrategyManual Strategy = "manual"
+)
+
+// Strategies are the valid conflict strategies
+var Strategies = []Strategy{StrategyTheirs, StrategyOurs, StrategyNewest, StrategyManual}
+
+// Entity types that can be given their own conflict strategy
+const (
+	EntityConfig        = "config"
+	EntityRules         = "rules"
+	EntityNotifications = "notifications"
+	EntityTargets       = "targets"
+	EntityIncidents     = "incidents"
+	EntityManifest      = "manifest"
+	EntityOther         = "other"
+)
+
+// EntityTypes are the valid entity types
+var EntityTypes = []string{
+	EntityConfig, EntityRules, EntityNotifications, EntityTargets,
+	EntityIncidents, EntityManifest, EntityOther,
+}
+
+// EntityType classifies a replicated file
+func EntityType(path string) string {
+	switch {
+	case path == "/etc/aami/config.yaml":
+		return EntityConfig
+	case strings.HasPrefix(path, "/etc/aami/rules/"):
+		return EntityRules
+	case strings.HasPrefix(path, "/etc/aami/alertmanager/"),
+		strings.HasPrefix(path, "/etc/aami/templates/"):
+		return EntityNotifications
+	case strings.HasPrefix(path, "/var/lib/aami/targets/"):
+		return EntityTargets
+	case strings.HasPrefix(path, "/var/lib/aami/incidents/"):
+		return EntityIncidents
+	case strings.HasPrefix(path, "/var/lib/aami/manifest"):
+		return EntityManifest
+	default:
+		return EntityOther
+	}
+}
+
+// ParseStrategies validates a map of entity type to strategy name, as
+// given in replication.conflicts.
+func ParseStrategies(raw map[string]string) (map[string]Strategy, error) {
+	strategies := make(map[string]Strategy, len(raw))
+	for entity, name := range raw {
+		if !validEntity(entity) {
+			return nil, fmt.Errorf("unknown entity type %q (valid: %s)", entity, strings.Join(EntityTypes, ", "))
+		}
+		s := Strategy(name)
+		if !validStrategy(s) {
+			return nil, fmt.Errorf("unknown conflict strategy %q for %s (valid: theirs, ours, newest, manual)", name, entity)
+		}
+		strategies[entity] = s
+	}
+	return strategies, nil
+}
+
+func validEntity(entity string) bool {
+	for _, e := range EntityTypes {
+		if e == entity {
+			return true
+		}
+	}
+	return false
+}
+
+func validStrategy(s Strategy) bool {
+	for _, v := range Strategies {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Conflict is a file changed on this node whose primary version differs.
+type Conflict struct {
+	ID         string      `json:"id"`
+	Path       string      `json:"path"`
+	Entity     string      `json:"entity"`
+	DetectedAt time.Time   `json:"detected_at"`
+	LocalHash  string      `json:"local_hash,omitempty"`  // empty if missing locally
+	TheirsHash string      `json:"theirs_hash,omitempty"` // empty if deleted on the primary
+	Mode       fs.FileMode `json:"mode,omitempty"`
+}
+
+// TheirsDeleted reports whether the primary deleted the file
+func (c *Conflict) TheirsDeleted() bool {
+	return c.TheirsHash == ""
+}
+
+// conflictID is stable for a path and primary version, so a conflict that
+// is detected again on the next sync is not queued twice
+func conflictID(path, theirsHash string) string {
+	sum := sha256.Sum256([]byte(path + "\x00" + theirsHash))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ConflictQueue stores conflicts awaiting manual review. Each conflict is a
+// <id>.json file, with the primary's content next to it in <id>.theirs.
+type ConflictQueue struct {
+	dir string
+}
+
+// NewConflictQueue creates a queue in dir
+func NewConflictQueue(dir string) *ConflictQueue {
+	return &ConflictQueue{dir: dir}
+}
+
+// Add queues a conflict. It is a no-op if the conflict is already queued.
+func (q *ConflictQueue) Add(c Conflict, theirs []byte) error {
+	metaPath := filepath.Join(q.dir, c.ID+".json")
+	if _, err := os.Stat(metaPath); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return fmt.Errorf("create conflict directory: %w", err)
+	}
+	// Content first, so a queued conflict always has it
+	if !c.TheirsDeleted() {
+		if err := os.WriteFile(filepath.Join(q.dir, c.ID+".theirs"), theirs, 0600); err != nil {
+			return fmt.Errorf("write conflict: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal conflict: %w", err)
+	}
+	if err := os.WriteFile(metaPath, data, 0600); err != nil {
+		return fmt.Errorf("write conflict: %w", err)
+	}
+	return nil
+}
+
+// List returns the queued conflicts, oldest first
+func (q *ConflictQueue) List() ([]Conflict, error) {
+	matches, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("list conflicts: %w", err)
+	}
+	conflicts := make([]Conflict, 0, len(matches))
+	for _, path := range matches {
+		c, err := q.load(path)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, *c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if !conflicts[i].DetectedAt.Equal(conflicts[j].DetectedAt) {
+			return conflicts[i].DetectedAt.Before(conflicts[j].DetectedAt)
+		}
+		return conflicts[i].Path < conflicts[j].Path
+	})
+	return conflicts, nil
+}
+
+// Get returns a queued conflict and the primary's content
+func (q *ConflictQueue) Get(id string) (*Conflict, []byte, error) {
+	c, err := q.load(filepath.Join(q.dir, filepath.Base(id)+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("conflict not found: %s", id)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.TheirsDeleted() {
+		return c, nil, nil
+	}
+	theirs, err := os.ReadFile(filepath.Join(q.dir, c.ID+".theirs"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read conflict: %w", err)
+	}
+	return c, theirs, nil
+}
+
+// Remove drops a conflict from the queue
+func (q *ConflictQueue) Remove(id string) error {
+	for _, ext := range []string{".json", ".theirs"} {
+		if err := os.Remove(filepath.Join(q.dir, id+ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove conflict: %w", err)
+		}
+	}
+	return nil
+}
+
+// prune removes queued conflicts that were not detected again, because
+// they were resolved or the primary changed the file since
+func (q *ConflictQueue) prune(detected map[string]bool) error {
+	conflicts, err := q.List()
+	if err != nil {
+		return err
+	}
+	for _, c := range conflicts {
+		if !detected[c.ID] {
+			if err := q.Remove(c.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (q *ConflictQueue) load(path string) (*Conflict, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Conflict
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse conflict %s: %w", filepath.Base(path), err)
+	}
+	return &c, nil
+}
+
+// Resolve settles a queued conflict. Taking theirs writes (or deletes) the
+// primary's version; taking ours keeps the local file and records that
+// choice in the replica state, so it holds until the primary changes the
+// file again.
+func Resolve(q *ConflictQueue, statePath, id string, take Strategy) (*Conflict, error) {
+	c, theirs, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch take {
+	case StrategyTheirs:
+		if c.TheirsDeleted() {
+			if err := os.Remove(c.Path); err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("remove %s: %w", c.Path, err)
+			}
+		} else if err := writeFileAtomic(c.Path, theirs, c.Mode, time.Time{}); err != nil {
+			return nil, err
+		}
+
+	case StrategyOurs:
+		st, err := LoadState(statePath)
+		if err != nil {
+			return nil, err
+		}
+		if st == nil {
+			return nil, fmt.Errorf("this node is not a replica")
+		}
+		if st.Accepted == nil {
+			st.Accepted = map[string]string{}
+		}
+		st.Accepted[c.Path] = c.TheirsHash
+		if err := st.Save(statePath); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("resolve by taking ours or theirs, not %q", take)
+	}
+
+	if err := q.Remove(c.ID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
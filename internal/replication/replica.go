@@ -0,0 +1,258 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultStatePath marks a node as a replica. It is outside the replicated
+// paths, so it is never overwritten by the primary's state.
+const DefaultStatePath = "/var/lib/aami/replica.json"
+
+// State is a replica's view of its primary.
+type State struct {
+	Primary       string    `json:"primary"`
+	Revision      string    `json:"revision,omitempty"`
+	LocalRevision string    `json:"local_revision,omitempty"` // after the last import
+	AppliedAt     time.Time `json:"applied_at,omitempty"`     // last import
+	LastSyncAt    time.Time `json:"last_sync_at,omitempty"`   // last successful poll
+	LastPollAt    time.Time `json:"last_poll_at,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	FilesWritten  int       `json:"files_written"`
+	FilesRemoved  int       `json:"files_removed"`
+	FilesKept     int       `json:"files_kept"`
+	Conflicts     int       `json:"conflicts"` // queued for manual review
+
+	// Files and Accepted carry ImportOptions.Base and Accepted between imports
+	Files    map[string]string `json:"files,omitempty"`
+	Accepted map[string]string `json:"accepted,omitempty"`
+}
+
+// LoadState returns the replica state, or nil if this node is not a replica.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read replica state: %w", err)
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parse replica state: %w", err)
+	}
+	return &st, nil
+}
+
+// Save writes the replica state atomically.
+func (s *State) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal replica state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write replica state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Promote turns a replica into a primary by removing its replica state.
+func Promote(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove replica state: %w", err)
+	}
+	return nil
+}
+
+// revisionResponse is served by the primary at /api/v1/replication/revision
+type revisionResponse struct {
+	Revision string `json:"revision"`
+}
+
+// Handler serves the primary's revision and snapshot to replicas.
+type Handler struct {
+	token string
+	paths []string
+}
+
+// NewHandler creates a primary-side handler. Requests must carry
+// "Authorization: Bearer <token>".
+func NewHandler(token string, paths []string) *Handler {
+	return &Handler{token: token, paths: paths}
+}
+
+// ServeHTTP handles GET /replication/revision and GET /replication/snapshot
+// (under /api/v1).
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.token)) != 1 {
+		http.Error(w, "invalid replication token", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/replication/revision":
+		rev, err := Revision(h.paths)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisionResponse{Revision: rev})
+
+	case "/replication/snapshot":
+		rev, err := Revision(h.paths)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("X-AAMI-Revision", rev)
+		if err := WriteSnapshot(w, h.paths); err != nil {
+			// Headers are already sent; the replica sees a broken archive
+			// and keeps its current state
+			return
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Follower imports the primary's state whenever its revision changes.
+type Follower struct {
+	Primary    string
+	Token      string
+	Paths      []string
+	StatePath  string
+	Strategies map[string]Strategy // conflict strategy per entity type
+	Queue      *ConflictQueue
+	Client     *http.Client
+	Logf       func(format string, args ...interface{})
+}
+
+// Sync polls the primary once and imports a new snapshot if the revision
+// changed. The outcome is recorded in the replica state.
+func (f *Follower) Sync() error {
+	st, err := LoadState(f.StatePath)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		st = &State{}
+	}
+	st.Primary = f.Primary
+	st.LastPollAt = time.Now().UTC()
+
+	err = f.sync(st)
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	} else {
+		st.LastSyncAt = st.LastPollAt
+	}
+	if saveErr := st.Save(f.StatePath); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func (f *Follower) sync(st *State) error {
+	resp, err := f.get("/api/v1/replication/revision")
+	if err != nil {
+		return err
+	}
+	var rev revisionResponse
+	err = json.NewDecoder(resp.Body).Decode(&rev)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("parse revision: %w", err)
+	}
+	// Compare with the files on disk too, so local edits are noticed
+	local, err := Revision(f.Paths)
+	if err != nil {
+		return err
+	}
+	if rev.Revision == local || (rev.Revision == st.Revision && local == st.LocalRevision) {
+		st.Revision = rev.Revision
+		st.LocalRevision = local
+		return nil
+	}
+
+	resp, err = f.get("/api/v1/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result, err := ApplySnapshot(resp.Body, f.Paths, ImportOptions{
+		Base:       st.Files,
+		Accepted:   st.Accepted,
+		Strategies: f.Strategies,
+		Queue:      f.Queue,
+	})
+	if err != nil {
+		return err
+	}
+	local, err = Revision(f.Paths)
+	if err != nil {
+		return err
+	}
+
+	st.Revision = resp.Header.Get("X-AAMI-Revision")
+	st.LocalRevision = local
+	st.AppliedAt = time.Now().UTC()
+	st.FilesWritten = result.Written
+	st.FilesRemoved = result.Removed
+	st.FilesKept = result.Kept
+	st.Conflicts = len(result.Conflicts)
+	st.Files = result.Base
+	st.Accepted = result.Accepted
+	f.Logf("imported revision %.12s from %s (%d written, %d removed, %d kept)",
+		st.Revision, f.Primary, result.Written, result.Removed, result.Kept)
+	for _, c := range result.Conflicts {
+		f.Logf("conflict %s on %s queued for review", c.ID, c.Path)
+	}
+	return nil
+}
+
+func (f *Follower) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(f.Primary, "/")+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+f.Token)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("contact primary: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("primary returned %s for %s", resp.Status, path)
+	}
+	return resp, nil
+}
+
+// Lag returns how long ago the replica last confirmed it matches the
+// primary. It is zero if the replica never synced.
+func (s *State) Lag(now time.Time) time.Duration {
+	if s.LastSyncAt.IsZero() {
+		return 0
+	}
+	return now.Sub(s.LastSyncAt)
+}
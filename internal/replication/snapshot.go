@@ -0,0 +1,370 @@
+// Package replication mirrors AAMI state from a primary control node to
+// read-only replicas in other regions.
+//
+// The primary serves a logical export (a snapshot of the replicated paths)
+// and its revision. Replicas poll the revision and import a new snapshot
+// whenever it changes. A replica rejects local changes until it is promoted.
+package replication
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPaths are the files and directories replicated to a secondary.
+// Generated targets and the drift manifest come along so the replica's
+// Prometheus and `aami drift check` behave as on the primary.
+var DefaultPaths = []string{
+	"/etc/aami",
+	"/var/lib/aami/incidents",
+	"/var/lib/aami/targets",
+	"/var/lib/aami/manifest.json",
+	"/var/lib/aami/manifest-objects",
+}
+
+// maxSnapshotFileSize guards against importing unexpected large files
+const maxSnapshotFileSize = 64 << 20
+
+// file is a regular file under one of the replicated paths
+type file struct {
+	path    string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// listFiles returns the regular files under paths, sorted. Temporary files
+// written during atomic saves are skipped.
+func listFiles(paths []string) ([]file, error) {
+	var files []file
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() || strings.HasSuffix(path, ".tmp") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, file{path: path, mode: info.Mode().Perm(), modTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", root, err)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+// Revision returns a hash over the paths, modes and contents of every
+// replicated file. It changes whenever any replicated file changes.
+func Revision(paths []string) (string, error) {
+	files, err := listFiles(paths)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return "", fmt.Errorf("read %s: %w", f.path, err)
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s %o %x\n", f.path, f.mode, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteSnapshot writes every replicated file to w as a gzipped tar archive
+// with absolute paths (without the leading slash).
+func WriteSnapshot(w io.Writer, paths []string) error {
+	files, err := listFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", f.path, err)
+		}
+		header := &tar.Header{
+			Name:     strings.TrimPrefix(f.path, "/"),
+			Mode:     int64(f.mode),
+			Size:     int64(len(data)),
+			ModTime:  f.modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write snapshot: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("write snapshot: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return gz.Close()
+}
+
+// ImportOptions control how ApplySnapshot handles files changed on this
+// node.
+type ImportOptions struct {
+	// Base holds each file's content hash as of the previous import. A
+	// local file that differs from it was changed on this node.
+	Base map[string]string
+	// Accepted holds, per path, the primary's hash for which the local
+	// version was kept. An empty hash means the primary deleted the file.
+	Accepted map[string]string
+	// Strategies are keyed by entity type; missing types use StrategyTheirs
+	Strategies map[string]Strategy
+	// Queue receives conflicts for StrategyManual
+	Queue *ConflictQueue
+}
+
+// ImportResult summarizes an import.
+type ImportResult struct {
+	Written   int
+	Removed   int
+	Kept      int        // local versions kept over the primary's
+	Conflicts []Conflict // conflicts queued for manual review
+	// Base and Accepted are the options for the next import
+	Base     map[string]string
+	Accepted map[string]string
+}
+
+// ApplySnapshot imports a snapshot. Files that are unchanged on this node
+// since the previous import are updated to the primary's version, and
+// removed if the primary deleted them. Files changed locally are handled
+// by the strategy for their entity type. Entries outside the replicated
+// paths are rejected.
+func ApplySnapshot(r io.Reader, paths []string, opts ImportOptions) (*ImportResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	// Read everything first, so a truncated download changes nothing
+	type entry struct {
+		data    []byte
+		mode    fs.FileMode
+		modTime time.Time
+		hash    string
+	}
+	entries := map[string]entry{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		path := filepath.Clean("/" + header.Name)
+		if !replicated(path, paths) {
+			return nil, fmt.Errorf("snapshot entry outside replicated paths: %s", header.Name)
+		}
+		if header.Size > maxSnapshotFileSize {
+			return nil, fmt.Errorf("snapshot entry too large: %s", header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxSnapshotFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot: %w", err)
+		}
+		entries[path] = entry{
+			data:    data,
+			mode:    fs.FileMode(header.Mode).Perm(),
+			modTime: header.ModTime,
+			hash:    hashBytes(data),
+		}
+	}
+
+	existing, err := listFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]file, len(existing))
+	for _, f := range existing {
+		local[f.path] = f
+	}
+
+	names := make([]string, 0, len(entries)+len(local))
+	for path := range entries {
+		names = append(names, path)
+	}
+	for path := range local {
+		if _, ok := entries[path]; !ok {
+			names = append(names, path)
+		}
+	}
+	sort.Strings(names)
+
+	result := &ImportResult{
+		Base:     map[string]string{},
+		Accepted: map[string]string{},
+	}
+	detected := map[string]bool{}
+	now := time.Now().UTC()
+
+	for _, path := range names {
+		in, inOK := entries[path]
+		if inOK {
+			result.Base[path] = in.hash
+		}
+
+		f, localOK := local[path]
+		var localData []byte
+		var localHash string
+		if localOK {
+			localData, err = os.ReadFile(path)
+			if err != nil {
+				return result, fmt.Errorf("read %s: %w", path, err)
+			}
+			localHash = hashBytes(localData)
+		}
+
+		if inOK && localOK && localHash == in.hash {
+			if f.mode == in.mode {
+				continue
+			}
+			if err := os.Chmod(path, in.mode); err != nil {
+				return result, fmt.Errorf("write %s: %w", path, err)
+			}
+			result.Written++
+			continue
+		}
+
+		base, hadBase := opts.Base[path]
+		changedLocally := localOK && (!hadBase || localHash != base)
+		if changedLocally {
+			theirsHash := in.hash // empty if the primary deleted the file
+			if accepted, ok := opts.Accepted[path]; ok && accepted == theirsHash {
+				result.Accepted[path] = theirsHash
+				result.Kept++
+				continue
+			}
+
+			strategy := opts.Strategies[EntityType(path)]
+			if strategy == StrategyNewest {
+				strategy = StrategyOurs
+				if inOK && in.modTime.After(f.modTime) {
+					strategy = StrategyTheirs
+				}
+			}
+
+			switch strategy {
+			case StrategyOurs:
+				result.Accepted[path] = theirsHash
+				result.Kept++
+				continue
+
+			case StrategyManual:
+				c := Conflict{
+					ID:         conflictID(path, theirsHash),
+					Path:       path,
+					Entity:     EntityType(path),
+					DetectedAt: now,
+					LocalHash:  localHash,
+					TheirsHash: theirsHash,
+					Mode:       in.mode,
+				}
+				if opts.Queue == nil {
+					return result, fmt.Errorf("conflict on %s: no conflict queue for manual review", path)
+				}
+				if err := opts.Queue.Add(c, in.data); err != nil {
+					return result, err
+				}
+				detected[c.ID] = true
+				result.Conflicts = append(result.Conflicts, c)
+				result.Kept++
+				continue
+			}
+		}
+
+		if inOK {
+			if err := writeFileAtomic(path, in.data, in.mode, in.modTime); err != nil {
+				return result, err
+			}
+			result.Written++
+		} else {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return result, fmt.Errorf("remove %s: %w", path, err)
+			}
+			result.Removed++
+		}
+	}
+
+	if opts.Queue != nil {
+		if err := opts.Queue.prune(detected); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// writeFileAtomic writes data to path through a temporary file. A non-zero
+// modTime is applied to the result, so "newest" compares the primary's
+// modification times rather than import times.
+func writeFileAtomic(path string, data []byte, mode fs.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replicated reports whether path is one of paths or inside one of them
+func replicated(path string, paths []string) bool {
+	for _, root := range paths {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
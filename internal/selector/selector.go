@@ -0,0 +1,215 @@
+// Package selector selects nodes by their labels with kubectl-style label
+// selectors: rack=a1,gpu_model!=H100,env in (prod,staging),!maintenance
+package selector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Operators of a requirement
+const (
+	OpEqual     = "="
+	OpNotEqual  = "!="
+	OpIn        = "in"
+	OpNotIn     = "notin"
+	OpExists    = "exists"
+	OpNotExists = "!"
+)
+
+// Requirement is one comma-separated term of a selector.
+type Requirement struct {
+	Key    string
+	Op     string
+	Values []string // one for = and !=, none for exists and !
+}
+
+// Matches reports whether labels satisfy the requirement. As in
+// Kubernetes, != and notin match nodes without the label.
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case OpEqual:
+		return ok && value == r.Values[0]
+	case OpNotEqual:
+		return !ok || value != r.Values[0]
+	case OpIn:
+		return ok && contains(r.Values, value)
+	case OpNotIn:
+		return !ok || !contains(r.Values, value)
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+	return false
+}
+
+func (r Requirement) String() string {
+	switch r.Op {
+	case OpIn, OpNotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Op, strings.Join(r.Values, ","))
+	case OpExists:
+		return r.Key
+	case OpNotExists:
+		return "!" + r.Key
+	}
+	return r.Key + r.Op + r.Values[0]
+}
+
+// Selector is a conjunction of requirements; the empty selector matches
+// every node.
+type Selector []Requirement
+
+// Matches reports whether labels satisfy every requirement.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Nodes returns the nodes whose labels match, in their order.
+func (s Selector) Nodes(nodes []config.NodeConfig) []config.NodeConfig {
+	if len(s) == 0 {
+		return nodes
+	}
+	var matched []config.NodeConfig
+	for _, n := range nodes {
+		if s.Matches(n.Labels) {
+			matched = append(matched, n)
+		}
+	}
+	return matched
+}
+
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, r := range s {
+		terms[i] = r.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// Parse parses a selector. Terms are separated by commas:
+//
+//	key=value, key==value   the label has the value
+//	key!=value              the label is missing or has another value
+//	key in (v1,v2)          the label has one of the values
+//	key notin (v1,v2)       the label is missing or has none of the values
+//	key                     the label is set
+//	!key                    the label is not set
+func Parse(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitTerms(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("invalid selector %q: empty term", s)
+		}
+		r, err := parseTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitTerms splits at the commas outside parentheses
+func splitTerms(s string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseTerm(term string) (Requirement, error) {
+	if key, ok := strings.CutPrefix(term, "!"); ok && !strings.Contains(key, "=") {
+		key = strings.TrimSpace(key)
+		if err := validKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Op: OpNotExists}, nil
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if key, value, ok := strings.Cut(term, op); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if err := validKey(key); err != nil {
+				return Requirement{}, err
+			}
+			if strings.ContainsAny(value, "=!() ") {
+				return Requirement{}, fmt.Errorf("invalid value %q", value)
+			}
+			if op == "==" {
+				op = OpEqual
+			}
+			return Requirement{Key: key, Op: op, Values: []string{value}}, nil
+		}
+	}
+
+	if open := strings.Index(term, "("); open >= 0 {
+		fields := strings.Fields(term[:open])
+		if len(fields) != 2 || (fields[1] != OpIn && fields[1] != OpNotIn) {
+			return Requirement{}, fmt.Errorf("%q: expected <key> in (...) or <key> notin (...)", term)
+		}
+		if !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("%q: missing )", term)
+		}
+		if err := validKey(fields[0]); err != nil {
+			return Requirement{}, err
+		}
+		var values []string
+		for _, v := range strings.Split(term[open+1:len(term)-1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return Requirement{}, fmt.Errorf("%q: no values", term)
+		}
+		sort.Strings(values)
+		return Requirement{Key: fields[0], Op: fields[1], Values: values}, nil
+	}
+
+	if err := validKey(term); err != nil {
+		return Requirement{}, err
+	}
+	return Requirement{Key: term, Op: OpExists}, nil
+}
+
+func validKey(key string) error {
+	if key == "" || strings.ContainsAny(key, " ()=!,") {
+		return fmt.Errorf("invalid label key %q", key)
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+// Package silence creates Alertmanager silences and keeps the metadata
+// Alertmanager has no place for: the reason, the creator and the alert rule
+// a silence belongs to.
+package silence
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+)
+
+// DefaultDir is where silence metadata is stored
+const DefaultDir = "/var/lib/aami/silences"
+
+// Metadata is what AAMI records about a silence it created.
+type Metadata struct {
+	ID        string    `yaml:"id" json:"id"`
+	Reason    string    `yaml:"reason" json:"reason"`
+	CreatedBy string    `yaml:"created_by" json:"created_by"`
+	Rule      string    `yaml:"rule,omitempty" json:"rule,omitempty"` // linked alert rule
+	Node      string    `yaml:"node,omitempty" json:"node,omitempty"`
+	Window    string    `yaml:"window,omitempty" json:"window,omitempty"` // maintenance window
+	CreatedAt time.Time `yaml:"created_at" json:"created_at"`
+	EndsAt    time.Time `yaml:"ends_at" json:"ends_at"`
+}
+
+// Request describes a silence to create. At least one of Rule, Node,
+// Nodes and Matchers must be set.
+type Request struct {
+	Rule      string            // alertname to silence
+	Node      string            // node label to silence
+	Nodes     []string          // several nodes to silence, instead of Node
+	Matchers  map[string]string // further label=value matchers
+	Window    string            // maintenance window the silence is for
+	Duration  time.Duration
+	Reason    string
+	CreatedBy string
+}
+
+// Silence is an Alertmanager silence with the metadata AAMI recorded for
+// it, if it was created through AAMI.
+type Silence struct {
+	alertmanager.Silence
+	Metadata *Metadata `json:"aami,omitempty"`
+}
+
+// State returns the Alertmanager state: active, pending or expired.
+func (s Silence) State() string {
+	if s.Status == nil {
+		return ""
+	}
+	return s.Status.State
+}
+
+// Manager creates, lists and expires silences.
+type Manager struct {
+	am    *alertmanager.Client
+	store *Store
+	now   func() time.Time
+}
+
+// NewManager creates a silence manager.
+func NewManager(am *alertmanager.Client, store *Store) *Manager {
+	return &Manager{am: am, store: store, now: time.Now}
+}
+
+// Create creates a silence in Alertmanager and records its metadata.
+func (m *Manager) Create(req Request) (*Silence, error) {
+	if req.Rule == "" && req.Node == "" && len(req.Nodes) == 0 && len(req.Matchers) == 0 {
+		return nil, fmt.Errorf("a silence needs a rule, node or matcher")
+	}
+	if req.Duration <= 0 {
+		return nil, fmt.Errorf("a silence needs a positive duration")
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return nil, fmt.Errorf("a silence needs a reason")
+	}
+	if req.CreatedBy == "" {
+		return nil, fmt.Errorf("a silence needs a creator")
+	}
+
+	var matchers []alertmanager.Matcher
+	if req.Rule != "" {
+		matchers = append(matchers, alertmanager.Equal("alertname", req.Rule))
+	}
+	if req.Node != "" {
+		matchers = append(matchers, alertmanager.Equal("node", req.Node))
+	}
+	if len(req.Nodes) > 0 {
+		quoted := make([]string, len(req.Nodes))
+		for i, n := range req.Nodes {
+			quoted[i] = regexp.QuoteMeta(n)
+		}
+		matchers = append(matchers, alertmanager.Regex("node", strings.Join(quoted, "|")))
+	}
+	names := make([]string, 0, len(req.Matchers))
+	for name := range req.Matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		matchers = append(matchers, alertmanager.Equal(name, req.Matchers[name]))
+	}
+
+	now := m.now().UTC()
+	s := alertmanager.Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(req.Duration),
+		CreatedBy: req.CreatedBy,
+		Comment:   req.Reason,
+	}
+	id, err := m.am.CreateSilence(s)
+	if err != nil {
+		return nil, err
+	}
+	s.ID = id
+
+	meta := &Metadata{
+		ID:        id,
+		Reason:    req.Reason,
+		CreatedBy: req.CreatedBy,
+		Rule:      req.Rule,
+		Node:      req.Node,
+		Window:    req.Window,
+		CreatedAt: now,
+		EndsAt:    s.EndsAt,
+	}
+	if err := m.store.Save(meta); err != nil {
+		return nil, fmt.Errorf("silence %s created, but its metadata was not saved: %w", id, err)
+	}
+	return &Silence{Silence: s, Metadata: meta}, nil
+}
+
+// List returns the silences known to Alertmanager with their metadata,
+// those ending last first. Expired silences are left out unless
+// includeExpired is set.
+func (m *Manager) List(includeExpired bool) ([]Silence, error) {
+	all, err := m.am.ListSilences()
+	if err != nil {
+		return nil, err
+	}
+
+	var silences []Silence
+	for _, s := range all {
+		entry := Silence{Silence: s}
+		if !includeExpired && entry.State() == "expired" {
+			continue
+		}
+		meta, err := m.store.Get(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry.Metadata = meta
+		silences = append(silences, entry)
+	}
+
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].EndsAt.After(silences[j].EndsAt)
+	})
+	return silences, nil
+}
+
+// Expire ends a silence. Its metadata is kept for the record.
+func (m *Manager) Expire(id string) error {
+	return m.am.ExpireSilence(id)
+}
+
+// Store reads and writes silence metadata, one YAML file per silence.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a silence metadata store.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Save writes the metadata of a silence.
+func (s *Store) Save(meta *Metadata) error {
+	path, err := s.path(meta.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("create silence directory: %w", err)
+	}
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal silence: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("write silence: %w", err)
+	}
+	return nil
+}
+
+// Get returns the metadata of a silence, or nil if none was recorded
+// (the silence was created outside AAMI).
+func (s *Store) Get(id string) (*Metadata, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read silence: %w", err)
+	}
+
+	var meta Metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parse silence %s: %w", id, err)
+	}
+	return &meta, nil
+}
+
+func (s *Store) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid silence ID: %q", id)
+	}
+	return filepath.Join(s.dir, id+".yaml"), nil
+}
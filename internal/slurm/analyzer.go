@@ -11,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/fregataa/aami/internal/gpuevents"
 )
 
 // Analyzer correlates Slurm jobs with GPU events.
@@ -38,17 +40,17 @@ func (a *Analyzer) AnalyzeJob(ctx context.Context, jobID int64) (*JobGPUCorrelat
 		return nil, fmt.Errorf("get job: %w", err)
 	}
 
-	result := &JobGPUCorrelation{
-		Job:         *job,
-		Correlation: CorrelationNone,
-	}
-
 	// Query GPU events during job execution
 	events, err := a.queryGPUEvents(ctx, job)
 	if err != nil {
 		return nil, fmt.Errorf("query GPU events: %w", err)
 	}
-	result.GPUEvents = events
+
+	result := &JobGPUCorrelation{
+		Job:         *job,
+		GPUEvents:   events,
+		Correlation: CorrelationNone,
+	}
 
 	// Analyze correlation
 	a.analyzeCorrelation(result)
@@ -57,6 +59,7 @@ func (a *Analyzer) AnalyzeJob(ctx context.Context, jobID int64) (*JobGPUCorrelat
 }
 
 // queryGPUEvents queries Prometheus for GPU events during job execution.
+// The job's GPUs are replaced by the resolved ones.
 func (a *Analyzer) queryGPUEvents(ctx context.Context, job *Job) ([]GPUEvent, error) {
 	var allEvents []GPUEvent
 
@@ -71,29 +74,46 @@ func (a *Analyzer) queryGPUEvents(ctx context.Context, job *Job) ([]GPUEvent, er
 	startTime = startTime.Add(-1 * time.Minute)
 	endTime = endTime.Add(1 * time.Minute)
 
+	allocs := a.resolveAllocations(ctx, job, endTime)
+	job.GPUs = nil
 	for _, node := range job.Nodes {
+		job.GPUs = append(job.GPUs, allocs[node]...)
+	}
+
+	for _, node := range job.Nodes {
+		// On a shared node only the job's GPUs count; without its
+		// allocation, every GPU of the node does
+		selector := gpuSelector(node, allocs[node])
+		var nodeEvents []GPUEvent
+
 		// Query Xid errors
-		xidEvents, err := a.queryXidErrors(ctx, node, startTime, endTime)
+		xidEvents, err := a.queryXidErrors(ctx, selector, startTime, endTime)
 		if err == nil {
-			allEvents = append(allEvents, xidEvents...)
+			nodeEvents = append(nodeEvents, xidEvents...)
 		}
 
 		// Query high temperature events
-		tempEvents, err := a.queryTemperatureEvents(ctx, node, startTime, endTime)
+		tempEvents, err := a.queryTemperatureEvents(ctx, selector, startTime, endTime)
 		if err == nil {
-			allEvents = append(allEvents, tempEvents...)
+			nodeEvents = append(nodeEvents, tempEvents...)
 		}
 
 		// Query ECC errors
-		eccEvents, err := a.queryECCErrors(ctx, node, startTime, endTime)
+		eccEvents, err := a.queryECCErrors(ctx, selector, startTime, endTime)
 		if err == nil {
-			allEvents = append(allEvents, eccEvents...)
+			nodeEvents = append(nodeEvents, eccEvents...)
 		}
 
 		// Query power throttling
-		throttleEvents, err := a.queryThrottleEvents(ctx, node, startTime, endTime)
+		throttleEvents, err := a.queryThrottleEvents(ctx, selector, startTime, endTime)
 		if err == nil {
-			allEvents = append(allEvents, throttleEvents...)
+			nodeEvents = append(nodeEvents, throttleEvents...)
+		}
+
+		for _, event := range nodeEvents {
+			if len(allocs[node]) == 0 || matchesAllocation(event, allocs[node]) {
+				allEvents = append(allEvents, event)
+			}
 		}
 	}
 
@@ -105,28 +125,81 @@ func (a *Analyzer) queryGPUEvents(ctx context.Context, job *Job) ([]GPUEvent, er
 	return allEvents, nil
 }
 
-// queryXidErrors queries for Xid errors on a node.
-func (a *Analyzer) queryXidErrors(ctx context.Context, node string, start, end time.Time) ([]GPUEvent, error) {
-	query := fmt.Sprintf(`DCGM_FI_DEV_XID_ERRORS{instance=~"%s.*"} > 0`, node)
-	return a.queryRangeEvents(ctx, query, start, end, "xid", "critical")
+// resolveAllocations returns the job's GPUs by node, with the GRES indices
+// of nodes with MIG instances mapped to GPUs and instances by the series
+// of the node at the end of the job. Nodes whose GPUs are unknown, or whose
+// MIG layout cannot be read, are left out.
+func (a *Analyzer) resolveAllocations(ctx context.Context, job *Job, at time.Time) map[string][]GPUAllocation {
+	byNode := make(map[string][]GPUAllocation)
+	for _, alloc := range job.GPUs {
+		byNode[alloc.Node] = append(byNode[alloc.Node], alloc)
+	}
+
+	for node, allocs := range byNode {
+		query := fmt.Sprintf(`group by (gpu, GPU_I_ID) (last_over_time(DCGM_FI_DEV_GPU_TEMP{instance=~"%s.*"}[10m]))`, node)
+		series, err := a.querySeries(ctx, query, at)
+		if err != nil || len(series) == 0 {
+			for _, alloc := range allocs {
+				if isMIGType(alloc.Type) {
+					// GRES indices of MIG devices are not GPU indices
+					delete(byNode, node)
+					break
+				}
+			}
+			continue
+		}
+		resolveGRESIndices(allocs, gresDevices(series))
+	}
+	return byNode
+}
+
+// gpuSelector selects the series of a node, and of the allocated GPUs if
+// known
+func gpuSelector(node string, allocs []GPUAllocation) string {
+	selector := fmt.Sprintf(`instance=~"%s.*"`, node)
+	if len(allocs) == 0 {
+		return selector
+	}
+	seen := make(map[int]bool)
+	var gpus []string
+	for _, alloc := range allocs {
+		if !seen[alloc.GPUIndex] {
+			seen[alloc.GPUIndex] = true
+			gpus = append(gpus, strconv.Itoa(alloc.GPUIndex))
+		}
+	}
+	return fmt.Sprintf(`%s,gpu=~"%s"`, selector, strings.Join(gpus, "|"))
+}
+
+// queryXidErrors queries for Xid errors on a node. The severity of an
+// event is its code's; unknown codes are critical.
+func (a *Analyzer) queryXidErrors(ctx context.Context, selector string, start, end time.Time) ([]GPUEvent, error) {
+	query := fmt.Sprintf(`DCGM_FI_DEV_XID_ERRORS{%s} > 0`, selector)
+	events, err := a.queryRangeEvents(ctx, query, start, end, "xid", "critical")
+	for i, e := range events {
+		if code, ok := gpuevents.ParseValue(e.Value); ok {
+			events[i].Severity = gpuevents.Severity(gpuevents.KindXid, code, e.Severity)
+		}
+	}
+	return events, err
 }
 
 // queryTemperatureEvents queries for high temperature events.
-func (a *Analyzer) queryTemperatureEvents(ctx context.Context, node string, start, end time.Time) ([]GPUEvent, error) {
-	query := fmt.Sprintf(`DCGM_FI_DEV_GPU_TEMP{instance=~"%s.*"} > 83`, node)
+func (a *Analyzer) queryTemperatureEvents(ctx context.Context, selector string, start, end time.Time) ([]GPUEvent, error) {
+	query := fmt.Sprintf(`DCGM_FI_DEV_GPU_TEMP{%s} > 83`, selector)
 	return a.queryRangeEvents(ctx, query, start, end, "temperature", "warning")
 }
 
 // queryECCErrors queries for ECC errors.
-func (a *Analyzer) queryECCErrors(ctx context.Context, node string, start, end time.Time) ([]GPUEvent, error) {
+func (a *Analyzer) queryECCErrors(ctx context.Context, selector string, start, end time.Time) ([]GPUEvent, error) {
 	// Double-bit ECC errors (uncorrectable)
-	query := fmt.Sprintf(`increase(DCGM_FI_DEV_ECC_DBE_VOL_TOTAL{instance=~"%s.*"}[5m]) > 0`, node)
+	query := fmt.Sprintf(`increase(DCGM_FI_DEV_ECC_DBE_VOL_TOTAL{%s}[5m]) > 0`, selector)
 	return a.queryRangeEvents(ctx, query, start, end, "ecc_dbe", "critical")
 }
 
 // queryThrottleEvents queries for power/thermal throttling.
-func (a *Analyzer) queryThrottleEvents(ctx context.Context, node string, start, end time.Time) ([]GPUEvent, error) {
-	query := fmt.Sprintf(`DCGM_FI_DEV_POWER_VIOLATION{instance=~"%s.*"} > 0`, node)
+func (a *Analyzer) queryThrottleEvents(ctx context.Context, selector string, start, end time.Time) ([]GPUEvent, error) {
+	query := fmt.Sprintf(`DCGM_FI_DEV_POWER_VIOLATION{%s} > 0`, selector)
 	return a.queryRangeEvents(ctx, query, start, end, "throttle", "warning")
 }
 
@@ -175,6 +248,7 @@ func (a *Analyzer) queryRangeEvents(ctx context.Context, query string, start, en
 	for _, series := range result.Data.Result {
 		node := extractNode(series.Metric)
 		gpu := extractGPU(series.Metric)
+		instance := series.Metric["GPU_I_ID"]
 
 		for _, v := range series.Values {
 			if len(v) < 2 {
@@ -198,10 +272,11 @@ func (a *Analyzer) queryRangeEvents(ctx context.Context, query string, start, en
 				Timestamp: time.Unix(int64(ts), 0),
 				Node:      node,
 				GPUIndex:  gpu,
+				GPUInstance: instance,
 				Type:      eventType,
 				Value:     value,
 				Severity:  severity,
-				Message:   formatEventMessage(eventType, value, gpu),
+				Message:   formatEventMessage(eventType, value, gpuLabel(gpu, instance)),
 			})
 		}
 	}
@@ -209,6 +284,45 @@ func (a *Analyzer) queryRangeEvents(ctx context.Context, query string, start, en
 	return events, nil
 }
 
+// querySeries returns the labels of the series of an instant query at a
+// time.
+func (a *Analyzer) querySeries(ctx context.Context, query string, at time.Time) ([]map[string]string, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.prometheusURL+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s", result.Status)
+	}
+
+	series := make([]map[string]string, 0, len(result.Data.Result))
+	for _, r := range result.Data.Result {
+		series = append(series, r.Metric)
+	}
+	return series, nil
+}
+
 // analyzeCorrelation determines the correlation between job failure and GPU events.
 func (a *Analyzer) analyzeCorrelation(result *JobGPUCorrelation) {
 	if len(result.GPUEvents) == 0 {
@@ -273,10 +387,11 @@ func (a *Analyzer) analyzeCorrelation(result *JobGPUCorrelation) {
 	affectedGPUs := make(map[string]GPUAllocation)
 	for _, event := range result.GPUEvents {
 		if event.Severity == "critical" {
-			key := fmt.Sprintf("%s:%d", event.Node, event.GPUIndex)
+			key := fmt.Sprintf("%s:%d:%s", event.Node, event.GPUIndex, event.GPUInstance)
 			affectedGPUs[key] = GPUAllocation{
-				Node:     event.Node,
-				GPUIndex: event.GPUIndex,
+				Node:        event.Node,
+				GPUIndex:    event.GPUIndex,
+				GPUInstance: event.GPUInstance,
 			}
 		}
 	}
@@ -290,18 +405,36 @@ func (a *Analyzer) generateRecommendation(result *JobGPUCorrelation, xid, ecc, t
 	var recommendations []string
 
 	if len(xid) > 0 {
-		// Get unique nodes with Xid errors
-		nodes := make(map[string]bool)
+		// Known codes get their own action, on the nodes that logged them
+		codeNodes := make(map[int]map[string]bool)
+		unknown := make(map[string]bool)
 		for _, e := range xid {
-			nodes[e.Node] = true
+			code, _ := gpuevents.ParseValue(e.Value)
+			if _, known := gpuevents.Lookup(gpuevents.KindXid, code); !known {
+				unknown[e.Node] = true
+				continue
+			}
+			if codeNodes[code] == nil {
+				codeNodes[code] = make(map[string]bool)
+			}
+			codeNodes[code][e.Node] = true
 		}
-		nodeList := make([]string, 0, len(nodes))
-		for n := range nodes {
-			nodeList = append(nodeList, n)
+		codes := make([]int, 0, len(codeNodes))
+		for code := range codeNodes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			c, _ := gpuevents.Lookup(gpuevents.KindXid, code)
+			recommendations = append(recommendations,
+				fmt.Sprintf("%s: %s on %s: %s. Action: %s.",
+					strings.ToUpper(c.Severity), c.Label(), sortedNodes(codeNodes[code]), c.Meaning, c.Action))
+		}
+		if len(unknown) > 0 {
+			recommendations = append(recommendations,
+				fmt.Sprintf("CRITICAL: Xid errors detected on %s. Drain node(s) and inspect GPU hardware.",
+					sortedNodes(unknown)))
 		}
-		recommendations = append(recommendations,
-			fmt.Sprintf("CRITICAL: Xid errors detected on %s. Drain node(s) and inspect GPU hardware.",
-				strings.Join(nodeList, ", ")))
 	}
 
 	if len(ecc) > 0 {
@@ -414,6 +547,16 @@ func (a *Analyzer) GetJobStatistics(ctx context.Context, partition string, hours
 
 // Helper functions
 
+// sortedNodes joins a set of nodes in order
+func sortedNodes(nodes map[string]bool) string {
+	list := make([]string, 0, len(nodes))
+	for n := range nodes {
+		list = append(list, n)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
 func extractNode(metric map[string]string) string {
 	if instance, ok := metric["instance"]; ok {
 		// Remove port
@@ -441,17 +584,22 @@ func extractGPU(metric map[string]string) int {
 	return 0
 }
 
-func formatEventMessage(eventType, value string, gpu int) string {
+func formatEventMessage(eventType, value, gpu string) string {
 	switch eventType {
 	case "xid":
-		return fmt.Sprintf("Xid error %s on GPU %d", value, gpu)
+		if code, ok := gpuevents.ParseValue(value); ok {
+			if c, ok := gpuevents.Lookup(gpuevents.KindXid, code); ok {
+				return fmt.Sprintf("%s on %s: %s — %s", c.Label(), gpu, c.Meaning, c.Action)
+			}
+		}
+		return fmt.Sprintf("Xid error %s on %s", value, gpu)
 	case "ecc_dbe":
-		return fmt.Sprintf("Uncorrectable ECC error on GPU %d (count: %s)", gpu, value)
+		return fmt.Sprintf("Uncorrectable ECC error on %s (count: %s)", gpu, value)
 	case "temperature":
-		return fmt.Sprintf("High temperature on GPU %d: %s°C", gpu, value)
+		return fmt.Sprintf("High temperature on %s: %s°C", gpu, value)
 	case "throttle":
-		return fmt.Sprintf("Power throttling on GPU %d", gpu)
+		return fmt.Sprintf("Power throttling on %s", gpu)
 	default:
-		return fmt.Sprintf("%s event on GPU %d: %s", eventType, gpu, value)
+		return fmt.Sprintf("%s event on %s: %s", eventType, gpu, value)
 	}
 }
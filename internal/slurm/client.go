@@ -2,119 +2,94 @@ package slurm
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Client provides access to Slurm functionality.
+// Client provides access to Slurm functionality. With an endpoint it
+// calls slurmrestd, and falls back to the Slurm commands while slurmrestd
+// is unavailable.
 type Client struct {
 	config     SlurmConfig
 	httpClient *http.Client
+	rest       *restClient
+
+	mu            sync.Mutex
+	restDownUntil time.Time
+
+	// OnFallback, if set, is called when an operation falls back to the
+	// Slurm commands, with the slurmrestd error.
+	OnFallback func(op string, err error)
 }
 
+// restRetryAfter is how long slurmrestd is not called after it failed
+const restRetryAfter = 30 * time.Second
+
 // NewClient creates a new Slurm client.
 func NewClient(cfg SlurmConfig) *Client {
-	return &Client{
+	c := &Client{
 		config: cfg,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
+	if cfg.Endpoint != "" {
+		c.rest = newRESTClient(cfg, c.httpClient)
+	}
+	return c
 }
 
-// GetJob retrieves job information by ID.
-func (c *Client) GetJob(ctx context.Context, jobID int64) (*Job, error) {
-	if c.config.Endpoint != "" {
-		return c.getJobREST(ctx, jobID)
+// useREST reports whether slurmrestd should be tried
+func (c *Client) useREST() bool {
+	if c.rest == nil {
+		return false
 	}
-	return c.getJobCLI(ctx, jobID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().After(c.restDownUntil)
 }
 
-// getJobREST retrieves job via slurmrestd API.
-func (c *Client) getJobREST(ctx context.Context, jobID int64) (*Job, error) {
-	url := fmt.Sprintf("%s/slurm/v0.0.40/job/%d", c.config.Endpoint, jobID)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, err
+// fallback reports whether a slurmrestd error should be retried with the
+// Slurm commands, and then stops calling slurmrestd for a while.
+func (c *Client) fallback(op string, err error) bool {
+	var unavailable *restUnavailable
+	if !errors.As(err, &unavailable) {
+		return false
 	}
-
-	if c.config.AuthToken != "" {
-		req.Header.Set("X-SLURM-USER-TOKEN", c.config.AuthToken)
+	c.mu.Lock()
+	c.restDownUntil = time.Now().Add(restRetryAfter)
+	c.mu.Unlock()
+	if c.OnFallback != nil {
+		c.OnFallback(op, err)
 	}
+	return true
+}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("slurm API request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("slurm API returned status %d", resp.StatusCode)
-	}
-
-	var result struct {
-		Jobs []struct {
-			JobID       int64  `json:"job_id"`
-			Name        string `json:"name"`
-			UserName    string `json:"user_name"`
-			GroupName   string `json:"group_name"`
-			Partition   string `json:"partition"`
-			JobState    string `json:"job_state"`
-			ExitCode    int    `json:"exit_code"`
-			Nodes       string `json:"nodes"`
-			StartTime   int64  `json:"start_time"`
-			EndTime     int64  `json:"end_time"`
-			SubmitTime  int64  `json:"submit_time"`
-			TimeLimit   int    `json:"time_limit"`
-			WorkDir     string `json:"work_dir"`
-			Command     string `json:"command"`
-			Account     string `json:"account"`
-			QOS         string `json:"qos"`
-			Priority    int    `json:"priority"`
-			StateReason string `json:"state_reason"`
-		} `json:"jobs"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("decode response: %w", err)
-	}
-
-	if len(result.Jobs) == 0 {
-		return nil, fmt.Errorf("job not found: %d", jobID)
+// GetJob retrieves job information by ID. Jobs slurmctld no longer knows
+// are looked up in the accounting database.
+func (c *Client) GetJob(ctx context.Context, jobID int64) (*Job, error) {
+	if c.useREST() {
+		job, err := c.rest.getJob(ctx, jobID)
+		if err == nil {
+			return job, nil
+		}
+		if !errors.Is(err, errRESTNotFound) && !c.fallback("get job", err) {
+			return nil, err
+		}
 	}
-
-	j := result.Jobs[0]
-	return &Job{
-		ID:         j.JobID,
-		Name:       j.Name,
-		User:       j.UserName,
-		Group:      j.GroupName,
-		Partition:  j.Partition,
-		State:      JobState(j.JobState),
-		ExitCode:   j.ExitCode,
-		Nodes:      c.expandNodeList(ctx, j.Nodes),
-		StartTime:  time.Unix(j.StartTime, 0),
-		EndTime:    time.Unix(j.EndTime, 0),
-		SubmitTime: time.Unix(j.SubmitTime, 0),
-		TimeLimit:  time.Duration(j.TimeLimit) * time.Minute,
-		WorkDir:    j.WorkDir,
-		Command:    j.Command,
-		Account:    j.Account,
-		QOS:        j.QOS,
-		Priority:   j.Priority,
-		Reason:     j.StateReason,
-	}, nil
+	return c.getJobCLI(ctx, jobID)
 }
 
 // getJobCLI retrieves job via scontrol command.
 func (c *Client) getJobCLI(ctx context.Context, jobID int64) (*Job, error) {
-	cmd := exec.CommandContext(ctx, "scontrol", "show", "job", strconv.FormatInt(jobID, 10))
+	// -d adds the GPU indices allocated on each node
+	cmd := exec.CommandContext(ctx, "scontrol", "show", "job", "-d", strconv.FormatInt(jobID, 10))
 	output, err := cmd.Output()
 	if err != nil {
 		// Try sacct for completed jobs
@@ -183,6 +158,10 @@ func (c *Client) parseScontrolJobOutput(ctx context.Context, output string) (*Jo
 	// scontrol output is key=value pairs
 	for _, line := range strings.Split(output, "\n") {
 		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Nodes=") {
+			job.GPUs = append(job.GPUs, c.parseJobDetailLine(ctx, line)...)
+			continue
+		}
 		for _, part := range strings.Fields(line) {
 			kv := strings.SplitN(part, "=", 2)
 			if len(kv) != 2 {
@@ -255,6 +234,29 @@ func (c *Client) parseScontrolJobOutput(ctx context.Context, output string) (*Jo
 	return job, nil
 }
 
+// parseJobDetailLine parses the GPUs of a detail line of scontrol show job
+// -d, e.g. "Nodes=gpu-[01-02] CPU_IDs=0-15 Mem=0 GRES=gpu:a100:2(IDX:0-1)",
+// or GRES_IDX=gpu(IDX:0-1) before Slurm 21.08.
+func (c *Client) parseJobDetailLine(ctx context.Context, line string) []GPUAllocation {
+	var nodes []string
+	var detail string
+	for _, part := range strings.Fields(line) {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "Nodes":
+			nodes = c.expandNodeList(ctx, value)
+		case "GRES", "GRES_IDX":
+			detail = value
+		}
+	}
+
+	var allocs []GPUAllocation
+	for _, node := range nodes {
+		allocs = append(allocs, parseGRESDetail(node, detail)...)
+	}
+	return allocs
+}
+
 // expandNodeList expands Slurm node list notation.
 // e.g., "gpu-node-[01-04]" -> ["gpu-node-01", "gpu-node-02", "gpu-node-03", "gpu-node-04"]
 func (c *Client) expandNodeList(ctx context.Context, nodeList string) []string {
@@ -262,7 +264,11 @@ func (c *Client) expandNodeList(ctx context.Context, nodeList string) []string {
 		return nil
 	}
 
-	// Use scontrol to expand
+	if nodes, err := expandHostlist(nodeList); err == nil {
+		return nodes
+	}
+
+	// Use scontrol for notation not handled here
 	cmd := exec.CommandContext(ctx, "scontrol", "show", "hostnames", nodeList)
 	output, err := cmd.Output()
 	if err != nil {
@@ -287,6 +293,13 @@ func (c *Client) GetRunningJobs(ctx context.Context) ([]Job, error) {
 
 // GetJobs retrieves jobs matching the filter.
 func (c *Client) GetJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
+	if c.useREST() {
+		jobs, err := c.rest.getJobs(ctx, filter)
+		if err == nil || !c.fallback("list jobs", err) {
+			return jobs, err
+		}
+	}
+
 	args := []string{"-h", "-o", "%i|%j|%u|%P|%T|%N|%l|%V|%S|%e|%a"}
 
 	if filter.User != "" {
@@ -344,6 +357,16 @@ func (c *Client) GetJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
 
 // GetNode retrieves node information.
 func (c *Client) GetNode(ctx context.Context, nodeName string) (*NodeInfo, error) {
+	if c.useREST() {
+		node, err := c.rest.getNode(ctx, nodeName)
+		if errors.Is(err, errRESTNotFound) {
+			return nil, fmt.Errorf("node not found: %s", nodeName)
+		}
+		if err == nil || !c.fallback("get node", err) {
+			return node, err
+		}
+	}
+
 	cmd := exec.CommandContext(ctx, "scontrol", "show", "node", nodeName)
 	output, err := cmd.Output()
 	if err != nil {
@@ -367,7 +390,10 @@ func (c *Client) parseNodeOutput(output string) (*NodeInfo, error) {
 		case "NodeName":
 			node.Name = value
 		case "State":
-			node.State = NodeState(strings.Split(value, "+")[0])
+			// The base state, then flags: IDLE+DRAIN, MIXED+DRAIN
+			states := strings.Split(value, "+")
+			node.State = NodeState(states[0])
+			node.StateFlags = states[1:]
 		case "CPUTot":
 			node.CPUs, _ = strconv.Atoi(value)
 		case "CPUAlloc":
@@ -404,6 +430,13 @@ func (c *Client) parseNodeOutput(output string) (*NodeInfo, error) {
 
 // DrainNode drains a node from Slurm scheduling.
 func (c *Client) DrainNode(ctx context.Context, nodeName, reason string) error {
+	if c.useREST() {
+		err := c.rest.updateNode(ctx, nodeName, "DRAIN", reason)
+		if err == nil || !c.fallback("drain node", err) {
+			return restNodeError("drain", nodeName, err)
+		}
+	}
+
 	cmd := exec.CommandContext(ctx, "scontrol", "update",
 		fmt.Sprintf("NodeName=%s", nodeName),
 		"State=DRAIN",
@@ -419,6 +452,13 @@ func (c *Client) DrainNode(ctx context.Context, nodeName, reason string) error {
 
 // ResumeNode resumes a drained node.
 func (c *Client) ResumeNode(ctx context.Context, nodeName string) error {
+	if c.useREST() {
+		err := c.rest.updateNode(ctx, nodeName, "RESUME", "")
+		if err == nil || !c.fallback("resume node", err) {
+			return restNodeError("resume", nodeName, err)
+		}
+	}
+
 	cmd := exec.CommandContext(ctx, "scontrol", "update",
 		fmt.Sprintf("NodeName=%s", nodeName),
 		"State=RESUME")
@@ -433,6 +473,13 @@ func (c *Client) ResumeNode(ctx context.Context, nodeName string) error {
 
 // GetPartitions retrieves all partition information.
 func (c *Client) GetPartitions(ctx context.Context) ([]PartitionInfo, error) {
+	if c.useREST() {
+		partitions, err := c.rest.getPartitions(ctx)
+		if err == nil || !c.fallback("list partitions", err) {
+			return partitions, err
+		}
+	}
+
 	cmd := exec.CommandContext(ctx, "sinfo", "-h", "-o", "%P|%a|%D|%T|%C|%G")
 	output, err := cmd.Output()
 	if err != nil {
@@ -494,6 +541,18 @@ func (c *Client) GetPartitions(ctx context.Context) ([]PartitionInfo, error) {
 	return partitions, nil
 }
 
+// restNodeError words a failed node update like the scontrol one
+func restNodeError(op, nodeName string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errRESTNotFound):
+		return fmt.Errorf("%s failed: node not found: %s", op, nodeName)
+	default:
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+}
+
 // GetJobsByNode returns jobs running on a specific node.
 func (c *Client) GetJobsByNode(ctx context.Context, nodeName string) ([]Job, error) {
 	return c.GetJobs(ctx, JobFilter{Node: nodeName})
@@ -0,0 +1,451 @@
+package slurm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCorrelationDir is where job-GPU correlations are stored
+const DefaultCorrelationDir = "/var/lib/aami/slurm-correlations"
+
+// DefaultCorrelationRetention is how long correlations are kept when
+// slurm.correlations.retention is not set
+const DefaultCorrelationRetention = 90 * 24 * time.Hour
+
+// correlationDayLayout names the file of each day
+const correlationDayLayout = "2006-01-02"
+
+// CorrelationTypes returns the valid correlation types, weakest first.
+func CorrelationTypes() []CorrelationType {
+	return []CorrelationType{CorrelationNone, CorrelationUnlikely, CorrelationPossible, CorrelationLikely, CorrelationConfirmed}
+}
+
+// Validate checks the fields a hook must report.
+func (c CorrelationLog) Validate() error {
+	if c.Node == "" {
+		return fmt.Errorf("node is required")
+	}
+	if c.JobID <= 0 {
+		return fmt.Errorf("job_id is required")
+	}
+	if c.HealthScore < 0 || c.HealthScore > 100 {
+		return fmt.Errorf("health_score must be between 0 and 100")
+	}
+	for _, t := range CorrelationTypes() {
+		if c.Correlation == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid correlation %q", c.Correlation)
+}
+
+// CorrelationFilter selects correlations. Empty fields match everything.
+type CorrelationFilter struct {
+	Node        string
+	JobID       int64
+	Correlation CorrelationType
+	Since       time.Time // reported at or after
+	// Nodes, if not nil, limits correlations to these nodes, e.g. the nodes
+	// of a tenant's namespace
+	Nodes map[string]bool
+}
+
+// Match reports whether a correlation passes the filter.
+func (f CorrelationFilter) Match(c CorrelationLog) bool {
+	switch {
+	case f.Node != "" && c.Node != f.Node:
+		return false
+	case f.JobID != 0 && c.JobID != f.JobID:
+		return false
+	case f.Correlation != "" && c.Correlation != f.Correlation:
+		return false
+	case !f.Since.IsZero() && c.ReportedAt.Before(f.Since):
+		return false
+	case f.Nodes != nil && !f.Nodes[c.Node]:
+		return false
+	}
+	return true
+}
+
+// CorrelationPage is one page of correlations, newest first.
+type CorrelationPage struct {
+	Correlations []CorrelationLog `json:"correlations"`
+	Page         int              `json:"page"`
+	Limit        int              `json:"limit"`
+	Total        int              `json:"total"` // correlations matching the filter
+}
+
+// NodeCorrelations summarizes the correlations of a node.
+type NodeCorrelations struct {
+	Node           string                  `json:"node"`
+	Correlations   int                     `json:"correlations"`
+	Jobs           int                     `json:"jobs"`
+	ByType         map[CorrelationType]int `json:"by_type"`
+	LowestHealth   int                     `json:"lowest_health"`
+	LastReportedAt time.Time               `json:"last_reported_at"`
+}
+
+// CorrelationStore keeps job-GPU correlations in one JSON Lines file per
+// day, so that retention removes whole files and never rewrites the ones
+// still kept.
+type CorrelationStore struct {
+	dir string
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// NewCorrelationStore creates a correlation store in dir.
+func NewCorrelationStore(dir string) *CorrelationStore {
+	return &CorrelationStore{dir: dir, now: time.Now}
+}
+
+// Add validates and stores correlations. Each is given an ID and the time
+// it was reported; correlations without a timestamp are taken to happen
+// when reported. Nothing is stored unless all correlations are valid.
+func (s *CorrelationStore) Add(logs []CorrelationLog) ([]CorrelationLog, error) {
+	for i, c := range logs {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("correlation %d: %w", i, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UTC()
+	var lines bytes.Buffer
+	stored := make([]CorrelationLog, 0, len(logs))
+	for _, c := range logs {
+		id, err := newCorrelationID()
+		if err != nil {
+			return nil, err
+		}
+		c.ID = id
+		c.ReportedAt = now
+		if c.Timestamp.IsZero() {
+			c.Timestamp = now
+		}
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			return nil, fmt.Errorf("marshal correlation: %w", err)
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+		stored = append(stored, c)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("create correlation directory: %w", err)
+	}
+	path := filepath.Join(s.dir, now.Format(correlationDayLayout)+".jsonl")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open correlations: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(lines.Bytes()); err != nil {
+		return nil, fmt.Errorf("write correlations: %w", err)
+	}
+	return stored, nil
+}
+
+// Get returns the correlation with an ID, or nil.
+func (s *CorrelationStore) Get(id string) (*CorrelationLog, error) {
+	matched, err := s.read(CorrelationFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range matched {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns a page of the correlations matching the filter, newest
+// first. Pages start at 1.
+func (s *CorrelationStore) List(f CorrelationFilter, page, limit int) (CorrelationPage, error) {
+	if page < 1 {
+		return CorrelationPage{}, fmt.Errorf("page must be at least 1")
+	}
+	if limit < 1 {
+		return CorrelationPage{}, fmt.Errorf("limit must be at least 1")
+	}
+
+	matched, err := s.read(f)
+	if err != nil {
+		return CorrelationPage{}, err
+	}
+	p := CorrelationPage{Correlations: []CorrelationLog{}, Page: page, Limit: limit, Total: len(matched)}
+	if start := (page - 1) * limit; start < len(matched) {
+		end := start + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		p.Correlations = matched[start:end]
+	}
+	return p, nil
+}
+
+// Nodes summarizes the correlations matching the filter per node, the
+// nodes with the most first.
+func (s *CorrelationStore) Nodes(f CorrelationFilter) ([]NodeCorrelations, error) {
+	matched, err := s.read(f)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*NodeCorrelations)
+	jobs := make(map[string]map[int64]bool)
+	for _, c := range matched {
+		n := nodes[c.Node]
+		if n == nil {
+			// matched is newest first
+			n = &NodeCorrelations{
+				Node:           c.Node,
+				ByType:         make(map[CorrelationType]int),
+				LowestHealth:   c.HealthScore,
+				LastReportedAt: c.ReportedAt,
+			}
+			nodes[c.Node] = n
+			jobs[c.Node] = make(map[int64]bool)
+		}
+		n.Correlations++
+		n.ByType[c.Correlation]++
+		if c.HealthScore < n.LowestHealth {
+			n.LowestHealth = c.HealthScore
+		}
+		jobs[c.Node][c.JobID] = true
+	}
+
+	summaries := make([]NodeCorrelations, 0, len(nodes))
+	for name, n := range nodes {
+		n.Jobs = len(jobs[name])
+		summaries = append(summaries, *n)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Correlations != summaries[j].Correlations {
+			return summaries[i].Correlations > summaries[j].Correlations
+		}
+		return summaries[i].Node < summaries[j].Node
+	})
+	return summaries, nil
+}
+
+// Prune removes the days older than the retention period and returns how
+// many day files were removed.
+func (s *CorrelationStore) Prune(retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, fmt.Errorf("retention must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.days()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := s.now().UTC().Add(-retention).Format(correlationDayLayout)
+	removed := 0
+	for _, day := range days {
+		if day >= cutoff {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, day+".jsonl")); err != nil {
+			return removed, fmt.Errorf("remove correlations of %s: %w", day, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// read returns the correlations matching the filter, newest first. Day
+// files before the filter's start are not opened.
+func (s *CorrelationStore) read(f CorrelationFilter) ([]CorrelationLog, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []CorrelationLog
+	for i := len(days) - 1; i >= 0; i-- {
+		if !f.Since.IsZero() && days[i] < f.Since.UTC().Format(correlationDayLayout) {
+			break
+		}
+		logs, err := s.readDay(days[i])
+		if err != nil {
+			return nil, err
+		}
+		for j := len(logs) - 1; j >= 0; j-- {
+			if f.Match(logs[j]) {
+				matched = append(matched, logs[j])
+			}
+		}
+	}
+	return matched, nil
+}
+
+// readDay returns the correlations of a day in the order they were
+// stored. A line cut short by a crash is skipped.
+func (s *CorrelationStore) readDay(day string) ([]CorrelationLog, error) {
+	file, err := os.Open(filepath.Join(s.dir, day+".jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("read correlations: %w", err)
+	}
+	defer file.Close()
+
+	var logs []CorrelationLog
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var c CorrelationLog
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			continue
+		}
+		logs = append(logs, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read correlations of %s: %w", day, err)
+	}
+	return logs, nil
+}
+
+// days returns the days with stored correlations, oldest first.
+func (s *CorrelationStore) days() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read correlation directory: %w", err)
+	}
+
+	var days []string
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(correlationDayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func newCorrelationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CorrelationClient reports and reads correlations through the API of
+// aami slurm serve.
+type CorrelationClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewCorrelationClient creates a client of the API at baseURL, sending
+// token as its bearer token: an agent credential to report, the API token
+// to read.
+func NewCorrelationClient(baseURL, token string) *CorrelationClient {
+	return &CorrelationClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Report stores correlations on the server and returns them as stored.
+func (c *CorrelationClient) Report(ctx context.Context, logs []CorrelationLog) ([]CorrelationLog, error) {
+	body, err := json.Marshal(map[string]interface{}{"correlations": logs})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Correlations []CorrelationLog `json:"correlations"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/correlations", body, http.StatusCreated, &result); err != nil {
+		return nil, err
+	}
+	return result.Correlations, nil
+}
+
+// List returns a page of the correlations matching the filter, newest
+// first.
+func (c *CorrelationClient) List(ctx context.Context, f CorrelationFilter, page, limit int) (CorrelationPage, error) {
+	q := url.Values{}
+	if f.Node != "" {
+		q.Set("node", f.Node)
+	}
+	if f.JobID != 0 {
+		q.Set("job", strconv.FormatInt(f.JobID, 10))
+	}
+	if f.Correlation != "" {
+		q.Set("correlation", string(f.Correlation))
+	}
+	if !f.Since.IsZero() {
+		q.Set("since", f.Since.UTC().Format(time.RFC3339))
+	}
+	q.Set("page", strconv.Itoa(page))
+	q.Set("limit", strconv.Itoa(limit))
+
+	var result CorrelationPage
+	err := c.do(ctx, http.MethodGet, "/api/v1/correlations?"+q.Encode(), nil, http.StatusOK, &result)
+	return result, err
+}
+
+func (c *CorrelationClient) do(ctx context.Context, method, path string, body []byte, want int, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("correlation API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("correlation API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("correlation API: decode: %w", err)
+	}
+	return nil
+}
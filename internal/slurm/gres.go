@@ -0,0 +1,191 @@
+package slurm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migProfilePattern matches the MIG profile GRES types of nvml
+// autodetection, e.g. 1g.10gb or nvidia_a100_3g.40gb
+var migProfilePattern = regexp.MustCompile(`(^|_)\d+g\.\d+gb$`)
+
+// isMIGType reports whether a GRES type is a MIG profile
+func isMIGType(gresType string) bool {
+	return migProfilePattern.MatchString(strings.ToLower(gresType))
+}
+
+// Label names the GPU, or the MIG instance, e.g. "GPU 3" or "GPU 3 MIG 2".
+func (g GPUAllocation) Label() string {
+	return gpuLabel(g.GPUIndex, g.GPUInstance)
+}
+
+func gpuLabel(gpu int, instance string) string {
+	if instance != "" {
+		return fmt.Sprintf("GPU %d MIG %s", gpu, instance)
+	}
+	return fmt.Sprintf("GPU %d", gpu)
+}
+
+// parseGRESDetail parses the GPUs allocated to a job on a node from its
+// GRES detail, e.g. "gpu:a100:2(IDX:0-1)", "gpu:1g.10gb:1(IDX:4)" or, before
+// Slurm 21.08, "gpu(IDX:0-1)". The indices are those of the node's GRES
+// devices, which are GPU indices only on nodes without MIG; see
+// resolveGRESIndices.
+func parseGRESDetail(node, detail string) []GPUAllocation {
+	var allocs []GPUAllocation
+	for _, spec := range splitGRES(detail) {
+		open := strings.Index(spec, "(IDX:")
+		if open < 0 || !strings.HasPrefix(spec, "gpu") {
+			continue
+		}
+		fields := strings.Split(spec[:open], ":")
+		gresType := ""
+		if len(fields) >= 3 {
+			gresType = fields[1]
+		} else if len(fields) == 2 {
+			if _, err := strconv.Atoi(fields[1]); err != nil {
+				gresType = fields[1]
+			}
+		}
+
+		indices := strings.TrimSuffix(spec[open+len("(IDX:"):], ")")
+		for _, idx := range parseIndexList(indices) {
+			allocs = append(allocs, GPUAllocation{Node: node, GPUIndex: idx, Type: gresType})
+		}
+	}
+	return allocs
+}
+
+// splitGRES splits a GRES list at the commas outside parentheses
+func splitGRES(gres string) []string {
+	var specs []string
+	depth, start := 0, 0
+	for i, r := range gres {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				specs = append(specs, strings.TrimSpace(gres[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(specs, strings.TrimSpace(gres[start:]))
+}
+
+// parseIndexList parses "0-1,3"; invalid entries such as N/A are skipped
+func parseIndexList(list string) []int {
+	var indices []int
+	for _, r := range strings.Split(list, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(r), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				continue
+			}
+		}
+		for i := first; i <= last; i++ {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// gresDevice is a GPU, or a MIG instance of one, as Slurm counts GRES
+// devices
+type gresDevice struct {
+	gpu      int
+	instance string
+}
+
+// gresDevices orders a node's GPUs and MIG instances, from the gpu and
+// GPU_I_ID labels of its series, as nvml autodetection does: by GPU, and a
+// GPU with MIG enabled by its instances instead of itself.
+func gresDevices(series []map[string]string) []gresDevice {
+	instances := make(map[int]map[string]bool)
+	for _, metric := range series {
+		gpu, err := strconv.Atoi(metric["gpu"])
+		if err != nil {
+			continue
+		}
+		if instances[gpu] == nil {
+			instances[gpu] = make(map[string]bool)
+		}
+		if id := metric["GPU_I_ID"]; id != "" {
+			instances[gpu][id] = true
+		}
+	}
+
+	gpus := make([]int, 0, len(instances))
+	for gpu := range instances {
+		gpus = append(gpus, gpu)
+	}
+	sort.Ints(gpus)
+
+	var devices []gresDevice
+	for _, gpu := range gpus {
+		if len(instances[gpu]) == 0 {
+			devices = append(devices, gresDevice{gpu: gpu})
+			continue
+		}
+		ids := make([]string, 0, len(instances[gpu]))
+		for id := range instances[gpu] {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			a, _ := strconv.Atoi(ids[i])
+			b, _ := strconv.Atoi(ids[j])
+			return a < b
+		})
+		for _, id := range ids {
+			devices = append(devices, gresDevice{gpu: gpu, instance: id})
+		}
+	}
+	return devices
+}
+
+// resolveGRESIndices turns the GRES indices of allocations into GPU
+// indices and MIG instances. Without MIG instances the indices are the GPU
+// indices and are kept.
+func resolveGRESIndices(allocs []GPUAllocation, devices []gresDevice) {
+	mig := false
+	for _, d := range devices {
+		if d.instance != "" {
+			mig = true
+		}
+	}
+	if !mig {
+		return
+	}
+	for i := range allocs {
+		if idx := allocs[i].GPUIndex; idx >= 0 && idx < len(devices) {
+			allocs[i].GPUIndex = devices[idx].gpu
+			allocs[i].GPUInstance = devices[idx].instance
+		}
+	}
+}
+
+// matchesAllocation reports whether an event is on one of the allocated
+// GPUs. Events of a whole GPU, such as Xid errors, count for all of its MIG
+// instances.
+func matchesAllocation(event GPUEvent, allocs []GPUAllocation) bool {
+	for _, a := range allocs {
+		if a.GPUIndex != event.GPUIndex {
+			continue
+		}
+		if a.GPUInstance == "" || event.GPUInstance == "" || a.GPUInstance == event.GPUInstance {
+			return true
+		}
+	}
+	return false
+}
@@ -53,6 +53,7 @@ type HookConfig struct {
 	AutoDrain       bool
 	DrainOnXid      []int
 	LogPath         string
+	HealthGate      bool // run the checks of slurm.prolog before the health score
 }
 
 const prologTemplate = `#!/bin/bash
@@ -89,6 +90,27 @@ if [[ ! -x "$AAMI_BIN" ]]; then
     exit 0
 fi
 
+{{- if .HealthGate }}
+
+# Run the health gate checks (slurm.prolog in the AAMI config)
+gate_status=0
+gate_output=$("$AAMI_BIN" slurm prolog-check --job "$JOB_ID" -o json 2>>"$LOG_FILE") || gate_status=$?
+if [[ "$gate_status" -ne 0 ]]; then
+    if [[ "$(echo "$gate_output" | jq -r '.rejected // false' 2>/dev/null)" == "true" ]]; then
+        failed=$(echo "$gate_output" | jq -r '[.results[] | select(.status == "failed" or .status == "timeout") | .check] | join(", ")')
+        gate_score=$(echo "$gate_output" | jq -r '.score')
+        log "ERROR: Health gate failed (score: $gate_score, checks: $failed), rejecting job"
+        echo "ERROR: GPU health gate failed on $NODE: $failed" >&2
+        echo "Please contact system administrators or try a different partition." >&2
+        {{- if .AutoDrain }}
+        "$AAMI_BIN" slurm drain "$NODE" --reason "AAMI: Health gate failed ($failed)" 2>/dev/null || true
+        {{- end }}
+        exit 1
+    fi
+    log "WARNING: Health gate could not run, allowing job to proceed"
+fi
+{{- end }}
+
 # Query GPU health
 health_output=$("$AAMI_BIN" health "$NODE" --json 2>/dev/null) || {
     log "WARNING: Failed to get GPU health, allowing job to proceed"
@@ -182,12 +204,18 @@ log "Post-job GPU health score: $score"
 if [[ "$EXIT_CODE" -ne 0 ]] && [[ "$score" -lt 70 ]]; then
     log "Potential GPU-related failure detected (exit=$EXIT_CODE, health=$score)"
 
-    # Log correlation data
+    # Report correlation data to the correlation API
+    correlation="possible"
+    if [[ "$score" -lt "$HEALTH_THRESHOLD" ]]; then
+        correlation="likely"
+    fi
     "$AAMI_BIN" slurm log-correlation \
         --job "$JOB_ID" \
         --node "$NODE" \
+        --user "$JOB_USER" \
         --score "$score" \
-        --exit-code "$EXIT_CODE" 2>/dev/null || true
+        --exit-code "$EXIT_CODE" \
+        --correlation "$correlation" >/dev/null 2>&1 || log "WARNING: Failed to report correlation"
 fi
 
 # Auto-drain if health is critical
@@ -245,6 +273,7 @@ func (h *HookManager) installScript(path, templateStr string) error {
 		AutoDrain:       h.config.AutoDrain,
 		DrainOnXid:      h.config.DrainOnXid,
 		LogPath:         "/var/log/aami",
+		HealthGate:      h.config.HealthGate,
 	}
 
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
@@ -0,0 +1,93 @@
+package slurm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandHostlist expands Slurm hostlist notation without the Slurm
+// commands, e.g. "gpu-[01-03,07],login1" -> gpu-01, gpu-02, gpu-03,
+// gpu-07, login1. Ranges keep the zero padding of their start.
+func expandHostlist(hostlist string) ([]string, error) {
+	var hosts []string
+	for _, expr := range splitHostlist(hostlist) {
+		expanded, err := expandHostExpr(expr)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+// splitHostlist splits a hostlist at the commas outside brackets
+func splitHostlist(hostlist string) []string {
+	var exprs []string
+	depth, start := 0, 0
+	for i, r := range hostlist {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				exprs = append(exprs, hostlist[start:i])
+				start = i + 1
+			}
+		}
+	}
+	exprs = append(exprs, hostlist[start:])
+
+	var nonEmpty []string
+	for _, e := range exprs {
+		if e = strings.TrimSpace(e); e != "" {
+			nonEmpty = append(nonEmpty, e)
+		}
+	}
+	return nonEmpty
+}
+
+// expandHostExpr expands one host expression, bracket by bracket
+func expandHostExpr(expr string) ([]string, error) {
+	open := strings.IndexByte(expr, '[')
+	if open < 0 {
+		if strings.ContainsRune(expr, ']') {
+			return nil, fmt.Errorf("invalid hostlist %q", expr)
+		}
+		return []string{expr}, nil
+	}
+	end := strings.IndexByte(expr[open:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("invalid hostlist %q: unclosed bracket", expr)
+	}
+	end += open
+	prefix, ranges, rest := expr[:open], expr[open+1:end], expr[end+1:]
+
+	suffixes, err := expandHostExpr(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, r := range strings.Split(ranges, ",") {
+		lo, hi, isRange := strings.Cut(r, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hostlist range %q", r)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid hostlist range %q", r)
+			}
+		}
+		for n := first; n <= last; n++ {
+			for _, suffix := range suffixes {
+				hosts = append(hosts, fmt.Sprintf("%s%0*d%s", prefix, len(lo), n, suffix))
+			}
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,505 @@
+package slurm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/gpuevents"
+)
+
+// DefaultDrainAuditLog receives a record for every drain the policy makes,
+// or would make in dry-run
+const DefaultDrainAuditLog = "/var/log/aami/auto-drain.log"
+
+// Policy defaults
+const (
+	DefaultPolicyInterval = time.Minute
+	DefaultDrainCooldown  = time.Hour
+	DefaultTempFor        = 5 * time.Minute
+)
+
+// Drain actions recorded in the audit log
+const (
+	DrainActionDrained = "drained"
+	DrainActionDryRun  = "dry-run"
+	DrainActionFailed  = "failed"
+	DrainActionResumed = "resumed"
+)
+
+// DrainRuleManual is the rule of drains and resumes made with 'aami slurm
+// drain' and 'aami slurm resume'; they do not start the policy's cooldowns
+const DrainRuleManual = "manual"
+
+// DrainRecord is a drain made, or that would have been made, by the policy.
+type DrainRecord struct {
+	Time       time.Time `json:"time"`
+	Rule       string    `json:"rule"`
+	Node       string    `json:"node"`
+	Partitions []string  `json:"partitions,omitempty"`
+	Reason     string    `json:"reason"` // as set in Slurm
+	Action     string    `json:"action"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DrainSkip is a node that met a rule but was left alone.
+type DrainSkip struct {
+	Rule   string `json:"rule"`
+	Node   string `json:"node"`
+	Reason string `json:"reason"`
+}
+
+// PolicyEvaluation is the outcome of evaluating every rule once.
+type PolicyEvaluation struct {
+	Time    time.Time     `json:"time"`
+	Records []DrainRecord `json:"records"`
+	Skipped []DrainSkip   `json:"skipped"`
+	Errors  []string      `json:"errors"` // rules that could not be evaluated
+}
+
+// Failed returns the drains that failed.
+func (e *PolicyEvaluation) Failed() int {
+	failed := 0
+	for _, r := range e.Records {
+		if r.Action == DrainActionFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// drainRule is a rule of the policy, compiled to PromQL
+type drainRule struct {
+	name       string
+	expr       string
+	describe   func(metric map[string]string, value string) string
+	partitions []string
+	cooldown   time.Duration
+}
+
+// DrainPolicy drains the Slurm nodes whose GPUs meet the conditions of its
+// rules. Every rule is a PromQL query evaluated on an interval; a node is
+// drained when it is in an allowed partition, still schedulable and not
+// drained by the policy within the rule's cooldown.
+type DrainPolicy struct {
+	client        *Client
+	prometheusURL string
+	httpClient    *http.Client
+	rules         []drainRule
+	interval      time.Duration
+	dryRun        bool
+	auditLog      string
+	lastDrain     map[string]time.Time // node -> last drain, from the audit log
+}
+
+// NewDrainPolicy compiles the configured policy and loads the last drain of
+// every node from the audit log, so that a restart keeps the cooldowns.
+func NewDrainPolicy(client *Client, prometheusURL string, cfg config.DrainPolicyConfig) (*DrainPolicy, error) {
+	p := &DrainPolicy{
+		client:        client,
+		prometheusURL: strings.TrimRight(prometheusURL, "/"),
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		interval:      DefaultPolicyInterval,
+		dryRun:        cfg.DryRun,
+		auditLog:      cfg.AuditLog,
+		lastDrain:     make(map[string]time.Time),
+	}
+	if p.auditLog == "" {
+		p.auditLog = DefaultDrainAuditLog
+	}
+
+	var err error
+	if cfg.Interval != "" {
+		if p.interval, err = time.ParseDuration(cfg.Interval); err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", cfg.Interval, err)
+		}
+	}
+	cooldown := DefaultDrainCooldown
+	if cfg.Cooldown != "" {
+		if cooldown, err = time.ParseDuration(cfg.Cooldown); err != nil {
+			return nil, fmt.Errorf("invalid cooldown %q: %w", cfg.Cooldown, err)
+		}
+	}
+
+	for _, rc := range cfg.Rules {
+		rule, err := p.compileRule(rc, cfg.Partitions, cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rc.Name, err)
+		}
+		p.rules = append(p.rules, rule)
+	}
+	if len(p.rules) == 0 {
+		return nil, fmt.Errorf("no drain rules configured")
+	}
+
+	records, err := LoadDrainAudit(p.auditLog)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Rule == DrainRuleManual || r.Action == DrainActionFailed || r.Action == DrainActionResumed {
+			continue
+		}
+		if r.Time.After(p.lastDrain[r.Node]) {
+			p.lastDrain[r.Node] = r.Time
+		}
+	}
+	return p, nil
+}
+
+// DryRun reports whether drains are only audited.
+func (p *DrainPolicy) DryRun() bool {
+	return p.dryRun
+}
+
+// Interval returns the evaluation interval.
+func (p *DrainPolicy) Interval() time.Duration {
+	return p.interval
+}
+
+// NumRules returns the number of rules.
+func (p *DrainPolicy) NumRules() int {
+	return len(p.rules)
+}
+
+// AuditLog returns the path of the audit log.
+func (p *DrainPolicy) AuditLog() string {
+	return p.auditLog
+}
+
+// compileRule turns a configured rule into its PromQL query. Xid and ECC
+// conditions only match errors new within the lookback, twice the
+// interval, so that the gauge DCGM keeps at the last Xid does not drain a
+// node again after it is resumed.
+func (p *DrainPolicy) compileRule(rc config.DrainRule, partitions []string, cooldown time.Duration) (drainRule, error) {
+	rule := drainRule{name: rc.Name, partitions: partitions, cooldown: cooldown}
+	if len(rc.Partitions) > 0 {
+		rule.partitions = rc.Partitions
+	}
+	if rc.Cooldown != "" {
+		d, err := time.ParseDuration(rc.Cooldown)
+		if err != nil {
+			return rule, fmt.Errorf("invalid cooldown %q: %w", rc.Cooldown, err)
+		}
+		rule.cooldown = d
+	}
+	lookback := promDuration(2 * p.interval)
+
+	switch {
+	case len(rc.Xid) > 0:
+		const metric = "DCGM_FI_DEV_XID_ERRORS"
+		var codes []string
+		for _, code := range rc.Xid {
+			codes = append(codes, fmt.Sprintf("%s == %d", metric, code))
+		}
+		rule.expr = fmt.Sprintf("(%s) and (changes(%s[%s]) > 0 or (%s unless %s offset %s))",
+			strings.Join(codes, " or "), metric, lookback, metric, metric, lookback)
+		rule.describe = func(m map[string]string, value string) string {
+			code, _ := gpuevents.ParseValue(value)
+			if c, ok := gpuevents.Lookup(gpuevents.KindXid, code); ok {
+				return fmt.Sprintf("%s%s (%s)", c.Label(), onGPU(m), c.Meaning)
+			}
+			return fmt.Sprintf("Xid %s%s", value, onGPU(m))
+		}
+	case rc.ECCDBE:
+		rule.expr = fmt.Sprintf("increase(DCGM_FI_DEV_ECC_DBE_VOL_TOTAL[%s]) > 0", lookback)
+		rule.describe = func(m map[string]string, value string) string {
+			return "double-bit ECC error" + onGPU(m)
+		}
+	case rc.TempAbove > 0:
+		sustained, forText := DefaultTempFor, "5m"
+		if rc.For != "" {
+			d, err := time.ParseDuration(rc.For)
+			if err != nil {
+				return rule, fmt.Errorf("invalid for %q: %w", rc.For, err)
+			}
+			sustained, forText = d, rc.For
+		}
+		rule.expr = fmt.Sprintf("min_over_time(DCGM_FI_DEV_GPU_TEMP[%s]) > %g", promDuration(sustained), rc.TempAbove)
+		rule.describe = func(m map[string]string, value string) string {
+			return fmt.Sprintf("temperature above %g°C for %s%s (min %s°C)", rc.TempAbove, forText, onGPU(m), value)
+		}
+	case rc.Alert != "":
+		rule.expr = fmt.Sprintf("ALERTS{alertname=%q, alertstate=\"firing\"}", rc.Alert)
+		rule.describe = func(m map[string]string, value string) string {
+			return fmt.Sprintf("alert %s firing%s", rc.Alert, onGPU(m))
+		}
+	case rc.Expr != "":
+		rule.expr = rc.Expr
+		rule.describe = func(m map[string]string, value string) string {
+			return fmt.Sprintf("matched with value %s%s", value, onGPU(m))
+		}
+	default:
+		return rule, fmt.Errorf("no condition: set one of xid, ecc_dbe, temp_above, alert or expr")
+	}
+	return rule, nil
+}
+
+// Evaluate evaluates every rule once and drains the nodes that meet one.
+// Rules that cannot be evaluated are reported in the evaluation; an error
+// is only returned when the audit log cannot be written.
+func (p *DrainPolicy) Evaluate(ctx context.Context) (*PolicyEvaluation, error) {
+	eval := &PolicyEvaluation{
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Records: []DrainRecord{},
+		Skipped: []DrainSkip{},
+		Errors:  []string{},
+	}
+	nodes := make(map[string]*NodeInfo) // scontrol answers, for this evaluation
+
+	for _, rule := range p.rules {
+		samples, err := p.query(ctx, rule.expr)
+		if err != nil {
+			eval.Errors = append(eval.Errors, fmt.Sprintf("rule %s: %v", rule.name, err))
+			continue
+		}
+
+		// A node may match on several GPUs; drain it once for all of them
+		var order []string
+		details := make(map[string][]string)
+		for _, s := range samples {
+			node := seriesNode(s.Metric)
+			if node == "" {
+				eval.Skipped = append(eval.Skipped, DrainSkip{Rule: rule.name, Node: "?", Reason: "series without node label"})
+				continue
+			}
+			if _, ok := details[node]; !ok {
+				order = append(order, node)
+			}
+			details[node] = append(details[node], rule.describe(s.Metric, s.Value))
+		}
+
+		for _, node := range order {
+			skip := func(reason string) {
+				eval.Skipped = append(eval.Skipped, DrainSkip{Rule: rule.name, Node: node, Reason: reason})
+			}
+
+			if last, ok := p.lastDrain[node]; ok && eval.Time.Sub(last) < rule.cooldown {
+				skip(fmt.Sprintf("cooling down, last drain %s ago", eval.Time.Sub(last).Round(time.Second)))
+				continue
+			}
+
+			info, ok := nodes[node]
+			if !ok {
+				info, err = p.client.GetNode(ctx, node)
+				if err != nil {
+					skip(err.Error())
+					continue
+				}
+				nodes[node] = info
+			}
+			if !inPartitions(info.Partitions, rule.partitions) {
+				skip(fmt.Sprintf("partitions %s not allowed", strings.Join(info.Partitions, ",")))
+				continue
+			}
+			if info.Unavailable() {
+				skip("already " + strings.Join(append([]string{string(info.State)}, info.StateFlags...), "+"))
+				continue
+			}
+
+			record := DrainRecord{
+				Time:       eval.Time,
+				Rule:       rule.name,
+				Node:       node,
+				Partitions: info.Partitions,
+				Reason:     drainReason(rule.name, details[node]),
+				Action:     DrainActionDryRun,
+			}
+			if !p.dryRun {
+				record.Action = DrainActionDrained
+				if err := p.client.DrainNode(ctx, node, record.Reason); err != nil {
+					record.Action = DrainActionFailed
+					record.Error = strings.TrimSpace(err.Error())
+				} else {
+					info.StateFlags = append(info.StateFlags, string(NodeStateDrain))
+				}
+			}
+			if record.Action != DrainActionFailed {
+				p.lastDrain[node] = record.Time
+			}
+			if err := AppendDrainAudit(p.auditLog, &record); err != nil {
+				return eval, fmt.Errorf("record drain of %s in audit log: %w", node, err)
+			}
+			eval.Records = append(eval.Records, record)
+		}
+	}
+	return eval, nil
+}
+
+// Run evaluates the policy every interval until ctx is done, passing each
+// evaluation or error to report.
+func (p *DrainPolicy) Run(ctx context.Context, report func(*PolicyEvaluation, error)) {
+	for {
+		evalCtx, cancel := context.WithTimeout(ctx, p.interval)
+		eval, err := p.Evaluate(evalCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		report(eval, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// instantSample is a series of an instant query result
+type instantSample struct {
+	Metric map[string]string
+	Value  string
+}
+
+// query runs an instant query
+func (p *DrainPolicy) query(ctx context.Context, expr string) ([]instantSample, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/query?%s", p.prometheusURL, url.Values{"query": {expr}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  []interface{}     `json:"value"` // [timestamp, value]
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("prometheus returned status %d", resp.StatusCode)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("prometheus: %s", result.Error)
+	}
+
+	var samples []instantSample
+	for _, series := range result.Data.Result {
+		sample := instantSample{Metric: series.Metric}
+		if len(series.Value) == 2 {
+			sample.Value, _ = series.Value[1].(string)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// seriesNode returns the Slurm node of a series: the node label set on the
+// targets, else the host DCGM reports, else the instance without its port
+func seriesNode(metric map[string]string) string {
+	for _, label := range []string{"node", "Hostname", "hostname"} {
+		if v := metric[label]; v != "" {
+			return v
+		}
+	}
+	if instance := metric["instance"]; instance != "" {
+		return strings.Split(instance, ":")[0]
+	}
+	return ""
+}
+
+// onGPU describes the GPU of a series, if it has one
+func onGPU(metric map[string]string) string {
+	if gpu, ok := metric["gpu"]; ok {
+		return " on GPU " + gpu
+	}
+	return ""
+}
+
+// inPartitions reports whether a node in partitions may be drained; every
+// node may without an allowlist
+func inPartitions(partitions, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, p := range partitions {
+		for _, a := range allowed {
+			if p == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// drainReason is the reason set in Slurm, kept short for sinfo -R
+func drainReason(rule string, details []string) string {
+	reason := fmt.Sprintf("AAMI auto-drain %s: %s", rule, details[0])
+	if len(details) > 1 {
+		reason += fmt.Sprintf(" (+%d more)", len(details)-1)
+	}
+	return reason
+}
+
+// promDuration formats a duration for PromQL, in whole seconds
+func promDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d/time.Second))
+}
+
+// AppendDrainAudit appends a record to the audit log.
+func AppendDrainAudit(path string, record *DrainRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// LoadDrainAudit reads the audit log, oldest first. A line cut short by a
+// crash is skipped.
+func LoadDrainAudit(path string) ([]DrainRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []DrainRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r DrainRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
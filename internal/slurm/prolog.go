@@ -0,0 +1,467 @@
+package slurm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/gpuevents"
+)
+
+// DefaultPolicyCheckCache is where policy checks are kept for when the
+// config server cannot be reached
+const DefaultPolicyCheckCache = "/var/lib/aami/prolog-checks"
+
+// DefaultPrologTimeout is how long a prolog check may run
+const DefaultPrologTimeout = 10 * time.Second
+
+// prologXidWindow is how far back the xid check scans the kernel log
+const prologXidWindow = time.Hour
+
+// prologCheckPrefix names prolog checks in the check result API, apart
+// from the agent's checks of the same policies
+const prologCheckPrefix = "prolog/"
+
+// Built-in prolog checks
+const (
+	PrologCheckNvidiaSMI = "nvidia-smi" // every GPU answers, without uncorrectable ECC errors
+	PrologCheckXid       = "xid"        // no critical Xid in the kernel log of the last hour
+	PrologCheckNVLink    = "nvlink"     // no NVLink down
+)
+
+// PolicyCheck is a check assigned to a node by the config server's script
+// policies.
+type PolicyCheck struct {
+	Name          string                 `json:"name"`
+	ScriptContent string                 `json:"script_content"`
+	ScriptHash    string                 `json:"script_hash"`
+	Config        map[string]interface{} `json:"config"`
+}
+
+// FetchPolicyChecks returns the checks the config server assigns to a
+// node, and caches them in cacheDir. When the server cannot be reached the
+// cached checks are returned, with the error.
+func FetchPolicyChecks(ctx context.Context, serverURL, hostname, credential, cacheDir string) ([]PolicyCheck, error) {
+	checks, err := fetchPolicyChecks(ctx, serverURL, hostname, credential)
+	if err != nil {
+		cached, cacheErr := readPolicyCheckCache(cacheDir)
+		if cacheErr != nil {
+			return nil, err
+		}
+		return cached, err
+	}
+	if err := writePolicyCheckCache(cacheDir, checks); err != nil {
+		return checks, err
+	}
+	return checks, nil
+}
+
+func fetchPolicyChecks(ctx context.Context, serverURL, hostname, credential string) ([]PolicyCheck, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/checks/target/hostname/%s", strings.TrimRight(serverURL, "/"), url.PathEscape(hostname))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch policy checks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("fetch policy checks: config server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var checks []PolicyCheck
+	if err := json.NewDecoder(resp.Body).Decode(&checks); err != nil {
+		return nil, fmt.Errorf("fetch policy checks: decode: %w", err)
+	}
+	return checks, nil
+}
+
+func policyCheckCachePath(dir string) string {
+	return filepath.Join(dir, "checks.json")
+}
+
+func readPolicyCheckCache(dir string) ([]PolicyCheck, error) {
+	data, err := os.ReadFile(policyCheckCachePath(dir))
+	if err != nil {
+		return nil, err
+	}
+	var checks []PolicyCheck
+	if err := json.Unmarshal(data, &checks); err != nil {
+		return nil, err
+	}
+	return checks, nil
+}
+
+func writePolicyCheckCache(dir string, checks []PolicyCheck) error {
+	data, err := json.Marshal(checks)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("cache policy checks: %w", err)
+	}
+	tmp := policyCheckCachePath(dir) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("cache policy checks: %w", err)
+	}
+	return os.Rename(tmp, policyCheckCachePath(dir))
+}
+
+// prologCheck is a compiled check of the gate
+type prologCheck struct {
+	name    string
+	builtin string
+	command string
+	policy  *PolicyCheck
+	weight  int
+	timeout time.Duration
+}
+
+// PrologGate runs the fast GPU checks of the prolog hook and decides
+// whether a job may start on the node.
+type PrologGate struct {
+	node      string
+	checks    []prologCheck
+	failAbove int
+}
+
+// NewPrologGate compiles the checks of cfg. Policy checks are looked up in
+// policies, those of the node's script policies.
+func NewPrologGate(node string, cfg config.PrologConfig, policies []PolicyCheck) (*PrologGate, error) {
+	timeout := DefaultPrologTimeout
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", cfg.Timeout, err)
+		}
+		timeout = d
+	}
+
+	byName := make(map[string]*PolicyCheck, len(policies))
+	for i := range policies {
+		byName[policies[i].Name] = &policies[i]
+	}
+
+	g := &PrologGate{node: node, failAbove: cfg.FailAbove}
+	for _, c := range cfg.Checks {
+		check := prologCheck{
+			name:    c.Name,
+			builtin: c.Builtin,
+			command: c.Command,
+			weight:  1,
+			timeout: timeout,
+		}
+		if check.name == "" {
+			check.name = c.Builtin + c.Policy
+		}
+		if c.Weight != nil {
+			check.weight = *c.Weight
+		}
+		if c.Timeout != "" {
+			d, err := time.ParseDuration(c.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("check %s: invalid timeout %q: %w", check.name, c.Timeout, err)
+			}
+			check.timeout = d
+		}
+		if c.Policy != "" {
+			// A policy not assigned to the node is run as a check that
+			// cannot run, so that it is reported
+			check.policy = byName[c.Policy]
+			if check.policy == nil {
+				check.policy = &PolicyCheck{Name: c.Policy}
+			}
+		}
+		g.checks = append(g.checks, check)
+	}
+	return g, nil
+}
+
+// NumChecks returns the number of checks of the gate.
+func (g *PrologGate) NumChecks() int {
+	return len(g.checks)
+}
+
+// PrologReport is the outcome of the gate for a job.
+type PrologReport struct {
+	Node      string               `json:"node"`
+	JobID     int64                `json:"job_id,omitempty"`
+	Results   []checkresult.Result `json:"results"`
+	Score     int                  `json:"score"` // weight of the checks that failed or timed out
+	FailAbove int                  `json:"fail_above"`
+	Rejected  bool                 `json:"rejected"`
+}
+
+// Run runs the checks concurrently, so that the gate takes as long as its
+// slowest check. Checks that cannot be run are reported but do not count
+// against the node.
+func (g *PrologGate) Run(ctx context.Context, jobID int64) PrologReport {
+	report := PrologReport{
+		Node:      g.node,
+		JobID:     jobID,
+		Results:   make([]checkresult.Result, len(g.checks)),
+		FailAbove: g.failAbove,
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range g.checks {
+		wg.Add(1)
+		go func(i int, check prologCheck) {
+			defer wg.Done()
+			report.Results[i] = g.runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, r := range report.Results {
+		if r.Status == checkresult.StatusFailed || r.Status == checkresult.StatusTimeout {
+			report.Score += g.checks[i].weight
+		}
+	}
+	report.Rejected = report.Score > g.failAbove
+	return report
+}
+
+func (g *PrologGate) runCheck(ctx context.Context, check prologCheck) checkresult.Result {
+	result := checkresult.Result{
+		Target:    g.node,
+		Check:     prologCheckPrefix + check.name,
+		StartedAt: time.Now().UTC(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, check.timeout)
+	defer cancel()
+
+	var output string
+	var err error
+	switch {
+	case check.builtin != "":
+		output, err = runBuiltinCheck(ctx, check.builtin)
+	case check.policy != nil:
+		output, err = runPolicyCheck(ctx, check.policy)
+	default:
+		output, err = runCommand(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", check.command), nil)
+	}
+	result.DurationMS = time.Since(result.StartedAt).Milliseconds()
+	result.Output = truncateOutput(output)
+
+	var exitErr *exec.ExitError
+	var failure *checkFailure
+	switch {
+	case err == nil:
+		result.Status = checkresult.StatusPassed
+		code := 0
+		result.ExitCode = &code
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Status = checkresult.StatusTimeout
+		result.Error = fmt.Sprintf("timed out after %s", check.timeout)
+	case errors.As(err, &failure):
+		result.Status = checkresult.StatusFailed
+		result.Error = truncateOutput(failure.msg)
+	case errors.As(err, &exitErr):
+		result.Status = checkresult.StatusFailed
+		code := exitErr.ExitCode()
+		result.ExitCode = &code
+		result.Error = truncateOutput(strings.TrimSpace(string(exitErr.Stderr)))
+	default:
+		result.Status = checkresult.StatusError
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkFailure is a built-in check that ran and found a problem
+type checkFailure struct {
+	msg string
+}
+
+func (f *checkFailure) Error() string { return f.msg }
+
+func truncateOutput(s string) string {
+	if len(s) > checkresult.MaxOutputBytes {
+		return s[:checkresult.MaxOutputBytes]
+	}
+	return s
+}
+
+// runCommand runs cmd with stdin, returning its output; a non-zero exit is
+// an *exec.ExitError with the standard error
+func runCommand(ctx context.Context, cmd *exec.Cmd, stdin []byte) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children of a killed script may hold its output open
+	cmd.WaitDelay = time.Second
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+	return stdout.String(), err
+}
+
+// runPolicyCheck runs a policy check as the node agent does: the script,
+// with its config as JSON on stdin
+func runPolicyCheck(ctx context.Context, check *PolicyCheck) (string, error) {
+	if check.ScriptContent == "" {
+		return "", fmt.Errorf("policy check %s is not assigned to this node", check.Name)
+	}
+	f, err := os.CreateTemp("", "aami-prolog-*.sh")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(check.ScriptContent); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Chmod(0700); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+
+	cfg := check.Config
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	stdin, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return runCommand(ctx, exec.CommandContext(ctx, f.Name()), stdin)
+}
+
+func runBuiltinCheck(ctx context.Context, name string) (string, error) {
+	switch name {
+	case PrologCheckNvidiaSMI:
+		return checkNvidiaSMI(ctx)
+	case PrologCheckXid:
+		return checkXid(ctx)
+	case PrologCheckNVLink:
+		return checkNVLink(ctx)
+	default:
+		return "", fmt.Errorf("unknown built-in check %q", name)
+	}
+}
+
+// checkNvidiaSMI fails if a GPU is lost or has uncorrectable ECC errors
+func checkNvidiaSMI(ctx context.Context) (string, error) {
+	output, err := runCommand(ctx, exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu=index,pci.bus_id,ecc.errors.uncorrected.volatile.total",
+		"--format=csv,noheader,nounits"), nil)
+	if err != nil {
+		return output, err
+	}
+
+	var problems []string
+	gpus := 0
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		gpus++
+		index, ecc := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[2])
+		switch {
+		case strings.Contains(line, "GPU is lost") || strings.Contains(line, "ERR!"):
+			problems = append(problems, fmt.Sprintf("GPU %s: %s", index, strings.TrimSpace(line)))
+		case ecc != "0" && ecc != "[N/A]" && ecc != "N/A":
+			problems = append(problems, fmt.Sprintf("GPU %s: %s uncorrectable ECC errors", index, ecc))
+		}
+	}
+	if gpus == 0 {
+		return output, &checkFailure{"nvidia-smi reported no GPU"}
+	}
+	if len(problems) > 0 {
+		return output, &checkFailure{strings.Join(problems, "; ")}
+	}
+	return output, nil
+}
+
+var xidPattern = regexp.MustCompile(`NVRM: Xid \(([^)]*)\): (\d+)`)
+
+// checkXid fails on Xid errors of critical severity in the kernel log
+func checkXid(ctx context.Context) (string, error) {
+	since := fmt.Sprintf("-%ds", int(prologXidWindow.Seconds()))
+	output, err := runCommand(ctx, exec.CommandContext(ctx, "journalctl", "-k", "--since", since, "-o", "cat", "--no-pager"), nil)
+	if err != nil {
+		return "", err
+	}
+
+	var problems []string
+	seen := make(map[string]bool)
+	for _, m := range xidPattern.FindAllStringSubmatch(output, -1) {
+		code, _ := strconv.Atoi(m[2])
+		c, ok := gpuevents.Lookup(gpuevents.KindXid, code)
+		if !ok || c.Severity != gpuevents.SeverityCritical {
+			continue
+		}
+		problem := fmt.Sprintf("%s on %s: %s — %s", c.Label(), m[1], c.Meaning, c.Action)
+		if !seen[problem] {
+			seen[problem] = true
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return "", &checkFailure{strings.Join(problems, "; ")}
+	}
+	return "", nil
+}
+
+var nvlinkPattern = regexp.MustCompile(`Link\s+(\d+):\s*(.*)`)
+
+// checkNVLink fails if an NVLink of a GPU is inactive or in error. GPUs
+// without NVLink pass.
+func checkNVLink(ctx context.Context) (string, error) {
+	output, err := runCommand(ctx, exec.CommandContext(ctx, "nvidia-smi", "nvlink", "--status"), nil)
+	if err != nil {
+		return output, err
+	}
+
+	var problems []string
+	gpu := ""
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			gpu, _, _ = strings.Cut(strings.TrimPrefix(line, "GPU "), ":")
+			continue
+		}
+		m := nvlinkPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		status := strings.ToLower(m[2])
+		if strings.Contains(status, "inactive") || strings.Contains(status, "error") {
+			problems = append(problems, fmt.Sprintf("GPU %s link %s: %s", gpu, m[1], strings.TrimSpace(m[2])))
+		}
+	}
+	if len(problems) > 0 {
+		return output, &checkFailure{strings.Join(problems, "; ")}
+	}
+	return output, nil
+}
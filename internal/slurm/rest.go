@@ -0,0 +1,605 @@
+package slurm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAPIVersion is the slurmrestd API used when none is configured
+const DefaultAPIVersion = "v0.0.40"
+
+// DefaultTokenLifetime is the lifetime of tokens issued with scontrol token
+const DefaultTokenLifetime = time.Hour
+
+// errRESTNotFound is a job or node slurmrestd does not know, such as a job
+// that finished and is only in the accounting database
+var errRESTNotFound = errors.New("not found by slurmrestd")
+
+// restUnavailable is a slurmrestd that did not answer, failed or rejected
+// the token even after a refresh: the Slurm commands may still work.
+type restUnavailable struct {
+	err error
+}
+
+func (u *restUnavailable) Error() string { return u.err.Error() }
+
+// restClient calls slurmrestd with a JWT: the configured one until it
+// expires or is rejected, then tokens issued with scontrol token and
+// renewed when a third of their lifetime is left.
+type restClient struct {
+	endpoint   string
+	version    string
+	user       string
+	lifetime   time.Duration
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero when unknown
+}
+
+func newRESTClient(cfg SlurmConfig, httpClient *http.Client) *restClient {
+	r := &restClient{
+		endpoint:   strings.TrimRight(cfg.Endpoint, "/"),
+		version:    cfg.APIVersion,
+		user:       cfg.User,
+		lifetime:   cfg.TokenLifetime,
+		httpClient: httpClient,
+		token:      cfg.AuthToken,
+	}
+	if r.version == "" {
+		r.version = DefaultAPIVersion
+	}
+	if r.lifetime <= 0 {
+		r.lifetime = DefaultTokenLifetime
+	}
+	if r.token != "" {
+		r.expires = tokenExpiry(r.token)
+	}
+	return r
+}
+
+// currentToken returns a token that is not about to expire, issuing one if
+// needed
+func (r *restClient) currentToken(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.token != "" && (r.expires.IsZero() || time.Until(r.expires) > r.lifetime/3) {
+		return r.token, nil
+	}
+	if err := r.issueToken(ctx); err != nil {
+		return "", err
+	}
+	return r.token, nil
+}
+
+// refreshToken replaces a token slurmrestd rejected
+func (r *restClient) refreshToken(ctx context.Context, rejected string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.token != rejected {
+		// Another request refreshed it meanwhile
+		return nil
+	}
+	return r.issueToken(ctx)
+}
+
+// issueToken gets a token with scontrol token; the caller holds mu
+func (r *restClient) issueToken(ctx context.Context) error {
+	args := []string{"token", fmt.Sprintf("lifespan=%d", int64(r.lifetime/time.Second))}
+	if r.user != "" {
+		args = append(args, "username="+r.user)
+	}
+	output, err := exec.CommandContext(ctx, "scontrol", args...).CombinedOutput()
+	if err != nil {
+		return &restUnavailable{fmt.Errorf("scontrol token: %s", strings.TrimSpace(string(output)))}
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(line), "SLURM_JWT="); ok && token != "" {
+			r.token = token
+			r.expires = tokenExpiry(token)
+			if r.expires.IsZero() {
+				r.expires = time.Now().Add(r.lifetime)
+			}
+			return nil
+		}
+	}
+	return &restUnavailable{fmt.Errorf("scontrol token: no SLURM_JWT in output")}
+}
+
+// tokenExpiry reads the exp claim of a JWT, without verifying it; zero if
+// it has none
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// call sends a request to /slurm/<version><path> and decodes the answer
+// into out. A rejected token is refreshed once.
+func (r *restClient) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, err := r.currentToken(ctx)
+		if err != nil {
+			return err
+		}
+		status, data, err := r.send(ctx, method, path, token, payload)
+		if err != nil {
+			return &restUnavailable{err}
+		}
+
+		switch {
+		case (status == http.StatusUnauthorized || status == http.StatusForbidden) && attempt == 0:
+			if err := r.refreshToken(ctx, token); err != nil {
+				return err
+			}
+			continue
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			return &restUnavailable{fmt.Errorf("slurmrestd %s: token rejected: %s", path, restErrors(data))}
+		case status == http.StatusNotFound:
+			return errRESTNotFound
+		case status >= 500:
+			return &restUnavailable{fmt.Errorf("slurmrestd %s returned status %d: %s", path, status, restErrors(data))}
+		case status != http.StatusOK:
+			return fmt.Errorf("slurmrestd %s returned status %d: %s", path, status, restErrors(data))
+		}
+
+		if msg := restErrors(data); msg != "" {
+			return fmt.Errorf("slurmrestd %s: %s", path, msg)
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("slurmrestd %s: decode: %w", path, err)
+		}
+		return nil
+	}
+}
+
+func (r *restClient) send(ctx context.Context, method, path, token string, payload []byte) (int, []byte, error) {
+	endpoint := fmt.Sprintf("%s/slurm/%s%s", r.endpoint, r.version, path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("X-SLURM-USER-TOKEN", token)
+	if r.user != "" {
+		req.Header.Set("X-SLURM-USER-NAME", r.user)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("slurm API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return 0, nil, fmt.Errorf("slurm API response: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// restErrors returns the errors slurmrestd reported in a response, or the
+// start of a body that is not JSON
+func restErrors(data []byte) string {
+	var resp struct {
+		Errors []struct {
+			Error       string `json:"error"`
+			Description string `json:"description"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		if len(data) > 200 {
+			data = data[:200]
+		}
+		return strings.TrimSpace(string(data))
+	}
+	var msgs []string
+	for _, e := range resp.Errors {
+		msg := e.Error
+		if e.Description != "" && e.Description != e.Error {
+			msg = strings.TrimSpace(e.Description + ": " + msg)
+		}
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// restNumber is a slurmrestd number: plain before v0.0.39, then an object
+// telling whether it is set or infinite.
+type restNumber struct {
+	Value int64
+	Set   bool
+}
+
+func (n *restNumber) UnmarshalJSON(data []byte) error {
+	var plain float64
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*n = restNumber{Value: int64(plain), Set: true}
+		return nil
+	}
+	var obj struct {
+		Set      bool    `json:"set"`
+		Infinite bool    `json:"infinite"`
+		Number   float64 `json:"number"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*n = restNumber{Value: int64(obj.Number), Set: obj.Set && !obj.Infinite}
+	return nil
+}
+
+// time returns the number as a Unix time, zero if unset
+func (n restNumber) time() time.Time {
+	if !n.Set || n.Value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(n.Value, 0)
+}
+
+// restStrings is a list slurmrestd sends as an array, or in older versions
+// as a comma-separated string.
+type restStrings []string
+
+func (s *restStrings) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*s = list
+		return nil
+	}
+	var csv string
+	if err := json.Unmarshal(data, &csv); err != nil {
+		return err
+	}
+	*s = nil
+	for _, v := range strings.Split(csv, ",") {
+		if v = strings.TrimSpace(v); v != "" && v != "(null)" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// restExitCode is a job's exit code: plain before v0.0.39, then an object
+// with the return code.
+type restExitCode int
+
+func (e *restExitCode) UnmarshalJSON(data []byte) error {
+	var plain int
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*e = restExitCode(plain)
+		return nil
+	}
+	var obj struct {
+		ReturnCode restNumber `json:"return_code"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*e = restExitCode(obj.ReturnCode.Value)
+	return nil
+}
+
+// restJob is a job of the slurmrestd jobs endpoints
+type restJob struct {
+	JobID            int64        `json:"job_id"`
+	Name             string       `json:"name"`
+	UserName         string       `json:"user_name"`
+	GroupName        string       `json:"group_name"`
+	Partition        string       `json:"partition"`
+	JobState         restStrings  `json:"job_state"`
+	ExitCode         restExitCode `json:"exit_code"`
+	Nodes            string       `json:"nodes"`
+	NodeCount        restNumber   `json:"node_count"`
+	StartTime        restNumber   `json:"start_time"`
+	EndTime          restNumber   `json:"end_time"`
+	SubmitTime       restNumber   `json:"submit_time"`
+	TimeLimit        restNumber   `json:"time_limit"` // minutes
+	WorkDir          string       `json:"work_dir"`
+	CurrentDirectory string       `json:"current_working_directory"`
+	Command          string       `json:"command"`
+	StandardOutput   string       `json:"standard_output"`
+	StandardError    string       `json:"standard_error"`
+	Account          string       `json:"account"`
+	QOS              string       `json:"qos"`
+	Priority         restNumber   `json:"priority"`
+	StateReason      string       `json:"state_reason"`
+	Features         string       `json:"features"`
+	TRESPerNode      string       `json:"tres_per_node"`
+	GresDetail       []string     `json:"gres_detail"` // per node, e.g. gpu:a100:2(IDX:0-1)
+}
+
+func (j restJob) toJob() Job {
+	job := Job{
+		ID:         j.JobID,
+		Name:       j.Name,
+		User:       j.UserName,
+		Group:      j.GroupName,
+		Partition:  j.Partition,
+		ExitCode:   int(j.ExitCode),
+		NodeCount:  int(j.NodeCount.Value),
+		StartTime:  j.StartTime.time(),
+		EndTime:    j.EndTime.time(),
+		SubmitTime: j.SubmitTime.time(),
+		WorkDir:    j.WorkDir,
+		Command:    j.Command,
+		StdOut:     j.StandardOutput,
+		StdErr:     j.StandardError,
+		Account:    j.Account,
+		QOS:        j.QOS,
+		Priority:   int(j.Priority.Value),
+		Reason:     j.StateReason,
+	}
+	if len(j.JobState) > 0 {
+		job.State = JobState(j.JobState[0])
+	}
+	if job.WorkDir == "" {
+		job.WorkDir = j.CurrentDirectory
+	}
+	if j.TimeLimit.Set {
+		job.TimeLimit = time.Duration(j.TimeLimit.Value) * time.Minute
+	}
+	if j.Features != "" && j.Features != "(null)" {
+		job.Features = strings.Split(j.Features, ",")
+	}
+	if j.TRESPerNode != "" {
+		// e.g. gres/gpu:4 or gres:gpu:a100:4
+		job.Constraints = j.TRESPerNode
+		job.GPUCount = parseGPUCount(strings.NewReplacer("gres/", "", "gres:", "").Replace(j.TRESPerNode))
+	}
+	if nodes, err := expandHostlist(j.Nodes); err == nil {
+		job.Nodes = nodes
+	} else {
+		job.Nodes = []string{j.Nodes}
+	}
+	if len(j.GresDetail) == len(job.Nodes) {
+		for i, node := range job.Nodes {
+			job.GPUs = append(job.GPUs, parseGRESDetail(node, j.GresDetail[i])...)
+		}
+	}
+	return job
+}
+
+func (r *restClient) getJob(ctx context.Context, jobID int64) (*Job, error) {
+	var resp struct {
+		Jobs []restJob `json:"jobs"`
+	}
+	if err := r.call(ctx, http.MethodGet, fmt.Sprintf("/job/%d", jobID), nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Jobs) == 0 {
+		return nil, errRESTNotFound
+	}
+	job := resp.Jobs[0].toJob()
+	return &job, nil
+}
+
+// getJobs returns the jobs slurmctld knows, filtered here as slurmrestd
+// only filters by update time
+func (r *restClient) getJobs(ctx context.Context, filter JobFilter) ([]Job, error) {
+	var resp struct {
+		Jobs []restJob `json:"jobs"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/jobs", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, j := range resp.Jobs {
+		job := j.toJob()
+		if matchesJobFilter(job, filter) {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// matchesJobFilter applies the filters squeue would
+func matchesJobFilter(job Job, f JobFilter) bool {
+	switch {
+	case f.User != "" && job.User != f.User:
+		return false
+	case f.Partition != "" && job.Partition != f.Partition:
+		return false
+	case f.State != "" && job.State != f.State:
+		return false
+	case f.Account != "" && job.Account != f.Account:
+		return false
+	}
+	if f.Node == "" {
+		return true
+	}
+	for _, n := range job.Nodes {
+		if n == f.Node {
+			return true
+		}
+	}
+	return false
+}
+
+// restNode is a node of the slurmrestd nodes endpoints
+type restNode struct {
+	Name        string      `json:"name"`
+	State       restStrings `json:"state"`
+	CPUs        restNumber  `json:"cpus"`
+	AllocCPUs   restNumber  `json:"alloc_cpus"`
+	RealMemory  restNumber  `json:"real_memory"`
+	AllocMemory restNumber  `json:"alloc_memory"`
+	Gres        string      `json:"gres"`
+	GresUsed    string      `json:"gres_used"`
+	Partitions  restStrings `json:"partitions"`
+	Features    restStrings `json:"features"`
+	Reason      string      `json:"reason"`
+	Weight      restNumber  `json:"weight"`
+}
+
+func (n restNode) toNodeInfo() NodeInfo {
+	info := NodeInfo{
+		Name:        n.Name,
+		CPUs:        int(n.CPUs.Value),
+		CPUsAlloc:   int(n.AllocCPUs.Value),
+		Memory:      n.RealMemory.Value,
+		MemoryAlloc: n.AllocMemory.Value,
+		GPUs:        parseGPUCount(n.Gres),
+		GPUsAlloc:   parseGPUCount(n.GresUsed),
+		Partitions:  n.Partitions,
+		Features:    n.Features,
+		Reason:      n.Reason,
+		Weight:      int(n.Weight.Value),
+	}
+	if len(n.State) > 0 {
+		// The base state, then flags, as in IDLE+DRAIN
+		info.State = NodeState(n.State[0])
+		info.StateFlags = n.State[1:]
+	}
+	return info
+}
+
+func (r *restClient) getNode(ctx context.Context, name string) (*NodeInfo, error) {
+	var resp struct {
+		Nodes []restNode `json:"nodes"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/node/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Nodes) == 0 {
+		return nil, errRESTNotFound
+	}
+	info := resp.Nodes[0].toNodeInfo()
+	return &info, nil
+}
+
+func (r *restClient) getNodes(ctx context.Context) ([]NodeInfo, error) {
+	var resp struct {
+		Nodes []restNode `json:"nodes"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/nodes", nil, &resp); err != nil {
+		return nil, err
+	}
+	nodes := make([]NodeInfo, 0, len(resp.Nodes))
+	for _, n := range resp.Nodes {
+		nodes = append(nodes, n.toNodeInfo())
+	}
+	return nodes, nil
+}
+
+// updateNode sets the state of a node, with a reason for DRAIN
+func (r *restClient) updateNode(ctx context.Context, name, state, reason string) error {
+	body := map[string]interface{}{"state": []string{state}}
+	if reason != "" {
+		body["reason"] = reason
+	}
+	return r.call(ctx, http.MethodPost, "/node/"+url.PathEscape(name), body, nil)
+}
+
+// getPartitions returns the partitions with node, CPU and GPU counts
+// summed over their nodes, as sinfo reports them
+func (r *restClient) getPartitions(ctx context.Context) ([]PartitionInfo, error) {
+	var resp struct {
+		Partitions []struct {
+			Name      string      `json:"name"`
+			State     restStrings `json:"state"` // before v0.0.39
+			Partition struct {
+				State restStrings `json:"state"`
+			} `json:"partition"`
+			Maximums struct {
+				Time restNumber `json:"time"`
+			} `json:"maximums"`
+			Defaults struct {
+				Time restNumber `json:"time"`
+			} `json:"defaults"`
+		} `json:"partitions"`
+	}
+	if err := r.call(ctx, http.MethodGet, "/partitions", nil, &resp); err != nil {
+		return nil, err
+	}
+	nodes, err := r.getNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []PartitionInfo
+	index := make(map[string]int)
+	for _, p := range resp.Partitions {
+		info := PartitionInfo{Name: p.Name, State: "UP"}
+		if states := p.Partition.State; len(states) > 0 {
+			info.State = states[0]
+		} else if len(p.State) > 0 {
+			info.State = p.State[0]
+		}
+		if p.Maximums.Time.Set {
+			info.MaxTime = strconv.FormatInt(p.Maximums.Time.Value, 10)
+		}
+		if p.Defaults.Time.Set {
+			info.DefaultTime = strconv.FormatInt(p.Defaults.Time.Value, 10)
+		}
+		index[p.Name] = len(partitions)
+		partitions = append(partitions, info)
+	}
+
+	for _, n := range nodes {
+		for _, name := range n.Partitions {
+			i, ok := index[name]
+			if !ok {
+				continue
+			}
+			p := &partitions[i]
+			p.TotalNodes++
+			p.TotalCPUs += n.CPUs
+			p.TotalGPUs += n.GPUs
+			p.Nodes = append(p.Nodes, n.Name)
+			switch {
+			case n.Unavailable():
+				p.DownNodes++
+			case n.State == NodeStateIdle:
+				p.IdleNodes++
+			case n.State == NodeStateAllocated || n.State == NodeStateMixed:
+				p.AllocNodes++
+			}
+		}
+	}
+	return partitions, nil
+}
@@ -49,10 +49,11 @@ type Job struct {
 
 // GPUAllocation represents a GPU allocated to a job.
 type GPUAllocation struct {
-	Node     string `json:"node"`
-	GPUIndex int    `json:"gpu_index"`
-	UUID     string `json:"uuid"`
-	Type     string `json:"type"` // e.g., "nvidia_a100"
+	Node        string `json:"node"`
+	GPUIndex    int    `json:"gpu_index"`
+	GPUInstance string `json:"gpu_instance,omitempty"` // MIG GPU instance (GPU_I_ID), empty for a whole GPU
+	UUID        string `json:"uuid"`
+	Type        string `json:"type"` // e.g., "nvidia_a100", "1g.10gb"
 }
 
 // GPUEvent represents a GPU-related event during job execution.
@@ -60,6 +61,7 @@ type GPUEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 	Node      string    `json:"node"`
 	GPUIndex  int       `json:"gpu_index"`
+	GPUInstance string  `json:"gpu_instance,omitempty"` // MIG GPU instance (GPU_I_ID)
 	Type      string    `json:"type"`     // "xid", "temperature", "ecc", "power", "throttle"
 	Value     string    `json:"value"`
 	Severity  string    `json:"severity"` // "info", "warning", "critical"
@@ -93,11 +95,15 @@ type SlurmConfig struct {
 	Enabled       bool   `yaml:"enabled"`
 	Endpoint      string `yaml:"endpoint"`        // slurmrestd endpoint (optional)
 	AuthToken     string `yaml:"auth_token"`      // JWT or API token
+	User          string `yaml:"user"`            // X-SLURM-USER-NAME, and the user of issued tokens
+	APIVersion    string `yaml:"api_version"`     // slurmrestd API, e.g. v0.0.40
+	TokenLifetime time.Duration `yaml:"token_lifetime"` // of tokens issued with scontrol token
 	PreJobCheck   bool   `yaml:"pre_job_check"`   // Check GPU before job starts
 	PostJobCheck  bool   `yaml:"post_job_check"`  // Check GPU after job ends
 	AutoDrain     bool   `yaml:"auto_drain"`      // Drain node on GPU issue
 	DrainOnXid    []int  `yaml:"drain_on_xid"`    // Xid codes that trigger drain
 	HealthThreshold int  `yaml:"health_threshold"` // Minimum health score (0-100)
+	HealthGate    bool   `yaml:"health_gate"`     // Run the prolog checks (aami slurm prolog-check)
 }
 
 // DefaultSlurmConfig returns default Slurm configuration.
@@ -108,6 +114,7 @@ func DefaultSlurmConfig() SlurmConfig {
 		PostJobCheck:  true,
 		AutoDrain:     false,
 		HealthThreshold: 50,
+		HealthGate:    true,
 		DrainOnXid: []int{
 			31, // GPU memory page fault
 			43, // GPU stopped processing
@@ -157,6 +164,7 @@ const (
 type NodeInfo struct {
 	Name        string    `json:"name"`
 	State       NodeState `json:"state"`
+	StateFlags  []string  `json:"state_flags,omitempty"` // DRAIN, MAINT, ... after the base state
 	CPUs        int       `json:"cpus"`
 	CPUsAlloc   int       `json:"cpus_alloc"`
 	Memory      int64     `json:"memory"`       // MB
@@ -169,6 +177,21 @@ type NodeInfo struct {
 	Weight      int       `json:"weight"`
 }
 
+// Unavailable reports whether the node is drained, draining or down.
+func (n *NodeInfo) Unavailable() bool {
+	switch n.State {
+	case NodeStateDrain, NodeStateDraining, NodeStateDown:
+		return true
+	}
+	for _, flag := range n.StateFlags {
+		switch NodeState(flag) {
+		case NodeStateDrain, NodeStateDraining, NodeStateDown:
+			return true
+		}
+	}
+	return false
+}
+
 // PartitionInfo represents Slurm partition information.
 type PartitionInfo struct {
 	Name       string   `json:"name"`
@@ -209,8 +232,11 @@ type JobStatistics struct {
 
 // CorrelationLog represents a logged correlation event.
 type CorrelationLog struct {
+	ID           string          `json:"id"`
 	Timestamp    time.Time       `json:"timestamp"`
+	ReportedAt   time.Time       `json:"reported_at"`
 	JobID        int64           `json:"job_id"`
+	User         string          `json:"user,omitempty"`
 	Node         string          `json:"node"`
 	GPUIndex     int             `json:"gpu_index"`
 	HealthScore  int             `json:"health_score"`
@@ -0,0 +1,285 @@
+package slurm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage groupings
+const (
+	UsageByUser    = "user"
+	UsageByAccount = "account"
+)
+
+// usageQueryConcurrency bounds the utilization queries run at once
+const usageQueryConcurrency = 8
+
+// sacctTimeLayout is the time format of sacct's --starttime and --endtime
+const sacctTimeLayout = "2006-01-02T15:04:05"
+
+// GetAccountingJobs returns the jobs of all users that ran between since
+// and until, from the accounting database. GPUCount is the number of GPUs
+// allocated to the job on all its nodes.
+func (c *Client) GetAccountingJobs(ctx context.Context, since, until time.Time) ([]Job, error) {
+	cmd := exec.CommandContext(ctx, "sacct", "--allusers", "--allocations",
+		"--starttime", since.Local().Format(sacctTimeLayout),
+		"--endtime", until.Local().Format(sacctTimeLayout),
+		"--format=JobID,JobName,User,Account,Partition,State,Start,End,NodeList,AllocTRES",
+		"--noheader", "--parsable2")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sacct failed: %w", err)
+	}
+
+	var jobs []Job
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.Split(strings.TrimSpace(line), "|")
+		if len(parts) < 10 {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.Split(parts[0], "_")[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		job := Job{
+			ID:        id,
+			Name:      parts[1],
+			User:      parts[2],
+			Account:   parts[3],
+			Partition: parts[4],
+			State:     JobState(strings.Fields(parts[5] + " ")[0]), // "CANCELLED by 0"
+			StartTime: parseSacctTime(parts[6]),
+			EndTime:   parseSacctTime(parts[7]),
+			Nodes:     c.expandNodeList(ctx, parts[8]),
+			GPUCount:  parseTRESGPUs(parts[9]),
+		}
+		if job.StartTime.IsZero() {
+			// Never started
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// parseSacctTime parses a time of sacct, which is in local time like its
+// --starttime and --endtime
+func parseSacctTime(s string) time.Time {
+	t, err := time.ParseInLocation(sacctTimeLayout, s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// parseTRESGPUs returns the GPUs of a TRES list, e.g.
+// "cpu=8,mem=64G,node=1,gres/gpu=4,gres/gpu:a100=4"
+func parseTRESGPUs(tres string) int {
+	for _, item := range strings.Split(tres, ",") {
+		key, value, _ := strings.Cut(item, "=")
+		if key == "gres/gpu" {
+			n, _ := strconv.Atoi(value)
+			return n
+		}
+	}
+	return 0
+}
+
+// UsageRow is the GPU usage of a user or account.
+type UsageRow struct {
+	Key          string  `json:"key"`
+	Jobs         int     `json:"jobs"`
+	GPUHours     float64 `json:"gpu_hours"`
+	Efficiency   float64 `json:"efficiency"`         // mean GPU utilization (%) weighted by GPU hours, of the measured hours
+	MeasuredGPUH float64 `json:"measured_gpu_hours"` // GPU hours with utilization samples
+	IdleGPUHours float64 `json:"idle_gpu_hours"`     // measured GPU hours not used
+}
+
+// UsageReport is the GPU usage of a period by user or account.
+type UsageReport struct {
+	By    string     `json:"by"`
+	Since time.Time  `json:"since"`
+	Until time.Time  `json:"until"`
+	Rows  []UsageRow `json:"rows"` // most GPU hours first
+	Total UsageRow   `json:"total"`
+}
+
+// usageJob is a GPU job and its utilization over the period
+type usageJob struct {
+	job         Job
+	gpuHours    float64
+	utilization float64 // %
+	measured    bool
+}
+
+// Usage aggregates the GPU hours of the jobs that ran between since and
+// until, counting only the time within the period. With measure, their
+// efficiency is measured from the mean utilization of their nodes' GPUs.
+func (a *Analyzer) Usage(ctx context.Context, since, until time.Time, by string, measure bool) (*UsageReport, error) {
+	if by != UsageByUser && by != UsageByAccount {
+		return nil, fmt.Errorf("invalid grouping %q (valid: %s, %s)", by, UsageByUser, UsageByAccount)
+	}
+
+	jobs, err := a.slurmClient.GetAccountingJobs(ctx, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage []usageJob
+	for _, job := range jobs {
+		start, end := job.StartTime, job.EndTime
+		if end.IsZero() || end.After(until) {
+			end = until
+		}
+		if start.Before(since) {
+			start = since
+		}
+		if job.GPUCount == 0 || !end.After(start) {
+			continue
+		}
+		job.StartTime, job.EndTime = start, end
+		usage = append(usage, usageJob{job: job, gpuHours: end.Sub(start).Hours() * float64(job.GPUCount)})
+	}
+
+	if measure {
+		a.measureUtilization(ctx, usage)
+	}
+	return aggregateUsage(usage, by, since, until), nil
+}
+
+// measureUtilization queries the mean GPU utilization of each job's nodes
+// over its run within the period. Jobs without samples stay unmeasured.
+func (a *Analyzer) measureUtilization(ctx context.Context, usage []usageJob) {
+	sem := make(chan struct{}, usageQueryConcurrency)
+	var wg sync.WaitGroup
+	for i := range usage {
+		if len(usage[i].job.Nodes) == 0 {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(u *usageJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			window := int(u.job.EndTime.Sub(u.job.StartTime).Seconds())
+			if window < 60 {
+				window = 60
+			}
+			query := fmt.Sprintf(`avg(avg_over_time(DCGM_FI_DEV_GPU_UTIL{instance=~"(%s).*"}[%ds]))`,
+				strings.Join(u.job.Nodes, "|"), window)
+			value, err := a.queryInstantValue(ctx, query, u.job.EndTime)
+			if err == nil {
+				u.utilization, u.measured = value, true
+			}
+		}(&usage[i])
+	}
+	wg.Wait()
+}
+
+// queryInstantValue returns the value of the first series of an instant
+// query at a time
+func (a *Analyzer) queryInstantValue(ctx context.Context, query string, at time.Time) (float64, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("time", strconv.FormatInt(at.Unix(), 10))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.prometheusURL+"/api/v1/query?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Data   struct {
+			Result []struct {
+				Value []interface{} `json:"value"` // [timestamp, "value"]
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if result.Status != "success" {
+		return 0, fmt.Errorf("prometheus query failed: %s", result.Status)
+	}
+	if len(result.Data.Result) == 0 || len(result.Data.Result[0].Value) < 2 {
+		return 0, fmt.Errorf("no samples")
+	}
+	value, ok := result.Data.Result[0].Value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected sample value")
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, fmt.Errorf("invalid sample value %q", value)
+	}
+	return v, nil
+}
+
+func aggregateUsage(usage []usageJob, by string, since, until time.Time) *UsageReport {
+	type acc struct {
+		row     UsageRow
+		usedGPU float64 // measured GPU hours × utilization
+	}
+	add := func(a *acc, u usageJob) {
+		a.row.Jobs++
+		a.row.GPUHours += u.gpuHours
+		if u.measured {
+			a.row.MeasuredGPUH += u.gpuHours
+			a.usedGPU += u.gpuHours * u.utilization / 100
+		}
+	}
+	finish := func(a *acc) UsageRow {
+		row := a.row
+		if row.MeasuredGPUH > 0 {
+			row.Efficiency = 100 * a.usedGPU / row.MeasuredGPUH
+			row.IdleGPUHours = row.MeasuredGPUH - a.usedGPU
+		}
+		return row
+	}
+
+	groups := make(map[string]*acc)
+	total := &acc{row: UsageRow{Key: "total"}}
+	for _, u := range usage {
+		key := u.job.User
+		if by == UsageByAccount {
+			key = u.job.Account
+		}
+		if key == "" {
+			key = "(none)"
+		}
+		if groups[key] == nil {
+			groups[key] = &acc{row: UsageRow{Key: key}}
+		}
+		add(groups[key], u)
+		add(total, u)
+	}
+
+	report := &UsageReport{By: by, Since: since, Until: until, Total: finish(total)}
+	for _, g := range groups {
+		report.Rows = append(report.Rows, finish(g))
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].GPUHours != report.Rows[j].GPUHours {
+			return report.Rows[i].GPUHours > report.Rows[j].GPUHours
+		}
+		return report.Rows[i].Key < report.Rows[j].Key
+	})
+	return report
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// gcsEndpoint is the S3-compatible XML API of Cloud Storage, used with HMAC
+// keys of a service account
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// S3 stores files in an S3 bucket or any service speaking its API, signing
+// requests with AWS Signature Version 4.
+type S3 struct {
+	scheme    string
+	host      string
+	bucket    string
+	prefix    string
+	region    string
+	pathStyle bool // bucket in the path instead of the host name
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+	now       func() time.Time
+	display   string // s3 or gs, for Location
+}
+
+func newS3(c config.ObjectStoreConfig) (*S3, error) {
+	if c.Bucket == "" {
+		return nil, fmt.Errorf("%s storage needs a bucket", c.Backend)
+	}
+
+	s := &S3{
+		bucket:    c.Bucket,
+		prefix:    strings.Trim(c.Prefix, "/"),
+		region:    c.Region,
+		accessKey: c.AccessKey,
+		secretKey: c.SecretKey,
+		client:    &http.Client{Timeout: 60 * time.Second},
+		now:       time.Now,
+		display:   "s3",
+	}
+
+	endpoint := c.Endpoint
+	switch c.Backend {
+	case BackendGCS:
+		s.display = "gs"
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+		if s.region == "" {
+			s.region = "auto"
+		}
+		s.pathStyle = true
+	default:
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+		if s.accessKey == "" && s.secretKey == "" {
+			s.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+			s.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			s.token = os.Getenv("AWS_SESSION_TOKEN")
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region)
+		} else {
+			// S3-compatible services such as MinIO rarely resolve bucket
+			// host names
+			s.pathStyle = true
+		}
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("%s storage needs access_key and secret_key", c.Backend)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("invalid storage endpoint %q", endpoint)
+	}
+	s.scheme, s.host = u.Scheme, u.Host
+	if !s.pathStyle {
+		s.host = s.bucket + "." + s.host
+	}
+	return s, nil
+}
+
+// Put uploads an object.
+func (s *S3) Put(key string, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPut, s.objectPath(key), nil, data)
+	if err != nil {
+		return fmt.Errorf("upload %s: %w", s.Location(key), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upload %s: %s: %s", s.Location(key), resp.Status, readLimited(resp.Body, 1024))
+	}
+	return nil
+}
+
+// Get downloads an object.
+func (s *S3) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodGet, s.objectPath(key), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", s.Location(key), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, s.Location(key))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s: %s", s.Location(key), resp.Status, readLimited(resp.Body, 1024))
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, fmt.Errorf("download %s: %w", s.Location(key), err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Delete removes an object; a missing object is not an error.
+func (s *S3) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, s.objectPath(key), nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", s.Location(key), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: %s: %s", s.Location(key), resp.Status, readLimited(resp.Body, 1024))
+	}
+	return nil
+}
+
+// listResult is a page of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists the objects under the backend's prefix starting with prefix,
+// following continuation tokens.
+func (s *S3) List(prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.fullKey(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, s.bucketPath(), query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", s.Location(prefix), err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			msg := readLimited(resp.Body, 1024)
+			resp.Body.Close()
+			return nil, fmt.Errorf("list %s: %s: %s", s.Location(prefix), resp.Status, msg)
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: parse response: %w", s.Location(prefix), err)
+		}
+
+		for _, c := range page.Contents {
+			key := c.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			objects = append(objects, Object{Key: key, Size: c.Size, Modified: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// Location returns the object's URL, e.g. s3://bucket/prefix/key.
+func (s *S3) Location(key string) string {
+	return fmt.Sprintf("%s://%s/%s", s.display, s.bucket, s.fullKey(key))
+}
+
+func (s *S3) fullKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *S3) bucketPath() string {
+	if s.pathStyle {
+		return "/" + s.bucket + "/"
+	}
+	return "/"
+}
+
+func (s *S3) objectPath(key string) string {
+	return s.bucketPath() + s.fullKey(key)
+}
+
+// maxLinkTTL is the longest lifetime Signature Version 4 allows a
+// presigned URL
+const maxLinkTTL = 7 * 24 * time.Hour
+
+// Link returns a presigned URL that downloads the object until ttl passes.
+func (s *S3) Link(key string, ttl time.Duration) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > maxLinkTTL {
+		return "", fmt.Errorf("link lifetime must be between 1s and %s", maxLinkTTL)
+	}
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	path := s.objectPath(key)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.token != "" {
+		query.Set("X-Amz-Security-Token", s.token)
+	}
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		escapePath(path),
+		canonicalQuery(query),
+		"host:" + s.host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(day, strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")))
+	return s.scheme + "://" + s.host + escapePath(path) + "?" + canonicalQuery(query), nil
+}
+
+// do sends a signed request
+func (s *S3) do(method, path string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.scheme+"://"+s.host+escapePath(path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.URL.RawQuery = canonicalQuery(query)
+	req.ContentLength = int64(len(body))
+	s.sign(req, path, query, body)
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers for the request
+func (s *S3) sign(req *http.Request, path string, query url.Values, body []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": s.host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(path),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := s.signature(day, stringToSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// signature signs a string with the key derived for the day
+func (s *S3) signature(day, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signed
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, v := range query[name] {
+			parts = append(parts, awsEscape(name, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapePath(path string) string {
+	return awsEscape(path, true)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and /
+// in paths, as Signature Version 4 requires
+func awsEscape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,182 @@
+// Package storage keeps files AAMI generates, such as rule files and
+// backups, in a local directory or in object storage (S3, or GCS through its
+// S3-compatible XML API). Containerized Prometheus setups load rules synced
+// from a bucket by a sidecar instead of from /etc/aami/rules.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendGCS   = "gcs"
+)
+
+// ErrNotFound is returned by Get for a key that does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Object is a stored file.
+type Object struct {
+	Key      string // relative to the backend's prefix, with / separators
+	Size     int64
+	Modified time.Time
+}
+
+// Backend stores files by key. Keys use / separators on every backend.
+type Backend interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	// List returns the objects whose key starts with prefix, by key
+	List(prefix string) ([]Object, error)
+	// Location describes where a key is stored, for messages
+	Location(key string) string
+}
+
+// Linker is a backend that hands out download links to its objects, usable
+// without credentials until they expire.
+type Linker interface {
+	Link(key string, ttl time.Duration) (string, error)
+}
+
+// Remote reports whether the settings select object storage rather than a
+// local directory.
+func Remote(c config.ObjectStoreConfig) bool {
+	return c.Backend == BackendS3 || c.Backend == BackendGCS
+}
+
+// New returns the backend the settings select. The local backend stores
+// under dir.
+func New(c config.ObjectStoreConfig, dir string) (Backend, error) {
+	switch c.Backend {
+	case "", BackendLocal:
+		return NewLocal(dir), nil
+	case BackendS3, BackendGCS:
+		return newS3(c)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (valid: local, s3, gcs)", c.Backend)
+	}
+}
+
+// Local stores files in a directory.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a backend storing files under dir.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Put writes a file, creating its directory.
+func (l *Local) Put(key string, data []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+	return os.Rename(tmp, p)
+}
+
+// Get reads a file.
+func (l *Local) Get(key string) ([]byte, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return data, err
+}
+
+// Delete removes a file; a missing file is not an error.
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// List walks the directory for files whose key starts with prefix.
+func (l *Local) List(prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.Walk(l.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", l.dir, err)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// Location returns the file's path.
+func (l *Local) Location(key string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(key))
+}
+
+// path maps a key to a file, refusing keys that leave the directory
+func (l *Local) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// checkKey rejects empty keys and keys with . or .. segments
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == "." || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	return nil
+}
+
+// readLimited reads at most n bytes of an error response body
+func readLimited(r io.Reader, n int64) string {
+	data, _ := io.ReadAll(io.LimitReader(r, n))
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,501 @@
+// Package synthetic tests the alerting pipeline end to end. It injects an
+// always-firing rule, follows the alert through Prometheus and
+// Alertmanager, confirms the notification channels delivered it, and
+// removes the rule again, so a broken link anywhere in between shows up
+// before a real alert is lost.
+package synthetic
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/metrics"
+	"github.com/fregataa/aami/internal/storage"
+)
+
+// AlertName is the name of the synthetic alert
+const AlertName = "AAMISyntheticTest"
+
+// Label carries the test's ID on the synthetic alert, so it is never
+// confused with another test's or a real alert
+const Label = "aami_synthetic"
+
+// Defaults for Options
+const (
+	DefaultTimeout      = 5 * time.Minute
+	DefaultPollInterval = 5 * time.Second
+	DefaultSeverity     = "warning"
+)
+
+// Step statuses
+const (
+	StepPassed  = "passed"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+)
+
+// Options configure a pipeline test.
+type Options struct {
+	Group           string          // rule group the synthetic rule is added to
+	Severity        string          // severity label, for routing
+	RulesDir        string          // directory Prometheus loads rule files from
+	RuleKeyPrefix   string          // key of RulesDir in Storage, e.g. a namespace
+	Storage         storage.Backend // also upload the rule here, for sidecar setups
+	PrometheusURL   string
+	AlertmanagerURL string
+	Integrations    []string      // Alertmanager integrations expected to deliver, e.g. slack
+	Timeout         time.Duration // how long each step waits
+	PollInterval    time.Duration
+	Reload          func() error // reloads Prometheus in place of POST /-/reload, if set
+}
+
+// Step is the outcome of one stage of the pipeline.
+type Step struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Result is the outcome of a pipeline test.
+type Result struct {
+	ID         string    `json:"id"`
+	Group      string    `json:"group"`
+	Passed     bool      `json:"passed"`
+	Steps      []Step    `json:"steps"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Run tests the pipeline and reports each step to progress as it
+// finishes, if set. The synthetic rule is removed even when a step fails.
+// Later steps are skipped after a failure.
+func Run(ctx context.Context, opts Options, progress func(Step)) (*Result, error) {
+	if opts.Group == "" {
+		return nil, fmt.Errorf("a rule group is required")
+	}
+	if opts.Severity == "" {
+		opts.Severity = DefaultSeverity
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &test{
+		opts:     opts,
+		id:       id,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		am:       alertmanager.NewClient(opts.AlertmanagerURL),
+		progress: progress,
+		result:   &Result{ID: id, Group: opts.Group, StartedAt: time.Now().UTC()},
+	}
+	t.run(ctx)
+	t.result.FinishedAt = time.Now().UTC()
+	return t.result, nil
+}
+
+type test struct {
+	opts     Options
+	id       string
+	client   *http.Client
+	am       *alertmanager.Client
+	progress func(Step)
+	result   *Result
+	failed   bool
+}
+
+func (t *test) run(ctx context.Context) {
+	// Counters before the alert exists, so earlier notifications do not count
+	before, baselineErr := t.notificationCounts(ctx)
+
+	t.step("inject rule", t.inject)
+	defer t.step("clean up", t.cleanup)
+
+	t.step("prometheus rule loaded", func() (string, error) {
+		return t.poll(ctx, t.ruleLoaded)
+	})
+	t.step("prometheus alert firing", func() (string, error) {
+		return t.poll(ctx, t.alertFiring)
+	})
+	t.step("alertmanager received", func() (string, error) {
+		return t.poll(ctx, t.alertReceived)
+	})
+
+	if len(t.opts.Integrations) == 0 {
+		t.skip("notification delivered", "no notification channel is enabled")
+		return
+	}
+	t.step("notification delivered", func() (string, error) {
+		if baselineErr != nil {
+			return "", fmt.Errorf("read Alertmanager metrics: %w", baselineErr)
+		}
+		return t.poll(ctx, func(ctx context.Context) (bool, string, error) {
+			return t.delivered(ctx, before)
+		})
+	})
+}
+
+// step runs fn unless an earlier step failed; clean up always runs
+func (t *test) step(name string, fn func() (string, error)) {
+	if t.failed && name != "clean up" {
+		t.skip(name, "an earlier step failed")
+		return
+	}
+	start := time.Now()
+	detail, err := fn()
+	s := Step{Name: name, Status: StepPassed, Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		s.Status = StepFailed
+		s.Detail = err.Error()
+		t.failed = true
+	}
+	t.record(s)
+}
+
+func (t *test) skip(name, reason string) {
+	t.record(Step{Name: name, Status: StepSkipped, Detail: reason})
+}
+
+func (t *test) record(s Step) {
+	t.result.Steps = append(t.result.Steps, s)
+	t.result.Passed = !t.failed
+	if t.progress != nil {
+		t.progress(s)
+	}
+}
+
+// poll calls check until it reports done, fails, or the timeout passes
+func (t *test) poll(ctx context.Context, check func(context.Context) (bool, string, error)) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.opts.Timeout)
+	defer cancel()
+
+	last := ""
+	for {
+		done, detail, err := check(ctx)
+		if err != nil {
+			return "", err
+		}
+		if done {
+			return detail, nil
+		}
+		last = detail
+		select {
+		case <-ctx.Done():
+			if last != "" {
+				return "", fmt.Errorf("timed out after %s: %s", t.opts.Timeout, last)
+			}
+			return "", fmt.Errorf("timed out after %s", t.opts.Timeout)
+		case <-time.After(t.opts.PollInterval):
+		}
+	}
+}
+
+func (t *test) ruleFile() string {
+	return "aami-synthetic-" + t.id + ".yaml"
+}
+
+func (t *test) ruleKey() string {
+	if t.opts.RuleKeyPrefix == "" {
+		return t.ruleFile()
+	}
+	return t.opts.RuleKeyPrefix + "/" + t.ruleFile()
+}
+
+// inject writes the synthetic rule and asks Prometheus to reload
+func (t *test) inject() (string, error) {
+	rule := fmt.Sprintf(`# Synthetic alert of an AAMI pipeline test, removed when the test ends
+groups:
+  - name: %s
+    rules:
+      - alert: %s
+        expr: vector(1)
+        labels:
+          severity: %s
+          %s: %q
+        annotations:
+          summary: "AAMI pipeline test %s"
+          description: "Synthetic alert injected by 'aami doctor --e2e'; it resolves on its own. No action is needed."
+`, strconv.Quote(t.opts.Group), AlertName, strconv.Quote(t.opts.Severity), Label, t.id, t.id)
+
+	if err := os.MkdirAll(t.opts.RulesDir, 0755); err != nil {
+		return "", fmt.Errorf("create rules directory: %w", err)
+	}
+	path := filepath.Join(t.opts.RulesDir, t.ruleFile())
+	if err := os.WriteFile(path, []byte(rule), 0644); err != nil {
+		return "", fmt.Errorf("write synthetic rule: %w", err)
+	}
+	detail := path
+	if t.opts.Storage != nil {
+		if err := t.opts.Storage.Put(t.ruleKey(), []byte(rule)); err != nil {
+			return "", err
+		}
+		detail += ", " + t.opts.Storage.Location(t.ruleKey())
+	}
+
+	if err := t.reload(); err != nil {
+		// A sidecar may reload Prometheus instead; the next step tells
+		return detail + fmt.Sprintf(" (reload failed: %v)", err), nil
+	}
+	return detail, nil
+}
+
+// cleanup removes the synthetic rule. The alert then resolves in
+// Prometheus and Alertmanager on its own.
+func (t *test) cleanup() (string, error) {
+	path := filepath.Join(t.opts.RulesDir, t.ruleFile())
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("remove synthetic rule: %w", err)
+	}
+	if t.opts.Storage != nil {
+		if err := t.opts.Storage.Delete(t.ruleKey()); err != nil {
+			return "", err
+		}
+	}
+	if err := t.reload(); err != nil {
+		return fmt.Sprintf("removed %s (reload failed: %v)", path, err), nil
+	}
+	return "removed " + path, nil
+}
+
+func (t *test) reload() (err error) {
+	if t.opts.Reload != nil {
+		return t.opts.Reload()
+	}
+	defer func() { metrics.Reloads.Inc(metrics.Result(err)) }()
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(t.opts.PrometheusURL, "/")+"/-/reload", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// ruleLoaded reports whether Prometheus loaded the synthetic rule into the
+// chosen group
+func (t *test) ruleLoaded(ctx context.Context) (bool, string, error) {
+	var data struct {
+		Groups []struct {
+			Name  string `json:"name"`
+			File  string `json:"file"`
+			Rules []struct {
+				Name   string            `json:"name"`
+				Labels map[string]string `json:"labels"`
+				Health string            `json:"health"`
+			} `json:"rules"`
+		} `json:"groups"`
+	}
+	if err := t.prometheusAPI(ctx, "/api/v1/rules?type=alert", &data); err != nil {
+		return false, "", err
+	}
+	for _, g := range data.Groups {
+		for _, r := range g.Rules {
+			if r.Name != AlertName || r.Labels[Label] != t.id {
+				continue
+			}
+			if g.Name != t.opts.Group {
+				return false, "", fmt.Errorf("rule loaded into group %q instead of %q", g.Name, t.opts.Group)
+			}
+			return true, fmt.Sprintf("group %s in %s", g.Name, g.File), nil
+		}
+	}
+	return false, "Prometheus has not loaded the rule; is --web.enable-lifecycle set, or is the rules sidecar running?", nil
+}
+
+// alertFiring reports whether Prometheus fires the synthetic alert
+func (t *test) alertFiring(ctx context.Context) (bool, string, error) {
+	var data struct {
+		Alerts []struct {
+			Labels   map[string]string `json:"labels"`
+			State    string            `json:"state"`
+			ActiveAt time.Time         `json:"activeAt"`
+		} `json:"alerts"`
+	}
+	if err := t.prometheusAPI(ctx, "/api/v1/alerts", &data); err != nil {
+		return false, "", err
+	}
+	for _, a := range data.Alerts {
+		if a.Labels["alertname"] != AlertName || a.Labels[Label] != t.id {
+			continue
+		}
+		if a.State == "firing" {
+			return true, "active since " + a.ActiveAt.Local().Format("15:04:05"), nil
+		}
+		return false, "alert is " + a.State, nil
+	}
+	return false, "rule has not been evaluated yet", nil
+}
+
+// alertReceived reports whether Alertmanager has the synthetic alert and
+// would notify for it
+func (t *test) alertReceived(ctx context.Context) (bool, string, error) {
+	alerts, err := t.am.ListAlerts(fmt.Sprintf("%s=%q", Label, t.id))
+	if err != nil {
+		return false, "", err
+	}
+	for _, a := range alerts {
+		switch a.Status.State {
+		case "active":
+			return true, "active", nil
+		case "suppressed":
+			reason := "silenced by " + strings.Join(a.Status.SilencedBy, ", ")
+			if len(a.Status.InhibitedBy) > 0 {
+				reason = "inhibited by " + strings.Join(a.Status.InhibitedBy, ", ")
+			}
+			return false, "", fmt.Errorf("alert is suppressed (%s); no notification will be sent", reason)
+		default:
+			return false, "alert is " + a.Status.State, nil
+		}
+	}
+	return false, "Alertmanager has not received the alert; check Prometheus' alertmanagers", nil
+}
+
+// notificationCount is a delivery counter of one integration
+type notificationCount struct {
+	total  float64
+	failed float64
+}
+
+// delivered reports whether every expected integration delivered a
+// notification since the test started
+func (t *test) delivered(ctx context.Context, before map[string]notificationCount) (bool, string, error) {
+	now, err := t.notificationCounts(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("read Alertmanager metrics: %w", err)
+	}
+
+	var pending, done []string
+	for _, integration := range t.opts.Integrations {
+		sent := now[integration].total - before[integration].total
+		failed := now[integration].failed - before[integration].failed
+		switch {
+		case sent > failed:
+			done = append(done, integration)
+		case failed > 0:
+			pending = append(pending, fmt.Sprintf("%s failed %d time(s)", integration, int(failed)))
+		default:
+			pending = append(pending, integration+" has not sent yet")
+		}
+	}
+	if len(pending) > 0 {
+		return false, strings.Join(pending, "; "), nil
+	}
+	return true, "sent via " + strings.Join(done, ", "), nil
+}
+
+// notificationCounts reads alertmanager_notifications_total and
+// alertmanager_notifications_failed_total by integration
+func (t *test) notificationCounts(ctx context.Context) (map[string]notificationCount, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(t.opts.AlertmanagerURL, "/")+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	counts := make(map[string]notificationCount)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		name, rest, ok := strings.Cut(line, "{")
+		if !ok || (name != "alertmanager_notifications_total" && name != "alertmanager_notifications_failed_total") {
+			continue
+		}
+		labels, value, ok := strings.Cut(rest, "} ")
+		fields := strings.Fields(value)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		integration := labelValue(labels, "integration")
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if integration == "" || err != nil {
+			continue
+		}
+		c := counts[integration]
+		if name == "alertmanager_notifications_total" {
+			c.total += v
+		} else {
+			c.failed += v
+		}
+		counts[integration] = c
+	}
+	return counts, scanner.Err()
+}
+
+// labelValue returns a label's value from the label set of a metric line
+func labelValue(labels, name string) string {
+	for _, pair := range strings.Split(labels, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if ok && strings.TrimSpace(k) == name {
+			return strings.Trim(v, `"`)
+		}
+	}
+	return ""
+}
+
+func (t *test) prometheusAPI(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(t.opts.PrometheusURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("parse prometheus response: %w", err)
+	}
+	if envelope.Status != "success" {
+		return fmt.Errorf("prometheus error: %s", envelope.Error)
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,167 @@
+// Package tenant scopes the serve APIs to namespaces. API keys (api_keys
+// in the config) are bound to a namespace; requests made with one read and
+// change only the nodes labelled with that namespace and what belongs to
+// them. The service's own token keeps access to every namespace. People
+// signed in with OIDC (oidc in the config) get the namespace of their
+// groups, or every namespace in an operator group.
+package tenant
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fregataa/aami/internal/config"
+	"github.com/fregataa/aami/internal/oidc"
+)
+
+// OperatorName is the tenant of requests made with a service token
+const OperatorName = "operator"
+
+// Tenant is who a request acts for.
+type Tenant struct {
+	Name      string `json:"name"`                // API key name, OIDC user, or OperatorName
+	Namespace string `json:"namespace,omitempty"` // empty: every namespace
+}
+
+// Scoped reports whether the tenant is limited to one namespace.
+func (t Tenant) Scoped() bool { return t.Namespace != "" }
+
+// Allows reports whether the tenant may use a namespace.
+func (t Tenant) Allows(namespace string) bool {
+	return !t.Scoped() || namespace == t.Namespace
+}
+
+// OwnsNode reports whether a node is in the tenant's namespace.
+func (t Tenant) OwnsNode(n config.NodeConfig) bool {
+	return t.Allows(n.Labels[config.NamespaceLabel])
+}
+
+// Nodes returns the names of the tenant's nodes, or nil for a tenant that
+// is not scoped; nil filters nothing.
+func (t Tenant) Nodes(cfg *config.Config) map[string]bool {
+	if !t.Scoped() {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, n := range cfg.Nodes {
+		if t.OwnsNode(n) {
+			names[n.Name] = true
+		}
+	}
+	return names
+}
+
+// Scope returns cfg as the tenant sees it: only its nodes and its
+// namespace. The rest of cfg is shared, so the result must not be saved.
+func (t Tenant) Scope(cfg *config.Config) *config.Config {
+	if !t.Scoped() {
+		return cfg
+	}
+	scoped := *cfg
+	scoped.Nodes = nil
+	for _, n := range cfg.Nodes {
+		if t.OwnsNode(n) {
+			scoped.Nodes = append(scoped.Nodes, n)
+		}
+	}
+	scoped.Alerts.Namespaces = nil
+	for _, ns := range cfg.Alerts.Namespaces {
+		if t.Allows(ns.Name) {
+			scoped.Alerts.Namespaces = append(scoped.Alerts.Namespaces, ns)
+		}
+	}
+	return &scoped
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the tenant.
+func NewContext(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of a request context.
+func FromContext(ctx context.Context) (Tenant, bool) {
+	t, ok := ctx.Value(contextKey{}).(Tenant)
+	return t, ok
+}
+
+// Authenticate returns the tenant of a request's bearer token: the
+// operator for serviceToken, else the namespace of an API key, else the
+// OIDC user of an ID token.
+func Authenticate(cfg *config.Config, r *http.Request, serviceToken string) (Tenant, error) {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || given == "" {
+		return Tenant{}, fmt.Errorf("missing bearer token")
+	}
+	if serviceToken != "" && subtle.ConstantTimeCompare([]byte(serviceToken), []byte(given)) == 1 {
+		return Tenant{Name: OperatorName}, nil
+	}
+	for _, key := range cfg.APIKeys {
+		if key.Key != "" && key.Namespace != "" && subtle.ConstantTimeCompare([]byte(key.Key), []byte(given)) == 1 {
+			return Tenant{Name: key.Name, Namespace: key.Namespace}, nil
+		}
+	}
+	if cfg.OIDC.Issuer != "" && oidc.LooksLikeJWT(given) {
+		return authenticateOIDC(r.Context(), cfg.OIDC, given)
+	}
+	return Tenant{}, fmt.Errorf("invalid token")
+}
+
+// authenticateOIDC returns the tenant of an ID token: every namespace for
+// members of an operator group, else the namespace of their group
+func authenticateOIDC(ctx context.Context, c config.OIDCConfig, token string) (Tenant, error) {
+	claims, err := oidc.NewVerifier(c.Issuer, defaultString(c.Audience, c.ClientID)).Verify(ctx, token)
+	if err != nil {
+		return Tenant{}, fmt.Errorf("invalid token: %w", err)
+	}
+	user := defaultString(claims.String(defaultString(c.UsernameClaim, "email")), claims.Subject)
+	groups := claims.Strings(defaultString(c.GroupsClaim, "groups"))
+
+	if len(c.OperatorGroups) == 0 {
+		return Tenant{Name: user}, nil
+	}
+	for _, g := range groups {
+		for _, op := range c.OperatorGroups {
+			if g == op {
+				return Tenant{Name: user}, nil
+			}
+		}
+	}
+	for _, g := range groups {
+		if ns, ok := c.NamespaceGroups[g]; ok && ns != "" {
+			return Tenant{Name: user, Namespace: ns}, nil
+		}
+	}
+	return Tenant{}, fmt.Errorf("%s is in no group with access", user)
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Middleware authenticates every request with Authenticate and passes it
+// on with its tenant in the context; requests without a valid token get
+// 401. The config is loaded per request so key changes apply without a
+// restart.
+func Middleware(load func() (*config.Config, error), serviceToken func(*config.Config) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, err := load()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t, err := Authenticate(cfg, r, serviceToken(cfg))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	})
+}
@@ -0,0 +1,249 @@
+// Package timeline interleaves what happened on a node, from alert firings
+// and check failures to drains, resumes and failed jobs, into a single
+// chronological view for postmortems.
+package timeline
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fregataa/aami/internal/checkresult"
+	"github.com/fregataa/aami/internal/slurm"
+)
+
+// Sources of events
+const (
+	SourceAlerts = "alerts"
+	SourceChecks = "checks"
+	SourceDrains = "drains"
+	SourceJobs   = "jobs"
+)
+
+// Sources returns the sources of events, in the order they are read.
+func Sources() []string {
+	return []string{SourceAlerts, SourceChecks, SourceDrains, SourceJobs}
+}
+
+// Event types
+const (
+	EventAlertFiring    = "alert_firing"
+	EventAlertResolved  = "alert_resolved"
+	EventCheckFailed    = "check_failed"
+	EventCheckRecovered = "check_recovered"
+	EventNodeDrained    = "node_drained"
+	EventNodeResumed    = "node_resumed"
+	EventJobFailed      = "job_failed"
+)
+
+// Event is an entry of a timeline.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Type     string    `json:"type"`
+	Subject  string    `json:"subject"`            // alert name, check, drain rule or job ID
+	Severity string    `json:"severity,omitempty"` // of alerts
+	Message  string    `json:"message"`
+}
+
+// Timeline is the events of a node within a window, oldest first.
+type Timeline struct {
+	Target   string    `json:"target"`
+	Hostname string    `json:"hostname"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Events   []Event   `json:"events"`
+	// Errors are the sources that could not be read, by source; the
+	// timeline still holds the events of the others
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// New creates an empty timeline of a node.
+func New(target, hostname string, since, until time.Time) *Timeline {
+	return &Timeline{
+		Target:   target,
+		Hostname: hostname,
+		Since:    since.UTC(),
+		Until:    until.UTC(),
+		Events:   []Event{},
+	}
+}
+
+// Add adds the events within the window of the timeline, keeping it in
+// chronological order. Events at the same time keep the order they were
+// added in.
+func (t *Timeline) Add(events ...Event) {
+	for _, e := range events {
+		if e.Time.Before(t.Since) || !e.Time.Before(t.Until) {
+			continue
+		}
+		e.Time = e.Time.UTC()
+		t.Events = append(t.Events, e)
+	}
+	sort.SliceStable(t.Events, func(i, j int) bool {
+		return t.Events[i].Time.Before(t.Events[j].Time)
+	})
+}
+
+// Fail records a source that could not be read.
+func (t *Timeline) Fail(source string, err error) {
+	if t.Errors == nil {
+		t.Errors = map[string]string{}
+	}
+	t.Errors[source] = err.Error()
+}
+
+// AlertSeries is an alert and the times, oldest first, at which it was
+// firing, as sampled from the ALERTS series of Prometheus.
+type AlertSeries struct {
+	Labels map[string]string
+	Times  []time.Time
+}
+
+// AlertEvents turns alerts sampled every step into firing and resolved
+// events. A gap of more than a step between samples ends a firing; a
+// firing still going at the last sample before until is not resolved.
+func AlertEvents(series []AlertSeries, step time.Duration, until time.Time) []Event {
+	var events []Event
+	for _, s := range series {
+		if len(s.Times) == 0 {
+			continue
+		}
+		name := s.Labels["alertname"]
+		severity := s.Labels["severity"]
+		fired := func(at time.Time) {
+			events = append(events, Event{
+				Time:     at,
+				Source:   SourceAlerts,
+				Type:     EventAlertFiring,
+				Subject:  name,
+				Severity: severity,
+				Message:  fmt.Sprintf("%s firing", name),
+			})
+		}
+		resolved := func(at, since time.Time) {
+			events = append(events, Event{
+				Time:     at,
+				Source:   SourceAlerts,
+				Type:     EventAlertResolved,
+				Subject:  name,
+				Severity: severity,
+				Message:  fmt.Sprintf("%s resolved after %s", name, at.Sub(since).Round(time.Second)),
+			})
+		}
+
+		start := s.Times[0]
+		fired(start)
+		for i := 1; i < len(s.Times); i++ {
+			if s.Times[i].Sub(s.Times[i-1]) > step {
+				resolved(s.Times[i-1].Add(step), start)
+				start = s.Times[i]
+				fired(start)
+			}
+		}
+		if last := s.Times[len(s.Times)-1]; until.Sub(last) > step {
+			resolved(last.Add(step), start)
+		}
+	}
+	return events
+}
+
+// CheckEvents turns check results into an event when a check starts
+// failing and one when it passes again; repeated failures add none.
+func CheckEvents(results []checkresult.Result) []Event {
+	sorted := make([]checkresult.Result, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ReportedAt.Before(sorted[j].ReportedAt)
+	})
+
+	var events []Event
+	failing := map[string]time.Time{} // check -> since
+	for _, r := range sorted {
+		since, wasFailing := failing[r.Check]
+		switch {
+		case r.Status != checkresult.StatusPassed && !wasFailing:
+			failing[r.Check] = r.ReportedAt
+			message := fmt.Sprintf("Check %s %s", r.Check, r.Status)
+			if r.ExitCode != nil && r.Status == checkresult.StatusFailed {
+				message += fmt.Sprintf(" (exit code %d)", *r.ExitCode)
+			}
+			if r.Error != "" {
+				message += ": " + r.Error
+			}
+			events = append(events, Event{
+				Time:    r.ReportedAt,
+				Source:  SourceChecks,
+				Type:    EventCheckFailed,
+				Subject: r.Check,
+				Message: message,
+			})
+		case r.Status == checkresult.StatusPassed && wasFailing:
+			delete(failing, r.Check)
+			events = append(events, Event{
+				Time:    r.ReportedAt,
+				Source:  SourceChecks,
+				Type:    EventCheckRecovered,
+				Subject: r.Check,
+				Message: fmt.Sprintf("Check %s passing again after %s", r.Check, r.ReportedAt.Sub(since).Round(time.Second)),
+			})
+		}
+	}
+	return events
+}
+
+// DrainEvents turns the drain audit log into drained and resumed events.
+// Dry runs and failed drains left the node alone and are skipped.
+func DrainEvents(records []slurm.DrainRecord) []Event {
+	var events []Event
+	for _, r := range records {
+		e := Event{Time: r.Time, Source: SourceDrains, Subject: r.Rule}
+		switch r.Action {
+		case slurm.DrainActionDrained:
+			e.Type = EventNodeDrained
+			e.Message = "Drained"
+			if r.Reason != "" {
+				e.Message += ": " + r.Reason
+			}
+		case slurm.DrainActionResumed:
+			e.Type = EventNodeResumed
+			e.Message = "Resumed"
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// JobEvents turns job-GPU correlations, which the epilog hook reports for
+// failed jobs, into job failed events.
+func JobEvents(logs []slurm.CorrelationLog) []Event {
+	var events []Event
+	for _, c := range logs {
+		if c.ExitCode == 0 {
+			continue
+		}
+		at := c.Timestamp
+		if at.IsZero() {
+			at = c.ReportedAt
+		}
+		message := fmt.Sprintf("Job %d", c.JobID)
+		if c.User != "" {
+			message += " of " + c.User
+		}
+		message += fmt.Sprintf(" failed with exit code %d (GPU health %d, correlation %s)", c.ExitCode, c.HealthScore, c.Correlation)
+		if c.EventType != "" {
+			message += fmt.Sprintf(", %s %s", c.EventType, c.EventValue)
+		}
+		events = append(events, Event{
+			Time:    at,
+			Source:  SourceJobs,
+			Type:    EventJobFailed,
+			Subject: strconv.FormatInt(c.JobID, 10),
+			Message: message,
+		})
+	}
+	return events
+}
@@ -0,0 +1,246 @@
+// Package top provides the live cluster dashboard of aami top.
+package top
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/health"
+	"github.com/fregataa/aami/internal/slurm"
+)
+
+// GPU is a GPU of a node as shown by the dashboard.
+type GPU struct {
+	Index       int
+	Name        string
+	Temperature float64
+	Utilization float64 // %, -1 when not reported
+	Health      health.GPUHealth
+}
+
+// Node is a node with its GPUs and firing alerts.
+type Node struct {
+	Name     string
+	Instance string
+	Score    float64
+	Status   string
+	GPUs     []GPU
+	Alerts   []alertmanager.Alert
+}
+
+// Queue is the Slurm queue of a partition.
+type Queue struct {
+	Partition string
+	Pending   int
+	Running   int
+}
+
+// Snapshot is the state of the cluster at a time. Errors holds, by source,
+// what could not be collected; the rest of the snapshot is still shown.
+type Snapshot struct {
+	Nodes       []Node               // lowest score first
+	Alerts      []alertmanager.Alert // firing, newest first
+	Queues      []Queue              // by partition
+	SlurmOn     bool
+	Errors      map[string]string
+	CollectedAt time.Time
+}
+
+// Collector collects snapshots from Prometheus, Alertmanager and, when
+// set, Slurm.
+type Collector struct {
+	Prometheus   *health.PrometheusClient
+	Alertmanager *alertmanager.Client
+	Slurm        *slurm.Client     // nil without Slurm
+	NodeNames    map[string]string // node names by host, for instances without a node label
+	calculator   *health.Calculator
+}
+
+// NewCollector creates a collector. nodeNames maps the hosts of scrape
+// targets to node names.
+func NewCollector(prom *health.PrometheusClient, am *alertmanager.Client, slurmClient *slurm.Client, nodeNames map[string]string) *Collector {
+	return &Collector{
+		Prometheus:   prom,
+		Alertmanager: am,
+		Slurm:        slurmClient,
+		NodeNames:    nodeNames,
+		calculator:   health.NewCalculator(),
+	}
+}
+
+// Collect queries the sources concurrently.
+func (c *Collector) Collect(ctx context.Context) Snapshot {
+	snap := Snapshot{SlurmOn: c.Slurm != nil, Errors: make(map[string]string), CollectedAt: time.Now()}
+
+	var mu sync.Mutex
+	fail := func(source string, err error) {
+		mu.Lock()
+		snap.Errors[source] = err.Error()
+		mu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		nodes, err := c.collectNodes()
+		if err != nil {
+			fail("prometheus", err)
+		}
+		snap.Nodes = nodes
+	}()
+	go func() {
+		defer wg.Done()
+		alerts, err := c.Alertmanager.ListAlerts()
+		if err != nil {
+			fail("alertmanager", err)
+			return
+		}
+		// Only alerts that are neither silenced nor inhibited
+		for _, a := range alerts {
+			if a.Status.State == "active" {
+				snap.Alerts = append(snap.Alerts, a)
+			}
+		}
+		sort.Slice(snap.Alerts, func(i, j int) bool { return snap.Alerts[i].StartsAt.After(snap.Alerts[j].StartsAt) })
+	}()
+	if c.Slurm != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queues, err := c.collectQueues(ctx)
+			if err != nil {
+				fail("slurm", err)
+			}
+			snap.Queues = queues
+		}()
+	}
+	wg.Wait()
+
+	// Attach alerts to their nodes
+	byName := make(map[string]*Node, len(snap.Nodes))
+	for i := range snap.Nodes {
+		byName[snap.Nodes[i].Name] = &snap.Nodes[i]
+	}
+	for _, a := range snap.Alerts {
+		if n := byName[c.nodeName(a.Labels)]; n != nil {
+			n.Alerts = append(n.Alerts, a)
+		}
+	}
+	return snap
+}
+
+// collectNodes scores the GPUs of every node and adds their utilization
+func (c *Collector) collectNodes() ([]Node, error) {
+	metrics, err := c.Prometheus.CollectAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+	util := make(map[string]float64) // by instance_gpu
+	if resp, err := c.Prometheus.Query("DCGM_FI_DEV_GPU_UTIL"); err == nil {
+		for _, r := range resp.Data.Result {
+			if len(r.Value) < 2 {
+				continue
+			}
+			s, _ := r.Value[1].(string)
+			if v, err := strconv.ParseFloat(s, 64); err == nil {
+				util[r.Metric["instance"]+"_"+r.Metric["gpu"]] = v
+			}
+		}
+	}
+
+	nodes := make([]Node, 0, len(metrics))
+	for _, m := range metrics {
+		h := c.calculator.CalculateNodeHealth(m)
+		node := Node{
+			Name:     c.nodeName(map[string]string{"instance": m.NodeName}),
+			Instance: m.NodeName,
+			Score:    h.OverallScore,
+			Status:   h.Status,
+		}
+		for i, g := range m.GPUs {
+			u, ok := util[m.NodeName+"_"+g.GPU]
+			if !ok {
+				u = -1
+			}
+			node.GPUs = append(node.GPUs, GPU{
+				Index:       h.GPUs[i].Index,
+				Name:        g.Name,
+				Temperature: g.Temperature,
+				Utilization: u,
+				Health:      h.GPUs[i],
+			})
+		}
+		sort.Slice(node.GPUs, func(i, j int) bool { return node.GPUs[i].Index < node.GPUs[j].Index })
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Score != nodes[j].Score {
+			return nodes[i].Score < nodes[j].Score
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes, nil
+}
+
+// collectQueues counts the pending and running jobs of each partition
+func (c *Collector) collectQueues(ctx context.Context) ([]Queue, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	jobs, err := c.Slurm.GetJobs(ctx, slurm.JobFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	byPartition := make(map[string]*Queue)
+	for _, j := range jobs {
+		if j.State != slurm.JobStatePending && j.State != slurm.JobStateRunning {
+			continue
+		}
+		// Jobs submitted to several partitions count for the first
+		partition := j.Partition
+		if i := strings.IndexByte(partition, ','); i >= 0 {
+			partition = partition[:i]
+		}
+		q := byPartition[partition]
+		if q == nil {
+			q = &Queue{Partition: partition}
+			byPartition[partition] = q
+		}
+		if j.State == slurm.JobStatePending {
+			q.Pending++
+		} else {
+			q.Running++
+		}
+	}
+
+	queues := make([]Queue, 0, len(byPartition))
+	for _, q := range byPartition {
+		queues = append(queues, *q)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Partition < queues[j].Partition })
+	return queues, nil
+}
+
+// nodeName returns the node of a series or alert: its node label, or the
+// configured node with the host of its instance
+func (c *Collector) nodeName(labels map[string]string) string {
+	if node := labels["node"]; node != "" {
+		return node
+	}
+	instance := labels["instance"]
+	host, _, err := net.SplitHostPort(instance)
+	if err != nil {
+		host = instance
+	}
+	if name, ok := c.NodeNames[host]; ok {
+		return name
+	}
+	return host
+}
@@ -0,0 +1,394 @@
+package top
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fatih/color"
+
+	"github.com/fregataa/aami/internal/alertmanager"
+	"github.com/fregataa/aami/internal/health"
+)
+
+// Rows the overview gives to alerts and queues at most, so that nodes keep
+// most of the screen
+const (
+	maxAlertRows = 5
+	maxQueueRows = 5
+)
+
+var (
+	bold   = color.New(color.Bold).SprintFunc()
+	faint  = color.New(color.Faint).SprintFunc()
+	red    = color.New(color.FgRed).SprintFunc()
+	yellow = color.New(color.FgYellow).SprintFunc()
+	green  = color.New(color.FgGreen).SprintFunc()
+	invert = color.New(color.ReverseVideo).SprintFunc()
+)
+
+// snapshotMsg delivers a snapshot; tick is set for the periodic refresh,
+// which schedules the next one
+type snapshotMsg struct {
+	snap Snapshot
+	tick bool
+}
+
+type tickMsg struct{}
+
+// Model is the bubbletea model of the dashboard: an overview of nodes,
+// firing alerts and the Slurm queue, and a detail view of a node.
+type Model struct {
+	cluster   string
+	collector *Collector
+	interval  time.Duration
+
+	snap    Snapshot
+	loaded  bool
+	cursor  int    // selected node in the overview
+	offset  int    // first node shown
+	detail  string // node shown in detail, "" in the overview
+	width   int
+	height  int
+	loading bool
+}
+
+// New creates the dashboard of a cluster, refreshed every interval.
+func New(cluster string, collector *Collector, interval time.Duration) Model {
+	return Model{cluster: cluster, collector: collector, interval: interval, loading: true}
+}
+
+// Init collects the first snapshot.
+func (m Model) Init() tea.Cmd {
+	return m.collect(true)
+}
+
+func (m Model) collect(tick bool) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return snapshotMsg{snap: m.collector.Collect(ctx), tick: tick}
+	}
+}
+
+// Update handles keys, window sizes and snapshots.
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+		return m, nil
+
+	case snapshotMsg:
+		m.snap, m.loaded, m.loading = msg.snap, true, false
+		if m.cursor >= len(m.snap.Nodes) {
+			m.cursor = max(len(m.snap.Nodes)-1, 0)
+		}
+		m.scroll()
+		if msg.tick {
+			return m, tea.Tick(m.interval, func(time.Time) tea.Msg { return tickMsg{} })
+		}
+		return m, nil
+
+	case tickMsg:
+		m.loading = true
+		return m, m.collect(true)
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "r":
+			if m.loading {
+				return m, nil
+			}
+			m.loading = true
+			return m, m.collect(false)
+		}
+		if m.detail != "" {
+			switch msg.String() {
+			case "esc", "backspace", "left", "h":
+				m.detail = ""
+			}
+			return m, nil
+		}
+		switch msg.String() {
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, max(len(m.snap.Nodes)-1, 0))
+		case "home", "g":
+			m.cursor = 0
+		case "end", "G":
+			m.cursor = max(len(m.snap.Nodes)-1, 0)
+		case "enter", "right", "l":
+			if m.cursor < len(m.snap.Nodes) {
+				m.detail = m.snap.Nodes[m.cursor].Name
+			}
+		}
+		m.scroll()
+	}
+	return m, nil
+}
+
+// nodeRows returns how many nodes the overview has room for
+func (m Model) nodeRows() int {
+	if m.height == 0 {
+		return len(m.snap.Nodes)
+	}
+	// Header, errors, the nodes' title and columns, the alerts and queue
+	// sections with their titles, columns and blank lines, the position
+	// of the nodes shown and the help
+	used := 2 + len(m.snap.Errors) + 2 + 1 + 1
+	used += 3 + max(min(len(m.snap.Alerts), maxAlertRows), 1)
+	if m.snap.SlurmOn {
+		used += 3 + max(min(len(m.snap.Queues), maxQueueRows), 1)
+	}
+	return max(m.height-used, 3)
+}
+
+// scroll keeps the cursor within the nodes shown
+func (m *Model) scroll() {
+	rows := m.nodeRows()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+rows {
+		m.offset = m.cursor - rows + 1
+	}
+	m.offset = max(min(m.offset, len(m.snap.Nodes)-rows), 0)
+}
+
+// View renders the overview or the detail view.
+func (m Model) View() string {
+	if !m.loaded {
+		return "Collecting from Prometheus, Alertmanager and Slurm...\n"
+	}
+	var b strings.Builder
+	m.viewHeader(&b)
+	if m.detail != "" {
+		m.viewDetail(&b)
+		b.WriteString(faint("esc back · r refresh · q quit"))
+	} else {
+		m.viewOverview(&b)
+		b.WriteString(faint("↑/↓ select · enter details · r refresh · q quit"))
+	}
+	return b.String()
+}
+
+func (m Model) viewHeader(b *strings.Builder) {
+	var gpus, warning, critical int
+	for _, n := range m.snap.Nodes {
+		for _, g := range n.GPUs {
+			gpus++
+			switch g.Health.Status {
+			case health.StatusWarning:
+				warning++
+			case health.StatusCritical:
+				critical++
+			}
+		}
+	}
+	refresh := fmt.Sprintf("updated %s, every %s", m.snap.CollectedAt.Format("15:04:05"), m.interval)
+	if m.loading {
+		refresh = "refreshing..."
+	}
+	fmt.Fprintf(b, "%s  %d nodes · %d GPUs (%s warning, %s critical) · %d alerts firing · %s\n",
+		bold("aami top: "+m.cluster), len(m.snap.Nodes), gpus,
+		colorCount(warning, yellow), colorCount(critical, red), len(m.snap.Alerts), faint(refresh))
+
+	sources := make([]string, 0, len(m.snap.Errors))
+	for source := range m.snap.Errors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		b.WriteString(red(truncate(fmt.Sprintf("%s: %s", source, m.snap.Errors[source]), m.width)) + "\n")
+	}
+	b.WriteString("\n")
+}
+
+func (m Model) viewOverview(b *strings.Builder) {
+	b.WriteString(bold("Nodes") + "\n")
+	fmt.Fprintf(b, "  %-24s %6s  %-9s %5s %8s %8s %7s\n", "NODE", "SCORE", "STATUS", "GPUS", "MAX TEMP", "AVG UTIL", "ALERTS")
+	if len(m.snap.Nodes) == 0 {
+		b.WriteString(faint("  No GPU metrics in Prometheus") + "\n")
+	}
+	end := min(m.offset+m.nodeRows(), len(m.snap.Nodes))
+	for i := m.offset; i < end; i++ {
+		n := m.snap.Nodes[i]
+		maxTemp, avgUtil := nodeTemperatureUtilization(n)
+		line := fmt.Sprintf("%-24s %6.1f  %-9s %5d %8s %8s %7d",
+			truncate(n.Name, 24), n.Score, n.Status, len(n.GPUs), maxTemp, avgUtil, len(n.Alerts))
+		if i == m.cursor {
+			b.WriteString("> " + invert(line) + "\n")
+		} else {
+			b.WriteString("  " + colorStatus(n.Status, line) + "\n")
+		}
+	}
+	if hidden := len(m.snap.Nodes) - (end - m.offset); hidden > 0 {
+		fmt.Fprintf(b, "  %s\n", faint(fmt.Sprintf("%d-%d of %d", m.offset+1, end, len(m.snap.Nodes))))
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(b, "%s\n", bold(fmt.Sprintf("Firing alerts (%d)", len(m.snap.Alerts))))
+	if _, down := m.snap.Errors["alertmanager"]; down {
+		b.WriteString(faint("  Alertmanager unreachable") + "\n")
+	} else if len(m.snap.Alerts) == 0 {
+		b.WriteString(faint("  None") + "\n")
+	}
+	for i, a := range m.snap.Alerts {
+		if i == maxAlertRows {
+			break
+		}
+		b.WriteString("  " + m.alertLine(a) + "\n")
+	}
+	b.WriteString("\n")
+
+	if !m.snap.SlurmOn {
+		return
+	}
+	pending := 0
+	for _, q := range m.snap.Queues {
+		pending += q.Pending
+	}
+	fmt.Fprintf(b, "%s\n", bold(fmt.Sprintf("Slurm queue (%d pending)", pending)))
+	fmt.Fprintf(b, "  %-20s %8s %8s\n", "PARTITION", "PENDING", "RUNNING")
+	if len(m.snap.Queues) == 0 {
+		b.WriteString(faint("  No jobs") + "\n")
+	}
+	for i, q := range m.snap.Queues {
+		if i == maxQueueRows {
+			break
+		}
+		fmt.Fprintf(b, "  %-20s %8d %8d\n", truncate(q.Partition, 20), q.Pending, q.Running)
+	}
+	b.WriteString("\n")
+}
+
+func (m Model) viewDetail(b *strings.Builder) {
+	var node *Node
+	for i := range m.snap.Nodes {
+		if m.snap.Nodes[i].Name == m.detail {
+			node = &m.snap.Nodes[i]
+		}
+	}
+	if node == nil {
+		fmt.Fprintf(b, "%s\n\n", faint(fmt.Sprintf("%s no longer reports GPU metrics", m.detail)))
+		return
+	}
+
+	fmt.Fprintf(b, "%s  %s  score %.1f  %s\n\n", bold(node.Name), faint(node.Instance), node.Score,
+		colorStatus(node.Status, node.Status))
+	fmt.Fprintf(b, "  %-4s %-24s %6s %6s %6s  %-9s %s\n", "GPU", "MODEL", "TEMP", "UTIL", "SCORE", "STATUS", "ISSUES")
+	for _, g := range node.GPUs {
+		util := "-"
+		if g.Utilization >= 0 {
+			util = fmt.Sprintf("%.0f%%", g.Utilization)
+		}
+		var issues []string
+		for _, c := range g.Health.Components {
+			if c.Status != health.StatusHealthy {
+				issues = append(issues, fmt.Sprintf("%s: %s", c.Name, c.Message))
+			}
+		}
+		line := fmt.Sprintf("%-4d %-24s %5.0fC %6s %6.1f  %-9s", g.Index, truncate(g.Name, 24),
+			g.Temperature, util, g.Health.OverallScore, g.Health.Status)
+		fmt.Fprintf(b, "  %s %s\n", colorStatus(g.Health.Status, line), strings.Join(issues, "; "))
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(b, "%s\n", bold(fmt.Sprintf("Firing alerts (%d)", len(node.Alerts))))
+	if len(node.Alerts) == 0 {
+		b.WriteString(faint("  None") + "\n")
+	}
+	for _, a := range node.Alerts {
+		b.WriteString("  " + m.alertLine(a) + "\n")
+		if summary := a.Annotations["summary"]; summary != "" {
+			b.WriteString("      " + faint(truncate(summary, m.width-6)) + "\n")
+		}
+	}
+	b.WriteString("\n")
+}
+
+func (m Model) alertLine(a alertmanager.Alert) string {
+	severity := a.Labels["severity"]
+	line := fmt.Sprintf("%-9s %-32s %-20s %5s", truncate(severity, 9), truncate(a.Labels["alertname"], 32),
+		truncate(m.collector.nodeName(a.Labels), 20), formatAge(a.StartsAt, m.snap.CollectedAt))
+	switch severity {
+	case "critical":
+		return red(line)
+	case "warning":
+		return yellow(line)
+	}
+	return line
+}
+
+// nodeTemperatureUtilization returns the hottest GPU's temperature and the
+// mean utilization of the GPUs that report it
+func nodeTemperatureUtilization(n Node) (string, string) {
+	maxTemp, util, reported := 0.0, 0.0, 0
+	for _, g := range n.GPUs {
+		if g.Temperature > maxTemp {
+			maxTemp = g.Temperature
+		}
+		if g.Utilization >= 0 {
+			util += g.Utilization
+			reported++
+		}
+	}
+	avgUtil := "-"
+	if reported > 0 {
+		avgUtil = fmt.Sprintf("%.0f%%", util/float64(reported))
+	}
+	return fmt.Sprintf("%.0fC", maxTemp), avgUtil
+}
+
+func colorStatus(status, s string) string {
+	switch status {
+	case health.StatusCritical:
+		return red(s)
+	case health.StatusWarning:
+		return yellow(s)
+	case health.StatusHealthy:
+		return green(s)
+	}
+	return s
+}
+
+func colorCount(n int, c func(a ...interface{}) string) string {
+	if n == 0 {
+		return "0"
+	}
+	return c(n)
+}
+
+// truncate shortens s to n characters; n <= 0 keeps it whole
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 || len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(r[:n])
+	}
+	return string(r[:n-3]) + "..."
+}
+
+// formatAge formats how long ago a time was, e.g. 5m or 3h
+func formatAge(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
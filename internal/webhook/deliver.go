@@ -0,0 +1,181 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// retryDelays are the waits before the second and third attempt of a
+// delivery
+var retryDelays = []time.Duration{1 * time.Second, 5 * time.Second}
+
+// Request headers of a delivery
+const (
+	HeaderEvent     = "X-AAMI-Event"
+	HeaderDelivery  = "X-AAMI-Delivery"
+	HeaderTimestamp = "X-AAMI-Timestamp"
+	HeaderSignature = "X-AAMI-Signature"
+)
+
+// Sign returns the signature of a delivery: "v1=" and the hex HMAC-SHA256,
+// with the webhook's secret, of "v1:<timestamp>:<body>". Receivers compute
+// the same and reject old timestamps, so a captured delivery cannot be
+// replayed.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v1:%s:", timestamp)
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver sends events to the webhooks subscribed to them. Each webhook
+// receives its events in order, concurrently with the other webhooks.
+// Deliveries that fail every attempt are kept as dead letters and returned
+// as one error.
+func Deliver(store *Store, events []Event) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, h := range store.List() {
+		var payloads []DeadLetter
+		for _, e := range events {
+			if !h.Subscribed(e.Type) {
+				continue
+			}
+			body, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("marshal event: %w", err)
+			}
+			payloads = append(payloads, DeadLetter{ID: e.ID + "-" + h.Name, Webhook: h.Name, Event: e.Type, Payload: string(body)})
+		}
+		if len(payloads) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(h Webhook, payloads []DeadLetter) {
+			defer wg.Done()
+			for _, d := range payloads {
+				if err := deliverWithRetries(store, h, d); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}(h, payloads)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Ping sends a webhook.ping event to a webhook, whatever its events, with
+// a single attempt.
+func Ping(h Webhook, cluster string) (Event, error) {
+	e := Event{ID: newID(), Type: EventPing, OccurredAt: time.Now().UTC(), Cluster: cluster, Resource: "webhook", Name: h.Name}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	_, err = send(h, e.ID+"-"+h.Name, e.Type, body)
+	return e, err
+}
+
+// ErrNotFound is returned by Redeliver for an unknown dead letter, or one
+// whose webhook was removed
+var ErrNotFound = errors.New("not found")
+
+// Redeliver sends a dead letter again, with retries, and removes it once
+// delivered. The payload is signed with the webhook's current secret.
+func Redeliver(store *Store, id string) error {
+	letters, err := store.DeadLetters()
+	if err != nil {
+		return err
+	}
+	for _, d := range letters {
+		if d.ID != id {
+			continue
+		}
+		h, ok := store.Get(d.Webhook)
+		if !ok {
+			return fmt.Errorf("webhook %w: %s", ErrNotFound, d.Webhook)
+		}
+		if err := deliverWithRetries(store, h, d); err != nil {
+			return err
+		}
+		return store.RemoveDeadLetter(id)
+	}
+	return fmt.Errorf("dead letter %w: %s", ErrNotFound, id)
+}
+
+// deliverWithRetries sends a payload until it is accepted, a client error
+// says retrying will not help, or the attempts run out; it then records a
+// dead letter, counting the attempts of earlier deliveries of it
+func deliverWithRetries(store *Store, h Webhook, d DeadLetter) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = send(h, d.ID, d.Event, []byte(d.Payload))
+		d.Attempts++
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= len(retryDelays) {
+			break
+		}
+		time.Sleep(retryDelays[attempt])
+	}
+
+	d.Error = err.Error()
+	d.FailedAt = time.Now().UTC().Truncate(time.Second)
+	if serr := store.AddDeadLetter(d); serr != nil {
+		return fmt.Errorf("webhook %s: %v (and record dead letter: %v)", h.Name, err, serr)
+	}
+	return fmt.Errorf("webhook %s: %s not delivered after %d attempts: %w", h.Name, d.Event, d.Attempts, err)
+}
+
+// send makes one delivery attempt and reports whether a failure is worth
+// retrying: network errors, 429 and 5xx are
+func send(h Webhook, deliveryID, eventType string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "aami-webhook")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(h.Secret, timestamp, body))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("HTTP %d", resp.StatusCode)
+	if s := strings.TrimSpace(string(msg)); s != "" {
+		err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, s)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
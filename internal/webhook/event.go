@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/fregataa/aami/internal/config"
+)
+
+// Resources whose lifecycle raises events
+const (
+	ResourceTarget    = "target"     // nodes
+	ResourceAlertRule = "alert_rule" // alerts.custom
+	ResourceNamespace = "namespace"  // alerts.namespaces
+)
+
+// Lifecycle actions
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// EventPing is sent by 'aami webhooks test' to any webhook
+const EventPing = "webhook.ping"
+
+// EventTypes lists the event types webhooks can subscribe to.
+var EventTypes = func() []string {
+	var types []string
+	for _, r := range []string{ResourceTarget, ResourceAlertRule, ResourceNamespace} {
+		for _, a := range []string{ActionCreated, ActionUpdated, ActionDeleted} {
+			types = append(types, r+"."+a)
+		}
+	}
+	return types
+}()
+
+// ValidFilter reports whether an event filter matches known event types:
+// an event type, <resource>.* or *.
+func ValidFilter(filter string) bool {
+	for _, t := range EventTypes {
+		if MatchEvent(filter, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchEvent reports whether an event filter matches an event type.
+func MatchEvent(filter, eventType string) bool {
+	if filter == "*" || filter == eventType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(filter, ".*")
+	return ok && strings.HasPrefix(eventType, prefix+".")
+}
+
+// Event is the JSON payload delivered to webhooks.
+type Event struct {
+	ID         string                 `json:"id"`
+	Type       string                 `json:"type"` // <resource>.<action>
+	OccurredAt time.Time              `json:"occurred_at"`
+	Cluster    string                 `json:"cluster"`
+	Resource   string                 `json:"resource"`
+	Name       string                 `json:"name"`
+	Object     map[string]interface{} `json:"object"`             // as in config.yaml; the old object when deleted
+	Previous   map[string]interface{} `json:"previous,omitempty"` // the old object of an update
+}
+
+// ConfigEvents returns the events of saving after in place of before, in
+// the order targets, alert rules, namespaces. before is nil when the config
+// is saved for the first time.
+func ConfigEvents(before, after *config.Config) []Event {
+	if before == nil {
+		before = &config.Config{}
+	}
+	var events []Event
+	events = append(events, diff(ResourceTarget, before.Nodes, after.Nodes,
+		func(n config.NodeConfig) string { return n.Name })...)
+	events = append(events, diff(ResourceAlertRule, before.Alerts.Custom, after.Alerts.Custom,
+		func(r config.CustomAlertRule) string { return r.Name })...)
+	events = append(events, diff(ResourceNamespace, before.Alerts.Namespaces, after.Alerts.Namespaces,
+		func(ns config.RuleNamespace) string { return ns.Name })...)
+
+	now := time.Now().UTC()
+	for i := range events {
+		events[i].ID = newID()
+		events[i].OccurredAt = now
+		events[i].Cluster = after.Cluster.Name
+	}
+	return events
+}
+
+// diff compares two lists of named objects
+func diff[T any](resource string, before, after []T, name func(T) string) []Event {
+	old := make(map[string]T, len(before))
+	for _, o := range before {
+		old[name(o)] = o
+	}
+
+	var events []Event
+	seen := make(map[string]bool, len(after))
+	for _, o := range after {
+		n := name(o)
+		seen[n] = true
+		prev, existed := old[n]
+		switch {
+		case !existed:
+			events = append(events, Event{Type: resource + "." + ActionCreated, Resource: resource, Name: n, Object: object(o)})
+		case !sameObject(prev, o):
+			events = append(events, Event{Type: resource + "." + ActionUpdated, Resource: resource, Name: n, Object: object(o), Previous: object(prev)})
+		}
+	}
+	for _, o := range before {
+		if n := name(o); !seen[n] {
+			events = append(events, Event{Type: resource + "." + ActionDeleted, Resource: resource, Name: n, Object: object(o)})
+		}
+	}
+	return events
+}
+
+func sameObject(a, b interface{}) bool {
+	x, _ := yaml.Marshal(a)
+	y, _ := yaml.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+// object converts a config object to a map keyed like config.yaml
+func object(v interface{}) map[string]interface{} {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+func newID() string {
+	id, err := randomHex(8)
+	if err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return id
+}
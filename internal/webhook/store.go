@@ -0,0 +1,295 @@
+// Package webhook delivers resource lifecycle events — targets, alert
+// rules and namespaces created, updated or deleted — to registered HTTP
+// endpoints. Each webhook subscribes to event types, receives a JSON
+// payload signed with its own secret, and deliveries that still fail after
+// their retries are kept as dead letters to be redelivered.
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultStorePath is where webhooks and their secrets are kept
+const DefaultStorePath = "/etc/aami/webhooks.yaml"
+
+// DefaultDeadLetterPath is where failed deliveries are kept
+const DefaultDeadLetterPath = "/var/lib/aami/webhook-dead-letters.yaml"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Webhook is a registered endpoint.
+type Webhook struct {
+	Name      string    `yaml:"name" json:"name"`
+	URL       string    `yaml:"url" json:"url"`
+	Events    []string  `yaml:"events" json:"events"` // event types or patterns such as target.* and *
+	Secret    string    `yaml:"secret" json:"-"`
+	CreatedAt time.Time `yaml:"created_at" json:"created_at"`
+}
+
+// Subscribed reports whether the webhook receives an event type.
+func (h Webhook) Subscribed(eventType string) bool {
+	for _, f := range h.Events {
+		if MatchEvent(f, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeadLetter is a delivery that failed all of its attempts.
+type DeadLetter struct {
+	ID       string    `yaml:"id" json:"id"` // the delivery ID
+	Webhook  string    `yaml:"webhook" json:"webhook"`
+	Event    string    `yaml:"event" json:"event"`
+	Payload  string    `yaml:"payload" json:"payload"`
+	Error    string    `yaml:"error" json:"error"`
+	Attempts int       `yaml:"attempts" json:"attempts"`
+	FailedAt time.Time `yaml:"failed_at" json:"failed_at"`
+}
+
+// StoreConfig is the on-disk format of the webhook store.
+type StoreConfig struct {
+	Webhooks []Webhook `yaml:"webhooks"`
+}
+
+// Store manages webhooks and dead letters.
+type Store struct {
+	path     string
+	deadPath string
+	webhooks map[string]Webhook
+	mu       sync.RWMutex
+}
+
+// NewStore creates a webhook store.
+func NewStore(path, deadPath string) *Store {
+	return &Store{
+		path:     path,
+		deadPath: deadPath,
+		webhooks: make(map[string]Webhook),
+	}
+}
+
+// Load reads the webhooks from disk.
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read webhooks: %w", err)
+	}
+
+	var config StoreConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("parse webhooks: %w", err)
+	}
+
+	s.webhooks = make(map[string]Webhook)
+	for _, h := range config.Webhooks {
+		s.webhooks[h.Name] = h
+	}
+	return nil
+}
+
+func (s *Store) saveUnlocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	var config StoreConfig
+	for _, h := range s.webhooks {
+		config.Webhooks = append(config.Webhooks, h)
+	}
+	sort.Slice(config.Webhooks, func(i, j int) bool {
+		return config.Webhooks[i].Name < config.Webhooks[j].Name
+	})
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal webhooks: %w", err)
+	}
+
+	// Write with restricted permissions (contains the signing secrets)
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("write webhooks: %w", err)
+	}
+	return nil
+}
+
+// Create registers a webhook with a new signing secret.
+func (s *Store) Create(name, rawURL string, events []string) (Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// dead-letters is a path of the webhook API
+	if !namePattern.MatchString(name) || name == "dead-letters" {
+		return Webhook{}, fmt.Errorf("invalid webhook name: %s", name)
+	}
+	if _, ok := s.webhooks[name]; ok {
+		return Webhook{}, fmt.Errorf("webhook already exists: %s", name)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("invalid webhook url: %s", rawURL)
+	}
+	if len(events) == 0 {
+		return Webhook{}, fmt.Errorf("webhook %s subscribes to no events", name)
+	}
+	for _, e := range events {
+		if !ValidFilter(e) {
+			return Webhook{}, fmt.Errorf("unknown event %q: must be one of %s, <resource>.* or *", e, strings.Join(EventTypes, ", "))
+		}
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return Webhook{}, err
+	}
+	h := Webhook{
+		Name:      name,
+		URL:       rawURL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	s.webhooks[name] = h
+	return h, s.saveUnlocked()
+}
+
+// Delete removes a webhook.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[name]; !ok {
+		return fmt.Errorf("webhook not found: %s", name)
+	}
+	delete(s.webhooks, name)
+	return s.saveUnlocked()
+}
+
+// Get returns a webhook by name.
+func (s *Store) Get(name string) (Webhook, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.webhooks[name]
+	return h, ok
+}
+
+// List returns all webhooks sorted by name.
+func (s *Store) List() []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for _, h := range s.webhooks {
+		webhooks = append(webhooks, h)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Name < webhooks[j].Name
+	})
+	return webhooks
+}
+
+// DeadLetters returns the failed deliveries, oldest first.
+func (s *Store) DeadLetters() ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.deadLettersUnlocked()
+}
+
+func (s *Store) deadLettersUnlocked() ([]DeadLetter, error) {
+	data, err := os.ReadFile(s.deadPath)
+	if os.IsNotExist(err) {
+		return []DeadLetter{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read dead letters: %w", err)
+	}
+	var letters []DeadLetter
+	if err := yaml.Unmarshal(data, &letters); err != nil {
+		return nil, fmt.Errorf("parse dead letters: %w", err)
+	}
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	return letters, nil
+}
+
+func (s *Store) saveDeadLettersUnlocked(letters []DeadLetter) error {
+	if err := os.MkdirAll(filepath.Dir(s.deadPath), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	data, err := yaml.Marshal(letters)
+	if err != nil {
+		return fmt.Errorf("marshal dead letters: %w", err)
+	}
+	if err := os.WriteFile(s.deadPath, data, 0600); err != nil {
+		return fmt.Errorf("write dead letters: %w", err)
+	}
+	return nil
+}
+
+// AddDeadLetter records a failed delivery, replacing an earlier failure of
+// the same delivery.
+func (s *Store) AddDeadLetter(d DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters, err := s.deadLettersUnlocked()
+	if err != nil {
+		return err
+	}
+	kept := letters[:0]
+	for _, l := range letters {
+		if l.ID != d.ID {
+			kept = append(kept, l)
+		}
+	}
+	return s.saveDeadLettersUnlocked(append(kept, d))
+}
+
+// RemoveDeadLetter drops a dead letter, after it was redelivered.
+func (s *Store) RemoveDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	letters, err := s.deadLettersUnlocked()
+	if err != nil {
+		return err
+	}
+	kept := letters[:0]
+	for _, l := range letters {
+		if l.ID != id {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == len(letters) {
+		return fmt.Errorf("dead letter not found: %s", id)
+	}
+	return s.saveDeadLettersUnlocked(kept)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config contains the settings every exporter shares. Exporters with their
+// own settings embed it inline:
+//
+//	type config struct {
+//		exporter.Config `yaml:",inline"`
+//		Devices []string `yaml:"devices"`
+//	}
+type Config struct {
+	ListenAddress   string `yaml:"listen_address"`   // e.g. ":9315"
+	MetricsPath     string `yaml:"metrics_path"`     // default: "/metrics"
+	ScrapeTimeout   string `yaml:"scrape_timeout"`   // how long collectors may take, default: "10s"
+	ShutdownTimeout string `yaml:"shutdown_timeout"` // how long in-flight scrapes may finish, default: "5s"
+
+	scrapeTimeout   time.Duration
+	shutdownTimeout time.Duration
+}
+
+// Validate checks the durations and the metrics path.
+func (c Config) Validate() error {
+	if c.MetricsPath != "" && (c.MetricsPath[0] != '/' || c.MetricsPath == "/" || c.MetricsPath == "/health") {
+		return fmt.Errorf("invalid metrics_path %q", c.MetricsPath)
+	}
+	for name, value := range map[string]string{"scrape_timeout": c.ScrapeTimeout, "shutdown_timeout": c.ShutdownTimeout} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	return nil
+}
+
+// setDefaults fills unset fields; Validate has rejected bad durations
+func (c *Config) setDefaults() {
+	if c.MetricsPath == "" {
+		c.MetricsPath = DefaultMetricsPath
+	}
+	c.scrapeTimeout = DefaultScrapeTimeout
+	if d, err := time.ParseDuration(c.ScrapeTimeout); err == nil && d > 0 {
+		c.scrapeTimeout = d
+	}
+	c.shutdownTimeout = DefaultShutdownTimeout
+	if d, err := time.ParseDuration(c.ShutdownTimeout); err == nil && d > 0 {
+		c.shutdownTimeout = d
+	}
+}
+
+var envVar = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// LoadConfig reads a YAML config file into v, expanding ${VAR_NAME}
+// environment variables as AAMI's own config does. v is usually a struct
+// embedding Config; a Config found in it is validated.
+func LoadConfig(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	expanded := envVar.ReplaceAllStringFunc(string(data), func(match string) string {
+		return os.Getenv(match[2 : len(match)-1])
+	})
+	if err := yaml.Unmarshal([]byte(expanded), v); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	type validator interface{ Validate() error }
+	if c, ok := v.(validator); ok {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
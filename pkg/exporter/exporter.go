@@ -0,0 +1,316 @@
+// Package exporter is the shared library of AAMI's Prometheus exporters.
+// An exporter implements Collector for the hardware or service it watches;
+// Exporter serves its metrics on /metrics and its health on /health, loads
+// its YAML config and shuts down gracefully, so a custom exporter (IPMI,
+// InfiniBand, ...) is little more than its Collect method:
+//
+//	cfg := exporter.Config{ListenAddress: ":9315"}
+//	e := exporter.New("infiniband", cfg)
+//	e.Register(exporter.CollectorFunc("ports", collectPorts))
+//	log.Fatal(e.Run(context.Background()))
+//
+// Exporters running on nodes register with the Config Server through a
+// Registrar, so service discovery scrapes them.
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Defaults for Config
+const (
+	DefaultMetricsPath     = "/metrics"
+	DefaultScrapeTimeout   = 10 * time.Second
+	DefaultShutdownTimeout = 5 * time.Second
+)
+
+// Collector gathers the metrics of one source on every scrape.
+type Collector interface {
+	// Name identifies the collector in the scrape_collector_* metrics and
+	// /health
+	Name() string
+	// Collect returns the current samples. The context ends at the scrape
+	// timeout.
+	Collect(ctx context.Context) ([]Metric, error)
+}
+
+// HealthChecker is implemented by collectors that can tell whether their
+// source is usable, e.g. a device is present. /health reports unhealthy
+// when a check fails.
+type HealthChecker interface {
+	Healthy(ctx context.Context) error
+}
+
+type collectorFunc struct {
+	name string
+	fn   func(ctx context.Context) ([]Metric, error)
+}
+
+func (c collectorFunc) Name() string { return c.name }
+
+func (c collectorFunc) Collect(ctx context.Context) ([]Metric, error) { return c.fn(ctx) }
+
+// CollectorFunc turns a function into a Collector.
+func CollectorFunc(name string, fn func(ctx context.Context) ([]Metric, error)) Collector {
+	return collectorFunc{name: name, fn: fn}
+}
+
+// Exporter serves the metrics of its collectors over HTTP.
+type Exporter struct {
+	name      string
+	namespace string // metric prefix of the exporter's own metrics
+	cfg       Config
+	logger    *log.Logger
+
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// New creates an exporter. name prefixes the exporter's own metrics, e.g.
+// "ipmi" gives ipmi_scrape_collector_success.
+func New(name string, cfg Config) *Exporter {
+	cfg.setDefaults()
+	return &Exporter{
+		name:      name,
+		namespace: strings.NewReplacer("-", "_", ".", "_").Replace(name),
+		cfg:       cfg,
+		logger:    log.New(os.Stderr, name+": ", log.LstdFlags),
+	}
+}
+
+// Register adds collectors. Collectors with the same name replace each
+// other.
+func (e *Exporter) Register(collectors ...Collector) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range collectors {
+		replaced := false
+		for i, existing := range e.collectors {
+			if existing.Name() == c.Name() {
+				e.collectors[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			e.collectors = append(e.collectors, c)
+		}
+	}
+}
+
+// SetLogger replaces the logger, which writes to stderr by default.
+func (e *Exporter) SetLogger(l *log.Logger) {
+	e.logger = l
+}
+
+// Gather runs every collector concurrently and returns their samples
+// followed by the scrape_collector_success and
+// scrape_collector_duration_seconds of each. A collector that fails or
+// returns an invalid sample contributes no samples.
+func (e *Exporter) Gather(ctx context.Context) []Metric {
+	e.mu.RLock()
+	collectors := append([]Collector(nil), e.collectors...)
+	e.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.scrapeTimeout)
+	defer cancel()
+
+	type outcome struct {
+		name     string
+		metrics  []Metric
+		err      error
+		duration time.Duration
+	}
+	outcomes := make([]outcome, len(collectors))
+	var wg sync.WaitGroup
+	for i, c := range collectors {
+		wg.Add(1)
+		go func(i int, c Collector) {
+			defer wg.Done()
+			start := time.Now()
+			metrics, err := collect(ctx, c)
+			outcomes[i] = outcome{name: c.Name(), metrics: metrics, err: err, duration: time.Since(start)}
+		}(i, c)
+	}
+	wg.Wait()
+
+	var all []Metric
+	for _, o := range outcomes {
+		success := 1.0
+		if o.err != nil {
+			success = 0
+			e.logger.Printf("collector %s failed: %v", o.name, o.err)
+		} else {
+			all = append(all, o.metrics...)
+		}
+		labels := map[string]string{"collector": o.name}
+		all = append(all,
+			NewGauge(e.namespace+"_scrape_collector_success",
+				"Whether the collector succeeded in the last scrape.", success, labels),
+			NewGauge(e.namespace+"_scrape_collector_duration_seconds",
+				"How long the collector took in the last scrape.", o.duration.Seconds(), labels))
+	}
+	return all
+}
+
+// collect runs a collector, turning a panic into an error so one broken
+// collector does not take the exporter down
+func collect(ctx context.Context, c Collector) (metrics []Metric, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	metrics, err = c.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out: %w", ctx.Err())
+	}
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
+
+// Handler returns the HTTP handler serving the metrics path, /health and a
+// landing page on /.
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(e.cfg.MetricsPath, e.serveMetrics)
+	mux.HandleFunc("/health", e.serveHealth)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%[1]s exporter</title></head><body><h1>%[1]s exporter</h1>"+
+			"<p><a href=\"%[2]s\">Metrics</a> &middot; <a href=\"/health\">Health</a></p></body></html>\n",
+			e.name, e.cfg.MetricsPath)
+	})
+	return mux
+}
+
+func (e *Exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	if err := WriteText(&buf, e.Gather(r.Context())); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// serveHealth reports the result of every collector's health check
+func (e *Exporter) serveHealth(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	collectors := append([]Collector(nil), e.collectors...)
+	e.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.cfg.scrapeTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	checks := make(map[string]string)
+	for _, c := range collectors {
+		hc, ok := c.(HealthChecker)
+		if !ok {
+			continue
+		}
+		if err := hc.Healthy(ctx); err != nil {
+			checks[c.Name()] = err.Error()
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[c.Name()] = "ok"
+	}
+
+	names := make([]string, 0, len(collectors))
+	for _, c := range collectors {
+		names = append(names, c.Name())
+	}
+	sort.Strings(names)
+
+	body := struct {
+		Status     string            `json:"status"`
+		Exporter   string            `json:"exporter"`
+		Collectors []string          `json:"collectors"`
+		Checks     map[string]string `json:"checks,omitempty"`
+	}{Status: "ok", Exporter: e.name, Collectors: names, Checks: checks}
+	if status != http.StatusOK {
+		body.Status = "unhealthy"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Run serves until ctx ends or the process receives SIGINT or SIGTERM,
+// then stops accepting scrapes and waits for those in flight.
+func (e *Exporter) Run(ctx context.Context) error {
+	if e.cfg.ListenAddress == "" {
+		return fmt.Errorf("listen_address is required")
+	}
+	if err := e.cfg.Validate(); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", e.cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", e.cfg.ListenAddress, err)
+	}
+	return e.Serve(ctx, listener)
+}
+
+// Serve is Run on an existing listener.
+func (e *Exporter) Serve(ctx context.Context, listener net.Listener) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Handler:           e.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+	e.logger.Printf("serving %s on http://%s%s", e.name, listener.Addr(), e.cfg.MetricsPath)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	e.logger.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), e.cfg.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
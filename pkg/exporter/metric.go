@@ -0,0 +1,143 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetricType is the Prometheus type of a metric
+type MetricType string
+
+// Metric types
+const (
+	Gauge   MetricType = "gauge"
+	Counter MetricType = "counter"
+	Untyped MetricType = "untyped"
+)
+
+// Metric is one sample. Samples sharing a name form a metric family and
+// must share Help and Type.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   MetricType
+	Labels map[string]string
+	Value  float64
+}
+
+// NewGauge returns a gauge sample.
+func NewGauge(name, help string, value float64, labels map[string]string) Metric {
+	return Metric{Name: name, Help: help, Type: Gauge, Labels: labels, Value: value}
+}
+
+// NewCounter returns a counter sample.
+func NewCounter(name, help string, value float64, labels map[string]string) Metric {
+	return Metric{Name: name, Help: help, Type: Counter, Labels: labels, Value: value}
+}
+
+// validName reports whether s is a valid metric or label name
+func validName(s string, metric bool) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case c == ':' && metric:
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the metric's name and label names.
+func (m Metric) Validate() error {
+	if !validName(m.Name, true) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	for name := range m.Labels {
+		if !validName(name, false) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("metric %s: invalid label name %q", m.Name, name)
+		}
+	}
+	return nil
+}
+
+// WriteText writes metrics in the Prometheus text exposition format,
+// grouped by name with HELP and TYPE once per family, sorted so output is
+// stable between scrapes.
+func WriteText(w io.Writer, metrics []Metric) error {
+	sorted := make([]Metric, len(metrics))
+	copy(sorted, metrics)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	bw := bufio.NewWriter(w)
+	for i, m := range sorted {
+		if i == 0 || sorted[i-1].Name != m.Name {
+			if m.Help != "" {
+				fmt.Fprintf(bw, "# HELP %s %s\n", m.Name, escapeHelp(m.Help))
+			}
+			typ := m.Type
+			if typ == "" {
+				typ = Untyped
+			}
+			fmt.Fprintf(bw, "# TYPE %s %s\n", m.Name, typ)
+		}
+		bw.WriteString(m.Name)
+		writeLabels(bw, m.Labels)
+		bw.WriteByte(' ')
+		bw.WriteString(formatValue(m.Value))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func writeLabels(w *bufio.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%s=\"%s\"", name, escapeLabel(labels[name]))
+	}
+	w.WriteByte('}')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
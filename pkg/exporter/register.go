@@ -0,0 +1,232 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults shared with the node agent (scripts/node/dynamic_check.py)
+const (
+	agentConfigPath       = "/etc/aami/agent.yaml"
+	defaultCredentialFile = "/etc/aami/agent-credential"
+)
+
+// RegisterConfig contains how an exporter registers with the Config
+// Server. Unset fields are taken from the node agent's agent.yaml.
+// Exporters embed it as their register setting:
+//
+//	Register exporter.RegisterConfig `yaml:"register"`
+type RegisterConfig struct {
+	Disabled        bool   `yaml:"disabled"`
+	ConfigServerURL string `yaml:"config_server_url"`
+	ResultsURL      string `yaml:"results_url"`     // agent API of 'aami check-results serve', default: config_server_url
+	CredentialFile  string `yaml:"credential_file"` // agent credential, default: /etc/aami/agent-credential
+	Hostname        string `yaml:"hostname"`        // target hostname, default: system hostname
+	Port            int    `yaml:"port"`            // port Prometheus scrapes, default: the listen port
+}
+
+// Errors of Fetch
+var (
+	ErrNotFound = errors.New("HTTP 404")                                // the server has nothing at the path
+	ErrNoServer = errors.New("no results_url or config_server_url set") // neither here nor in agent.yaml
+)
+
+// Registrar registers an exporter as an exporter of its target, of the
+// type service discovery scrapes it as, retrying until the Config Server
+// accepts it.
+type Registrar struct {
+	typ    string
+	cfg    RegisterConfig
+	path   string
+	client *http.Client
+}
+
+// NewRegistrar creates a registrar for an exporter of a type, e.g.
+// "gpu_health", listening on port.
+func NewRegistrar(exporterType string, cfg RegisterConfig, port int, metricsPath string) *Registrar {
+	if cfg.Port == 0 {
+		cfg.Port = port
+	}
+	if metricsPath == "" {
+		metricsPath = DefaultMetricsPath
+	}
+
+	// Fill the rest from the node agent's settings
+	var agent RegisterConfig
+	if data, err := os.ReadFile(agentConfigPath); err == nil {
+		if err := yaml.Unmarshal(data, &agent); err != nil {
+			log.Printf("register: ignoring %s: %v", agentConfigPath, err)
+		}
+	}
+	if cfg.ConfigServerURL == "" {
+		cfg.ConfigServerURL = agent.ConfigServerURL
+	}
+	if cfg.ResultsURL == "" {
+		cfg.ResultsURL = agent.ResultsURL
+	}
+	if cfg.CredentialFile == "" {
+		cfg.CredentialFile = agent.CredentialFile
+	}
+	if cfg.CredentialFile == "" {
+		cfg.CredentialFile = defaultCredentialFile
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname = agent.Hostname
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	cfg.ConfigServerURL = strings.TrimRight(cfg.ConfigServerURL, "/")
+	cfg.ResultsURL = strings.TrimRight(cfg.ResultsURL, "/")
+	if cfg.ResultsURL == "" {
+		cfg.ResultsURL = cfg.ConfigServerURL
+	}
+
+	return &Registrar{typ: exporterType, cfg: cfg, path: metricsPath, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Run registers the exporter, retrying with backoff until it succeeds or
+// ctx ends.
+func (r *Registrar) Run(ctx context.Context) {
+	if r.cfg.ConfigServerURL == "" {
+		log.Printf("register: no config_server_url set here or in %s; not registering", agentConfigPath)
+		return
+	}
+	backoff := 30 * time.Second
+	for {
+		err := r.register(ctx)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("register: %v; retrying in %s", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Minute {
+			backoff = 10 * time.Minute
+		}
+	}
+}
+
+// Hostname returns the hostname of the exporter's target
+func (r *Registrar) Hostname() string {
+	return r.cfg.Hostname
+}
+
+// Fetch reads a path of the agent API (results_url, else the Config
+// Server) with the agent credential into out, e.g.
+// "/api/v1/targets/<hostname>/bmc".
+func (r *Registrar) Fetch(ctx context.Context, path string, out interface{}) error {
+	if r.cfg.ResultsURL == "" {
+		return fmt.Errorf("%w here or in %s", ErrNoServer, agentConfigPath)
+	}
+	return r.do(ctx, http.MethodGet, r.cfg.ResultsURL+path, nil, out)
+}
+
+type registeredExporter struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Port    int    `json:"port"`
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+	// TargetID is only sent on creation
+	TargetID string `json:"target_id,omitempty"`
+}
+
+// register creates the target's exporter of the type, or updates its port
+// and path if registered before
+func (r *Registrar) register(ctx context.Context) error {
+	var target struct {
+		ID string `json:"id"`
+	}
+	if err := r.do(ctx, http.MethodGet, r.cfg.ConfigServerURL+"/api/v1/targets/hostname/"+url.PathEscape(r.cfg.Hostname), nil, &target); err != nil {
+		return fmt.Errorf("look up target %s: %w", r.cfg.Hostname, err)
+	}
+	if target.ID == "" {
+		return fmt.Errorf("target %s is not registered", r.cfg.Hostname)
+	}
+
+	var existing []registeredExporter
+	if err := r.do(ctx, http.MethodGet, r.cfg.ConfigServerURL+"/api/v1/exporters/target/"+url.PathEscape(target.ID), nil, &existing); err != nil {
+		return fmt.Errorf("list exporters: %w", err)
+	}
+	want := registeredExporter{Type: r.typ, Port: r.cfg.Port, Path: r.path, Enabled: true}
+	for _, e := range existing {
+		if e.Type != r.typ {
+			continue
+		}
+		if e.Port == want.Port && e.Path == want.Path && e.Enabled {
+			log.Printf("register: already registered for %s (port %d)", r.cfg.Hostname, want.Port)
+			return nil
+		}
+		if err := r.do(ctx, http.MethodPut, r.cfg.ConfigServerURL+"/api/v1/exporters/"+url.PathEscape(e.ID), want, nil); err != nil {
+			return fmt.Errorf("update exporter %s: %w", e.ID, err)
+		}
+		log.Printf("register: updated registration for %s (port %d)", r.cfg.Hostname, want.Port)
+		return nil
+	}
+
+	want.TargetID = target.ID
+	if err := r.do(ctx, http.MethodPost, r.cfg.ConfigServerURL+"/api/v1/exporters", want, nil); err != nil {
+		return fmt.Errorf("create exporter: %w", err)
+	}
+	log.Printf("register: registered for %s (port %d)", r.cfg.Hostname, want.Port)
+	return nil
+}
+
+// do sends a request with the agent credential, if there is one
+func (r *Registrar) do(ctx context.Context, method, rawURL string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// Re-read on every request: the node agent renews the credential
+	if token, err := os.ReadFile(r.cfg.CredentialFile); err == nil && len(bytes.TrimSpace(token)) > 0 {
+		req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(string(msg)))
+		}
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -36,6 +36,13 @@ DEFAULT_PORT="9100"
 DEFAULT_DCGM_PORT="9400"
 DEFAULT_ALL_SMI_PORT="9401"
 
+# Agent credential returned on registration, scoped to this node
+readonly AGENT_CREDENTIAL_FILE="/etc/aami/agent-credential"
+
+# Client certificate for mutual TLS, its key and the agent CA, returned on
+# registration when the Config Server issues them
+readonly AGENT_CERT_DIR="/etc/aami"
+
 # Colors
 RED='\033[0;31m'
 GREEN='\033[0;32m'
@@ -123,6 +130,59 @@ print_verbose() {
     fi
 }
 
+# Save the agent credential from a registration response (mode 0600). The
+# agent renews it on its own; without one it runs unauthenticated.
+save_agent_credential() {
+    local body=$1
+    local token
+    token=$(echo "$body" | grep -o '"credential" *: *{ *"token" *: *"[^"]*"' | head -1 | sed 's/.*"\([^"]*\)"$/\1/')
+
+    if [[ -z "$token" ]]; then
+        print_substep "warn" "No agent credential in the registration response"
+        return 0
+    fi
+
+    mkdir -p "$(dirname "$AGENT_CREDENTIAL_FILE")"
+    if (umask 077 && echo "$token" > "${AGENT_CREDENTIAL_FILE}.tmp") \
+        && mv "${AGENT_CREDENTIAL_FILE}.tmp" "$AGENT_CREDENTIAL_FILE"; then
+        print_substep "ok" "Agent credential saved to ${AGENT_CREDENTIAL_FILE}"
+    else
+        print_substep "warn" "Could not save the agent credential to ${AGENT_CREDENTIAL_FILE}"
+    fi
+}
+
+# Save the agent's client certificate, key (mode 0600) and CA from a
+# registration response. PEM in JSON needs a JSON parser: python3 is used.
+save_agent_certificate() {
+    local body=$1
+
+    if ! echo "$body" | grep -q '"certificate" *: *{'; then
+        return 0
+    fi
+    if ! command -v python3 &>/dev/null; then
+        print_substep "warn" "python3 not found; could not save the client certificate"
+        return 0
+    fi
+
+    if echo "$body" | (umask 077 && python3 -c '
+import json, os, sys
+cert = json.load(sys.stdin)["certificate"]
+out = sys.argv[1]
+for name, key, mode in (("agent.key", "private_key", 0o600), ("agent.crt", "certificate", 0o644), ("ca.crt", "ca_certificate", 0o644)):
+    if not cert.get(key):
+        continue
+    path = os.path.join(out, name)
+    with open(path + ".tmp", "w") as f:
+        f.write(cert[key])
+    os.chmod(path + ".tmp", mode)
+    os.replace(path + ".tmp", path)
+' "$AGENT_CERT_DIR") 2>/dev/null; then
+        print_substep "ok" "Client certificate saved to ${AGENT_CERT_DIR}/agent.crt"
+    else
+        print_substep "warn" "Could not save the client certificate to ${AGENT_CERT_DIR}"
+    fi
+}
+
 # Check if running as root
 check_root() {
     if [[ $EUID -ne 0 ]]; then
@@ -552,7 +612,18 @@ install_dynamic_check() {
     print_step 6 8 "Installing dynamic check..."
 
     if [[ "$DRY_RUN" == true ]]; then
-        print_substep "info" "[DRY-RUN] Would install dynamic check script and cron job"
+        print_substep "info" "[DRY-RUN] Would install dynamic check script to /opt/aami/scripts"
+        print_substep "info" "[DRY-RUN] Would register cron job /etc/cron.d/aami-dynamic-check (1-minute interval)"
+
+        # Let the agent plan its checks without executing them
+        local plan_dir
+        plan_dir="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+        if command -v python3 &>/dev/null && [[ -f "${plan_dir}/dynamic_check.py" ]]; then
+            print_substep "info" "[DRY-RUN] Check plan from Config Server:"
+            python3 "${plan_dir}/dynamic_check.py" --dry-run \
+                --config-server "${CONFIG_SERVER}" \
+                --hostname "${DETECTED_HOSTNAME}" 2>&1 | sed 's/^/         /' || true
+        fi
         return 0
     fi
 
@@ -641,6 +712,8 @@ register_with_server() {
         print_substep "info" "  Hostname: ${DETECTED_HOSTNAME}"
         print_substep "info" "  IP: ${DETECTED_IP}"
         print_substep "info" "  Labels: ${LABELS[*]:-none}"
+        print_substep "info" "[DRY-RUN] Would save the agent credential to ${AGENT_CREDENTIAL_FILE}"
+        print_substep "info" "[DRY-RUN] Would save a client certificate, if issued, to ${AGENT_CERT_DIR}/agent.crt"
         return 0
     fi
 
@@ -701,6 +774,8 @@ register_with_server() {
         if [[ -n "$REGISTERED_TARGET_ID" ]]; then
             print_substep "info" "Target ID: ${REGISTERED_TARGET_ID}"
         fi
+        save_agent_credential "$body"
+        save_agent_certificate "$body"
         return 0
     else
         print_substep "fail" "Registration failed (HTTP ${http_code})"
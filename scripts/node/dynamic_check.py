@@ -4,34 +4,86 @@ Dynamic Check Runner for AAMI Monitoring
 
 This script fetches effective checks from the AAMI Config Server,
 executes them, and outputs results to Node Exporter's textfile collector.
+Tasks queued for the node (diagnostics, check-now, exporter restart, config
+refresh) are received on the heartbeat response and run first. With --watch
+the agent keeps running and polls every tick instead of being started by a
+timer. The outcome of each check run is reported to the check result API
+(POST /api/v1/check-results); results the server could not take are kept
+and sent with the next run. While the Config Server is unreachable the agent
+runs the checks it last fetched, spools check results and missed heartbeats
+to /var/lib/aami/spool, and replays them once it is back, retrying with
+backoff meanwhile. The node's hardware inventory (CPU, memory, GPUs, NIC and
+InfiniBand adapters, kernel and driver versions) is collected hourly and
+posted to /api/v1/targets/<hostname>/inventory when it changed, or daily.
+A diagnostics task may upload its bundle to
+/api/v1/targets/<hostname>/debug-bundles for a support case, and a
+gpu-validate task runs the GPU burn-in suite (dcgmi diag, bandwidthTest,
+NCCL all-reduce) and puts its progress to
+/api/v1/targets/<hostname>/gpu-validations/<task-id>.
+Checks whose config sets "report" to "textfile"
+or "both" also get their latest result written as metrics
+(aami_check_status, aami_check_duration_seconds) to aami_check_results.prom.
+
+Requests to the Config Server carry the agent credential issued at
+registration (/etc/aami/agent-credential). The credential is scoped to this
+node and short-lived; the agent renews it when a third of its lifetime is
+left. A client certificate issued at registration (agent.crt and agent.key
+next to the credential, with the agent CA in ca.crt) is presented for
+mutual TLS and renewed the same way.
 
 Usage:
     ./dynamic_check.py [OPTIONS]
+    ./dynamic_check.py config validate|migrate [-f FILE]
 
 Options:
-    -c, --config-server URL  Config Server URL (default: from /etc/aami/config)
+    -c, --config-server URL  Config Server URL (default: from /etc/aami/agent.yaml)
+    --results-url URL        Check result API URL (default: the Config Server URL)
+    --credential-file FILE   Agent credential (default: /etc/aami/agent-credential)
     -h, --hostname NAME      Override hostname (default: system hostname)
     -d, --debug              Enable debug logging
+    -n, --dry-run            Fetch and plan checks, print the plan, execute nothing
+    -w, --watch              Run continuously, polling every tick
     --help                   Show this help message
 
+Settings come from command-line flags, then environment variables, then
+/etc/aami/agent.yaml. Older agent.yaml versions and the legacy
+/etc/aami/config file are migrated to the current version on startup.
+
 Environment Variables:
     AAMI_CONFIG_SERVER_URL   - Config Server URL
+    AAMI_RESULTS_URL         - Check result API URL (default: Config Server URL)
+    AAMI_CREDENTIAL_FILE     - Agent credential (default: /etc/aami/agent-credential)
     AAMI_HOSTNAME            - Override hostname
     AAMI_DEBUG               - Enable debug logging (1=on, 0=off)
+    AAMI_NICE                - CPU nice level for agent and checks (default: 10)
+    AAMI_IONICE_CLASS        - I/O scheduling class: idle, best-effort (default: idle)
+    AAMI_CPU_QUOTA           - cgroup CPU quota per check, e.g. 20% (default: none)
+    AAMI_MEMORY_MAX          - cgroup memory limit per check, e.g. 256M (default: none)
+    AAMI_WATCH               - Run in watch mode (1=on, 0=off)
 """
 
 import argparse
+import base64
+import hashlib
+import io
 import json
 import logging
 import os
+import re
+import resource
+import shutil
 import socket
+import ssl
 import subprocess
 import sys
+import tarfile
+import tempfile
 import time
 import urllib.error
+import urllib.parse
 import urllib.request
-from dataclasses import dataclass
-from datetime import datetime
+from dataclasses import asdict, dataclass
+from datetime import datetime, timedelta
 from pathlib import Path
 from typing import Optional
 
@@ -41,7 +93,163 @@ VERSION = "1.0.0"
 DEFAULT_TEXTFILE_DIR = "/var/lib/node_exporter/textfile_collector"
 DEFAULT_CHECK_SCRIPTS_DIR = "/usr/local/lib/aami/checks"
 DEFAULT_CONFIG_FILE = "/etc/aami/config"
+DEFAULT_AGENT_CONFIG_FILE = "/etc/aami/agent.yaml"
 DEFAULT_LOG_FILE = "/var/log/aami/dynamic-check.log"
+DEFAULT_STATE_FILE = "/var/lib/aami/dynamic-check-state.json"
+DEFAULT_DIAGNOSTICS_DIR = "/var/lib/aami/diagnostics"
+DEFAULT_CREDENTIAL_FILE = "/etc/aami/agent-credential"
+
+# Renew the agent credential once less than this share of its lifetime is left
+CREDENTIAL_RENEW_FRACTION = 1 / 3
+
+# Client certificate for mutual TLS, its key and the agent CA, kept next to
+# the credential file
+AGENT_CERT_NAME = "agent.crt"
+AGENT_KEY_NAME = "agent.key"
+AGENT_CA_NAME = "ca.crt"
+
+# Agent tick: cron/systemd invoke the runner once per minute
+TICK_SECONDS = 60
+
+# Resource defaults: monitoring must never compete with training jobs
+DEFAULT_NICE = 10
+DEFAULT_IONICE_CLASS = "idle"
+IONICE_CLASSES = {"best-effort": 2, "idle": 3}
+
+# Local exporters probed in dry-run mode (name, port)
+KNOWN_EXPORTERS = [
+    ("node_exporter", 9100),
+    ("dcgm-exporter", 9400),
+    ("all-smi", 9401),
+]
+
+# Tasks the server may queue for this agent, delivered on heartbeat responses
+TASK_DIAGNOSTICS = "diagnostics"
+TASK_CHECK_NOW = "check-now"
+TASK_RESTART_EXPORTER = "restart-exporter"
+TASK_CONFIG_REFRESH = "config-refresh"
+TASK_GPU_VALIDATE = "gpu-validate"
+TASK_TYPES = (TASK_DIAGNOSTICS, TASK_CHECK_NOW, TASK_RESTART_EXPORTER, TASK_CONFIG_REFRESH, TASK_GPU_VALIDATE)
+
+# Commands collected by a diagnostics task (label, command)
+DIAGNOSTIC_COMMANDS = [
+    ("uptime", ["uptime"]),
+    ("nvidia-smi", ["nvidia-smi", "-q"]),
+    ("dmesg", ["dmesg", "--ctime", "--level=err,warn"]),
+    ("df", ["df", "-h"]),
+    ("free", ["free", "-m"]),
+    ("ibstat", ["ibstat"]),
+    ("ip-addr", ["ip", "-br", "addr"]),
+    ("exporters", ["systemctl", "status", "--no-pager", "node_exporter", "dcgm-exporter", "all-smi"]),
+    ("exporter-logs", ["journalctl", "--no-pager", "--since", "-24h",
+                       "-u", "node_exporter", "-u", "dcgm-exporter", "-u", "all-smi"]),
+]
+
+# nvidia-bug-report.sh, added to a diagnostic bundle when the task asks for
+# it (aami node collect-debug); it takes minutes on large nodes
+NVIDIA_BUG_REPORT = "nvidia-bug-report.sh"
+NVIDIA_BUG_REPORT_TIMEOUT = 900
+
+# Tail of the agent log put in a diagnostic bundle, and how many bundles
+# are kept on the node
+DIAGNOSTIC_LOG_TAIL = 1 << 20
+MAX_DIAGNOSTIC_BUNDLES = 10
+
+# GPU validation (gpu-validate task): the tool each step runs, the suite
+# without steps in the task, and how much of a tool's output goes in the report
+GPU_VALIDATION_TOOLS = {
+    "dcgm-diag": "dcgmi",
+    "bandwidth": "bandwidthTest",
+    "nccl-allreduce": "all_reduce_perf",
+}
+GPU_VALIDATION_DEFAULT_STEPS = [{"name": "dcgm-diag", "level": 3}, {"name": "bandwidth"}, {"name": "nccl-allreduce"}]
+GPU_VALIDATION_STEP_TIMEOUT = 1800
+GPU_VALIDATION_OUTPUT_TAIL = 4096
+
+# Completed task IDs remembered so redelivered tasks are not run twice
+MAX_COMPLETED_TASKS = 200
+
+# Heartbeats per run while the server reports more tasks pending
+MAX_HEARTBEAT_ROUNDS = 5
+
+# Records spooled to disk while the server is unreachable, oldest dropped
+# first: check results (a week of 10 checks every 10 minutes) and missed
+# heartbeats (a day of ticks)
+MAX_SPOOLED_RESULTS = 10000
+MAX_SPOOLED_HEARTBEATS = 1440
+
+# Spooled check results sent per request when replaying
+RESULT_BATCH_SIZE = 500
+
+# Hardware inventory: collected on the first run and hourly after, and
+# reported when it changed or daily
+INVENTORY_COLLECT_INTERVAL = 3600
+INVENTORY_REPORT_INTERVAL = 86400
+
+# nvidia-smi fields of the GPU inventory, in order
+INVENTORY_GPU_FIELDS = "index,name,uuid,serial,vbios_version,memory.total,driver_version"
+
+# Offline mode: while the Config Server is unreachable, runs use the last
+# fetched checks and only try to reach it again after a backoff that doubles
+# per failed attempt. Runs started within OFFLINE_RETRY_SLACK seconds of the
+# next attempt make it, so timer drift does not push it back a whole tick.
+OFFLINE_BACKOFF_MIN = TICK_SECONDS
+OFFLINE_BACKOFF_MAX = 900
+OFFLINE_RETRY_SLACK = 5
+
+# Output and error of a check kept in its reported result
+MAX_RESULT_OUTPUT = 4096
+
+# Where a check's results go, from the "report" key of its config: the check
+# result API, node_exporter's textfile collector, or both
+REPORT_API = "api"
+REPORT_TEXTFILE = "textfile"
+REPORT_BOTH = "both"
+REPORT_MODES = (REPORT_API, REPORT_TEXTFILE, REPORT_BOTH)
+
+# Check statuses, as reported to the check result API
+CHECK_STATUSES = ("passed", "failed", "timeout", "error")
+
+# Seconds a check script may run, unless its "timeout" config key says otherwise
+DEFAULT_CHECK_TIMEOUT = 30
+
+# agent.yaml schema version written by this agent. Version 0 is the legacy
+# KEY=VALUE file at /etc/aami/config.
+AGENT_CONFIG_VERSION = 1
+
+# agent.yaml keys and their types
+AGENT_CONFIG_FIELDS = {
+    "config_server_url": str,
+    "results_url": str,
+    "credential_file": str,
+    "hostname": str,
+    "debug": bool,
+    "textfile_dir": str,
+    "check_scripts_dir": str,
+    "state_file": str,
+    "nice": int,
+    "ionice_class": str,
+    "cpu_quota": str,
+    "memory_max": str,
+    "watch": bool,
+}
+
+# Legacy /etc/aami/config variables and the agent.yaml keys they became
+LEGACY_CONFIG_KEYS = {
+    "AAMI_CONFIG_SERVER_URL": "config_server_url",
+    "AAMI_RESULTS_URL": "results_url",
+    "AAMI_CREDENTIAL_FILE": "credential_file",
+    "AAMI_HOSTNAME": "hostname",
+    "AAMI_DEBUG": "debug",
+    "TEXTFILE_DIR": "textfile_dir",
+    "CHECK_SCRIPTS_DIR": "check_scripts_dir",
+    "AAMI_STATE_FILE": "state_file",
+    "AAMI_NICE": "nice",
+    "AAMI_IONICE_CLASS": "ionice_class",
+    "AAMI_CPU_QUOTA": "cpu_quota",
+    "AAMI_MEMORY_MAX": "memory_max",
+    "AAMI_WATCH": "watch",
+}
 
 
 @dataclass
@@ -53,6 +261,96 @@ class CheckInfo:
     config: dict
 
 
+@dataclass
+class Task:
+    """A task queued for this agent by the Config Server."""
+    id: str
+    type: str
+    priority: int = 0
+    expires_at: Optional[float] = None
+    args: Optional[dict] = None
+
+    @classmethod
+    def from_response(cls, item: dict) -> "Task":
+        return cls(
+            id=str(item["id"]),
+            type=str(item["type"]),
+            priority=int(item.get("priority", 0)),
+            expires_at=float(item["expires_at"]) if item.get("expires_at") is not None else None,
+            args=item.get("args") or {},
+        )
+
+    def expired(self, now: float) -> bool:
+        return self.expires_at is not None and now >= self.expires_at
+
+
+@dataclass
+class Heartbeat:
+    """Heartbeat response: queued tasks and the current effective-config hash."""
+    tasks: list[Task]
+    config_hash: str = ""
+    pending_tasks: int = 0
+
+
+class Spool:
+    """Records waiting to be delivered, one JSON object per line on disk.
+
+    Records are appended as they are made, so they survive the agent dying
+    before it could send them. Beyond the limit the oldest are dropped. A
+    line torn by a crash mid-write is skipped.
+    """
+
+    def __init__(self, path: Path, limit: int, logger: logging.Logger) -> None:
+        self.path = path
+        self.limit = limit
+        self.logger = logger
+        self._count: Optional[int] = None
+
+    def __len__(self) -> int:
+        if self._count is None:
+            self._count = len(self.read())
+        return self._count
+
+    def read(self, limit: Optional[int] = None) -> list[dict]:
+        """Return the oldest records, up to limit."""
+        records = []
+        try:
+            with open(self.path) as f:
+                for line in f:
+                    try:
+                        records.append(json.loads(line))
+                    except json.JSONDecodeError:
+                        continue
+                    if limit is not None and len(records) >= limit:
+                        break
+        except FileNotFoundError:
+            pass
+        except OSError as e:
+            self.logger.warning(f"Could not read spool {self.path}: {e}")
+        return records
+
+    def append(self, record: dict) -> None:
+        self.path.parent.mkdir(parents=True, exist_ok=True)
+        count = len(self)
+        with open(self.path, "a") as f:
+            f.write(json.dumps(record, sort_keys=True) + "\n")
+        self._count = count + 1
+        if self._count > self.limit:
+            dropped = self._count - self.limit
+            self.logger.warning(f"Spool {self.path.name} is full, dropping the {dropped} oldest record(s)")
+            self.drop(dropped)
+
+    def drop(self, count: int) -> None:
+        """Remove the oldest count records, once they are delivered."""
+        if count <= 0:
+            return
+        records = self.read()[count:]
+        temp_file = self.path.with_suffix(".tmp")
+        temp_file.write_text("".join(json.dumps(r, sort_keys=True) + "\n" for r in records))
+        temp_file.rename(self.path)
+        self._count = len(records)
+
+
 @dataclass
 class CheckResult:
     """Result of executing a check."""
@@ -60,6 +358,676 @@ class CheckResult:
     success: bool
     output: str
     error: Optional[str] = None
+    status: str = ""  # passed, failed, timeout or error
+    exit_code: Optional[int] = None
+    duration_ms: int = 0
+
+
+def credential_claims(token: str) -> Optional[dict]:
+    """Return the claims of an agent credential (a JWT), unverified.
+
+    The agent only reads the lifetime to know when to renew; the server
+    verifies the signature.
+    """
+    try:
+        payload = token.split(".")[1]
+        payload += "=" * (-len(payload) % 4)
+        claims = json.loads(base64.urlsafe_b64decode(payload))
+    except (IndexError, ValueError):
+        return None
+    if not isinstance(claims, dict) or not all(isinstance(claims.get(k), int) for k in ("iat", "exp")):
+        return None
+    return claims
+
+
+def parse_duration(value) -> int:
+    """Parse a duration like 30, "30s", "5m", "2h" or "1d" into seconds."""
+    if isinstance(value, (int, float)):
+        return int(value)
+    text = str(value).strip().lower()
+    units = {"s": 1, "m": 60, "h": 3600, "d": 86400}
+    if text and text[-1] in units:
+        return int(float(text[:-1]) * units[text[-1]])
+    return int(float(text))
+
+
+def _parse_scalar(text: str):
+    """Parse a YAML scalar: quoted string, true/false, integer, or plain string."""
+    if len(text) >= 2 and text[0] == text[-1] and text[0] in "\"'":
+        return json.loads(text) if text[0] == '"' else text[1:-1].replace("''", "'")
+    text = text.split(" #", 1)[0].strip()
+    if text.lower() in ("true", "false"):
+        return text.lower() == "true"
+    if re.fullmatch(r"-?\d+", text):
+        return int(text)
+    return text
+
+
+def parse_agent_yaml(text: str) -> dict:
+    """Parse agent.yaml, which uses flat "key: value" YAML only."""
+    config = {}
+    for lineno, line in enumerate(text.splitlines(), 1):
+        stripped = line.strip()
+        if not stripped or stripped.startswith("#"):
+            continue
+        if line[0].isspace() or ":" not in stripped:
+            raise ValueError(f"line {lineno}: expected 'key: value'")
+        key, value = stripped.split(":", 1)
+        config[key.strip()] = _parse_scalar(value.strip())
+    return config
+
+
+def parse_legacy_config(text: str) -> dict:
+    """Parse the legacy shell-style KEY=VALUE /etc/aami/config file."""
+    config = {}
+    for line in text.splitlines():
+        line = line.strip()
+        if not line or line.startswith("#") or "=" not in line:
+            continue
+        key, value = line.split("=", 1)
+        config[key.strip().removeprefix("export ").strip()] = value.strip().strip('"').strip("'")
+    return config
+
+
+def _migrate_v0(legacy: dict) -> dict:
+    """Version 0 (legacy /etc/aami/config) to version 1."""
+    config = {}
+    for env_key, key in LEGACY_CONFIG_KEYS.items():
+        value = legacy.get(env_key, "")
+        if value == "":
+            continue
+        if AGENT_CONFIG_FIELDS[key] is bool:
+            value = value.lower() in ("1", "true", "yes", "on")
+        elif AGENT_CONFIG_FIELDS[key] is int:
+            value = int(value)
+        config[key] = value
+    return config
+
+
+# AGENT_CONFIG_MIGRATIONS[n] turns a version n config into version n+1
+AGENT_CONFIG_MIGRATIONS = {
+    0: _migrate_v0,
+}
+
+
+def migrate_agent_config(raw: dict, version: int) -> dict:
+    """Migrate a config from the given version to AGENT_CONFIG_VERSION."""
+    if version > AGENT_CONFIG_VERSION:
+        raise ValueError(
+            f"config version {version} is newer than this agent supports ({AGENT_CONFIG_VERSION}); upgrade the agent"
+        )
+    config = {k: v for k, v in raw.items() if k != "version"}
+    while version < AGENT_CONFIG_VERSION:
+        config = AGENT_CONFIG_MIGRATIONS[version](config)
+        version += 1
+    return {"version": AGENT_CONFIG_VERSION, **config}
+
+
+def validate_agent_config(config: dict) -> list[str]:
+    """Return the problems found in a current-version config."""
+    errors = []
+    if config.get("version") != AGENT_CONFIG_VERSION:
+        errors.append(f"version: expected {AGENT_CONFIG_VERSION}, got {config.get('version')!r}")
+    for key, value in config.items():
+        if key == "version":
+            continue
+        expected = AGENT_CONFIG_FIELDS.get(key)
+        if expected is None:
+            errors.append(f"{key}: unknown setting")
+        elif type(value) is not expected:
+            errors.append(f"{key}: expected {expected.__name__}, got {value!r}")
+
+    for key in ("config_server_url", "results_url"):
+        url = config.get(key)
+        if isinstance(url, str) and url and not url.startswith(("http://", "https://")):
+            errors.append(f"{key}: must start with http:// or https://, got {url!r}")
+    ionice_class = config.get("ionice_class")
+    if isinstance(ionice_class, str) and ionice_class not in IONICE_CLASSES:
+        errors.append(f"ionice_class: must be one of {', '.join(sorted(IONICE_CLASSES))}, got {ionice_class!r}")
+    nice = config.get("nice")
+    if type(nice) is int and not -20 <= nice <= 19:
+        errors.append(f"nice: must be between -20 and 19, got {nice}")
+    return errors
+
+
+def render_agent_yaml(config: dict) -> str:
+    """Render a config as agent.yaml."""
+    lines = [
+        "# AAMI node agent configuration",
+        "# Check with: dynamic_check.py config validate",
+        f"version: {config['version']}",
+    ]
+    for key in AGENT_CONFIG_FIELDS:
+        if key not in config:
+            continue
+        value = config[key]
+        if isinstance(value, bool):
+            lines.append(f"{key}: {'true' if value else 'false'}")
+        elif isinstance(value, int):
+            lines.append(f"{key}: {value}")
+        else:
+            lines.append(f"{key}: {json.dumps(value)}")
+    return "\n".join(lines) + "\n"
+
+
+def read_agent_config(path: Path, legacy_path: Path) -> tuple[dict, int, Optional[Path]]:
+    """Read agent.yaml, or the legacy file if agent.yaml does not exist.
+
+    Returns the raw config, its version, and the file it came from (None if
+    neither exists). An agent.yaml without a version is version 1.
+    """
+    if path.exists():
+        raw = parse_agent_yaml(path.read_text())
+        version = raw.get("version", 1)
+        if type(version) is not int:
+            raise ValueError(f"version: expected int, got {version!r}")
+        return raw, version, path
+    if legacy_path.exists():
+        return parse_legacy_config(legacy_path.read_text()), 0, legacy_path
+    return {}, AGENT_CONFIG_VERSION, None
+
+
+def write_agent_config(path: Path, config: dict, old_version: Optional[int] = None) -> Optional[Path]:
+    """Atomically write agent.yaml, keeping an existing file as <name>.v<old_version>.bak."""
+    path.parent.mkdir(parents=True, exist_ok=True)
+    backup = None
+    if path.exists() and old_version is not None:
+        backup = path.with_name(f"{path.name}.v{old_version}.bak")
+        shutil.copy2(path, backup)
+    temp_file = path.with_suffix(".tmp")
+    temp_file.write_text(render_agent_yaml(config))
+    temp_file.rename(path)
+    return backup
+
+
+def load_agent_config(path: Path, legacy_path: Path, write: bool = True) -> dict:
+    """Load the agent config, migrating older versions to the current one.
+
+    A migrated config is written back (unless write is False) so the
+    migration runs once per node. The legacy file is left in place for
+    dynamic-check.sh.
+    """
+    raw, version, source = read_agent_config(path, legacy_path)
+    if source is None:
+        return {}
+    config = migrate_agent_config(raw, version)
+    errors = validate_agent_config(config)
+    if errors:
+        raise ValueError(f"{source}: " + "; ".join(errors))
+    if version < AGENT_CONFIG_VERSION and write:
+        write_agent_config(path, config, version if source == path else None)
+        print(f"Migrated agent config from {source} (version {version}) to {path} "
+              f"(version {AGENT_CONFIG_VERSION})", file=sys.stderr)
+    return config
+
+
+class CronExpression:
+    """Standard 5-field cron expression (minute hour day-of-month month day-of-week)."""
+
+    FIELD_RANGES = [(0, 59), (0, 23), (1, 31), (1, 12), (0, 7)]
+
+    def __init__(self, expr: str) -> None:
+        fields = expr.split()
+        if len(fields) != 5:
+            raise ValueError(f"cron expression needs 5 fields: {expr!r}")
+        self.expr = expr
+        parsed = [self._parse_field(f, lo, hi) for f, (lo, hi) in zip(fields, self.FIELD_RANGES)]
+        self.minutes, self.hours, self.days, self.months, self.weekdays = parsed
+        if 7 in self.weekdays:
+            self.weekdays.add(0)
+        # Cron ORs day-of-month and day-of-week when both are restricted
+        self.dom_any = fields[2] == "*"
+        self.dow_any = fields[4] == "*"
+
+    @staticmethod
+    def _parse_field(field: str, lo: int, hi: int) -> set[int]:
+        values: set[int] = set()
+        for part in field.split(","):
+            step = 1
+            if "/" in part:
+                part, step_text = part.split("/", 1)
+                step = int(step_text)
+            if part == "*":
+                start, end = lo, hi
+            elif "-" in part:
+                start_text, end_text = part.split("-", 1)
+                start, end = int(start_text), int(end_text)
+            else:
+                start = int(part)
+                end = hi if step > 1 else start
+            if start < lo or end > hi or start > end or step < 1:
+                raise ValueError(f"cron field out of range: {field!r}")
+            values.update(range(start, end + 1, step))
+        return values
+
+    def matches(self, t: datetime) -> bool:
+        return t.minute in self.minutes and t.hour in self.hours and self._day_matches(t)
+
+    def _day_matches(self, t: datetime) -> bool:
+        if t.month not in self.months:
+            return False
+        dom = t.day in self.days
+        dow = (t.isoweekday() % 7) in self.weekdays
+        if self.dom_any or self.dow_any:
+            return dom and dow
+        return dom or dow
+
+    def next_after(self, t: datetime) -> Optional[datetime]:
+        """Return the first matching minute strictly after t.
+
+        Searches field by field rather than minute by minute: months and days
+        that do not match are skipped whole, and the hour and minute are the
+        first values of their fields not before t. Returns None if nothing
+        matches within five years (e.g. "0 0 30 2 *").
+        """
+        start = t.replace(second=0, microsecond=0) + timedelta(minutes=1)
+        day = start.replace(hour=0, minute=0)
+        for _ in range(5 * 366):
+            if day.month not in self.months:
+                day = (day.replace(day=1) + timedelta(days=32)).replace(day=1)
+                continue
+            if self._day_matches(day):
+                found = self._first_time(day, start if day.date() == start.date() else day)
+                if found is not None:
+                    return found
+            day += timedelta(days=1)
+        return None
+
+    def _first_time(self, day: datetime, after: datetime) -> Optional[datetime]:
+        """Return the first matching hour and minute of day not before after."""
+        for hour in sorted(h for h in self.hours if h >= after.hour):
+            first_minute = after.minute if hour == after.hour else 0
+            minutes = [m for m in self.minutes if m >= first_minute]
+            if minutes:
+                return day.replace(hour=hour, minute=min(minutes))
+        return None
+
+
+@dataclass
+class CheckSchedule:
+    """When a check runs, read from the "schedule" key of the check config.
+
+    Example config:
+        {"schedule": {"cron": "0 2 * * *", "jitter": "10m", "windows": ["01:00-05:00"]}}
+        {"schedule": {"interval": "5m", "jitter": "30s"}}
+    Without a schedule the check runs on every agent tick.
+    """
+    interval: int = 0
+    cron: Optional[CronExpression] = None
+    jitter: int = 0
+    windows: tuple = ()
+
+    @classmethod
+    def from_config(cls, config: dict) -> "CheckSchedule":
+        raw = config.get("schedule") or {}
+        if isinstance(raw, str):
+            # Shorthand: a bare string is a cron expression or an interval
+            raw = {"cron": raw} if len(raw.split()) == 5 else {"interval": raw}
+        schedule = cls(
+            interval=parse_duration(raw["interval"]) if raw.get("interval") else 0,
+            cron=CronExpression(raw["cron"]) if raw.get("cron") else None,
+            jitter=parse_duration(raw["jitter"]) if raw.get("jitter") else 0,
+        )
+        windows = []
+        for window in raw.get("windows") or []:
+            start_text, end_text = window.split("-", 1)
+            windows.append((cls._parse_clock(start_text), cls._parse_clock(end_text)))
+        schedule.windows = tuple(windows)
+        return schedule
+
+    @staticmethod
+    def _parse_clock(text: str) -> int:
+        hour, minute = text.strip().split(":", 1)
+        return int(hour) * 60 + int(minute)
+
+    def describe(self) -> str:
+        if self.cron:
+            base = f"cron '{self.cron.expr}'"
+        elif self.interval:
+            base = f"every {self.interval}s"
+        else:
+            base = f"every tick ({TICK_SECONDS}s)"
+        if self.jitter:
+            base += f", jitter {self.jitter}s"
+        if self.windows:
+            spans = ", ".join(f"{a // 60:02d}:{a % 60:02d}-{b // 60:02d}:{b % 60:02d}" for a, b in self.windows)
+            base += f", windows {spans}"
+        return base
+
+    def jitter_offset(self, hostname: str, check_name: str) -> int:
+        """Stable per-host offset so nodes do not all run heavy checks at once."""
+        if not self.jitter:
+            return 0
+        digest = hashlib.sha256(f"{hostname}/{check_name}".encode()).hexdigest()
+        return int(digest[:8], 16) % (self.jitter + 1)
+
+    def in_window(self, t: datetime) -> bool:
+        if not self.windows:
+            return True
+        minute = t.hour * 60 + t.minute
+        for start, end in self.windows:
+            if start <= end and start <= minute < end:
+                return True
+            if start > end and (minute >= start or minute < end):
+                return True
+        return False
+
+    def next_run(self, last_run: Optional[float], now: float, offset: int) -> float:
+        """Return the earliest time the check may run, ignoring windows."""
+        if self.cron:
+            # A new check waits for its next cron slot instead of running at once
+            base = last_run if last_run is not None else now - TICK_SECONDS
+            slot = self.cron.next_after(datetime.fromtimestamp(base - offset))
+            return slot.timestamp() + offset if slot else float("inf")
+        if last_run is None:
+            return now
+        if not self.interval:
+            return last_run + TICK_SECONDS
+        # Align runs to interval slots shifted by the jitter offset
+        slot = int((last_run - offset) // self.interval) + 1
+        return slot * self.interval + offset
+
+    def is_due(self, last_run: Optional[float], now: float, offset: int) -> bool:
+        if not self.in_window(datetime.fromtimestamp(now)):
+            return False
+        # Allow half a tick of slack so timer drift never skips a run
+        return self.next_run(last_run, now, offset) <= now + TICK_SECONDS // 2
+
+
+@dataclass
+class Escalation:
+    """Faster re-runs after a failure, read from the "escalation" config key.
+
+    Example config:
+        {"escalation": {"interval": "1m", "recover_after": 3}}
+    After a failure the check runs every `interval` until it passes
+    `recover_after` times in a row, then returns to its normal schedule.
+    """
+    interval: int = TICK_SECONDS
+    recover_after: int = 3
+
+    @classmethod
+    def from_config(cls, config: dict) -> Optional["Escalation"]:
+        raw = config.get("escalation")
+        if not raw:
+            return None
+        if raw is True:
+            return cls()
+        escalation = cls(
+            interval=parse_duration(raw.get("interval", TICK_SECONDS)),
+            recover_after=int(raw.get("recover_after", 3)),
+        )
+        if escalation.interval < 1 or escalation.recover_after < 1:
+            raise ValueError("escalation interval and recover_after must be positive")
+        return escalation
+
+    def schedule(self, base: CheckSchedule) -> CheckSchedule:
+        """Return the schedule used while escalated (windows still apply)."""
+        return CheckSchedule(interval=self.interval, windows=base.windows)
+
+
+def report_mode(config: dict) -> str:
+    """Return where a check's results go, from the "report" config key.
+
+    Example config:
+        {"report": "textfile"}
+    "api" (default) posts results to the check result API, "textfile" writes
+    them to aami_check_results.prom for node_exporter, "both" does both.
+    """
+    mode = config.get("report") or REPORT_API
+    if mode not in REPORT_MODES:
+        raise ValueError(f"report must be one of {', '.join(REPORT_MODES)}, not {mode!r}")
+    return mode
+
+
+def check_timeout(config: dict) -> int:
+    """Return how long a check may run in seconds, from the "timeout" config key.
+
+    Example config:
+        {"timeout": "2m"}
+    """
+    raw = config.get("timeout")
+    if raw is None:
+        return DEFAULT_CHECK_TIMEOUT
+    timeout = parse_duration(raw)
+    if timeout <= 0:
+        raise ValueError(f"timeout must be positive, not {raw!r}")
+    return timeout
+
+
+def check_dependencies(check: CheckInfo) -> list[str]:
+    """Return the names of checks this check depends on ("depends_on" config key)."""
+    deps = check.config.get("depends_on") or []
+    if isinstance(deps, str):
+        deps = [deps]
+    return [str(dep) for dep in deps]
+
+
+def order_checks(checks: list[CheckInfo]) -> tuple[list[CheckInfo], set[str]]:
+    """Order checks so dependencies run first.
+
+    Returns the ordered checks and the names of checks caught in a dependency
+    cycle, which are left out of the order.
+    """
+    by_name = {check.name: check for check in checks}
+    ordered: list[CheckInfo] = []
+    visiting: set[str] = set()
+    done: set[str] = set()
+    cyclic: set[str] = set()
+
+    def visit(name: str, path: list[str]) -> None:
+        if name in done or name in cyclic or name not in by_name:
+            return
+        if name in visiting:
+            cyclic.update(path[path.index(name):])
+            return
+        visiting.add(name)
+        for dep in check_dependencies(by_name[name]):
+            visit(dep, path + [dep])
+        visiting.discard(name)
+        if name not in cyclic:
+            done.add(name)
+            ordered.append(by_name[name])
+
+    for check in checks:
+        visit(check.name, [check.name])
+
+    return ordered, cyclic
+
+
+def resource_usage() -> dict:
+    """Return the CPU time and peak memory of the agent and all its check
+    processes so far, and the nice level it runs at."""
+    usage_self = resource.getrusage(resource.RUSAGE_SELF)
+    usage_children = resource.getrusage(resource.RUSAGE_CHILDREN)
+    cpu_seconds = (
+        usage_self.ru_utime + usage_self.ru_stime
+        + usage_children.ru_utime + usage_children.ru_stime
+    )
+    try:
+        nice_level = os.getpriority(os.PRIO_PROCESS, 0)
+    except OSError:
+        nice_level = 0
+    return {
+        "cpu_seconds": round(cpu_seconds, 3),
+        "max_rss_bytes": max(usage_self.ru_maxrss, usage_children.ru_maxrss) * 1024,
+        "nice": nice_level,
+    }
+
+
+def _read_text(path: Path) -> str:
+    try:
+        return path.read_text().strip()
+    except OSError:
+        return ""
+
+
+def _command_output(command: list[str]) -> str:
+    """Return the output of a command, or "" if it is missing or fails."""
+    if not shutil.which(command[0]):
+        return ""
+    try:
+        result = subprocess.run(command, capture_output=True, text=True, timeout=30)
+    except (OSError, subprocess.TimeoutExpired):
+        return ""
+    return result.stdout.strip() if result.returncode == 0 else ""
+
+
+def collect_cpu() -> dict:
+    """CPU model, sockets and cores from /proc/cpuinfo."""
+    model, sockets, cores = "", set(), set()
+    physical_id = ""
+    for line in _read_text(Path("/proc/cpuinfo")).splitlines():
+        key, _, value = line.partition(":")
+        key, value = key.strip(), value.strip()
+        if key == "model name" and not model:
+            model = value
+        elif key == "physical id":
+            physical_id = value
+            sockets.add(value)
+        elif key == "core id":
+            cores.add((physical_id, value))
+    threads = os.cpu_count() or 0
+    return {
+        "model": model,
+        "sockets": len(sockets) or 1,
+        "cores": len(cores) or threads,
+        "threads": threads,
+    }
+
+
+def collect_memory_bytes() -> int:
+    for line in _read_text(Path("/proc/meminfo")).splitlines():
+        if line.startswith("MemTotal:"):
+            return int(line.split()[1]) * 1024
+    return 0
+
+
+def collect_gpus() -> tuple[list[dict], str]:
+    """GPUs from nvidia-smi, and the NVIDIA driver version."""
+    output = _command_output(
+        ["nvidia-smi", f"--query-gpu={INVENTORY_GPU_FIELDS}", "--format=csv,noheader,nounits"]
+    )
+    gpus, driver = [], ""
+    for line in output.splitlines():
+        fields = [f.strip() for f in line.split(",")]
+        if len(fields) != len(INVENTORY_GPU_FIELDS.split(",")):
+            continue
+        index, name, uuid, serial, vbios, memory, driver = fields
+        gpu = {"index": int(index) if index.isdigit() else len(gpus), "model": name, "uuid": uuid}
+        if serial and serial != "[N/A]":
+            gpu["serial"] = serial
+        if vbios and vbios != "[N/A]":
+            gpu["vbios"] = vbios
+        if memory.isdigit():
+            gpu["memory_mib"] = int(memory)
+        gpus.append(gpu)
+    return gpus, driver
+
+
+def collect_nics() -> list[dict]:
+    """Physical network interfaces (those backed by a device) from sysfs."""
+    nics = []
+    for iface in sorted(Path("/sys/class/net").glob("*")):
+        if not (iface / "device").exists():
+            continue
+        # ARPHRD_INFINIBAND
+        nic = {"name": iface.name, "type": "infiniband" if _read_text(iface / "type") == "32" else "ethernet"}
+        driver = iface / "device" / "driver"
+        if driver.exists():
+            nic["driver"] = driver.resolve().name
+        mac = _read_text(iface / "address")
+        if mac:
+            nic["mac"] = mac
+        speed = _read_text(iface / "speed")
+        if speed.isdigit():
+            nic["speed_mbps"] = int(speed)
+        nics.append(nic)
+    return nics
+
+
+def collect_hcas() -> list[dict]:
+    """InfiniBand adapters and their ports from sysfs."""
+    hcas = []
+    for device in sorted(Path("/sys/class/infiniband").glob("*")):
+        hca = {"name": device.name}
+        for key, name in (("model", "hca_type"), ("board_id", "board_id"), ("firmware", "fw_ver")):
+            value = _read_text(device / name)
+            if value:
+                hca[key] = value
+        ports = []
+        for port in sorted((device / "ports").glob("*"), key=lambda p: int(p.name) if p.name.isdigit() else 0):
+            if not port.name.isdigit():
+                continue
+            # state reads like "4: ACTIVE"
+            state = _read_text(port / "state").partition(":")[2].strip()
+            ports.append({"port": int(port.name), "state": state, "rate": _read_text(port / "rate")})
+        if ports:
+            hca["ports"] = ports
+        hcas.append(hca)
+    return hcas
+
+
+def collect_os() -> str:
+    for line in _read_text(Path("/etc/os-release")).splitlines():
+        if line.startswith("PRETTY_NAME="):
+            return line.split("=", 1)[1].strip('"')
+    return ""
+
+
+def collect_inventory(hostname: str) -> dict:
+    """Collect the hardware inventory of this node."""
+    gpus, nvidia_driver = collect_gpus()
+    drivers = {}
+    if nvidia_driver:
+        drivers["nvidia"] = nvidia_driver
+    cuda = re.search(r"CUDA Version:\s*([\d.]+)", _command_output(["nvidia-smi"]))
+    if cuda:
+        drivers["cuda"] = cuda.group(1)
+    ofed = _command_output(["ofed_info", "-s"]).rstrip(":")
+    if ofed:
+        drivers["ofed"] = ofed
+    return {
+        "target": hostname,
+        "cpu": collect_cpu(),
+        "memory_bytes": collect_memory_bytes(),
+        "gpus": gpus,
+        "nics": collect_nics(),
+        "hcas": collect_hcas(),
+        "os": collect_os(),
+        "kernel": os.uname().release,
+        "drivers": drivers,
+    }
+
+
+def parse_bandwidth_test(output: str) -> dict:
+    """Bandwidths in GB/s of CUDA bandwidthTest output, at its largest transfer size."""
+    sections = {
+        "Host to Device Bandwidth": "h2d_gbps",
+        "Device to Host Bandwidth": "d2h_gbps",
+        "Device to Device Bandwidth": "d2d_gbps",
+    }
+    metrics, current = {}, None
+    for line in output.splitlines():
+        header = next((key for title, key in sections.items() if title in line), None)
+        if header:
+            current = header
+            continue
+        fields = line.split()
+        if current and len(fields) == 2 and fields[0].isdigit():
+            try:
+                metrics[current] = float(fields[1])
+            except ValueError:
+                pass
+        elif current and not fields:
+            current = None
+    return metrics
+
+
+def parse_nccl_busbw(output: str) -> Optional[float]:
+    """Average bus bandwidth in GB/s of nccl-tests output."""
+    match = re.search(r"#\s*Avg bus bandwidth\s*:\s*([\d.]+)", output)
+    return float(match.group(1)) if match else None
 
 
 class DynamicCheckRunner:
@@ -68,24 +1036,63 @@ class DynamicCheckRunner:
     def __init__(
         self,
         config_server_url: str = "",
+        results_url: str = "",
+        credential_file: str = DEFAULT_CREDENTIAL_FILE,
         hostname: str = "",
         debug: bool = False,
         textfile_dir: str = DEFAULT_TEXTFILE_DIR,
         check_scripts_dir: str = DEFAULT_CHECK_SCRIPTS_DIR,
         config_file: str = DEFAULT_CONFIG_FILE,
         log_file: str = DEFAULT_LOG_FILE,
+        state_file: str = DEFAULT_STATE_FILE,
+        dry_run: bool = False,
+        nice: int = DEFAULT_NICE,
+        ionice_class: str = DEFAULT_IONICE_CLASS,
+        cpu_quota: str = "",
+        memory_max: str = "",
     ) -> None:
         self.config_server_url = config_server_url
+        self.results_url = results_url
+        self.credential_file = Path(credential_file)
+        self.credential = ""
+        self.cert_file = self.credential_file.with_name(AGENT_CERT_NAME)
+        self.key_file = self.credential_file.with_name(AGENT_KEY_NAME)
+        self.ca_file = self.credential_file.with_name(AGENT_CA_NAME)
+        self.ssl_context: Optional[ssl.SSLContext] = None
         self.hostname = hostname or socket.gethostname()
         self.debug = debug
+        self.dry_run = dry_run
+        self.nice = nice
+        self.ionice_class = ionice_class
+        self.cpu_quota = cpu_quota
+        self.memory_max = memory_max
         self.textfile_dir = Path(textfile_dir)
         self.check_scripts_dir = Path(check_scripts_dir)
         self.config_file = Path(config_file)
         self.log_file = Path(log_file)
+        self.state_file = Path(state_file)
+        self.checks_cache_file = self.state_file.with_name("effective-checks.json")
+        self.state: dict = {}
+        spool_dir = self.state_file.with_name("spool")
+        self.result_spool = Spool(spool_dir / "results.jsonl", MAX_SPOOLED_RESULTS, logging.getLogger(__name__))
+        self.heartbeat_spool = Spool(spool_dir / "heartbeats.jsonl", MAX_SPOOLED_HEARTBEATS, logging.getLogger(__name__))
+        self._schedules: dict[str, Optional[CheckSchedule]] = {}
+        self._escalations: dict[str, Optional[Escalation]] = {}
+        self._report_modes: dict[str, str] = {}
+        self._timeouts: dict[str, int] = {}
+        self.diagnostics_dir = Path(DEFAULT_DIAGNOSTICS_DIR)
+        self._refresh_scripts = False
+        self._unreachable: Optional[str] = None
+        self._fetch_error: Optional[str] = None
 
         self._setup_logging()
         self._load_config()
-        self._ensure_directories()
+        self._load_credential()
+        self._load_certificate()
+        self._load_state()
+        if not self.dry_run:
+            self._ensure_directories()
+            self._apply_priority()
 
     def _setup_logging(self) -> None:
         """Setup logging configuration."""
@@ -94,13 +1101,14 @@ class DynamicCheckRunner:
 
         handlers: list[logging.Handler] = []
 
-        # Try to setup file logging
-        try:
-            self.log_file.parent.mkdir(parents=True, exist_ok=True)
-            handlers.append(logging.FileHandler(self.log_file))
-        except PermissionError:
-            # Fall back to stderr if we can't write to log file
-            pass
+        # Try to setup file logging (dry-run leaves no trace on the node)
+        if not self.dry_run:
+            try:
+                self.log_file.parent.mkdir(parents=True, exist_ok=True)
+                handlers.append(logging.FileHandler(self.log_file))
+            except PermissionError:
+                # Fall back to stderr if we can't write to log file
+                pass
 
         # Add console handler for debug mode
         if self.debug:
@@ -142,22 +1150,206 @@ class DynamicCheckRunner:
         """Create required directories if they don't exist."""
         self.textfile_dir.mkdir(parents=True, exist_ok=True)
         self.check_scripts_dir.mkdir(parents=True, exist_ok=True)
+        self.state_file.parent.mkdir(parents=True, exist_ok=True)
+
+    def _load_state(self) -> None:
+        """Load per-check scheduler state (last run times) from disk."""
+        try:
+            self.state = json.loads(self.state_file.read_text())
+        except FileNotFoundError:
+            self.state = {}
+        except (OSError, json.JSONDecodeError) as e:
+            self.logger.warning(f"Could not read state file, starting fresh: {e}")
+            self.state = {}
+        self.state.setdefault("checks", {})
+        self.state.setdefault("tasks", {"completed": [], "results": []})
+        self.state.setdefault("offline", {})
+        self.state.setdefault("inventory", {})
+        # Results queued in the state file by older agents move to the spool
+        pending = self.state.pop("pending_results", None)
+        if pending and not self.dry_run:
+            for item in pending:
+                self.result_spool.append(item)
+
+    def _save_state(self) -> None:
+        """Atomically persist scheduler state."""
+        temp_file = self.state_file.with_suffix(".tmp")
+        temp_file.write_text(json.dumps(self.state, indent=2, sort_keys=True))
+        temp_file.rename(self.state_file)
+
+    def _check_state(self, check_name: str) -> dict:
+        return self.state["checks"].setdefault(check_name, {})
+
+    def _blocking_dependency(self, check: CheckInfo, assigned: set[str]) -> Optional[str]:
+        """Return the first dependency that prevents the check from running.
+
+        A dependency blocks when it is not assigned to this host or its last
+        run failed. A dependency that has never run does not block.
+        """
+        for dep in check_dependencies(check):
+            if dep not in assigned:
+                return dep
+            if self.state["checks"].get(dep, {}).get("last_success") is False:
+                return dep
+        return None
+
+    def _write_blocked_metric(self, check_name: str, dependency: str) -> None:
+        """Replace a blocked check's output so stale results are not scraped."""
+        output_file = self.textfile_dir / f"{check_name}.prom.tmp"
+        final_file = self.textfile_dir / f"{check_name}.prom"
+        output_file.write_text(f"""# HELP aami_check_blocked Check skipped because a dependency failed (1=blocked)
+# TYPE aami_check_blocked gauge
+aami_check_blocked{{check="{check_name}",dependency="{dependency}"}} 1
+""")
+        output_file.rename(final_file)
+
+    def _schedule_for(self, check: CheckInfo) -> Optional[CheckSchedule]:
+        """Return the schedule in effect for a check, or None if its config is invalid.
+
+        An escalated check uses its faster escalation schedule. The check's
+        report mode and timeout are read along with it.
+        """
+        if check.name not in self._schedules:
+            try:
+                schedule = CheckSchedule.from_config(check.config)
+                self._escalations[check.name] = Escalation.from_config(check.config)
+                self._report_modes[check.name] = report_mode(check.config)
+                self._timeouts[check.name] = check_timeout(check.config)
+                self._schedules[check.name] = schedule
+            except (ValueError, KeyError, TypeError, AttributeError) as e:
+                self.logger.error(f"Invalid config for check {check.name}: {e}")
+                self._schedules[check.name] = None
+        schedule = self._schedules[check.name]
+        escalation = self._escalations.get(check.name)
+        if schedule is not None and escalation is not None:
+            if self.state["checks"].get(check.name, {}).get("escalated"):
+                return escalation.schedule(schedule)
+        return schedule
+
+    def _record_result(self, result: CheckResult, now: float) -> None:
+        """Update run history and escalation state after a check ran."""
+        check_name, success = result.name, result.success
+        state = self._check_state(check_name)
+        state["last_run"] = now
+        state["last_success"] = success
+        state["last_status"] = result.status
+        state["last_duration_ms"] = result.duration_ms
+        state["last_exit_code"] = result.exit_code
+        if success:
+            state["consecutive_passes"] = state.get("consecutive_passes", 0) + 1
+            state["consecutive_failures"] = 0
+        else:
+            state["consecutive_failures"] = state.get("consecutive_failures", 0) + 1
+            state["consecutive_passes"] = 0
+
+        escalation = self._escalations.get(check_name)
+        if escalation is None:
+            state.pop("escalated", None)
+            return
+        if not success and not state.get("escalated"):
+            self.logger.info(f"Escalating check {check_name}: re-running every {escalation.interval}s")
+            state["escalated"] = True
+        elif success and state.get("escalated") and state["consecutive_passes"] >= escalation.recover_after:
+            self.logger.info(f"Check {check_name} recovered after {state['consecutive_passes']} passes")
+            state["escalated"] = False
+
+    def _apply_priority(self) -> None:
+        """Lower CPU and I/O priority; checks inherit both."""
+        try:
+            current = os.getpriority(os.PRIO_PROCESS, 0)
+            if self.nice > current:
+                os.nice(self.nice - current)
+        except OSError as e:
+            self.logger.warning(f"Could not set nice level {self.nice}: {e}")
+
+        io_class = IONICE_CLASSES.get(self.ionice_class)
+        if io_class is None:
+            self.logger.warning(f"Unknown ionice class: {self.ionice_class}")
+        elif shutil.which("ionice"):
+            subprocess.run(
+                ["ionice", "-c", str(io_class), "-p", str(os.getpid())],
+                capture_output=True,
+            )
+
+    def _check_command(self, script_path: Path) -> list[str]:
+        """Build the check command, wrapped in a transient cgroup if limits are set."""
+        command = [str(script_path)]
+        if not (self.cpu_quota or self.memory_max):
+            return command
+
+        if not shutil.which("systemd-run"):
+            self.logger.warning("systemd-run not found, cgroup limits not applied")
+            return command
+
+        scope = ["systemd-run", "--scope", "--quiet", "--collect"]
+        if self.cpu_quota:
+            scope += ["-p", f"CPUQuota={self.cpu_quota}"]
+        if self.memory_max:
+            scope += ["-p", f"MemoryMax={self.memory_max}"]
+        return scope + command
 
     def run(self) -> int:
         """Run dynamic checks and return exit code."""
+        if self.dry_run:
+            return self.plan()
+
         if not self.config_server_url:
             self._write_status_metrics(success=False, checks_total=0, checks_success=0, checks_failed=0, duration=0)
             return 1
 
         start_time = time.time()
         self.logger.info(f"Starting dynamic check run for hostname: {self.hostname}")
+        self._renew_credential()
+        self._renew_certificate()
         self.logger.debug(f"Config Server: {self.config_server_url}")
         self.logger.debug(f"Textfile Directory: {self.textfile_dir}")
         self.logger.debug(f"Check Scripts Directory: {self.check_scripts_dir}")
 
-        # Fetch effective checks
-        checks = self._fetch_effective_checks()
+        # While offline, only try the Config Server again once the backoff
+        # is over
+        self._unreachable = None
+        offline = self.state["offline"]
+        contact = (not offline
+                   or start_time + OFFLINE_RETRY_SLACK >= offline["next_attempt"])
+        if not contact:
+            self.logger.info(
+                f"Config Server unreachable since {datetime.fromtimestamp(offline['since']).isoformat()}, "
+                f"next attempt in {int(offline['next_attempt'] - start_time)}s; running the last known checks"
+            )
+
+        # Report in and run queued tasks; check-now tasks affect this run
+        config_hash = ""
+        pending_tasks = 0
+        for _ in range(MAX_HEARTBEAT_ROUNDS if contact else 0):
+            heartbeat = self._heartbeat()
+            if heartbeat is None:
+                break
+            config_hash = heartbeat.config_hash or config_hash
+            pending_tasks = heartbeat.pending_tasks
+            if heartbeat.tasks:
+                self._run_tasks(heartbeat.tasks)
+                self._save_state()
+            if not heartbeat.tasks or heartbeat.pending_tasks <= 0:
+                break
+        if not contact or self._unreachable:
+            self.heartbeat_spool.append({"timestamp": int(start_time), "config_hash": self._cached_config_hash()})
+
+        # Fetch effective checks, unless the config hash is unchanged. If the
+        # Config Server cannot be reached, run the checks last fetched.
+        checks, config_cached = None, False
+        if contact and not self._unreachable:
+            checks, config_cached = self._effective_checks(config_hash)
+        if contact and self._unreachable:
+            self._went_offline(time.time())
+        elif contact:
+            self._came_online(time.time())
+        if checks is None and self.state["offline"]:
+            checks = self._cached_checks()
+            config_cached = checks is not None
+            if checks is None:
+                self.logger.error("No checks were fetched before, nothing to run offline")
         if checks is None:
+            self._save_state()
             self._write_status_metrics(
                 success=False,
                 checks_total=0,
@@ -167,42 +1359,413 @@ class DynamicCheckRunner:
             )
             return 1
 
-        # Execute checks
+        # Execute checks that are due
         checks_total = len(checks)
         checks_success = 0
         checks_failed = 0
+        checks_skipped = 0
+        checks_blocked = 0
+        now = time.time()
+
+        assigned = {check.name for check in checks}
+        ordered, cyclic = order_checks(checks)
+        for name in sorted(cyclic):
+            self.logger.error(f"Dependency cycle involving check: {name}")
+            checks_failed += 1
+
+        for check in ordered:
+            schedule = self._schedule_for(check)
+            if schedule is None:
+                checks_failed += 1
+                continue
+
+            state = self._check_state(check.name)
+            offset = schedule.jitter_offset(self.hostname, check.name)
+            if not schedule.is_due(state.get("last_run"), now, offset):
+                self.logger.debug(f"Check not due: {check.name} ({schedule.describe()})")
+                checks_skipped += 1
+                continue
+
+            blocker = self._blocking_dependency(check, assigned)
+            if blocker is not None:
+                self.logger.info(f"Skipping check {check.name}: dependency {blocker} failed or is not assigned")
+                self._write_blocked_metric(check.name, blocker)
+                checks_blocked += 1
+                continue
 
-        for check in checks:
             self.logger.info(f"Processing check: {check.name}")
 
             # Save script
             script_path = self._save_check_script(check)
 
             # Execute check
-            result = self._execute_check(check.name, script_path, check.config)
+            started_at = time.time()
+            timeout = self._timeouts.get(check.name, DEFAULT_CHECK_TIMEOUT)
+            result = self._execute_check(check.name, script_path, check.config, timeout)
+            self._record_result(result, now)
+            if self._report_modes.get(check.name, REPORT_API) != REPORT_TEXTFILE:
+                self._queue_result(result, started_at)
 
             if result.success:
                 checks_success += 1
             else:
                 checks_failed += 1
 
+        # A separate check result API is tried every run
+        if self.results_url or (contact and not self.state["offline"]):
+            self._report_results()
+            self._report_inventory(time.time())
+
+        # Forget checks no longer assigned to this host
+        for name in list(self.state["checks"]):
+            if name not in assigned:
+                del self.state["checks"][name]
+        self._save_state()
+        self._write_schedule_metrics(checks, now)
+        self._write_result_metrics(checks)
+
         # Write status metrics
         duration = int(time.time() - start_time)
         self._write_status_metrics(
-            success=True,
+            success=not self.state["offline"],
             checks_total=checks_total,
             checks_success=checks_success,
             checks_failed=checks_failed,
             duration=duration,
+            checks_skipped=checks_skipped,
+            checks_blocked=checks_blocked,
+            config_cached=config_cached,
+            pending_tasks=pending_tasks,
         )
 
         self.logger.info(
             f"Check run completed: total={checks_total}, success={checks_success}, "
-            f"failed={checks_failed}, duration={duration}s"
+            f"failed={checks_failed}, skipped={checks_skipped}, blocked={checks_blocked}, "
+            f"duration={duration}s"
         )
 
         return 0
 
+    def watch(self) -> int:
+        """Run every tick until stopped, as the timer would start the agent.
+
+        Each run heartbeats and fetches as usual, so config changes and
+        queued tasks are picked up on the next tick.
+        """
+        while True:
+            started = time.time()
+            # A fresh run, as if started by the timer
+            self._refresh_scripts = False
+            self._schedules.clear()
+            self._escalations.clear()
+            self._report_modes.clear()
+            try:
+                self.run()
+            except Exception:
+                self.logger.exception("Check run failed")
+            time.sleep(max(0.0, started + TICK_SECONDS - time.time()))
+
+    def plan(self) -> int:
+        """Print what a run would do without executing or writing anything."""
+        print(f"[DRY-RUN] AAMI dynamic check plan for {self.hostname}")
+        print()
+
+        print("Registration:")
+        print(f"  Hostname:       {self.hostname}")
+        if not self.config_server_url:
+            print("  Config Server:  not configured")
+            return 1
+        print(f"  Config Server:  {self.config_server_url}")
+        reachable = self._probe(f"{self.config_server_url.rstrip('/')}/api/v1/health")
+        print(f"  Reachable:      {'yes' if reachable else 'no'}")
+        claims = credential_claims(self.credential) if self.credential else None
+        if not self.credential:
+            print("  Credential:     none")
+        elif claims is None:
+            print("  Credential:     malformed")
+        else:
+            expires = datetime.fromtimestamp(claims["exp"]).isoformat(timespec="seconds")
+            print(f"  Credential:     {claims.get('sub', '?')}, expires {expires}")
+        offline = self.state["offline"]
+        if offline:
+            since = datetime.fromtimestamp(offline["since"]).isoformat(timespec="seconds")
+            retry = datetime.fromtimestamp(offline["next_attempt"]).isoformat(timespec="seconds")
+            print(f"  Offline:        since {since}, next attempt {retry}")
+        print(f"  Spooled:        {len(self.result_spool)} check result(s), {len(self.heartbeat_spool)} heartbeat(s)")
+        print()
+
+        print("Config fetch:")
+        checks = self._fetch_effective_checks()
+        if checks is None:
+            print(f"  Failed to fetch effective checks: {self._fetch_error}")
+            return 1
+        print(f"  {len(checks)} effective check(s)")
+        print()
+
+        print("Check plan:")
+        if not checks:
+            print("  (no checks assigned to this host)")
+        now = time.time()
+        assigned = {check.name for check in checks}
+        ordered, cyclic = order_checks(checks)
+        for name in sorted(cyclic):
+            print(f"  - {name}")
+            print("      INVALID: dependency cycle (check would be counted as failed)")
+        for check in ordered:
+            schedule = self._schedule_for(check)
+            state = self.state["checks"].get(check.name, {})
+            script_dir = self.check_scripts_dir / check.name
+            script_file = script_dir / f"{check.name}_{check.script_hash}.sh"
+            cached = "cached" if script_file.exists() else "would save"
+            print(f"  - {check.name}")
+            print(f"      script:   {script_file} ({cached})")
+            print(f"      config:   {json.dumps(check.config, sort_keys=True)}")
+            print(f"      output:   {self.textfile_dir / (check.name + '.prom')}")
+            if schedule is None:
+                print("      schedule: INVALID (check would be counted as failed)")
+                continue
+            print(f"      timeout:  {self._timeouts[check.name]}s")
+            mode = self._report_modes[check.name]
+            reports = {
+                REPORT_API: "check result API",
+                REPORT_TEXTFILE: f"{self.textfile_dir / 'aami_check_results.prom'}",
+                REPORT_BOTH: f"check result API and {self.textfile_dir / 'aami_check_results.prom'}",
+            }
+            print(f"      report:   {reports[mode]}")
+            offset = schedule.jitter_offset(self.hostname, check.name)
+            next_run = schedule.next_run(state.get("last_run"), now, offset)
+            print(f"      schedule: {schedule.describe()}")
+            escalation = self._escalations.get(check.name)
+            if escalation is not None:
+                status = "ESCALATED" if state.get("escalated") else "normal"
+                print(f"      escalate: every {escalation.interval}s after failure, "
+                      f"until {escalation.recover_after} passes ({status})")
+            deps = check_dependencies(check)
+            if deps:
+                print(f"      depends:  {', '.join(deps)}")
+                blocker = self._blocking_dependency(check, assigned)
+                if blocker is not None:
+                    print(f"      blocked:  {blocker} failed or is not assigned (as of last run)")
+            if schedule.is_due(state.get("last_run"), now, offset):
+                print("      next run: now")
+            elif next_run != float("inf"):
+                when = datetime.fromtimestamp(next_run).strftime("%Y-%m-%d %H:%M:%S")
+                suffix = "" if schedule.in_window(datetime.fromtimestamp(next_run)) else " (then wait for window)"
+                print(f"      next run: {when}{suffix}")
+        print()
+
+        print("Resource limits:")
+        print(f"  nice:           {self.nice}")
+        print(f"  ionice class:   {self.ionice_class}")
+        print(f"  cpu quota:      {self.cpu_quota or 'unlimited'}")
+        print(f"  memory max:     {self.memory_max or 'unlimited'}")
+        print()
+
+        print("Hardware inventory:")
+        inventory = collect_inventory(self.hostname)
+        cpu = inventory["cpu"]
+        print(f"  cpu:            {cpu['model'] or 'unknown'} ({cpu['sockets']} socket(s), {cpu['cores']} cores, {cpu['threads']} threads)")
+        print(f"  memory:         {inventory['memory_bytes'] / 2**30:.1f} GiB")
+        for gpu in inventory["gpus"]:
+            print(f"  {'gpu ' + str(gpu['index']) + ':':<15} {gpu['model']}, serial {gpu.get('serial', '?')}, vbios {gpu.get('vbios', '?')}")
+        for nic in inventory["nics"]:
+            print(f"  nic:            {nic['name']} ({nic['type']}, {nic.get('driver', '?')})")
+        for hca in inventory["hcas"]:
+            print(f"  hca:            {hca['name']} {hca.get('model', '')} firmware {hca.get('firmware', '?')}")
+        print(f"  kernel:         {inventory['kernel']}")
+        for name, version in sorted(inventory["drivers"].items()):
+            print(f"  {name + ':':<15} {version}")
+        reported = self.state["inventory"].get("reported_at")
+        print(f"  last reported:  {datetime.fromtimestamp(reported).isoformat(timespec='seconds') if reported else 'never'}")
+        print()
+
+        print("Exporters:")
+        for name, port in KNOWN_EXPORTERS:
+            running = self._probe(f"http://localhost:{port}/metrics")
+            print(f"  {name:<15} :{port:<6} {'running' if running else 'not running'}")
+        print()
+
+        print("[DRY-RUN] No scripts were saved or executed, no metrics were written.")
+        return 0
+
+    def _load_credential(self) -> None:
+        """Read the agent credential issued at registration, if any."""
+        try:
+            self.credential = self.credential_file.read_text().strip()
+        except FileNotFoundError:
+            self.credential = ""
+        except OSError as e:
+            self.logger.warning(f"Could not read agent credential: {e}")
+            self.credential = ""
+
+    def _auth_headers(self) -> dict:
+        """Authorization header for Config Server requests."""
+        if not self.credential:
+            return {}
+        return {"Authorization": f"Bearer {self.credential}"}
+
+    def _renew_credential(self) -> None:
+        """Renew the agent credential once little of its lifetime is left.
+
+        The renewed credential replaces the file atomically. A failed
+        renewal is retried on the next run; the old credential keeps working
+        until it expires.
+        """
+        if not self.credential:
+            return
+        claims = credential_claims(self.credential)
+        if claims is None:
+            self.logger.warning(f"Agent credential in {self.credential_file} is malformed, not renewing")
+            return
+        lifetime = claims["exp"] - claims["iat"]
+        remaining = claims["exp"] - time.time()
+        if remaining > lifetime * CREDENTIAL_RENEW_FRACTION:
+            return
+
+        url = f"{self.config_server_url.rstrip('/')}/api/v1/agents/credentials/renew"
+        try:
+            request = urllib.request.Request(
+                url,
+                data=b"{}",
+                headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                method="POST",
+            )
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context) as response:
+                token = json.loads(response.read().decode("utf-8"))["token"]
+        except urllib.error.HTTPError as e:
+            if e.code == 401:
+                self._credential_rejected(e)
+            elif e.code == 404:
+                self.logger.debug("Config Server has no credential renewal endpoint")
+            else:
+                self.logger.warning(f"Credential renewal failed, retrying next run: {e}")
+            return
+        except (urllib.error.URLError, OSError, json.JSONDecodeError, KeyError, TypeError) as e:
+            self.logger.warning(f"Credential renewal failed, retrying next run: {e}")
+            return
+
+        temp_file = self.credential_file.with_suffix(".tmp")
+        try:
+            fd = os.open(temp_file, os.O_WRONLY | os.O_CREAT | os.O_TRUNC, 0o600)
+            with os.fdopen(fd, "w") as f:
+                f.write(token + "\n")
+            temp_file.rename(self.credential_file)
+        except OSError as e:
+            self.logger.error(f"Could not save renewed credential: {e}")
+            return
+        self.credential = token
+        renewed = credential_claims(token)
+        expires = datetime.fromtimestamp(renewed["exp"]).isoformat() if renewed else "unknown"
+        self.logger.info(f"Renewed agent credential, expires {expires}")
+
+    def _load_certificate(self) -> None:
+        """Set up mutual TLS with the client certificate issued at registration, if any.
+
+        The agent CA is trusted besides the system CAs, for servers with a
+        certificate of their own from it.
+        """
+        self.ssl_context = None
+        if not (self.cert_file.exists() and self.key_file.exists()):
+            return
+        try:
+            context = ssl.create_default_context()
+            if self.ca_file.exists():
+                context.load_verify_locations(cafile=str(self.ca_file))
+            context.load_cert_chain(str(self.cert_file), str(self.key_file))
+        except (OSError, ssl.SSLError) as e:
+            self.logger.warning(f"Could not load client certificate {self.cert_file}: {e}")
+            return
+        self.ssl_context = context
+
+    def _renew_certificate(self) -> None:
+        """Renew the client certificate once little of its lifetime is left.
+
+        The current certificate authenticates the request. The new key and
+        certificate replace the files atomically; a failed renewal is
+        retried on the next run.
+        """
+        if self.ssl_context is None:
+            return
+        try:
+            # The stdlib reads certificate dates only through this helper
+            decoded = ssl._ssl._test_decode_cert(str(self.cert_file))
+            not_before = ssl.cert_time_to_seconds(decoded["notBefore"])
+            not_after = ssl.cert_time_to_seconds(decoded["notAfter"])
+        except (AttributeError, KeyError, ValueError, OSError, ssl.SSLError) as e:
+            self.logger.warning(f"Could not read client certificate {self.cert_file}, not renewing: {e}")
+            return
+        if not_after - time.time() > (not_after - not_before) * CREDENTIAL_RENEW_FRACTION:
+            return
+
+        url = f"{self.config_server_url.rstrip('/')}/api/v1/agents/certificates/renew"
+        try:
+            request = urllib.request.Request(
+                url,
+                data=b"{}",
+                headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                method="POST",
+            )
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context) as response:
+                data = json.loads(response.read().decode("utf-8"))
+            files = [(self.key_file, data["private_key"], 0o600), (self.cert_file, data["certificate"], 0o644)]
+            if data.get("ca_certificate"):
+                files.append((self.ca_file, data["ca_certificate"], 0o644))
+        except urllib.error.HTTPError as e:
+            if e.code == 401:
+                self._credential_rejected(e)
+            elif e.code == 404:
+                self.logger.debug("Config Server has no certificate renewal endpoint")
+            else:
+                self.logger.warning(f"Certificate renewal failed, retrying next run: {e}")
+            return
+        except (urllib.error.URLError, OSError, json.JSONDecodeError, KeyError, TypeError) as e:
+            self.logger.warning(f"Certificate renewal failed, retrying next run: {e}")
+            return
+
+        try:
+            for path, content, mode in files:
+                temp_file = path.with_name(path.name + ".tmp")
+                fd = os.open(temp_file, os.O_WRONLY | os.O_CREAT | os.O_TRUNC, mode)
+                with os.fdopen(fd, "w") as f:
+                    f.write(content)
+                temp_file.rename(path)
+        except OSError as e:
+            self.logger.error(f"Could not save renewed certificate: {e}")
+            return
+        self._load_certificate()
+        self.logger.info(f"Renewed client certificate, expires {data.get('expires_at', 'unknown')}")
+
+    def _credential_rejected(self, error: urllib.error.HTTPError) -> None:
+        """Log a request rejected for its credential, with what to do about it."""
+        try:
+            code = json.loads(error.read().decode("utf-8"))["error"]["code"]
+        except (ValueError, KeyError, TypeError, OSError):
+            code = ""
+        if code == "CERTIFICATE_REQUIRED":
+            self.logger.error(
+                f"Server requires a client certificate; install one at {self.cert_file} "
+                "(aami agents certificate, or re-run bootstrap)"
+            )
+        elif not self.credential:
+            self.logger.error(
+                f"Config Server requires an agent credential; install one at {self.credential_file} "
+                "(aami agents issue, or re-run bootstrap)"
+            )
+        elif code == "CREDENTIAL_REVOKED":
+            self.logger.error("Agent credential was revoked; this node needs a new one (aami agents issue)")
+        elif code == "CREDENTIAL_EXPIRED":
+            self.logger.error("Agent credential expired before it could be renewed; this node needs a new one")
+        else:
+            self.logger.error(f"Agent credential rejected ({code or error.code})")
+
+    def _probe(self, url: str) -> bool:
+        """Return True if a GET to url succeeds."""
+        try:
+            with urllib.request.urlopen(url, timeout=5):
+                return True
+        except Exception:
+            return False
+
     def _fetch_effective_checks(self) -> Optional[list[CheckInfo]]:
         """Fetch effective checks from Config Server."""
         url = f"{self.config_server_url.rstrip('/')}/api/v1/checks/target/hostname/{self.hostname}"
@@ -211,9 +1774,9 @@ class DynamicCheckRunner:
         try:
             request = urllib.request.Request(
                 url,
-                headers={"Accept": "application/json"},
+                headers={"Accept": "application/json", **self._auth_headers()},
             )
-            with urllib.request.urlopen(request, timeout=30) as response:
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context) as response:
                 data = json.loads(response.read().decode("utf-8"))
 
             checks = []
@@ -229,16 +1792,463 @@ class DynamicCheckRunner:
             self.logger.debug(f"Received {len(checks)} checks")
             return checks
 
-        except urllib.error.URLError as e:
+        except urllib.error.HTTPError as e:
+            self._fetch_error = str(e)
+            if e.code == 401:
+                self._credential_rejected(e)
+            else:
+                self.logger.error(f"Failed to fetch effective checks: {e}")
+                if e.code >= 500:
+                    self._unreachable = str(e)
+            return None
+        except (urllib.error.URLError, OSError) as e:
+            self._fetch_error = str(e)
             self.logger.error(f"Failed to fetch effective checks: {e}")
+            self._unreachable = str(e)
             return None
         except json.JSONDecodeError as e:
+            self._fetch_error = f"invalid response JSON: {e}"
             self.logger.error(f"Failed to parse response JSON: {e}")
             return None
         except Exception as e:
+            self._fetch_error = str(e)
             self.logger.error(f"Unexpected error fetching checks: {e}")
             return None
 
+    def _heartbeat(self) -> Optional[Heartbeat]:
+        """Report in to the Config Server and return its heartbeat response.
+
+        Results of tasks run since the last heartbeat, and the heartbeats
+        missed while the server was unreachable, are sent along and dropped
+        once the server has accepted them. Returns None if the heartbeat
+        failed or the server has no heartbeat endpoint.
+        """
+        url = f"{self.config_server_url.rstrip('/')}/api/v1/agents/{self.hostname}/heartbeat"
+        task_state = self.state["tasks"]
+        missed = self.heartbeat_spool.read()
+        body = {
+            "hostname": self.hostname,
+            "agent_version": VERSION,
+            "timestamp": int(time.time()),
+            "config_hash": self._cached_config_hash(),
+            "task_results": task_state["results"],
+            "resource_usage": resource_usage(),
+            "escalations": self._escalation_state(),
+        }
+        if missed:
+            body["missed_heartbeats"] = missed
+
+        try:
+            request = urllib.request.Request(
+                url,
+                data=json.dumps(body).encode("utf-8"),
+                headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                method="POST",
+            )
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context) as response:
+                data = json.loads(response.read().decode("utf-8") or "{}")
+        except urllib.error.HTTPError as e:
+            if e.code == 404:
+                self.logger.debug("Config Server has no heartbeat endpoint, skipping tasks")
+            elif e.code == 401:
+                self._credential_rejected(e)
+            else:
+                self.logger.warning(f"Heartbeat failed: {e}")
+                if e.code >= 500:
+                    self._unreachable = str(e)
+            return None
+        except json.JSONDecodeError as e:
+            self.logger.warning(f"Heartbeat failed: {e}")
+            return None
+        except (urllib.error.URLError, OSError) as e:
+            self.logger.warning(f"Heartbeat failed: {e}")
+            self._unreachable = str(e)
+            return None
+
+        task_state["results"] = []
+        if missed:
+            self.logger.info(f"Replayed {len(missed)} heartbeat(s) missed while offline")
+            self.heartbeat_spool.drop(len(missed))
+
+        tasks = []
+        for item in data.get("tasks") or []:
+            try:
+                tasks.append(Task.from_response(item))
+            except (KeyError, TypeError, ValueError) as e:
+                self.logger.warning(f"Ignoring malformed task {item!r}: {e}")
+        heartbeat = Heartbeat(
+            tasks=tasks,
+            config_hash=str(data.get("config_hash") or ""),
+            pending_tasks=int(data.get("pending_tasks") or 0),
+        )
+        self.logger.debug(
+            f"Received {len(tasks)} task(s), {heartbeat.pending_tasks} pending, "
+            f"config hash {heartbeat.config_hash[:12] or 'not reported'}"
+        )
+        return heartbeat
+
+    def _escalation_state(self) -> list[dict]:
+        """Return the checks with an escalation policy and whether each is escalated."""
+        escalations = []
+        for name, state in sorted(self.state["checks"].items()):
+            if "escalated" not in state:
+                continue
+            escalations.append({
+                "check": name,
+                "escalated": bool(state["escalated"]),
+                "consecutive_failures": state.get("consecutive_failures", 0),
+                "consecutive_passes": state.get("consecutive_passes", 0),
+            })
+        return escalations
+
+    def _cached_config_hash(self) -> str:
+        try:
+            return json.loads(self.checks_cache_file.read_text()).get("config_hash", "")
+        except (OSError, json.JSONDecodeError, AttributeError):
+            return ""
+
+    def _effective_checks(self, config_hash: str) -> tuple[Optional[list[CheckInfo]], bool]:
+        """Return the effective checks and whether they came from the local cache.
+
+        The full config is only fetched when the heartbeat reports a hash that
+        differs from the cached one. Without a hash every run fetches.
+        """
+        if config_hash and not self._refresh_scripts and config_hash == self._cached_config_hash():
+            checks = self._cached_checks()
+            if checks is not None:
+                self.logger.debug(f"Config hash unchanged, using {len(checks)} cached check(s)")
+                return checks, True
+            self.logger.warning("Could not read cached checks, fetching")
+
+        # The checks are cached even without a hash, to run while offline
+        checks = self._fetch_effective_checks()
+        if checks is not None:
+            temp_file = self.checks_cache_file.with_suffix(".tmp")
+            temp_file.write_text(json.dumps(
+                {"config_hash": config_hash, "checks": [asdict(check) for check in checks]},
+                indent=2,
+            ))
+            temp_file.rename(self.checks_cache_file)
+        return checks, False
+
+    def _cached_checks(self) -> Optional[list[CheckInfo]]:
+        """Return the checks last fetched, or None if there are none."""
+        try:
+            cached = json.loads(self.checks_cache_file.read_text())
+            return [CheckInfo(**item) for item in cached["checks"]]
+        except FileNotFoundError:
+            return None
+        except (OSError, json.JSONDecodeError, KeyError, TypeError) as e:
+            self.logger.warning(f"Could not read cached checks: {e}")
+            return None
+
+    def _went_offline(self, now: float) -> None:
+        """Back off after failing to reach the Config Server."""
+        offline = self.state["offline"]
+        if not offline:
+            self.logger.warning(f"Config Server unreachable, running the last known checks: {self._unreachable}")
+        failures = offline.get("failures", 0) + 1
+        delay = min(OFFLINE_BACKOFF_MIN * 2 ** (failures - 1), OFFLINE_BACKOFF_MAX)
+        offline.update({
+            "since": offline.get("since", now),
+            "failures": failures,
+            "next_attempt": now + delay,
+            "error": self._unreachable,
+        })
+        self.logger.info(f"Trying the Config Server again in {delay}s")
+
+    def _came_online(self, now: float) -> None:
+        """Leave offline mode once the Config Server answered."""
+        offline = self.state["offline"]
+        if not offline:
+            return
+        self.logger.info(
+            f"Config Server reachable again after {int(now - offline['since'])}s offline, "
+            f"replaying {len(self.result_spool)} spooled check result(s)"
+        )
+        offline.clear()
+
+    def _run_tasks(self, tasks: list[Task]) -> None:
+        """Run queued tasks, highest priority first, skipping expired and repeated ones."""
+        task_state = self.state["tasks"]
+        completed = task_state["completed"]
+        now = time.time()
+
+        for task in sorted(tasks, key=lambda t: -t.priority):
+            if task.id in completed:
+                self.logger.debug(f"Task already completed: {task.id}")
+                continue
+
+            if task.expired(now):
+                self.logger.info(f"Task expired before delivery: {task.id} ({task.type})")
+                status, message = "expired", "task TTL elapsed before it was delivered"
+            else:
+                self.logger.info(f"Running task {task.id}: {task.type} (priority {task.priority})")
+                try:
+                    status, message = self._run_task(task)
+                except Exception as e:
+                    status, message = "failed", str(e)
+                if status == "failed":
+                    self.logger.error(f"Task {task.id} failed: {message}")
+
+            completed.append(task.id)
+            task_state["results"].append({
+                "id": task.id,
+                "type": task.type,
+                "status": status,
+                "message": message,
+                "finished_at": int(time.time()),
+            })
+
+        del completed[:-MAX_COMPLETED_TASKS]
+
+    def _run_task(self, task: Task) -> tuple[str, str]:
+        """Run one task and return its status ("succeeded" or "failed") and a message."""
+        args = task.args or {}
+
+        if task.type == TASK_CHECK_NOW:
+            # Clearing the last run makes the check due in this run
+            names = args.get("checks") or list(self.state["checks"])
+            for name in names:
+                self.state["checks"].get(name, {}).pop("last_run", None)
+            return "succeeded", f"scheduled {len(names)} check(s) to run now"
+
+        if task.type == TASK_CONFIG_REFRESH:
+            self._refresh_scripts = True
+            self._schedules.clear()
+            self._escalations.clear()
+            self._report_modes.clear()
+            return "succeeded", "checks will be refetched and scripts and schedules reloaded"
+
+        if task.type == TASK_RESTART_EXPORTER:
+            name = args.get("exporter", "")
+            if name not in {exporter for exporter, _ in KNOWN_EXPORTERS}:
+                return "failed", f"unknown exporter: {name!r}"
+            result = subprocess.run(
+                ["systemctl", "restart", f"{name}.service"],
+                capture_output=True,
+                text=True,
+                timeout=60,
+            )
+            if result.returncode != 0:
+                return "failed", result.stderr.strip() or f"systemctl exited {result.returncode}"
+            return "succeeded", f"restarted {name}"
+
+        if task.type == TASK_GPU_VALIDATE:
+            return self._run_gpu_validation(task)
+
+        if task.type == TASK_DIAGNOSTICS:
+            bundle = self._collect_diagnostics(task.id, bool(args.get("nvidia_bug_report")))
+            size = bundle.stat().st_size
+            if not args.get("upload"):
+                return "succeeded", f"wrote {bundle} ({size} bytes)"
+            uploaded = self._upload_diagnostics(bundle, task.id)
+            return "succeeded", f"uploaded {uploaded} ({size} bytes), kept {bundle}"
+
+        return "failed", f"unknown task type: {task.type!r} (supported: {', '.join(TASK_TYPES)})"
+
+    def _collect_diagnostics(self, task_id: str, bug_report: bool = False) -> Path:
+        """Write a diagnostic bundle: command outputs, exporter logs, the agent
+        log tail, its state, check metrics and unsent check results, the checks
+        it runs, and with bug_report nvidia-bug-report.log.gz. Older bundles
+        beyond MAX_DIAGNOSTIC_BUNDLES are removed."""
+        files: dict[str, bytes] = {}
+        for label, command in DIAGNOSTIC_COMMANDS:
+            if not shutil.which(command[0]):
+                continue
+            try:
+                result = subprocess.run(command, capture_output=True, timeout=30)
+                files[f"{label}.txt"] = result.stdout + result.stderr
+            except subprocess.TimeoutExpired:
+                files[f"{label}.txt"] = b"timed out\n"
+
+        if self.log_file.exists():
+            with self.log_file.open("rb") as f:
+                f.seek(max(self.log_file.stat().st_size - DIAGNOSTIC_LOG_TAIL, 0))
+                files["agent.log"] = f.read()
+        for name, path in (
+            ("state.json", self.state_file),
+            ("effective-checks.json", self.checks_cache_file),
+            ("check-results.jsonl", self.result_spool.path),
+        ):
+            if path.exists():
+                files[name] = path.read_bytes()
+        for path in sorted(self.textfile_dir.glob("*.prom")):
+            files[f"metrics/{path.name}"] = path.read_bytes()
+
+        if bug_report and shutil.which(NVIDIA_BUG_REPORT):
+            with tempfile.TemporaryDirectory() as tmp:
+                report = Path(tmp) / "nvidia-bug-report.log"
+                try:
+                    subprocess.run(
+                        [NVIDIA_BUG_REPORT, "--output-file", str(report)],
+                        capture_output=True,
+                        timeout=NVIDIA_BUG_REPORT_TIMEOUT,
+                    )
+                except subprocess.TimeoutExpired:
+                    files["nvidia-bug-report.txt"] = b"timed out\n"
+                gz = report.with_name(report.name + ".gz")
+                if gz.exists():
+                    files["nvidia-bug-report.log.gz"] = gz.read_bytes()
+
+        self.diagnostics_dir.mkdir(parents=True, exist_ok=True)
+        bundle = self.diagnostics_dir / f"{task_id}.tar.gz"
+        prefix = f"{self.hostname}-{task_id}"
+        now = time.time()
+        with tarfile.open(bundle, "w:gz") as tar:
+            for name, data in files.items():
+                info = tarfile.TarInfo(f"{prefix}/{name}")
+                info.size = len(data)
+                info.mtime = int(now)
+                tar.addfile(info, io.BytesIO(data))
+        bundle.chmod(0o600)
+
+        bundles = sorted(self.diagnostics_dir.glob("*.tar.gz"), key=lambda p: p.stat().st_mtime)
+        for old in bundles[:-MAX_DIAGNOSTIC_BUNDLES]:
+            old.unlink(missing_ok=True)
+        return bundle
+
+    def _run_gpu_validation(self, task: Task) -> tuple[str, str]:
+        """Run the GPU validation suite of a task, step by step.
+
+        The report is put to the check result API when the run starts and as
+        each step starts and finishes, so 'aami gpu validate' can follow it.
+        Checks wait while the suite runs, as they would disturb it.
+        """
+        steps = (task.args or {}).get("steps") or GPU_VALIDATION_DEFAULT_STEPS
+        gpus, _ = collect_gpus()
+        report = {
+            "id": task.id,
+            "target": self.hostname,
+            "status": "running",
+            "gpus": len(gpus),
+            "started_at": datetime.now().astimezone().isoformat(),
+            "steps": [{"name": str(step.get("name", "")), "status": "pending"} for step in steps],
+        }
+        self._put_gpu_validation(report)
+
+        for step, result in zip(steps, report["steps"]):
+            self.logger.info(f"GPU validation {task.id}: running {result['name']}")
+            result["status"] = "running"
+            self._put_gpu_validation(report)
+            started = time.monotonic()
+            status, message, metrics, output = self._run_gpu_step(step, len(gpus))
+            result.update(
+                status=status,
+                message=message,
+                duration_seconds=round(time.monotonic() - started, 1),
+                output=output[-GPU_VALIDATION_OUTPUT_TAIL:],
+            )
+            if metrics:
+                result["metrics"] = metrics
+            self._put_gpu_validation(report)
+
+        failed = [r["name"] for r in report["steps"] if r["status"] == "failed"]
+        report["status"] = "failed" if failed else "passed"
+        report["finished_at"] = datetime.now().astimezone().isoformat()
+        self._put_gpu_validation(report)
+        if failed:
+            return "failed", f"GPU validation failed: {', '.join(failed)}"
+        return "succeeded", f"GPU validation passed: {len(steps)} step(s)"
+
+    def _run_gpu_step(self, step: dict, gpu_count: int) -> tuple[str, str, dict, str]:
+        """Run a step of the GPU validation suite and return its status
+        ("passed" or "failed"), a message, metrics and the tool's output."""
+        name = step.get("name", "")
+        tool = GPU_VALIDATION_TOOLS.get(name)
+        if tool is None:
+            return "failed", f"unknown step: {name!r} (supported: {', '.join(GPU_VALIDATION_TOOLS)})", {}, ""
+        if not shutil.which(tool):
+            return "failed", f"{tool} is not installed", {}, ""
+        timeout = int(step.get("timeout") or GPU_VALIDATION_STEP_TIMEOUT)
+        min_gbps = float(step.get("min_gbps") or 0)
+        level = int(step.get("level") or 3)
+
+        if name == "dcgm-diag":
+            command = ["dcgmi", "diag", "-r", str(level)]
+        elif name == "bandwidth":
+            command = ["bandwidthTest", "--device=all", "--memory=pinned", "--mode=quick"]
+        else:
+            command = ["all_reduce_perf", "-b", "8", "-e", "1G", "-f", "2", "-g", str(max(gpu_count, 1))]
+        try:
+            result = subprocess.run(command, capture_output=True, text=True, timeout=timeout)
+        except subprocess.TimeoutExpired:
+            return "failed", f"timed out after {timeout}s", {}, ""
+        output = result.stdout + result.stderr
+        if result.returncode != 0:
+            return "failed", f"{tool} exited {result.returncode}", {}, output
+
+        if name == "dcgm-diag":
+            if re.search(r"\bFail\b", output):
+                return "failed", f"DCGM level {level} diagnostic reported failures", {}, output
+            return "passed", f"DCGM level {level} diagnostic passed", {}, output
+
+        if name == "bandwidth":
+            metrics = parse_bandwidth_test(output)
+            if "Result = PASS" not in output:
+                return "failed", "bandwidthTest did not pass", metrics, output
+            host_device = [metrics[k] for k in ("h2d_gbps", "d2h_gbps") if k in metrics]
+            if min_gbps and host_device and min(host_device) < min_gbps:
+                return "failed", f"host-device bandwidth {min(host_device):.1f} GB/s below {min_gbps:g}", metrics, output
+            return "passed", f"host-device bandwidth {min(host_device, default=0):.1f} GB/s", metrics, output
+
+        busbw = parse_nccl_busbw(output)
+        if busbw is None:
+            return "failed", "no bus bandwidth in all_reduce_perf output", {}, output
+        metrics = {"busbw_gbps": busbw}
+        wrong = re.search(r"Out of bounds values\s*:\s*(\d+)", output)
+        if wrong and int(wrong.group(1)) > 0:
+            return "failed", f"{wrong.group(1)} wrong all-reduce results", metrics, output
+        if min_gbps and busbw < min_gbps:
+            return "failed", f"bus bandwidth {busbw:.1f} GB/s below {min_gbps:g}", metrics, output
+        return "passed", f"bus bandwidth {busbw:.1f} GB/s over {gpu_count} GPU(s)", metrics, output
+
+    def _put_gpu_validation(self, report: dict) -> None:
+        """Put a GPU validation report to the check result API. Failures are
+        logged only; the task result still tells how the run went."""
+        base_url = self.results_url or self.config_server_url
+        url = f"{base_url.rstrip('/')}/api/v1/targets/{self.hostname}/gpu-validations/{urllib.parse.quote(report['id'])}"
+        try:
+            request = urllib.request.Request(
+                url,
+                data=json.dumps(report).encode("utf-8"),
+                headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                method="PUT",
+            )
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context):
+                pass
+        except urllib.error.HTTPError as e:
+            if e.code == 401:
+                self._credential_rejected(e)
+            else:
+                self.logger.warning(f"Reporting GPU validation {report['id']} failed ({e.code})")
+        except (urllib.error.URLError, OSError) as e:
+            self.logger.warning(f"Reporting GPU validation {report['id']} failed: {e}")
+
+    def _upload_diagnostics(self, bundle: Path, task_id: str) -> str:
+        """Upload a diagnostic bundle to the check result API and return the
+        ID the server gave it. Failures raise, failing the task."""
+        base_url = self.results_url or self.config_server_url
+        url = (f"{base_url.rstrip('/')}/api/v1/targets/{self.hostname}/debug-bundles"
+               f"?task={urllib.parse.quote(task_id)}")
+        request = urllib.request.Request(
+            url,
+            data=bundle.read_bytes(),
+            headers={"Content-Type": "application/gzip", "Accept": "application/json", **self._auth_headers()},
+            method="POST",
+        )
+        try:
+            with urllib.request.urlopen(request, timeout=300, context=self.ssl_context) as response:
+                return json.loads(response.read()).get("id", "")
+        except urllib.error.HTTPError as e:
+            if e.code == 401:
+                self._credential_rejected(e)
+                raise RuntimeError(f"upload of {bundle} rejected: agent credential not accepted") from e
+            detail = e.read().decode("utf-8", "replace").strip()
+            raise RuntimeError(f"upload of {bundle} rejected ({e.code}): {detail}") from e
+        except (urllib.error.URLError, OSError) as e:
+            raise RuntimeError(f"upload of {bundle} failed: {e}") from e
+
     def _save_check_script(self, check: CheckInfo) -> Path:
         """Save check script with hash-based versioning."""
         script_dir = self.check_scripts_dir / check.name
@@ -248,7 +2258,7 @@ class DynamicCheckRunner:
         current_link = script_dir / "current.sh"
 
         # Check if script already exists with this hash
-        if script_file.exists():
+        if script_file.exists() and not self._refresh_scripts:
             self.logger.debug(f"Check script already exists: {script_file}")
         else:
             self.logger.info(f"Saving new check script: {check.name} (hash: {check.script_hash[:8]})")
@@ -264,7 +2274,7 @@ class DynamicCheckRunner:
 
         return current_link
 
-    def _execute_check(self, check_name: str, script_path: Path, config: dict) -> CheckResult:
+    def _execute_check(self, check_name: str, script_path: Path, config: dict, timeout: int) -> CheckResult:
         """Execute a single check."""
         output_file = self.textfile_dir / f"{check_name}.prom.tmp"
         final_file = self.textfile_dir / f"{check_name}.prom"
@@ -273,15 +2283,20 @@ class DynamicCheckRunner:
         self.logger.debug(f"Script: {script_path}")
         self.logger.debug(f"Config: {config}")
 
+        start = time.monotonic()
+
+        def elapsed_ms() -> int:
+            return int((time.monotonic() - start) * 1000)
+
         try:
             # Execute check script with config as stdin
             config_json = json.dumps(config)
             result = subprocess.run(
-                [str(script_path)],
+                self._check_command(script_path),
                 input=config_json,
                 capture_output=True,
                 text=True,
-                timeout=30,
+                timeout=timeout,
             )
 
             if result.returncode == 0:
@@ -289,7 +2304,14 @@ class DynamicCheckRunner:
                 output_file.write_text(result.stdout)
                 output_file.rename(final_file)
                 self.logger.info(f"Check completed successfully: {check_name}")
-                return CheckResult(name=check_name, success=True, output=result.stdout)
+                return CheckResult(
+                    name=check_name,
+                    success=True,
+                    output=result.stdout,
+                    status="passed",
+                    exit_code=0,
+                    duration_ms=elapsed_ms(),
+                )
             else:
                 self.logger.error(f"Check failed: {check_name} (exit code: {result.returncode})")
                 if result.stderr:
@@ -305,21 +2327,144 @@ class DynamicCheckRunner:
                     success=False,
                     output=result.stdout,
                     error=result.stderr,
+                    status="failed",
+                    exit_code=result.returncode,
+                    duration_ms=elapsed_ms(),
                 )
 
         except subprocess.TimeoutExpired:
-            self.logger.error(f"Check timed out: {check_name}")
+            self.logger.error(f"Check timed out after {timeout}s: {check_name}")
             error_output = self._generate_error_metric(check_name)
             output_file.write_text(error_output)
             output_file.rename(final_file)
-            return CheckResult(name=check_name, success=False, output="", error="Timeout")
+            return CheckResult(
+                name=check_name, success=False, output="", error="Timeout",
+                status="timeout", duration_ms=elapsed_ms(),
+            )
 
         except Exception as e:
             self.logger.error(f"Check execution error: {check_name} - {e}")
             error_output = self._generate_error_metric(check_name)
             output_file.write_text(error_output)
             output_file.rename(final_file)
-            return CheckResult(name=check_name, success=False, output="", error=str(e))
+            return CheckResult(
+                name=check_name, success=False, output="", error=str(e),
+                status="error", duration_ms=elapsed_ms(),
+            )
+
+    def _queue_result(self, result: CheckResult, started_at: float) -> None:
+        """Spool a check result for the check result API."""
+        item = {
+            "target": self.hostname,
+            "check": result.name,
+            "status": result.status,
+            "duration_ms": result.duration_ms,
+            "output": result.output[:MAX_RESULT_OUTPUT],
+            "error": (result.error or "")[:MAX_RESULT_OUTPUT],
+            "started_at": datetime.fromtimestamp(started_at).astimezone().isoformat(),
+        }
+        if result.exit_code is not None:
+            item["exit_code"] = result.exit_code
+        self.result_spool.append(item)
+
+    def _report_results(self) -> None:
+        """Send spooled check results to the check result API, oldest first.
+
+        Results are sent in batches of RESULT_BATCH_SIZE. They stay spooled
+        if the server is unreachable or fails, and are dropped if it rejects
+        them or has no check result endpoint.
+        """
+        base_url = self.results_url or self.config_server_url
+        url = f"{base_url.rstrip('/')}/api/v1/check-results"
+
+        while True:
+            pending = self.result_spool.read(RESULT_BATCH_SIZE)
+            if not pending:
+                return
+            try:
+                request = urllib.request.Request(
+                    url,
+                    data=json.dumps({"results": pending}).encode("utf-8"),
+                    headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                    method="POST",
+                )
+                with urllib.request.urlopen(request, timeout=30, context=self.ssl_context):
+                    pass
+            except urllib.error.HTTPError as e:
+                if e.code == 404:
+                    self.logger.debug("No check result endpoint, dropping results")
+                    self.result_spool.drop(len(self.result_spool))
+                elif e.code == 401:
+                    self._credential_rejected(e)
+                    return
+                elif 400 <= e.code < 500:
+                    detail = e.read().decode("utf-8", "replace").strip()
+                    self.logger.warning(f"Check results rejected ({e.code}): {detail}; dropping {len(pending)} result(s)")
+                    self.result_spool.drop(len(pending))
+                    continue
+                else:
+                    self.logger.warning(f"Reporting check results failed, keeping {len(self.result_spool)} for the next run: {e}")
+                return
+            except (urllib.error.URLError, OSError) as e:
+                self.logger.warning(f"Reporting check results failed, keeping {len(self.result_spool)} for the next run: {e}")
+                return
+
+            self.logger.debug(f"Reported {len(pending)} check result(s)")
+            self.result_spool.drop(len(pending))
+
+    def _report_inventory(self, now: float) -> None:
+        """Collect the hardware inventory when due and report it if it changed.
+
+        An inventory the server could not take is kept in the state file and
+        sent with the next run. One it rejected, or a server without the
+        endpoint (404), is tried again once a day.
+        """
+        inventory = self.state["inventory"]
+        if now - inventory.get("collected_at", 0) >= INVENTORY_COLLECT_INTERVAL:
+            data = collect_inventory(self.hostname)
+            inventory["data"] = data
+            inventory["hash"] = hashlib.sha256(json.dumps(data, sort_keys=True).encode()).hexdigest()
+            inventory["collected_at"] = now
+            data["collected_at"] = datetime.fromtimestamp(now).astimezone().isoformat()
+        if "data" not in inventory:
+            return
+        if (inventory.get("reported_hash") == inventory["hash"]
+                and now - inventory.get("reported_at", 0) < INVENTORY_REPORT_INTERVAL):
+            return
+
+        base_url = self.results_url or self.config_server_url
+        url = f"{base_url.rstrip('/')}/api/v1/targets/{self.hostname}/inventory"
+        try:
+            request = urllib.request.Request(
+                url,
+                data=json.dumps(inventory["data"]).encode("utf-8"),
+                headers={"Content-Type": "application/json", "Accept": "application/json", **self._auth_headers()},
+                method="POST",
+            )
+            with urllib.request.urlopen(request, timeout=30, context=self.ssl_context):
+                pass
+        except urllib.error.HTTPError as e:
+            if e.code == 404:
+                self.logger.debug("No inventory endpoint, skipping the hardware inventory")
+                inventory["reported_hash"] = inventory["hash"]
+                inventory["reported_at"] = now
+            elif e.code == 401:
+                self._credential_rejected(e)
+            elif 400 <= e.code < 500:
+                detail = e.read().decode("utf-8", "replace").strip()
+                self.logger.warning(f"Hardware inventory rejected ({e.code}): {detail}")
+                inventory["reported_hash"] = inventory["hash"]
+                inventory["reported_at"] = now
+            else:
+                self.logger.warning(f"Reporting the hardware inventory failed, retrying next run: {e}")
+            return
+        except (urllib.error.URLError, OSError) as e:
+            self.logger.warning(f"Reporting the hardware inventory failed, retrying next run: {e}")
+            return
+
+        self.logger.info("Reported the hardware inventory")
+        inventory["reported_hash"] = inventory["hash"]
+        inventory["reported_at"] = now
 
     def _generate_error_metric(self, check_name: str) -> str:
         """Generate error metric for a failed check."""
@@ -328,6 +2473,88 @@ class DynamicCheckRunner:
 aami_check_error{{check="{check_name}"}} 1
 """
 
+    def _write_schedule_metrics(self, checks: list[CheckInfo], now: float) -> None:
+        """Write per-check scheduling metrics."""
+        lines = [
+            "# HELP aami_check_last_run_timestamp_seconds Last time the check was executed",
+            "# TYPE aami_check_last_run_timestamp_seconds gauge",
+        ]
+        next_lines = [
+            "# HELP aami_check_next_run_timestamp_seconds Earliest time the check will run next",
+            "# TYPE aami_check_next_run_timestamp_seconds gauge",
+        ]
+        escalation_lines = [
+            "# HELP aami_check_escalated Check is re-running at its faster escalation interval (1=escalated)",
+            "# TYPE aami_check_escalated gauge",
+        ]
+        streak_lines = [
+            "# HELP aami_check_consecutive_passes Consecutive successful runs",
+            "# TYPE aami_check_consecutive_passes gauge",
+        ]
+        failure_lines = [
+            "# HELP aami_check_consecutive_failures Consecutive failed runs",
+            "# TYPE aami_check_consecutive_failures gauge",
+        ]
+        for check in checks:
+            state = self.state["checks"].get(check.name, {})
+            schedule = self._schedule_for(check)
+            if "last_run" in state:
+                lines.append(f'aami_check_last_run_timestamp_seconds{{check="{check.name}"}} {int(state["last_run"])}')
+            if schedule is not None and "last_run" in state:
+                offset = schedule.jitter_offset(self.hostname, check.name)
+                next_run = schedule.next_run(state["last_run"], now, offset)
+                if next_run != float("inf"):
+                    next_lines.append(f'aami_check_next_run_timestamp_seconds{{check="{check.name}"}} {int(next_run)}')
+            if self._escalations.get(check.name) is not None:
+                escalated = 1 if state.get("escalated") else 0
+                escalation_lines.append(f'aami_check_escalated{{check="{check.name}"}} {escalated}')
+            if "last_run" in state:
+                streak_lines.append(f'aami_check_consecutive_passes{{check="{check.name}"}} {state.get("consecutive_passes", 0)}')
+                failure_lines.append(f'aami_check_consecutive_failures{{check="{check.name}"}} {state.get("consecutive_failures", 0)}')
+
+        schedule_file = self.textfile_dir / "aami_schedule.prom"
+        temp_file = self.textfile_dir / "aami_schedule.prom.tmp"
+        sections = [lines, next_lines, escalation_lines, streak_lines, failure_lines]
+        temp_file.write_text("\n\n".join("\n".join(section) for section in sections) + "\n")
+        temp_file.rename(schedule_file)
+
+    def _write_result_metrics(self, checks: list[CheckInfo]) -> None:
+        """Write the latest result of checks reporting to the textfile collector.
+
+        Every status gets a series, 1 for the current one, so alerts can
+        match on the status label and a check leaving a status resets it.
+        """
+        status_lines = [
+            "# HELP aami_check_status Latest result of the check (1=current status)",
+            "# TYPE aami_check_status gauge",
+        ]
+        duration_lines = [
+            "# HELP aami_check_duration_seconds Run time of the check's latest run",
+            "# TYPE aami_check_duration_seconds gauge",
+        ]
+        exit_lines = [
+            "# HELP aami_check_exit_code Exit code of the check's latest run",
+            "# TYPE aami_check_exit_code gauge",
+        ]
+        for check in checks:
+            if self._report_modes.get(check.name) not in (REPORT_TEXTFILE, REPORT_BOTH):
+                continue
+            state = self.state["checks"].get(check.name, {})
+            status = state.get("last_status")
+            if not status:
+                continue
+            for value in CHECK_STATUSES:
+                status_lines.append(f'aami_check_status{{check="{check.name}",status="{value}"}} {1 if value == status else 0}')
+            duration_lines.append(f'aami_check_duration_seconds{{check="{check.name}"}} {state.get("last_duration_ms", 0) / 1000:.3f}')
+            if state.get("last_exit_code") is not None:
+                exit_lines.append(f'aami_check_exit_code{{check="{check.name}"}} {state["last_exit_code"]}')
+
+        results_file = self.textfile_dir / "aami_check_results.prom"
+        temp_file = self.textfile_dir / "aami_check_results.prom.tmp"
+        sections = [status_lines, duration_lines, exit_lines]
+        temp_file.write_text("\n\n".join("\n".join(section) for section in sections) + "\n")
+        temp_file.rename(results_file)
+
     def _write_status_metrics(
         self,
         success: bool,
@@ -335,10 +2562,20 @@ aami_check_error{{check="{check_name}"}} 1
         checks_success: int,
         checks_failed: int,
         duration: int,
+        checks_skipped: int = 0,
+        checks_blocked: int = 0,
+        config_cached: bool = False,
+        pending_tasks: int = 0,
     ) -> None:
         """Write overall status metrics."""
         timestamp = int(time.time())
         status_value = 1 if success else 0
+        offline = self.state.get("offline")
+
+        usage = resource_usage()
+        cpu_seconds = usage["cpu_seconds"]
+        max_rss_bytes = usage["max_rss_bytes"]
+        nice_level = usage["nice"]
 
         metrics = f"""# HELP aami_check_fetch_status Check fetch status (1=success, 0=failed)
 # TYPE aami_check_fetch_status gauge
@@ -363,6 +2600,46 @@ aami_checks_success {checks_success}
 # HELP aami_checks_failed Number of failed checks
 # TYPE aami_checks_failed gauge
 aami_checks_failed {checks_failed}
+
+# HELP aami_checks_skipped Number of checks not due in this run
+# TYPE aami_checks_skipped gauge
+aami_checks_skipped {checks_skipped}
+
+# HELP aami_checks_blocked Number of checks skipped because a dependency failed
+# TYPE aami_checks_blocked gauge
+aami_checks_blocked {checks_blocked}
+
+# HELP aami_check_config_cached Effective checks came from the local cache because the config hash was unchanged (1=cached)
+# TYPE aami_check_config_cached gauge
+aami_check_config_cached {1 if config_cached else 0}
+
+# HELP aami_agent_offline Config Server unreachable, running the last known checks (1=offline)
+# TYPE aami_agent_offline gauge
+aami_agent_offline {1 if offline else 0}
+
+# HELP aami_agent_spooled_results Check results spooled on disk until the check result API takes them
+# TYPE aami_agent_spooled_results gauge
+aami_agent_spooled_results {len(self.result_spool)}
+
+# HELP aami_agent_spooled_heartbeats Heartbeats missed while offline, to be replayed on reconnect
+# TYPE aami_agent_spooled_heartbeats gauge
+aami_agent_spooled_heartbeats {len(self.heartbeat_spool)}
+
+# HELP aami_agent_pending_tasks Tasks still queued on the server after the last heartbeat
+# TYPE aami_agent_pending_tasks gauge
+aami_agent_pending_tasks {pending_tasks}
+
+# HELP aami_agent_run_cpu_seconds CPU time used by the agent and its checks in the last run
+# TYPE aami_agent_run_cpu_seconds gauge
+aami_agent_run_cpu_seconds {cpu_seconds:.3f}
+
+# HELP aami_agent_max_rss_bytes Peak resident memory of the agent or any check in the last run
+# TYPE aami_agent_max_rss_bytes gauge
+aami_agent_max_rss_bytes {max_rss_bytes}
+
+# HELP aami_agent_nice Nice level the agent ran at
+# TYPE aami_agent_nice gauge
+aami_agent_nice {nice_level}
 """
 
         status_file = self.textfile_dir / "aami_status.prom"
@@ -372,50 +2649,213 @@ aami_checks_failed {checks_failed}
         temp_file.rename(status_file)
 
 
+def config_main(argv: list[str]) -> int:
+    """Handle "config validate" and "config migrate"."""
+    parser = argparse.ArgumentParser(
+        prog="dynamic_check.py config",
+        description="Validate or migrate the agent config file",
+        formatter_class=argparse.RawDescriptionHelpFormatter,
+        epilog=f"""
+agent.yaml is read from --file; if it does not exist, the legacy
+{DEFAULT_CONFIG_FILE} file is used (version 0).
+
+Example:
+    %(prog)s validate
+    %(prog)s migrate --dry-run
+    %(prog)s migrate -f /etc/aami/agent.yaml
+        """,
+    )
+    actions = parser.add_subparsers(dest="action", required=True)
+    for name, help_text in (("validate", "Check the config without changing it"),
+                            ("migrate", "Rewrite the config at the current version")):
+        action = actions.add_parser(name, help=help_text)
+        action.add_argument("-f", "--file", default=DEFAULT_AGENT_CONFIG_FILE,
+                            help=f"Agent config file (default: {DEFAULT_AGENT_CONFIG_FILE})")
+        action.add_argument("--legacy-file", default=DEFAULT_CONFIG_FILE,
+                            help=f"Legacy config file (default: {DEFAULT_CONFIG_FILE})")
+        if name == "migrate":
+            action.add_argument("-n", "--dry-run", action="store_true",
+                                help="Print the migrated config instead of writing it")
+    args = parser.parse_args(argv)
+
+    path, legacy_path = Path(args.file), Path(args.legacy_file)
+    try:
+        raw, version, source = read_agent_config(path, legacy_path)
+        if source is None:
+            print(f"No agent config found at {path} or {legacy_path}", file=sys.stderr)
+            return 1
+        config = migrate_agent_config(raw, version)
+    except (OSError, ValueError, KeyError) as e:
+        print(f"Invalid agent config: {e}", file=sys.stderr)
+        return 1
+
+    errors = validate_agent_config(config)
+    if args.action == "validate":
+        for error in errors:
+            print(f"  {error}", file=sys.stderr)
+        if errors:
+            print(f"{source}: {len(errors)} problem(s)", file=sys.stderr)
+            return 1
+        if version < AGENT_CONFIG_VERSION:
+            print(f"{source}: valid, version {version} (run 'config migrate' to upgrade to {AGENT_CONFIG_VERSION})")
+        else:
+            print(f"{source}: valid, version {version}")
+        return 0
+
+    if errors:
+        for error in errors:
+            print(f"  {error}", file=sys.stderr)
+        print(f"{source}: not migrated, fix the problems above first", file=sys.stderr)
+        return 1
+    if args.dry_run:
+        print(render_agent_yaml(config), end="")
+        return 0
+    if version == AGENT_CONFIG_VERSION and source == path:
+        print(f"{path}: already at version {version}")
+        return 0
+    backup = write_agent_config(path, config, version if source == path else None)
+    print(f"Migrated {source} (version {version}) to {path} (version {AGENT_CONFIG_VERSION})")
+    if backup:
+        print(f"Previous config saved as {backup}")
+    return 0
+
+
 def main() -> None:
     """Main entry point."""
+    if sys.argv[1:2] == ["config"]:
+        sys.exit(config_main(sys.argv[2:]))
+
+    # Read the agent config first; its settings become the defaults below
+    pre = argparse.ArgumentParser(add_help=False)
+    pre.add_argument("--agent-config", default=os.environ.get("AAMI_AGENT_CONFIG", DEFAULT_AGENT_CONFIG_FILE))
+    pre.add_argument("-n", "--dry-run", action="store_true")
+    known, _ = pre.parse_known_args()
+    try:
+        agent_config = load_agent_config(
+            Path(known.agent_config), Path(DEFAULT_CONFIG_FILE), write=not known.dry_run
+        )
+    except (OSError, ValueError, KeyError) as e:
+        print(f"Invalid agent config: {e}", file=sys.stderr)
+        print("Check it with: dynamic_check.py config validate", file=sys.stderr)
+        sys.exit(1)
+
+    def default(env: str, key: str, fallback):
+        """Environment variable, else agent.yaml, else the built-in default."""
+        if env in os.environ:
+            return os.environ[env]
+        return agent_config.get(key, fallback)
+
     parser = argparse.ArgumentParser(
         description="Dynamic Check Runner for AAMI Monitoring",
         formatter_class=argparse.RawDescriptionHelpFormatter,
         epilog="""
 Environment Variables:
     AAMI_CONFIG_SERVER_URL   - Config Server URL
+    AAMI_RESULTS_URL         - Check result API URL (default: Config Server URL)
+    AAMI_CREDENTIAL_FILE     - Agent credential (default: /etc/aami/agent-credential)
     AAMI_HOSTNAME            - Override hostname
     AAMI_DEBUG               - Enable debug logging (1=on, 0=off)
+    AAMI_NICE                - CPU nice level (default: 10)
+    AAMI_IONICE_CLASS        - I/O scheduling class (default: idle)
+    AAMI_CPU_QUOTA           - cgroup CPU quota per check (e.g. 20%%)
+    AAMI_MEMORY_MAX          - cgroup memory limit per check (e.g. 256M)
+    AAMI_AGENT_CONFIG        - Agent config file (default: /etc/aami/agent.yaml)
+    AAMI_WATCH               - Run in watch mode (1=on, 0=off)
+
+Subcommands:
+    config validate|migrate  Check or upgrade the agent config file
 
 Example:
     %(prog)s --config-server http://config-server:8080
     %(prog)s --debug
+    %(prog)s --dry-run
+    %(prog)s --watch
+    %(prog)s config validate
         """,
     )
 
     parser.add_argument(
         "-c", "--config-server",
         metavar="URL",
-        default=os.environ.get("AAMI_CONFIG_SERVER_URL", ""),
-        help="Config Server URL (default: from /etc/aami/config)",
+        default=default("AAMI_CONFIG_SERVER_URL", "config_server_url", ""),
+        help="Config Server URL (default: from /etc/aami/agent.yaml)",
+    )
+    parser.add_argument(
+        "--results-url",
+        metavar="URL",
+        default=default("AAMI_RESULTS_URL", "results_url", ""),
+        help="Check result API URL (default: the Config Server URL)",
+    )
+    parser.add_argument(
+        "--credential-file",
+        metavar="FILE",
+        default=default("AAMI_CREDENTIAL_FILE", "credential_file", DEFAULT_CREDENTIAL_FILE),
+        help=f"Agent credential issued at registration (default: {DEFAULT_CREDENTIAL_FILE})",
     )
     parser.add_argument(
         "--hostname",
-        default=os.environ.get("AAMI_HOSTNAME", ""),
+        default=default("AAMI_HOSTNAME", "hostname", ""),
         help=f"Override hostname (default: {socket.gethostname()})",
     )
     parser.add_argument(
         "-d", "--debug",
         action="store_true",
-        default=os.environ.get("AAMI_DEBUG", "0") == "1",
+        default=default("AAMI_DEBUG", "debug", False) in (True, "1"),
         help="Enable debug logging",
     )
     parser.add_argument(
         "--textfile-dir",
-        default=os.environ.get("TEXTFILE_DIR", DEFAULT_TEXTFILE_DIR),
+        default=default("TEXTFILE_DIR", "textfile_dir", DEFAULT_TEXTFILE_DIR),
         help=f"Textfile collector directory (default: {DEFAULT_TEXTFILE_DIR})",
     )
     parser.add_argument(
         "--check-scripts-dir",
-        default=os.environ.get("CHECK_SCRIPTS_DIR", DEFAULT_CHECK_SCRIPTS_DIR),
+        default=default("CHECK_SCRIPTS_DIR", "check_scripts_dir", DEFAULT_CHECK_SCRIPTS_DIR),
         help=f"Check scripts directory (default: {DEFAULT_CHECK_SCRIPTS_DIR})",
     )
+    parser.add_argument(
+        "--state-file",
+        default=default("AAMI_STATE_FILE", "state_file", DEFAULT_STATE_FILE),
+        help=f"Scheduler state file (default: {DEFAULT_STATE_FILE})",
+    )
+    parser.add_argument(
+        "--nice",
+        type=int,
+        default=int(default("AAMI_NICE", "nice", DEFAULT_NICE)),
+        help=f"CPU nice level for the agent and its checks (default: {DEFAULT_NICE})",
+    )
+    parser.add_argument(
+        "--ionice-class",
+        choices=sorted(IONICE_CLASSES),
+        default=default("AAMI_IONICE_CLASS", "ionice_class", DEFAULT_IONICE_CLASS),
+        help=f"I/O scheduling class (default: {DEFAULT_IONICE_CLASS})",
+    )
+    parser.add_argument(
+        "--cpu-quota",
+        default=default("AAMI_CPU_QUOTA", "cpu_quota", ""),
+        help="cgroup CPU quota per check via systemd-run, e.g. 20%%",
+    )
+    parser.add_argument(
+        "--memory-max",
+        default=default("AAMI_MEMORY_MAX", "memory_max", ""),
+        help="cgroup memory limit per check via systemd-run, e.g. 256M",
+    )
+    parser.add_argument(
+        "--agent-config",
+        default=known.agent_config,
+        help=f"Agent config file (default: {DEFAULT_AGENT_CONFIG_FILE})",
+    )
+    parser.add_argument(
+        "-n", "--dry-run",
+        action="store_true",
+        help="Fetch and plan checks, print the plan, but execute nothing",
+    )
+    parser.add_argument(
+        "-w", "--watch",
+        action="store_true",
+        default=default("AAMI_WATCH", "watch", False) in (True, "1"),
+        help="Run continuously, polling the Config Server every tick",
+    )
     parser.add_argument(
         "-V", "--version",
         action="version",
@@ -426,12 +2866,22 @@ Example:
 
     runner = DynamicCheckRunner(
         config_server_url=args.config_server,
+        results_url=args.results_url,
+        credential_file=args.credential_file,
         hostname=args.hostname,
         debug=args.debug,
         textfile_dir=args.textfile_dir,
         check_scripts_dir=args.check_scripts_dir,
+        state_file=args.state_file,
+        dry_run=args.dry_run,
+        nice=args.nice,
+        ionice_class=args.ionice_class,
+        cpu_quota=args.cpu_quota,
+        memory_max=args.memory_max,
     )
 
+    if args.watch and not args.dry_run:
+        sys.exit(runner.watch())
     sys.exit(runner.run())
 
 
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// gpuFields are queried from nvidia-smi, in this order
+var gpuFields = []string{
+	"index",
+	"uuid",
+	"name",
+	"pci.bus_id",
+	"ecc.errors.corrected.volatile.total",
+	"ecc.errors.uncorrected.volatile.total",
+	"ecc.errors.corrected.aggregate.total",
+	"ecc.errors.uncorrected.aggregate.total",
+	"clocks_throttle_reasons.hw_slowdown",
+	"clocks_throttle_reasons.hw_thermal_slowdown",
+	"clocks_throttle_reasons.hw_power_brake_slowdown",
+	"clocks_throttle_reasons.sw_thermal_slowdown",
+	"clocks_throttle_reasons.sw_power_cap",
+}
+
+// eccFields map the ECC columns to their type and scope labels
+var eccFields = []struct {
+	field, typ, scope string
+}{
+	{"ecc.errors.corrected.volatile.total", "corrected", "volatile"},
+	{"ecc.errors.uncorrected.volatile.total", "uncorrected", "volatile"},
+	{"ecc.errors.corrected.aggregate.total", "corrected", "aggregate"},
+	{"ecc.errors.uncorrected.aggregate.total", "uncorrected", "aggregate"},
+}
+
+// throttleFields map the throttle reason columns to their reason label
+var throttleFields = []struct {
+	field, reason string
+}{
+	{"clocks_throttle_reasons.hw_slowdown", "hw_slowdown"},
+	{"clocks_throttle_reasons.hw_thermal_slowdown", "hw_thermal_slowdown"},
+	{"clocks_throttle_reasons.hw_power_brake_slowdown", "hw_power_brake_slowdown"},
+	{"clocks_throttle_reasons.sw_thermal_slowdown", "sw_thermal_slowdown"},
+	{"clocks_throttle_reasons.sw_power_cap", "sw_power_cap"},
+}
+
+// gpuCollector reports ECC error counts and clock throttle reasons per GPU.
+type gpuCollector struct {
+	nvidiaSMI string
+}
+
+func (c *gpuCollector) Name() string { return "gpu" }
+
+func (c *gpuCollector) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	out, err := runNvidiaSMI(ctx, c.nvidiaSMI,
+		"--query-gpu="+strings.Join(gpuFields, ","), "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := parseGPUQuery(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var metrics []exporter.Metric
+	for _, row := range rows {
+		gpu := map[string]string{"gpu": row["index"], "uuid": row["uuid"]}
+		metrics = append(metrics, exporter.NewGauge("aami_gpu_info",
+			"GPU model and PCI address, for joining on pci_bus_id.", 1,
+			withLabels(gpu, "name", row["name"], "pci_bus_id", normalizeBusID(row["pci.bus_id"]))))
+
+		for _, f := range eccFields {
+			// [N/A] on GPUs without ECC or with ECC disabled
+			if v, ok := parseNumber(row[f.field]); ok {
+				metrics = append(metrics, exporter.NewCounter("aami_gpu_ecc_errors_total",
+					"ECC errors, volatile since the driver loaded or aggregate over the GPU's life.", v,
+					withLabels(gpu, "type", f.typ, "scope", f.scope)))
+			}
+		}
+		for _, f := range throttleFields {
+			active, ok := parseActive(row[f.field])
+			if !ok {
+				continue
+			}
+			metrics = append(metrics, exporter.NewGauge("aami_gpu_throttle_reason_active",
+				"Whether the reason currently lowers the GPU's clocks.", active,
+				withLabels(gpu, "reason", f.reason)))
+		}
+	}
+	return metrics, nil
+}
+
+// Healthy reports whether nvidia-smi can reach the driver.
+func (c *gpuCollector) Healthy(ctx context.Context) error {
+	_, err := runNvidiaSMI(ctx, c.nvidiaSMI, "-L")
+	return err
+}
+
+// parseGPUQuery parses the CSV of a --query-gpu run into one map per GPU,
+// keyed by field
+func parseGPUQuery(out []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(out))
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse nvidia-smi output: %w", err)
+	}
+	rows := make([]map[string]string, 0, len(records))
+	for _, rec := range records {
+		if len(rec) != len(gpuFields) {
+			return nil, fmt.Errorf("parse nvidia-smi output: %d fields, expected %d", len(rec), len(gpuFields))
+		}
+		row := make(map[string]string, len(rec))
+		for i, field := range gpuFields {
+			row[field] = strings.TrimSpace(rec[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseNumber parses a numeric nvidia-smi value, reporting false for
+// [N/A], [Not Supported] and the like
+func parseNumber(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+// parseActive parses a throttle reason, "Active" or "Not Active"
+func parseActive(s string) (float64, bool) {
+	switch s {
+	case "Active":
+		return 1, true
+	case "Not Active":
+		return 0, true
+	}
+	return 0, false
+}
+
+// normalizeBusID turns nvidia-smi's 00000000:3B:00.0 into 0000:3b:00, the
+// form Xid messages in the kernel log use
+func normalizeBusID(id string) string {
+	id = strings.ToLower(id)
+	id = strings.TrimPrefix(id, "pci:")
+	if i := strings.LastIndex(id, "."); i > 0 {
+		id = id[:i]
+	}
+	parts := strings.Split(id, ":")
+	if len(parts) == 3 && len(parts[0]) > 4 {
+		parts[0] = parts[0][len(parts[0])-4:]
+	}
+	return strings.Join(parts, ":")
+}
+
+// withLabels returns a copy of labels with more name, value pairs added
+func withLabels(labels map[string]string, pairs ...string) map[string]string {
+	out := make(map[string]string, len(labels)+len(pairs)/2)
+	for k, v := range labels {
+		out[k] = v
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		out[pairs[i]] = pairs[i+1]
+	}
+	return out
+}
+
+// runNvidiaSMI runs nvidia-smi, including its message in the error when it
+// fails
+func runNvidiaSMI(ctx context.Context, bin string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(string(out))
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("%s %s: %w: %s", bin, args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", bin, args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,103 @@
+// Command aami-gpu-health is AAMI's GPU health exporter. It exposes Xid
+// error counts from the kernel log, ECC error counts, clock throttle
+// reasons and NVLink status queried through NVML (nvidia-smi) as
+// Prometheus metrics, and registers itself with the Config Server as the
+// gpu_health exporter of its node.
+//
+// Usage:
+//
+//	aami-gpu-health [--config /etc/aami/gpu-health.yaml] [--listen :9402]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// exporterType is the type the exporter registers as
+const exporterType = "gpu_health"
+
+// Defaults
+const (
+	defaultConfigPath    = "/etc/aami/gpu-health.yaml"
+	defaultListenAddress = ":9402"
+	defaultNvidiaSMI     = "nvidia-smi"
+	defaultKernelLog     = "/dev/kmsg"
+)
+
+// config is the exporter's configuration file
+type config struct {
+	exporter.Config `yaml:",inline"`
+	NvidiaSMI       string                  `yaml:"nvidia_smi"` // nvidia-smi binary, default: "nvidia-smi" from PATH
+	KernelLog       string                  `yaml:"kernel_log"` // where Xid errors are read from, default: "/dev/kmsg"
+	Register        exporter.RegisterConfig `yaml:"register"`
+}
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath, "Config file (optional)")
+	listen := flag.String("listen", "", "Listen address, overrides listen_address")
+	noRegister := flag.Bool("no-register", false, "Do not register with the Config Server")
+	flag.Parse()
+	log.SetPrefix("aami-gpu-health: ")
+
+	if err := run(*configPath, *listen, *noRegister); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, listen string, noRegister bool) error {
+	cfg := config{
+		Config:    exporter.Config{ListenAddress: defaultListenAddress},
+		NvidiaSMI: defaultNvidiaSMI,
+		KernelLog: defaultKernelLog,
+	}
+	if err := exporter.LoadConfig(configPath, &cfg); err != nil {
+		// The defaults suffice; only a config that exists must be valid
+		if !errors.Is(err, os.ErrNotExist) || configPath != defaultConfigPath {
+			return err
+		}
+	}
+	if listen != "" {
+		cfg.ListenAddress = listen
+	}
+	if noRegister {
+		cfg.Register.Disabled = true
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	xids := newXidWatcher(cfg.KernelLog)
+	go xids.Run(ctx)
+
+	e := exporter.New("aami-gpu-health", cfg.Config)
+	e.Register(
+		&gpuCollector{nvidiaSMI: cfg.NvidiaSMI},
+		&nvlinkCollector{nvidiaSMI: cfg.NvidiaSMI},
+		xids,
+	)
+
+	listener, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.ListenAddress, err)
+	}
+	if !cfg.Register.Disabled {
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		go exporter.NewRegistrar(exporterType, cfg.Register, p, cfg.MetricsPath).Run(ctx)
+	} else {
+		log.Printf("registration with the Config Server is disabled")
+	}
+	return e.Serve(ctx, listener)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+var (
+	// GPU 0: NVIDIA A100-SXM4-80GB (UUID: GPU-5fd9...)
+	nvlinkGPULine = regexp.MustCompile(`^GPU (\d+): .*\(UUID: ([^)]+)\)`)
+	// Link 0: 25.781 GB/s, or Link 1: <inactive>
+	nvlinkLinkLine = regexp.MustCompile(`^Link (\d+): (.*)$`)
+	// Link 0: Replay Errors: 0, of 'nvidia-smi nvlink -e'
+	nvlinkErrorLine = regexp.MustCompile(`^(\w+) Errors: (\d+)$`)
+)
+
+// nvlinkErrorTypes maps the error counters of 'nvidia-smi nvlink -e' to
+// the type label. Recovery errors count link retrains.
+var nvlinkErrorTypes = map[string]string{
+	"replay":   "replay",
+	"recovery": "recovery",
+	"crc":      "crc",
+}
+
+// nvlinkCollector reports the state, speed and error counters of each
+// NVLink.
+type nvlinkCollector struct {
+	nvidiaSMI string
+}
+
+func (c *nvlinkCollector) Name() string { return "nvlink" }
+
+func (c *nvlinkCollector) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	out, err := runNvidiaSMI(ctx, c.nvidiaSMI, "nvlink", "--status")
+	if err != nil {
+		return nil, err
+	}
+	metrics := parseNVLinkStatus(out)
+	if len(metrics) == 0 {
+		return metrics, nil
+	}
+	out, err = runNvidiaSMI(ctx, c.nvidiaSMI, "nvlink", "--errorcounters")
+	if err != nil {
+		return nil, err
+	}
+	return append(metrics, parseNVLinkErrors(out)...), nil
+}
+
+// parseNVLinkStatus turns 'nvidia-smi nvlink --status' into metrics. GPUs
+// without NVLink list no links and produce none.
+func parseNVLinkStatus(out []byte) []exporter.Metric {
+	var metrics []exporter.Metric
+	var gpu map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := nvlinkGPULine.FindStringSubmatch(line); m != nil {
+			gpu = map[string]string{"gpu": m[1], "uuid": m[2]}
+			continue
+		}
+		m := nvlinkLinkLine.FindStringSubmatch(line)
+		if m == nil || gpu == nil {
+			continue
+		}
+
+		labels := withLabels(gpu, "link", m[1])
+		status := strings.ToLower(m[2])
+		active := 0.0
+		if speed, ok := parseLinkSpeed(status); ok {
+			active = 1
+			metrics = append(metrics, exporter.NewGauge("aami_gpu_nvlink_speed_bytes",
+				"Speed of the NVLink in bytes per second.", speed, labels))
+		}
+		metrics = append(metrics, exporter.NewGauge("aami_gpu_nvlink_active",
+			"Whether the NVLink is up.", active, labels))
+	}
+	return metrics
+}
+
+// parseNVLinkErrors turns 'nvidia-smi nvlink --errorcounters' into
+// metrics. Counters the driver does not report, or reports under other
+// names, are left out.
+func parseNVLinkErrors(out []byte) []exporter.Metric {
+	var metrics []exporter.Metric
+	var gpu map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := nvlinkGPULine.FindStringSubmatch(line); m != nil {
+			gpu = map[string]string{"gpu": m[1], "uuid": m[2]}
+			continue
+		}
+		link := nvlinkLinkLine.FindStringSubmatch(line)
+		if link == nil || gpu == nil {
+			continue
+		}
+		m := nvlinkErrorLine.FindStringSubmatch(strings.TrimSpace(link[2]))
+		if m == nil {
+			continue
+		}
+		typ, ok := nvlinkErrorTypes[strings.ToLower(m[1])]
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, exporter.NewCounter("aami_gpu_nvlink_errors_total",
+			"NVLink errors by type: replay, recovery (link retrains) and crc.", count,
+			withLabels(gpu, "link", link[1], "type", typ)))
+	}
+	return metrics
+}
+
+// parseLinkSpeed parses a link's "25.781 GB/s"; inactive links have none
+func parseLinkSpeed(status string) (float64, bool) {
+	fields := strings.Fields(status)
+	if len(fields) != 2 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	switch fields[1] {
+	case "gb/s":
+		return v * 1e9, true
+	case "mb/s":
+		return v * 1e6, true
+	}
+	return 0, false
+}
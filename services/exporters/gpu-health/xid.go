@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fregataa/aami/internal/xid"
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// xidLine matches the driver's report of an Xid error, e.g.
+// "NVRM: Xid (PCI:0000:3b:00): 79, pid=1234, GPU has fallen off the bus."
+var xidLine = regexp.MustCompile(`NVRM: Xid \(PCI:([0-9A-Fa-f:.]+)\): (\d+),`)
+
+type xidKey struct {
+	busID string
+	code  int
+}
+
+// xidWatcher counts Xid errors in the kernel log. /dev/kmsg replays the
+// kernel's ring buffer before following new messages, so the counts start
+// with the errors since boot that are still buffered.
+type xidWatcher struct {
+	path string
+
+	mu     sync.Mutex
+	counts map[xidKey]float64
+	err    error // why the log cannot be read, if it cannot
+}
+
+func newXidWatcher(path string) *xidWatcher {
+	return &xidWatcher{path: path, counts: make(map[xidKey]float64)}
+}
+
+func (w *xidWatcher) Name() string { return "xid" }
+
+// Run follows the kernel log until ctx ends, reopening it after errors.
+func (w *xidWatcher) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := w.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		w.setErr(err)
+		log.Printf("xid: %v; retrying in 30s", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+func (w *xidWatcher) follow(ctx context.Context) error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return fmt.Errorf("open kernel log: %w", err)
+	}
+	defer f.Close()
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	w.setErr(nil)
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			w.observe(line)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, syscall.EPIPE):
+			// /dev/kmsg: messages were overwritten before being read; the
+			// next read continues with the oldest one left
+		case err == io.EOF:
+			// A regular file such as /var/log/kern.log: wait for more
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+		default:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read kernel log: %w", err)
+		}
+	}
+}
+
+// observe counts the Xid error a kernel log line reports, if any
+func (w *xidWatcher) observe(line string) {
+	m := xidLine.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	code, err := strconv.Atoi(m[2])
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	w.counts[xidKey{busID: normalizeBusID(m[1]), code: code}]++
+	w.mu.Unlock()
+}
+
+func (w *xidWatcher) setErr(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+}
+
+func (w *xidWatcher) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return nil, w.err
+	}
+	keys := make([]xidKey, 0, len(w.counts))
+	for k := range w.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].busID != keys[j].busID {
+			return keys[i].busID < keys[j].busID
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	metrics := make([]exporter.Metric, 0, len(keys))
+	for _, k := range keys {
+		n := w.counts[k]
+		labels := map[string]string{"pci_bus_id": k.busID, "xid": strconv.Itoa(k.code)}
+		if info, ok := xid.GetXidInfo(k.code); ok {
+			labels["severity"] = info.Severity
+		}
+		metrics = append(metrics, exporter.NewCounter("aami_gpu_xid_errors_total",
+			"Xid errors the NVIDIA driver reported, by GPU PCI address and code.", n, labels))
+	}
+	return metrics, nil
+}
+
+// Healthy reports whether the kernel log can be read.
+func (w *xidWatcher) Healthy(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
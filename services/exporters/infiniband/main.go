@@ -0,0 +1,94 @@
+// Command aami-infiniband is AAMI's InfiniBand fabric health exporter. It
+// exposes the state, rate and error counters of every InfiniBand (and
+// RoCE) port of the node's HCAs, read from sysfs as ibstat and perfquery
+// report them, as Prometheus metrics, and registers itself with the Config
+// Server as the infiniband exporter of its node.
+//
+// Usage:
+//
+//	aami-infiniband [--config /etc/aami/infiniband.yaml] [--listen :9315]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// exporterType is the type the exporter registers as
+const exporterType = "infiniband"
+
+// Defaults
+const (
+	defaultConfigPath    = "/etc/aami/infiniband.yaml"
+	defaultListenAddress = ":9315"
+	defaultSysfsPath     = "/sys/class/infiniband"
+)
+
+// config is the exporter's configuration file
+type config struct {
+	exporter.Config `yaml:",inline"`
+	SysfsPath       string                  `yaml:"sysfs_path"` // default: /sys/class/infiniband
+	Devices         []string                `yaml:"devices"`    // HCAs to watch, e.g. mlx5_0; default: all
+	Register        exporter.RegisterConfig `yaml:"register"`
+}
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath, "Config file (optional)")
+	listen := flag.String("listen", "", "Listen address, overrides listen_address")
+	noRegister := flag.Bool("no-register", false, "Do not register with the Config Server")
+	flag.Parse()
+	log.SetPrefix("aami-infiniband: ")
+
+	if err := run(*configPath, *listen, *noRegister); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, listen string, noRegister bool) error {
+	cfg := config{
+		Config:    exporter.Config{ListenAddress: defaultListenAddress},
+		SysfsPath: defaultSysfsPath,
+	}
+	if err := exporter.LoadConfig(configPath, &cfg); err != nil {
+		// The defaults suffice; only a config that exists must be valid
+		if !errors.Is(err, os.ErrNotExist) || configPath != defaultConfigPath {
+			return err
+		}
+	}
+	if listen != "" {
+		cfg.ListenAddress = listen
+	}
+	if noRegister {
+		cfg.Register.Disabled = true
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	e := exporter.New("aami-infiniband", cfg.Config)
+	e.Register(&portCollector{root: cfg.SysfsPath, devices: cfg.Devices})
+
+	listener, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.ListenAddress, err)
+	}
+	if !cfg.Register.Disabled {
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		go exporter.NewRegistrar(exporterType, cfg.Register, p, cfg.MetricsPath).Run(ctx)
+	} else {
+		log.Printf("registration with the Config Server is disabled")
+	}
+	return e.Serve(ctx, listener)
+}
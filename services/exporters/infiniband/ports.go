@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// Logical port states, as the kernel numbers them in ports/<n>/state
+const (
+	portStateActive = 4
+	physStateLinkUp = 5
+)
+
+// portCounter is a port counter of sysfs exposed as a metric
+type portCounter struct {
+	metric string
+	help   string
+	scale  float64 // the data counters count 4-byte words
+}
+
+// portCounters are the counters exposed, by file in ports/<n>/counters.
+// Error counters are those of the PortCounters attribute and stop at their
+// maximum (e.g. 65535 symbol errors) until reset.
+var portCounters = map[string]portCounter{
+	"symbol_error":                    {"aami_infiniband_port_symbol_errors_total", "Symbol errors on the physical link.", 1},
+	"link_downed":                     {"aami_infiniband_port_link_downed_total", "Times the link went down after failing to recover.", 1},
+	"link_error_recovery":             {"aami_infiniband_port_link_error_recovery_total", "Times the link retrained to recover from errors.", 1},
+	"port_rcv_errors":                 {"aami_infiniband_port_receive_errors_total", "Packets received with errors.", 1},
+	"port_rcv_remote_physical_errors": {"aami_infiniband_port_receive_remote_physical_errors_total", "Packets received marked bad by the sender.", 1},
+	"port_xmit_discards":              {"aami_infiniband_port_transmit_discards_total", "Packets discarded because the port was down or congested.", 1},
+	"local_link_integrity_errors":     {"aami_infiniband_port_local_link_integrity_errors_total", "Times the link's error rate exceeded its threshold.", 1},
+	"excessive_buffer_overrun_errors": {"aami_infiniband_port_buffer_overrun_errors_total", "Consecutive receive buffer overruns.", 1},
+	"port_rcv_data":                   {"aami_infiniband_port_receive_bytes_total", "Bytes received.", 4},
+	"port_xmit_data":                  {"aami_infiniband_port_transmit_bytes_total", "Bytes transmitted.", 4},
+}
+
+// portCollector reports the ports of the HCAs in sysfs.
+type portCollector struct {
+	root    string
+	devices []string // empty: all
+}
+
+func (c *portCollector) Name() string { return "ports" }
+
+// Healthy fails when there is no HCA to watch
+func (c *portCollector) Healthy(ctx context.Context) error {
+	devices, err := c.listDevices()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no InfiniBand devices in %s", c.root)
+	}
+	return nil
+}
+
+func (c *portCollector) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	devices, err := c.listDevices()
+	if err != nil {
+		return nil, err
+	}
+	var metrics []exporter.Metric
+	for _, device := range devices {
+		dir := filepath.Join(c.root, device)
+		metrics = append(metrics, exporter.NewGauge("aami_infiniband_device_info",
+			"Information about the HCA.", 1, map[string]string{
+				"device":    device,
+				"hca_type":  readString(filepath.Join(dir, "hca_type")),
+				"fw_ver":    readString(filepath.Join(dir, "fw_ver")),
+				"node_guid": readString(filepath.Join(dir, "node_guid")),
+				"board_id":  readString(filepath.Join(dir, "board_id")),
+			}))
+
+		ports, err := os.ReadDir(filepath.Join(dir, "ports"))
+		if err != nil {
+			return nil, fmt.Errorf("read ports of %s: %w", device, err)
+		}
+		for _, p := range ports {
+			metrics = append(metrics, portMetrics(filepath.Join(dir, "ports", p.Name()), device, p.Name())...)
+		}
+	}
+	return metrics, nil
+}
+
+// listDevices returns the HCAs to watch; configured devices that are
+// missing are left out
+func (c *portCollector) listDevices() ([]string, error) {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", c.root, err)
+	}
+	var devices []string
+	for _, e := range entries {
+		if len(c.devices) == 0 || slices.Contains(c.devices, e.Name()) {
+			devices = append(devices, e.Name())
+		}
+	}
+	return devices, nil
+}
+
+// portMetrics returns the state, rate and counters of a port
+func portMetrics(dir, device, port string) []exporter.Metric {
+	labels := map[string]string{
+		"device":     device,
+		"port":       port,
+		"link_layer": readString(filepath.Join(dir, "link_layer")),
+	}
+
+	// state reads "4: ACTIVE", phys_state "5: LinkUp"
+	state, stateOK := readNumbered(filepath.Join(dir, "state"))
+	phys, physOK := readNumbered(filepath.Join(dir, "phys_state"))
+	var metrics []exporter.Metric
+	if stateOK {
+		metrics = append(metrics, exporter.NewGauge("aami_infiniband_port_state",
+			"Logical state of the port: 1 down, 2 init, 3 armed, 4 active, 5 active defer.", state, labels))
+	}
+	if physOK {
+		metrics = append(metrics, exporter.NewGauge("aami_infiniband_port_physical_state",
+			"Physical state of the port: 2 polling, 3 disabled, 5 link up, 6 link error recovery, 7 phy test.", phys, labels))
+	}
+	up := 0.0
+	if state == portStateActive && phys == physStateLinkUp {
+		up = 1
+	}
+	metrics = append(metrics, exporter.NewGauge("aami_infiniband_port_up",
+		"Whether the port is active with its physical link up.", up, labels))
+
+	// rate reads "200 Gb/sec (4X HDR)"
+	if fields := strings.Fields(readString(filepath.Join(dir, "rate"))); len(fields) >= 2 && fields[1] == "Gb/sec" {
+		if gbps, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			metrics = append(metrics, exporter.NewGauge("aami_infiniband_port_rate_bytes",
+				"Signalling rate of the port in bytes per second.", gbps*1e9/8, labels))
+		}
+	}
+
+	for file, counter := range portCounters {
+		v, err := strconv.ParseFloat(readString(filepath.Join(dir, "counters", file)), 64)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, exporter.NewCounter(counter.metric, counter.help, v*counter.scale, labels))
+	}
+	return metrics
+}
+
+// readNumbered reads the number of a "<n>: <NAME>" sysfs file
+func readNumbered(path string) (float64, bool) {
+	n, _, _ := strings.Cut(readString(path), ":")
+	v, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	return v, err == nil
+}
+
+// readString reads a sysfs file; missing files read empty
+func readString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fregataa/aami/internal/bmc"
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// Sensor types, the type label of aami_ipmi_sensor_ok
+const (
+	sensorFan         = "fan"
+	sensorTemperature = "temperature"
+	sensorVoltage     = "voltage"
+	sensorCurrent     = "current"
+	sensorPower       = "power"
+	sensorPowerSupply = "power_supply"
+	sensorOther       = "other"
+)
+
+// modeInBand is the mode label of a BMC polled through /dev/ipmi0
+const modeInBand = "inband"
+
+// reading is a sensor as the BMC reports it
+type reading struct {
+	chassis string // Redfish chassis; empty for IPMI
+	sensor  string
+	typ     string
+	value   float64
+	valued  bool // whether value holds a reading
+	ok      bool
+	status  bool // whether ok holds a status; absent sensors have none
+}
+
+// sensorCollector reports the fans, temperatures and sensor faults of the
+// BMC.
+type sensorCollector struct {
+	source   *credentialSource
+	ipmitool string
+}
+
+func (c *sensorCollector) Name() string { return "sensors" }
+
+func (c *sensorCollector) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	cred, err := c.source.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var readings []reading
+	mode, address := modeInBand, ""
+	if cred != nil {
+		mode, address = cred.Protocol, cred.Address
+	}
+	if mode == bmc.ProtocolRedfish {
+		readings, err = redfishSensors(ctx, cred)
+	} else {
+		readings, err = ipmiSensors(ctx, c.ipmitool, cred)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []exporter.Metric{exporter.NewGauge("aami_ipmi_bmc_info",
+		"The BMC polled and how: inband, ipmi or redfish.", 1,
+		map[string]string{"mode": mode, "address": address})}
+	for _, r := range readings {
+		metrics = append(metrics, readingMetrics(r)...)
+	}
+	return metrics, nil
+}
+
+// readingMetrics returns the value and status of a sensor
+func readingMetrics(r reading) []exporter.Metric {
+	labels := map[string]string{"sensor": r.sensor}
+	if r.chassis != "" {
+		labels["chassis"] = r.chassis
+	}
+	var metrics []exporter.Metric
+	if r.valued {
+		switch r.typ {
+		case sensorFan:
+			metrics = append(metrics, exporter.NewGauge("aami_ipmi_fan_speed_rpm",
+				"Speed of the fan in revolutions per minute.", r.value, labels))
+		case sensorTemperature:
+			metrics = append(metrics, exporter.NewGauge("aami_ipmi_temperature_celsius",
+				"Temperature of the sensor in degrees Celsius.", r.value, labels))
+		case sensorPower:
+			metrics = append(metrics, exporter.NewGauge("aami_ipmi_power_sensor_watts",
+				"Power reading of the sensor in watts, e.g. the input of a power supply.", r.value, labels))
+		}
+	}
+	if r.status {
+		status := map[string]string{"type": r.typ}
+		for k, v := range labels {
+			status[k] = v
+		}
+		ok := 0.0
+		if r.ok {
+			ok = 1
+		}
+		metrics = append(metrics, exporter.NewGauge("aami_ipmi_sensor_ok",
+			"Whether the sensor is within its thresholds and asserts no fault.", ok, status))
+	}
+	return metrics
+}
+
+// powerCollector reports the power draw of the system. It is a collector
+// of its own because many BMCs lack DCMI: its failure leaves the sensors.
+type powerCollector struct {
+	source   *credentialSource
+	ipmitool string
+}
+
+func (c *powerCollector) Name() string { return "power" }
+
+func (c *powerCollector) Collect(ctx context.Context) ([]exporter.Metric, error) {
+	cred, err := c.source.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var draw map[string]float64 // by chassis
+	if cred != nil && cred.Protocol == bmc.ProtocolRedfish {
+		draw, err = redfishPower(ctx, cred)
+	} else {
+		var watts float64
+		watts, err = ipmiPower(ctx, c.ipmitool, cred)
+		draw = map[string]float64{"": watts}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(draw) == 0 {
+		return nil, fmt.Errorf("the BMC reports no power draw")
+	}
+
+	var metrics []exporter.Metric
+	for chassis, watts := range draw {
+		labels := map[string]string{}
+		if chassis != "" {
+			labels["chassis"] = chassis
+		}
+		metrics = append(metrics, exporter.NewGauge("aami_ipmi_power_watts",
+			"Power the system draws in watts, as the BMC measures it.", watts, labels))
+	}
+	return metrics, nil
+}
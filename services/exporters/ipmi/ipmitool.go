@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fregataa/aami/internal/bmc"
+)
+
+// IPMI entity IDs of discrete sensors whose type the unit does not tell
+const (
+	entityPowerSupply = "10"
+	entityFan         = "29"
+)
+
+// dcmiPowerLine is the reading of 'ipmitool dcmi power reading':
+// "    Instantaneous power reading:                   312 Watts"
+var dcmiPowerLine = regexp.MustCompile(`Instantaneous power reading:\s+([\d.]+) Watts`)
+
+// faultStates are asserted states of discrete sensors that are faults.
+// ipmitool reports such sensors "ok" as long as they have a reading.
+var faultStates = []string{
+	"failure detected",
+	"predictive failure",
+	"ac lost",
+	"redundancy lost",
+	"non-recoverable",
+	"config error",
+}
+
+// ipmiSensors reads the sensor data records with 'ipmitool sdr elist'
+func ipmiSensors(ctx context.Context, bin string, cred *bmc.Credential) ([]reading, error) {
+	out, err := runIPMITool(ctx, bin, cred, "sdr", "elist")
+	if err != nil {
+		return nil, err
+	}
+	return parseSDR(out), nil
+}
+
+// ipmiPower reads the power draw with 'ipmitool dcmi power reading'
+func ipmiPower(ctx context.Context, bin string, cred *bmc.Credential) (float64, error) {
+	out, err := runIPMITool(ctx, bin, cred, "dcmi", "power", "reading")
+	if err != nil {
+		return 0, err
+	}
+	m := dcmiPowerLine.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no power reading in ipmitool dcmi output; is power measurement active?")
+	}
+	return strconv.ParseFloat(string(m[1]), 64)
+}
+
+// parseSDR turns 'ipmitool sdr elist' into readings. A line is
+// "name | record ID | status | entity | reading", e.g.
+//
+//	FAN1             | 41h | ok  | 29.1 | 5100 RPM
+//	Temp             | 0Eh | ok  |  3.1 | 45 degrees C
+//	PS1 Status       | 64h | ok  | 10.1 | Presence detected, Failure detected
+//
+// Sensors without a reading (status "ns") are left out.
+func parseSDR(out []byte) []reading {
+	var readings []reading
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 5 {
+			continue
+		}
+		name := strings.TrimSpace(fields[0])
+		status := strings.TrimSpace(fields[2])
+		entity, _, _ := strings.Cut(strings.TrimSpace(fields[3]), ".")
+		value := strings.TrimSpace(fields[4])
+		if name == "" || status == "ns" {
+			continue
+		}
+
+		r := reading{sensor: name, typ: sensorOther, status: true, ok: status == "ok"}
+		if number, unit, ok := strings.Cut(value, " "); ok {
+			if v, err := strconv.ParseFloat(number, 64); err == nil {
+				r.value, r.valued = v, true
+				switch unit {
+				case "RPM":
+					r.typ = sensorFan
+				case "degrees C":
+					r.typ = sensorTemperature
+				case "Volts":
+					r.typ = sensorVoltage
+				case "Amps":
+					r.typ = sensorCurrent
+				case "Watts":
+					r.typ = sensorPower
+				}
+			}
+		}
+		if !r.valued {
+			// A discrete sensor: its reading lists the asserted states
+			switch entity {
+			case entityPowerSupply:
+				r.typ = sensorPowerSupply
+			case entityFan:
+				r.typ = sensorFan
+			}
+			states := strings.ToLower(value)
+			for _, fault := range faultStates {
+				if strings.Contains(states, fault) {
+					r.ok = false
+				}
+			}
+		}
+		readings = append(readings, r)
+	}
+	return readings
+}
+
+// runIPMITool runs ipmitool against the local BMC, or over the LAN with
+// IPMI v2.0 when there is a credential. The password is passed in the
+// environment (-E) so that it does not show in the process list.
+func runIPMITool(ctx context.Context, bin string, cred *bmc.Credential, args ...string) ([]byte, error) {
+	var lan []string
+	if cred != nil {
+		lan = []string{"-I", "lanplus", "-H", cred.Host(), "-U", cred.Username, "-E"}
+		if _, port, err := net.SplitHostPort(cred.Address); err == nil {
+			lan = append(lan, "-p", port)
+		}
+	}
+	cmd := exec.CommandContext(ctx, bin, append(lan, args...)...)
+	if cred != nil {
+		cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+cred.Password)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return nil, fmt.Errorf("%s %s: %w: %s", bin, strings.Join(args, " "), err, msg)
+		}
+		return nil, fmt.Errorf("%s %s: %w", bin, strings.Join(args, " "), err)
+	}
+	return out, nil
+}
@@ -0,0 +1,142 @@
+// Command aami-ipmi is AAMI's IPMI/BMC exporter. It polls the node's BMC
+// for power draw, fan speeds, temperatures and sensor faults, with
+// ipmitool or Redfish, exposes them as Prometheus metrics, and registers
+// itself with the Config Server as the ipmi exporter of its node. The
+// BMC's address and credentials come from the Config Server, where they
+// are stored encrypted ('aami node bmc set'); without them the BMC is
+// polled in-band through /dev/ipmi0.
+//
+// Usage:
+//
+//	aami-ipmi [--config /etc/aami/ipmi.yaml] [--listen :9316]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/fregataa/aami/internal/bmc"
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// exporterType is the type the exporter registers as
+const exporterType = "ipmi"
+
+// Defaults
+const (
+	defaultConfigPath    = "/etc/aami/ipmi.yaml"
+	defaultListenAddress = ":9316"
+	defaultScrapeTimeout = "30s" // BMCs answer slowly
+	defaultIPMITool      = "ipmitool"
+	defaultRefresh       = 5 * time.Minute
+)
+
+// config is the exporter's configuration file
+type config struct {
+	exporter.Config `yaml:",inline"`
+	IPMITool        string                  `yaml:"ipmitool"`           // ipmitool binary, default: "ipmitool" from PATH
+	BMC             *localBMC               `yaml:"bmc"`                // default: fetched from the Config Server
+	Refresh         string                  `yaml:"credential_refresh"` // how often to fetch the credential, default: 5m
+	Register        exporter.RegisterConfig `yaml:"register"`
+}
+
+// localBMC is a BMC configured on the node instead of the Config Server
+type localBMC struct {
+	Address            string `yaml:"address"`
+	Protocol           string `yaml:"protocol"` // ipmi or redfish, default: ipmi
+	Username           string `yaml:"username"`
+	Password           string `yaml:"password"` // e.g. ${BMC_PASSWORD}
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func main() {
+	configPath := flag.String("config", defaultConfigPath, "Config file (optional)")
+	listen := flag.String("listen", "", "Listen address, overrides listen_address")
+	noRegister := flag.Bool("no-register", false, "Do not register with the Config Server")
+	flag.Parse()
+	log.SetPrefix("aami-ipmi: ")
+
+	if err := run(*configPath, *listen, *noRegister); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(configPath, listen string, noRegister bool) error {
+	cfg := config{
+		Config:   exporter.Config{ListenAddress: defaultListenAddress, ScrapeTimeout: defaultScrapeTimeout},
+		IPMITool: defaultIPMITool,
+	}
+	if err := exporter.LoadConfig(configPath, &cfg); err != nil {
+		// The defaults suffice; only a config that exists must be valid
+		if !errors.Is(err, os.ErrNotExist) || configPath != defaultConfigPath {
+			return err
+		}
+	}
+	if listen != "" {
+		cfg.ListenAddress = listen
+	}
+	if noRegister {
+		cfg.Register.Disabled = true
+	}
+	refresh := defaultRefresh
+	if cfg.Refresh != "" {
+		d, err := time.ParseDuration(cfg.Refresh)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid credential_refresh %q", cfg.Refresh)
+		}
+		refresh = d
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.ListenAddress, err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	registrar := exporter.NewRegistrar(exporterType, cfg.Register, p, cfg.MetricsPath)
+
+	source := &credentialSource{registrar: registrar, refresh: refresh}
+	if cfg.BMC != nil {
+		c := bmc.Credential{
+			Target:             registrar.Hostname(),
+			Address:            cfg.BMC.Address,
+			Protocol:           cfg.BMC.Protocol,
+			Username:           cfg.BMC.Username,
+			Password:           cfg.BMC.Password,
+			InsecureSkipVerify: cfg.BMC.InsecureSkipVerify,
+		}
+		if c.Protocol == "" {
+			c.Protocol = bmc.ProtocolIPMI
+		}
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("bmc: %w", err)
+		}
+		source.static = &c
+	}
+
+	e := exporter.New("aami-ipmi", cfg.Config)
+	e.Register(
+		&sensorCollector{source: source, ipmitool: cfg.IPMITool},
+		&powerCollector{source: source, ipmitool: cfg.IPMITool},
+	)
+
+	if !cfg.Register.Disabled {
+		go registrar.Run(ctx)
+	} else {
+		log.Printf("registration with the Config Server is disabled")
+	}
+	return e.Serve(ctx, listener)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fregataa/aami/internal/bmc"
+)
+
+// redfishStatus is the Status of a Redfish resource
+type redfishStatus struct {
+	State  string `json:"State"`  // e.g. Enabled, Absent
+	Health string `json:"Health"` // OK, Warning or Critical; null when unknown
+}
+
+type redfishLink struct {
+	ID string `json:"@odata.id"`
+}
+
+type redfishChassis struct {
+	ID      string       `json:"Id"`
+	Power   *redfishLink `json:"Power"`
+	Thermal *redfishLink `json:"Thermal"`
+}
+
+type redfishPowerResource struct {
+	PowerControl []struct {
+		PowerConsumedWatts *float64 `json:"PowerConsumedWatts"`
+	} `json:"PowerControl"`
+	PowerSupplies []struct {
+		Name            string        `json:"Name"`
+		MemberID        string        `json:"MemberId"`
+		PowerInputWatts *float64      `json:"PowerInputWatts"`
+		Status          redfishStatus `json:"Status"`
+	} `json:"PowerSupplies"`
+}
+
+type redfishThermalResource struct {
+	Fans []struct {
+		Name         string        `json:"Name"`
+		FanName      string        `json:"FanName"` // before Redfish 2016.2
+		Reading      *float64      `json:"Reading"`
+		ReadingUnits string        `json:"ReadingUnits"` // RPM or Percent
+		Status       redfishStatus `json:"Status"`
+	} `json:"Fans"`
+	Temperatures []struct {
+		Name           string        `json:"Name"`
+		ReadingCelsius *float64      `json:"ReadingCelsius"`
+		Status         redfishStatus `json:"Status"`
+	} `json:"Temperatures"`
+}
+
+// redfishClient reads the Redfish API of a BMC with basic authentication
+type redfishClient struct {
+	cred   *bmc.Credential
+	client *http.Client
+}
+
+func newRedfishClient(cred *bmc.Credential) *redfishClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cred.InsecureSkipVerify}
+	return &redfishClient{cred: cred, client: &http.Client{Transport: transport}}
+}
+
+// get reads a resource, e.g. /redfish/v1/Chassis, into out
+func (c *redfishClient) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+c.cred.Address+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.cred.Username, c.cred.Password)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("redfish %s: HTTP %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("redfish %s: %w", path, err)
+	}
+	return nil
+}
+
+// chassis returns every chassis of the BMC
+func (c *redfishClient) chassis(ctx context.Context) ([]redfishChassis, error) {
+	var collection struct {
+		Members []redfishLink `json:"Members"`
+	}
+	if err := c.get(ctx, "/redfish/v1/Chassis", &collection); err != nil {
+		return nil, err
+	}
+	chassis := make([]redfishChassis, 0, len(collection.Members))
+	for _, m := range collection.Members {
+		var ch redfishChassis
+		if err := c.get(ctx, m.ID, &ch); err != nil {
+			return nil, err
+		}
+		chassis = append(chassis, ch)
+	}
+	return chassis, nil
+}
+
+// redfishSensors reads the fans, temperatures and power supplies of every
+// chassis from its Thermal and Power resources
+func redfishSensors(ctx context.Context, cred *bmc.Credential) ([]reading, error) {
+	c := newRedfishClient(cred)
+	defer c.client.CloseIdleConnections()
+	chassis, err := c.chassis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []reading
+	for _, ch := range chassis {
+		if ch.Thermal != nil {
+			var thermal redfishThermalResource
+			if err := c.get(ctx, ch.Thermal.ID, &thermal); err != nil {
+				return nil, err
+			}
+			for _, f := range thermal.Fans {
+				r := redfishReading(ch.ID, firstNonEmpty(f.Name, f.FanName), sensorFan, f.Status)
+				if f.Reading != nil && strings.EqualFold(f.ReadingUnits, "RPM") {
+					r.value, r.valued = *f.Reading, true
+				}
+				readings = appendPresent(readings, r, f.Status)
+			}
+			for _, t := range thermal.Temperatures {
+				r := redfishReading(ch.ID, t.Name, sensorTemperature, t.Status)
+				if t.ReadingCelsius != nil {
+					r.value, r.valued = *t.ReadingCelsius, true
+				}
+				readings = appendPresent(readings, r, t.Status)
+			}
+		}
+		if ch.Power != nil {
+			var power redfishPowerResource
+			if err := c.get(ctx, ch.Power.ID, &power); err != nil {
+				return nil, err
+			}
+			for _, p := range power.PowerSupplies {
+				r := redfishReading(ch.ID, firstNonEmpty(p.Name, "PSU "+p.MemberID), sensorPowerSupply, p.Status)
+				readings = appendPresent(readings, r, p.Status)
+				if p.PowerInputWatts != nil && p.Status.State != "Absent" {
+					readings = append(readings, reading{chassis: ch.ID, sensor: r.sensor + " Input",
+						typ: sensorPower, value: *p.PowerInputWatts, valued: true})
+				}
+			}
+		}
+	}
+	return readings, nil
+}
+
+// redfishPower returns the power each chassis draws, from the
+// PowerConsumedWatts of its power controls
+func redfishPower(ctx context.Context, cred *bmc.Credential) (map[string]float64, error) {
+	c := newRedfishClient(cred)
+	defer c.client.CloseIdleConnections()
+	chassis, err := c.chassis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	draw := map[string]float64{}
+	for _, ch := range chassis {
+		if ch.Power == nil {
+			continue
+		}
+		var power redfishPowerResource
+		if err := c.get(ctx, ch.Power.ID, &power); err != nil {
+			return nil, err
+		}
+		for _, pc := range power.PowerControl {
+			if pc.PowerConsumedWatts != nil {
+				draw[ch.ID] += *pc.PowerConsumedWatts
+			}
+		}
+	}
+	return draw, nil
+}
+
+// redfishReading returns a sensor with the status of its Health
+func redfishReading(chassis, name, typ string, status redfishStatus) reading {
+	return reading{
+		chassis: chassis,
+		sensor:  name,
+		typ:     typ,
+		ok:      status.Health == "OK",
+		status:  status.Health != "",
+	}
+}
+
+// appendPresent adds a sensor that is not absent
+func appendPresent(readings []reading, r reading, status redfishStatus) []reading {
+	if status.State == "Absent" || r.sensor == "" {
+		return readings
+	}
+	return append(readings, r)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
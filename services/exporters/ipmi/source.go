@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fregataa/aami/internal/bmc"
+	"github.com/fregataa/aami/pkg/exporter"
+)
+
+// credentialSource tells the collectors how to reach the BMC: the
+// credential of the config file, else the one the Config Server keeps for
+// the node, fetched every refresh. No credential means in-band.
+type credentialSource struct {
+	static    *bmc.Credential
+	registrar *exporter.Registrar
+	refresh   time.Duration
+
+	mu        sync.Mutex
+	current   *bmc.Credential
+	fetchedAt time.Time
+	fetched   bool
+}
+
+// Get returns the BMC credential, or nil to poll the BMC in-band. When the
+// Config Server cannot be reached, the credential fetched last is kept.
+func (s *credentialSource) Get(ctx context.Context) (*bmc.Credential, error) {
+	if s.static != nil {
+		return s.static, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetched && time.Since(s.fetchedAt) < s.refresh {
+		return s.current, nil
+	}
+
+	var c bmc.Credential
+	err := s.registrar.Fetch(ctx, "/api/v1/targets/"+url.PathEscape(s.registrar.Hostname())+"/bmc", &c)
+	switch {
+	case errors.Is(err, exporter.ErrNoServer):
+		if !s.fetched {
+			log.Printf("%v; polling in-band", err)
+		}
+		s.current = nil
+	case errors.Is(err, exporter.ErrNotFound):
+		if !s.fetched || s.current != nil {
+			log.Printf("no BMC credential for %s on the Config Server; polling in-band", s.registrar.Hostname())
+		}
+		s.current = nil
+	case err != nil:
+		if !s.fetched {
+			return nil, err
+		}
+		log.Printf("fetch BMC credential: %v; keeping the last one", err)
+	default:
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		if s.current == nil || s.current.Address != c.Address || s.current.Protocol != c.Protocol {
+			log.Printf("polling the BMC of %s at %s over %s", s.registrar.Hostname(), c.Address, c.Protocol)
+		}
+		s.current = &c
+	}
+	s.fetched, s.fetchedAt = true, time.Now()
+	return s.current, nil
+}